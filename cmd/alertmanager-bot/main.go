@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -27,7 +28,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager" //change to soramitsu
-	"github.com/tshigapov/alertmanager-bot/pkg/telegram"     //change to soramitsu
+	"github.com/tshigapov/alertmanager-bot/pkg/source"
+	"github.com/tshigapov/alertmanager-bot/pkg/telegram" //change to soramitsu
 )
 
 const (
@@ -53,16 +55,95 @@ var (
 )
 
 var cli struct {
-	AlertmanagerURL *url.URL `name:"alertmanager.url" default:"http://localhost:9093/" help:"The URL that's used to connect to the alertmanager"`
-	ListenAddr      string   `name:"listen.addr" default:"0.0.0.0:8080" help:"The address the alertmanager-bot listens on for incoming webhooks"`
-	LogJSON         bool     `name:"log.json" default:"false" help:"Tell the application to log json and not key value pairs"`
-	LogLevel        string   `name:"log.level" default:"info" enum:"error,warn,info,debug" help:"The log level to use for filtering logs"`
-	TemplatePaths   []string `name:"template.paths" default:"/templates/default.tmpl" help:"The paths to the template"`
+	AlertmanagerURL       *url.URL          `name:"alertmanager.url" default:"http://localhost:9093/" help:"The URL that's used to connect to the alertmanager"`
+	ListenAddr            string            `name:"listen.addr" default:"0.0.0.0:8080" help:"The address the alertmanager-bot listens on for incoming webhooks"`
+	LogJSON               bool              `name:"log.json" default:"false" help:"Tell the application to log json and not key value pairs"`
+	LogLevel              string            `name:"log.level" default:"info" enum:"error,warn,info,debug" help:"The log level to use for filtering logs"`
+	TemplatePaths         []string          `name:"template.paths" default:"/templates/default.tmpl" help:"The paths to the template"`
+	LoadTest              bool              `name:"loadtest.enabled" default:"false" help:"Allow admins to send synthetic alerts with /loadtest"`
+	MaxAlerts             int               `name:"telegram.max-alerts" default:"0" help:"The number of alerts a message shows before summarising the rest, 0 shows all"`
+	DeliverInhibited      bool              `name:"alertmanager.deliver-inhibited" default:"false" help:"Send alerts Alertmanager inhibits, too"`
+	PollAlerts            time.Duration     `name:"alertmanager.poll-interval" default:"0" help:"How often Alertmanager is asked for the alerts of chats that get no webhooks, to notify them about changes, 0 never"`
+	ReceiverPattern       string            `name:"alertmanager.receiver-pattern" default:"/webhooks/telegram/{id}" help:"The receiver /alerts asks Alertmanager for if a chat has none saved and none is found by its ID, {id} is the chat's ID"`
+	SetupWizard           bool              `name:"telegram.setup-wizard" default:"false" help:"Ask new chats after /start what they want to get alerts for"`
+	MaintenanceDrop       bool              `name:"maintenance.drop" default:"false" help:"Drop alert notifications during maintenance instead of sending them when it's over"`
+	WebhookMaxBytes       int64             `name:"webhook.max-bytes" default:"4194304" help:"The largest webhook body accepted, 0 accepts any size"`
+	WebhookMaxAlerts      int               `name:"webhook.max-alerts" default:"1000" help:"The number of alerts kept per webhook, the rest is dropped and counted as truncated"`
+	WebhookMaxDepth       int               `name:"webhook.max-depth" default:"32" help:"How deep webhook bodies may nest"`
+	WebhookSecret         string            `name:"webhook.signing-secret" env:"WEBHOOK_SIGNING_SECRET" help:"The secret signing the webhooks the bot sends itself to check its public URL, random if unset, which only works with a single replica and is thus required with telegram.webhook-url or replica.role=follower"`
+	WebhookAllowUnknown   bool              `name:"webhook.allow-unknown-fields" default:"false" help:"Accept webhook bodies with fields Alertmanager doesn't send"`
+	WebhookRoutes         []string          `name:"webhook.route" sep:"none" help:"Route webhooks sent to /webhooks/telegram without a chat ID, written like for /route_add: receiver[team-a] common[\"severity=critical\"] chats[-100123] tags[payments], can be repeated"`
+	WebhookRouteMode      string            `name:"webhook.route-mode" default:"first" enum:"first,all" help:"Whether webhooks without a chat ID go to the first matching route or all of them"`
+	WebhookRouteDefault   int64             `name:"webhook.route-default-chat" default:"0" help:"The chat getting webhooks without a chat ID no route matches, 0 rejects them"`
+	WebhookWorkers        int               `name:"webhook.workers" default:"4" help:"How many chats get their webhooks processed at the same time, each chat keeps the order of its webhooks"`
+	SuppressedCritical    bool              `name:"suppressed.critical" default:"false" help:"Tell the admins when a chat's mutes or minimum severity suppress critical alerts"`
+	DebounceBypass        string            `name:"debounce.bypass-severity" help:"Send alerts of this severity and above right away, like critical, even to chats holding firing alerts with /debounce"`
+	UnknownChatThreshold  int               `name:"unknown-chats.threshold" default:"50" help:"Tell the admins when more webhooks than this arrive for an unsubscribed chat within an hour, 0 never tells them"`
+	UnknownChatCooldown   time.Duration     `name:"unknown-chats.cooldown" default:"6h" help:"How long the admins aren't told about the same unsubscribed chat again"`
+	SuppressedWindow      time.Duration     `name:"suppressed.window" default:"5m" help:"How long suppressed critical alerts are collected before a notice is sent"`
+	SuppressedLabel       string            `name:"suppressed.label" default:"severity" help:"The label telling the severity of alerts"`
+	SuppressedValue       string            `name:"suppressed.value" default:"critical" help:"The value of the severity label of critical alerts"`
+	SuppressedChat        int64             `name:"suppressed.chat" default:"0" help:"The chat getting suppressed critical alert notices, 0 sends them to the admins"`
+	SilenceMaxExtension   time.Duration     `name:"silence.max-extension" default:"168h" help:"How far a silence can be extended at once from Telegram"`
+	NewAlertWindow        time.Duration     `name:"alerts.new-window" default:"0" help:"Mark alerts first notified about at most this long ago as new and the others with when that was, 0 doesn't mark them"`
+	FingerprintTTL        time.Duration     `name:"alerts.fingerprint-ttl" default:"720h" help:"How long alerts are remembered for alerts.new-window after they were last notified about"`
+	StaleAfter            time.Duration     `name:"alerts.stale-after" default:"1h" help:"How long a firing alert can go without updates before /alerts warns that it may be stale, 0 never warns"`
+	NatsURL               string            `name:"nats.url" help:"Receive webhooks from this NATS JetStream server instead of over HTTP"`
+	NatsSubject           string            `name:"nats.subject" default:"alertmanager.telegram" help:"The NATS subject webhooks are published to"`
+	NatsQueue             string            `name:"nats.queue" help:"The queue group bots share the webhooks with, also the name of their durable consumer"`
+	NatsCredentials       string            `name:"nats.credentials" type:"path" help:"The path to a NATS credentials file"`
+	NatsMaxDeliver        int               `name:"nats.max-deliver" default:"10" help:"How often a webhook is delivered to the bots before NATS gives up on it, 0 never does. Only applies when the consumer is created"`
+	PublicURL             string            `name:"telegram.public-url" help:"The URL Alertmanager reaches the bot at, used in the configuration /webhook_config shows"`
+	StartupAttempts       int               `name:"telegram.startup-attempts" default:"5" help:"How often Telegram and the store are tried when the bot starts, an invalid token isn't tried again"`
+	StartupBackoff        time.Duration     `name:"telegram.startup-backoff" default:"2s" help:"How long to wait before trying Telegram and the store again when the bot starts, doubling with every attempt up to a minute"`
+	FailoverThreshold     int               `name:"failover.threshold" default:"3" help:"How many deliveries to a chat fail in a row before its alerts go to its /fallback chat"`
+	FailoverProbeInterval time.Duration     `name:"failover.probe-interval" default:"1m" help:"How often chats failing over are checked for being reachable again"`
+	SendResolved          bool              `name:"telegram.send-resolved" default:"true" negatable:"" help:"Notify chats about resolved alerts, unless they chose otherwise with /resolved"`
+	SlowCommand           time.Duration     `name:"telegram.slow-command" default:"5s" help:"Log commands taking longer than this, 0 logs none"`
+	SplitByStatus         bool              `name:"telegram.split-by-status" default:"true" negatable:"" help:"Send the firing and the resolved alerts of a webhook as separate messages, the firing ones first"`
+	CommandMenu           bool              `name:"telegram.command-menu" default:"false" help:"Set the bot's command menu in Telegram to the commands of /help, everyone sees it"`
+	ReplyToCommands       bool              `name:"telegram.reply-to-commands" default:"true" negatable:"" help:"Send command replies as replies to the command, so that it's clear in busy groups which belongs to whom"`
+	SentLogFile           string            `name:"sent-log.file" type:"path" help:"Append every message the bot sends to this file as JSON lines"`
+	SentLogFileMaxBytes   int64             `name:"sent-log.file-max-bytes" default:"104857600" help:"The size the sent log file is rotated at"`
+	SentLogFileBackups    int               `name:"sent-log.file-backups" default:"5" help:"How many rotated sent log files are kept"`
+	SentLogSize           int               `name:"sent-log.store-size" default:"0" help:"Keep the last messages sent to each chat in the store for /sent_log, 0 keeps none"`
+	SentLogPrefix         string            `name:"sent-log.store-prefix" default:"telegram/sent_log" help:"Prefix for the store keys of the sent log"`
+	SilenceSync           time.Duration     `name:"silence.sync-interval" default:"0" help:"How often new Alertmanager silences are checked for to tell on the notifications of the alerts they cover, 0 never"`
+	AckSilence            time.Duration     `name:"ack.silence" default:"0" help:"How long /ack silences the acknowledged alerts in Alertmanager, 0 doesn't silence them"`
+	ShedMaxAge            time.Duration     `name:"notifications.max-age" default:"0" help:"How long a notification can wait to be sent, like while Telegram rate limits the bot, before it's shed, 0 sends all of them"`
+	ShedPolicy            string            `name:"notifications.shedding" default:"merge" enum:"drop,merge" help:"Whether notifications that waited too long are dropped or merged into one message per chat"`
+	SeverityOrdering      string            `name:"severity.ordering" default:"info,warning,critical" help:"How severities rank: numeric-ascending, numeric-descending (1 is the worst) or a list from the least to the most severe"`
+	SeverityEmoji         map[string]string `name:"severity.emoji" help:"Emoji of alert severities in notifications, summaries and digests, like critical=🟥;warning=🟧;info=🟦, others get 🔥"`
+	UnlabeledPolicy       string            `name:"unlabeled.policy" default:"other" enum:"other,drop,admin,tag" help:"What happens to alerts without environment or project label: delivered as other, dropped, sent to the unlabeled.chat only or tagged with a warning"`
+	UnlabeledChat         int64             `name:"unlabeled.chat" default:"0" help:"The chat getting the alerts without environment or project label with the admin policy"`
+	DurableOutbox         bool              `name:"outbox.durable" default:"false" help:"Write webhooks to the store until they are delivered, to deliver them after a crash or restart"`
+	OutboxMaxAge          time.Duration     `name:"outbox.max-age" default:"6h" help:"How old webhooks left in the outbox can be and still be delivered on startup"`
+	Escalation            []time.Duration   `name:"escalation.thresholds" help:"Escalate alerts still firing after each of these durations, like 1h,4h"`
+	WebToken              string            `name:"web.token" env:"WEB_TOKEN" help:"Bearer token /metrics and the JSON API under /api/v1/ require, empty serves them to anyone. /api/v1/token rotating the Telegram token is only served with one"`
+	InviteTTL             time.Duration     `name:"invite.ttl" default:"168h" help:"How long invite links created with /invite can be used"`
+	ClusterCheck          time.Duration     `name:"cluster.check-interval" default:"0s" help:"Check Alertmanager's cluster this often and tell the global admins when it's degraded, 0 doesn't"`
+	ClusterPeers          int               `name:"cluster.peers" default:"0" help:"The number of peers Alertmanager's cluster should have, 0 expects as many as were seen at most"`
+	ResponseTemplates     string            `name:"response.templates" help:"Directory with <name>.tmpl files overriding the bot's canned replies, like start_private.tmpl"`
+	ErrorReportWindow     time.Duration     `name:"error.report-window" default:"10m" help:"How long the same error isn't reported to a chat again, repeats are summed up once it passes. 0 reports every error"`
+	HTMLCheck             bool              `name:"telegram.html-check" default:"true" negatable:"" help:"Check the HTML of messages before sending them and send the ones Telegram can't parse as plain text, instead of having them rejected"`
+	FloodWait             bool              `name:"telegram.flood-wait" default:"true" negatable:"" help:"Pause sending to all chats while Telegram's flood control is on, instead of extending it with more messages"`
+	ReplicaRole           string            `name:"replica.role" default:"leader" enum:"leader,follower" help:"Whether this replica delivers webhooks and handles all commands (leader) or only answers read-only commands (follower), which needs telegram.webhook-url"`
+	UpdatesURL            string            `name:"telegram.webhook-url" help:"The https URL Telegram sends updates to instead of the bot polling for them, needed to run several replicas"`
+	UpdatesListen         string            `name:"telegram.webhook-listen" default:":8443" help:"The address to listen for the updates Telegram sends to telegram.webhook-url"`
+	Correlation           bool              `name:"correlation.hints" default:"false" help:"List the other alerts firing for the same project under notifications"`
+	Reconcile             bool              `name:"reconcile.startup" default:"false" help:"Check all subscribed chats with Telegram on startup and stop sending to unreachable ones"`
+	ReconcileGrace        time.Duration     `name:"reconcile.grace" default:"168h" help:"How long a chat stays unreachable before reconciliation removes it"`
+	Redactions            []string          `name:"redaction.pattern" sep:"none" help:"Regular expression replaced by [REDACTED] in alert labels and annotations, can be repeated"`
 
 	cliTelegram
-
-	Store       string `required:"true" name:"store" enum:"bolt,consul,etcd" help:"The store to use"`
-	StorePrefix string `name:"storeKeyPrefix" default:"telegram/chats" help:"Prefix for store keys"`
+	cliIssueTracker
+
+	Store             string                   `required:"true" name:"store" enum:"bolt,consul,etcd" help:"The store to use"`
+	StorePrefix       string                   `name:"storeKeyPrefix" default:"telegram/chats" help:"Prefix for store keys"`
+	SyncWrites        bool                     `name:"store.sync-writes" default:"false" help:"Write every message record to the store right away rather than buffering them for up to 500ms"`
+	StoreMaxEntries   map[string]int           `name:"store.max-entries" help:"Keep at most this many records in areas of the store, like messages=10000;fingerprints=50000;outbox=1000"`
+	StoreMaxAge       map[string]time.Duration `name:"store.max-age" help:"Remove the records of areas of the store older than this, like fingerprints=2160h"`
+	StoreCompactAbove int                      `name:"store.compact-above" default:"0" help:"Compact the fingerprints area of the store into fewer keys once it has more than this many, 0 doesn't"`
 	cliBolt
 	cliConsul
 	cliEtcd
@@ -85,9 +166,16 @@ type cliEtcd struct {
 	TLSCA                 string   `name:"etcd.tls.ca" type:"path" help:"Path to the TLS trusted CA cert file"`
 }
 
+type cliIssueTracker struct {
+	Kind    string `name:"issuetracker.kind" help:"Attach \"Create issue\" buttons to alerts for this tracker: gitlab or jira"`
+	URL     string `name:"issuetracker.url" help:"The base URL of the issue tracker"`
+	Project string `name:"issuetracker.project" help:"The GitLab project path or the numeric Jira project ID"`
+}
+
 type cliTelegram struct {
-	Admins []int  `required:"true" name:"telegram.admin" help:"The ID of the initial Telegram Admin"`
-	Token  string `required:"true" name:"telegram.token" env:"TELEGRAM_TOKEN" help:"The token used to connect with Telegram"`
+	Admins       []int  `required:"true" name:"telegram.admin" help:"The ID of the initial Telegram Admin"`
+	GlobalAdmins []int  `name:"telegram.global-admin" help:"The IDs of admins who can protect chats and change protected chats on their own, besides the initial admin"`
+	Token        string `required:"true" name:"telegram.token" env:"TELEGRAM_TOKEN" help:"The token used to connect with Telegram"`
 }
 
 func main() {
@@ -109,6 +197,11 @@ func main() {
 		logger = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
 	}
 
+	// debugLogger isn't filtered, it logs chats with /debug on at every level.
+	debugLogger := log.With(logger,
+		"ts", log.DefaultTimestampUTC,
+		"caller", log.DefaultCaller,
+	)
 	logger = level.NewFilter(logger, levelFilter[cli.LogLevel])
 	logger = log.With(logger,
 		"ts", log.DefaultTimestampUTC,
@@ -131,69 +224,33 @@ func main() {
 		am = client
 	}
 
-	var kvStore store.Store
-	{
-		switch strings.ToLower(cli.Store) {
-		case storeBolt:
-			kvStore, err = boltdb.New([]string{cli.cliBolt.Path}, &store.Config{Bucket: "alertmanager"})
-			if err != nil {
-				level.Error(logger).Log("msg", "failed to create bolt store backend", "err", err)
-				os.Exit(1)
-			}
-		case storeConsul:
-			kvStore, err = consul.New([]string{cli.cliConsul.URL.String()}, nil)
-			if err != nil {
-				level.Error(logger).Log("msg", "failed to create consul store backend", "err", err)
-				os.Exit(1)
-			}
-		case storeEtcd:
-			tlsConfig := &tls.Config{}
-
-			if cli.cliEtcd.TLSCert != "" {
-				cert, err := tls.LoadX509KeyPair(cli.cliEtcd.TLSCert, cli.cliEtcd.TLSKey)
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to create etcd store backend, could not load certificates", "err", err)
-					os.Exit(1)
-				}
-				tlsConfig.Certificates = []tls.Certificate{cert}
-			}
-
-			if cli.cliEtcd.TLSCA != "" {
-				caCert, err := ioutil.ReadFile(cli.cliEtcd.TLSCA)
-				if err != nil {
-					level.Error(logger).Log("msg", "failed to create etcd store backend, could not load ca certificate", "err", err)
-					os.Exit(1)
-				}
-
-				caCertPool := x509.NewCertPool()
-				caCertPool.AppendCertsFromPEM(caCert)
-				tlsConfig.RootCAs = caCertPool
-			}
-
-			tlsConfig.InsecureSkipVerify = cli.cliEtcd.TLSInsecureSkipVerify
-
-			if !cli.cliEtcd.TLSInsecure {
-				kvStore, err = etcd.New([]string{cli.cliEtcd.URL.String()}, &store.Config{TLS: tlsConfig})
-			} else {
-				kvStore, err = etcd.New([]string{cli.cliEtcd.URL.String()}, nil)
-			}
-
-			if err != nil {
-				level.Error(logger).Log("msg", "failed to create etcd store backend", "err", err)
-				os.Exit(1)
-			}
-		default:
-			level.Error(logger).Log("msg", "please provide one of the following supported store backends: bolt, consul, etcd")
-			os.Exit(1)
-		}
+	kvStore, err := newKVStore()
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to create store backend", "err", err)
+		os.Exit(1)
 	}
-	defer kvStore.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// TODO Needs fan out for multiple bots
 	webhooks := make(chan alertmanager.TelegramWebhook, 32)
 
+	selfCheckSecret := []byte(cli.WebhookSecret)
+	// A random secret is only known to this process, the self-check would fail whenever the public URL leads to another replica.
+	if len(selfCheckSecret) == 0 && cli.PublicURL != "" && (cli.UpdatesURL != "" || cli.ReplicaRole == string(telegram.RoleFollower)) {
+		level.Error(logger).Log("msg", "--webhook.signing-secret is required to run several replicas with --telegram.public-url")
+		os.Exit(1)
+	}
+	if len(selfCheckSecret) == 0 {
+		selfCheckSecret = make([]byte, 32)
+		if _, err := rand.Read(selfCheckSecret); err != nil {
+			level.Error(logger).Log("msg", "failed to generate the webhook signing secret", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	var chats *telegram.ChatStore
+	var bot *telegram.Bot
 	var g run.Group
 	{
 		tlogger := log.With(logger, "component", "telegram")
@@ -208,17 +265,30 @@ func main() {
 			commandCounter.WithLabelValues(command).Inc()
 		}
 
-		chats, err := telegram.NewChatStore(kvStore, cli.StorePrefix)
+		chats, err = telegram.NewChatStore(kvStore, cli.StorePrefix,
+			telegram.WithStoreFactory(newKVStore),
+			telegram.WithStoreLogger(log.With(logger, "component", "store")),
+			telegram.WithSyncWrites(cli.SyncWrites),
+		)
 		if err != nil {
 			level.Error(logger).Log("msg", "failed to create chat store", "err", err)
 			os.Exit(1)
 		}
+		defer chats.Close()
 
 		fetchPeriod, _ := strconv.ParseFloat(os.Getenv("FETCH_PERIOD"), 64)
 		deletePeriod, _ := strconv.ParseFloat(os.Getenv("DELETE_PERIOD"), 64)
-		bot, err := telegram.NewBot(
-			chats, cli.cliTelegram.Token, cli.cliTelegram.Admins[0],
+		storeAddress := cli.cliBolt.Path
+		switch cli.Store {
+		case storeConsul:
+			storeAddress = cli.cliConsul.URL.String()
+		case storeEtcd:
+			storeAddress = cli.cliEtcd.URL.String()
+		}
+
+		opts := []telegram.BotOption{
 			telegram.WithLogger(tlogger),
+			telegram.WithDebugLogger(log.With(debugLogger, "component", "telegram")),
 			telegram.WithCommandEvent(commandCount),
 			telegram.WithAddr(cli.ListenAddr),
 			telegram.WithAlertmanager(am),
@@ -226,12 +296,124 @@ func main() {
 			telegram.WithRevision(Revision),
 			telegram.WithStartTime(StartTime),
 			telegram.WithExtraAdmins(cli.cliTelegram.Admins[1:]...),
+			telegram.WithGlobalAdmins(cli.cliTelegram.GlobalAdmins...),
 
 			telegram.WithEnvironments(os.Getenv("PROMETHEUS_ENVS")),
 			telegram.WithProjects(os.Getenv("PROMETHEUS_PROJECTS")),
 			telegram.WithFetchPeriod(fetchPeriod),
 			telegram.WithDeletePeriod(deletePeriod),
-		)
+			telegram.WithLoadTestEnabled(cli.LoadTest),
+			telegram.WithRedactionPatterns(cli.Redactions...),
+			telegram.WithCorrelationHints(cli.Correlation),
+			telegram.WithDeliverInhibited(cli.DeliverInhibited),
+			telegram.WithSetupWizard(cli.SetupWizard),
+			telegram.WithMaintenanceBuffering(!cli.MaintenanceDrop),
+			telegram.WithDebounceBypass(cli.DebounceBypass),
+			telegram.WithSuppressedCriticalAlerting(cli.SuppressedCritical),
+			telegram.WithUnknownChatAlerting(cli.UnknownChatThreshold, cli.UnknownChatCooldown),
+			telegram.WithWebhookWorkers(cli.WebhookWorkers),
+			telegram.WithRouteMode(telegram.RouteMode(cli.WebhookRouteMode)),
+			telegram.WithRouteDefaultChat(cli.WebhookRouteDefault),
+			telegram.WithPublicURL(cli.PublicURL),
+			telegram.WithWebhookSelfCheck(selfCheckSecret),
+			telegram.WithFailover(cli.FailoverThreshold, cli.FailoverProbeInterval),
+			telegram.WithStartupRetry(cli.StartupAttempts, cli.StartupBackoff),
+			telegram.WithSendResolved(cli.SendResolved),
+			telegram.WithSplitByStatus(cli.SplitByStatus),
+			telegram.WithReplyToCommands(cli.ReplyToCommands),
+			telegram.WithCommandMenu(cli.CommandMenu),
+			telegram.WithSlowCommandThreshold(cli.SlowCommand),
+			telegram.WithHTMLCheck(cli.HTMLCheck),
+			telegram.WithFloodWait(cli.FloodWait),
+			telegram.WithReceiverPattern(cli.ReceiverPattern),
+			telegram.WithPollAlerts(cli.PollAlerts),
+			telegram.WithReplicaRole(telegram.ReplicaRole(cli.ReplicaRole)),
+			telegram.WithSilenceSync(cli.SilenceSync),
+			telegram.WithAckCreatesSilence(cli.AckSilence),
+			telegram.WithLoadShedding(cli.ShedMaxAge, cli.ShedPolicy),
+			telegram.WithSeverityEmoji(cli.SeverityEmoji),
+			telegram.WithSeverityOrdering(cli.SeverityOrdering),
+			telegram.WithUnlabeledPolicy(cli.UnlabeledPolicy),
+			telegram.WithUnlabeledChat(cli.UnlabeledChat),
+			telegram.WithDurableOutbox(cli.DurableOutbox),
+			telegram.WithOutboxMaxAge(cli.OutboxMaxAge),
+			telegram.WithEscalation(cli.Escalation...),
+			telegram.WithInviteTTL(cli.InviteTTL),
+			telegram.WithErrorReportWindow(cli.ErrorReportWindow),
+			telegram.WithClusterCheck(cli.ClusterCheck, cli.ClusterPeers),
+			telegram.WithMaxSilenceExtension(cli.SilenceMaxExtension),
+			telegram.WithStaleThreshold(cli.StaleAfter),
+			telegram.WithNewAlertWindow(cli.NewAlertWindow),
+			telegram.WithFingerprintTTL(cli.FingerprintTTL),
+			telegram.WithSuppressedCriticalSettings(cli.SuppressedWindow, cli.SuppressedLabel, cli.SuppressedValue, cli.SuppressedChat),
+			telegram.WithMaxAlerts(cli.MaxAlerts),
+			telegram.WithReconciliation(cli.Reconcile, cli.ReconcileGrace),
+			telegram.WithStore(cli.Store, storeAddress),
+			telegram.WithStoreCompaction(cli.StoreCompactAbove),
+		}
+		// Unknown areas are passed on for the option to reject them.
+		quotaAreas := map[string]bool{}
+		for area := range cli.StoreMaxEntries {
+			quotaAreas[area] = true
+		}
+		for area := range cli.StoreMaxAge {
+			quotaAreas[area] = true
+		}
+		for area := range quotaAreas {
+			opts = append(opts, telegram.WithStoreQuota(area, cli.StoreMaxEntries[area], cli.StoreMaxAge[area]))
+		}
+		var routes []telegram.Route
+		for _, text := range cli.WebhookRoutes {
+			r, err := telegram.ParseRoute(text)
+			if err != nil {
+				level.Error(tlogger).Log("msg", "failed to parse webhook route", "route", text, "err", err)
+				os.Exit(2)
+			}
+			routes = append(routes, r)
+		}
+		if len(routes) > 0 {
+			opts = append(opts, telegram.WithRoutes(routes...))
+		}
+		if cli.cliIssueTracker.Kind != "" {
+			opts = append(opts, telegram.WithIssueTracker(cli.cliIssueTracker.Kind, cli.cliIssueTracker.URL, cli.cliIssueTracker.Project))
+		}
+		if cli.UpdatesURL != "" {
+			opts = append(opts, telegram.WithTelegramWebhook(cli.UpdatesListen, cli.UpdatesURL))
+		}
+		if cli.ResponseTemplates != "" {
+			opts = append(opts, telegram.WithResponseTemplates(cli.ResponseTemplates))
+		}
+		if cli.SentLogFile != "" {
+			sink, err := telegram.NewFileSink(cli.SentLogFile, cli.SentLogFileMaxBytes, cli.SentLogFileBackups)
+			if err != nil {
+				level.Error(tlogger).Log("msg", "failed to create sent log file", "err", err)
+				os.Exit(2)
+			}
+			opts = append(opts, telegram.WithMessageSinks(sink))
+		}
+		if cli.SentLogSize > 0 {
+			sink, err := telegram.NewKVSink(kvStore, cli.SentLogPrefix, cli.SentLogSize)
+			if err != nil {
+				level.Error(tlogger).Log("msg", "failed to create sent log", "err", err)
+				os.Exit(2)
+			}
+			opts = append(opts, telegram.WithMessageSinks(sink))
+		}
+
+		// Stop trying to reach Telegram and the store if the bot is stopped while starting.
+		startupCtx, stopStartup := context.WithCancel(ctx)
+		startupSig := make(chan os.Signal, 1)
+		signal.Notify(startupSig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			select {
+			case <-startupSig:
+				stopStartup()
+			case <-startupCtx.Done():
+			}
+		}()
+		bot, err = telegram.NewBotContext(startupCtx, chats, cli.cliTelegram.Token, cli.cliTelegram.Admins[0], opts...)
+		signal.Stop(startupSig)
+		stopStartup()
 		if err != nil {
 			level.Error(tlogger).Log("msg", "failed to create bot", "err", err)
 			os.Exit(2)
@@ -246,8 +428,26 @@ func main() {
 			)
 
 			// Runs the bot itself communicating with Telegram
-			return bot.Run(ctx, webhooks)
+			var src telegram.WebhookSource = telegram.ChannelSource(webhooks)
+			if cli.NatsURL != "" {
+				opts := []source.NATSOption{
+					source.WithQueueGroup(cli.NatsQueue),
+					source.WithMaxDeliver(cli.NatsMaxDeliver),
+					source.WithLogger(log.With(logger, "component", "nats")),
+				}
+				if cli.NatsCredentials != "" {
+					opts = append(opts, source.WithCredentials(cli.NatsCredentials))
+				}
+				src = source.NewNATS(cli.NatsURL, cli.NatsSubject, opts...)
+			}
+			return bot.Run(ctx, src)
 		}, func(err error) {
+			// Give the queued webhooks some time to be delivered.
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			if err := bot.Shutdown(shutdownCtx); err != nil {
+				level.Warn(tlogger).Log("msg", "failed to shut down gracefully", "err", err)
+			}
 			cancel()
 		})
 	}
@@ -267,10 +467,57 @@ func main() {
 		reg.MustRegister(webhooksCounter)
 
 		m := http.NewServeMux()
-		m.HandleFunc("/webhooks/telegram/", alertmanager.HandleTelegramWebhook(wlogger, webhooksCounter, webhooks))
-		m.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		// With NATS nothing reads the webhooks sent over HTTP, so only self-checks are accepted.
+		var handleWebhook, handleRoutedWebhook http.Handler = http.NotFoundHandler(), http.NotFoundHandler()
+		if cli.NatsURL == "" {
+			limits := alertmanager.WebhookLimits{
+				MaxBytes:           cli.WebhookMaxBytes,
+				MaxAlerts:          cli.WebhookMaxAlerts,
+				MaxDepth:           cli.WebhookMaxDepth,
+				AllowUnknownFields: cli.WebhookAllowUnknown,
+			}
+			// Followers don't deliver webhooks, Alertmanager retries the ones they refuse.
+			handleWebhook = bot.LeaderWebhooks(alertmanager.HandleTelegramWebhookWithLimits(wlogger, webhooksCounter, webhooks, limits))
+			handleRoutedWebhook = bot.LeaderWebhooks(alertmanager.HandleRoutedWebhookWithLimits(wlogger, webhooksCounter, webhooks, limits, bot.RouteWebhook))
+		}
+		m.Handle("/webhooks/telegram/", alertmanager.HandleSelfCheck(selfCheckSecret, handleWebhook))
+		m.Handle("/webhooks/telegram", handleRoutedWebhook)
+		m.Handle("/metrics", telegram.BearerAuth(cli.WebToken, promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+		m.Handle(telegram.APIPrefix, telegram.BearerAuth(cli.WebToken, bot.APIHandler()))
+		// Rotating the Telegram token changes what the bot runs as, it's only served behind a token.
+		if cli.WebToken != "" {
+			m.Handle(telegram.TokenPath, telegram.BearerAuth(cli.WebToken, bot.TokenHandler()))
+		}
 		m.HandleFunc("/health", handleHealth)
 		m.HandleFunc("/healthz", handleHealth)
+		// The bot isn't ready while it can't reach its store.
+		m.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+			if !chats.Healthy() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		// /readyz tells what's wrong, too, checking the templates and reporting the public URL as well.
+		m.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			storeCheck := telegram.Check{Name: "store"}
+			if !chats.Healthy() {
+				storeCheck.Err = telegram.ErrStoreUnavailable
+			}
+			checks := append([]telegram.Check{storeCheck}, bot.Checks(r.Context())...)
+
+			status := http.StatusOK
+			for _, c := range checks {
+				if c.Err != nil && !c.Advisory {
+					status = http.StatusServiceUnavailable
+				}
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			for _, c := range checks {
+				fmt.Fprintln(w, c.String())
+			}
+		})
 
 		s := http.Server{
 			Addr:    cli.ListenAddr,
@@ -302,3 +549,58 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// newKVStore creates a client of the store backend, again when the backend was unavailable for a while.
+func newKVStore() (store.Store, error) {
+	switch strings.ToLower(cli.Store) {
+	case storeBolt:
+		kvStore, err := boltdb.New([]string{cli.cliBolt.Path}, &store.Config{Bucket: "alertmanager"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bolt store backend: %w", err)
+		}
+		return kvStore, nil
+	case storeConsul:
+		kvStore, err := consul.New([]string{cli.cliConsul.URL.String()}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consul store backend: %w", err)
+		}
+		return kvStore, nil
+	case storeEtcd:
+		tlsConfig := &tls.Config{}
+
+		if cli.cliEtcd.TLSCert != "" {
+			cert, err := tls.LoadX509KeyPair(cli.cliEtcd.TLSCert, cli.cliEtcd.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create etcd store backend, could not load certificates: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		if cli.cliEtcd.TLSCA != "" {
+			caCert, err := ioutil.ReadFile(cli.cliEtcd.TLSCA)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create etcd store backend, could not load ca certificate: %w", err)
+			}
+
+			caCertPool := x509.NewCertPool()
+			caCertPool.AppendCertsFromPEM(caCert)
+			tlsConfig.RootCAs = caCertPool
+		}
+
+		tlsConfig.InsecureSkipVerify = cli.cliEtcd.TLSInsecureSkipVerify
+
+		var kvStore store.Store
+		var err error
+		if !cli.cliEtcd.TLSInsecure {
+			kvStore, err = etcd.New([]string{cli.cliEtcd.URL.String()}, &store.Config{TLS: tlsConfig})
+		} else {
+			kvStore, err = etcd.New([]string{cli.cliEtcd.URL.String()}, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create etcd store backend: %w", err)
+		}
+		return kvStore, nil
+	default:
+		return nil, fmt.Errorf("please provide one of the following supported store backends: bolt, consul, etcd")
+	}
+}
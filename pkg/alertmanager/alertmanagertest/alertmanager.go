@@ -0,0 +1,203 @@
+// Package alertmanagertest provides a fake Alertmanager and alert and silence fixtures for tests.
+package alertmanagertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// The methods of the Alertmanager, to script, fail and count calls by.
+const (
+	MethodListAlerts          = "ListAlerts"
+	MethodListSilences        = "ListSilences"
+	MethodStatus              = "Status"
+	MethodAlertStatuses       = "AlertStatuses"
+	MethodListInhibitedAlerts = "ListInhibitedAlerts"
+	MethodUpdateSilence       = "UpdateSilence"
+	MethodListReceivers       = "ListReceivers"
+	MethodListAlertGroups     = "ListAlertGroups"
+	MethodDeleteSilence       = "DeleteSilence"
+)
+
+// Call is a call the fake Alertmanager got.
+type Call struct {
+	Method string
+	// Receiver is the receiver asked for, if the method takes one.
+	Receiver string
+	// Silence is what UpdateSilence got, a copy.
+	Silence *types.Silence
+	// SilenceID is the silence DeleteSilence got.
+	SilenceID string
+}
+
+// Alertmanager is a fake of the Alertmanager the bot talks to.
+// By default it returns what its fields are set to, the On funcs script a method's responses instead.
+// Set the fields before it's used, it's safe for concurrent calls then.
+type Alertmanager struct {
+	Alerts             []*types.Alert
+	Silences           []*types.Silence
+	AlertmanagerStatus *models.AlertmanagerStatus
+	Statuses           map[string]alertmanager.AlertStatus
+	Inhibited          []alertmanager.InhibitedAlert
+	Receivers          []string
+	AlertGroups        []*models.AlertGroup
+
+	// Err is returned by every method, unless Errs has one for the method.
+	Err  error
+	Errs map[string]error
+	// Delay makes every call take that long, or until its context is done, unless Delays has one for the method.
+	Delay  time.Duration
+	Delays map[string]time.Duration
+
+	OnListAlerts    func(ctx context.Context, receiver string, silenced bool) ([]*types.Alert, error)
+	OnListSilences  func(ctx context.Context) ([]*types.Silence, error)
+	OnUpdateSilence func(ctx context.Context, s *types.Silence) (string, error)
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// call records a call and returns the error it's to fail with, if any.
+func (a *Alertmanager) call(ctx context.Context, c Call) error {
+	a.mu.Lock()
+	a.calls = append(a.calls, c)
+	a.mu.Unlock()
+
+	delay := a.Delay
+	if d, ok := a.Delays[c.Method]; ok {
+		delay = d
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err, ok := a.Errs[c.Method]; ok {
+		return err
+	}
+	return a.Err
+}
+
+// Calls returns the calls so far, oldest first.
+func (a *Alertmanager) Calls() []Call {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Call(nil), a.calls...)
+}
+
+// CallCount returns how often the method was called.
+func (a *Alertmanager) CallCount(method string) int {
+	var n int
+	for _, c := range a.Calls() {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// DeletedSilences returns the IDs of the silences DeleteSilence got, oldest first, failed calls too.
+func (a *Alertmanager) DeletedSilences() []string {
+	var ids []string
+	for _, c := range a.Calls() {
+		if c.Method == MethodDeleteSilence {
+			ids = append(ids, c.SilenceID)
+		}
+	}
+	return ids
+}
+
+// UpdatedSilences returns the silences UpdateSilence got, oldest first, failed calls too.
+func (a *Alertmanager) UpdatedSilences() []*types.Silence {
+	var silences []*types.Silence
+	for _, c := range a.Calls() {
+		if c.Method == MethodUpdateSilence {
+			silences = append(silences, c.Silence)
+		}
+	}
+	return silences
+}
+
+func (a *Alertmanager) ListAlerts(ctx context.Context, receiver string, silenced bool) ([]*types.Alert, error) {
+	if err := a.call(ctx, Call{Method: MethodListAlerts, Receiver: receiver}); err != nil {
+		return nil, err
+	}
+	if a.OnListAlerts != nil {
+		return a.OnListAlerts(ctx, receiver, silenced)
+	}
+	return a.Alerts, nil
+}
+
+func (a *Alertmanager) ListSilences(ctx context.Context) ([]*types.Silence, error) {
+	if err := a.call(ctx, Call{Method: MethodListSilences}); err != nil {
+		return nil, err
+	}
+	if a.OnListSilences != nil {
+		return a.OnListSilences(ctx)
+	}
+	return a.Silences, nil
+}
+
+func (a *Alertmanager) Status(ctx context.Context) (*models.AlertmanagerStatus, error) {
+	if err := a.call(ctx, Call{Method: MethodStatus}); err != nil {
+		return nil, err
+	}
+	return a.AlertmanagerStatus, nil
+}
+
+func (a *Alertmanager) AlertStatuses(ctx context.Context, receiver string) (map[string]alertmanager.AlertStatus, error) {
+	if err := a.call(ctx, Call{Method: MethodAlertStatuses, Receiver: receiver}); err != nil {
+		return nil, err
+	}
+	return a.Statuses, nil
+}
+
+func (a *Alertmanager) ListInhibitedAlerts(ctx context.Context, receiver string) ([]alertmanager.InhibitedAlert, error) {
+	if err := a.call(ctx, Call{Method: MethodListInhibitedAlerts, Receiver: receiver}); err != nil {
+		return nil, err
+	}
+	return a.Inhibited, nil
+}
+
+// UpdateSilence returns the ID of the silence, or new-N for the Nth call if it has none, like creating one.
+func (a *Alertmanager) UpdateSilence(ctx context.Context, s *types.Silence) (string, error) {
+	posted := *s
+	if err := a.call(ctx, Call{Method: MethodUpdateSilence, Silence: &posted}); err != nil {
+		return "", err
+	}
+	if a.OnUpdateSilence != nil {
+		return a.OnUpdateSilence(ctx, s)
+	}
+	if s.ID == "" {
+		return fmt.Sprintf("new-%d", a.CallCount(MethodUpdateSilence)), nil
+	}
+	return s.ID, nil
+}
+
+func (a *Alertmanager) ListReceivers(ctx context.Context) ([]string, error) {
+	if err := a.call(ctx, Call{Method: MethodListReceivers}); err != nil {
+		return nil, err
+	}
+	return a.Receivers, nil
+}
+
+func (a *Alertmanager) ListAlertGroups(ctx context.Context, receiver string) ([]*models.AlertGroup, error) {
+	if err := a.call(ctx, Call{Method: MethodListAlertGroups, Receiver: receiver}); err != nil {
+		return nil, err
+	}
+	return a.AlertGroups, nil
+}
+
+func (a *Alertmanager) DeleteSilence(ctx context.Context, id string) error {
+	return a.call(ctx, Call{Method: MethodDeleteSilence, SilenceID: id})
+}
@@ -0,0 +1,97 @@
+package alertmanagertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanager(t *testing.T) {
+	am := &Alertmanager{
+		Alerts:   []*types.Alert{Alert("HighCPU").Build()},
+		Silences: []*types.Silence{Silence("s1").Matcher("alertname", "HighCPU").Build()},
+		Errs:     map[string]error{MethodStatus: errors.New("unavailable")},
+	}
+	ctx := context.Background()
+
+	alerts, err := am.ListAlerts(ctx, "/webhooks/telegram/1", false)
+	require.NoError(t, err)
+	require.Equal(t, am.Alerts, alerts)
+	silences, err := am.ListSilences(ctx)
+	require.NoError(t, err)
+	require.Equal(t, am.Silences, silences)
+	_, err = am.Status(ctx)
+	require.EqualError(t, err, "unavailable")
+
+	id, err := am.UpdateSilence(ctx, &types.Silence{Comment: "new"})
+	require.NoError(t, err)
+	require.Equal(t, "new-1", id)
+	id, err = am.UpdateSilence(ctx, &types.Silence{ID: "s1"})
+	require.NoError(t, err)
+	require.Equal(t, "s1", id)
+
+	require.Equal(t, []Call{
+		{Method: MethodListAlerts, Receiver: "/webhooks/telegram/1"},
+		{Method: MethodListSilences},
+		{Method: MethodStatus},
+		{Method: MethodUpdateSilence, Silence: &types.Silence{Comment: "new"}},
+		{Method: MethodUpdateSilence, Silence: &types.Silence{ID: "s1"}},
+	}, am.Calls())
+	require.Len(t, am.UpdatedSilences(), 2)
+}
+
+func TestAlertmanagerScripted(t *testing.T) {
+	var n int
+	am := &Alertmanager{
+		Err: errors.New("connection refused"),
+		Errs: map[string]error{
+			MethodListAlerts: nil,
+		},
+		OnListAlerts: func(context.Context, string, bool) ([]*types.Alert, error) {
+			n++
+			return []*types.Alert{Alert("HighCPU").FiringFor(time.Duration(n) * time.Minute).Build()}, nil
+		},
+	}
+
+	for i := 1; i <= 2; i++ {
+		alerts, err := am.ListAlerts(context.Background(), "", false)
+		require.NoError(t, err, "the error of the method overrides the one of all methods")
+		require.WithinDuration(t, time.Now().Add(-time.Duration(i)*time.Minute), alerts[0].StartsAt, time.Second)
+	}
+	_, err := am.ListSilences(context.Background())
+	require.EqualError(t, err, "connection refused")
+	require.Equal(t, 2, am.CallCount(MethodListAlerts))
+}
+
+func TestAlertmanagerDelay(t *testing.T) {
+	am := &Alertmanager{Delay: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := am.ListAlerts(ctx, "", false)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestFixtures(t *testing.T) {
+	b := Alert("DiskFull").Environment("prod").Project("billing").Severity("critical")
+	firing := b.Build()
+	resolved := b.ResolvedAfter(42 * time.Minute).Build()
+
+	require.Equal(t, "DiskFull", string(firing.Name()))
+	require.Equal(t, "prod", string(firing.Labels["environment"]))
+	require.False(t, firing.Resolved())
+	require.True(t, resolved.Resolved())
+	require.Equal(t, 42*time.Minute, resolved.EndsAt.Sub(resolved.StartsAt))
+	require.Equal(t, firing.Labels, resolved.Labels)
+
+	silence := Silence("s1").RegexpMatcher("instance", "node-0[0-9]").Build()
+	require.Equal(t, types.SilenceStateActive, silence.Status.State)
+	require.True(t, silence.Matchers[0].Matches("node-01"))
+	expired := Silence("s2").Ends(time.Now().Add(-time.Minute)).Build()
+	require.Equal(t, types.SilenceStateExpired, expired.Status.State)
+	require.True(t, expired.StartsAt.Before(expired.EndsAt))
+}
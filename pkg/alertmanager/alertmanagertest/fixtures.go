@@ -0,0 +1,190 @@
+package alertmanagertest
+
+import (
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+)
+
+// AlertBuilder builds alerts like Alertmanager's API returns them, firing for an hour unless told otherwise.
+type AlertBuilder struct {
+	alert types.Alert
+}
+
+// Alert starts building an alert with the alertname.
+func Alert(name string) *AlertBuilder {
+	now := time.Now()
+	return &AlertBuilder{alert: types.Alert{
+		Alert: model.Alert{
+			Labels:      model.LabelSet{model.AlertNameLabel: model.LabelValue(name)},
+			Annotations: model.LabelSet{},
+			StartsAt:    now.Add(-time.Hour),
+			EndsAt:      now.Add(time.Hour),
+		},
+		UpdatedAt: now,
+	}}
+}
+
+// Label sets a label.
+func (b *AlertBuilder) Label(name string, value string) *AlertBuilder {
+	b.alert.Labels[model.LabelName(name)] = model.LabelValue(value)
+	return b
+}
+
+// Environment sets the environment label.
+func (b *AlertBuilder) Environment(env string) *AlertBuilder {
+	return b.Label("environment", env)
+}
+
+// Project sets the project label.
+func (b *AlertBuilder) Project(project string) *AlertBuilder {
+	return b.Label("project", project)
+}
+
+// Severity sets the severity label.
+func (b *AlertBuilder) Severity(severity string) *AlertBuilder {
+	return b.Label("severity", severity)
+}
+
+// Annotation sets an annotation.
+func (b *AlertBuilder) Annotation(name string, value string) *AlertBuilder {
+	b.alert.Annotations[model.LabelName(name)] = model.LabelValue(value)
+	return b
+}
+
+// FiringFor makes the alert fire since d ago.
+func (b *AlertBuilder) FiringFor(d time.Duration) *AlertBuilder {
+	now := time.Now()
+	b.alert.StartsAt = now.Add(-d)
+	b.alert.EndsAt = now.Add(time.Hour)
+	return b
+}
+
+// ResolvedAfter makes the alert resolve a minute ago after firing for d.
+func (b *AlertBuilder) ResolvedAfter(d time.Duration) *AlertBuilder {
+	b.alert.EndsAt = time.Now().Add(-time.Minute)
+	b.alert.StartsAt = b.alert.EndsAt.Add(-d)
+	return b
+}
+
+// UpdatedAgo makes Alertmanager have updated the alert d ago.
+func (b *AlertBuilder) UpdatedAgo(d time.Duration) *AlertBuilder {
+	b.alert.UpdatedAt = time.Now().Add(-d)
+	return b
+}
+
+// Build returns the alert, the builder can be used for more alerts.
+func (b *AlertBuilder) Build() *types.Alert {
+	a := b.alert
+	a.Labels = a.Labels.Clone()
+	a.Annotations = a.Annotations.Clone()
+	return &a
+}
+
+// Group returns an alert group of the receiver with the group labels and the alerts, like Alertmanager's API returns it.
+func Group(receiver string, groupLabels model.LabelSet, alerts ...*types.Alert) *models.AlertGroup {
+	g := &models.AlertGroup{
+		Labels:   labelSet(groupLabels),
+		Receiver: &models.Receiver{Name: &receiver},
+		Alerts:   make([]*models.GettableAlert, 0, len(alerts)),
+	}
+	for _, a := range alerts {
+		startsAt, endsAt, updatedAt := strfmt.DateTime(a.StartsAt), strfmt.DateTime(a.EndsAt), strfmt.DateTime(a.UpdatedAt)
+		fingerprint := a.Fingerprint().String()
+		g.Alerts = append(g.Alerts, &models.GettableAlert{
+			Alert:       models.Alert{Labels: labelSet(a.Labels), GeneratorURL: strfmt.URI(a.GeneratorURL)},
+			Annotations: labelSet(a.Annotations),
+			StartsAt:    &startsAt,
+			EndsAt:      &endsAt,
+			UpdatedAt:   &updatedAt,
+			Fingerprint: &fingerprint,
+		})
+	}
+	return g
+}
+
+func labelSet(ls model.LabelSet) models.LabelSet {
+	converted := make(models.LabelSet, len(ls))
+	for name, value := range ls {
+		converted[string(name)] = string(value)
+	}
+	return converted
+}
+
+// SilenceBuilder builds silences, active for another hour unless told otherwise.
+type SilenceBuilder struct {
+	silence types.Silence
+}
+
+// Silence starts building a silence with the ID.
+func Silence(id string) *SilenceBuilder {
+	now := time.Now()
+	return &SilenceBuilder{silence: types.Silence{
+		ID:        id,
+		StartsAt:  now.Add(-time.Hour),
+		EndsAt:    now.Add(time.Hour),
+		UpdatedAt: now.Add(-time.Hour),
+		CreatedBy: "alertmanagertest",
+		Status:    types.SilenceStatus{State: types.SilenceStateActive},
+	}}
+}
+
+// Matcher adds an equality matcher.
+func (b *SilenceBuilder) Matcher(name string, value string) *SilenceBuilder {
+	return b.matcher(labels.MatchEqual, name, value)
+}
+
+// RegexpMatcher adds a regular expression matcher.
+func (b *SilenceBuilder) RegexpMatcher(name string, value string) *SilenceBuilder {
+	return b.matcher(labels.MatchRegexp, name, value)
+}
+
+func (b *SilenceBuilder) matcher(t labels.MatchType, name string, value string) *SilenceBuilder {
+	m, err := labels.NewMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+	b.silence.Matchers = append(b.silence.Matchers, m)
+	return b
+}
+
+// Comment sets who created the silence and why.
+func (b *SilenceBuilder) Comment(createdBy string, comment string) *SilenceBuilder {
+	b.silence.CreatedBy = createdBy
+	b.silence.Comment = comment
+	return b
+}
+
+// Ends makes the silence end at t, expired if that's in the past.
+func (b *SilenceBuilder) Ends(t time.Time) *SilenceBuilder {
+	b.silence.EndsAt = t
+	if b.silence.StartsAt.After(t) {
+		b.silence.StartsAt = t.Add(-time.Hour)
+	}
+	b.silence.Status.State = types.SilenceStateActive
+	if t.Before(time.Now()) {
+		b.silence.Status.State = types.SilenceStateExpired
+	}
+	return b
+}
+
+// Pending makes the silence start in d.
+func (b *SilenceBuilder) Pending(d time.Duration) *SilenceBuilder {
+	b.silence.StartsAt = time.Now().Add(d)
+	if !b.silence.EndsAt.After(b.silence.StartsAt) {
+		b.silence.EndsAt = b.silence.StartsAt.Add(time.Hour)
+	}
+	b.silence.Status.State = types.SilenceStatePending
+	return b
+}
+
+// Build returns the silence, the builder can be used for more silences.
+func (b *SilenceBuilder) Build() *types.Silence {
+	s := b.silence
+	s.Matchers = append(labels.Matchers(nil), s.Matchers...)
+	return &s
+}
@@ -5,14 +5,18 @@ import (
 	"time"
 
 	"github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/prometheus/alertmanager/types"
 	"github.com/prometheus/common/model"
 )
 
+// ListAlerts returns the alerts of a receiver, without the inhibited ones, which don't notify anyone.
 func (c *Client) ListAlerts(ctx context.Context, receiver string, silenced bool) ([]*types.Alert, error) {
+	inhibited := false
 	getAlerts, err := c.alertmanager.Alert.GetAlerts(alert.NewGetAlertsParams().WithContext(ctx).
 		WithReceiver(&receiver).
-		WithSilenced(&silenced),
+		WithSilenced(&silenced).
+		WithInhibited(&inhibited),
 	)
 	if err != nil {
 		return nil, err
@@ -20,36 +24,40 @@ func (c *Client) ListAlerts(ctx context.Context, receiver string, silenced bool)
 
 	alerts := make([]*types.Alert, 0, len(getAlerts.Payload))
 	for _, a := range getAlerts.Payload {
-		labels := make(model.LabelSet, len(a.Labels))
-		for name, value := range a.Labels {
-			labels[model.LabelName(name)] = model.LabelValue(value)
-		}
-		annotations := make(model.LabelSet, len(a.Annotations))
-		for name, value := range a.Annotations {
-			annotations[model.LabelName(name)] = model.LabelValue(value)
-		}
-
-		endsAt := time.Time{}
-		if a.EndsAt != nil {
-			endsAt = time.Time(*a.EndsAt)
-		}
-		updatedAt := time.Time{}
-		if a.UpdatedAt != nil {
-			updatedAt = time.Time(*a.UpdatedAt)
-		}
-
-		alerts = append(alerts, &types.Alert{
-			Alert: model.Alert{
-				Labels:       labels,
-				Annotations:  annotations,
-				StartsAt:     time.Time(*a.StartsAt),
-				EndsAt:       endsAt,
-				GeneratorURL: a.GeneratorURL.String(),
-			},
-			UpdatedAt: updatedAt,
-			Timeout:   false,
-		})
+		alerts = append(alerts, alertFromAPI(a))
 	}
 
 	return alerts, nil
 }
+
+func alertFromAPI(a *models.GettableAlert) *types.Alert {
+	labels := make(model.LabelSet, len(a.Labels))
+	for name, value := range a.Labels {
+		labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	annotations := make(model.LabelSet, len(a.Annotations))
+	for name, value := range a.Annotations {
+		annotations[model.LabelName(name)] = model.LabelValue(value)
+	}
+
+	endsAt := time.Time{}
+	if a.EndsAt != nil {
+		endsAt = time.Time(*a.EndsAt)
+	}
+	updatedAt := time.Time{}
+	if a.UpdatedAt != nil {
+		updatedAt = time.Time(*a.UpdatedAt)
+	}
+
+	return &types.Alert{
+		Alert: model.Alert{
+			Labels:       labels,
+			Annotations:  annotations,
+			StartsAt:     time.Time(*a.StartsAt),
+			EndsAt:       endsAt,
+			GeneratorURL: a.GeneratorURL.String(),
+		},
+		UpdatedAt: updatedAt,
+		Timeout:   false,
+	}
+}
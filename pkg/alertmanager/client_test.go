@@ -2,14 +2,17 @@ package alertmanager
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-openapi/strfmt"
 	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/pkg/labels"
 	"github.com/prometheus/alertmanager/types"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
@@ -187,7 +190,11 @@ func TestClient(t *testing.T) {
 			StartsAt:  time.Date(2021, 01, 11, 16, 10, 11, 0, time.UTC),
 			EndsAt:    time.Date(2022, 01, 11, 16, 10, 02, 0, time.UTC),
 			UpdatedAt: time.Date(2021, 01, 11, 16, 10, 11, 0, time.UTC),
-			Matchers:  types.Matchers{},
+			Matchers: labels.Matchers{
+				{Type: labels.MatchEqual, Name: "alertname", Value: "KubeMemoryOvercommit"},
+				{Type: labels.MatchEqual, Name: "prometheus", Value: "monitoring/metalmatze"},
+				{Type: labels.MatchEqual, Name: "severity", Value: "warning"},
+			},
 			Status: types.SilenceStatus{
 				State: types.SilenceStateActive,
 			},
@@ -198,3 +205,66 @@ func TestClient(t *testing.T) {
 		require.Equal(t, expected, alerts)
 	}
 }
+
+func TestUpdateSilence(t *testing.T) {
+	var posted models.PostableSilence
+	m := http.NewServeMux()
+	m.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"silenceID": "34f5f82b-b66f-456b-aff7-b556a7eafe81"}`))
+	})
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	client, err := NewClient(u)
+	require.NoError(t, err)
+
+	endsAt := time.Date(2022, 01, 11, 20, 10, 02, 0, time.UTC)
+	id, err := client.UpdateSilence(context.Background(), &types.Silence{
+		ID:        "34f5f82b-b66f-456b-aff7-b556a7eafe81",
+		CreatedBy: "metalmatze",
+		Comment:   "foo",
+		StartsAt:  time.Date(2021, 01, 11, 16, 10, 11, 0, time.UTC),
+		EndsAt:    endsAt,
+		Matchers: labels.Matchers{
+			{Type: labels.MatchEqual, Name: "alertname", Value: "KubeMemoryOvercommit"},
+			{Type: labels.MatchNotRegexp, Name: "severity", Value: "info|none"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "34f5f82b-b66f-456b-aff7-b556a7eafe81", id)
+
+	require.Equal(t, "34f5f82b-b66f-456b-aff7-b556a7eafe81", posted.ID)
+	require.Equal(t, strfmt.DateTime(endsAt).String(), posted.EndsAt.String())
+	require.Len(t, posted.Matchers, 2)
+	require.Equal(t, "severity", *posted.Matchers[1].Name)
+	require.False(t, *posted.Matchers[1].IsEqual)
+	require.True(t, *posted.Matchers[1].IsRegex)
+}
+
+func TestDeleteSilence(t *testing.T) {
+	var deleted []string
+	m := http.NewServeMux()
+	m.HandleFunc("/api/v2/silence/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		id := strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")
+		if id == "00000000-0000-0000-0000-000000000000" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		deleted = append(deleted, id)
+	})
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	client, err := NewClient(u)
+	require.NoError(t, err)
+
+	require.NoError(t, client.DeleteSilence(context.Background(), "34f5f82b-b66f-456b-aff7-b556a7eafe81"))
+	require.Equal(t, []string{"34f5f82b-b66f-456b-aff7-b556a7eafe81"}, deleted)
+	require.Error(t, client.DeleteSilence(context.Background(), "00000000-0000-0000-0000-000000000000"))
+}
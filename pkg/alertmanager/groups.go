@@ -0,0 +1,33 @@
+package alertmanager
+
+import (
+	"context"
+
+	"github.com/prometheus/alertmanager/api/v2/client/alertgroup"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/types"
+)
+
+// ListAlertGroups returns the alert groups of a receiver, grouped by the group_by labels of its route.
+// Silenced and inhibited alerts are left out, like ListAlerts does by default.
+func (c *Client) ListAlertGroups(ctx context.Context, receiver string) ([]*models.AlertGroup, error) {
+	silenced, inhibited := false, false
+	getGroups, err := c.alertmanager.Alertgroup.GetAlertGroups(alertgroup.NewGetAlertGroupsParams().WithContext(ctx).
+		WithReceiver(&receiver).
+		WithSilenced(&silenced).
+		WithInhibited(&inhibited),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return getGroups.Payload, nil
+}
+
+// GroupAlerts returns the alerts of the group.
+func GroupAlerts(g *models.AlertGroup) []*types.Alert {
+	alerts := make([]*types.Alert, 0, len(g.Alerts))
+	for _, a := range g.Alerts {
+		alerts = append(alerts, alertFromAPI(a))
+	}
+	return alerts
+}
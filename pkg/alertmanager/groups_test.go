@@ -0,0 +1,63 @@
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const jsonAlertGroups = `[
+  {
+    "labels": {"alertname": "HighCPU", "environment": "prod"},
+    "receiver": {"name": "telegram-ops"},
+    "alerts": [
+      {
+        "labels": {"alertname": "HighCPU", "environment": "prod", "instance": "web-1"},
+        "annotations": {"summary": "CPU usage is 95%"},
+        "startsAt": "2021-03-04T11:00:00Z",
+        "endsAt": "2021-03-04T13:00:00Z",
+        "updatedAt": "2021-03-04T12:29:00Z",
+        "generatorURL": "http://prometheus/graph",
+        "fingerprint": "a1",
+        "receivers": [{"name": "telegram-ops"}],
+        "status": {"state": "active", "silencedBy": [], "inhibitedBy": []}
+      }
+    ]
+  },
+  {
+    "labels": {"alertname": "DiskFull"},
+    "receiver": {"name": "telegram-ops"},
+    "alerts": []
+  }
+]`
+
+func TestListAlertGroups(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/alerts/groups", r.URL.Path)
+		require.Equal(t, "telegram-ops", r.URL.Query().Get("receiver"))
+		require.Equal(t, "false", r.URL.Query().Get("silenced"))
+		require.Equal(t, "false", r.URL.Query().Get("inhibited"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(jsonAlertGroups))
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	client, err := NewClient(u)
+	require.NoError(t, err)
+
+	groups, err := client.ListAlertGroups(context.Background(), "telegram-ops")
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	require.Equal(t, "prod", groups[0].Labels["environment"])
+
+	alerts := GroupAlerts(groups[0])
+	require.Len(t, alerts, 1)
+	require.Equal(t, "web-1", string(alerts[0].Labels["instance"]))
+	require.Equal(t, "CPU usage is 95%", string(alerts[0].Annotations["summary"]))
+	require.Empty(t, GroupAlerts(groups[1]))
+}
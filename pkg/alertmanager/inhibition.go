@@ -0,0 +1,102 @@
+package alertmanager
+
+import (
+	"context"
+
+	"github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/types"
+)
+
+// AlertStatus is what Alertmanager knows about the state of an alert.
+type AlertStatus struct {
+	Fingerprint string
+	// State is active, suppressed or unprocessed.
+	State       string
+	SilencedBy  []string
+	InhibitedBy []string
+}
+
+// Inhibited tells if other alerts inhibit the alert.
+func (s AlertStatus) Inhibited() bool {
+	return len(s.InhibitedBy) > 0
+}
+
+// InhibitedAlert is an alert and the alerts inhibiting it.
+type InhibitedAlert struct {
+	Alert *types.Alert
+	// InhibitedBy are the alertnames of the inhibiting alerts, or their fingerprints if they aren't listed.
+	InhibitedBy []string
+}
+
+// listAllAlerts returns all alerts of a receiver, silenced and inhibited ones too.
+func (c *Client) listAllAlerts(ctx context.Context, receiver string) ([]*models.GettableAlert, error) {
+	silenced, inhibited := true, true
+	getAlerts, err := c.alertmanager.Alert.GetAlerts(alert.NewGetAlertsParams().WithContext(ctx).
+		WithReceiver(&receiver).
+		WithSilenced(&silenced).
+		WithInhibited(&inhibited),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return getAlerts.Payload, nil
+}
+
+// AlertStatuses returns the status of all alerts of a receiver by their fingerprint.
+func (c *Client) AlertStatuses(ctx context.Context, receiver string) (map[string]AlertStatus, error) {
+	alerts, err := c.listAllAlerts(ctx, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]AlertStatus, len(alerts))
+	for _, a := range alerts {
+		if a.Fingerprint == nil || a.Status == nil {
+			continue
+		}
+		s := AlertStatus{
+			Fingerprint: *a.Fingerprint,
+			SilencedBy:  a.Status.SilencedBy,
+			InhibitedBy: a.Status.InhibitedBy,
+		}
+		if a.Status.State != nil {
+			s.State = *a.Status.State
+		}
+		statuses[s.Fingerprint] = s
+	}
+	return statuses, nil
+}
+
+// ListInhibitedAlerts returns the inhibited alerts of a receiver.
+// The inhibiting alerts are named by their alertname if they're listed for the receiver too.
+func (c *Client) ListInhibitedAlerts(ctx context.Context, receiver string) ([]InhibitedAlert, error) {
+	alerts, err := c.listAllAlerts(ctx, receiver)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(alerts))
+	for _, a := range alerts {
+		if a.Fingerprint != nil && a.Labels["alertname"] != "" {
+			names[*a.Fingerprint] = a.Labels["alertname"]
+		}
+	}
+
+	var inhibited []InhibitedAlert
+	for _, a := range alerts {
+		if a.Status == nil || len(a.Status.InhibitedBy) == 0 {
+			continue
+		}
+		by := make([]string, 0, len(a.Status.InhibitedBy))
+		for _, fingerprint := range a.Status.InhibitedBy {
+			if name, ok := names[fingerprint]; ok {
+				by = append(by, name)
+			} else {
+				by = append(by, fingerprint)
+			}
+		}
+		inhibited = append(inhibited, InhibitedAlert{Alert: alertFromAPI(a), InhibitedBy: by})
+	}
+	return inhibited, nil
+}
@@ -0,0 +1,101 @@
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonInhibitedAlerts = `
+[
+  {
+    "annotations": {},
+    "endsAt": "2021-02-22T00:52:37.000Z",
+    "fingerprint": "1111111111111111",
+    "receivers": [{"name": "ops"}],
+    "startsAt": "2021-02-22T00:40:00.000Z",
+    "status": {"inhibitedBy": [], "silencedBy": [], "state": "active"},
+    "updatedAt": "2021-02-22T00:48:37.000Z",
+    "labels": {"alertname": "InstanceDown", "instance": "node-01"}
+  },
+  {
+    "annotations": {"summary": "p99 above 2s"},
+    "endsAt": "2021-02-22T00:52:37.000Z",
+    "fingerprint": "2222222222222222",
+    "receivers": [{"name": "ops"}],
+    "startsAt": "2021-02-22T00:41:00.000Z",
+    "status": {"inhibitedBy": ["1111111111111111", "9999999999999999"], "silencedBy": [], "state": "suppressed"},
+    "updatedAt": "2021-02-22T00:48:37.000Z",
+    "labels": {"alertname": "HighLatency", "instance": "node-01"}
+  },
+  {
+    "annotations": {},
+    "endsAt": "2021-02-22T00:52:37.000Z",
+    "fingerprint": "3333333333333333",
+    "receivers": [{"name": "ops"}],
+    "startsAt": "2021-02-22T00:42:00.000Z",
+    "status": {"inhibitedBy": [], "silencedBy": ["34f5f82b"], "state": "suppressed"},
+    "updatedAt": "2021-02-22T00:48:37.000Z",
+    "labels": {"alertname": "DiskFull", "instance": "node-02"}
+  }
+]`
+
+func newInhibitionTestClient(t *testing.T, queries chan<- url.Values) *Client {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(jsonInhibitedAlerts))
+	}))
+	t.Cleanup(s.Close)
+
+	u, _ := url.Parse(s.URL)
+	client, err := NewClient(u)
+	require.NoError(t, err)
+	return client
+}
+
+func TestListAlertsExcludesInhibited(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	client := newInhibitionTestClient(t, queries)
+
+	_, err := client.ListAlerts(context.Background(), "ops", false)
+	require.NoError(t, err)
+	require.Equal(t, "false", (<-queries).Get("inhibited"))
+}
+
+func TestAlertStatuses(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	client := newInhibitionTestClient(t, queries)
+
+	statuses, err := client.AlertStatuses(context.Background(), "ops")
+	require.NoError(t, err)
+	q := <-queries
+	require.Equal(t, "true", q.Get("inhibited"))
+	require.Equal(t, "true", q.Get("silenced"))
+
+	require.Len(t, statuses, 3)
+	require.False(t, statuses["1111111111111111"].Inhibited())
+	require.True(t, statuses["2222222222222222"].Inhibited())
+	require.Equal(t, "suppressed", statuses["2222222222222222"].State)
+	require.False(t, statuses["3333333333333333"].Inhibited())
+	require.Equal(t, []string{"34f5f82b"}, statuses["3333333333333333"].SilencedBy)
+}
+
+func TestListInhibitedAlerts(t *testing.T) {
+	queries := make(chan url.Values, 1)
+	client := newInhibitionTestClient(t, queries)
+
+	inhibited, err := client.ListInhibitedAlerts(context.Background(), "ops")
+	require.NoError(t, err)
+	<-queries
+
+	require.Len(t, inhibited, 1)
+	require.Equal(t, model.LabelValue("HighLatency"), inhibited[0].Alert.Labels["alertname"])
+	// The second inhibiting alert isn't listed, so only its fingerprint is known.
+	require.Equal(t, []string{"InstanceDown", "9999999999999999"}, inhibited[0].InhibitedBy)
+}
@@ -0,0 +1,65 @@
+package alertmanager
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+)
+
+// MatcherMatches evaluates a single matcher against a label set.
+// A missing label matches like an empty value, as in Alertmanager.
+// Regular expressions are fully anchored, an invalid one never matches.
+func MatcherMatches(m *labels.Matcher, lset model.LabelSet) bool {
+	value := string(lset[model.LabelName(m.Name)])
+
+	switch m.Type {
+	case labels.MatchEqual:
+		return value == m.Value
+	case labels.MatchNotEqual:
+		return value != m.Value
+	case labels.MatchRegexp, labels.MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return false
+		}
+		return re.MatchString(value) == (m.Type == labels.MatchRegexp)
+	default:
+		return false
+	}
+}
+
+// SilenceMatches returns true if all matchers of the silence match the label set.
+// A silence without matchers matches nothing.
+func SilenceMatches(s *types.Silence, lset model.LabelSet) bool {
+	if len(s.Matchers) == 0 {
+		return false
+	}
+	for _, m := range s.Matchers {
+		if !MatcherMatches(m, lset) {
+			return false
+		}
+	}
+	return true
+}
+
+// CoveringSilences returns the active silences matching the label set, the longest running first.
+func CoveringSilences(silences []*types.Silence, lset model.LabelSet) []*types.Silence {
+	var covering []*types.Silence
+	seen := map[string]bool{}
+	for _, s := range silences {
+		if s.Status.State != types.SilenceStateActive || seen[s.ID] {
+			continue
+		}
+		if SilenceMatches(s, lset) {
+			seen[s.ID] = true
+			covering = append(covering, s)
+		}
+	}
+	sort.SliceStable(covering, func(i, j int) bool {
+		return covering[i].EndsAt.After(covering[j].EndsAt)
+	})
+	return covering
+}
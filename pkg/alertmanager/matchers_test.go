@@ -0,0 +1,89 @@
+package alertmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var matcherLabels = model.LabelSet{
+	"alertname": "HighCPU",
+	"instance":  "node-01:9100",
+	"severity":  "critical",
+}
+
+func TestMatcherMatches(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		matcher labels.Matcher
+		matches bool
+	}{
+		{"equal", labels.Matcher{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"}, true},
+		{"equal other value", labels.Matcher{Type: labels.MatchEqual, Name: "alertname", Value: "HighMemory"}, false},
+		{"equal missing label", labels.Matcher{Type: labels.MatchEqual, Name: "team", Value: "ops"}, false},
+		{"equal empty matches missing label", labels.Matcher{Type: labels.MatchEqual, Name: "team", Value: ""}, true},
+		{"not equal", labels.Matcher{Type: labels.MatchNotEqual, Name: "severity", Value: "warning"}, true},
+		{"not equal same value", labels.Matcher{Type: labels.MatchNotEqual, Name: "severity", Value: "critical"}, false},
+		{"regex", labels.Matcher{Type: labels.MatchRegexp, Name: "instance", Value: "node-0[0-9]:.*"}, true},
+		{"regex alternatives", labels.Matcher{Type: labels.MatchRegexp, Name: "severity", Value: "warning|critical"}, true},
+		{"regex is anchored", labels.Matcher{Type: labels.MatchRegexp, Name: "instance", Value: "node-01"}, false},
+		{"regex invalid", labels.Matcher{Type: labels.MatchRegexp, Name: "instance", Value: "node-(01"}, false},
+		{"not regex", labels.Matcher{Type: labels.MatchNotRegexp, Name: "alertname", Value: "High.*"}, false},
+		{"not regex other value", labels.Matcher{Type: labels.MatchNotRegexp, Name: "alertname", Value: "Disk.*"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.matches, MatcherMatches(&tc.matcher, matcherLabels))
+		})
+	}
+}
+
+func TestSilenceMatches(t *testing.T) {
+	s := &types.Silence{Matchers: labels.Matchers{
+		{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"},
+		{Type: labels.MatchRegexp, Name: "instance", Value: "node-.*"},
+	}}
+	assert.True(t, SilenceMatches(s, matcherLabels))
+
+	s.Matchers = append(s.Matchers, &labels.Matcher{Type: labels.MatchEqual, Name: "severity", Value: "warning"})
+	assert.False(t, SilenceMatches(s, matcherLabels))
+
+	assert.False(t, SilenceMatches(&types.Silence{}, matcherLabels))
+}
+
+func TestCoveringSilences(t *testing.T) {
+	now := time.Now()
+	active := types.SilenceStatus{State: types.SilenceStateActive}
+	short := &types.Silence{
+		ID:       "short",
+		EndsAt:   now.Add(time.Hour),
+		Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"}},
+		Status:   active,
+	}
+	long := &types.Silence{
+		ID:       "long",
+		EndsAt:   now.Add(24 * time.Hour),
+		Matchers: labels.Matchers{{Type: labels.MatchRegexp, Name: "severity", Value: "critical|warning"}},
+		Status:   active,
+	}
+	expired := &types.Silence{
+		ID:       "expired",
+		EndsAt:   now.Add(-time.Hour),
+		Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"}},
+		Status:   types.SilenceStatus{State: types.SilenceStateExpired},
+	}
+	other := &types.Silence{
+		ID:       "other",
+		EndsAt:   now.Add(time.Hour),
+		Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "DiskFull"}},
+		Status:   active,
+	}
+
+	covering := CoveringSilences([]*types.Silence{short, expired, other, long, short}, matcherLabels)
+	assert.Equal(t, []*types.Silence{long, short}, covering)
+
+	assert.Empty(t, CoveringSilences([]*types.Silence{other, expired}, matcherLabels))
+}
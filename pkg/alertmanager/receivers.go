@@ -0,0 +1,26 @@
+package alertmanager
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/alertmanager/api/v2/client/receiver"
+)
+
+// ListReceivers returns the names of the receivers configured in Alertmanager, sorted.
+func (c *Client) ListReceivers(ctx context.Context) ([]string, error) {
+	getReceivers, err := c.alertmanager.Receiver.GetReceivers(receiver.NewGetReceiversParams().WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(getReceivers.Payload))
+	for _, r := range getReceivers.Payload {
+		if r == nil || r.Name == nil {
+			continue
+		}
+		names = append(names, *r.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,28 @@
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListReceivers(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/receivers", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "telegram-ops"}, {"name": "/webhooks/telegram/-100"}, {"name": "blackhole"}]`))
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	client, err := NewClient(u)
+	require.NoError(t, err)
+
+	receivers, err := client.ListReceivers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"/webhooks/telegram/-100", "blackhole", "telegram-ops"}, receivers)
+}
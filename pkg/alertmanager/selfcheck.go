@@ -0,0 +1,77 @@
+package alertmanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of a webhook's body, like sha256=<hex>.
+	SignatureHeader = "X-Alertmanager-Bot-Signature"
+	// SelfCheckStatus is the status of the no-op webhook the bot sends itself
+	// through its public URL, to check that Alertmanager can reach it.
+	SelfCheckStatus = "selfcheck"
+
+	// maxSelfCheckBytes is the largest self-check body read, they're way smaller.
+	maxSelfCheckBytes = 64 << 10
+)
+
+// SignWebhook returns the signature of body for the SignatureHeader.
+func SignWebhook(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SelfCheckWebhook returns the no-op webhook to sign and send for a self-check at now.
+func SelfCheckWebhook(now time.Time) webhook.Message {
+	return webhook.Message{
+		Version:  "4",
+		GroupKey: strconv.FormatInt(now.UnixNano(), 10),
+		Data:     &template.Data{Status: SelfCheckStatus},
+	}
+}
+
+// HandleSelfCheck answers signed self-check webhooks with 204 No Content, without delivering them.
+// Requests without a signature are passed on to next.
+func HandleSelfCheck(secret []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature := r.Header.Get(SignatureHeader)
+		if signature == "" || len(secret) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost || r.Body == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSelfCheckBytes))
+		if err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var message webhook.Message
+		if err := json.Unmarshal(body, &message); err != nil || message.Data == nil || message.Status != SelfCheckStatus {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"only self-check webhooks are signed"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,48 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSelfCheck(t *testing.T) {
+	secret := []byte("s3cret")
+	selfCheck, err := json.Marshal(SelfCheckWebhook(time.Now()))
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		body      []byte
+		signature string
+		status    int
+		delivered bool
+	}{
+		{name: "unsigned webhooks are delivered", body: []byte(validWebhook), status: http.StatusOK, delivered: true},
+		{name: "signed self-check", body: selfCheck, signature: SignWebhook(secret, selfCheck), status: http.StatusNoContent},
+		{name: "wrong secret", body: selfCheck, signature: SignWebhook([]byte("guessed"), selfCheck), status: http.StatusUnauthorized},
+		{name: "signed real webhook", body: []byte(validWebhook), signature: SignWebhook(secret, []byte(validWebhook)), status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			webhooks := make(chan TelegramWebhook, 1)
+			h := HandleSelfCheck(secret, HandleTelegramWebhook(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{Name: "webhooks"}), webhooks))
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/telegram/1234", bytes.NewReader(tc.body))
+			if tc.signature != "" {
+				req.Header.Set(SignatureHeader, tc.signature)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.delivered, len(webhooks) == 1)
+		})
+	}
+}
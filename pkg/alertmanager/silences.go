@@ -8,8 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-openapi/strfmt"
 	"github.com/hako/durafmt"
 	"github.com/prometheus/alertmanager/api/v2/client/silence"
+	"github.com/prometheus/alertmanager/api/v2/models"
 )
 
 func (c *Client) ListSilences(ctx context.Context) ([]*types.Silence, error) {
@@ -21,10 +23,11 @@ func (c *Client) ListSilences(ctx context.Context) ([]*types.Silence, error) {
 	silences := make([]*types.Silence, 0, len(getSilences.Payload))
 	for _, s := range getSilences.Payload {
 		var matchers = make([]*labels.Matcher, 0, len(s.Matchers))
-		for _, m := range matchers {
+		for _, m := range s.Matchers {
 			matchers = append(matchers, &labels.Matcher{
-				Name:  m.Name,
-				Value: m.Value,
+				Type:  matchType(m),
+				Name:  *m.Name,
+				Value: *m.Value,
 			})
 		}
 
@@ -45,6 +48,58 @@ func (c *Client) ListSilences(ctx context.Context) ([]*types.Silence, error) {
 	return silences, nil
 }
 
+// UpdateSilence posts the silence to Alertmanager, which updates the silence with its ID
+// or creates a new one if the ID is empty. It returns the ID of the silence.
+func (c *Client) UpdateSilence(ctx context.Context, s *types.Silence) (string, error) {
+	matchers := make(models.Matchers, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		name, value := m.Name, m.Value
+		isEqual := m.Type == labels.MatchEqual || m.Type == labels.MatchRegexp
+		isRegex := m.Type == labels.MatchRegexp || m.Type == labels.MatchNotRegexp
+		matchers = append(matchers, &models.Matcher{Name: &name, Value: &value, IsEqual: &isEqual, IsRegex: &isRegex})
+	}
+	startsAt, endsAt := strfmt.DateTime(s.StartsAt), strfmt.DateTime(s.EndsAt)
+	createdBy, comment := s.CreatedBy, s.Comment
+
+	params := silence.NewPostSilencesParams().WithContext(ctx).WithSilence(&models.PostableSilence{
+		ID: s.ID,
+		Silence: models.Silence{
+			Comment:   &comment,
+			CreatedBy: &createdBy,
+			StartsAt:  &startsAt,
+			EndsAt:    &endsAt,
+			Matchers:  matchers,
+		},
+	})
+	ok, err := c.alertmanager.Silence.PostSilences(params)
+	if err != nil {
+		return "", err
+	}
+	return ok.Payload.SilenceID, nil
+}
+
+// DeleteSilence expires the silence with the ID, Alertmanager drops expired silences after its retention.
+func (c *Client) DeleteSilence(ctx context.Context, id string) error {
+	_, err := c.alertmanager.Silence.DeleteSilence(silence.NewDeleteSilenceParams().WithContext(ctx).WithSilenceID(strfmt.UUID(id)))
+	return err
+}
+
+// matchType returns the type of an API matcher, which is an equal match if not set otherwise.
+func matchType(m *models.Matcher) labels.MatchType {
+	isEqual := m.IsEqual == nil || *m.IsEqual
+	isRegex := m.IsRegex != nil && *m.IsRegex
+	switch {
+	case isRegex && isEqual:
+		return labels.MatchRegexp
+	case isRegex:
+		return labels.MatchNotRegexp
+	case isEqual:
+		return labels.MatchEqual
+	default:
+		return labels.MatchNotEqual
+	}
+}
+
 // SilenceMessage converts a silences to a message string.
 func SilenceMessage(s *types.Silence) string {
 	var alertname, emoji, matchers, duration string
@@ -15,3 +15,24 @@ func (c Client) Status(ctx context.Context) (*models.AlertmanagerStatus, error)
 
 	return status.Payload, nil
 }
+
+// ClusterStatus is the state of the cluster an Alertmanager is part of.
+type ClusterStatus struct {
+	Name string
+	// Status is ready, settling or disabled if Alertmanager doesn't run in a cluster.
+	Status string
+	// Peers is the number of peers the Alertmanager sees, itself included.
+	Peers int
+}
+
+// Cluster returns the cluster status of the Alertmanager status, disabled if it has none.
+func Cluster(s *models.AlertmanagerStatus) ClusterStatus {
+	if s == nil || s.Cluster == nil {
+		return ClusterStatus{Status: models.ClusterStatusStatusDisabled}
+	}
+	cs := ClusterStatus{Name: s.Cluster.Name, Peers: len(s.Cluster.Peers), Status: models.ClusterStatusStatusDisabled}
+	if s.Cluster.Status != nil {
+		cs.Status = *s.Cluster.Status
+	}
+	return cs
+}
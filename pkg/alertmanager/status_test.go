@@ -0,0 +1,20 @@
+package alertmanager
+
+import (
+	"testing"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster(t *testing.T) {
+	ready := models.ClusterStatusStatusReady
+	name := "peer"
+	require.Equal(t, ClusterStatus{Status: "disabled"}, Cluster(nil))
+	require.Equal(t, ClusterStatus{Status: "disabled"}, Cluster(&models.AlertmanagerStatus{}))
+	require.Equal(t, ClusterStatus{Name: "01F", Status: "ready", Peers: 2}, Cluster(&models.AlertmanagerStatus{Cluster: &models.ClusterStatus{
+		Name:   "01F",
+		Status: &ready,
+		Peers:  []*models.PeerStatus{{Name: &name}, {Name: &name}},
+	}}))
+}
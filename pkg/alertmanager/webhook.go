@@ -1,7 +1,10 @@
 package alertmanager
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,16 +12,124 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type TelegramWebhook struct {
 	ChatID  int64
 	Message webhook.Message
+	// Raw is the payload the webhook was decoded from, if it came from a source that keeps it.
+	Raw []byte `json:"-"`
+	// Ack, if set, is called once the bot is done with the webhook, with an error if it couldn't
+	// deliver it, for sources that can redeliver webhooks to know if they should.
+	Ack func(err error) `json:"-"`
+}
+
+// WebhookLimits bound what the webhook handler accepts, so that a single request can't exhaust memory.
+type WebhookLimits struct {
+	// MaxBytes is the largest body accepted, 0 accepts any size.
+	MaxBytes int64
+	// MaxAlerts is the number of alerts kept per webhook, the rest is dropped and counted as truncated.
+	// 0 keeps all alerts.
+	MaxAlerts int
+	// MaxDepth is how deep the JSON body may nest, 0 doesn't limit it.
+	MaxDepth int
+	// AllowUnknownFields accepts fields Alertmanager's webhook message doesn't have.
+	AllowUnknownFields bool
+}
+
+// DefaultWebhookLimits are generous for what Alertmanager sends.
+var DefaultWebhookLimits = WebhookLimits{
+	MaxBytes:  4 << 20,
+	MaxAlerts: 1000,
+	MaxDepth:  32,
+}
+
+var (
+	errNotObject = errors.New("webhook body is not a JSON object")
+	errTooDeep   = errors.New("webhook body nests too deep")
+)
+
+// errBodyTooLarge is the message of the error http.MaxBytesReader returns.
+const errBodyTooLarge = "http: request body too large"
+
+// jsonShapeReader passes a JSON body through, failing as soon as it isn't an object or nests deeper than maxDepth.
+type jsonShapeReader struct {
+	r        io.Reader
+	maxDepth int
+
+	depth    int
+	started  bool
+	inString bool
+	escaped  bool
+}
+
+func (s *jsonShapeReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	for _, c := range p[:n] {
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+			}
+			continue
+		}
+		if !s.started {
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				continue
+			}
+			if c != '{' {
+				return 0, errNotObject
+			}
+			s.started = true
+		}
+		switch c {
+		case '"':
+			s.inString = true
+		case '{', '[':
+			s.depth++
+			if s.maxDepth > 0 && s.depth > s.maxDepth {
+				return 0, errTooDeep
+			}
+		case '}', ']':
+			s.depth--
+		}
+	}
+	return n, err
+}
+
+// decodeWebhook decodes a webhook message from body within the limits.
+// Alerts over the limit are dropped and added to the message's truncated alerts.
+func decodeWebhook(body io.Reader, limits WebhookLimits) (webhook.Message, error) {
+	var message webhook.Message
+
+	dec := json.NewDecoder(&jsonShapeReader{r: body, maxDepth: limits.MaxDepth})
+	if !limits.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&message); err != nil {
+		return message, err
+	}
+
+	if message.Data != nil && limits.MaxAlerts > 0 && len(message.Alerts) > limits.MaxAlerts {
+		message.TruncatedAlerts += uint64(len(message.Alerts) - limits.MaxAlerts)
+		message.Alerts = message.Alerts[:limits.MaxAlerts]
+	}
+	return message, nil
 }
 
 // HandleTelegramWebhook returns a HandlerFunc that forwards webhooks to all bots via a channel.
 func HandleTelegramWebhook(logger log.Logger, counter prometheus.Counter, webhooks chan<- TelegramWebhook) http.HandlerFunc {
+	return HandleTelegramWebhookWithLimits(logger, counter, webhooks, DefaultWebhookLimits)
+}
+
+// HandleTelegramWebhookWithLimits is HandleTelegramWebhook rejecting webhooks over the limits.
+func HandleTelegramWebhookWithLimits(logger log.Logger, counter prometheus.Counter, webhooks chan<- TelegramWebhook, limits WebhookLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -35,29 +146,132 @@ func HandleTelegramWebhook(logger log.Logger, counter prometheus.Counter, webhoo
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte(`{"error":"unable to parse chat ID to int64"}`))
-			//_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 
-		var message webhook.Message
+		message, raw, ok := readWebhook(log.With(logger, "chat_id", chatID), w, r, limits)
+		if !ok {
+			return
+		}
 
-		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
-			level.Warn(logger).Log(
-				"msg", "failed to decode webhook message",
-				"err", err,
-			)
-			_, _ = w.Write([]byte("in json decoder"))
+		webhooks <- TelegramWebhook{ChatID: chatID, Message: message, Raw: raw}
+		counter.Inc()
+	}
+}
+
+// ErrNoRoute is returned by a WebhookRouter when no route matches a webhook and there is no default chat.
+var ErrNoRoute = errors.New("no route matches the webhook")
+
+// WebhookRouter returns the chats a webhook sent without a chat ID goes to.
+type WebhookRouter func(webhook.Message) ([]int64, error)
+
+// HandleRoutedWebhookWithLimits handles webhooks sent to /webhooks/telegram without a chat ID, for Alertmanagers
+// that can't put it into the URL. The router picks the chats, every one of them gets the webhook.
+// Webhooks no route matches are rejected as unprocessable, failing routers make Alertmanager retry.
+func HandleRoutedWebhookWithLimits(logger log.Logger, counter prometheus.Counter, webhooks chan<- TelegramWebhook, limits WebhookLimits, route WebhookRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Body == nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		w.Write([]byte("before chan"))
-		level.Info(logger).Log(
-			"msg", "received webhook",
-			"alerts", len(message.Alerts),
-			"chat_id", chatID,
-		)
+		defer r.Body.Close()
 
-		webhooks <- TelegramWebhook{ChatID: chatID, Message: message}
+		message, raw, ok := readWebhook(logger, w, r, limits)
+		if !ok {
+			return
+		}
+
+		chatIDs, err := route(message)
+		if errors.Is(err, ErrNoRoute) {
+			level.Warn(logger).Log("msg", "no route matches webhook", "receiver", receiverOf(message))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error":"no route matches the webhook"}`))
+			return
+		}
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to route webhook", "receiver", receiverOf(message), "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		level.Debug(logger).Log("msg", "routed webhook", "receiver", receiverOf(message), "chats", len(chatIDs))
+
+		for i, chatID := range chatIDs {
+			m := message
+			if i > 0 {
+				m = copyMessage(message)
+			}
+			webhooks <- TelegramWebhook{ChatID: chatID, Message: m, Raw: raw}
+		}
 		counter.Inc()
 	}
 }
+
+// readWebhook decodes the webhook of a request within the limits. If it can't, it answers the request and returns false.
+func readWebhook(logger log.Logger, w http.ResponseWriter, r *http.Request, limits WebhookLimits) (webhook.Message, []byte, bool) {
+	body := io.Reader(r.Body)
+	if limits.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, limits.MaxBytes)
+	}
+	var raw bytes.Buffer
+	message, err := decodeWebhook(io.TeeReader(body, &raw), limits)
+	if err != nil {
+		level.Warn(logger).Log(
+			"msg", "failed to decode webhook message",
+			"err", err,
+		)
+		if err.Error() == errBodyTooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return message, nil, false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return message, nil, false
+	}
+	level.Info(logger).Log(
+		"msg", "received webhook",
+		"alerts", len(message.Alerts),
+		"truncated_alerts", message.TruncatedAlerts,
+	)
+	return message, bytes.TrimSpace(raw.Bytes()), true
+}
+
+func receiverOf(message webhook.Message) string {
+	if message.Data == nil {
+		return ""
+	}
+	return message.Receiver
+}
+
+// copyMessage copies the alerts of a webhook going to several chats, so that no chat sees another one's changes.
+func copyMessage(message webhook.Message) webhook.Message {
+	if message.Data == nil {
+		return message
+	}
+	data := *message.Data
+	data.Alerts = make(template.Alerts, len(message.Alerts))
+	for i, a := range message.Alerts {
+		a.Labels = copyKV(a.Labels)
+		a.Annotations = copyKV(a.Annotations)
+		data.Alerts[i] = a
+	}
+	data.GroupLabels = copyKV(data.GroupLabels)
+	data.CommonLabels = copyKV(data.CommonLabels)
+	data.CommonAnnotations = copyKV(data.CommonAnnotations)
+	message.Data = &data
+	return message
+}
+
+func copyKV(kv template.KV) template.KV {
+	if kv == nil {
+		return nil
+	}
+	c := make(template.KV, len(kv))
+	for k, v := range kv {
+		c[k] = v
+	}
+	return c
+}
@@ -6,12 +6,14 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -89,7 +91,7 @@ func TestHandleWebhook(t *testing.T) {
 					}
 
 					webhook := <-webhooks
-					if !assert.Equal(t, TelegramWebhook{ChatID: 123, Message: expected}, webhook) {
+					if !assert.Equal(t, TelegramWebhook{ChatID: 123, Message: expected, Raw: bytes.TrimSpace([]byte(validWebhook))}, webhook) {
 						return errors.New("")
 					}
 					return nil
@@ -112,7 +114,7 @@ func TestHandleWebhook(t *testing.T) {
 					}
 
 					webhook := <-webhooks
-					if !assert.Equal(t, TelegramWebhook{ChatID: -1234, Message: expected}, webhook) {
+					if !assert.Equal(t, TelegramWebhook{ChatID: -1234, Message: expected, Raw: bytes.TrimSpace([]byte(validWebhook))}, webhook) {
 						return errors.New("")
 					}
 					return nil
@@ -134,3 +136,205 @@ func TestHandleWebhook(t *testing.T) {
 		})
 	}
 }
+
+// alertsBody streams a webhook with n copies of an alert, without holding it in memory.
+type alertsBody struct {
+	n    int
+	sent int
+	part []byte
+	done bool
+}
+
+const streamedAlert = `{"status":"firing","labels":{"alertname":"Fire","severity":"critical"},"annotations":{"message":"Something is on fire"},"startsAt":"2018-11-04T22:43:58.283995108+01:00","endsAt":"0001-01-01T00:00:00Z","generatorURL":"http://localhost:9090"}`
+
+var (
+	streamedHead  = []byte(`{"receiver":"telegram","status":"firing","alerts":[`)
+	streamedFirst = []byte(streamedAlert)
+	streamedNext  = []byte("," + streamedAlert)
+	streamedTail  = []byte(`],"version":"4"}`)
+)
+
+func newAlertsBody(n int) *alertsBody {
+	return &alertsBody{n: n, part: streamedHead}
+}
+
+func (a *alertsBody) Read(p []byte) (int, error) {
+	var read int
+	for read < len(p) {
+		if len(a.part) == 0 {
+			if a.done {
+				break
+			}
+			switch {
+			case a.n == 0:
+				a.part = streamedTail
+				a.done = true
+			case a.sent == 0:
+				a.part = streamedFirst
+			default:
+				a.part = streamedNext
+			}
+			if a.n > 0 {
+				a.n--
+				a.sent++
+			}
+		}
+		n := copy(p[read:], a.part)
+		a.part = a.part[n:]
+		read += n
+	}
+	if read == 0 {
+		return 0, io.EOF
+	}
+	return read, nil
+}
+
+func TestHandleWebhookLimits(t *testing.T) {
+	limits := WebhookLimits{MaxBytes: 1 << 20, MaxAlerts: 2, MaxDepth: 8}
+
+	testcases := []struct {
+		name string
+		body io.Reader
+		code int
+	}{
+		{name: "Array", body: bytes.NewBufferString(`[{}]`), code: http.StatusBadRequest},
+		{name: "String", body: bytes.NewBufferString(` "alerts"`), code: http.StatusBadRequest},
+		{name: "TooDeep", body: bytes.NewBufferString(`{"commonLabels":` + strings.Repeat(`[`, 10) + strings.Repeat(`]`, 10) + `}`), code: http.StatusBadRequest},
+		{name: "BracketsInStrings", body: bytes.NewBufferString(`{"receiver":"` + strings.Repeat(`[{`, 10) + `\"","alerts":[]}`), code: http.StatusOK},
+		{name: "UnknownField", body: bytes.NewBufferString(`{"receiver":"telegram","unknown":1}`), code: http.StatusBadRequest},
+		{name: "TooLarge", body: newAlertsBody(100000), code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			webhooks := make(chan TelegramWebhook, 1)
+			h := HandleTelegramWebhookWithLimits(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), webhooks, limits)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", tc.body))
+			assert.Equal(t, tc.code, rec.Code)
+		})
+	}
+
+	t.Run("AllowUnknownFields", func(t *testing.T) {
+		webhooks := make(chan TelegramWebhook, 1)
+		relaxed := limits
+		relaxed.AllowUnknownFields = true
+		h := HandleTelegramWebhookWithLimits(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), webhooks, relaxed)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", bytes.NewBufferString(`{"receiver":"telegram","unknown":1}`)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "telegram", (<-webhooks).Message.Receiver)
+	})
+
+	t.Run("MaxAlerts", func(t *testing.T) {
+		webhooks := make(chan TelegramWebhook, 1)
+		h := HandleTelegramWebhookWithLimits(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), webhooks, limits)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", newAlertsBody(5)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		message := (<-webhooks).Message
+		assert.Len(t, message.Alerts, 2)
+		assert.Equal(t, uint64(3), message.TruncatedAlerts)
+	})
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// TestHandleWebhookBoundedMemory sends a 20MB body, which must be rejected without being read past the limit.
+func TestHandleWebhookBoundedMemory(t *testing.T) {
+	body := &countingReader{r: newAlertsBody(20 << 20 / len(streamedAlert))}
+	limits := WebhookLimits{MaxBytes: 1 << 20}
+	webhooks := make(chan TelegramWebhook, 1)
+	h := HandleTelegramWebhookWithLimits(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), webhooks, limits)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", body))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.LessOrEqual(t, body.read, limits.MaxBytes+1, "the body isn't read past the limit")
+	assert.Empty(t, webhooks)
+}
+
+func TestHandleRoutedWebhook(t *testing.T) {
+	route := func(m webhook.Message) ([]int64, error) {
+		switch m.Receiver {
+		case "telegram":
+			return []int64{123, -1234}, nil
+		case "broken":
+			return nil, errors.New("store unavailable")
+		}
+		return nil, ErrNoRoute
+	}
+	serve := func(body string) (*httptest.ResponseRecorder, []TelegramWebhook, float64) {
+		webhooks := make(chan TelegramWebhook, 2)
+		counter := prometheus.NewCounter(prometheus.CounterOpts{})
+		h := HandleRoutedWebhookWithLimits(log.NewNopLogger(), counter, webhooks, DefaultWebhookLimits, route)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram", bytes.NewBufferString(body)))
+		close(webhooks)
+		var received []TelegramWebhook
+		for w := range webhooks {
+			received = append(received, w)
+		}
+		return rec, received, testutil.ToFloat64(counter)
+	}
+
+	rec, received, count := serve(validWebhook)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1.0, count, "a webhook is counted once however many chats it goes to")
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, int64(123), received[0].ChatID)
+		assert.Equal(t, int64(-1234), received[1].ChatID)
+		assert.Equal(t, received[0].Message, received[1].Message)
+		assert.Equal(t, bytes.TrimSpace([]byte(validWebhook)), received[1].Raw)
+
+		received[1].Message.Alerts[0].Labels["severity"] = "changed"
+		assert.Equal(t, "critical", received[0].Message.Alerts[0].Labels["severity"], "every chat gets its own copy of the alerts")
+	}
+
+	rec, received, count = serve(strings.Replace(validWebhook, `"receiver":"telegram"`, `"receiver":"other"`, 1))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, `{"error":"no route matches the webhook"}`, rec.Body.String())
+	assert.Empty(t, received)
+	assert.Equal(t, 0.0, count)
+
+	rec, received, _ = serve(strings.Replace(validWebhook, `"receiver":"telegram"`, `"receiver":"broken"`, 1))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Empty(t, received)
+
+	rec, _, _ = serve(`[]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func BenchmarkHandleWebhook(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		body func() io.Reader
+	}{
+		{name: "Valid", body: func() io.Reader { return bytes.NewBufferString(validWebhook) }},
+		{name: "TooLarge20MB", body: func() io.Reader { return newAlertsBody(20 << 20 / len(streamedAlert)) }},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			webhooks := make(chan TelegramWebhook, 1)
+			h := HandleTelegramWebhook(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{}), webhooks)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", bm.body()))
+				select {
+				case <-webhooks:
+				default:
+				}
+			}
+		})
+	}
+}
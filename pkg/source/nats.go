@@ -0,0 +1,257 @@
+// Package source receives webhooks for the bot from elsewhere than Alertmanager's HTTP requests.
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/nats-io/nats.go"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+const (
+	defaultReconnectMin = 100 * time.Millisecond
+	defaultReconnectMax = 30 * time.Second
+	// The nak backoff stays below JetStream's default ack wait of 30s, after which the server redelivers anyway.
+	defaultNakMin = time.Second
+	defaultNakMax = 15 * time.Second
+)
+
+// NATS receives webhooks from a subject of a NATS JetStream stream, every message a TelegramWebhook as JSON.
+// A message is acked once the bot delivered its webhook, and nacked to be redelivered if it couldn't,
+// after a backoff growing with the number of times it was delivered.
+type NATS struct {
+	url        string
+	subject    string
+	queue      string
+	maxDeliver int
+	backoffMin time.Duration
+	backoffMax time.Duration
+	nakMin     time.Duration
+	nakMax     time.Duration
+	options    []nats.Option
+	logger     log.Logger
+}
+
+// NATSOption changes the default NATS source.
+type NATSOption func(n *NATS)
+
+// WithQueueGroup splits the webhooks between the bots of the group, which share a durable consumer named like the group.
+func WithQueueGroup(group string) NATSOption {
+	return func(n *NATS) {
+		n.queue = group
+	}
+}
+
+// WithCredentials authenticates with a NATS credentials file.
+func WithCredentials(file string) NATSOption {
+	return func(n *NATS) {
+		n.options = append(n.options, nats.UserCredentials(file))
+	}
+}
+
+// WithUserInfo authenticates with a user and password.
+func WithUserInfo(user string, password string) NATSOption {
+	return func(n *NATS) {
+		n.options = append(n.options, nats.UserInfo(user, password))
+	}
+}
+
+// WithMaxDeliver gives up on a webhook after it was delivered n times, 0 never does.
+func WithMaxDeliver(n int) NATSOption {
+	return func(s *NATS) {
+		s.maxDeliver = n
+	}
+}
+
+// WithNakBackoff waits min before nacking a webhook the bot failed to deliver the first time, doubling with
+// every delivery up to max. The client has no NakWithDelay, so the nak itself is delayed: max should stay below
+// the consumer's ack wait, after which the server redelivers the webhook anyway.
+func WithNakBackoff(min time.Duration, max time.Duration) NATSOption {
+	return func(n *NATS) {
+		n.nakMin = min
+		n.nakMax = max
+	}
+}
+
+// WithReconnectBackoff waits min before reconnecting the first time, doubling with every attempt up to max.
+func WithReconnectBackoff(min time.Duration, max time.Duration) NATSOption {
+	return func(n *NATS) {
+		n.backoffMin = min
+		n.backoffMax = max
+	}
+}
+
+// WithLogger sets the logger of the NATS source.
+func WithLogger(l log.Logger) NATSOption {
+	return func(n *NATS) {
+		n.logger = l
+	}
+}
+
+// NewNATS returns a source of the webhooks published to the subject of the NATS server at url.
+func NewNATS(url string, subject string, opts ...NATSOption) *NATS {
+	n := &NATS{
+		url:        url,
+		subject:    subject,
+		backoffMin: defaultReconnectMin,
+		backoffMax: defaultReconnectMax,
+		nakMin:     defaultNakMin,
+		nakMax:     defaultNakMax,
+		logger:     log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// backoffDelay is the wait before the given attempt, doubling from min up to max.
+func backoffDelay(attempts int, min time.Duration, max time.Duration) time.Duration {
+	delay := min
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Receive connects to NATS and subscribes to the subject until ctx is done, reconnecting meanwhile if needed.
+// Webhooks still on their way to the bot when ctx is done are nacked, then the connection is drained and closed.
+func (n *NATS) Receive(ctx context.Context) (<-chan alertmanager.TelegramWebhook, error) {
+	options := append([]nats.Option{
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return backoffDelay(attempts, n.backoffMin, n.backoffMax)
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				level.Warn(n.logger).Log("msg", "disconnected from NATS", "err", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			level.Info(n.logger).Log("msg", "reconnected to NATS", "url", c.ConnectedUrl())
+		}),
+	}, n.options...)
+	nc, err := nats.Connect(n.url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	webhooks := make(chan alertmanager.TelegramWebhook)
+	naks := &delayedNaks{pending: map[*time.Timer]func(){}}
+	handler := func(m *nats.Msg) {
+		var w alertmanager.TelegramWebhook
+		if err := json.Unmarshal(m.Data, &w); err != nil {
+			level.Warn(n.logger).Log("msg", "dropping malformed webhook", "subject", m.Subject, "err", err)
+			if err := m.Term(); err != nil {
+				level.Warn(n.logger).Log("msg", "failed to terminate malformed webhook", "err", err)
+			}
+			return
+		}
+		w.Raw = m.Data
+		w.Ack = func(err error) {
+			ack := func() {
+				if err := m.Ack(); err != nil {
+					level.Warn(n.logger).Log("msg", "failed to ack webhook", "chat_id", w.ChatID, "err", err)
+				}
+			}
+			if err == nil {
+				ack()
+				return
+			}
+			nak := func() {
+				if err := m.Nak(); err != nil {
+					level.Warn(n.logger).Log("msg", "failed to nak webhook", "chat_id", w.ChatID, "err", err)
+				}
+			}
+			if ctx.Err() != nil {
+				// Stopping, another bot can take the webhook right away.
+				nak()
+				return
+			}
+			delivered := 1
+			if md, err := m.Metadata(); err == nil {
+				delivered = int(md.NumDelivered)
+			}
+			naks.after(backoffDelay(delivered, n.nakMin, n.nakMax), nak)
+		}
+		select {
+		case webhooks <- w:
+		case <-ctx.Done():
+			w.Ack(ctx.Err())
+		}
+	}
+
+	subOpts := []nats.SubOpt{nats.ManualAck(), nats.AckExplicit()}
+	if n.maxDeliver > 0 {
+		subOpts = append(subOpts, nats.MaxDeliver(n.maxDeliver))
+	}
+	if n.queue != "" {
+		_, err = js.QueueSubscribe(n.subject, n.queue, handler, append(subOpts, nats.Durable(n.queue))...)
+	} else {
+		_, err = js.Subscribe(n.subject, handler, subOpts...)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", n.subject, err)
+	}
+	level.Info(n.logger).Log("msg", "receiving webhooks from NATS", "subject", n.subject, "queue", n.queue)
+
+	go func() {
+		<-ctx.Done()
+		naks.flush()
+		if err := nc.Drain(); err != nil {
+			level.Warn(n.logger).Log("msg", "failed to drain NATS connection", "err", err)
+			nc.Close()
+		}
+	}()
+	return webhooks, nil
+}
+
+// delayedNaks naks webhooks after their backoff, or all at once when the source stops.
+type delayedNaks struct {
+	mu      sync.Mutex
+	pending map[*time.Timer]func()
+}
+
+// after naks with nak once d passed.
+func (d *delayedNaks) after(delay time.Duration, nak func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		_, ok := d.pending[t]
+		delete(d.pending, t)
+		d.mu.Unlock()
+		if ok {
+			nak()
+		}
+	})
+	d.pending[t] = nak
+}
+
+// flush naks the pending webhooks right away.
+func (d *delayedNaks) flush() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = map[*time.Timer]func(){}
+	d.mu.Unlock()
+	for t, nak := range pending {
+		t.Stop()
+		nak()
+	}
+}
@@ -0,0 +1,22 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		0:  100 * time.Millisecond,
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		9:  25600 * time.Millisecond,
+		10: 30 * time.Second,
+		99: 30 * time.Second,
+	} {
+		require.Equal(t, expected, backoffDelay(attempts, defaultReconnectMin, defaultReconnectMax), "attempt %d", attempts)
+	}
+}
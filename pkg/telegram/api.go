@@ -0,0 +1,214 @@
+package telegram
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// APIPrefix is where APIHandler serves the bot's JSON API.
+const APIPrefix = "/api/v1/"
+
+// APIChat is a subscribed chat as the JSON API shows it, without what's only the bot's business
+// like the escalated alerts and the escalation contact.
+// It's kept apart from ChatInfo so that the store can change without breaking the API.
+type APIChat struct {
+	ID                int64    `json:"id"`
+	Type              string   `json:"type"`
+	Title             string   `json:"title,omitempty"`
+	Username          string   `json:"username,omitempty"`
+	Environments      []string `json:"environments"`
+	Projects          []string `json:"projects"`
+	MutedEnvironments []string `json:"muted_environments"`
+	MutedProjects     []string `json:"muted_projects"`
+	MinSeverity       string   `json:"min_severity,omitempty"`
+	Unreachable       bool     `json:"unreachable"`
+	FailedSends       int      `json:"failed_sends"`
+	FallbackChatID    int64    `json:"fallback_chat_id,omitempty"`
+	Protected         bool     `json:"protected"`
+}
+
+// APIStats is how the bot's deliveries are going, as the JSON API shows it.
+type APIStats struct {
+	Chats int `json:"chats"`
+	// Delivered and Failed count the notifications sent since the bot started.
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`
+	// QueuedWebhooks wait for a worker, QueuedChats are the chats with webhooks queued or worked on.
+	QueuedWebhooks int `json:"queued_webhooks"`
+	QueuedChats    int `json:"queued_chats"`
+	// LastWebhook is when the last webhook came in, null if none did since the bot started.
+	LastWebhook *time.Time `json:"last_webhook"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// deliveryStats counts the deliveries and remembers the last webhook for the JSON API.
+type deliveryStats struct {
+	mu          sync.Mutex
+	delivered   int64
+	failed      int64
+	lastWebhook time.Time
+}
+
+func (s *deliveryStats) delivery(delivered bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if delivered {
+		s.delivered++
+	} else {
+		s.failed++
+	}
+}
+
+func (s *deliveryStats) webhook(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWebhook = at
+}
+
+func newAPIChat(ci ChatInfo) APIChat {
+	nonNil := func(l []string) []string {
+		if l == nil {
+			return []string{}
+		}
+		return l
+	}
+	return APIChat{
+		ID:                ci.Chat.ID,
+		Type:              string(ci.Chat.Type),
+		Title:             ci.Chat.Title,
+		Username:          ci.Chat.Username,
+		Environments:      nonNil(ci.AlertEnvironments),
+		Projects:          nonNil(ci.AlertProjects),
+		MutedEnvironments: nonNil(ci.MutedEnvironments),
+		MutedProjects:     nonNil(ci.MutedProjects),
+		MinSeverity:       ci.MinSeverity,
+		Unreachable:       ci.Unreachable,
+		FailedSends:       ci.FailedSends,
+		FallbackChatID:    ci.FallbackChatID,
+		Protected:         ci.Protected,
+	}
+}
+
+// BearerAuth lets only requests with the token as bearer token through, or every request if the token is empty.
+func BearerAuth(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The scheme is case-insensitive, the token isn't; a bare token isn't taken for one.
+		auth := r.Header.Get("Authorization")
+		const scheme = "Bearer "
+		if len(auth) < len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) ||
+			subtle.ConstantTimeCompare([]byte(auth[len(scheme):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIHandler serves the bot's JSON API under APIPrefix: the subscribed chats at chats, a single one at chats/<id>
+// and how deliveries are going at stats. Alerts posted to chats/<id>/evaluate are run through the chat's filters
+// and routes, the Decision tells what would happen to them. Nothing is changed or sent.
+func (b *Bot) APIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+		if strings.HasPrefix(path, "chats/") && strings.HasSuffix(path, "/evaluate") {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", "POST")
+				b.writeAPI(w, http.StatusMethodNotAllowed, apiError{Error: "only POST is allowed"})
+				return
+			}
+			b.apiEvaluate(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "chats/"), "/evaluate"))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			b.writeAPI(w, http.StatusMethodNotAllowed, apiError{Error: "only GET is allowed"})
+			return
+		}
+		switch {
+		case path == "chats":
+			b.apiChats(w)
+		case strings.HasPrefix(path, "chats/"):
+			b.apiChat(w, strings.TrimPrefix(path, "chats/"))
+		case path == "stats":
+			b.apiStats(w)
+		default:
+			b.writeAPI(w, http.StatusNotFound, apiError{Error: "not found"})
+		}
+	})
+}
+
+func (b *Bot) apiChats(w http.ResponseWriter) {
+	chats, err := b.chats.List()
+	if err != nil && !errors.Is(err, ErrChatNotFound) {
+		level.Warn(b.logger).Log("msg", "failed to list chats for the API", "err", err)
+		b.writeAPI(w, http.StatusInternalServerError, apiError{Error: "failed to list chats"})
+		return
+	}
+	out := make([]APIChat, 0, len(chats))
+	for _, ci := range chats {
+		out = append(out, newAPIChat(ci))
+	}
+	b.writeAPI(w, http.StatusOK, out)
+}
+
+func (b *Bot) apiChat(w http.ResponseWriter, rawID string) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		b.writeAPI(w, http.StatusNotFound, apiError{Error: "chat not found"})
+		return
+	}
+	ci, err := b.chats.GetChatInfo(id)
+	if errors.Is(err, ErrChatNotFound) {
+		b.writeAPI(w, http.StatusNotFound, apiError{Error: "chat not found"})
+		return
+	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat for the API", "chat_id", id, "err", err)
+		b.writeAPI(w, http.StatusInternalServerError, apiError{Error: "failed to get the chat"})
+		return
+	}
+	b.writeAPI(w, http.StatusOK, newAPIChat(*ci))
+}
+
+func (b *Bot) apiStats(w http.ResponseWriter) {
+	chats, err := b.chats.List()
+	if err != nil && !errors.Is(err, ErrChatNotFound) {
+		level.Warn(b.logger).Log("msg", "failed to list chats for the API", "err", err)
+		b.writeAPI(w, http.StatusInternalServerError, apiError{Error: "failed to list chats"})
+		return
+	}
+
+	s := b.deliveryStats
+	s.mu.Lock()
+	stats := APIStats{Chats: len(chats), Delivered: s.delivered, Failed: s.failed}
+	if !s.lastWebhook.IsZero() {
+		last := s.lastWebhook.UTC()
+		stats.LastWebhook = &last
+	}
+	s.mu.Unlock()
+	stats.QueuedWebhooks, stats.QueuedChats = b.webhookQueues.depth()
+	b.writeAPI(w, http.StatusOK, stats)
+}
+
+func (b *Bot) writeAPI(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to write API response", "err", err)
+	}
+}
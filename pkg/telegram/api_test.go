@@ -0,0 +1,114 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getAPI requests the path from the handler and decodes the JSON response into v.
+func getAPI(t *testing.T, h http.Handler, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	return rec.Code
+}
+
+func TestAPIChats(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	h := b.APIHandler()
+
+	var list []APIChat
+	require.Equal(t, http.StatusOK, getAPI(t, h, "/api/v1/chats", &list))
+	require.Empty(t, list)
+	require.NotNil(t, list, "no chats are an empty list")
+
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(testChat, []string{"staging"}, b.environmentsAndOther))
+	require.NoError(t, chats.SetEscalationContact(testChat, "@oncall_person"))
+
+	require.Equal(t, http.StatusOK, getAPI(t, h, "/api/v1/chats", &list))
+	require.Len(t, list, 1)
+	require.Equal(t, int64(123), list[0].ID)
+	require.Equal(t, []string{"staging"}, list[0].MutedEnvironments)
+	require.Empty(t, list[0].MutedProjects)
+
+	var chat map[string]interface{}
+	require.Equal(t, http.StatusOK, getAPI(t, h, "/api/v1/chats/123", &chat))
+	require.Equal(t, "elliot", chat["username"])
+	require.NotContains(t, chat, "escalation_contact")
+
+	var apiErr apiError
+	require.Equal(t, http.StatusNotFound, getAPI(t, h, "/api/v1/chats/456", &apiErr))
+	require.Equal(t, "chat not found", apiErr.Error)
+	require.Equal(t, http.StatusNotFound, getAPI(t, h, "/api/v1/chats/elliot", &apiErr))
+	require.Equal(t, http.StatusNotFound, getAPI(t, h, "/api/v1/silences", &apiErr))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/chats", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAPIStoreFailure(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	b, err := NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID)
+	require.NoError(t, err)
+	kv.err = errors.New("connection refused")
+
+	var apiErr apiError
+	require.Equal(t, http.StatusInternalServerError, getAPI(t, b.APIHandler(), "/api/v1/chats", &apiErr))
+	require.Equal(t, "failed to list chats", apiErr.Error, "store errors aren't shown")
+}
+
+func TestAPIStats(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	var stats APIStats
+	require.Equal(t, http.StatusOK, getAPI(t, b.APIHandler(), "/api/v1/stats", &stats))
+	require.Equal(t, APIStats{Chats: 1}, stats)
+
+	now := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	b.deliveryStats.webhook(now)
+	b.deliveryStats.delivery(true)
+	b.deliveryStats.delivery(false)
+	b.webhookQueues.push(outboxWebhook("HighCPU"))
+	require.Equal(t, http.StatusOK, getAPI(t, b.APIHandler(), "/api/v1/stats", &stats))
+	require.Equal(t, int64(1), stats.Delivered)
+	require.Equal(t, int64(1), stats.Failed)
+	require.Equal(t, 1, stats.QueuedWebhooks)
+	require.Equal(t, 1, stats.QueuedChats)
+	require.True(t, now.Equal(*stats.LastWebhook))
+}
+
+func TestBearerAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(h http.Handler, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, request(BearerAuth("", ok), ""))
+	h := BearerAuth("s3cret", ok)
+	require.Equal(t, http.StatusUnauthorized, request(h, ""))
+	require.Equal(t, http.StatusUnauthorized, request(h, "Bearer wrong"))
+	require.Equal(t, http.StatusOK, request(h, "Bearer s3cret"))
+	require.Equal(t, http.StatusOK, request(h, "bearer s3cret"), "the scheme is case-insensitive")
+	require.Equal(t, http.StatusUnauthorized, request(h, "s3cret"), "a bare token isn't enough")
+	require.Equal(t, http.StatusUnauthorized, request(h, "Basic s3cret"))
+	require.Equal(t, http.StatusUnauthorized, request(h, "Bearer S3CRET"))
+}
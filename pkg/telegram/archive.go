@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandArchiveTo = "/archive_to"
+
+	archiveResultArchived = "archived"
+	archiveResultFailed   = "failed"
+
+	responseArchiveUsage = "Usage: " + CommandArchiveTo + " <chat_id> to keep a copy of this chat's alert messages there " +
+		"before they're deleted or once their alerts resolved, " + CommandArchiveTo + " off to stop."
+)
+
+// SetArchiveChat sets the chat keeping copies of the alert messages of c, 0 removes it.
+func (s *ChatStore) SetArchiveChat(c *telebot.Chat, archiveID int64) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.ArchiveChatID = archiveID
+		return nil
+	})
+}
+
+// archiveHeader is put above the copy of a message of the chat in its archive chat.
+func archiveHeader(ci *ChatInfo, r *MessageRecord) string {
+	header := fmt.Sprintf("🗄 %s, %s", chatName(ci.Chat), r.SentAt.In(chatLocation(ci)).Format("2006-01-02 15:04 MST"))
+	switch r.ParseMode {
+	case telebot.ModeHTML:
+		header = html.EscapeString(header)
+	case telebot.ModeMarkdownV2:
+		header = markdownV2Escaper.Replace(header)
+	}
+	return header + "\n\n"
+}
+
+// archiveMessage copies the tracked message to the chat's archive chat, headed by the chat it was sent to and when.
+// Messages whose text wasn't kept are forwarded, Telegram shows where they're from then. It marks the record
+// archived and tells if archiving worked, a failure is logged and counted but mustn't keep the message around.
+func (b *Bot) archiveMessage(logger log.Logger, ci *ChatInfo, r *MessageRecord) bool {
+	archive := &telebot.Chat{ID: ci.ArchiveChatID}
+	var err error
+	if r.Text != "" {
+		text := archiveHeader(ci, r) + r.Text
+		_, err = b.send(archive, b.truncateMessage(text), "archive", &telebot.SendOptions{ParseMode: r.ParseMode})
+	} else {
+		var forwarded *telebot.Message
+		forwarded, err = b.telegram.Forward(archive, telebot.StoredMessage{MessageID: strconv.Itoa(r.MessageID), ChatID: r.ChatID})
+		if err == nil {
+			b.recordSent(forwarded, nil, "archive")
+		}
+	}
+	if err != nil {
+		b.messageArchives.WithLabelValues(archiveResultFailed).Inc()
+		level.Warn(logger).Log("msg", "failed to archive message", "archive_chat_id", archive.ID, "message_id", r.MessageID, "err", err)
+		return false
+	}
+	b.messageArchives.WithLabelValues(archiveResultArchived).Inc()
+	r.Archived = true
+	return true
+}
+
+// archiveChats returns the chats that have an archive chat, by ID.
+func (b *Bot) archiveChats() (map[int64]*ChatInfo, error) {
+	chats, err := b.chats.List()
+	if err != nil {
+		return nil, err
+	}
+	archiving := map[int64]*ChatInfo{}
+	for i := range chats {
+		if chats[i].ArchiveChatID != 0 {
+			archiving[chats[i].Chat.ID] = &chats[i]
+		}
+	}
+	return archiving, nil
+}
+
+func (b *Bot) handleArchiveTo(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "get the archive chat")
+			return err
+		}
+		if ci.ArchiveChatID == 0 {
+			_, err = b.reply(message, b.responseText("archive_none", b.localResponseContext(message))+"\n"+b.responseText("archive_usage", b.localResponseContext(message)))
+			return err
+		}
+		_, err = b.reply(message, fmt.Sprintf("Alert messages of this chat are archived to chat %d.", ci.ArchiveChatID))
+		return err
+	}
+
+	var archiveID int64
+	if payload != "off" {
+		id, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			_, err = b.respond(message, "archive_usage", responseContext(message))
+			return err
+		}
+		if id == message.Chat.ID {
+			_, err = b.respond(message, "archive_self", responseContext(message))
+			return err
+		}
+		if me := b.telegram.Me(); me != nil && id == int64(me.ID) {
+			_, err = b.respond(message, "archive_bot", responseContext(message))
+			return err
+		}
+		if _, err := b.chats.GetChatInfo(id); err != nil {
+			if errors.Is(err, ErrChatNotFound) {
+				_, err = b.reply(message, fmt.Sprintf("Chat %d didn't subscribe, send %s there first.", id, CommandStart))
+				return err
+			}
+			_, err = b.replyStoreError(message, err, "check the archive chat")
+			return err
+		}
+		archiveID = id
+	}
+
+	if err := b.chats.SetArchiveChat(message.Chat, archiveID); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set archive chat", "err", err)
+		_, err = b.replyStoreError(message, err, "set the archive chat")
+		return err
+	}
+
+	if archiveID == 0 {
+		_, err := b.respond(message, "archive_off", responseContext(message))
+		return err
+	}
+	_, err := b.reply(message, fmt.Sprintf("Alert messages of this chat are copied to chat %d before they're deleted or once their alerts resolved.", archiveID))
+	return err
+}
@@ -0,0 +1,138 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestHandleArchiveTo(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+
+	archiveTo := func(payload string) string {
+		require.NoError(t, b.handleArchiveTo(&telebot.Message{Chat: payments, Sender: testAdmin, Text: CommandArchiveTo + " " + payload, Payload: payload}))
+		return tb.lastText()
+	}
+
+	require.Equal(t, "Chat -200 didn't subscribe, send /start there first.", archiveTo("-200"))
+	require.Equal(t, "A chat can't be its own archive chat.", archiveTo("-100"))
+	require.Equal(t, responseArchiveUsage, archiveTo("archive"))
+
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.Equal(t, "Alert messages of this chat are copied to chat -200 before they're deleted or once their alerts resolved.", archiveTo("-200"))
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Equal(t, oncall.ID, ci.ArchiveChatID)
+	require.Equal(t, "Alert messages of this chat are archived to chat -200.", archiveTo(""))
+
+	require.Equal(t, "Alert messages of this chat aren't archived anymore.", archiveTo("off"))
+	ci, err = chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Zero(t, ci.ArchiveChatID)
+}
+
+// archivingBot returns a bot deleting messages after an hour, with payments archiving to oncall.
+func archivingBot(t *testing.T, opts ...BotOption) (*Bot, *fakeTelebot, *ChatStore) {
+	t.Helper()
+	b, tb, chats := newTestBot(t, append([]BotOption{WithDeletePeriod(3600)}, opts...)...)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetArchiveChat(payments, oncall.ID))
+	return b, tb, chats
+}
+
+func TestArchiveBeforeDelete(t *testing.T) {
+	b, tb, chats := archivingBot(t)
+	now := time.Now()
+	sentAt := time.Date(2021, 3, 1, 14, 5, 0, 0, time.UTC)
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: payments.ID, MessageID: 1, SentAt: sentAt, Text: "<b>HighCPU</b>", ParseMode: telebot.ModeHTML}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: payments.ID, MessageID: 2, SentAt: sentAt}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 3, SentAt: sentAt}))
+
+	// Every deletion notes how much was archived by then.
+	archivedAtDelete := map[string]int{}
+	tb.deleteErr = func(msg telebot.Editable) error {
+		id, _ := msg.MessageSig()
+		archivedAtDelete[id] = len(tb.sent) + len(tb.forwarded)
+		return nil
+	}
+
+	b.cleanupMessages(now)
+	require.Len(t, tb.deleted, 3)
+	require.Equal(t, map[string]int{"1": 1, "2": 2, "3": 2}, archivedAtDelete, "messages are archived before they're deleted")
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "-200", msgs[0].to)
+	require.Equal(t, "🗄 payments-oncall (-100), 2021-03-01 14:05 UTC\n\n<b>HighCPU</b>", msgs[0].text())
+	require.Len(t, tb.forwarded, 1, "messages without their text are forwarded")
+	require.Equal(t, "-200", tb.forwarded[0].to)
+	messageID, chatID := tb.forwarded[0].what.(telebot.Editable).MessageSig()
+	require.Equal(t, "2", messageID)
+	require.Equal(t, payments.ID, chatID)
+}
+
+func TestArchiveFailureDoesntBlockDelete(t *testing.T) {
+	b, tb, chats := archivingBot(t)
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: payments.ID, MessageID: 1, SentAt: time.Now().Add(-2 * time.Hour), Text: "HighCPU"}))
+	tb.setUnreachable("-200", true)
+	// The metric is global, so only its change is compared.
+	before := testutil.ToFloat64(b.messageArchives.WithLabelValues(archiveResultFailed))
+
+	b.cleanupMessages(time.Now())
+	require.Len(t, tb.deleted, 1)
+	require.Empty(t, tb.messages())
+	require.Equal(t, before+1, testutil.ToFloat64(b.messageArchives.WithLabelValues(archiveResultFailed)))
+	_, err := chats.GetMessage(payments.ID, 1)
+	require.ErrorIs(t, err, ErrMessageNotFound)
+}
+
+func TestArchiveRetriedDeleteOnce(t *testing.T) {
+	b, tb, chats := archivingBot(t)
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: payments.ID, MessageID: 1, SentAt: time.Now().Add(-2 * time.Hour), Text: "HighCPU"}))
+	tb.deleteErr = func(telebot.Editable) error { return telebot.ErrInternal }
+
+	b.cleanupMessages(time.Now())
+	r, err := chats.GetMessage(payments.ID, 1)
+	require.NoError(t, err)
+	require.True(t, r.Archived)
+
+	tb.deleteErr = nil
+	b.cleanupMessages(time.Now().Add(time.Hour))
+	require.Len(t, tb.deleted, 1)
+	require.Len(t, tb.messages(), 1, "the message isn't archived again when its deletion is retried")
+}
+
+func TestArchiveResolved(t *testing.T) {
+	b, tb, chats := archivingBot(t)
+	webhook := func(status string) error {
+		w := mixedWebhook(status, template.Alert{Status: status, Labels: template.KV{"alertname": "HighCPU"}})
+		w.ChatID = payments.ID
+		_, err := b.processWebhook(context.Background(), w)
+		return err
+	}
+
+	require.NoError(t, webhook("firing"))
+	require.Len(t, tb.messages(), 1, "firing alerts aren't archived")
+
+	require.NoError(t, webhook("resolved"))
+	msgs := tb.messages()
+	require.Len(t, msgs, 3)
+	require.Equal(t, "-100", msgs[1].to)
+	require.Equal(t, "-200", msgs[2].to)
+	require.Contains(t, msgs[2].text(), "payments-oncall (-100)")
+	require.Contains(t, msgs[2].text(), msgs[1].text())
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	b.cleanupMessages(time.Now().Add(2 * time.Hour))
+	require.Len(t, tb.deleted, 2)
+	require.Len(t, tb.messages(), 4, "only the firing message is archived when it's deleted")
+}
@@ -0,0 +1,71 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// BlockedSends are the notifications that couldn't be delivered to a private chat because the user blocked the bot.
+type BlockedSends struct {
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+// RecordBlockedSend counts a notification that failed at because the user blocked the bot.
+func (s *ChatStore) RecordBlockedSend(chatID int64, at time.Time) error {
+	return s.UpdateChat(chatID, func(ci *ChatInfo) error {
+		if ci.Blocked == nil {
+			ci.Blocked = &BlockedSends{First: at}
+		}
+		ci.Blocked.Count++
+		ci.Blocked.Last = at
+		return nil
+	})
+}
+
+// ClearBlockedSends forgets the notifications that failed while the user blocked the bot.
+func (s *ChatStore) ClearBlockedSends(chatID int64) error {
+	return s.UpdateChat(chatID, func(ci *ChatInfo) error {
+		ci.Blocked = nil
+		return nil
+	})
+}
+
+// recordBlocked counts a notification to a private chat that failed because the user blocked the bot.
+func (b *Bot) recordBlocked(logger log.Logger, chat *telebot.Chat, err error, at time.Time) {
+	if chat.Type != telebot.ChatPrivate || !errors.Is(err, telebot.ErrBlockedByUser) {
+		return
+	}
+	if err := b.chats.RecordBlockedSend(chat.ID, at); err != nil {
+		level.Warn(logger).Log("msg", "failed to record notification blocked by the user", "err", err)
+	}
+}
+
+// welcomeBack tells a user who unblocked the bot how many notifications they missed, once.
+func (b *Bot) welcomeBack(m *telebot.Message) {
+	if m.Chat == nil || m.Chat.Type != telebot.ChatPrivate {
+		return
+	}
+	ci, err := b.chats.GetChatInfo(m.Chat.ID)
+	if err != nil || ci.Blocked == nil || ci.Blocked.Count == 0 {
+		return
+	}
+	if err := b.chats.ClearBlockedSends(m.Chat.ID); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to clear notifications blocked by the user", "chat_id", m.Chat.ID, "err", err)
+		return
+	}
+
+	const layout = "2006-01-02 15:04 UTC"
+	notice := fmt.Sprintf("While you had me blocked, %d notifications could not be delivered between %s and %s.\n"+
+		"Use %s to see the alerts firing now.",
+		ci.Blocked.Count, ci.Blocked.First.UTC().Format(layout), ci.Blocked.Last.UTC().Format(layout), CommandAlerts)
+	if _, err := b.send(m.Chat, notice, "welcome back"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to tell the user about blocked notifications", "chat_id", m.Chat.ID, "err", err)
+	}
+}
@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestBlockedNotificationsToldOnUnblock(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	// The user blocks the bot, notifications fail.
+	tb.unreachable = map[string]bool{"123": true}
+	before := time.Now()
+	for _, name := range []string{"HighCPU", "DiskFull", "TargetDown"} {
+		_, err := b.processWebhook(context.Background(), outboxWebhook(name))
+		require.NoError(t, err)
+	}
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.NotNil(t, ci.Blocked)
+	require.Equal(t, 3, ci.Blocked.Count)
+	require.False(t, ci.Blocked.First.Before(before))
+	require.False(t, ci.Blocked.Last.Before(ci.Blocked.First))
+
+	// The user unblocks the bot and sends a command.
+	tb.unreachable = nil
+	var handled int
+	handler := b.middleware(func(m *telebot.Message) error {
+		handled++
+		_, err := b.reply(m, "pong")
+		return err
+	})
+	handler(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStatus})
+	require.Equal(t, 1, handled, "the command is still handled")
+	msgs := tb.messages()
+	require.Len(t, msgs, 2)
+	require.Regexp(t, `^While you had me blocked, 3 notifications could not be delivered between \d{4}-\d\d-\d\d \d\d:\d\d UTC and \d{4}-\d\d-\d\d \d\d:\d\d UTC\.`, msgs[0].text())
+	require.Equal(t, "pong", msgs[1].text())
+
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Nil(t, ci.Blocked)
+
+	handler(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStatus})
+	require.Len(t, tb.messages(), 3, "the user is told once")
+}
+
+func TestBlockedOnlyTrackedForPrivateChats(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	tb.unreachable = map[string]bool{"-100": true}
+
+	w := outboxWebhook("HighCPU")
+	w.ChatID = sharedGroup.ID
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	ci, err := chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.Nil(t, ci.Blocked)
+	require.Equal(t, 1, ci.FailedSends)
+}
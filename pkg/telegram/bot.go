@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"github.com/docker/libkv/store"
 	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -18,7 +19,6 @@ import (
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
 	"github.com/prometheus/alertmanager/api/v2/models"
-	"github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/alertmanager/types"
 	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
 	"gopkg.in/tucnak/telebot.v2"
@@ -41,6 +41,7 @@ const (
 	CommandMutedEnvs    = "/muted_envs"
 	CommandMutedPrs     = "/muted_prs"
 
+	// The regexps of mute commands are kept for compatibility, commands are parsed by parseBracketArgs.
 	ProjectAndEnvironmentMuteRegexp   = `/mute environment\[(\w+(\s*,\s*\w+)*)\],[ ]?project\[(\w+(\s*,\s*\w+)*)\]`
 	MuteProjectRegexp                 = `/mute project\[(\w+(\s*,\s*\w+)*)\]`
 	MuteEnvironmentRegexp             = `/mute environment\[(\w+(\s*,\s*\w+)*)\]`
@@ -50,31 +51,76 @@ const (
 	EnvironmentValuesRegexp           = `environment\[(.*?)\]`
 	ProjectValuesRegexp               = `project\[(.*?)\]`
 
-	responseAlertsNotConfigured = "This chat hasn't been setup to receive any alerts yet... 😕\n\n" +
-		"Ask an administrator of the Alertmanager to add a webhook with `/webhooks/telegram/%d` as URL."
-
-	responseStartPrivate          = "Hey, %s! I will now keep you up to date!\n" + CommandHelp
-	responseStartPrivateAnonymous = "Hey! I will now keep you up to date!\n" + CommandHelp
-	responseStartGroup            = "Hey! I will now keep you all up to date!\n" + CommandHelp
-	responseStop                  = "Alright, %s! I won't talk to you again.\n" + CommandHelp
-	ResponseHelp                  = `
+	ResponseHelp = `
 I'm a Prometheus AlertManager Bot for Telegram. I will notify you about alerts.
 You can also ask me about my ` + CommandStatus + `, ` + CommandAlerts + ` & ` + CommandSilences + `
+Admins can search alerts from any chat by typing my @username and words or label=value filters, like HighCPU prod.
 
 Available commands:
 ` + CommandStart + ` - Subscribe for alerts.
 ` + CommandStop + ` - Unsubscribe for alerts.
 ` + CommandStatus + ` - Print the current status.
-` + CommandAlerts + ` - List all alerts.
+` + CommandAlerts + ` - List all alerts, or only the silenced or inhibited ones. Add "grouped" to list them by alert group, "file" to get them as a Markdown document.
 ` + CommandSilences + ` - List all silences.
+` + CommandSilenceExtend + ` - Extend a silence by a duration, like 4h.
+` + CommandSilencesCleanup + ` - Delete the silences matching filters like expired, created_by:me or older_than:30d, after confirming.
 ` + CommandChats + ` - List all users and group chats that subscribed.
 ` + CommandID + ` - Send the senders Telegram ID (works for all Telegram users).
-` + CommandMute + ` - Mute environments and/or projects.
+` + CommandMute + ` - Mute environments and/or projects, or reply to an alert to mute its labels.
 ` + CommandMuteDel + ` - Delete mute.
+` + CommandMutePreview + ` - Show how many firing alerts a mute would suppress before applying it.
 ` + CommandEnvironments + ` - List all environments for alerts.
 ` + CommandProjects + ` - List all projects for alerts.
 ` + CommandMutedEnvs + ` - List all muted environments.
 ` + CommandMutedPrs + ` - List all muted projects.
+` + CommandStoreCheck + ` - Check the store for stale chat records, records stored at the wrong key and records that can't be read.
+` + CommandStoreRepair + ` - Move chat records stored at the wrong key to the key of their chat, after you confirm it.
+` + CommandIssueButtons + ` - Turn "Create issue" buttons on alerts on or off.
+` + CommandLoadTest + ` - Send synthetic alerts to this chat, if load tests are enabled.
+` + CommandFilters + ` - Show what this chat gets alerts for and how.
+` + CommandTestAlert + ` - Send this chat a test alert like critical prod billing, or tell why it wouldn't get it.
+` + CommandAlertmanagerURL + ` - Link alerts in this chat to another Alertmanager.
+` + CommandRedact + ` - Show only alertname, severity and environment of alerts (strict) or everything (normal).
+` + CommandMaxAlerts + ` - Set how many alerts a message shows before summarising the rest.
+` + CommandReconcile + ` - Check all subscribed chats with Telegram and stop sending to unreachable ones.
+` + CommandConfig + ` - Show the configuration the bot runs with.
+` + CommandDebugInfo + ` - Send a JSON document with the configuration, health, queues and recent errors of the bot, to attach when asking for support.
+` + CommandEcho + ` - Send the payload of every webhook to this chat next to its notification for a while (on [duration]) or stop (off).
+` + CommandDebug + ` - Log everything about this chat at debug level for a while (on [duration]) or stop (off).
+` + CommandMaintenance + ` - Hold all alert notifications during maintenance (on [duration] ["reason"]) and send them when it's over (off).
+` + CommandWebhookConfig + ` - Show the Alertmanager receiver and route sending this chat its alerts.
+` + CommandFallback + ` - Send this chat's alerts to another chat while it's unreachable (<chat_id> or off).
+` + CommandArchiveTo + ` - Copy this chat's alert messages to another chat before they're deleted or once resolved (<chat_id> or off).
+` + CommandResolved + ` - Turn notifications about resolved alerts in this chat on or off.
+` + CommandLang + ` - Show or set the language of the replies in this chat, like de, or follow your Telegram app (auto).
+` + CommandFormat + ` - Show or set the format of notifications to this chat: compact, normal or verbose.
+` + CommandRoute + ` - Send alerts with a severity to another chat instead of this one, like ` + CommandRoute + ` severity[critical] to -100123, or to both with also.
+` + CommandRoutes + ` - List the severity routes of this chat.
+` + CommandParseMode + ` - Format messages to this chat as html, markdownv2 or plain text.
+` + CommandProtect + ` - Require a global admin or a second admin to confirm changes of this chat, or stop requiring it.
+` + CommandReceivers + ` - List the receivers Alertmanager knows and the subscribed chats they send to.
+` + CommandReceiverClaim + ` - Store this chat's receiver, or the given one, for this chat only, taking it away from other chats.
+` + CommandWatchSubscriptions + ` - Tell this chat whenever a chat subscribes, unsubscribes or changes its mutes or severities (on|off), global admins only.
+` + CommandSentLog + ` - Show the last messages sent to this chat or the given chat ID, if they're logged.
+` + CommandAck + ` - Acknowledge the alerts of the notification you reply to, silencing them for a while if the bot is set up to.
+` + CommandEscalateTo + ` - Mention a user like @oncall in escalations of alerts firing for long, or nobody (off).
+` + CommandDebounce + ` - Hold firing alerts for a while, like 3m, and drop those resolving meanwhile, or send them right away (off).
+` + CommandEscalation + ` - Escalate alerts firing for long after thresholds like 1h,4h, the bot's (default) or never (off).
+` + CommandTag + ` - Add or delete tags of this chat, like ` + CommandTag + ` add team-payments.
+` + CommandTags + ` - List the tags of the chats.
+` + CommandBroadcast + ` - Send a message to a chat or all chats with a tag, like ` + CommandBroadcast + ` tag:frontend "maintenance at 5".
+` + CommandInvite + ` - Create a link subscribing whoever opens it to alerts like environment[prod] project[billing] severity[critical] uses[1].
+` + CommandBulkMute + ` - Mute environments or projects in a chat or all chats with a tag, like ` + CommandBulkMute + ` tag:staging project[billing].
+` + CommandRouteAdd + ` - Route webhooks sent without a chat ID, like ` + CommandRouteAdd + ` receiver[team-a] common["severity=critical"] chats[-100123] tags[payments].
+` + CommandRouteList + ` - List the routes of webhooks sent without a chat ID.
+` + CommandRouteDel + ` - Delete a route added with ` + CommandRouteAdd + `.
+` + CommandExpire + ` - Stop sending alerts to this chat after a while, like 7d, or never (off). ` + CommandStart + ` for 7d subscribes like that.
+` + CommandMyData + ` - Show everything the bot stores about this chat and send it as a JSON document.
+` + CommandForgetMe + ` - Erase everything the bot stores about this chat, after you confirm it.
+` + CommandUnknownChats + ` - List the chats webhooks arrive for that aren't subscribed, to subscribe them if they're legitimate.
+` + CommandSuppress + ` - Send an alert to this chat at most once in a while, like ` + CommandSuppress + ` alertname[BackupJobFailed] 24h, optionally per[environment, project].
+` + CommandSuppressions + ` - List the repeat suppressions of this chat and when their alerts are sent again.
+` + CommandSuppressDel + ` - Delete a repeat suppression listed by ` + CommandSuppressions + `.
 `
 )
 
@@ -90,34 +136,131 @@ type BotChatStore interface {
 	UnmuteProject(*telebot.Chat, string, []string) error
 	MutedEnvironments(*telebot.Chat) ([]string, error)
 	MutedProjects(*telebot.Chat) ([]string, error)
-	// DeleteAllMessages() error
+	AddMessage(MessageRecord) error
+	GetMessage(chatID int64, messageID int) (*MessageRecord, error)
+	MigrateChat(from int64, to int64) error
+	RecordDelivery(chatID int64, delivered bool) error
+	GetChatInfo(id int64) (*ChatInfo, error)
+	SetIssueButtons(*telebot.Chat, bool) error
+	SetExternalURL(*telebot.Chat, string) error
+	ListMessages() ([]MessageRecord, error)
+	RemoveMessage(chatID int64, messageID int) error
+	PruneMessages(max int) (int, error)
+	SetRedactStrict(*telebot.Chat, bool) error
+	SetMaxAlerts(*telebot.Chat, int) error
+	SetFallback(*telebot.Chat, int64) error
+	SetArchiveChat(*telebot.Chat, int64) error
+	SetSendResolved(*telebot.Chat, bool) error
+	SetParseMode(*telebot.Chat, string) error
+	SetProtected(*telebot.Chat, bool) error
+	AddOutbox(OutboxEntry) error
+	RemoveOutbox(chatID int64, seq int64) error
+	ListOutbox() ([]OutboxEntry, error)
+	SetEscalationContact(*telebot.Chat, string) error
+	SetEscalation(c *telebot.Chat, thresholds []time.Duration, off bool) error
+	SetEscalated(id int64, escalated map[string]time.Duration) error
+	SetTags(*telebot.Chat, []string) error
+	AddInvite(Invite) error
+	UseInvite(token string, now time.Time) (*Invite, error)
+	ReturnInvite(Invite) error
+	PruneInvites(now time.Time) (int, error)
+	RecordBlockedSend(chatID int64, at time.Time) error
+	ClearBlockedSends(chatID int64) error
+	SetExpiry(c *telebot.Chat, at time.Time) error
+	SetExpiryWarned(id int64) error
+	PauseChat(id int64, since time.Time) error
+	Healthy() bool
+	KeyCounts() (map[string]int, error)
+	SetUnreachable(id int64, since time.Time) error
+	SetLanguage(id int64, lang string, explicit bool) error
+	SetReceiver(id int64, receiver string) error
+	ClaimReceiver(id int64, receiver string) ([]*telebot.Chat, error)
+	SetEcho(id int64, until time.Time) error
+	SetFormat(id int64, preset string) error
+	SetSeverityRoutes(id int64, routes []SeverityRoute) error
+	GetPollState(id int64) (*PollState, error)
+	ChatData(id int64) (*ChatData, error)
+	ForgetChat(id int64) (map[string]int, error)
+	CheckChatKeys() ([]ChatKeyProblem, error)
+	Flush() error
+	RepairChatKeys() ([]ChatKeyProblem, error)
+	SetPollState(id int64, state PollState) error
+	LastConfig() (*StoredConfig, error)
+	SaveConfig(StoredConfig) error
+	ForEach(fn func(ci *ChatInfo) error) error
+	UpdateChat(id int64, fn func(ci *ChatInfo) error) error
+	GetFingerprints(fingerprints []string) (map[string]FingerprintRecord, error)
+	RecordNotified(fingerprints []string, at time.Time) error
+	PruneFingerprints(before time.Time) (int, error)
+	ListRoutes() ([]Route, error)
+	AddRoute(Route) (Route, error)
+	RemoveRoute(id int) error
+	SoftDeleteChat(id int64) error
+	SetDebug(*telebot.Chat, time.Time) error
+	ApplySetup(id int64, setup ChatSetup, allEnvs []string, allPrs []string) error
+	GetMaintenance() (*Maintenance, error)
+	SetMaintenance(Maintenance) error
+	ClearMaintenance() error
+	ListWatchers() ([]Watcher, error)
+	SetWatcher(id int64, on bool, since time.Time) error
+	SetDebounce(id int64, d time.Duration) error
+	CheckArea(area string) (AreaStats, error)
+	EnforceQuota(area string, q AreaQuota, now time.Time) (AreaStats, error)
+	CompactArea(area string) (AreaStats, error)
+	GetUnknownChats() ([]UnknownChat, error)
+	SetUnknownChats(chats []UnknownChat) error
+	SetRepeatSuppressions(id int64, rules []RepeatSuppression) error
+	GetRepeats(chatID int64) (map[string]RepeatRecord, error)
+	RecordRepeats(records []RepeatRecord) error
+	PruneRepeats(now time.Time) (int, error)
 }
 
-// ChatNotFoundErr returned by the store if a chat isn't found.
-var ChatNotFoundErr = errors.New("chat not found in store")
-
 type Telebot interface {
 	Start()
 	Stop()
 	Send(to telebot.Recipient, what interface{}, options ...interface{}) (*telebot.Message, error)
 	Notify(to telebot.Recipient, action telebot.ChatAction) error
 	Handle(endpoint interface{}, handler interface{})
+	Delete(msg telebot.Editable) error
+	Respond(c *telebot.Callback, resp ...*telebot.CallbackResponse) error
+	ChatByID(id string) (*telebot.Chat, error)
+	EditReplyMarkup(msg telebot.Editable, markup *telebot.ReplyMarkup) (*telebot.Message, error)
+	Edit(msg telebot.Editable, what interface{}, options ...interface{}) (*telebot.Message, error)
+	Forward(to telebot.Recipient, msg telebot.Editable, options ...interface{}) (*telebot.Message, error)
+	Pin(msg telebot.Editable, options ...interface{}) error
+	Unpin(chat *telebot.Chat) error
+	Answer(query *telebot.Query, resp *telebot.QueryResponse) error
+	// Raw calls a method of the Bot API with the payload as JSON, for what telebot has no call for.
+	Raw(method string, payload interface{}) ([]byte, error)
+	// Me returns the bot's own user.
+	Me() *telebot.User
 }
 
 type Alertmanager interface {
 	ListAlerts(context.Context, string, bool) ([]*types.Alert, error)
 	ListSilences(context.Context) ([]*types.Silence, error)
 	Status(context.Context) (*models.AlertmanagerStatus, error)
+	AlertStatuses(ctx context.Context, receiver string) (map[string]alertmanager.AlertStatus, error)
+	ListInhibitedAlerts(ctx context.Context, receiver string) ([]alertmanager.InhibitedAlert, error)
+	UpdateSilence(ctx context.Context, s *types.Silence) (string, error)
+	ListReceivers(ctx context.Context) ([]string, error)
+	ListAlertGroups(ctx context.Context, receiver string) ([]*models.AlertGroup, error)
+	DeleteSilence(ctx context.Context, id string) error
 }
 
 // Bot runs the alertmanager telegram.
 type Bot struct {
 	addr                 string
+	publicURL            string
 	admins               []int // must be kept sorted
 	alertmanager         Alertmanager
-	templates            *template.Template
+	templates            *alertTemplates
+	templatesURL         *url.URL
+	responses            responseOverrides
+	errorReports         *errorGovernor
 	chats                BotChatStore
 	logger               log.Logger
+	debugLogger          log.Logger
 	revision             string
 	startTime            time.Time
 	environments         []string
@@ -126,12 +269,139 @@ type Bot struct {
 	projectsAndOther     []string
 	fetchPeriod          float64
 	deletePeriod         float64
+	issueTracker         IssueTracker
+	loadTestEnabled      bool
+	// loadTestMu guards loadTest, the load test running, if any.
+	loadTestMu sync.Mutex
+	loadTest   *loadTestRun
+	// syntheticWebhooks are the webhooks of load tests, dispatched by Run like those of the source.
+	syntheticWebhooks  chan alertmanager.TelegramWebhook
+	maxTrackedMessages int
+	redactions         []*regexp.Regexp
+	correlation        *correlationCache
+	maxAlerts          int
+	// formatProfiles are how messages to the chats of each type are formatted.
+	formatProfiles        map[telebot.ChatType]FormatProfile
+	overflowListings      *overflowListings
+	reconcileOnStartup    bool
+	reconcileGrace        time.Duration
+	reconcileInterval     time.Duration
+	reconcileRunning      int32
+	deliverInhibited      bool
+	setupWizardEnabled    bool
+	setupSessions         *setupSessions
+	maintenanceBuffering  bool
+	maintenanceBuffer     *maintenanceBuffer
+	debouncer             *debouncer
+	debounceBypass        string
+	storeQuotas           map[string]AreaQuota
+	storeCompactAbove     int
+	areaCounts            *areaCounts
+	templateFailures      *templateFailures
+	unknownChats          *unknownChats
+	custom                *customCommands
+	commandLimits         *commandLimiter
+	commandMenu           bool
+	repeatsNow            func() time.Time
+	suppressedAlerting    bool
+	suppressed            *suppressedNotices
+	webhookWorkers        int
+	webhookQueues         *chatQueues
+	deliveryStats         *deliveryStats
+	maxSilenceExtension   time.Duration
+	latency               *latencyStats
+	staleAfter            time.Duration
+	newAlertWindow        time.Duration
+	fingerprintTTL        time.Duration
+	routes                []Route
+	routeMode             RouteMode
+	routeDefaultChat      int64
+	sendResolved          bool
+	splitByStatus         bool
+	failoverThreshold     int
+	failoverProbeInterval time.Duration
+	selfCheckSecret       []byte
+	selfCheckClient       *http.Client
+	replyToCommands       bool
+	globalAdmins          []int // must be kept sorted
+	sharedReceivers       *sharedReceiverNotices
+	// actors and watchLimits are for telling the watchers of subscriptions who changed them.
+	actors           *commandActors
+	watchLimits      *watchLimits
+	protectedChanges *protectedChanges
+	targetedChanges  *protectedChanges
+	// silenceCleanupInterval paces the deletions of /silences_cleanup.
+	silenceCleanupInterval time.Duration
+	receivers              *receiverCache
+	// receiverPattern names the receiver of a chat that has none stored and none found by its ID.
+	receiverPattern     string
+	messageSinks        *messageSinks
+	silenceSyncInterval time.Duration
+	silenceSync         *silenceSyncState
+	pollInterval        time.Duration
+	// pollPace is the least time between two requests of a poll to Alertmanager.
+	pollPace             time.Duration
+	webhookActivity      *webhookActivity
+	ackSilence           time.Duration
+	shedMaxAge           time.Duration
+	shedMerge            bool
+	severityEmojis       map[string]string
+	severityOrdering     severityOrdering
+	unlabeledPolicy      string
+	unlabeledChat        int64
+	durableOutbox        bool
+	outboxMaxAge         time.Duration
+	outboxSeq            int64
+	escalationThresholds []time.Duration
+	inviteTTL            time.Duration
+	clusterCheckInterval time.Duration
+	cluster              *clusterState
+	// runMu guards running, the state of the Run in progress, if any.
+	runMu        sync.Mutex
+	running      *runState
+	handlersOnce sync.Once
+	// config keeps what options configure but the bot doesn't use otherwise, for ConfigSnapshot.
+	config ConfigSnapshot
 
 	telegram Telebot
 
-	commandEvents   func(command string)
-	commandsCounter *prometheus.CounterVec
-	webhooksCounter prometheus.Counter
+	commandEvents         func(command string)
+	commandsCounter       *prometheus.CounterVec
+	commandDuration       *prometheus.HistogramVec
+	commandErrors         *prometheus.CounterVec
+	unknownSeverities     *prometheus.CounterVec
+	messageArchives       *prometheus.CounterVec
+	debouncedAlerts       *prometheus.CounterVec
+	storeAreaEntries      *prometheus.GaugeVec
+	storeAreaCorrupt      *prometheus.GaugeVec
+	slowCommand           time.Duration
+	webhooksCounter       prometheus.Counter
+	messageDeletesCounter *prometheus.CounterVec
+	messagesPrunedCounter prometheus.Counter
+	messageSinkFailures   *prometheus.CounterVec
+	notificationsShed     prometheus.Counter
+	notificationsMerged   prometheus.Counter
+	unlabeledCounter      *prometheus.CounterVec
+	outboxReplayed        prometheus.Counter
+	outboxExpired         prometheus.Counter
+	ownMessagesSkipped    prometheus.Counter
+	htmlFallbacks         prometheus.Counter
+	floodWaitSeconds      prometheus.Gauge
+	floodWaitEnabled      bool
+	flood                 *floodWait
+	htmlCheck             bool
+	// loggedErrors are the last warnings and errors logged, for /debug_info.
+	loggedErrors *errorRing
+	replica      *replicaState
+	// updatesWebhook, if set, has Telegram send the updates to it instead of the bot polling them.
+	updatesWebhook *telebot.Webhook
+	// swappable is the innermost Telebot, RotateToken swaps what it calls.
+	swappable *swappableTelebot
+	// newTelebot creates the Telebot of a new token, nil if the bot wasn't created with a token.
+	newTelebot func(token string) (Telebot, error)
+	// startupAttempts and startupBackoff are how NewBotContext tries to reach Telegram and the store.
+	startupAttempts int
+	startupBackoff  time.Duration
 }
 
 // BotOption passed to NewBot to change the default instance.
@@ -139,49 +409,285 @@ type BotOption func(b *Bot) error
 
 // NewBot creates a Bot with the UserStore and telegram telegram.
 func NewBot(chats BotChatStore, token string, admin int, opts ...BotOption) (*Bot, error) {
-	poller := &telebot.LongPoller{
-		Timeout: 10 * time.Second,
-	}
+	return NewBotContext(context.Background(), chats, token, admin, opts...)
+}
 
-	bot, err := telebot.NewBot(telebot.Settings{
-		Token:  token,
-		Poller: poller,
-	})
+// NewBotContext creates a Bot like NewBot, giving up on reaching Telegram and the store once ctx is done.
+// How often they're tried is set by WithStartupRetry, what failed is told by a *StartupError.
+func NewBotContext(ctx context.Context, chats BotChatStore, token string, admin int, opts ...BotOption) (*Bot, error) {
+	return newBotContext(ctx, chats, token, admin, dialTelegram, opts...)
+}
+
+// newBotContext creates the Bot with the Telebot dial creates for the token.
+func newBotContext(ctx context.Context, chats BotChatStore, token string, admin int, dial func(token string, updates *telebot.Webhook) (Telebot, error), opts ...BotOption) (*Bot, error) {
+	// An empty token is one of the problems with the options, to tell about every problem at once.
+	var problems []error
+	if strings.TrimSpace(token) == "" {
+		problems = append(problems, ErrEmptyToken)
+	}
+	b, err := newBot(chats, nil, admin, problems, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if b.updatesWebhook == nil && b.Role() == RoleFollower {
+		return nil, errFollowerPolling
+	}
+	b.newTelebot = func(token string) (Telebot, error) {
+		return dial(token, b.updatesWebhook)
+	}
 
-	return NewBotWithTelegram(chats, bot, admin, opts...)
+	bot, err := b.connect(ctx, token, b.newTelebot)
+	if err != nil {
+		return nil, err
+	}
+	b.swappable.current = bot
+	return b, nil
 }
 
 func NewBotWithTelegram(chats BotChatStore, bot Telebot, admin int, opts ...BotOption) (*Bot, error) {
+	return newBot(chats, bot, admin, nil, opts...)
+}
+
+// newBot creates the Bot, applying the options once and returning their problems after the given ones.
+func newBot(chats BotChatStore, bot Telebot, admin int, problems []error, opts ...BotOption) (*Bot, error) {
 	commandsCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "alertmanagerbot",
 		Name:      "commands_total",
 		Help:      "Number of commands received by command name",
 	}, []string{"command"})
-	if err := prometheus.Register(commandsCounter); err != nil {
-		return nil, err
+	commandDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "command_duration_seconds",
+		Help:      "How long the handlers of commands took by command name",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+	commandErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "command_errors_total",
+		Help:      "Number of commands whose handler failed by command name",
+	}, []string{"command"})
+	messageDeletesCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "message_deletes_total",
+		Help:      "Number of attempts to delete old alert messages by result: deleted, permanent, transient or abandoned failure",
+	}, []string{"result"})
+	messagesPrunedCounter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "messages_pruned_total",
+		Help:      "Number of message records pruned because the store had too many",
+	})
+	messageSinkFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "message_sink_failures_total",
+		Help:      "Number of sent messages not recorded by the message sinks by reason: failed or dropped",
+	}, []string{"reason"})
+	notificationsShed := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "notifications_shed_total",
+		Help:      "Number of notifications dropped because they waited too long to be sent",
+	})
+	notificationsMerged := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "notifications_merged_total",
+		Help:      "Number of notifications merged into one message because they waited too long to be sent",
+	})
+
+	unlabeledCounter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "unlabeled_alerts_total",
+		Help:      "Number of alerts without environment or project label by what the policy did: other, dropped, catch_all, forward_failed or tagged",
+	}, []string{"outcome"})
+
+	outboxReplayed := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "outbox_replayed_total",
+		Help:      "Number of webhooks left in the outbox by an earlier run and delivered on startup",
+	})
+	outboxExpired := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "outbox_expired_total",
+		Help:      "Number of webhooks left in the outbox by an earlier run and dropped on startup as too old",
+	})
+	ownMessagesSkipped := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "own_messages_skipped_total",
+		Help:      "Number of updates about messages the bot sent itself that were skipped",
+	})
+	htmlFallbacks := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "html_fallbacks_total",
+		Help:      "Number of messages sent as escaped plain text because Telegram couldn't have parsed their HTML",
+	})
+	floodWaitSeconds := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "telegram_flood_wait_seconds",
+		Help:      "Seconds sends to all chats were paused for by Telegram's flood control, 0 when they aren't paused",
+	})
+	unknownSeverities := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "unknown_severities_total",
+		Help:      "Number of alerts whose severity the severity ordering doesn't cover, which rank lowest, by severity",
+	}, []string{"severity"})
+	messageArchives := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "message_archives_total",
+		Help:      "Number of alert messages copied to the archive chat of their chat, by result",
+	}, []string{"result"})
+	debouncedAlerts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "debounced_alerts_total",
+		Help:      "Number of firing alerts held by debounce, by whether they were dropped because they resolved meanwhile or released",
+	}, []string{"outcome"})
+	storeAreaEntries := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "store_area_entries",
+		Help:      "Number of records in the areas of the store records are added to all the time, by area",
+	}, []string{"area"})
+	storeAreaCorrupt := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "alertmanagerbot",
+		Name:      "store_area_corrupt_entries",
+		Help:      "Number of records in the areas of the store that can't be read and are skipped, by area",
+	}, []string{"area"})
+
+	var collectors []prometheus.Collector
+	for _, c := range []prometheus.Collector{commandsCounter, messageDeletesCounter, messagesPrunedCounter, messageSinkFailures, notificationsShed, notificationsMerged, unlabeledCounter, outboxReplayed, outboxExpired, ownMessagesSkipped, htmlFallbacks, floodWaitSeconds, commandDuration, commandErrors, unknownSeverities, messageArchives, debouncedAlerts, storeAreaEntries, storeAreaCorrupt} {
+		c, err := registerCollector(c)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, c)
 	}
+
 	b := &Bot{
-		logger:          log.NewNopLogger(),
-		telegram:        bot,
-		chats:           chats,
-		addr:            "127.0.0.1:8080",
-		admins:          []int{admin},
-		commandEvents:   func(command string) {},
-		commandsCounter: commandsCounter,
+		logger:                 log.NewNopLogger(),
+		swappable:              &swappableTelebot{current: bot},
+		chats:                  chats,
+		addr:                   "127.0.0.1:8080",
+		admins:                 []int{admin},
+		maxTrackedMessages:     defaultMaxTrackedMessages,
+		overflowListings:       newOverflowListings(overflowListingsMax),
+		reconcileInterval:      defaultReconcileInterval,
+		setupSessions:          newSetupSessions(setupWizardTimeout),
+		maintenanceBuffering:   true,
+		maintenanceBuffer:      newMaintenanceBuffer(),
+		debouncer:              newDebouncer(),
+		areaCounts:             newAreaCounts(),
+		templateFailures:       newTemplateFailures(),
+		unknownChats:           newUnknownChats(),
+		custom:                 &customCommands{},
+		commandLimits:          newCommandLimiter(),
+		repeatsNow:             time.Now,
+		webhookWorkers:         defaultWebhookWorkers,
+		maxSilenceExtension:    defaultMaxSilenceExtension,
+		latency:                newLatencyStats(),
+		staleAfter:             defaultStaleAfter,
+		fingerprintTTL:         defaultFingerprintTTL,
+		routeMode:              RouteFirstMatch,
+		sendResolved:           true,
+		splitByStatus:          true,
+		failoverThreshold:      defaultFailoverThreshold,
+		startupAttempts:        defaultStartupAttempts,
+		startupBackoff:         defaultStartupBackoff,
+		failoverProbeInterval:  defaultFailoverProbeInterval,
+		selfCheckClient:        &http.Client{},
+		replyToCommands:        true,
+		globalAdmins:           []int{admin},
+		sharedReceivers:        &sharedReceiverNotices{},
+		actors:                 newCommandActors(),
+		watchLimits:            newWatchLimits(),
+		protectedChanges:       newProtectedChanges(protectConfirmTimeout),
+		targetedChanges:        newTargetedChanges(protectConfirmTimeout),
+		silenceCleanupInterval: defaultSilenceCleanupInterval,
+		receivers:              newReceiverCache(receiversCacheTTL),
+		receiverPattern:        defaultReceiverPattern,
+		silenceSync:            &silenceSyncState{},
+		pollPace:               defaultPollPace,
+		webhookActivity:        newWebhookActivity(),
+		deliveryStats:          &deliveryStats{},
+		cluster:                &clusterState{},
+		unlabeledPolicy:        UnlabeledOther,
+		outboxMaxAge:           defaultOutboxMaxAge,
+		inviteTTL:              defaultInviteTTL,
+		errorReports:           newErrorGovernor(defaultErrorReportWindow),
+		outboxSeq:              time.Now().UnixNano(),
+		suppressed:             newSuppressedNotices(),
+		commandEvents:          func(command string) {},
+		commandsCounter:        collectors[0].(*prometheus.CounterVec),
+		messageDeletesCounter:  collectors[1].(*prometheus.CounterVec),
+		messagesPrunedCounter:  collectors[2].(prometheus.Counter),
+		messageSinkFailures:    collectors[3].(*prometheus.CounterVec),
+		notificationsShed:      collectors[4].(prometheus.Counter),
+		notificationsMerged:    collectors[5].(prometheus.Counter),
+		unlabeledCounter:       collectors[6].(*prometheus.CounterVec),
+		outboxReplayed:         collectors[7].(prometheus.Counter),
+		outboxExpired:          collectors[8].(prometheus.Counter),
+		ownMessagesSkipped:     collectors[9].(prometheus.Counter),
+		htmlFallbacks:          collectors[10].(prometheus.Counter),
+		floodWaitSeconds:       collectors[11].(prometheus.Gauge),
+		commandDuration:        collectors[12].(*prometheus.HistogramVec),
+		commandErrors:          collectors[13].(*prometheus.CounterVec),
+		unknownSeverities:      collectors[14].(*prometheus.CounterVec),
+		messageArchives:        collectors[15].(*prometheus.CounterVec),
+		debouncedAlerts:        collectors[16].(*prometheus.CounterVec),
+		storeAreaEntries:       collectors[17].(*prometheus.GaugeVec),
+		storeAreaCorrupt:       collectors[18].(*prometheus.GaugeVec),
+		severityOrdering:       defaultSeverityOrdering,
+		slowCommand:            defaultSlowCommand,
+		htmlCheck:              true,
+		loggedErrors:           newErrorRing(defaultLoggedErrors),
+		replica:                &replicaState{role: RoleLeader},
+		syntheticWebhooks:      make(chan alertmanager.TelegramWebhook),
 	}
 
+	// Every problem with the options is returned at once, so that they can all be fixed in one go.
 	for _, opt := range opts {
 		if err := opt(b); err != nil {
-			return nil, err
+			problems = append(problems, err)
+		}
+	}
+	// Keep the last warnings and errors for /debug_info.
+	b.logger = b.loggedErrors.tee(b.logger)
+	problems = append(problems, b.validate()...)
+	if len(problems) > 0 {
+		return nil, &OptionsError{Errs: problems}
+	}
+	b.warnBadResponses()
+	b.webhookQueues = newChatQueues(b.webhookWorkers)
+
+	// Time the store, Alertmanager and Telegram for /status, keeping nil ones nil.
+	if b.chats != nil {
+		b.chats = timedChatStore{BotChatStore: b.chats, reads: b.latency.storeReads, writes: b.latency.storeWrites}
+		if len(b.storeQuotas) > 0 {
+			b.chats = quotaChatStore{BotChatStore: b.chats, bot: b}
 		}
+		// Outermost, so that watchers are told about the changes of subscriptions only once they're stored.
+		b.chats = watchedChatStore{BotChatStore: b.chats, bot: b}
+	}
+	if b.alertmanager != nil {
+		b.alertmanager = timedAlertmanager{Alertmanager: b.alertmanager, listAlerts: b.latency.listAlerts}
+	}
+	b.telegram = timedTelebot{Telebot: b.swappable, sends: b.latency.telegramSends}
+	if b.floodWaitEnabled {
+		b.flood = newFloodWait(b.logger, b.floodWaitSeconds)
+		b.telegram = floodTelebot{Telebot: b.telegram, flood: b.flood}
 	}
 
 	return b, nil
 }
 
+// registerCollector registers a collector or returns the one
+// another Bot in this process registered already, to share it.
+func registerCollector(c prometheus.Collector) (prometheus.Collector, error) {
+	if err := prometheus.Register(c); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+		return are.ExistingCollector, nil
+	}
+	return c, nil
+}
+
 // WithLogger sets the logger for the Bot as an option.
 func WithLogger(l log.Logger) BotOption {
 	return func(b *Bot) error {
@@ -212,7 +718,7 @@ func WithProjects(projectsToUse string) BotOption {
 	}
 }
 
-// WithFetchPeriod allows to define scheduler period for fetching messages from store
+// WithFetchPeriod allows to define scheduler period for fetching messages from store, in seconds.
 func WithFetchPeriod(fetchPeriod float64) BotOption {
 	return func(b *Bot) error {
 		b.fetchPeriod = fetchPeriod
@@ -220,7 +726,8 @@ func WithFetchPeriod(fetchPeriod float64) BotOption {
 	}
 }
 
-// WithDeletePeriod allows to define period of deleting messages
+// WithDeletePeriod allows to define period of deleting messages: alert messages are deleted after this many seconds.
+// Telegram doesn't allow bots to delete messages older than 48 hours.
 func WithDeletePeriod(deletePeriod float64) BotOption {
 	return func(b *Bot) error {
 		b.deletePeriod = deletePeriod
@@ -254,24 +761,12 @@ func WithAlertmanager(alertmanager Alertmanager) BotOption {
 // WithTemplates uses Alertmanager template to render messages for Telegram.
 func WithTemplates(alertmanager *url.URL, templatePaths ...string) BotOption {
 	return func(b *Bot) error {
-		funcs := template.DefaultFuncs
-		funcs["since"] = func(t time.Time) string {
-			return durafmt.Parse(time.Since(t)).String()
-		}
-		funcs["duration"] = func(start time.Time, end time.Time) string {
-			return durafmt.Parse(end.Sub(start)).String()
+		if len(templatePaths) == 0 {
+			return fmt.Errorf("no template paths given")
 		}
-
-		template.DefaultFuncs = funcs
-
-		tmpl, err := template.FromGlobs(templatePaths...)
-		if err != nil {
-			return err
-		}
-
-		tmpl.ExternalURL = alertmanager
-		b.templates = tmpl
-
+		// The templates are parsed by validate, once it's known that the globs are fine.
+		b.config.TemplatePaths = templatePaths
+		b.templatesURL = alertmanager
 		return nil
 	}
 }
@@ -304,7 +799,7 @@ func WithExtraAdmins(ids ...int) BotOption {
 
 // SendAdminMessage to the admin's ID with a message.
 func (b *Bot) SendAdminMessage(adminID int, message string) {
-	_, _ = b.telegram.Send(&telebot.User{ID: adminID}, message)
+	_, _ = b.send(&telebot.User{ID: adminID}, message, "admin message")
 }
 
 // isAdminID returns whether id is one of the configured admin IDs.
@@ -313,27 +808,166 @@ func (b *Bot) isAdminID(id int) bool {
 	return i < len(b.admins) && b.admins[i] == id
 }
 
-// Run the telegram and listen to messages send to the telegram.
-func (b *Bot) Run(ctx context.Context, webhooks <-chan alertmanager.TelegramWebhook) error {
-	b.telegram.Handle(CommandStart, b.middleware(b.handleStart))
-	b.telegram.Handle(CommandStop, b.middleware(b.handleStop))
-	b.telegram.Handle(CommandHelp, b.middleware(b.handleHelp))
-	b.telegram.Handle(CommandChats, b.middleware(b.handleChats))
-	b.telegram.Handle(CommandID, b.middleware(b.handleID))
-	b.telegram.Handle(CommandStatus, b.middleware(b.handleStatus))
-	b.telegram.Handle(CommandAlerts, b.middleware(b.handleAlerts))
-	b.telegram.Handle(CommandSilences, b.middleware(b.handleSilences))
-	b.telegram.Handle(CommandMute, b.middleware(b.handleMute))
-	b.telegram.Handle(CommandMuteDel, b.middleware(b.handleMuteDel))
-	b.telegram.Handle(CommandEnvironments, b.middleware(b.handleEnvironments))
-	b.telegram.Handle(CommandProjects, b.middleware(b.handleProjects))
-	b.telegram.Handle(CommandMutedEnvs, b.middleware(b.handleMutedEnvs))
-	b.telegram.Handle(CommandMutedPrs, b.middleware(b.handleMutedPrs))
+// Run the telegram and listen to messages send to the telegram, delivering the webhooks of the source.
+// It returns once ctx is done, Shutdown is called or a webhook can't be processed,
+// and can be called again afterwards. The goroutines it starts - polling Telegram,
+// the webhook workers, the background jobs and recording the sent messages - have all returned by then.
+// Webhooks sent to a channel are received with ChannelSource.
+func (b *Bot) Run(ctx context.Context, source WebhookSource) error {
+	b.runMu.Lock()
+	if b.running != nil {
+		b.runMu.Unlock()
+		return errors.New("the bot is running already")
+	}
+	work, abort := context.WithCancel(ctx)
+	ctx, stop := context.WithCancel(ctx)
+	state := &runState{ctx: ctx, stop: stop, abort: abort, done: make(chan struct{})}
+	b.running = state
+	b.runMu.Unlock()
+	if b.flood != nil {
+		b.flood.abortOn(work.Done())
+	}
+	defer func() {
+		stop()
+		abort()
+		if b.flood != nil {
+			b.flood.abortOn(nil)
+		}
+		if err := b.chats.Flush(); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to flush buffered store writes", "err", err)
+		}
+		b.stopMessageSinks()
+		b.runMu.Lock()
+		b.running = nil
+		b.runMu.Unlock()
+		close(state.done)
+	}()
+
+	// The source keeps going until the queued webhooks are delivered, so they can still be acked.
+	webhooks, err := source.Receive(work)
+	if err != nil {
+		return fmt.Errorf("failed to receive webhooks: %w", err)
+	}
+
+	b.handlersOnce.Do(b.registerHandlers)
+
 	var gr run.Group
 	{
 		gr.Add(func() error {
-			return b.sendWebhook(ctx, webhooks)
+			return b.sendWebhook(ctx, work, webhooks)
+		}, func(err error) {
+			stop()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runCleanup(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if b.suppressedAlerting {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runSuppressedNotices(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runStoreAreas(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runDebounce(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runUnknownChats(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runFailoverProbes(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if b.silenceSyncInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runSilenceSync(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if b.pollInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runPollAlerts(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if b.clusterCheckInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.runClusterCheck(ctx)
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.checkChatKeysOnStart()
+			<-ctx.Done()
+			return nil
 		}, func(err error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.cleanupRemovedOnStart(ctx)
+			<-ctx.Done()
+			return nil
+		}, func(err error) {
+			cancel()
+		})
+	}
+	if b.reconcileOnStartup {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(func() error {
+			b.reconcileOnStart(ctx)
+			<-ctx.Done()
+			return nil
+		}, func(err error) {
+			cancel()
 		})
 	}
 	{
@@ -348,12 +982,158 @@ func (b *Bot) Run(ctx context.Context, webhooks <-chan alertmanager.TelegramWebh
 	return gr.Run()
 }
 
+// Shutdown stops Run gracefully: it stops taking webhooks, waits for the workers to deliver
+// those already queued and stops polling Telegram. If ctx is done first, the queued webhooks
+// are dropped and ctx's error is returned once Run has returned. The Prometheus collectors
+// stay registered for the next Run to reuse, the chat store is the caller's to close.
+// Shutdown returns right away if the bot isn't running.
+func (b *Bot) Shutdown(ctx context.Context) error {
+	b.runMu.Lock()
+	state := b.running
+	b.runMu.Unlock()
+	if state == nil {
+		return nil
+	}
+
+	state.stop()
+	select {
+	case <-state.done:
+		return nil
+	case <-ctx.Done():
+		state.abort()
+		<-state.done
+		return ctx.Err()
+	}
+}
+
+// runContext returns the context of the Run in progress, done once it stops taking webhooks, or nil if there's none.
+func (b *Bot) runContext() context.Context {
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+	if b.running == nil {
+		return nil
+	}
+	return b.running.ctx
+}
+
+// runState lets Shutdown stop the running Run.
+type runState struct {
+	// ctx is done once the Run stops taking webhooks.
+	ctx context.Context
+	// stop ends taking webhooks and the background jobs, abort the delivery of the queued webhooks.
+	stop  context.CancelFunc
+	abort context.CancelFunc
+	done  chan struct{}
+}
+
+// builtinCommand is a command of the bot and its handler.
+type builtinCommand struct {
+	name    string
+	handler func(*telebot.Message) error
+}
+
+// builtinCommands are the commands of the bot, handlers of changes that need a second admin in protected chats
+// are wrapped in protected.
+func (b *Bot) builtinCommands() []builtinCommand {
+	return []builtinCommand{
+		{CommandStart, b.handleStart},
+		{CommandStop, b.protected(b.handleStop)},
+		{CommandHelp, b.handleHelp},
+		{CommandChats, b.handleChats},
+		{CommandID, b.handleID},
+		{CommandStatus, b.handleStatus},
+		{CommandAlerts, b.handleAlerts},
+		{CommandSilences, b.handleSilences},
+		{CommandMute, b.handleMute},
+		{CommandMuteDel, b.handleMuteDel},
+		{CommandMutePreview, b.handleMutePreview},
+		{CommandSilencesCleanup, b.handleSilencesCleanup},
+		{CommandSilenceExtend, b.handleSilenceExtend},
+		{CommandEnvironments, b.handleEnvironments},
+		{CommandProjects, b.handleProjects},
+		{CommandMutedEnvs, b.handleMutedEnvs},
+		{CommandMutedPrs, b.handleMutedPrs},
+		{CommandStoreCheck, b.handleStoreCheck},
+		{CommandStoreRepair, b.handleStoreRepair},
+		{CommandIssueButtons, b.handleIssueButtons},
+		{CommandLoadTest, b.handleLoadTest},
+		{CommandFilters, b.handleFilters},
+		{CommandTestAlert, b.handleTestAlert},
+		{CommandAlertmanagerURL, b.handleAlertmanagerURL},
+		{CommandRedact, b.handleRedact},
+		{CommandMaxAlerts, b.handleMaxAlerts},
+		{CommandReconcile, b.handleReconcile},
+		{CommandConfig, b.handleConfig},
+		{CommandDebugInfo, b.handleDebugInfo},
+		{CommandDebug, b.handleDebug},
+		{CommandEcho, b.handleEcho},
+		{CommandMaintenance, b.handleMaintenance},
+		{CommandWebhookConfig, b.handleWebhookConfig},
+		{CommandFallback, b.handleFallback},
+		{CommandArchiveTo, b.protected(b.handleArchiveTo)},
+		{CommandResolved, b.handleResolved},
+		{CommandLang, b.handleLang},
+		{CommandFormat, b.handleFormat},
+		{CommandRoute, b.protected(b.handleRoute)},
+		{CommandRoutes, b.handleRoutes},
+		{CommandParseMode, b.protected(b.handleParseMode)},
+		{CommandProtect, b.handleProtect},
+		{CommandReceivers, b.handleReceivers},
+		{CommandReceiverClaim, b.protected(b.handleReceiverClaim)},
+		{CommandWatchSubscriptions, b.handleWatchSubscriptions},
+		{CommandSentLog, b.handleSentLog},
+		{CommandAck, b.handleAck},
+		{CommandEscalateTo, b.protected(b.handleEscalateTo)},
+		{CommandDebounce, b.protected(b.handleDebounce)},
+		{CommandEscalation, b.protected(b.handleEscalation)},
+		{CommandTag, b.protected(b.handleTag)},
+		{CommandTags, b.handleTags},
+		{CommandBroadcast, b.handleBroadcast},
+		{CommandBulkMute, b.handleBulkMute},
+		{CommandInvite, b.handleInvite},
+		{CommandExpire, b.protected(b.handleExpire)},
+		{CommandRouteAdd, b.handleRouteAdd},
+		{CommandRouteList, b.handleRouteList},
+		{CommandRouteDel, b.handleRouteDel},
+		{CommandMyData, b.handleMyData},
+		{CommandForgetMe, b.handleForgetMe},
+		{CommandUnknownChats, b.handleUnknownChats},
+		{CommandSuppress, b.protected(b.handleSuppress)},
+		{CommandSuppressions, b.handleSuppressions},
+		{CommandSuppressDel, b.protected(b.handleSuppressDel)},
+	}
+}
+
+// registerHandlers registers the commands and callbacks with Telegram, once per Bot.
+func (b *Bot) registerHandlers() {
+	for _, c := range b.builtinCommands() {
+		b.telegram.Handle(c.name, b.middleware(c.handler))
+	}
+	b.registerCustomCommands()
+	b.setCommandMenu()
+	b.telegram.Handle("\f"+showAllUnique, b.handleShowAll)
+	b.telegram.Handle("\f"+storeRepairUnique, b.leaderCallback(b.handleStoreRepairConfirm))
+	b.telegram.Handle("\f"+setupWizardUnique, b.leaderCallback(b.handleSetup))
+	b.telegram.Handle("\f"+mutePreviewUnique, b.leaderCallback(b.handleMutePreviewConfirm))
+	b.telegram.Handle("\f"+saveReceiverUnique, b.leaderCallback(b.handleSaveReceiver))
+	b.telegram.Handle("\f"+silenceExtendUnique, b.leaderCallback(b.handleSilenceButton(false)))
+	b.telegram.Handle("\f"+silenceRecreateUnique, b.leaderCallback(b.handleSilenceButton(true)))
+	b.telegram.Handle("\f"+protectConfirmUnique, b.leaderCallback(b.handleProtectConfirm))
+	b.telegram.Handle("\f"+targetConfirmUnique, b.leaderCallback(b.handleTargetConfirm))
+	b.telegram.Handle("\f"+expireExtendUnique, b.leaderCallback(b.handleExpireButton))
+	b.telegram.Handle("\f"+forgetMeUnique, b.leaderCallback(b.handleForgetMeConfirm))
+	b.telegram.Handle("\f"+unknownChatSubscribeUnique, b.leaderCallback(b.handleUnknownChatSubscribe))
+	b.telegram.Handle(telebot.OnMigration, b.handleMigration)
+	b.telegram.Handle(telebot.OnChannelPost, b.handleChannelPost)
+	b.telegram.Handle(telebot.OnQuery, b.handleInlineQuery)
+}
+
 func (b *Bot) middleware(next func(*telebot.Message) error) func(*telebot.Message) {
 	return func(m *telebot.Message) {
-		if m.IsService() {
+		if m.IsService() || b.skipOwnMessage(m) {
 			return
 		}
-		if !b.isAdminID(m.Sender.ID) && m.Text != CommandID {
+		if !b.isAdminID(m.Sender.ID) && strings.Split(m.Text, "@")[0] != CommandID && !inviteStart(m) && !ownPrivacyCommand(m) && !b.publicCommand(m) {
 			level.Info(b.logger).Log(
 				"msg", "dropping message from forbidden sender",
 				"sender_id", m.Sender.ID,
@@ -365,9 +1145,15 @@ func (b *Bot) middleware(next func(*telebot.Message) error) func(*telebot.Messag
 		command := strings.Split(m.Text, " ")[0]
 		b.commandEvents(command)
 
-		level.Debug(b.logger).Log("msg", "message received", "text", m.Text)
-		if err := next(m); err != nil {
-			level.Warn(b.logger).Log("msg", "failed to handle command", "err", err)
+		logger := b.messageLogger(m)
+		level.Debug(logger).Log("msg", "message received", "text", m.Text)
+		if b.readOnlyRefused(m, command) {
+			return
+		}
+		b.welcomeBack(m)
+		defer b.actors.acting(m.Chat.ID, m.Sender)()
+		if err := b.timeCommand(logger, command, next, m); err != nil {
+			level.Warn(logger).Log("msg", "failed to handle command", "err", err)
 		}
 	}
 }
@@ -397,17 +1183,28 @@ func (b *Bot) handleMute(message *telebot.Message) error {
 			"sender_username", message.Sender.Username,
 		)
 	} else {
-		envsToMute, prsToMute, err := parseMuteCommand(message.Text)
+		envsToMute, prsToMute, inferred, err := b.targetsFromReply(message)
 		if err != nil {
-			_, _ = b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse mute command... %v", err))
+			if errors.Is(err, ErrMessageNotFound) {
+				_, _ = b.respond(message, "reply_context_unknown", responseContext(message))
+				return nil
+			}
+			_, _ = b.respondError(message, "mute_infer_failed", err)
 			return err
 		}
+		if !inferred {
+			envsToMute, prsToMute, err = parseMuteCommand(message.Text)
+			if err != nil {
+				_, _ = b.respondError(message, "mute_parse_failed", err)
+				return err
+			}
+		}
 
 		if len(envsToMute) > 0 {
 			err := b.chats.MuteEnvironments(message.Chat, envsToMute, b.environmentsAndOther)
 			if err != nil {
 				level.Warn(b.logger).Log("msg", "failed to subscribe user to environments", "err", err)
-				_, _ = b.telegram.Send(message.Chat, fmt.Sprintf("failed to subscribe user to environments... %v", err))
+				_, _ = b.replyStoreError(message, err, "subscribe user to environments")
 			}
 		}
 
@@ -415,11 +1212,15 @@ func (b *Bot) handleMute(message *telebot.Message) error {
 			err := b.chats.MuteProjects(message.Chat, prsToMute, b.projectsAndOther)
 			if err != nil {
 				level.Warn(b.logger).Log("msg", "failed to subscribe user to project", "err", err)
-				_, _ = b.telegram.Send(message.Chat, fmt.Sprintf("failed to subscribe user to proj... %v", err))
+				_, _ = b.replyStoreError(message, err, "subscribe user to proj")
 			}
 		}
 
-		_, err = b.telegram.Send(message.Chat, "You were successfully muted environments and/or projects")
+		ctx, name := b.localResponseContext(message), "mute_done"
+		if inferred {
+			ctx.Args, name = describeTargets(envsToMute, prsToMute), "mute_inferred"
+		}
+		_, err = b.reply(message, b.responseText(name, ctx)+b.receivesNothingWarning(message.Chat.ID))
 		if err != nil {
 			level.Warn(b.logger).Log("msg", "failed to send success of muting the env/projects message to the user", "err", err)
 		}
@@ -437,7 +1238,7 @@ func (b *Bot) handleEnvironments(message *telebot.Message) error {
 		)
 		return nil
 	} else {
-		b.telegram.Send(message.Chat, fmt.Sprintf("The following environments are available: %s", b.environmentsAndOther))
+		b.reply(message, "The following environments are available: "+formatList(b.environmentsAndOther))
 		return err
 	}
 }
@@ -452,7 +1253,7 @@ func (b *Bot) handleProjects(message *telebot.Message) error {
 		)
 		return nil
 	} else {
-		b.telegram.Send(message.Chat, fmt.Sprintf("The following projects are available: %s", b.projectsAndOther))
+		b.reply(message, "The following projects are available: "+formatList(b.projectsAndOther))
 		return err
 	}
 }
@@ -470,12 +1271,13 @@ func (b *Bot) handleMutedEnvs(message *telebot.Message) error {
 		mutedEnvs, err := b.chats.MutedEnvironments(message.Chat)
 		if err != nil {
 			level.Warn(b.logger).Log("msg", "failed to get muted environments", "err", err)
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get muted environments... %v", err))
+			b.replyStoreError(message, err, "get muted environments")
+			return err
 		}
 		if len(mutedEnvs) > 0 {
-			b.telegram.Send(message.Chat, fmt.Sprintf("Muted environments:  %s", mutedEnvs))
+			b.reply(message, "Muted environments: "+formatList(mutedEnvs))
 		} else {
-			b.telegram.Send(message.Chat, "No muted environments")
+			b.reply(message, "No muted environments")
 		}
 		return err
 	}
@@ -494,64 +1296,40 @@ func (b *Bot) handleMutedPrs(message *telebot.Message) error {
 		mutedPrs, err := b.chats.MutedProjects(message.Chat)
 		if err != nil {
 			level.Warn(b.logger).Log("msg", "failed to get muted projects", "err", err)
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to get muted projects... %v", err))
+			b.replyStoreError(message, err, "get muted projects")
+			return err
 		}
 		if len(mutedPrs) > 0 {
-			b.telegram.Send(message.Chat, fmt.Sprintf("Muted projects:  %s", mutedPrs))
+			b.reply(message, "Muted projects: "+formatList(mutedPrs))
 		} else {
-			b.telegram.Send(message.Chat, "No muted projects")
+			b.reply(message, "No muted projects")
 		}
 		return err
 	}
 }
 
-// sendWebhook sends messages received via webhook to all subscribed chats.
-func (b *Bot) sendWebhook(ctx context.Context, webhooks <-chan alertmanager.TelegramWebhook) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case w := <-webhooks:
-			level.Warn(b.logger).Log("msg", "got webhook")
-			chat, err, kv := b.chats.Get(telebot.ChatID(w.ChatID))
-			level.Debug(b.logger).Log("key", kv.Key, "value", string(kv.Value), "chatid", strconv.FormatInt(chat.ID, 10))
-			if err != nil {
-				if errors.Is(err, ChatNotFoundErr) {
-					level.Warn(b.logger).Log("msg", "chat is not subscribed for alerts", "chat_id", w.ChatID, "err", err)
-					continue
-				}
-				return err
-			}
-
-			data := &template.Data{
-				Receiver:          w.Message.Receiver,
-				Status:            w.Message.Status,
-				Alerts:            w.Message.Alerts,
-				GroupLabels:       w.Message.GroupLabels,
-				CommonLabels:      w.Message.CommonLabels,
-				CommonAnnotations: w.Message.CommonAnnotations,
-				ExternalURL:       w.Message.ExternalURL,
-			}
-
-			out, err := b.templates.ExecuteHTMLString(`{{ template "telegram.default" . }}`, data)
-			if err != nil {
-				level.Warn(b.logger).Log("msg", "failed to template alerts", "err", err)
-				continue
-			}
-			level.Debug(b.logger).Log("msg", out)
-			_, err = b.telegram.Send(chat, b.truncateMessage(out), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
-			if err != nil {
-				level.Warn(b.logger).Log("msg", "failed to send message with alerts", "err", err)
-				continue
-			}
+// handleStart subscribes the chat, with the settings of an invite or an expiry if the payload has one.
+func (b *Bot) handleStart(message *telebot.Message) error {
+	if inviteStart(message) {
+		started, err := b.startInvited(message)
+		if started || !b.isAdminID(message.Sender.ID) {
+			return err
 		}
+		// Admins get the plain /start.
 	}
-}
-
-func (b *Bot) handleStart(message *telebot.Message) error {
+	expiry, expiring, err := startExpiry(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, b.responseText("expire_usage", b.localResponseContext(message))))
+		return err
+	}
+	previous, _ := b.chats.GetChatInfo(message.Chat.ID)
 	if err := b.chats.AddChat(message.Chat, b.environmentsAndOther, b.projectsAndOther); err != nil {
 		level.Warn(b.logger).Log("msg", "failed to add chat to chat store", "err", err)
-		_, err = b.telegram.Send(message.Chat, "I can't add this chat to the subscribers list.")
+		reply := b.responseText("start_failed", b.localResponseContext(message))
+		if errors.Is(err, ErrStoreUnavailable) {
+			reply = b.storeErrorReply(err, "")
+		}
+		_, err = b.replyError(message, storeErrorCategory(err, ""), reply)
 		return err
 	}
 
@@ -562,29 +1340,41 @@ func (b *Bot) handleStart(message *telebot.Message) error {
 		"chat_id", message.Chat.ID,
 	)
 
-	if message.Chat.Type == telebot.ChatPrivate {
-		if len(message.Sender.FirstName) > 0 {
-			_, err := b.telegram.Send(message.Chat, fmt.Sprintf(responseStartPrivate, message.Sender.FirstName))
-			return err
-		} else {
-			_, err := b.telegram.Send(message.Chat, responseStartPrivateAnonymous)
+	if expiring {
+		if err := b.chats.SetExpiry(message.Chat, time.Now().Add(expiry)); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to set expiry", "err", err)
+			_, err = b.replyStoreError(message, err, "set the expiry of this chat")
 			return err
 		}
+	}
 
+	if err := b.detectLanguage(message, previous); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set the language of the chat", "err", err)
+	}
+
+	if message.Chat.Type == telebot.ChatPrivate {
+		_, err = b.respond(message, "start_private", responseContext(message))
 	} else {
-		_, err := b.telegram.Send(message.Chat, responseStartGroup)
+		_, err = b.respond(message, "start_group", responseContext(message))
+	}
+	if err != nil || !b.setupWizardEnabled {
 		return err
 	}
+	return b.startSetup(message.Chat)
 }
 
 func (b *Bot) handleStop(message *telebot.Message) error {
 	if err := b.chats.RemoveChat(message.Chat); err != nil {
 		level.Warn(b.logger).Log("msg", "failed to remove chat from chat store", "err", err)
-		_, err = b.telegram.Send(message.Chat, "I can't remove this chat from the subscribers list.")
+		reply := b.responseText("stop_failed", b.localResponseContext(message))
+		if errors.Is(err, ErrStoreUnavailable) {
+			reply = b.storeErrorReply(err, "")
+		}
+		_, err = b.replyError(message, storeErrorCategory(err, ""), reply)
 		return err
 	}
 
-	_, err := b.telegram.Send(message.Chat, fmt.Sprintf(responseStop, message.Sender.FirstName))
+	_, err := b.respond(message, "stop", responseContext(message))
 	level.Info(b.logger).Log(
 		"msg", "user unsubscribed",
 		"username", message.Sender.Username,
@@ -594,7 +1384,7 @@ func (b *Bot) handleStop(message *telebot.Message) error {
 }
 
 func (b *Bot) handleHelp(message *telebot.Message) error {
-	_, err := b.telegram.Send(message.Chat, ResponseHelp)
+	_, err := b.reply(message, localizedHelp(b.chatLanguage(message.Chat))+b.customHelp())
 	return err
 }
 
@@ -602,37 +1392,40 @@ func (b *Bot) handleChats(message *telebot.Message) error {
 	chats, err := b.chats.List()
 	if err != nil {
 		level.Warn(b.logger).Log("msg", "failed to list chats from chat store", "err", err)
-		_, err = b.telegram.Send(message.Chat, "I can't list the subscribed chats.")
+		reply := "I can't list the subscribed chats."
+		if errors.Is(err, ErrStoreUnavailable) {
+			reply = b.storeErrorReply(err, "")
+		}
+		_, err = b.replyError(message, storeErrorCategory(err, ""), reply)
 		return err
 	}
 
 	if len(chats) == 0 {
-		_, err = b.telegram.Send(message.Chat, "Currently no one is subscribed.")
+		_, err = b.reply(message, "Currently no one is subscribed.")
 		return err
 	}
 
 	list := ""
 	for _, chat := range chats {
 		if chat.Chat.Type == telebot.ChatGroup {
-			list = list + fmt.Sprintf("@%s\n", chat.Chat.Title)
+			list = list + fmt.Sprintf("@%s", chat.Chat.Title)
 		} else if len(chat.Chat.Username) > 0 {
-			list = list + fmt.Sprintf("@%s\n", chat.Chat.Username)
+			list = list + fmt.Sprintf("@%s", chat.Chat.Username)
 		} else {
-			list = list + fmt.Sprintf("@%d\n", chat.Chat.ID)
+			list = list + fmt.Sprintf("@%d", chat.Chat.ID)
+		}
+		if chat.Unreachable {
+			list = list + fmt.Sprintf(" (unreachable since %s)", chat.UnreachableSince.UTC().Format("2006-01-02 15:04 UTC"))
 		}
+		list = list + "\n"
 	}
 
-	_, err = b.telegram.Send(message.Chat, "Currently these chat have subscribed:\n"+list)
+	_, err = b.reply(message, "Currently these chat have subscribed:\n"+list)
 	return err
 }
 
 func (b *Bot) handleID(message *telebot.Message) error {
-	if message.Private() {
-		_, err := b.telegram.Send(message.Chat, fmt.Sprintf("Your ID is %d", message.Sender.ID))
-		return err
-	}
-
-	_, err := b.telegram.Send(message.Chat, fmt.Sprintf("Your ID is %d\nChat ID is %d", message.Sender.ID, message.Chat.ID))
+	_, err := b.replyFormatted(message, formatIDRows(idRows(message)), &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
 	return err
 }
 
@@ -640,21 +1433,30 @@ func (b *Bot) handleStatus(message *telebot.Message) error {
 	status, err := b.alertmanager.Status(context.TODO())
 	if err != nil {
 		level.Warn(b.logger).Log("msg", "failed to get status", "err", err)
-		_, err = b.telegram.Send(message.Chat, fmt.Sprintf("failed to get status... %v", err))
+		_, err = b.respondFailed(message, "get status", err)
 		return err
 	}
 
 	uptime := durafmt.Parse(time.Since(time.Time(*status.Uptime)))
 	uptimeBot := durafmt.Parse(time.Since(b.startTime))
 
-	_, err = b.telegram.Send(
-		message.Chat,
-		fmt.Sprintf(
-			"*AlertManager*\nVersion: %s\nUptime: %s\n*AlertManager Bot*\nVersion: %s\nUptime: %s",
+	var banner string
+	if m := b.activeMaintenance(time.Now()); m != nil {
+		banner = escapeMarkdown(m.banner()) + "\n\n"
+	}
+
+	_, err = b.replyFormatted(
+		message,
+		banner+fmt.Sprintf(
+			"*AlertManager*\nVersion: %s\nUptime: %s\nCluster: %s\n*AlertManager Bot*\nVersion: %s\nUptime: %s\nUnlabeled alerts: %s\n\n%s\n\n%s",
 			*status.VersionInfo.Version,
 			uptime,
+			b.clusterLine(status),
 			b.revision,
 			uptimeBot,
+			b.unlabeledStatus(),
+			b.latency.status(time.Now()),
+			statusChecks(b.Checks(context.TODO())),
 		),
 		&telebot.SendOptions{ParseMode: telebot.ModeMarkdown},
 	)
@@ -671,18 +1473,29 @@ func (b *Bot) handleMuteDel(message *telebot.Message) error {
 		)
 		return nil
 	} else {
-		envsToUnmute, prsToUnmute, err := parseUnmuteCommand(message.Text)
+		envsToUnmute, prsToUnmute, inferred, err := b.targetsFromReply(message)
 		if err != nil {
-			b.telegram.Send(message.Chat, fmt.Sprintf("failed to parse unmute command... %v", err))
+			if errors.Is(err, ErrMessageNotFound) {
+				b.respond(message, "reply_context_unknown", responseContext(message))
+				return nil
+			}
+			b.respondError(message, "unmute_infer_failed", err)
 			return err
 		}
+		if !inferred {
+			envsToUnmute, prsToUnmute, err = parseUnmuteCommand(message.Text)
+			if err != nil {
+				b.respondError(message, "unmute_parse_failed", err)
+				return err
+			}
+		}
 
 		if len(envsToUnmute) > 0 {
 			for _, env := range envsToUnmute {
 				err := b.chats.UnmuteEnvironment(message.Chat, env, b.environmentsAndOther)
 				if err != nil {
 					level.Warn(b.logger).Log("msg", "failed to unsubscribe user from an environment", "err", err)
-					b.telegram.Send(message.Chat, fmt.Sprintf("failed to unsubscribe user from an environment... %v", err))
+					b.replyStoreError(message, err, "unsubscribe user from an environment")
 				}
 			}
 		}
@@ -692,28 +1505,48 @@ func (b *Bot) handleMuteDel(message *telebot.Message) error {
 				err := b.chats.UnmuteProject(message.Chat, pr, b.projectsAndOther)
 				if err != nil {
 					level.Warn(b.logger).Log("msg", "failed to unsubscribe user from a project", "err", err)
-					b.telegram.Send(message.Chat, fmt.Sprintf("failed to unsubscribe user from a project... %v", err))
+					b.replyStoreError(message, err, "unsubscribe user from a project")
 				}
 			}
 		}
 
-		b.telegram.Send(message.Chat, "You were successfully delete mute from environments and/or projects")
+		ctx, name := responseContext(message), "unmute_done"
+		if inferred {
+			ctx.Args, name = describeTargets(envsToUnmute, prsToUnmute), "unmute_inferred"
+		}
+		b.respond(message, name, ctx)
 	}
 	return nil
 }
 
 func (b *Bot) handleAlerts(message *telebot.Message) error {
 
-	chats, err := b.chats.List()
-	if err != nil {
-		level.Warn(b.logger).Log("msg", "empty alert list - ", "err", err)
-	}
-	receiver, err := receiverFromConfig(chats, message.Chat.ID)
-	if err != nil || receiver == "" {
-		_, err := b.telegram.Send(message.Chat, fmt.Sprintf(responseAlertsNotConfigured, message.Chat.ID), &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
+	chatInfo, err := b.chats.GetChatInfo(message.Chat.ID)
+	if errors.Is(err, ErrChatNotFound) {
+		_, err := b.replyFormatted(message, b.responseText("alerts_not_configured", b.localResponseContext(message)), &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
 		level.Warn(b.logger).Log("msg", "alerts not configured - ", "err", err)
 		return err
 	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat", "err", err)
+		_, err = b.replyStoreError(message, err, "get the receiver of this chat")
+		return err
+	}
+	resolved := b.resolveReceiver(context.TODO(), chatInfo)
+	receiver := resolved.Name
+	if !b.checkReceiver(message, receiver) {
+		return nil
+	}
+	if resolved.Source == receiverDiscovered {
+		b.offerReceiver(message, receiver)
+	}
+
+	if strings.Contains(message.Payload, "inhibited") {
+		return b.handleInhibitedAlerts(message, receiver)
+	}
+	if hasModifier(message.Payload, groupedModifier) {
+		return b.handleGroupedAlerts(message, receiver)
+	}
 
 	silenced := false
 	if strings.Contains(message.Payload, "silenced") {
@@ -723,51 +1556,55 @@ func (b *Bot) handleAlerts(message *telebot.Message) error {
 	alerts, err := b.alertmanager.ListAlerts(context.TODO(), receiver, silenced)
 	if err != nil {
 		level.Warn(b.logger).Log("msg", "failed to list alerts", "err", err)
-		_, err = b.telegram.Send(message.Chat, fmt.Sprintf("failed to list alerts... %v", err))
+		_, err = b.replyError(message, "failed to list alerts", b.failedText(message, "list alerts", err))
 		return err
 	}
 
 	if len(alerts) == 0 {
-		_, err = b.telegram.Send(message.Chat, "No alerts right now! 🎉")
+		_, err = b.respond(message, "no_alerts", responseContext(message))
 		return err
 	}
 
-	out, err := b.tmplAlerts(alerts...)
+	if hasModifier(message.Payload, exportModifier) {
+		now := time.Now()
+		out, err := b.renderAlertsExport(message.Chat, chatInfo, alerts, now)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to export alerts", "err", err)
+			_, err = b.respondFailed(message, "export alerts", err)
+			return err
+		}
+		_, err = b.reply(message, exportDocument("alerts", message.Chat, now, out))
+		return err
+	}
+
+	var out string
+	if silenced {
+		out, err = b.tmplAlertsWithSilences(chatInfo, alerts)
+	} else {
+		out, err = b.tmplAlerts(chatInfo, alerts...)
+	}
 	if err != nil {
 		level.Warn(b.logger).Log("msg", "failed to template alerts", "err", err)
 		return nil
 	}
 
-	_, err = b.telegram.Send(message.Chat, b.truncateMessage(out), &telebot.SendOptions{
+	out = b.staleSummary(alerts, time.Now()) + out
+
+	_, err = b.replyFormatted(message, b.truncateMessage(out), &telebot.SendOptions{
 		ParseMode: telebot.ModeHTML,
 	})
 	return err
 }
 
-func receiverFromConfig(l []ChatInfo, id int64) (string, error) {
-	if len(l) == 0 {
-		return "", fmt.Errorf("list of chats is empty")
-	}
-
-	for ind := range l {
-		chatId := l[ind].Chat.ID
-		if chatId == id {
-			return "/webhooks/telegram/" + strconv.FormatInt(l[ind].Chat.ID, 10), nil
-		}
-	}
-
-	return "", nil
-}
-
 func (b *Bot) handleSilences(message *telebot.Message) error {
 	silences, err := b.alertmanager.ListSilences(context.TODO())
 	if err != nil {
-		_, err = b.telegram.Send(message.Chat, fmt.Sprintf("failed to list silences... %v", err))
+		_, err = b.respondFailed(message, "list silences", err)
 		return err
 	}
 
 	if len(silences) == 0 {
-		_, err = b.telegram.Send(message.Chat, "No silences right now.")
+		_, err = b.reply(message, "No silences right now.")
 		return err
 	}
 
@@ -776,72 +1613,46 @@ func (b *Bot) handleSilences(message *telebot.Message) error {
 		out = out + alertmanager.SilenceMessage(silence) + "\n"
 	}
 
-	_, err = b.telegram.Send(message.Chat, out, &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
+	_, err = b.replyFormatted(message, out, &telebot.SendOptions{
+		ParseMode:   telebot.ModeMarkdown,
+		ReplyMarkup: silenceExtendMarkup(silences),
+	})
 	return err
 }
 
-func (b *Bot) tmplAlerts(alerts ...*types.Alert) (string, error) {
-	data := b.templates.Data("default", nil, alerts...)
-
-	out, err := b.templates.ExecuteHTMLString(`{{ template "telegram.default" . }}`, data)
-	if err != nil {
-		return "", err
-	}
+func (b *Bot) tmplAlerts(chatInfo *ChatInfo, alerts ...*types.Alert) (string, error) {
+	data := b.redactData(chatInfo, b.alertsData(alerts...))
+	data.ExternalURL = externalURL(chatInfo, data.ExternalURL)
 
-	return out, nil
+	td := b.newTemplateData(chatInfo, data)
+	td.UpdatedAt = alertsUpdatedAt(alerts)
+	return b.renderAlertsOrPlain(b.logger, td), nil
 }
 
 func parseMuteCommand(text string) ([]string, []string, error) {
-	return parseCommands(text, ProjectAndEnvironmentMuteRegexp, MuteEnvironmentRegexp, MuteProjectRegexp)
+	return parseMuteTargets(text)
 }
 
 func parseUnmuteCommand(text string) ([]string, []string, error) {
-	return parseCommands(text, ProjectAndEnvironmentUnmuteRegexp, UnmuteEnvironmentRegexp, UnmuteProjectRegexp)
-}
-
-func parseCommands(text string, projectAndEnvironmentRegexp string, environmentRegexp string,
-	projectRegexp string) ([]string, []string, error) {
-	matchProjectAndEnvironment, err := regexp.MatchString(projectAndEnvironmentRegexp, text)
-	if err != nil {
-		return []string{}, []string{}, err
-	}
-
-	regexProject, err := regexp.Compile(ProjectValuesRegexp)
-	regexEnvironment, err := regexp.Compile(EnvironmentValuesRegexp)
-
-	if matchProjectAndEnvironment {
-		env := strings.Replace(regexEnvironment.FindStringSubmatch(text)[1], " ", "", -1)
-		environmentsToMute := strings.Split(env, ",")
-
-		p := strings.Replace(regexProject.FindStringSubmatch(text)[1], " ", "", -1)
-		projectsToMute := strings.Split(p, ",")
-		return environmentsToMute, projectsToMute, nil
-	}
-
-	matchEnvironment, err := regexp.MatchString(environmentRegexp, text)
-	if matchEnvironment {
-		env := strings.Replace(regexEnvironment.FindStringSubmatch(text)[1], " ", "", -1)
-		environmentsToMute := strings.Split(env, ",")
-		return environmentsToMute, []string{}, nil
-	}
-
-	matchProject, err := regexp.MatchString(projectRegexp, text)
-	if matchProject {
-		p := strings.Replace(regexProject.FindStringSubmatch(text)[1], " ", "", -1)
-		projectsToRemove := strings.Split(p, ",")
-		return []string{}, projectsToRemove, nil
-	}
-
-	return []string{}, []string{}, errors.New("no matches were found")
+	return parseMuteTargets(text)
 }
 
 // Truncate very big message.
 func (b *Bot) truncateMessage(str string) string {
+	return b.truncateMessageTo(str, telegramMessageMaxLength) // telegram API can only support 4096 bytes per message
+}
+
+// truncateMessageTo truncates a message to at most max bytes, leaving room for text appended to it.
+func (b *Bot) truncateMessageTo(str string, max int) string {
 	truncateMsg := str
-	if len(str) > 4095 { // telegram API can only support 4096 bytes per message
-		level.Warn(b.logger).Log("msg", "Message is bigger than 4095, truncate...")
+	if len(str) > max {
+		level.Warn(b.logger).Log("msg", fmt.Sprintf("Message is bigger than %d, truncate...", max))
 		// find the end of last alert, we do not want break the html tags
-		i := strings.LastIndex(str[0:4080], "\n\n") // 4080 + "\n<b>[SNIP]</b>" == 4095
+		end := max - len("\n<b>[SNIP]</b>")
+		if end < 0 {
+			end = 0
+		}
+		i := strings.LastIndex(str[0:end], "\n\n")
 		if i > 1 {
 			truncateMsg = str[0:i] + "\n<b>[SNIP]</b>"
 		} else {
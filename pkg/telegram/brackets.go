@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// bracketGroup is a keyword[value, ...] group of a command, like environment[prod, staging].
+// Groups without values, like project[], have no values.
+type bracketGroup struct {
+	Keyword string
+	Values  []string
+}
+
+// parseBracketArgs parses keyword[value, ...] groups in any order, separated by spaces or commas. A leading
+// command like /mute is skipped. Values may contain letters, digits, -, . and _, others have to be quoted like
+// "a, b", with \" and \\ escaping a quote and a backslash. Keywords given twice are rejected, as are
+// keywords that aren't among the given ones, unless there are none given.
+func parseBracketArgs(text string, keywords ...string) ([]bracketGroup, error) {
+	p := bracketParser{text: text}
+	if strings.HasPrefix(text, "/") {
+		p.pos = strings.IndexAny(text, " \t\n")
+		if p.pos < 0 {
+			p.pos = len(text)
+		}
+	}
+
+	var groups []bracketGroup
+	seen := map[string]bool{}
+	for {
+		p.skip(" \t\n,")
+		if p.done() {
+			return groups, nil
+		}
+		keyword, err := p.keyword()
+		if err != nil {
+			return nil, err
+		}
+		if len(keywords) > 0 && !contains(keywords, keyword) {
+			return nil, fmt.Errorf("unknown %s[], use %s", keyword, bracketUsage(keywords))
+		}
+		if seen[keyword] {
+			return nil, fmt.Errorf("%s[] is given twice, put all its values into one %s[...]", keyword, keyword)
+		}
+		values, err := p.values(keyword)
+		if err != nil {
+			return nil, err
+		}
+		seen[keyword] = true
+		groups = append(groups, bracketGroup{Keyword: keyword, Values: values})
+	}
+}
+
+// parseMuteTargets reads the environment[...] and project[...] of commands like /mute, at least one has to be given.
+func parseMuteTargets(text string) ([]string, []string, error) {
+	groups, err := parseBracketArgs(text, labelEnvironment, labelProject)
+	if err != nil {
+		return nil, nil, err
+	}
+	var envs, prs []string
+	for _, g := range groups {
+		if len(g.Values) == 0 {
+			return nil, nil, fmt.Errorf("%s[] is empty", g.Keyword)
+		}
+		if g.Keyword == labelEnvironment {
+			envs = g.Values
+		} else {
+			prs = g.Values
+		}
+	}
+	if len(envs) == 0 && len(prs) == 0 {
+		return nil, nil, errors.New("no environment[...] or project[...] given")
+	}
+	return envs, prs, nil
+}
+
+// bracketUsage lists the keywords like environment[...] or project[...].
+func bracketUsage(keywords []string) string {
+	groups := make([]string, len(keywords))
+	for i, k := range keywords {
+		groups[i] = k + "[...]"
+	}
+	return strings.Join(groups, " or ")
+}
+
+// bracketParser reads a command's bracket groups byte by byte.
+type bracketParser struct {
+	text string
+	pos  int
+}
+
+func (p *bracketParser) done() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *bracketParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.text[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// near quotes the text from the current position for errors, cut short if it's long.
+func (p *bracketParser) near() string {
+	rest := p.text[p.pos:]
+	if len(rest) > 20 {
+		rest = rest[:20] + "…"
+	}
+	return fmt.Sprintf("%q", rest)
+}
+
+func isKeywordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isValueByte(c byte) bool {
+	return isKeywordByte(c) || c == '-' || c == '.'
+}
+
+// keyword reads a keyword and the [ after it.
+func (p *bracketParser) keyword() (string, error) {
+	start := p.pos
+	for !p.done() && isKeywordByte(p.text[p.pos]) {
+		p.pos++
+	}
+	keyword := p.text[start:p.pos]
+	if keyword == "" {
+		return "", fmt.Errorf("expected keyword[...] at %s", p.near())
+	}
+	if p.done() || p.text[p.pos] != '[' {
+		return "", fmt.Errorf("expected [ after %s", keyword)
+	}
+	p.pos++
+	return keyword, nil
+}
+
+// values reads the values of a group up to and including its ].
+func (p *bracketParser) values(keyword string) ([]string, error) {
+	var values []string
+	for {
+		p.skip(" \t\n")
+		if p.done() {
+			return nil, fmt.Errorf("%s[ isn't closed with ]", keyword)
+		}
+		if p.text[p.pos] == ']' && len(values) == 0 {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.value(keyword)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skip(" \t\n")
+		switch {
+		case p.done():
+			return nil, fmt.Errorf("%s[ isn't closed with ]", keyword)
+		case p.text[p.pos] == ']':
+			p.pos++
+			return values, nil
+		case p.text[p.pos] == ',':
+			p.pos++
+		default:
+			return nil, fmt.Errorf("expected , or ] in %s[...] at %s, quote values with other characters", keyword, p.near())
+		}
+	}
+}
+
+// value reads a bare or quoted value.
+func (p *bracketParser) value(keyword string) (string, error) {
+	if p.text[p.pos] != '"' {
+		start := p.pos
+		for !p.done() && isValueByte(p.text[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start {
+			return "", fmt.Errorf("expected a value in %s[...] at %s, quote values with other characters", keyword, p.near())
+		}
+		return p.text[start:p.pos], nil
+	}
+
+	p.pos++
+	var value strings.Builder
+	for !p.done() {
+		c := p.text[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			if value.Len() == 0 {
+				return "", fmt.Errorf("empty value in %s[...]", keyword)
+			}
+			return value.String(), nil
+		case c == '\\' && !p.done() && (p.text[p.pos] == '"' || p.text[p.pos] == '\\'):
+			value.WriteByte(p.text[p.pos])
+			p.pos++
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("quote in %s[...] isn't closed", keyword)
+}
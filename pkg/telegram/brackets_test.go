@@ -0,0 +1,158 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestParseBracketArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		keywords []string
+		expected []bracketGroup
+		err      string
+	}{
+		{
+			name:     "single group",
+			text:     "environment[prod]",
+			expected: []bracketGroup{{Keyword: "environment", Values: []string{"prod"}}},
+		},
+		{
+			name: "command is skipped",
+			text: "/mute@alertmanager_bot environment[prod, staging], project[billing]",
+			expected: []bracketGroup{
+				{Keyword: "environment", Values: []string{"prod", "staging"}},
+				{Keyword: "project", Values: []string{"billing"}},
+			},
+		},
+		{
+			name: "any order",
+			text: "project[billing] environment[prod]",
+			expected: []bracketGroup{
+				{Keyword: "project", Values: []string{"billing"}},
+				{Keyword: "environment", Values: []string{"prod"}},
+			},
+		},
+		{
+			name:     "hyphens and dots",
+			text:     "environment[us-east-1, eu.west_2] project[v1.2]",
+			expected: []bracketGroup{{Keyword: "environment", Values: []string{"us-east-1", "eu.west_2"}}, {Keyword: "project", Values: []string{"v1.2"}}},
+		},
+		{
+			name:     "spaces around values",
+			text:     "environment[  prod ,staging  ]",
+			expected: []bracketGroup{{Keyword: "environment", Values: []string{"prod", "staging"}}},
+		},
+		{
+			name:     "quoted values",
+			text:     `project["billing, EU", "say \"hi\"", "back\\slash"]`,
+			expected: []bracketGroup{{Keyword: "project", Values: []string{"billing, EU", `say "hi"`, `back\slash`}}},
+		},
+		{
+			name:     "empty group",
+			text:     "project[]",
+			expected: []bracketGroup{{Keyword: "project"}},
+		},
+		{
+			name: "nothing",
+			text: "/mute",
+		},
+		{
+			name:     "only allowed keywords",
+			text:     "team[payments]",
+			keywords: []string{"environment", "project"},
+			err:      "unknown team[], use environment[...] or project[...]",
+		},
+		{
+			name: "duplicate keyword",
+			text: "environment[prod] environment[staging]",
+			err:  "environment[] is given twice, put all its values into one environment[...]",
+		},
+		{
+			name: "not a group",
+			text: "/mute prod",
+			err:  "expected [ after prod",
+		},
+		{
+			name: "stray text",
+			text: "environment[prod] !",
+			err:  `expected keyword[...] at "!"`,
+		},
+		{
+			name: "unclosed group",
+			text: "environment[prod, staging",
+			err:  "environment[ isn't closed with ]",
+		},
+		{
+			name: "space inside a value",
+			text: "environment[us east]",
+			err:  `expected , or ] in environment[...] at "east]", quote values with other characters`,
+		},
+		{
+			name: "other characters",
+			text: "environment[prod/1]",
+			err:  `expected , or ] in environment[...] at "/1]", quote values with other characters`,
+		},
+		{
+			name: "empty value",
+			text: "environment[prod,,staging]",
+			err:  `expected a value in environment[...] at ",staging]", quote values with other characters`,
+		},
+		{
+			name: "trailing comma",
+			text: "environment[prod,]",
+			err:  `expected a value in environment[...] at "]", quote values with other characters`,
+		},
+		{
+			name: "empty quotes",
+			text: `environment[""]`,
+			err:  "empty value in environment[...]",
+		},
+		{
+			name: "unclosed quote",
+			text: `environment["prod]`,
+			err:  "quote in environment[...] isn't closed",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, err := parseBracketArgs(tc.text, tc.keywords...)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, groups)
+		})
+	}
+}
+
+func TestParseMuteTargetsErrors(t *testing.T) {
+	_, _, err := parseMuteTargets("/mute")
+	require.EqualError(t, err, "no environment[...] or project[...] given")
+	_, _, err = parseMuteTargets("/mute environment[]")
+	require.EqualError(t, err, "environment[] is empty")
+	_, _, err = parseMuteTargets("/mute severity[critical]")
+	require.EqualError(t, err, "unknown severity[], use environment[...] or project[...]")
+}
+
+func TestMuteHyphenatedValues(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithEnvironments("us-east-1,eu-west-1"), WithProjects("v1.2"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute project[v1.2] environment[us-east-1]"}))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"us-east-1"}, ci.MutedEnvironments)
+	require.Equal(t, []string{"v1.2"}, ci.MutedProjects)
+
+	require.Error(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute environment[us-east-1] environment[eu-west-1]"}))
+	require.Equal(t, "failed to parse mute command... environment[] is given twice, put all its values into one environment[...]", tb.lastText())
+
+	require.NoError(t, b.handleMuteDel(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute_del environment[us-east-1]"}))
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.MutedEnvironments)
+}
@@ -0,0 +1,110 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+)
+
+// errNoTemplates is returned rendering alerts before templates are loaded.
+var errNoTemplates = errors.New("no templates loaded")
+
+// builtinTemplate renders alerts when WithTemplates isn't given.
+const builtinTemplate = `{{ define "telegram.default" }}
+{{ range .Alerts }}
+{{ if eq .Status "firing" }}🔥{{ else }}✅{{ end }} <b>{{ label "alertname" . }}</b>{{ with index $.Seen .Fingerprint }}
+{{ if .New }}🆕 new{{ else }}seen before: first at {{ (.FirstNotified.In $.Chat.Location).Format "2006-01-02 15:04 MST" }}, {{ .Notifications }} notifications{{ end }}{{ end }}
+<b>Labels:</b>{{ range sortedLabelPairs .Labels "alertname" }}
+    {{ .Name }}: {{ .Value }}{{ end }}{{ if .Annotations }}
+<b>Annotations:</b>{{ range .Annotations.SortedPairs }}
+    {{ .Name }}: {{ .Value }}{{ end }}{{ end }}
+{{ end }}
+{{ end }}`
+
+// writeTemplateFile writes a template the bot comes with to a temporary file, the Alertmanager template package
+// only parses files. The returned func removes it.
+func writeTemplateFile(text string) (string, func(), error) {
+	f, err := ioutil.TempFile("", "alertmanager-bot-*.tmpl")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { os.Remove(f.Name()) }
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		remove()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
+
+// parseBuiltinTemplate parses builtinTemplate with the format presets.
+func parseBuiltinTemplate(externalURL *url.URL, funcs template.FuncMap) (*alertTemplates, error) {
+	path, remove, err := writeTemplateFile(presetTemplates + builtinTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the built-in template: %w", err)
+	}
+	defer remove()
+
+	tmpl, err := parseTemplateGlobs(funcs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the built-in template: %w", err)
+	}
+	tmpl.ExternalURL = externalURL
+	return tmpl, nil
+}
+
+// alertsData is the template data of the alerts, also without templates.
+func (b *Bot) alertsData(alerts ...*types.Alert) *template.Data {
+	// Data only uses the external URL of the templates.
+	externalURL := &url.URL{}
+	if b.templates != nil && b.templates.ExternalURL != nil {
+		externalURL = b.templates.ExternalURL
+	}
+	return (&template.Template{ExternalURL: externalURL}).Data("default", nil, alerts...)
+}
+
+// renderAlertsOrPlain renders the alerts like renderTemplateData, or as plain text if the templates fail
+// or panic, so that alerts still get through. Each distinct failure is logged once, later ones at debug level.
+func (b *Bot) renderAlertsOrPlain(logger log.Logger, td *templateData) string {
+	out, err := b.renderTemplateData(td)
+	if err != nil {
+		name := formatTemplate(td.Chat.Format)
+		logf := level.Debug(logger).Log
+		if b.templateFailures.first(name, err) {
+			logf = level.Warn(logger).Log
+		}
+		logf("msg", "failed to template alerts, sending them as plain text", "template", name, "data", summarizeTemplateData(td), "err", err)
+		return plainAlerts(td.Data)
+	}
+	return out
+}
+
+// plainAlerts renders the alerts without templates, escaped for HTML messages.
+func plainAlerts(data *template.Data) string {
+	var b strings.Builder
+	for _, a := range data.Alerts {
+		fmt.Fprintf(&b, "\n[%s] %s\n", strings.ToUpper(a.Status), html.EscapeString(a.Labels["alertname"]))
+		for _, p := range a.Labels.SortedPairs() {
+			if p.Name != "alertname" {
+				fmt.Fprintf(&b, "    %s: %s\n", html.EscapeString(p.Name), html.EscapeString(p.Value))
+			}
+		}
+		for _, p := range a.Annotations.SortedPairs() {
+			fmt.Fprintf(&b, "    %s: %s\n", html.EscapeString(p.Name), html.EscapeString(p.Value))
+		}
+	}
+	return b.String()
+}
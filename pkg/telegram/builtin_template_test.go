@@ -0,0 +1,50 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+)
+
+func TestWebhookWithoutTemplates(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := mixedWebhook("firing", template.Alert{
+		Status:      "firing",
+		Labels:      template.KV{"alertname": "HighCPU", "environment": "prod"},
+		Annotations: template.KV{"summary": "CPU is <busy>"},
+	})
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "🔥 <b>HighCPU</b>\n<b>Labels:</b>\n    environment: prod")
+	require.Contains(t, tb.lastText(), "summary: CPU is &lt;busy&gt;", "the built-in template escapes like the configured ones")
+
+	b.templates = nil
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 2, "alerts are sent as plain text without any templates")
+	require.Contains(t, tb.lastText(), "[FIRING] HighCPU\n    environment: prod\n    summary: CPU is &lt;busy&gt;")
+}
+
+func TestWebhookWithFailingTemplate(t *testing.T) {
+	failing := filepath.Join(t.TempDir(), "failing.tmpl")
+	require.NoError(t, ioutil.WriteFile(failing, []byte(`{{ define "telegram.default" }}{{ template "missing" . }}{{ end }}`), 0o644))
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, failing))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	_, err := b.processWebhook(context.Background(), mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}}))
+	require.NoError(t, err)
+	require.Contains(t, tb.lastText(), "[FIRING] HighCPU")
+
+	out, err := b.tmplAlerts(nil, alertmanagertest.Alert("DiskFull").Environment("prod").Build())
+	require.NoError(t, err, "/alerts falls back to plain text as well")
+	require.Contains(t, out, "DiskFull\n    environment: prod")
+}
@@ -1,8 +1,12 @@
 package telegram
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/tucnak/telebot.v2"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ChatInfo struct {
@@ -11,50 +15,171 @@ type ChatInfo struct {
 	AlertProjects     []string
 	MutedEnvironments []string
 	MutedProjects     []string
+	// FailedSends counts consecutive failed alert deliveries to the chat.
+	FailedSends int `json:",omitempty"`
+	// FallbackChatID gets the chat's alerts once FailedSends reaches the failover threshold, 0 is none.
+	FallbackChatID int64 `json:",omitempty"`
+	// ArchiveChatID gets copies of the chat's alert messages before they're deleted or once their alerts resolved, 0 is none.
+	ArchiveChatID int64 `json:",omitempty"`
+	// SendResolved overrides if the chat gets notified about resolved alerts, nil uses the bot's default.
+	SendResolved *bool `json:",omitempty"`
+	// IssueButtonsOff opts the chat out of "Create issue" buttons.
+	IssueButtonsOff bool `json:",omitempty"`
+	// ExternalURL overrides the Alertmanager URL used in links of the chat's alerts.
+	ExternalURL string `json:",omitempty"`
+	// RedactStrict drops annotations and all but a few labels from the chat's alerts.
+	RedactStrict bool `json:",omitempty"`
+	// MaxAlertsPerMessage overrides the number of alerts a message shows in full, 0 uses the bot's default.
+	MaxAlertsPerMessage int `json:",omitempty"`
+	// Unreachable is set when reconciliation found Telegram doesn't know the chat anymore, it gets no alerts then.
+	Unreachable      bool      `json:",omitempty"`
+	UnreachableSince time.Time `json:",omitempty"`
+	// MinSeverity drops alerts less severe than it, empty sends all.
+	MinSeverity string `json:",omitempty"`
+	// Timezone is the IANA name of the chat's timezone, empty is UTC.
+	Timezone string `json:",omitempty"`
+	// ParseMode is how messages to the chat are formatted, html, markdownv2 or plain, empty is html.
+	ParseMode string `json:",omitempty"`
+	// Protected chats can only be changed by global admins, or by admins once a second admin confirmed.
+	Protected bool `json:",omitempty"`
+	// DebugUntil logs everything about the chat at debug level until then, whatever the bot's log level is.
+	DebugUntil time.Time `json:",omitempty"`
+	// EscalationContact is mentioned in the chat's escalations, like @oncall.
+	EscalationContact string `json:",omitempty"`
+	// EscalationThresholds override the bot's escalation thresholds, EscalationOff stops escalations in the chat.
+	EscalationThresholds []time.Duration `json:",omitempty"`
+	EscalationOff        bool            `json:",omitempty"`
+	// Escalated is the highest threshold each firing alert of the chat was escalated for, by fingerprint.
+	Escalated map[string]time.Duration `json:",omitempty"`
+	// Tags group chats for admin commands, which target all chats with a tag by tag:<tag>.
+	Tags []string `json:",omitempty"`
+	// Blocked are the notifications that failed since the user of a private chat blocked the bot.
+	Blocked *BlockedSends `json:",omitempty"`
+	// ExpiresAt ends the chat's subscription, zero never does. ExpiryWarned is set once the chat was warned about it.
+	ExpiresAt    time.Time `json:",omitempty"`
+	ExpiryWarned bool      `json:",omitempty"`
+	// Paused chats get no alerts, like once their subscription expired, until it's renewed.
+	Paused      bool      `json:",omitempty"`
+	PausedSince time.Time `json:",omitempty"`
+	// EchoUntil sends the payloads of the chat's webhooks next to their notifications until then, for debugging.
+	EchoUntil time.Time `json:",omitempty"`
+	// Receiver is the Alertmanager receiver sending the chat its alerts, saved when /alerts found it.
+	// Empty looks for it among Alertmanager's receivers.
+	Receiver string `json:",omitempty"`
+	// Language of the chat's canned replies, like de, empty is English. LanguageExplicit is set when it was
+	// chosen with /lang rather than detected from the user's Telegram client, it's kept when the chat subscribes again.
+	Language         string `json:",omitempty"`
+	LanguageExplicit bool   `json:",omitempty"`
+	// Format is the preset of the chat's notifications set with /format, like compact, empty is normal.
+	Format string `json:",omitempty"`
+	// SeverityRoutes send the chat's alerts with their severities to other chats, the first matching route wins.
+	SeverityRoutes []SeverityRoute `json:",omitempty"`
+	// Debounce holds the chat's firing alerts that long and drops those resolving meanwhile, 0 sends them right away.
+	Debounce time.Duration `json:",omitempty"`
+	// RepeatSuppressions drop the chat's firing alerts with their alertnames while one was delivered within their window.
+	RepeatSuppressions []RepeatSuppression `json:",omitempty"`
 }
 
 func (ch *ChatInfo) UnmuteEnvironment(env string, allEnvs []string) {
-	var index int
+	index := -1
 	for i, value := range ch.MutedEnvironments {
 		if 0 == strings.Compare(value, env) {
 			index = i
 			break
 		}
 	}
+	if index < 0 {
+		return
+	}
 	ch.MutedEnvironments = append(ch.MutedEnvironments[:index], ch.MutedEnvironments[index+1:]...)
 	ch.AlertEnvironments = arrayDifference(allEnvs, ch.MutedEnvironments)
 }
 
 func (ch *ChatInfo) UnmuteProject(pr string, allPrs []string) {
-	var index int
+	index := -1
 	for i, value := range ch.MutedProjects {
 		if 0 == strings.Compare(value, pr) {
 			index = i
 			break
 		}
 	}
+	if index < 0 {
+		return
+	}
 	ch.MutedProjects = append(ch.MutedProjects[:index], ch.MutedProjects[index+1:]...)
 	ch.AlertProjects = arrayDifference(allPrs, ch.MutedProjects)
 }
 
 func (ch *ChatInfo) MuteEnvironments(envsToMute []string, allEnvs []string) {
 	ch.MutedEnvironments = getUniqueStrings(append(ch.MutedEnvironments, envsToMute...))
+	sort.Strings(ch.MutedEnvironments)
 	ch.AlertEnvironments = arrayDifference(allEnvs, ch.MutedEnvironments)
 }
 
 func (ch *ChatInfo) MuteProjects(prsToMute []string, allPrs []string) {
 	ch.MutedProjects = getUniqueStrings(append(ch.MutedProjects, prsToMute...))
+	sort.Strings(ch.MutedProjects)
 	ch.AlertProjects = arrayDifference(allPrs, ch.MutedProjects)
 }
 
+// validate tells why the chat with the ID can't be stored like this, nil if it can.
+func (ch *ChatInfo) validate(id int64) error {
+	switch {
+	case ch.Chat == nil:
+		return errors.New("the chat is missing")
+	case ch.Chat.ID != id:
+		return fmt.Errorf("the chat's ID can't change to %d", ch.Chat.ID)
+	case ch.FallbackChatID == id:
+		return errors.New("a chat can't be its own fallback chat")
+	case ch.ArchiveChatID == id:
+		return errors.New("a chat can't be its own archive")
+	case ch.FailedSends < 0:
+		return fmt.Errorf("the failed deliveries can't be negative, are %d", ch.FailedSends)
+	case ch.MaxAlertsPerMessage < 0:
+		return fmt.Errorf("the alerts per message can't be negative, are %d", ch.MaxAlertsPerMessage)
+	case ch.Debounce < 0:
+		return fmt.Errorf("the debounce can't be negative, is %s", ch.Debounce)
+	}
+	for _, r := range ch.SeverityRoutes {
+		if r.ChatID == id && r.TopicID == 0 {
+			return errors.New("a chat can't route alerts to itself, only to one of its topics")
+		}
+		if r.TopicID < 0 {
+			return fmt.Errorf("the topic of a route can't be negative, is %d", r.TopicID)
+		}
+	}
+	seen := map[string]bool{}
+	for _, r := range ch.RepeatSuppressions {
+		if r.Window <= 0 {
+			return fmt.Errorf("the window of the repeat suppression of %s must be positive, is %s", r.Alertname, r.Window)
+		}
+		if seen[r.Alertname] {
+			return fmt.Errorf("%s has two repeat suppressions", r.Alertname)
+		}
+		seen[r.Alertname] = true
+	}
+	return nil
+}
+
+// getUniqueStrings returns the values without duplicates, in the order they first occur.
 func getUniqueStrings(values []string) []string {
-	uniqueSet := make(map[string]bool, len(values))
+	seen := make(map[string]bool, len(values))
+	uniqueValues := make([]string, 0, len(values))
 	for _, x := range values {
-		uniqueSet[x] = true
-	}
-	uniqueValues := make([]string, 0, len(uniqueSet))
-	for x := range uniqueSet {
-		uniqueValues = append(uniqueValues, x)
+		if !seen[x] {
+			seen[x] = true
+			uniqueValues = append(uniqueValues, x)
+		}
 	}
 	return uniqueValues
 }
+
+// formatList renders values for a reply as a sorted, comma-separated list, "none" if there are none.
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
@@ -1,31 +1,60 @@
 package telegram
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+
 	"github.com/docker/libkv/store"
 	"gopkg.in/tucnak/telebot.v2"
 )
 
 // ChatStore writes the users to a libkv store backend.
 type ChatStore struct {
+	// kvMu guards kv, which is replaced when the backend client is created anew.
+	kvMu           sync.RWMutex
 	kv             store.Store
 	storeKeyPrefix string
+	health         *storeHealth
+	// writes buffers the writes of message records, nil with WithSyncWrites.
+	writes *writeBuffer
+	// receiverMu keeps changes of the receivers of chats from overlapping.
+	receiverMu sync.Mutex
+	// fingerprintsMu keeps the fingerprints from being recorded while they're pruned or compacted.
+	fingerprintsMu sync.Mutex
+	// messagesMu keeps the batches of message records from being written by two at once, and guards batchedChats.
+	messagesMu sync.Mutex
+	// batchedChats are the chats whose records stored at a key each were moved into their batch.
+	batchedChats map[int64]bool
 }
 
-const telegramChatsDirectory = "telegram/chats"
+const (
+	telegramChatsDirectory = "telegram/chats"
+	// maxChatUpdateAttempts is how often UpdateChat applies its change to a chat that's written by others meanwhile.
+	maxChatUpdateAttempts = 5
+)
 
-// const telegramMessagesDirectory = "telegram/messages"
+var (
+	// ErrChatUpdateConflict is returned by UpdateChat when the chat kept being written by others meanwhile.
+	ErrChatUpdateConflict = errors.New("the chat was changed by someone else at the same time, try again")
+	// ErrInvalidChatInfo is wrapped by the errors of UpdateChat rejecting a change, which isn't stored then.
+	ErrInvalidChatInfo = errors.New("invalid chat")
+)
 
 // NewChatStore stores telegram chats in the provided kv backend.
-func NewChatStore(kv store.Store, storeKeyPrefix string) (*ChatStore, error) {
-	return &ChatStore{kv: kv, storeKeyPrefix: storeKeyPrefix}, nil
+func NewChatStore(kv store.Store, storeKeyPrefix string, opts ...ChatStoreOption) (*ChatStore, error) {
+	s := &ChatStore{kv: kv, storeKeyPrefix: storeKeyPrefix, health: newStoreHealth(), writes: newWriteBuffer(), batchedChats: map[int64]bool{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
 }
 
 // List all chats saved in the kv backend.
 func (s *ChatStore) List() ([]ChatInfo, error) {
-	kvPairs, err := s.kv.List(telegramChatsDirectory)
+	kvPairs, err := s.list(telegramChatsDirectory, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +63,7 @@ func (s *ChatStore) List() ([]ChatInfo, error) {
 
 	for _, kv := range kvPairs {
 		var chatInfo ChatInfo
-		if err := json.Unmarshal(kv.Value, &chatInfo); err != nil {
+		if err := decode(kv.Key, kv.Value, &chatInfo); err != nil {
 			return nil, err
 		}
 		chatInfos = append(chatInfos, chatInfo)
@@ -44,211 +73,136 @@ func (s *ChatStore) List() ([]ChatInfo, error) {
 
 // RemoveChat Remove a telegram chat from the kv backend.
 func (s *ChatStore) RemoveChat(c *telebot.Chat) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	return s.kv.Delete(key)
+	return s.delete(chatKey(c.ID))
 }
 
 func (s *ChatStore) Get(id telebot.ChatID) (*telebot.Chat, error, *store.KVPair) {
 	key := fmt.Sprintf("%s/%d", s.storeKeyPrefix, id)
-	kv, err := s.kv.Get(key)
+	kv, err := s.get(key, ErrChatNotFound)
 	if err != nil {
-		if errors.Is(err, store.ErrKeyNotFound) {
-			return nil, ChatNotFoundErr, kv
-		}
 		return nil, err, kv
 	}
 	var ci *ChatInfo
-	err = json.Unmarshal(kv.Value, &ci)
-	return ci.Chat, err, kv
+	if err := decode(key, kv.Value, &ci); err != nil {
+		return nil, err, kv
+	}
+	return ci.Chat, nil, kv
 }
 
 // AddChat Add a telegram chat to the kv backend.
 func (s *ChatStore) AddChat(c *telebot.Chat, allEnvs []string, allPrs []string) error {
 	newChat := ChatInfo{Chat: c, AlertEnvironments: allEnvs, AlertProjects: allPrs,
 		MutedEnvironments: []string{}, MutedProjects: []string{}}
-	info, err := json.Marshal(newChat)
-	if err != nil {
-		return err
-	}
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	return s.kv.Put(key, info, nil)
+	return s.putChatInfo(&newChat)
 }
 
-/*
-func (s *ChatStore) AddMessage(m *telebot.Message) error {
-	messages, err := s.GetAllMessages()
-	if err != nil {
-		return err
-	}
-	messages = append(messages, *m)
-	info, err := json.Marshal(messages)
-	if err != nil {
-		return nil
-	}
-	return s.kv.Put(telegramMessagesDirectory, info, nil)
-}*/
+// GetChatInfo returns the stored ChatInfo of a chat or ErrChatNotFound.
+func (s *ChatStore) GetChatInfo(id int64) (*ChatInfo, error) {
+	ci, _, err := s.getChatInfo(id)
+	return ci, err
+}
 
-/*func (s *ChatStore) GetAllMessages() ([]telebot.Message, error) {
-	kvPair, err := s.kv.Get(telegramMessagesDirectory)
+// getChatInfo returns the stored ChatInfo of a chat and the pair it was read from, to write it back atomically.
+func (s *ChatStore) getChatInfo(id int64) (*ChatInfo, *store.KVPair, error) {
+	kv, err := s.get(chatKey(id), ErrChatNotFound)
 	if err != nil {
-		if 0 == strings.Compare("Key not found in store", err.Error()) {
-			return []telebot.Message{}, nil
-		} else {
-			return nil, err
-		}
-	}
-	var messages []telebot.Message
-	if err = json.Unmarshal(kvPair.Value, &messages); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return messages, nil
-}*/
 
-/*func (s *ChatStore) DeleteAllMessages() error {
-	return s.kv.Delete(telegramMessagesDirectory)
-}*/
-
-/*func (s *ChatStore) GetMessagesForPeriodInMinutes(minutes float64) ([]telebot.Message, error) {
-	messages, err := s.GetAllMessages()
-	if err != nil {
-		return nil, err
+	var chatInfo ChatInfo
+	if err = decode(chatKey(id), kv.Value, &chatInfo); err != nil {
+		return nil, nil, err
 	}
-	var messagesToDelete []telebot.Message
-	var messagesToSave []telebot.Message
-	currentTime := time.Now().UTC()
-	for _, msg := range messages {
-		timeDiff := currentTime.Sub(msg.Time().UTC())
-		if timeDiff.Minutes() >= minutes {
-			messagesToDelete = append(messagesToDelete, msg)
-		} else {
-			messagesToSave = append(messagesToSave, msg)
+	return &chatInfo, kv, nil
+}
+
+// UpdateChat changes a stored chat all at once: it reads the chat, lets fn change it, validates the result and
+// writes it unless someone else wrote the chat meanwhile. Then it starts over with the chat they wrote, up to
+// maxChatUpdateAttempts times before giving up with ErrChatUpdateConflict.
+//
+// fn may thus run several times and must only change the ChatInfo it's given: no I/O, no changes of anything
+// else, and the same result for the same ChatInfo. It returning an error, or the result being invalid, leaves
+// the stored chat unchanged and returns the error. A chat fn doesn't change isn't written.
+func (s *ChatStore) UpdateChat(id int64, fn func(ci *ChatInfo) error) error {
+	for attempt := 0; attempt < maxChatUpdateAttempts; attempt++ {
+		ci, previous, err := s.getChatInfo(id)
+		if err != nil {
+			return err
+		}
+		if err := fn(ci); err != nil {
+			return err
+		}
+		if err := ci.validate(id); err != nil {
+			return fmt.Errorf("%w %d: %v", ErrInvalidChatInfo, id, err)
+		}
+		value, err := json.Marshal(ci)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(value, previous.Value) {
+			return nil
+		}
+		err = s.atomicPut(chatKey(id), value, previous)
+		if !errors.Is(err, errKeyModified) {
+			return err
 		}
 	}
-	info, err := json.Marshal(messagesToSave)
-	if err != nil {
-		return nil, err
-	}
-	err = s.kv.Put(telegramMessagesDirectory, info, nil)
-	if err != nil {
-		return nil, err
-	}
-	return messagesToDelete, nil
-}*/
+	return ErrChatUpdateConflict
+}
 
-/*func (s *ChatStore) GetChatInfo(c *telebot.Chat) (ChatInfo, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+// putChatInfo writes a chat whatever is stored, for new chats. Stored chats are changed with UpdateChat.
+func (s *ChatStore) putChatInfo(ci *ChatInfo) error {
+	value, err := json.Marshal(ci)
 	if err != nil {
-		return ChatInfo{}, err
+		return err
 	}
+	return s.put(chatKey(ci.Chat.ID), value)
+}
 
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return ChatInfo{}, err
-	}
-	return chatInfo, nil
-}*/
+func chatKey(id int64) string {
+	return fmt.Sprintf("%s/%d", telegramChatsDirectory, id)
+}
 
 func (s *ChatStore) MuteEnvironments(c *telebot.Chat, envsToMute []string, allEnvs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
-	if err != nil {
-		return err
-	}
-
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return err
-	}
-	chatInfo.MuteEnvironments(envsToMute, allEnvs)
-	updated, err := json.Marshal(chatInfo)
-	if err != nil {
-		return err
-	}
-	return s.kv.Put(key, updated, nil)
+	return s.UpdateChat(c.ID, func(chatInfo *ChatInfo) error {
+		chatInfo.MuteEnvironments(envsToMute, allEnvs)
+		return nil
+	})
 }
 
 func (s *ChatStore) MuteProjects(c *telebot.Chat, prsToMute []string, allPrs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
-	if err != nil {
-		return err
-	}
-
-	var chatInfo *ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return err
-	}
-	chatInfo.MuteProjects(prsToMute, allPrs)
-	updated, err := json.Marshal(chatInfo)
-	if err != nil {
-		return err
-	}
-	return s.kv.Put(key, updated, nil)
+	return s.UpdateChat(c.ID, func(chatInfo *ChatInfo) error {
+		chatInfo.MuteProjects(prsToMute, allPrs)
+		return nil
+	})
 }
 
 func (s *ChatStore) UnmuteEnvironment(c *telebot.Chat, envToUnmute string, allEnvs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
-	if err != nil {
-		return err
-	}
-
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return err
-	}
-	chatInfo.UnmuteEnvironment(envToUnmute, allEnvs)
-	updated, err := json.Marshal(chatInfo)
-	if err != nil {
-		return err
-	}
-	return s.kv.Put(key, updated, nil)
+	return s.UpdateChat(c.ID, func(chatInfo *ChatInfo) error {
+		chatInfo.UnmuteEnvironment(envToUnmute, allEnvs)
+		return nil
+	})
 }
 
 func (s *ChatStore) UnmuteProject(c *telebot.Chat, prToUnmute string, allPrs []string) error {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
-	if err != nil {
-		return err
-	}
-
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return err
-	}
-	chatInfo.UnmuteProject(prToUnmute, allPrs)
-	updated, err := json.Marshal(chatInfo)
-	if err != nil {
-		return err
-	}
-	return s.kv.Put(key, updated, nil)
+	return s.UpdateChat(c.ID, func(chatInfo *ChatInfo) error {
+		chatInfo.UnmuteProject(prToUnmute, allPrs)
+		return nil
+	})
 }
 
 func (s *ChatStore) MutedEnvironments(c *telebot.Chat) ([]string, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+	chatInfo, err := s.GetChatInfo(c.ID)
 	if err != nil {
 		return nil, err
 	}
-
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return nil, err
-	}
 	return chatInfo.MutedEnvironments, nil
 }
 
 func (s *ChatStore) MutedProjects(c *telebot.Chat) ([]string, error) {
-	key := fmt.Sprintf("%s/%d", telegramChatsDirectory, c.ID)
-	kvPairs, err := s.kv.Get(key)
+	chatInfo, err := s.GetChatInfo(c.ID)
 	if err != nil {
 		return nil, err
 	}
-
-	var chatInfo ChatInfo
-	if err = json.Unmarshal(kvPairs.Value, &chatInfo); err != nil {
-		return nil, err
-	}
 	return chatInfo.MutedProjects, nil
 }
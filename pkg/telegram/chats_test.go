@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+)
+
+// racingKV writes a chat before each of the next atomic writes of it, like another replica would.
+type racingKV struct {
+	*memoryKV
+	races int
+	race  func()
+}
+
+func (r *racingKV) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	if r.races > 0 {
+		r.races--
+		r.race()
+	}
+	return r.memoryKV.AtomicPut(key, value, previous, opts)
+}
+
+func TestUpdateChatRetriesOnConflict(t *testing.T) {
+	kv := &racingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	require.NoError(t, chats.AddChat(testChat, []string{"prod"}, []string{"billing"}))
+	other, err := NewChatStore(kv.memoryKV, telegramChatsDirectory)
+	require.NoError(t, err)
+	kv.races = 1
+	kv.race = func() { require.NoError(t, other.SetTags(testChat, []string{"payments"})) }
+
+	var calls int
+	require.NoError(t, chats.UpdateChat(testChat.ID, func(ci *ChatInfo) error {
+		calls++
+		ci.Format = "compact"
+		return nil
+	}))
+	require.Equal(t, 2, calls, "the change is applied again to the chat written meanwhile")
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, "compact", ci.Format)
+	require.Equal(t, []string{"payments"}, ci.Tags, "the other write isn't lost")
+
+	kv.races = maxChatUpdateAttempts
+	var debounce time.Duration
+	kv.race = func() {
+		debounce += time.Second
+		require.NoError(t, other.SetDebounce(testChat.ID, debounce))
+	}
+	err = chats.UpdateChat(testChat.ID, func(ci *ChatInfo) error {
+		ci.Format = "verbose"
+		return nil
+	})
+	require.ErrorIs(t, err, ErrChatUpdateConflict)
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, "compact", ci.Format)
+}
+
+func TestUpdateChatLeavesRejectedChangesUnstored(t *testing.T) {
+	_, _, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, []string{"prod"}, []string{"billing"}))
+	before, err := chats.get(chatKey(testChat.ID), nil)
+	require.NoError(t, err)
+
+	err = chats.UpdateChat(testChat.ID, func(ci *ChatInfo) error {
+		ci.Format = "compact"
+		ci.FallbackChatID = testChat.ID
+		return nil
+	})
+	require.ErrorIs(t, err, ErrInvalidChatInfo)
+	require.EqualError(t, err, "invalid chat 123: a chat can't be its own fallback chat")
+
+	failed := errors.New("no such preset")
+	require.Equal(t, failed, chats.UpdateChat(testChat.ID, func(ci *ChatInfo) error {
+		ci.Format = "compact"
+		return failed
+	}))
+
+	require.ErrorIs(t, chats.UpdateChat(testChat.ID, func(ci *ChatInfo) error {
+		ci.Chat.ID = 456
+		return nil
+	}), ErrInvalidChatInfo)
+
+	require.NoError(t, chats.UnmuteEnvironment(testChat, "staging", []string{"prod"}), "unmuting what isn't muted changes nothing")
+	after, err := chats.get(chatKey(testChat.ID), nil)
+	require.NoError(t, err)
+	require.Equal(t, before.LastIndex, after.LastIndex, "the chat wasn't written")
+	require.Equal(t, string(before.Value), string(after.Value))
+
+	require.ErrorIs(t, chats.UpdateChat(404, func(*ChatInfo) error { return nil }), ErrChatNotFound)
+}
@@ -0,0 +1,258 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	deleteResultDeleted   = "deleted"
+	deleteResultPermanent = "permanent"
+	deleteResultTransient = "transient"
+	deleteResultAbandoned = "abandoned"
+
+	defaultCleanupInterval    = time.Minute
+	defaultMaxTrackedMessages = 10000
+
+	// deleteRetryBase is the wait before the first retry of a transient failure, doubling with every attempt.
+	deleteRetryBase = time.Minute
+	deleteRetryMax  = time.Hour
+	// deleteMaxAttempts is how often a message is tried to be deleted before its record is dropped.
+	deleteMaxAttempts = 6
+)
+
+// unknownTelegramErrorCode finds the status code in errors telebot doesn't know, like
+// "telegram unknown: Bad Request: message can't be deleted for everyone (400)".
+var unknownTelegramErrorCode = regexp.MustCompile(`^telegram unknown: .* \((\d{3})\)$`)
+
+// permanentDeleteErrors are the errors after which deleting a message never succeeds.
+// Telegram answers "message can't be deleted" for messages older than 48 hours, too.
+var permanentDeleteErrors = []error{
+	telebot.ErrToDeleteNotFound,
+	telebot.ErrNoRightsToDelete,
+	telebot.ErrChatNotFound,
+	telebot.ErrBlockedByUser,
+	telebot.ErrUserIsDeactivated,
+	telebot.ErrNotStartedByUser,
+	telebot.ErrKickingChatOwner, // telebot returns this for ErrBotKickedFromGroup
+	telebot.ErrBotKickedFromGroup,
+	telebot.ErrBotKickedFromSuperGroup,
+}
+
+// classifyTelegramError tells if a failed call, like deleting a message, may succeed when retried later (transient) or not (permanent).
+// Flood control, server and network errors are transient, every other error Telegram returns is permanent.
+func classifyTelegramError(err error) string {
+	for _, perm := range permanentDeleteErrors {
+		if err == perm {
+			return deleteResultPermanent
+		}
+	}
+
+	var flood telebot.FloodError
+	if errors.As(err, &flood) {
+		return deleteResultTransient
+	}
+	var apiErr *telebot.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code >= 500 || apiErr.Code == 429 {
+			return deleteResultTransient
+		}
+		return deleteResultPermanent
+	}
+	if m := unknownTelegramErrorCode.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		if code >= 500 || code == 429 {
+			return deleteResultTransient
+		}
+		return deleteResultPermanent
+	}
+	// Network errors and everything else we don't know about.
+	return deleteResultTransient
+}
+
+// deleteRetryDelay is the backoff after the given number of failed attempts.
+func deleteRetryDelay(attempts int) time.Duration {
+	delay := deleteRetryBase
+	for i := 1; i < attempts && delay < deleteRetryMax; i++ {
+		delay = delay * 2
+	}
+	if delay > deleteRetryMax {
+		return deleteRetryMax
+	}
+	return delay
+}
+
+// WithMaxTrackedMessages limits the number of sent messages remembered in the store, the oldest are pruned first.
+func WithMaxTrackedMessages(max int) BotOption {
+	return func(b *Bot) error {
+		b.maxTrackedMessages = max
+		return nil
+	}
+}
+
+// ListMessages returns the records of all tracked messages or ErrMessageStoreEmpty.
+// Records that can't be read are skipped, CheckArea tells which.
+func (s *ChatStore) ListMessages() ([]MessageRecord, error) {
+	a, err := s.scanMessages()
+	if err != nil {
+		return nil, err
+	}
+	records := []MessageRecord{}
+	for _, chat := range a.records {
+		for _, r := range chat {
+			records = append(records, r)
+		}
+	}
+	if len(records) == 0 && len(a.corrupt) == 0 {
+		return nil, ErrMessageStoreEmpty
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].ChatID != records[j].ChatID {
+			return records[i].ChatID < records[j].ChatID
+		}
+		return records[i].MessageID < records[j].MessageID
+	})
+	return records, nil
+}
+
+// RemoveMessage stops tracking a sent message, written behind like AddMessage.
+func (s *ChatStore) RemoveMessage(chatID int64, messageID int) error {
+	return s.writeMessageBehind(chatID, messageID, nil)
+}
+
+// PruneMessages removes the oldest message records until at most max are left.
+// It returns the number of removed records.
+func (s *ChatStore) PruneMessages(max int) (int, error) {
+	records, err := s.ListMessages()
+	if errors.Is(err, ErrMessageStoreEmpty) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(records) <= max {
+		return 0, nil
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SentAt.Before(records[j].SentAt)
+	})
+
+	var pruned int
+	for _, r := range records[:len(records)-max] {
+		if err := s.RemoveMessage(r.ChatID, r.MessageID); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// runCleanup deletes old alert messages and prunes the message records until the context is done.
+func (b *Bot) runCleanup(ctx context.Context) {
+	interval := defaultCleanupInterval
+	if b.fetchPeriod > 0 {
+		interval = time.Duration(b.fetchPeriod * float64(time.Second))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if b.leading() {
+				b.cleanupMessages(now)
+				b.checkMaintenance(ctx, now)
+				b.checkEscalations(ctx, now)
+				b.pruneInvites(now)
+				b.pruneFingerprints(now)
+				b.pruneRepeats(now)
+				b.checkExpiries(now)
+				b.checkEchoes(now)
+				b.checkSharedReceivers()
+			}
+			b.summariseErrors()
+		}
+	}
+}
+
+// cleanupMessages deletes the tracked messages older than the delete period from their chats.
+// Records of messages that can't ever be deleted are dropped right away,
+// transient failures are retried with backoff up to deleteMaxAttempts.
+func (b *Bot) cleanupMessages(now time.Time) {
+	if b.deletePeriod > 0 {
+		b.deleteOldMessages(now, time.Duration(b.deletePeriod*float64(time.Second)))
+	}
+
+	if b.maxTrackedMessages > 0 {
+		pruned, err := b.chats.PruneMessages(b.maxTrackedMessages)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to prune message records", "err", err)
+		}
+		b.messagesPrunedCounter.Add(float64(pruned))
+	}
+}
+
+func (b *Bot) deleteOldMessages(now time.Time, deleteAfter time.Duration) {
+	records, err := b.chats.ListMessages()
+	if errors.Is(err, ErrMessageStoreEmpty) {
+		return
+	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list message records", "err", err)
+		return
+	}
+	archiving, err := b.archiveChats()
+	if err != nil {
+		// The messages are deleted all the same, the next cleanups don't find them to archive either.
+		level.Warn(b.logger).Log("msg", "failed to list chats to archive messages of", "err", err)
+	}
+
+	for _, r := range records {
+		if now.Sub(r.SentAt) < deleteAfter || now.Before(r.NextDeleteAt) {
+			continue
+		}
+
+		// Messages are archived before they're deleted, but a failed archive doesn't keep them.
+		if ci := archiving[r.ChatID]; ci != nil && !r.Archived {
+			b.archiveMessage(log.With(b.logger, "chat_id", r.ChatID), ci, &r)
+		}
+
+		err := b.telegram.Delete(telebot.StoredMessage{MessageID: strconv.Itoa(r.MessageID), ChatID: r.ChatID})
+		result := deleteResultDeleted
+		if err != nil {
+			result = classifyTelegramError(err)
+		}
+		r.DeleteAttempts++
+		if result == deleteResultTransient && r.DeleteAttempts >= deleteMaxAttempts {
+			result = deleteResultAbandoned
+		}
+		b.messageDeletesCounter.WithLabelValues(result).Inc()
+
+		if result == deleteResultTransient {
+			level.Debug(b.logger).Log("msg", "failed to delete message, retrying later", "chat_id", r.ChatID, "message_id", r.MessageID, "err", err)
+			r.NextDeleteAt = now.Add(deleteRetryDelay(r.DeleteAttempts))
+			if err := b.chats.AddMessage(r); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to update message record", "err", err)
+			}
+			continue
+		}
+		if err != nil {
+			level.Info(b.logger).Log("msg", "giving up deleting message", "chat_id", r.ChatID, "message_id", r.MessageID, "result", result, "err", err)
+		}
+		if err := b.chats.RemoveMessage(r.ChatID, r.MessageID); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove message record", "err", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestClassifyTelegramError(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		class string
+	}{
+		{telebot.ErrToDeleteNotFound, deleteResultPermanent},
+		{telebot.ErrNoRightsToDelete, deleteResultPermanent},
+		{telebot.ErrChatNotFound, deleteResultPermanent},
+		{telebot.ErrBlockedByUser, deleteResultPermanent},
+		{telebot.ErrKickingChatOwner, deleteResultPermanent},
+		{telebot.ErrBotKickedFromSuperGroup, deleteResultPermanent},
+		{errors.New("telegram unknown: Bad Request: message can't be deleted for everyone (400)"), deleteResultPermanent},
+		{errors.New("telegram unknown: Forbidden: bot is not a member of the channel chat (403)"), deleteResultPermanent},
+		{telebot.FloodError{APIError: telebot.NewAPIError(429, "Too Many Requests: retry after 5"), RetryAfter: 5}, deleteResultTransient},
+		{telebot.ErrInternal, deleteResultTransient},
+		{errors.New("telegram unknown: Bad Gateway (502)"), deleteResultTransient},
+		{fmt.Errorf("telebot: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("i/o timeout")}), deleteResultTransient},
+		{errors.New("something else"), deleteResultTransient},
+	} {
+		require.Equal(t, tc.class, classifyTelegramError(tc.err), tc.err.Error())
+	}
+}
+
+func TestDeleteRetryDelay(t *testing.T) {
+	require.Equal(t, time.Minute, deleteRetryDelay(1))
+	require.Equal(t, 2*time.Minute, deleteRetryDelay(2))
+	require.Equal(t, 16*time.Minute, deleteRetryDelay(5))
+	require.Equal(t, time.Hour, deleteRetryDelay(10))
+}
+
+func TestCleanupMessages(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithDeletePeriod(3600))
+	now := time.Now()
+
+	for id := 1; id <= 4; id++ {
+		require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: id, SentAt: now.Add(-2 * time.Hour)}))
+	}
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 5, SentAt: now.Add(-time.Minute)}))
+
+	tb.deleteErr = func(msg telebot.Editable) error {
+		switch id, _ := msg.MessageSig(); id {
+		case "2":
+			return telebot.ErrNoRightsToDelete // older than 48 hours
+		case "3":
+			return telebot.ErrToDeleteNotFound
+		case "4":
+			return telebot.ErrInternal
+		}
+		return nil
+	}
+
+	b.cleanupMessages(now)
+	require.Len(t, tb.deleted, 1)
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	retry, err := chats.GetMessage(testChat.ID, 4)
+	require.NoError(t, err)
+	require.Equal(t, 1, retry.DeleteAttempts)
+	require.True(t, retry.NextDeleteAt.After(now))
+
+	// Not retried before the backoff passed.
+	b.cleanupMessages(now.Add(time.Second))
+	retry, err = chats.GetMessage(testChat.ID, 4)
+	require.NoError(t, err)
+	require.Equal(t, 1, retry.DeleteAttempts)
+
+	// Transient failures are given up after deleteMaxAttempts.
+	later := now
+	for i := 1; i < deleteMaxAttempts; i++ {
+		later = later.Add(deleteRetryMax)
+		b.cleanupMessages(later)
+	}
+	_, err = chats.GetMessage(testChat.ID, 4)
+	require.Equal(t, MessageNotFoundErr, err)
+}
+
+func TestPruneMessages(t *testing.T) {
+	b, _, chats := newTestBot(t, WithMaxTrackedMessages(3))
+	now := time.Now()
+	for id := 1; id <= 5; id++ {
+		require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: id, SentAt: now.Add(time.Duration(id) * time.Minute)}))
+	}
+
+	b.cleanupMessages(now)
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for _, r := range records {
+		require.Greater(t, r.MessageID, 2)
+	}
+}
@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+const (
+	// clusterCheckTimeout is the longest a cluster check waits for Alertmanager.
+	clusterCheckTimeout = 30 * time.Second
+	// clusterHysteresis is how many checks in a row have to agree before the global admins are told
+	// that the cluster is degraded or healthy again, so that a flapping cluster doesn't flood them.
+	clusterHysteresis = 3
+)
+
+// WithClusterCheck checks Alertmanager's cluster every interval and tells the global admins once it's degraded
+// and once it's healthy again, 0 doesn't. The cluster is degraded while it isn't ready or has fewer than peers peers,
+// 0 peers expects as many as were seen at most since the bot started.
+func WithClusterCheck(interval time.Duration, peers int) BotOption {
+	return func(b *Bot) error {
+		if interval < 0 {
+			return fmt.Errorf("the cluster check interval must not be negative, is %s", interval)
+		}
+		if peers < 0 {
+			return fmt.Errorf("the expected number of cluster peers must not be negative, is %d", peers)
+		}
+		b.clusterCheckInterval = interval
+		b.cluster.expected = peers
+		return nil
+	}
+}
+
+// clusterState is what the cluster checks saw so far.
+type clusterState struct {
+	mu sync.Mutex
+	// expected is the number of peers the cluster should have, 0 uses maxPeers.
+	expected int
+	maxPeers int
+	// degraded is what the global admins were told last, streak counts the checks disagreeing with it.
+	degraded bool
+	streak   int
+}
+
+// observe notes the cluster status and returns what the cluster is like and how many peers it should have.
+func (s *clusterState) observe(cs alertmanager.ClusterStatus) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cs.Peers > s.maxPeers {
+		s.maxPeers = cs.Peers
+	}
+	return s.isDegraded(cs), s.expectedPeers()
+}
+
+func (s *clusterState) expectedPeers() int {
+	if s.expected > 0 {
+		return s.expected
+	}
+	return s.maxPeers
+}
+
+// isDegraded returns whether the cluster isn't ready or misses peers. Alertmanagers without a cluster never are.
+func (s *clusterState) isDegraded(cs alertmanager.ClusterStatus) bool {
+	if cs.Status == models.ClusterStatusStatusDisabled {
+		return false
+	}
+	return cs.Status != models.ClusterStatusStatusReady || cs.Peers < s.expectedPeers()
+}
+
+// transition notes a check's result and returns whether it changed the state told to the global admins,
+// which takes clusterHysteresis checks in a row.
+func (s *clusterState) transition(degraded bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if degraded == s.degraded {
+		s.streak = 0
+		return false
+	}
+	s.streak++
+	if s.streak < clusterHysteresis {
+		return false
+	}
+	s.degraded, s.streak = degraded, 0
+	return true
+}
+
+// clusterLine sums up the cluster for /status, like "2/3 peers, degraded".
+func (b *Bot) clusterLine(status *models.AlertmanagerStatus) string {
+	cs := alertmanager.Cluster(status)
+	if cs.Status == models.ClusterStatusStatusDisabled {
+		return "disabled"
+	}
+	degraded, expected := b.cluster.observe(cs)
+	state := cs.Status
+	if degraded {
+		state = "degraded"
+		if cs.Status != models.ClusterStatusStatusReady {
+			state = "degraded, " + cs.Status
+		}
+	}
+	return fmt.Sprintf("%d/%d peers, %s", cs.Peers, expected, state)
+}
+
+func (b *Bot) runClusterCheck(ctx context.Context) {
+	ticker := time.NewTicker(b.clusterCheckInterval)
+	defer ticker.Stop()
+	for {
+		if b.leading() {
+			b.checkCluster(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkCluster checks Alertmanager's cluster and tells the global admins if it got degraded or healthy again.
+func (b *Bot) checkCluster(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, clusterCheckTimeout)
+	defer cancel()
+	status, err := b.alertmanager.Status(ctx)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get the cluster status", "err", err)
+		return
+	}
+
+	cs := alertmanager.Cluster(status)
+	degraded, expected := b.cluster.observe(cs)
+	if !b.cluster.transition(degraded) {
+		return
+	}
+
+	notice := fmt.Sprintf("✅ The Alertmanager cluster is healthy again, %d/%d peers are %s.", cs.Peers, expected, cs.Status)
+	if degraded {
+		level.Warn(b.logger).Log("msg", "Alertmanager cluster is degraded", "peers", cs.Peers, "expected", expected, "status", cs.Status)
+		notice = fmt.Sprintf("⚠️ The Alertmanager cluster is degraded, %d/%d peers are %s. Silences may not propagate and alerts may be stale.",
+			cs.Peers, expected, cs.Status)
+	} else {
+		level.Info(b.logger).Log("msg", "Alertmanager cluster is healthy again", "peers", cs.Peers)
+	}
+	for _, admin := range b.globalAdmins {
+		b.SendAdminMessage(admin, notice)
+	}
+}
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+)
+
+// clusterStatus is an Alertmanager status with a cluster of peers in the state.
+func clusterStatus(state string, peers int) *models.AlertmanagerStatus {
+	s := &models.ClusterStatus{Name: "01F", Status: &state}
+	for i := 0; i < peers; i++ {
+		s.Peers = append(s.Peers, &models.PeerStatus{})
+	}
+	return &models.AlertmanagerStatus{Cluster: s}
+}
+
+func TestClusterCheck(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{AlertmanagerStatus: clusterStatus("ready", 3)}
+	b, tb, _ := newTestBot(t, WithAlertmanager(am), WithClusterCheck(time.Minute, 0))
+	check := func(state string, peers int) {
+		am.AlertmanagerStatus = clusterStatus(state, peers)
+		b.checkCluster(context.Background())
+	}
+
+	check("ready", 3)
+	require.Empty(t, tb.messages())
+	require.Equal(t, "3/3 peers, ready", b.clusterLine(am.AlertmanagerStatus))
+
+	check("ready", 2)
+	check("ready", 2)
+	check("ready", 3)
+	check("ready", 2)
+	check("ready", 2)
+	require.Empty(t, tb.messages(), "flapping doesn't notify")
+	require.Equal(t, "2/3 peers, degraded", b.clusterLine(am.AlertmanagerStatus))
+
+	check("ready", 2)
+	require.Len(t, tb.messages(), 1)
+	require.Equal(t, "⚠️ The Alertmanager cluster is degraded, 2/3 peers are ready. Silences may not propagate and alerts may be stale.", tb.lastText())
+	check("settling", 2)
+	require.Len(t, tb.messages(), 1, "it's only told once")
+
+	for i := 0; i < clusterHysteresis; i++ {
+		check("ready", 3)
+	}
+	require.Len(t, tb.messages(), 2)
+	require.Equal(t, "✅ The Alertmanager cluster is healthy again, 3/3 peers are ready.", tb.lastText())
+}
+
+func TestClusterExpectedPeers(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{}
+	b, tb, _ := newTestBot(t, WithAlertmanager(am), WithClusterCheck(time.Minute, 3))
+
+	require.Equal(t, "disabled", b.clusterLine(am.AlertmanagerStatus))
+	for i := 0; i < clusterHysteresis; i++ {
+		b.checkCluster(context.Background())
+	}
+	require.Empty(t, tb.messages(), "an Alertmanager without a cluster isn't degraded")
+
+	require.Equal(t, "2/3 peers, degraded, settling", b.clusterLine(clusterStatus("settling", 2)))
+	require.Equal(t, "3/3 peers, ready", b.clusterLine(clusterStatus("ready", 3)))
+}
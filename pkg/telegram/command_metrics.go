@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// defaultSlowCommand is how long a command may take before it's logged as slow.
+const defaultSlowCommand = 5 * time.Second
+
+// WithSlowCommandThreshold logs commands whose handler takes longer than d, with how long it took. 0 logs none.
+func WithSlowCommandThreshold(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d < 0 {
+			return fmt.Errorf("the slow command threshold must not be negative, is %s", d)
+		}
+		b.slowCommand = d
+		return nil
+	}
+}
+
+// commandLabel is the command of a message for metrics, without the bot's username of commands like /alerts@bot.
+func commandLabel(command string) string {
+	return strings.Split(command, "@")[0]
+}
+
+// timeCommand runs the handler of a command, recording how long it took and if it failed.
+// The handler's error is returned for the caller to log, a panic of the handler is returned as its error.
+func (b *Bot) timeCommand(logger log.Logger, command string, next func(*telebot.Message) error, m *telebot.Message) error {
+	command = commandLabel(command)
+	start := time.Now()
+	err := runCommand(next, m)
+	took := time.Since(start)
+
+	b.commandDuration.WithLabelValues(command).Observe(took.Seconds())
+	if err != nil {
+		b.commandErrors.WithLabelValues(command).Inc()
+	}
+	if b.slowCommand > 0 && took > b.slowCommand {
+		level.Warn(logger).Log(
+			"msg", "slow command",
+			"command", command,
+			"duration", took,
+			"threshold", b.slowCommand,
+			"failed", err != nil,
+		)
+	}
+	return err
+}
+
+// runCommand runs the handler of a command, returning a panic of it as an error.
+func runCommand(next func(*telebot.Message) error, m *telebot.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the command panicked: %v", r)
+		}
+	}()
+	return next(m)
+}
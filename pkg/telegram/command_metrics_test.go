@@ -0,0 +1,73 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// commandSamples returns how many durations the histogram has for the command and their sum.
+func commandSamples(t *testing.T, b *Bot, command string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, b.commandDuration.WithLabelValues(command).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestCommandMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	b, _, _ := newTestBot(t, WithLogger(log.NewLogfmtLogger(&logs)), WithSlowCommandThreshold(50*time.Millisecond))
+
+	fast := func(*telebot.Message) error { return nil }
+	slow := func(*telebot.Message) error {
+		time.Sleep(80 * time.Millisecond)
+		return errors.New("timed out")
+	}
+
+	// The metrics are global, so only their changes are compared.
+	fastCount, _ := commandSamples(t, b, "/fast")
+	slowCount, slowSum := commandSamples(t, b, "/slow")
+	fastErrors := testutil.ToFloat64(b.commandErrors.WithLabelValues("/fast"))
+	slowErrors := testutil.ToFloat64(b.commandErrors.WithLabelValues("/slow"))
+
+	b.middleware(fast)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/fast"})
+	b.middleware(fast)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/fast@alertmanager_bot now"})
+	require.NotContains(t, logs.String(), "slow command")
+
+	b.middleware(slow)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/slow"})
+
+	count, _ := commandSamples(t, b, "/fast")
+	require.Equal(t, fastCount+2, count, "the bot's username isn't part of the command")
+	count, sum := commandSamples(t, b, "/slow")
+	require.Equal(t, slowCount+1, count)
+	require.GreaterOrEqual(t, sum-slowSum, 0.08)
+
+	require.Equal(t, fastErrors, testutil.ToFloat64(b.commandErrors.WithLabelValues("/fast")))
+	require.Equal(t, slowErrors+1, testutil.ToFloat64(b.commandErrors.WithLabelValues("/slow")))
+
+	require.Contains(t, logs.String(), `msg="slow command" command=/slow duration=`)
+	require.Contains(t, logs.String(), "threshold=50ms failed=true")
+	require.Contains(t, logs.String(), `msg="failed to handle command" err="timed out"`, "the handler's error is still logged")
+}
+
+func TestSlowCommandThresholdOff(t *testing.T) {
+	var logs bytes.Buffer
+	b, _, _ := newTestBot(t, WithLogger(log.NewLogfmtLogger(&logs)), WithSlowCommandThreshold(0))
+	b.middleware(func(*telebot.Message) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/slow"})
+	require.NotContains(t, logs.String(), "slow command")
+
+	_, err := NewBotWithTelegram(nil, nil, testAdmin.ID, WithSlowCommandThreshold(-time.Second))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the slow command threshold must not be negative, is -1s")
+}
@@ -0,0 +1,221 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandConfig = "/config"
+
+	// maskedSecret replaces passwords and tokens in the configuration shown to admins.
+	maskedSecret = "xxxxx"
+)
+
+// secretQueryParams are query parameters of URLs whose values are masked, matched as substrings.
+var secretQueryParams = []string{"token", "key", "secret", "password", "auth"}
+
+// ConfigSnapshot is the effective configuration of a Bot, with secrets masked.
+// It's safe to show to admins and to marshal into logs.
+type ConfigSnapshot struct {
+	Revision            string                   `json:"revision,omitempty"`
+	ListenAddr          string                   `json:"listen_addr"`
+	Admins              []int                    `json:"admins"`
+	GlobalAdmins        []int                    `json:"global_admins"`
+	Environments        []string                 `json:"environments"`
+	Projects            []string                 `json:"projects"`
+	LabelEnvironment    string                   `json:"label_environment"`
+	LabelProject        string                   `json:"label_project"`
+	UnlabeledPolicy     string                   `json:"unlabeled_policy"`
+	AlertmanagerURL     string                   `json:"alertmanager_url,omitempty"`
+	TemplatePaths       []string                 `json:"template_paths"`
+	TemplatesParsed     bool                     `json:"templates_parsed"`
+	ParseMode           string                   `json:"parse_mode"`
+	Store               StoreConfig              `json:"store"`
+	FetchPeriod         float64                  `json:"fetch_period_seconds"`
+	DeletePeriod        float64                  `json:"delete_period_seconds"`
+	Cleanup             CleanupConfig            `json:"cleanup"`
+	MaxAlertsPerMessage int                      `json:"max_alerts_per_message"`
+	FormatProfiles      map[string]FormatProfile `json:"format_profiles,omitempty"`
+	IssueTracker        string                   `json:"issue_tracker,omitempty"`
+	RedactionPatterns   int                      `json:"redaction_patterns"`
+	Reconcile           ReconcileConfig          `json:"reconcile"`
+	Features            map[string]bool          `json:"features"`
+}
+
+// StoreConfig is the store backend the bot's chats are kept in.
+type StoreConfig struct {
+	Backend string `json:"backend,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// CleanupConfig is how old alert messages are deleted.
+type CleanupConfig struct {
+	Interval           string `json:"interval"`
+	MaxTrackedMessages int    `json:"max_tracked_messages"`
+	RetryBase          string `json:"retry_base"`
+	RetryMax           string `json:"retry_max"`
+	MaxAttempts        int    `json:"max_attempts"`
+}
+
+// ReconcileConfig is how stored chats are checked against Telegram.
+type ReconcileConfig struct {
+	OnStartup bool   `json:"on_startup"`
+	Grace     string `json:"grace"`
+	RateLimit string `json:"rate_limit"`
+}
+
+// WithStore records the store backend and its address, only to show them in the configuration.
+func WithStore(backend string, address string) BotOption {
+	return func(b *Bot) error {
+		b.config.Store = StoreConfig{Backend: backend, Address: maskURL(address)}
+		return nil
+	}
+}
+
+// ConfigSnapshot returns the configuration the bot runs with.
+func (b *Bot) ConfigSnapshot() ConfigSnapshot {
+	c := b.config
+	c.Revision = b.revision
+	c.ListenAddr = b.addr
+	c.Admins = append([]int(nil), b.admins...)
+	c.GlobalAdmins = append([]int(nil), b.globalAdmins...)
+	c.Environments = append([]string(nil), b.environments...)
+	c.Projects = append([]string(nil), b.projects...)
+	c.LabelEnvironment = labelEnvironment
+	c.LabelProject = labelProject
+	c.UnlabeledPolicy = b.unlabeledPolicy
+	if b.templates != nil && b.templates.ExternalURL != nil {
+		c.AlertmanagerURL = maskURL(b.templates.ExternalURL.String())
+	}
+	c.TemplatePaths = append([]string{}, c.TemplatePaths...)
+	c.ParseMode = string(telebot.ModeHTML)
+	c.FetchPeriod = b.fetchPeriod
+	c.DeletePeriod = b.deletePeriod
+	c.Cleanup = CleanupConfig{
+		Interval:           defaultCleanupInterval.String(),
+		MaxTrackedMessages: b.maxTrackedMessages,
+		RetryBase:          deleteRetryBase.String(),
+		RetryMax:           deleteRetryMax.String(),
+		MaxAttempts:        deleteMaxAttempts,
+	}
+	c.MaxAlertsPerMessage = b.maxAlerts
+	if len(b.formatProfiles) > 0 {
+		c.FormatProfiles = make(map[string]FormatProfile, len(b.formatProfiles))
+		for chatType, p := range b.formatProfiles {
+			c.FormatProfiles[string(chatType)] = p
+		}
+	}
+	c.RedactionPatterns = len(b.redactions)
+	c.Reconcile = ReconcileConfig{
+		OnStartup: b.reconcileOnStartup,
+		Grace:     b.reconcileGrace.String(),
+		RateLimit: fmt.Sprintf("1 chat per %s", b.reconcileInterval),
+	}
+	c.Features = map[string]bool{
+		"loadtest":              b.loadTestEnabled,
+		"correlation_hints":     b.correlation != nil,
+		"issue_buttons":         b.issueTracker != nil,
+		"deliver_inhibited":     b.deliverInhibited,
+		"setup_wizard":          b.setupWizardEnabled,
+		"maintenance_buffering": b.maintenanceBuffering,
+		"suppressed_critical":   b.suppressedAlerting,
+		"send_resolved":         b.sendResolved,
+		"split_by_status":       b.splitByStatus,
+		"reply_to_commands":     b.replyToCommands,
+		"message_sinks":         b.messageSinks != nil,
+		"silence_sync":          b.silenceSyncInterval > 0,
+		"poll_alerts":           b.pollInterval > 0,
+		"ack_creates_silence":   b.ackSilence > 0,
+		"load_shedding":         b.shedMaxAge > 0,
+		"severity_emoji":        len(b.severityEmojis) > 0,
+		"durable_outbox":        b.durableOutbox,
+		"escalation":            len(b.escalationThresholds) > 0,
+		"cluster_check":         b.clusterCheckInterval > 0,
+		"new_alert_marks":       b.newAlertWindow > 0,
+	}
+	return c
+}
+
+// maskURL masks the password and secret looking query parameters of a URL.
+// Values that don't parse as URL, like file paths, are returned as they are.
+func maskURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if strings.Contains(raw, "@") {
+			return maskedSecret
+		}
+		return raw
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), maskedSecret)
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for name := range q {
+			for _, secret := range secretQueryParams {
+				if strings.Contains(strings.ToLower(name), secret) {
+					q.Set(name, maskedSecret)
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// formatConfig renders the configuration as Markdown code blocks, split into messages Telegram accepts.
+func formatConfig(c ConfigSnapshot) ([]string, error) {
+	out, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// A backtick would end the code block.
+	lines := strings.Split(strings.Replace(string(out), "`", "'", -1), "\n")
+
+	const fence = "```\n"
+	max := telegramMessageMaxLength - 2*len(fence)
+
+	var messages []string
+	var block strings.Builder
+	for _, line := range lines {
+		for len(line) > max-1 {
+			// Indented JSON has short lines, only huge values are cut.
+			_, size := utf8.DecodeLastRuneInString(line)
+			line = line[:len(line)-size]
+		}
+		if block.Len() > 0 && block.Len()+len(line)+1 > max {
+			messages = append(messages, fence+block.String()+fence)
+			block.Reset()
+		}
+		block.WriteString(line + "\n")
+	}
+	if block.Len() > 0 {
+		messages = append(messages, fence+block.String()+fence)
+	}
+	return messages, nil
+}
+
+func (b *Bot) handleConfig(message *telebot.Message) error {
+	messages, err := formatConfig(b.ConfigSnapshot())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to render configuration", "err", err)
+		_, err = b.respondFailed(message, "render the configuration", err)
+		return err
+	}
+
+	for _, m := range messages {
+		if _, err := b.replyFormatted(message, m, &telebot.SendOptions{ParseMode: telebot.ModeMarkdown}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+)
+
+const (
+	// correlationMaxEntries is the number of other alerts listed per project.
+	correlationMaxEntries = 5
+	// correlationCacheTTL is how long the firing alerts of a project are reused for following webhooks.
+	correlationCacheTTL = 30 * time.Second
+	// correlationTimeout is the longest a notification waits for Alertmanager before it's sent without hints.
+	correlationTimeout = 2 * time.Second
+	// correlationAllReceivers is the receiver regexp listing alerts of every receiver.
+	correlationAllReceivers = ".*"
+)
+
+// WithCorrelationHints adds a footer to notifications listing the other alerts firing for the same projects.
+func WithCorrelationHints(enabled bool) BotOption {
+	return func(b *Bot) error {
+		if enabled {
+			b.correlation = newCorrelationCache(correlationCacheTTL)
+		} else {
+			b.correlation = nil
+		}
+		return nil
+	}
+}
+
+type correlationEntry struct {
+	alerts  []*types.Alert
+	fetched time.Time
+}
+
+// correlationCache keeps the firing alerts per project for a short time,
+// so that bursts of webhooks don't each ask Alertmanager.
+type correlationCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	timeout  time.Duration
+	projects map[string]correlationEntry
+}
+
+func newCorrelationCache(ttl time.Duration) *correlationCache {
+	return &correlationCache{ttl: ttl, timeout: correlationTimeout, projects: map[string]correlationEntry{}}
+}
+
+// get returns the cached alerts of the projects, ok is false if any of them is missing or expired.
+func (c *correlationCache) get(projects []string, now time.Time) (map[string][]*types.Alert, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := make(map[string][]*types.Alert, len(projects))
+	for _, p := range projects {
+		e, ok := c.projects[p]
+		if !ok || now.Sub(e.fetched) > c.ttl {
+			return nil, false
+		}
+		cached[p] = e.alerts
+	}
+	return cached, true
+}
+
+// set caches the firing alerts of the projects, projects without any alerts are cached too.
+func (c *correlationCache) set(projects []string, alerts []*types.Alert, now time.Time) map[string][]*types.Alert {
+	byProject := make(map[string][]*types.Alert, len(projects))
+	for _, p := range projects {
+		byProject[p] = nil
+	}
+	for _, a := range alerts {
+		p := string(a.Labels[labelProject])
+		if _, ok := byProject[p]; ok && !a.Resolved() {
+			byProject[p] = append(byProject[p], a)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p, as := range byProject {
+		c.projects[p] = correlationEntry{alerts: as, fetched: now}
+	}
+	return byProject
+}
+
+// firingByProject returns the firing alerts of the projects from the cache or Alertmanager.
+// Alertmanager gets at most the cache's timeout to answer, even if it ignores the context.
+func (b *Bot) firingByProject(ctx context.Context, projects []string) (map[string][]*types.Alert, error) {
+	now := time.Now()
+	if cached, ok := b.correlation.get(projects, now); ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.correlation.timeout)
+	defer cancel()
+
+	type result struct {
+		alerts []*types.Alert
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		alerts, err := b.alertmanager.ListAlerts(ctx, correlationAllReceivers, false)
+		results <- result{alerts: alerts, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-results:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return b.correlation.set(projects, r.alerts, now), nil
+	}
+}
+
+// correlationFooter lists the other alerts firing for the projects of the webhook's alerts, like:
+// Also firing in billing: HighLatency (12m), DiskFull (3h)
+// It's empty if hints are disabled, nothing else is firing or Alertmanager can't tell in time.
+func (b *Bot) correlationFooter(ctx context.Context, alerts template.Alerts) string {
+	if b.correlation == nil || b.alertmanager == nil {
+		return ""
+	}
+
+	notified := map[string]bool{}
+	var projects []string
+	for _, a := range alerts {
+		notified[a.Fingerprint] = true
+		if p := a.Labels[labelProject]; p != "" {
+			projects = append(projects, p)
+		}
+	}
+	projects = getUniqueStrings(projects)
+	if len(projects) == 0 {
+		return ""
+	}
+	sort.Strings(projects)
+
+	firing, err := b.firingByProject(ctx, projects)
+	if err != nil {
+		level.Debug(b.logger).Log("msg", "omitting correlation hints", "err", err)
+		return ""
+	}
+
+	var lines []string
+	for _, p := range projects {
+		var others []string
+		for _, a := range firing[p] {
+			if notified[a.Fingerprint().String()] {
+				continue
+			}
+			others = append(others, fmt.Sprintf("%s (%s)", html.EscapeString(b.redactValue(string(a.Labels["alertname"]))), shortDuration(time.Since(a.StartsAt))))
+		}
+		if len(others) == 0 {
+			continue
+		}
+		if len(others) > correlationMaxEntries {
+			others = append(others[:correlationMaxEntries], fmt.Sprintf("and %d more", len(others)-correlationMaxEntries))
+		}
+		lines = append(lines, fmt.Sprintf("<i>Also firing in %s: %s</i>", html.EscapeString(p), strings.Join(others, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shortDuration formats a duration with a single unit, like 45s, 12m, 3h or 2d.
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
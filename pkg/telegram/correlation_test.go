@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+)
+
+func firingAlert(name string, project string, since time.Duration) *types.Alert {
+	return alertmanagertest.Alert(name).Project(project).FiringFor(since).Build()
+}
+
+func correlationWebhook(notified *types.Alert) alertmanager.TelegramWebhook {
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{
+			Status:      "firing",
+			Labels:      template.KV{"alertname": string(notified.Labels["alertname"]), labelProject: string(notified.Labels[labelProject])},
+			Fingerprint: notified.Fingerprint().String(),
+		}},
+	}}}
+}
+
+func TestShortDuration(t *testing.T) {
+	require.Equal(t, "45s", shortDuration(45*time.Second))
+	require.Equal(t, "12m", shortDuration(12*time.Minute+30*time.Second))
+	require.Equal(t, "3h", shortDuration(3*time.Hour+59*time.Minute))
+	require.Equal(t, "2d", shortDuration(50*time.Hour))
+}
+
+func TestCorrelationFooter(t *testing.T) {
+	notified := firingAlert("HighCPU", "billing", time.Minute)
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{
+		notified,
+		firingAlert("HighLatency", "billing", 12*time.Minute+5*time.Second),
+		firingAlert("DiskFull", "billing", 3*time.Hour+time.Minute),
+		firingAlert("InstanceDown", "frontend", time.Hour),
+	}}
+
+	b, tb, chats := newTestBot(t,
+		WithAlertmanager(am),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithCorrelationHints(true),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	_, err := b.processWebhook(context.Background(), correlationWebhook(notified))
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(tb.lastText(), "\n\n<i>Also firing in billing: HighLatency (12m), DiskFull (3h)</i>"))
+
+	// A burst is answered from the cache.
+	_, err = b.processWebhook(context.Background(), correlationWebhook(notified))
+	require.NoError(t, err)
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListAlerts))
+}
+
+func TestCorrelationFooterLimit(t *testing.T) {
+	notified := firingAlert("HighCPU", "billing", time.Minute)
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{notified}}
+	for i := 0; i < correlationMaxEntries+2; i++ {
+		am.Alerts = append(am.Alerts, firingAlert("Other", "billing", time.Duration(i+1)*time.Minute))
+	}
+	b, _, _ := newTestBot(t, WithAlertmanager(am), WithCorrelationHints(true))
+
+	footer := b.correlationFooter(context.Background(), correlationWebhook(notified).Message.Alerts)
+	require.Equal(t, correlationMaxEntries+1, strings.Count(footer, ", ")+1)
+	require.True(t, strings.HasSuffix(footer, "and 2 more</i>"))
+}
+
+func TestCorrelationFooterSlowAlertmanager(t *testing.T) {
+	notified := firingAlert("HighCPU", "billing", time.Minute)
+	am := &alertmanagertest.Alertmanager{
+		Alerts: []*types.Alert{notified, firingAlert("HighLatency", "billing", time.Hour)},
+		Delays: map[string]time.Duration{alertmanagertest.MethodListAlerts: time.Second},
+	}
+	b, tb, chats := newTestBot(t,
+		WithAlertmanager(am),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithCorrelationHints(true),
+	)
+	b.correlation.timeout = 20 * time.Millisecond
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	start := time.Now()
+	_, err := b.processWebhook(context.Background(), correlationWebhook(notified))
+	require.NoError(t, err)
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	require.Len(t, tb.messages(), 1)
+	require.NotContains(t, tb.lastText(), "Also firing")
+}
+
+func TestCorrelationFooterDisabled(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{firingAlert("HighLatency", "billing", time.Hour)}}
+	b, _, _ := newTestBot(t, WithAlertmanager(am))
+
+	require.Empty(t, b.correlationFooter(context.Background(), correlationWebhook(firingAlert("HighCPU", "billing", time.Minute)).Message.Alerts))
+	require.Equal(t, 0, am.CallCount(alertmanagertest.MethodListAlerts))
+}
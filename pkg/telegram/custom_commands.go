@@ -0,0 +1,258 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// customCommandTimeout is how long the handler of a custom command has for its context.
+	customCommandTimeout = 30 * time.Second
+	// commandLimitMemory is how long the last use of a rate limited command is remembered at most.
+	commandLimitMemory = time.Hour
+	// maxMenuCommands is how many commands Telegram's command menu takes.
+	maxMenuCommands = 100
+)
+
+// customCommandName is what Telegram allows as a command: 1 to 32 lowercase letters, digits and underscores.
+var customCommandName = regexp.MustCompile(`^/[a-z0-9_]{1,32}$`)
+
+// CommandRateLimit is how often a chat may use a command registered with RegisterCommand.
+type CommandRateLimit int
+
+const (
+	// RateUnlimited doesn't limit the command, like the built-in commands.
+	RateUnlimited CommandRateLimit = iota
+	// RateModerate lets a chat use the command once every 5 seconds, for commands asking other services.
+	RateModerate
+	// RateStrict lets a chat use the command once a minute, for expensive commands.
+	RateStrict
+)
+
+// interval is the least time between two uses of a command by a chat, 0 if there's none.
+func (r CommandRateLimit) interval() time.Duration {
+	switch r {
+	case RateModerate:
+		return 5 * time.Second
+	case RateStrict:
+		return time.Minute
+	}
+	return 0
+}
+
+// CommandOptions describe a command registered with RegisterCommand.
+type CommandOptions struct {
+	// Public lets everyone use the command, like /id. Otherwise only the admins can, like the built-in commands.
+	Public bool
+	// Description is the command's line in /help and its description in Telegram's command menu,
+	// like "Show who's on call.". It's required.
+	Description string
+	// RateLimit is how often a chat may use the command, unlimited by default.
+	RateLimit CommandRateLimit
+	// ReadOnly lets followers answer the command, for commands that don't change state.
+	ReadOnly bool
+}
+
+// customCommand is a command registered with RegisterCommand.
+type customCommand struct {
+	name    string
+	options CommandOptions
+	handler func(ctx context.Context, m *telebot.Message) error
+}
+
+// customCommands are the commands registered with RegisterCommand, they're sealed once Run registers them
+// with Telegram.
+type customCommands struct {
+	mu       sync.RWMutex
+	commands []*customCommand
+	sealed   bool
+}
+
+// get returns the custom command of a message's text, like /oncall@bot today, nil if it isn't one.
+func (c *customCommands) get(text string) *customCommand {
+	name := commandLabel(strings.Fields(text + " ")[0])
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, cmd := range c.commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// RegisterCommand adds a command like /oncall to the bot. It's handled like the built-in commands: only the
+// admins can use it unless it's public, it's listed in /help, its uses are timed, counted and attributed to
+// the sender, followers refuse it unless it's read-only, and a panic of its handler is logged as its error.
+// The handler's context is done after customCommandTimeout.
+// Commands have to be registered before Run and must not be built-in commands or registered twice.
+func (b *Bot) RegisterCommand(cmd string, opts CommandOptions, handler func(ctx context.Context, m *telebot.Message) error) error {
+	if !customCommandName.MatchString(cmd) {
+		return fmt.Errorf("the command %q must be a slash and 1 to 32 lowercase letters, digits or underscores", cmd)
+	}
+	if handler == nil {
+		return fmt.Errorf("the command %s has no handler", cmd)
+	}
+	if n := len([]rune(strings.TrimSpace(opts.Description))); n < 3 || n > 256 {
+		return fmt.Errorf("the description of the command %s must have 3 to 256 characters, has %d", cmd, n)
+	}
+	if opts.RateLimit < RateUnlimited || opts.RateLimit > RateStrict {
+		return fmt.Errorf("the rate limit of the command %s is unknown: %d", cmd, opts.RateLimit)
+	}
+	for _, c := range b.builtinCommands() {
+		if c.name == cmd {
+			return fmt.Errorf("the command %s is a built-in command", cmd)
+		}
+	}
+
+	b.custom.mu.Lock()
+	defer b.custom.mu.Unlock()
+	if b.custom.sealed {
+		return errors.New("commands have to be registered before the bot runs")
+	}
+	for _, c := range b.custom.commands {
+		if c.name == cmd {
+			return fmt.Errorf("the command %s is registered already", cmd)
+		}
+	}
+	opts.Description = strings.TrimSpace(opts.Description)
+	b.custom.commands = append(b.custom.commands, &customCommand{name: cmd, options: opts, handler: handler})
+	return nil
+}
+
+// registerCustomCommands registers the custom commands with Telegram, no more can be added afterwards.
+func (b *Bot) registerCustomCommands() {
+	b.custom.mu.Lock()
+	b.custom.sealed = true
+	commands := b.custom.commands
+	b.custom.mu.Unlock()
+	for _, c := range commands {
+		b.telegram.Handle(c.name, b.middleware(b.customHandler(c)))
+	}
+}
+
+// customHandler runs the handler of a custom command within its rate limit.
+func (b *Bot) customHandler(c *customCommand) func(*telebot.Message) error {
+	return func(m *telebot.Message) error {
+		if wait, ok := b.commandLimits.allow(m.Chat.ID, c.name, c.options.RateLimit.interval(), time.Now()); !ok {
+			_, err := b.reply(m, fmt.Sprintf("%s can be used again in %s.", c.name, wait.Round(time.Second)))
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), customCommandTimeout)
+		defer cancel()
+		return c.handler(ctx, m)
+	}
+}
+
+// publicCommand tells if anyone may send the message, because it's a public custom command.
+func (b *Bot) publicCommand(m *telebot.Message) bool {
+	c := b.custom.get(m.Text)
+	return c != nil && c.options.Public
+}
+
+// readOnlyCustomCommand tells if followers answer the command, because it's a read-only custom command.
+func (b *Bot) readOnlyCustomCommand(command string) bool {
+	c := b.custom.get(command)
+	return c != nil && c.options.ReadOnly
+}
+
+// customHelp lists the custom commands for /help, "" if there are none.
+func (b *Bot) customHelp() string {
+	b.custom.mu.RLock()
+	defer b.custom.mu.RUnlock()
+	var out string
+	for _, c := range b.custom.commands {
+		out += fmt.Sprintf("%s - %s\n", c.name, c.options.Description)
+	}
+	return out
+}
+
+// commandLimitKey is a command used in a chat.
+type commandLimitKey struct {
+	chatID  int64
+	command string
+}
+
+// commandLimiter remembers when chats last used rate limited commands, in memory only.
+type commandLimiter struct {
+	mu   sync.Mutex
+	last map[commandLimitKey]time.Time
+}
+
+func newCommandLimiter() *commandLimiter {
+	return &commandLimiter{last: map[commandLimitKey]time.Time{}}
+}
+
+// allow tells if the chat may use the command now, at most once per interval, and if not how long it has to wait.
+func (l *commandLimiter) allow(chatID int64, command string, interval time.Duration, now time.Time) (time.Duration, bool) {
+	if interval <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := commandLimitKey{chatID: chatID, command: command}
+	if last, ok := l.last[key]; ok && now.Sub(last) < interval {
+		return interval - now.Sub(last), false
+	}
+	l.last[key] = now
+	for k, at := range l.last {
+		if now.Sub(at) > commandLimitMemory {
+			delete(l.last, k)
+		}
+	}
+	return 0, true
+}
+
+// commandSetter sets the command menu of the bot, like *telebot.Bot does.
+type commandSetter interface {
+	SetCommands(cmds []telebot.Command) error
+}
+
+// WithCommandMenu sets the bot's command menu in Telegram to the commands of /help, including the custom
+// ones, when it runs. Telegram shows the menu to everyone, also those who aren't admins.
+func WithCommandMenu(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.commandMenu = enabled
+		return nil
+	}
+}
+
+// menuCommands are the commands of /help for Telegram's command menu, sorted by command.
+func (b *Bot) menuCommands() []telebot.Command {
+	help := ResponseHelp + b.customHelp()
+	var commands []telebot.Command
+	for _, line := range strings.Split(help, "\n") {
+		if !strings.HasPrefix(line, "/") {
+			continue
+		}
+		parts := strings.SplitN(line, " - ", 2)
+		if len(parts) != 2 || !customCommandName.MatchString(parts[0]) {
+			continue
+		}
+		commands = append(commands, telebot.Command{Text: strings.TrimPrefix(parts[0], "/"), Description: trunc(256, parts[1])})
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Text < commands[j].Text })
+	if len(commands) > maxMenuCommands {
+		commands = commands[:maxMenuCommands]
+	}
+	return commands
+}
+
+// setCommandMenu sets the command menu in Telegram, if it's enabled and the Telebot can.
+func (b *Bot) setCommandMenu() {
+	if !b.commandMenu {
+		return
+	}
+	if err := b.swappable.setCommands(b.menuCommands()); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set the command menu", "err", err)
+	}
+}
@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// menuTelebot is a fakeTelebot keeping the command menu it's set.
+type menuTelebot struct {
+	*fakeTelebot
+	commands []telebot.Command
+}
+
+func (m *menuTelebot) SetCommands(cmds []telebot.Command) error {
+	m.commands = cmds
+	return nil
+}
+
+// handlerOf returns the handler registered with the fake for the command.
+func handlerOf(t *testing.T, tb *fakeTelebot, command string) func(*telebot.Message) {
+	t.Helper()
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	h, ok := tb.handlers[command].(func(*telebot.Message))
+	require.True(t, ok, "%s is registered", command)
+	return h
+}
+
+func TestRegisterCommand(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	var calls int
+	require.NoError(t, b.RegisterCommand("/oncall", CommandOptions{Description: "Show who's on call.", RateLimit: RateModerate},
+		func(ctx context.Context, m *telebot.Message) error {
+			calls++
+			_, hasDeadline := ctx.Deadline()
+			require.True(t, hasDeadline)
+			_, err := b.reply(m, "Alice is on call.")
+			return err
+		}))
+	require.NoError(t, b.RegisterCommand("/rota", CommandOptions{Description: "Show the rota.", Public: true},
+		func(ctx context.Context, m *telebot.Message) error {
+			_, err := b.reply(m, "Alice, then Bob.")
+			return err
+		}))
+	require.NoError(t, b.RegisterCommand("/boom", CommandOptions{Description: "Panic."},
+		func(ctx context.Context, m *telebot.Message) error {
+			panic("rota service returned nothing")
+		}))
+
+	require.EqualError(t, b.RegisterCommand(CommandAlerts, CommandOptions{Description: "Mine."}, func(context.Context, *telebot.Message) error { return nil }),
+		"the command /alerts is a built-in command")
+	require.EqualError(t, b.RegisterCommand("/oncall", CommandOptions{Description: "Again."}, func(context.Context, *telebot.Message) error { return nil }),
+		"the command /oncall is registered already")
+	require.Error(t, b.RegisterCommand("/On-Call", CommandOptions{Description: "Bad name."}, func(context.Context, *telebot.Message) error { return nil }))
+	require.Error(t, b.RegisterCommand("/pager", CommandOptions{}, func(context.Context, *telebot.Message) error { return nil }), "the description is required")
+	require.Error(t, b.RegisterCommand("/pager", CommandOptions{Description: "Page."}, nil))
+
+	b.handlersOnce.Do(b.registerHandlers)
+	require.EqualError(t, b.RegisterCommand("/pager", CommandOptions{Description: "Page."}, func(context.Context, *telebot.Message) error { return nil }),
+		"commands have to be registered before the bot runs")
+
+	oncall := handlerOf(t, tb, "/oncall")
+	stranger := &telebot.User{ID: 999}
+	strangerChat := &telebot.Chat{ID: 999, Type: telebot.ChatPrivate}
+	oncall(&telebot.Message{Sender: stranger, Chat: strangerChat, Text: "/oncall"})
+	require.Zero(t, calls, "only admins can use commands that aren't public")
+	require.Empty(t, tb.messages())
+
+	count, _ := commandSamples(t, b, "/oncall")
+	oncall(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/oncall@alertmanager_bot"})
+	require.Equal(t, 1, calls)
+	require.Equal(t, "Alice is on call.", tb.lastText())
+	after, _ := commandSamples(t, b, "/oncall")
+	require.Equal(t, count+1, after, "custom commands are timed like the built-in ones")
+
+	oncall(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/oncall"})
+	require.Equal(t, 1, calls, "the rate limit holds the command back")
+	require.Equal(t, "/oncall can be used again in 5s.", tb.lastText())
+
+	handlerOf(t, tb, "/rota")(&telebot.Message{Sender: stranger, Chat: strangerChat, Text: "/rota"})
+	require.Equal(t, "Alice, then Bob.", tb.lastText(), "public commands are answered for everyone")
+
+	errors := testutil.ToFloat64(b.commandErrors.WithLabelValues("/boom"))
+	handlerOf(t, tb, "/boom")(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/boom"})
+	require.Equal(t, errors+1, testutil.ToFloat64(b.commandErrors.WithLabelValues("/boom")), "a panic counts as the command's error")
+
+	require.NoError(t, b.handleHelp(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandHelp}))
+	require.Contains(t, tb.lastText(), "\n/oncall - Show who's on call.\n/rota - Show the rota.\n")
+}
+
+func TestCustomCommandsOnFollowers(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithReplicaRole(RoleFollower))
+	noop := func(ctx context.Context, m *telebot.Message) error {
+		_, err := b.reply(m, "done")
+		return err
+	}
+	require.NoError(t, b.RegisterCommand("/rota", CommandOptions{Description: "Show the rota.", ReadOnly: true}, noop))
+	require.NoError(t, b.RegisterCommand("/swap", CommandOptions{Description: "Swap shifts."}, noop))
+	b.handlersOnce.Do(b.registerHandlers)
+
+	handlerOf(t, tb, "/rota")(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/rota"})
+	require.Equal(t, "done", tb.lastText())
+	handlerOf(t, tb, "/swap")(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/swap"})
+	require.Equal(t, responseReadOnlyReplica, tb.lastText())
+}
+
+func TestCommandMenu(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &menuTelebot{fakeTelebot: &fakeTelebot{}}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithCommandMenu(true))
+	require.NoError(t, err)
+	require.NoError(t, b.RegisterCommand("/oncall", CommandOptions{Description: "Show who's on call."}, func(context.Context, *telebot.Message) error { return nil }))
+	b.handlersOnce.Do(b.registerHandlers)
+
+	menu := map[string]string{}
+	for _, c := range tb.commands {
+		menu[c.Text] = c.Description
+	}
+	require.Equal(t, "Show who's on call.", menu["oncall"])
+	require.Equal(t, "Subscribe for alerts.", menu["start"])
+	require.NotContains(t, menu, "help", "/help isn't listed in itself")
+}
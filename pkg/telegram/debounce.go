@@ -0,0 +1,328 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandDebounce = "/debounce"
+
+	// debounceCheckInterval is how often held alerts are checked for being due.
+	debounceCheckInterval = time.Second
+	// debounceMemory is how long the alerts released or dropped by debouncing are remembered, so that their
+	// repeated notifications aren't held or sent again.
+	debounceMemory = 24 * time.Hour
+	// debounceRetryDelay is how long released alerts that couldn't be delivered are held again before the next attempt.
+	debounceRetryDelay = 30 * time.Second
+
+	responseDebounceUsage = "Usage: " + CommandDebounce + " 3m to hold firing alerts for 3 minutes and drop those resolving meanwhile, " + CommandDebounce + " off to send them right away."
+)
+
+// WithDebounceBypass sends alerts at least as severe as minSeverity right away, even to chats that debounce.
+// Empty, the default, debounces alerts of all severities.
+func WithDebounceBypass(minSeverity string) BotOption {
+	return func(b *Bot) error {
+		b.debounceBypass = strings.TrimSpace(minSeverity)
+		return nil
+	}
+}
+
+// SetDebounce sets how long the chat's firing alerts are held, 0 sends them right away.
+func (s *ChatStore) SetDebounce(id int64, d time.Duration) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Debounce = d
+		return nil
+	})
+}
+
+// pendingAlert is a firing alert held by debouncing.
+type pendingAlert struct {
+	alert template.Alert
+	// webhook is the last webhook the alert came with, the released notification is based on it.
+	webhook alertmanager.TelegramWebhook
+	due     time.Time
+	delay   time.Duration
+}
+
+// debouncer holds the firing alerts of chats that debounce until their delay passed, by chat and fingerprint.
+// It's in memory only: alerts held when the bot stops are lost, they come again with Alertmanager's next
+// repeat of their notification.
+type debouncer struct {
+	mu      sync.Mutex
+	pending map[int64]map[string]*pendingAlert
+	// settled are the alerts released or dropped, with when, so that their repeats pass or stay dropped.
+	released map[int64]map[string]time.Time
+	dropped  map[int64]map[string]time.Time
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{
+		pending:  map[int64]map[string]*pendingAlert{},
+		released: map[int64]map[string]time.Time{},
+		dropped:  map[int64]map[string]time.Time{},
+	}
+}
+
+// debounceEntry returns the chat's map of fingerprints, creating it if needed.
+func debounceEntry(m map[int64]map[string]time.Time, chatID int64) map[string]time.Time {
+	entries, ok := m[chatID]
+	if !ok {
+		entries = map[string]time.Time{}
+		m[chatID] = entries
+	}
+	return entries
+}
+
+// filter returns the alerts of the webhook to send now, holding the new firing ones for delay.
+// Resolved alerts whose firing notification is still held are dropped together with it, dropped counts them.
+// Alerts without a fingerprint and those bypass tells to are never held.
+func (d *debouncer) filter(w alertmanager.TelegramWebhook, alerts template.Alerts, delay time.Duration, bypass func(template.Alert) bool, now time.Time) (send template.Alerts, dropped int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := d.pending[w.ChatID]
+	send = make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		fp := a.Fingerprint
+		if fp == "" || bypass(a) {
+			send = append(send, a)
+			continue
+		}
+		if a.Status == "resolved" {
+			switch {
+			case pending[fp] != nil:
+				delete(pending, fp)
+				debounceEntry(d.dropped, w.ChatID)[fp] = now
+				dropped++
+			case !d.dropped[w.ChatID][fp].IsZero():
+				// Its firing notification was never sent, neither is this one.
+			default:
+				delete(d.released[w.ChatID], fp)
+				send = append(send, a)
+			}
+			continue
+		}
+
+		switch {
+		case pending[fp] != nil:
+			pending[fp].alert, pending[fp].webhook = a, w
+		case !d.released[w.ChatID][fp].IsZero():
+			debounceEntry(d.released, w.ChatID)[fp] = now
+			send = append(send, a)
+		default:
+			if pending == nil {
+				pending = map[string]*pendingAlert{}
+				d.pending[w.ChatID] = pending
+			}
+			delete(d.dropped[w.ChatID], fp)
+			pending[fp] = &pendingAlert{alert: a, webhook: w, due: now.Add(delay), delay: delay}
+		}
+	}
+	return send, dropped
+}
+
+// debouncedWebhook is a webhook of held alerts that are due, with the longest delay they were held for.
+type debouncedWebhook struct {
+	webhook alertmanager.TelegramWebhook
+	delay   time.Duration
+	// held are the alerts of the webhook as they were held, released at releasedAt, for holding them again.
+	held       []*pendingAlert
+	releasedAt time.Time
+}
+
+// due takes the held alerts whose delay passed and returns them as a webhook per chat and alert group.
+// They are marked released right away, so that repeats and resolutions coming while they're being delivered
+// are sent; if the delivery fails, retry holds them again.
+// It also forgets the alerts released or dropped longer than debounceMemory ago.
+func (d *debouncer) due(now time.Time) []debouncedWebhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	type groupKey struct {
+		chatID int64
+		group  string
+	}
+	groups := map[groupKey][]*pendingAlert{}
+	for chatID, pending := range d.pending {
+		for fp, p := range pending {
+			if now.Before(p.due) {
+				continue
+			}
+			key := groupKey{chatID: chatID, group: p.webhook.Message.GroupKey}
+			groups[key] = append(groups[key], p)
+			delete(pending, fp)
+			debounceEntry(d.released, chatID)[fp] = now
+		}
+		if len(pending) == 0 {
+			delete(d.pending, chatID)
+		}
+	}
+	for _, settled := range []map[int64]map[string]time.Time{d.released, d.dropped} {
+		for chatID, entries := range settled {
+			for fp, at := range entries {
+				if now.Sub(at) > debounceMemory {
+					delete(entries, fp)
+				}
+			}
+			if len(entries) == 0 {
+				delete(settled, chatID)
+			}
+		}
+	}
+
+	keys := make([]groupKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].chatID != keys[j].chatID {
+			return keys[i].chatID < keys[j].chatID
+		}
+		return keys[i].group < keys[j].group
+	})
+
+	webhooks := make([]debouncedWebhook, 0, len(keys))
+	for _, key := range keys {
+		held := groups[key]
+		sort.Slice(held, func(i, j int) bool { return held[i].due.Before(held[j].due) })
+		alerts := make(template.Alerts, 0, len(held))
+		var delay time.Duration
+		for _, p := range held {
+			alerts = append(alerts, p.alert)
+			if p.delay > delay {
+				delay = p.delay
+			}
+		}
+		last := held[len(held)-1].webhook
+		webhooks = append(webhooks, debouncedWebhook{webhook: statusPart(last, "firing", alerts), delay: delay, held: held, releasedAt: now})
+	}
+	return webhooks
+}
+
+// retry holds the alerts of a released webhook that couldn't be delivered again, until debounceRetryDelay passed.
+// Alerts that were repeated or resolved since they were released are left alone, those have been sent meanwhile.
+func (d *debouncer) retry(dw debouncedWebhook, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	chatID := dw.webhook.ChatID
+	for _, p := range dw.held {
+		fp := p.alert.Fingerprint
+		if at, ok := d.released[chatID][fp]; !ok || !at.Equal(dw.releasedAt) {
+			continue
+		}
+		delete(d.released[chatID], fp)
+		pending := d.pending[chatID]
+		if pending == nil {
+			pending = map[string]*pendingAlert{}
+			d.pending[chatID] = pending
+		}
+		p.due = now.Add(debounceRetryDelay)
+		pending[fp] = p
+	}
+}
+
+// bypassesDebounce tells if the alert is severe enough to be sent right away.
+func (b *Bot) bypassesDebounce(a template.Alert) bool {
+	if b.debounceBypass == "" {
+		return false
+	}
+	s := a.Labels[labelSeverity]
+	return b.severityOrdering.known(s) && b.severityOrdering.known(b.debounceBypass) && b.severityOrdering.compare(s, b.debounceBypass) >= 0
+}
+
+// debounce holds the chat's new firing alerts for its debounce delay, returning those to send now.
+// Digests, like the released alerts themselves, aren't debounced.
+func (b *Bot) debounce(ctx context.Context, ci *ChatInfo, w alertmanager.TelegramWebhook, alerts template.Alerts, now time.Time) template.Alerts {
+	if ci.Debounce <= 0 || ctx.Value(unsplitKey{}) != nil {
+		return alerts
+	}
+	send, dropped := b.debouncer.filter(w, alerts, ci.Debounce, b.bypassesDebounce, now)
+	if dropped > 0 {
+		b.debouncedAlerts.WithLabelValues("dropped").Add(float64(dropped))
+	}
+	return send
+}
+
+// releaseDebounced sends the held alerts whose delay passed, holding those that couldn't be sent again.
+func (b *Bot) releaseDebounced(ctx context.Context, now time.Time) {
+	for _, dw := range b.debouncer.due(now) {
+		header := fmt.Sprintf("<i>(delayed %s by debounce)</i>\n\n", formatExtension(dw.delay))
+		d, err := b.deliverWebhook(unsplit(ctx), dw.webhook, header)
+		if err == nil {
+			err = d.Failed
+		}
+		if err != nil {
+			level.Warn(b.webhookLogger(dw.webhook, nil)).Log("msg", "failed to send alerts held by debounce, holding them again", "retry_in", debounceRetryDelay, "err", err)
+			b.debouncer.retry(dw, now)
+			continue
+		}
+		b.debouncedAlerts.WithLabelValues("released").Add(float64(len(dw.webhook.Message.Alerts)))
+	}
+}
+
+// runDebounce releases the held alerts once they're due until the context is done.
+func (b *Bot) runDebounce(ctx context.Context) {
+	ticker := time.NewTicker(debounceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.releaseDebounced(ctx, now)
+		}
+	}
+}
+
+func (b *Bot) handleDebounce(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	var d time.Duration
+	switch payload {
+	case "":
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "get the debounce of this chat")
+			return err
+		}
+		if ci.Debounce <= 0 {
+			_, err = b.reply(message, b.responseText("debounce_none", b.localResponseContext(message))+"\n"+b.responseText("debounce_usage", b.localResponseContext(message)))
+			return err
+		}
+		_, err = b.reply(message, fmt.Sprintf("Firing alerts are held for %s in this chat.\n%s", formatExtension(ci.Debounce), b.responseText("debounce_usage", b.localResponseContext(message))))
+		return err
+	case "off":
+	default:
+		var err error
+		if d, err = parseExpiry(payload); err != nil {
+			_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, b.responseText("debounce_usage", b.localResponseContext(message))))
+			return err
+		}
+	}
+
+	if err := b.chats.SetDebounce(message.Chat.ID, d); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set debounce", "err", err)
+		_, err = b.replyStoreError(message, err, "set the debounce of this chat")
+		return err
+	}
+	if d == 0 {
+		_, err := b.respond(message, "debounce_off", responseContext(message))
+		return err
+	}
+	text := fmt.Sprintf("Firing alerts are held for %s in this chat now, those resolving meanwhile aren't sent at all.", formatExtension(d))
+	if b.debounceBypass != "" {
+		text += fmt.Sprintf(" Alerts of severity %s and above are sent right away.", b.debounceBypass)
+	}
+	_, err := b.reply(message, text)
+	return err
+}
@@ -0,0 +1,162 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func debounceAlert(status string, fingerprint string, severity string) template.Alert {
+	return template.Alert{Status: status, Fingerprint: fingerprint, Labels: template.KV{"alertname": "HighCPU-" + fingerprint, labelSeverity: severity}}
+}
+
+func noBypass(template.Alert) bool { return false }
+
+func TestDebouncerDropsAlertsResolvingWithinDelay(t *testing.T) {
+	d := newDebouncer()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	firing := mixedWebhook("firing", debounceAlert("firing", "a", "warning"))
+
+	send, dropped := d.filter(firing, firing.Message.Alerts, 3*time.Minute, noBypass, now)
+	require.Empty(t, send)
+	require.Zero(t, dropped)
+	send, _ = d.filter(firing, firing.Message.Alerts, 3*time.Minute, noBypass, now.Add(time.Minute))
+	require.Empty(t, send, "repeats of held alerts are held, too")
+
+	resolved := mixedWebhook("resolved", debounceAlert("resolved", "a", "warning"))
+	send, dropped = d.filter(resolved, resolved.Message.Alerts, 3*time.Minute, noBypass, now.Add(2*time.Minute))
+	require.Empty(t, send)
+	require.Equal(t, 1, dropped)
+	require.Empty(t, d.due(now.Add(10*time.Minute)))
+
+	send, dropped = d.filter(resolved, resolved.Message.Alerts, 3*time.Minute, noBypass, now.Add(11*time.Minute))
+	require.Empty(t, send, "repeats of dropped resolved alerts stay dropped")
+	require.Zero(t, dropped)
+}
+
+func TestDebouncerReleasesAlertsAfterDelay(t *testing.T) {
+	d := newDebouncer()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	firing := mixedWebhook("firing", debounceAlert("firing", "a", "warning"), debounceAlert("firing", "b", "warning"))
+	firing.Message.GroupKey = "{}:{alertname=\"HighCPU\"}"
+
+	send, _ := d.filter(firing, firing.Message.Alerts, 3*time.Minute, noBypass, now)
+	require.Empty(t, send)
+	require.Empty(t, d.due(now.Add(2*time.Minute)))
+
+	due := d.due(now.Add(3 * time.Minute))
+	require.Len(t, due, 1)
+	require.Equal(t, 3*time.Minute, due[0].delay)
+	require.Equal(t, testChat.ID, due[0].webhook.ChatID)
+	require.Equal(t, firing.Message.GroupKey, due[0].webhook.Message.GroupKey)
+	require.Len(t, due[0].webhook.Message.Alerts, 2)
+	require.Empty(t, d.due(now.Add(4*time.Minute)), "alerts are released once")
+
+	send, _ = d.filter(firing, firing.Message.Alerts, 3*time.Minute, noBypass, now.Add(5*time.Minute))
+	require.Len(t, send, 2, "repeats of released alerts are sent right away")
+	resolved := mixedWebhook("resolved", debounceAlert("resolved", "a", "warning"))
+	send, dropped := d.filter(resolved, resolved.Message.Alerts, 3*time.Minute, noBypass, now.Add(6*time.Minute))
+	require.Len(t, send, 1)
+	require.Zero(t, dropped)
+
+	d.due(now.Add(debounceMemory + 10*time.Minute))
+	require.Empty(t, d.released, "released alerts are forgotten after a while")
+}
+
+func TestDebounce(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithDebounceBypass("critical"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "3m"}))
+	require.Equal(t, "Firing alerts are held for 3m in this chat now, those resolving meanwhile aren't sent at all. Alerts of severity critical and above are sent right away.", tb.lastText())
+	ctx := context.Background()
+
+	t.Run("released", func(t *testing.T) {
+		released := testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("released"))
+		sent := len(tb.messages())
+		_, err := b.processWebhook(ctx, mixedWebhook("firing", debounceAlert("firing", "released", "warning")))
+		require.NoError(t, err)
+		require.Len(t, tb.messages(), sent)
+
+		b.releaseDebounced(ctx, time.Now().Add(2*time.Minute))
+		require.Len(t, tb.messages(), sent)
+		b.releaseDebounced(ctx, time.Now().Add(4*time.Minute))
+		require.Len(t, tb.messages(), sent+1)
+		require.Contains(t, tb.lastText(), "(delayed 3m by debounce)")
+		require.Contains(t, tb.lastText(), "HighCPU-released")
+		require.Equal(t, released+1, testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("released")))
+
+		_, err = b.processWebhook(ctx, mixedWebhook("resolved", debounceAlert("resolved", "released", "warning")))
+		require.NoError(t, err)
+		require.Len(t, tb.messages(), sent+2, "the resolution of a released alert is sent")
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		dropped := testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("dropped"))
+		sent := len(tb.messages())
+		_, err := b.processWebhook(ctx, mixedWebhook("firing", debounceAlert("firing", "spike", "warning")))
+		require.NoError(t, err)
+		_, err = b.processWebhook(ctx, mixedWebhook("resolved", debounceAlert("resolved", "spike", "warning")))
+		require.NoError(t, err)
+		b.releaseDebounced(ctx, time.Now().Add(4*time.Minute))
+		require.Len(t, tb.messages(), sent)
+		require.Equal(t, dropped+1, testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("dropped")))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		released := testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("released"))
+		sent := len(tb.messages())
+		_, err := b.processWebhook(ctx, mixedWebhook("firing", debounceAlert("firing", "unsent", "warning")))
+		require.NoError(t, err)
+		tb.sendErr = func() error { return errors.New("connection reset") }
+		now := time.Now().Add(4 * time.Minute)
+		b.releaseDebounced(ctx, now)
+		tb.sendErr = nil
+		require.Len(t, tb.messages(), sent)
+		require.Equal(t, released, testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("released")))
+
+		b.releaseDebounced(ctx, now.Add(debounceRetryDelay-time.Second))
+		require.Len(t, tb.messages(), sent, "alerts that couldn't be sent are held again")
+		b.releaseDebounced(ctx, now.Add(debounceRetryDelay))
+		require.Len(t, tb.messages(), sent+1)
+		require.Contains(t, tb.lastText(), "HighCPU-unsent")
+		require.Equal(t, released+1, testutil.ToFloat64(b.debouncedAlerts.WithLabelValues("released")))
+	})
+
+	t.Run("bypass", func(t *testing.T) {
+		sent := len(tb.messages())
+		_, err := b.processWebhook(ctx, mixedWebhook("firing", debounceAlert("firing", "outage", "critical")))
+		require.NoError(t, err)
+		require.Len(t, tb.messages(), sent+1)
+		require.NotContains(t, tb.lastText(), "debounce")
+	})
+}
+
+func TestHandleDebounce(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "Firing alerts are sent to this chat right away.\n"+responseDebounceUsage, tb.lastText())
+
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "soon"}))
+	require.Contains(t, tb.lastText(), responseDebounceUsage)
+
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "90s"}))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, ci.Debounce)
+	require.Contains(t, b.formatFilters(ci), "Debounce: 1m30s")
+
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	require.Equal(t, "Firing alerts are sent to this chat right away now.", tb.lastText())
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Zero(t, ci.Debounce)
+}
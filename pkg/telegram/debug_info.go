@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandDebugInfo = "/debug_info"
+
+	// debugInfoTimeout bounds checking Alertmanager for /debug_info.
+	debugInfoTimeout = 10 * time.Second
+)
+
+// storeDirectories are the directories of the store, counted in /debug_info.
+var storeDirectories = []string{
+	telegramChatsDirectory,
+	telegramMessagesDirectory,
+	telegramMessageBatchesDirectory,
+	telegramInvitesDirectory,
+	telegramOutboxDirectory,
+	telegramRemovedChatsDirectory,
+	telegramFingerprintsDirectory,
+	telegramFingerprintSegmentsDirectory,
+	telegramRoutesDirectory,
+	telegramPollDirectory,
+	telegramRepeatsDirectory,
+}
+
+// DebugInfo is the diagnostic bundle /debug_info sends, to attach when asking for support.
+// It holds no secrets: the configuration is masked like for /config and bot tokens are masked in errors.
+type DebugInfo struct {
+	Generated        time.Time         `json:"generated"`
+	Revision         string            `json:"revision"`
+	Uptime           string            `json:"uptime"`
+	Config           ConfigSnapshot    `json:"config"`
+	Store            DebugStore        `json:"store"`
+	Queues           DebugQueues       `json:"queues"`
+	Errors           []LoggedError     `json:"errors"`
+	DeliveryFailures []DeliveryFailure `json:"delivery_failures"`
+	Alertmanager     DebugAlertmanager `json:"alertmanager"`
+}
+
+// DebugStore is the health of the store, how many keys each of its directories has and how big its areas are.
+type DebugStore struct {
+	Healthy bool           `json:"healthy"`
+	Keys    map[string]int `json:"keys,omitempty"`
+	Areas   []AreaStats    `json:"areas,omitempty"`
+	Err     string         `json:"err,omitempty"`
+}
+
+// DebugQueues are the webhooks waiting to be delivered.
+type DebugQueues struct {
+	Webhooks        int `json:"webhooks"`
+	Chats           int `json:"chats"`
+	MaintenanceHeld int `json:"maintenance_held"`
+}
+
+// DeliveryFailure is a chat alerts failed to be delivered to.
+type DeliveryFailure struct {
+	ChatID           int64      `json:"chat_id"`
+	Chat             string     `json:"chat"`
+	FailedSends      int        `json:"failed_sends,omitempty"`
+	Unreachable      bool       `json:"unreachable,omitempty"`
+	UnreachableSince *time.Time `json:"unreachable_since,omitempty"`
+	BlockedSends     int        `json:"blocked_sends,omitempty"`
+	FallbackChatID   int64      `json:"fallback_chat_id,omitempty"`
+}
+
+// DebugAlertmanager is whether Alertmanager answered and which version it runs.
+type DebugAlertmanager struct {
+	Healthy bool   `json:"healthy"`
+	Version string `json:"version,omitempty"`
+	Latency string `json:"latency,omitempty"`
+	Err     string `json:"err,omitempty"`
+}
+
+// KeyCounts returns how many keys each directory of the store has.
+func (s *ChatStore) KeyCounts() (map[string]int, error) {
+	counts := make(map[string]int, len(storeDirectories))
+	for _, dir := range storeDirectories {
+		kvPairs, err := s.list(dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		counts[dir] = len(kvPairs)
+	}
+	return counts, nil
+}
+
+// DebugInfo assembles the diagnostic bundle.
+func (b *Bot) DebugInfo(ctx context.Context, now time.Time) DebugInfo {
+	info := DebugInfo{
+		Generated:        now.UTC(),
+		Revision:         b.revision,
+		Uptime:           now.Sub(b.startTime).Truncate(time.Second).String(),
+		Config:           b.ConfigSnapshot(),
+		Errors:           b.loggedErrors.errors(),
+		DeliveryFailures: []DeliveryFailure{},
+	}
+
+	info.Store.Healthy = b.chats.Healthy()
+	keys, err := b.chats.KeyCounts()
+	if err != nil {
+		info.Store.Err = err.Error()
+	}
+	info.Store.Keys = keys
+	for _, area := range storeAreaNames {
+		stats, err := b.chats.CheckArea(area)
+		if err != nil {
+			if info.Store.Err == "" {
+				info.Store.Err = err.Error()
+			}
+			continue
+		}
+		info.Store.Areas = append(info.Store.Areas, stats)
+	}
+
+	info.Queues.Webhooks, info.Queues.Chats = b.webhookQueues.depth()
+	info.Queues.MaintenanceHeld = b.maintenanceBuffer.len()
+
+	chats, err := b.chats.List()
+	if err != nil && info.Store.Err == "" {
+		info.Store.Err = err.Error()
+	}
+	for _, ci := range chats {
+		blocked := 0
+		if ci.Blocked != nil {
+			blocked = ci.Blocked.Count
+		}
+		if ci.FailedSends == 0 && !ci.Unreachable && blocked == 0 {
+			continue
+		}
+		failure := DeliveryFailure{
+			ChatID:         ci.Chat.ID,
+			Chat:           chatName(ci.Chat),
+			FailedSends:    ci.FailedSends,
+			Unreachable:    ci.Unreachable,
+			BlockedSends:   blocked,
+			FallbackChatID: ci.FallbackChatID,
+		}
+		if ci.Unreachable {
+			since := ci.UnreachableSince
+			failure.UnreachableSince = &since
+		}
+		info.DeliveryFailures = append(info.DeliveryFailures, failure)
+	}
+	sort.Slice(info.DeliveryFailures, func(i, j int) bool { return info.DeliveryFailures[i].ChatID < info.DeliveryFailures[j].ChatID })
+
+	info.Alertmanager = b.debugAlertmanager(ctx)
+	return info
+}
+
+// debugAlertmanager asks Alertmanager for its status.
+func (b *Bot) debugAlertmanager(ctx context.Context) DebugAlertmanager {
+	if b.alertmanager == nil {
+		return DebugAlertmanager{Err: "not configured"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, debugInfoTimeout)
+	defer cancel()
+
+	start := time.Now()
+	status, err := b.alertmanager.Status(ctx)
+	if err != nil {
+		return DebugAlertmanager{Err: maskTelegramToken(err.Error())}
+	}
+	am := DebugAlertmanager{Healthy: true, Latency: formatLatency(time.Since(start))}
+	if status != nil && status.VersionInfo != nil && status.VersionInfo.Version != nil {
+		am.Version = *status.VersionInfo.Version
+	}
+	return am
+}
+
+// debugInfoDocument is the bundle as a JSON document named after when it was generated.
+func debugInfoDocument(info DebugInfo) (*telebot.Document, error) {
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &telebot.Document{
+		File:     telebot.FromReader(strings.NewReader(string(out) + "\n")),
+		FileName: fmt.Sprintf("debug_info-%s.json", info.Generated.Format("20060102T150405Z")),
+		MIME:     "application/json",
+	}, nil
+}
+
+func (b *Bot) handleDebugInfo(message *telebot.Message) error {
+	doc, err := debugInfoDocument(b.DebugInfo(context.TODO(), time.Now()))
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to render debug info", "err", err)
+		_, err = b.respondFailed(message, "render the debug info", err)
+		return err
+	}
+	_, err = b.reply(message, doc)
+	return err
+}
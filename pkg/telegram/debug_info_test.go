@@ -0,0 +1,77 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestDebugInfoGolden(t *testing.T) {
+	version := "0.21.0"
+	am := &alertmanagertest.Alertmanager{AlertmanagerStatus: &models.AlertmanagerStatus{VersionInfo: &models.VersionInfo{Version: &version}}}
+	now := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+	b, _, chats := newTestBot(t,
+		WithAlertmanager(am),
+		WithRevision("v0.5.0"),
+		WithStartTime(now.Add(-26*time.Hour)),
+		WithStore("consul", "http://consul:8500?token=secret"),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+	)
+	b.loggedErrors.now = func() time.Time { return now.Add(-time.Minute) }
+
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.RecordDelivery(sharedGroup.ID, false))
+	require.NoError(t, chats.RecordDelivery(sharedGroup.ID, false))
+	require.NoError(t, chats.RecordBlockedSend(testChat.ID, now.Add(-time.Hour)))
+	level.Warn(b.logger).Log("msg", "failed to send alerts", "chat_id", sharedGroup.ID, "err", errors.New("telegram: bot was kicked from the group chat (403)"))
+
+	info := b.DebugInfo(context.Background(), now)
+	info.Alertmanager.Latency = "" // How long the fake takes isn't deterministic.
+	out, err := json.MarshalIndent(info, "", "  ")
+	require.NoError(t, err)
+
+	golden, err := ioutil.ReadFile("testdata/debug_info.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(golden), string(out))
+}
+
+func TestDebugInfoFailures(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Err: errors.New("connection refused")}
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	b, err := NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID, WithAlertmanager(am))
+	require.NoError(t, err)
+	kv.err = errors.New("Unexpected response code: 503")
+
+	info := b.DebugInfo(context.Background(), time.Now())
+	require.False(t, info.Alertmanager.Healthy)
+	require.Equal(t, "connection refused", info.Alertmanager.Err)
+	require.NotEmpty(t, info.Store.Err)
+	require.Empty(t, info.DeliveryFailures)
+}
+
+func TestDebugInfoCommand(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithAlertmanager(&alertmanagertest.Alertmanager{}))
+
+	require.NoError(t, b.handleDebugInfo(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandDebugInfo}))
+	doc, ok := tb.messages()[0].what.(*telebot.Document)
+	require.True(t, ok, "the bundle is sent as a document")
+	require.Equal(t, "application/json", doc.MIME)
+	require.Regexp(t, `^debug_info-\d{8}T\d{6}Z\.json$`, doc.FileName)
+
+	var info DebugInfo
+	require.NoError(t, json.NewDecoder(doc.FileReader).Decode(&info))
+	require.True(t, info.Alertmanager.Healthy)
+}
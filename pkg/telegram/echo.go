@@ -0,0 +1,184 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandEcho = "/echo"
+
+	// defaultEchoDuration is how long /echo on lasts without a duration, echoMaxDuration the longest allowed.
+	defaultEchoDuration = 10 * time.Minute
+	echoMaxDuration     = time.Hour
+	// echoInlineMax is the largest payload echoed in a message, larger ones are sent as a document.
+	echoInlineMax = 3500
+
+	echoLabel = "DEBUG ECHO"
+
+	responseEchoUsage = "Usage: " + CommandEcho + " on [duration]|off, for example " + CommandEcho + " on 10m"
+)
+
+// SetEcho echoes the webhooks of a chat until the given time, a zero time stops it.
+func (s *ChatStore) SetEcho(id int64, until time.Time) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.EchoUntil = until
+		return nil
+	})
+}
+
+// echoing tells if the chat's webhooks are echoed at now.
+func echoing(ci *ChatInfo, now time.Time) bool {
+	return ci != nil && ci.EchoUntil.After(now)
+}
+
+// webhookPayload returns the webhook's payload as JSON, the one it was received as if it was kept
+// and re-encoded otherwise, like for webhooks replayed from the outbox.
+func webhookPayload(w alertmanager.TelegramWebhook) ([]byte, bool, error) {
+	if len(w.Raw) > 0 {
+		return w.Raw, true, nil
+	}
+	payload, err := json.Marshal(w.Message)
+	return payload, false, err
+}
+
+// redactPayload redacts the webhook's payload for the chat like its notifications. Chats in strict mode get the
+// payload re-encoded from the redacted alerts, the others get the redaction patterns applied to each of its
+// strings. It tells if the payload was changed, a payload that can't be redacted is an error.
+func (b *Bot) redactPayload(ci *ChatInfo, w alertmanager.TelegramWebhook, payload []byte) ([]byte, bool, error) {
+	if ci.RedactStrict {
+		if w.Message.Data == nil {
+			return nil, false, errors.New("the webhook has no alerts to redact")
+		}
+		m := w.Message
+		m.Data = b.redactData(ci, w.Message.Data)
+		redactedPayload, err := json.Marshal(m)
+		return redactedPayload, true, err
+	}
+	if len(b.redactions) == 0 {
+		return payload, false, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, false, err
+	}
+	redactedPayload, err := json.Marshal(b.redactJSON(v))
+	return redactedPayload, true, err
+}
+
+// redactJSON applies the redaction patterns to the strings of a decoded JSON value.
+func (b *Bot) redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return b.redactValue(v)
+	case map[string]interface{}:
+		for k, value := range v {
+			v[k] = b.redactJSON(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = b.redactJSON(value)
+		}
+	}
+	return v
+}
+
+// echoWebhook sends the chat the payload of the webhook, redacted like its notifications, next to the
+// notification rendered from it. A payload that can't be redacted isn't echoed.
+func (b *Bot) echoWebhook(logger log.Logger, ci *ChatInfo, w alertmanager.TelegramWebhook, now time.Time) {
+	chat := ci.Chat
+	payload, received, err := webhookPayload(w)
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to encode webhook for echo", "err", err)
+		return
+	}
+	payload, redactedEcho, err := b.redactPayload(ci, w, payload)
+	if err != nil {
+		level.Warn(logger).Log("msg", "not echoing webhook, its payload can't be redacted", "err", err)
+		return
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, payload, "", "  "); err == nil {
+		payload = indented.Bytes()
+	}
+	label := echoLabel
+	switch {
+	case redactedEcho:
+		label += " (redacted)"
+	case !received:
+		label += " (re-encoded, the received payload wasn't kept)"
+	}
+
+	var what interface{}
+	options := &telebot.SendOptions{ParseMode: telebot.ModeHTML}
+	if len(payload) <= echoInlineMax {
+		what = fmt.Sprintf("<b>%s</b>\n<pre><code class=\"language-json\">%s</code></pre>", label, html.EscapeString(string(payload)))
+	} else {
+		what = &telebot.Document{
+			File:     telebot.FromReader(bytes.NewReader(payload)),
+			FileName: fmt.Sprintf("echo-%d-%s.json", chat.ID, now.UTC().Format("20060102T150405Z")),
+			MIME:     "application/json",
+			Caption:  label,
+		}
+		options = &telebot.SendOptions{}
+	}
+	if _, err := b.send(chat, what, "echo", options); err != nil {
+		level.Warn(logger).Log("msg", "failed to echo webhook", "err", err)
+	}
+}
+
+// checkEchoes stops echoing webhooks to the chats whose echo expired and tells them.
+func (b *Bot) checkEchoes(now time.Time) {
+	chats, err := b.chats.List()
+	if err != nil {
+		if !errors.Is(err, ErrChatNotFound) {
+			level.Warn(b.logger).Log("msg", "failed to list chats for echoes", "err", err)
+		}
+		return
+	}
+	for _, ci := range chats {
+		if ci.EchoUntil.IsZero() || echoing(&ci, now) {
+			continue
+		}
+		if err := b.chats.SetEcho(ci.Chat.ID, time.Time{}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to stop echo", "chat_id", ci.Chat.ID, "err", err)
+			continue
+		}
+		if _, err := b.send(ci.Chat, "Echoing webhooks to this chat expired, it's off now.", "echo"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to tell chat its echo expired", "chat_id", ci.Chat.ID, "err", err)
+		}
+	}
+}
+
+func (b *Bot) handleEcho(message *telebot.Message) error {
+	until, err := parseTimedSwitch(message.Payload, time.Now(), defaultEchoDuration, echoMaxDuration)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseEchoUsage))
+		return err
+	}
+
+	if err := b.chats.SetEcho(message.Chat.ID, until); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set echo", "err", err)
+		_, err = b.replyStoreError(message, err, "set the echo of webhooks")
+		return err
+	}
+
+	if until.IsZero() {
+		_, err = b.reply(message, "Webhooks to this chat aren't echoed anymore.")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Webhooks to this chat are echoed as %s next to their notifications until %s.",
+		echoLabel, until.UTC().Format("2006-01-02 15:04 UTC")))
+	return err
+}
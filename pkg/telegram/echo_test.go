@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"context"
+	"html"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestEchoWebhooks(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	echo := b.middleware(b.handleEcho)
+	echo(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandEcho + " on 2h", Payload: "on 2h"})
+	require.Contains(t, tb.lastText(), "duration must be between 0s and 1h0m0s")
+	echo(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandEcho + " on 10m", Payload: "on 10m"})
+	require.True(t, strings.HasPrefix(tb.lastText(), "Webhooks to this chat are echoed as DEBUG ECHO next to their notifications until "), tb.lastText())
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU", "environment": "prod"}})
+	w.Raw = []byte(`{"receiver":"telegram","status":"firing","alerts":[{"labels":{"alertname":"HighCPU","note":"<b>"}}]}`)
+	sent := len(tb.messages())
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	msgs := tb.messages()[sent:]
+	require.Len(t, msgs, 2, "the payload is echoed next to the notification")
+	require.True(t, strings.HasPrefix(msgs[0].text(), "<b>DEBUG ECHO</b>\n<pre><code class=\"language-json\">{\n  &#34;receiver&#34;: &#34;telegram&#34;,"), msgs[0].text())
+	require.Contains(t, html.UnescapeString(msgs[0].text()), `"note": "<b>"`, "the payload is escaped")
+	require.Contains(t, msgs[1].text(), "HighCPU")
+
+	// Webhooks without their payload, like those replayed from the outbox, are re-encoded.
+	w.Raw = nil
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Contains(t, tb.messages()[sent+2].text(), "DEBUG ECHO (re-encoded")
+
+	// Large payloads are sent as a document.
+	w.Raw = []byte(`{"receiver":"` + strings.Repeat("x", echoInlineMax) + `"}`)
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	doc, ok := tb.messages()[sent+4].what.(*telebot.Document)
+	require.True(t, ok)
+	require.Equal(t, "DEBUG ECHO", doc.Caption)
+	require.Equal(t, "application/json", doc.MIME)
+}
+
+func TestEchoExpires(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	now := time.Now()
+	require.NoError(t, chats.SetEcho(testChat.ID, now.Add(-time.Second)))
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}})
+	w.Raw = []byte(`{"status":"firing"}`)
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1, "an expired echo isn't sent, even before the scheduler turned it off")
+	require.NotContains(t, tb.lastText(), "DEBUG ECHO")
+
+	b.checkEchoes(now)
+	require.Equal(t, "Echoing webhooks to this chat expired, it's off now.", tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.True(t, ci.EchoUntil.IsZero())
+
+	b.checkEchoes(now)
+	require.Len(t, tb.messages(), 2, "chats are told once")
+}
+
+func TestEchoRedacted(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithRedactionPatterns(`hunter\d`),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetEcho(testChat.ID, time.Now().Add(10*time.Minute)))
+
+	w := mixedWebhook("firing", template.Alert{
+		Status:      "firing",
+		Labels:      template.KV{"alertname": "HighCPU", "environment": "prod", "instance": "db-1"},
+		Annotations: template.KV{"summary": "password hunter2 leaked"},
+	})
+	w.Raw = []byte(`{"receiver":"telegram","alerts":[{"labels":{"alertname":"HighCPU"},"annotations":{"summary":"password hunter2 leaked"},"values":[1.5,"hunter3"]}]}`)
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	echo := html.UnescapeString(tb.messages()[0].text())
+	require.True(t, strings.HasPrefix(echo, "<b>DEBUG ECHO (redacted)</b>"), echo)
+	require.NotContains(t, echo, "hunter2")
+	require.NotContains(t, echo, "hunter3")
+	require.Contains(t, echo, `"summary": "password `)
+	require.Contains(t, echo, "1.5", "numbers are kept as they were")
+
+	// Strict chats get the payload of the redacted alerts.
+	require.NoError(t, chats.SetRedactStrict(testChat, true))
+	sent := len(tb.messages())
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	echo = html.UnescapeString(tb.messages()[sent].text())
+	require.Contains(t, echo, "DEBUG ECHO (redacted)")
+	require.NotContains(t, echo, "hunter2")
+	require.NotContains(t, echo, "db-1", "strict chats don't get the other labels")
+
+	// A payload that can't be redacted isn't echoed.
+	require.NoError(t, chats.SetRedactStrict(testChat, false))
+	w.Raw = []byte(`{"summary":"hunter2`)
+	sent = len(tb.messages())
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), sent+1)
+	require.NotContains(t, tb.lastText(), "hunter2")
+}
@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hako/durafmt"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// defaultErrorReportWindow is how long the same error isn't reported to a chat again.
+const defaultErrorReportWindow = 10 * time.Minute
+
+// WithErrorReportWindow reports an error to a chat at most once per window d,
+// repeats are counted and summed up to the chat once the window closes. 0 reports every error.
+func WithErrorReportWindow(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d < 0 {
+			return fmt.Errorf("the error report window must not be negative, is %s", d)
+		}
+		b.errorReports.window = d
+		return nil
+	}
+}
+
+// errorKey is the kind of error that happened in a chat.
+type errorKey struct {
+	chatID   int64
+	category string
+}
+
+// errorWindow is an error reported to a chat, and how often it happened again since.
+type errorWindow struct {
+	errorKey
+	opened  time.Time
+	repeats int
+	// text is what the chat was told, empty if the error was only logged.
+	text string
+}
+
+// errorGovernor keeps errors from being reported to a chat over and over, in memory only.
+type errorGovernor struct {
+	mu      sync.Mutex
+	window  time.Duration
+	now     func() time.Time
+	windows map[errorKey]*errorWindow
+	// closed are the windows with repeats that closed before the next summary.
+	closed []*errorWindow
+}
+
+func newErrorGovernor(window time.Duration) *errorGovernor {
+	return &errorGovernor{window: window, now: time.Now, windows: map[errorKey]*errorWindow{}}
+}
+
+// report returns whether the error should be reported, it isn't if it was reported to the chat within the window.
+// text is what the chat is told, it's repeated in the summary.
+func (g *errorGovernor) report(chatID int64, category, text string) bool {
+	if g.window == 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := errorKey{chatID: chatID, category: category}
+	now := g.now()
+	if w, ok := g.windows[key]; ok {
+		if now.Sub(w.opened) < g.window {
+			w.repeats++
+			return false
+		}
+		if w.repeats > 0 {
+			g.closed = append(g.closed, w)
+		}
+	}
+	g.windows[key] = &errorWindow{errorKey: key, opened: now, text: text}
+	return true
+}
+
+// summaries returns the closed windows the error was repeated in and forgets all closed windows.
+func (g *errorGovernor) summaries() []*errorWindow {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	closed := g.closed
+	g.closed = nil
+	for key, w := range g.windows {
+		if now.Sub(w.opened) < g.window {
+			continue
+		}
+		delete(g.windows, key)
+		if w.repeats > 0 {
+			closed = append(closed, w)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].opened.Before(closed[j].opened) })
+	return closed
+}
+
+// storeErrorCategory tells store errors apart for the error governor.
+func storeErrorCategory(err error, action string) string {
+	var corrupt *ErrCorruptRecord
+	switch {
+	case errors.Is(err, ErrChatNotFound):
+		return "chat not subscribed"
+	case errors.Is(err, ErrStoreUnavailable):
+		return "store unavailable"
+	case errors.As(err, &corrupt):
+		return "corrupt record"
+	default:
+		return "failed to " + action
+	}
+}
+
+// replyError replies with the error, unless the same category of error was reported to the chat recently.
+func (b *Bot) replyError(message *telebot.Message, category, text string) (*telebot.Message, error) {
+	if !b.errorReports.report(message.Chat.ID, category, text) {
+		level.Debug(b.logger).Log("msg", "not reporting repeated error to the chat", "chat_id", message.Chat.ID, "category", category)
+		return nil, nil
+	}
+	return b.reply(message, text)
+}
+
+// replyStoreError replies with the store error through replyError.
+func (b *Bot) replyStoreError(message *telebot.Message, err error, action string) (*telebot.Message, error) {
+	return b.replyError(message, storeErrorCategory(err, action), b.storeErrorReply(err, action))
+}
+
+// errorLogger returns the logger for an error of the chat: lvl unless the category was logged for the chat recently,
+// repeats are logged at debug level.
+func (b *Bot) errorLogger(logger log.Logger, lvl func(log.Logger) log.Logger, chatID int64, category string) log.Logger {
+	if !b.errorReports.report(chatID, "log: "+category, "") {
+		return level.Debug(log.With(logger, "repeated", true))
+	}
+	return lvl(logger)
+}
+
+// summariseErrors tells the chats how often the errors reported to them happened again in the windows that closed.
+func (b *Bot) summariseErrors() {
+	for _, w := range b.errorReports.summaries() {
+		summary := fmt.Sprintf("This problem occurred %d more times in the last %s.", w.repeats, durafmt.Parse(b.errorReports.window))
+		if w.text == "" {
+			level.Warn(b.logger).Log("msg", "error repeated", "chat_id", w.chatID, "category", w.category, "repeats", w.repeats, "window", b.errorReports.window)
+			continue
+		}
+		if _, err := b.send(&telebot.Chat{ID: w.chatID}, w.text+"\n"+summary, "error summary"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send error summary", "chat_id", w.chatID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestErrorGovernorWindows(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	g := newErrorGovernor(10 * time.Minute)
+	g.now = clock.now
+
+	require.True(t, g.report(1, "store unavailable", "down"))
+	require.True(t, g.report(2, "store unavailable", "down"), "chats are governed on their own")
+	require.True(t, g.report(1, "failed to list alerts", "failed"), "categories are governed on their own")
+	for i := 0; i < 14; i++ {
+		clock.advance(30 * time.Second)
+		require.False(t, g.report(1, "store unavailable", "down"))
+	}
+	require.Empty(t, g.summaries(), "the window is still open")
+
+	clock.advance(4 * time.Minute)
+	summaries := g.summaries()
+	require.Len(t, summaries, 1, "windows without repeats aren't summed up")
+	require.Equal(t, int64(1), summaries[0].chatID)
+	require.Equal(t, "store unavailable", summaries[0].category)
+	require.Equal(t, 14, summaries[0].repeats)
+	require.Empty(t, g.summaries(), "a window is summed up once")
+
+	require.True(t, g.report(1, "store unavailable", "down"), "the error is reported again once the window closed")
+}
+
+func TestErrorGovernorReopenedWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	g := newErrorGovernor(time.Minute)
+	g.now = clock.now
+
+	require.True(t, g.report(1, "store unavailable", "down"))
+	require.False(t, g.report(1, "store unavailable", "down"))
+	clock.advance(2 * time.Minute)
+	require.True(t, g.report(1, "store unavailable", "down"), "the error is reported before the closed window is summed up")
+
+	summaries := g.summaries()
+	require.Len(t, summaries, 1, "the closed window is still summed up")
+	require.Equal(t, 1, summaries[0].repeats)
+}
+
+func TestErrorGovernorDisabled(t *testing.T) {
+	g := newErrorGovernor(0)
+	for i := 0; i < 3; i++ {
+		require.True(t, g.report(1, "store unavailable", "down"))
+	}
+	require.Empty(t, g.summaries())
+}
+
+func TestMuteStoreErrorsGoverned(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithEnvironments("prod,staging"), WithProjects("billing,frontend"))
+	require.NoError(t, err)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	clock := &fakeClock{t: time.Now()}
+	b.errorReports.now = clock.now
+
+	kv.err = errors.New("Unexpected response code: 503")
+	for i := 0; i < 15; i++ {
+		require.NoError(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute environment[prod]"}))
+	}
+	var unavailable int
+	for _, m := range tb.messages() {
+		if m.text() == "I can't reach my store right now, try again in a bit." {
+			unavailable++
+		}
+	}
+	require.Equal(t, 1, unavailable, "the chat is told once per window")
+
+	clock.advance(defaultErrorReportWindow)
+	b.summariseErrors()
+	require.Equal(t, "I can't reach my store right now, try again in a bit.\nThis problem occurred 14 more times in the last 10 minutes.", tb.lastText())
+}
+
+func TestAlertsErrorsGoverned(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{
+		Alerts: []*types.Alert{alertmanagertest.Alert("HighCPU").Environment("prod").Build()},
+		Errs:   map[string]error{"ListAlerts": errors.New("connection refused")},
+	}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithErrorReportWindow(time.Minute))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	clock := &fakeClock{t: time.Now()}
+	b.errorReports.now = clock.now
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAlerts}))
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAlerts}))
+	require.Len(t, tb.messages(), 1)
+	require.Equal(t, "failed to list alerts... connection refused", tb.lastText())
+
+	clock.advance(time.Minute)
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAlerts}))
+	require.Len(t, tb.messages(), 2, "the error is reported again once the window closed")
+
+	b.summariseErrors()
+	require.Equal(t, "failed to list alerts... connection refused\nThis problem occurred 1 more times in the last 1 minute.", tb.lastText())
+}
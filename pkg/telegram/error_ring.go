@@ -0,0 +1,119 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// defaultLoggedErrors is how many of the last warnings and errors the bot keeps for /debug_info.
+const defaultLoggedErrors = 50
+
+// telegramTokenInURL finds bot tokens in the Telegram API URLs that errors of HTTP requests contain.
+var telegramTokenInURL = regexp.MustCompile(`/bot[0-9]+:[A-Za-z0-9_-]+`)
+
+// LoggedError is a warning or an error the bot logged.
+type LoggedError struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"msg,omitempty"`
+	Err     string            `json:"err,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// errorRing keeps the last warnings and errors logged, the oldest are overwritten.
+type errorRing struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries []LoggedError
+	// next is where the next entry goes, once entries is full.
+	next int
+}
+
+func newErrorRing(size int) *errorRing {
+	return &errorRing{now: time.Now, entries: make([]LoggedError, 0, size)}
+}
+
+func (r *errorRing) add(e LoggedError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cap(r.entries) == 0 {
+		return
+	}
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// errors returns the kept entries, the oldest first.
+func (r *errorRing) errors() []LoggedError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]LoggedError, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// tee returns a logger logging to next that keeps the warnings and errors in the ring, too.
+func (r *errorRing) tee(next log.Logger) log.Logger {
+	return errorTee{next: next, ring: r}
+}
+
+// errorTee keeps the warnings and errors logged through it in a ring.
+type errorTee struct {
+	next log.Logger
+	ring *errorRing
+}
+
+func (t errorTee) Log(keyvals ...interface{}) error {
+	if e, ok := t.loggedError(keyvals); ok {
+		t.ring.add(e)
+	}
+	return t.next.Log(keyvals...)
+}
+
+// loggedError returns the entry for a log line at warning or error level.
+func (t errorTee) loggedError(keyvals []interface{}) (LoggedError, bool) {
+	var lvl string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == level.Key() {
+			if v, ok := keyvals[i+1].(level.Value); ok {
+				lvl = v.String()
+			}
+		}
+	}
+	if lvl != level.WarnValue().String() && lvl != level.ErrorValue().String() {
+		return LoggedError{}, false
+	}
+
+	e := LoggedError{Time: t.ring.now(), Level: lvl}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := maskTelegramToken(fmt.Sprint(keyvals[i+1]))
+		switch key {
+		case "level":
+		case "msg":
+			e.Message = value
+		case "err":
+			e.Err = value
+		default:
+			if e.Fields == nil {
+				e.Fields = map[string]string{}
+			}
+			e.Fields[key] = value
+		}
+	}
+	return e, true
+}
+
+// maskTelegramToken masks the bot tokens in Telegram API URLs.
+func maskTelegramToken(s string) string {
+	return telegramTokenInURL.ReplaceAllString(s, "/bot"+maskedSecret)
+}
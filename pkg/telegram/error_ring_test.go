@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorRingKeepsTheLast(t *testing.T) {
+	r := newErrorRing(3)
+	for i := 0; i < 5; i++ {
+		r.add(LoggedError{Message: fmt.Sprintf("error %d", i)})
+	}
+	var messages []string
+	for _, e := range r.errors() {
+		messages = append(messages, e.Message)
+	}
+	require.Equal(t, []string{"error 2", "error 3", "error 4"}, messages, "the oldest are overwritten, the oldest kept comes first")
+
+	require.Empty(t, newErrorRing(3).errors())
+	empty := newErrorRing(0)
+	empty.add(LoggedError{Message: "dropped"})
+	require.Empty(t, empty.errors())
+}
+
+func TestErrorTee(t *testing.T) {
+	var buf bytes.Buffer
+	r := newErrorRing(10)
+	clock := &fakeClock{t: time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)}
+	r.now = clock.now
+	logger := log.With(r.tee(log.NewLogfmtLogger(&buf)), "component", "bot")
+
+	level.Info(logger).Log("msg", "user subscribed")
+	level.Debug(logger).Log("msg", "chat found for webhook")
+	level.Warn(logger).Log("msg", "failed to send", "chat_id", 123, "err", errors.New(`Post "https://api.telegram.org/bot123456:AAE-secret_token/sendMessage": timeout`))
+	clock.advance(time.Minute)
+	level.Error(logger).Log("msg", "corrupt record", "key", "telegram/chats/1")
+
+	require.Contains(t, buf.String(), "user subscribed", "everything is still logged")
+	require.Contains(t, buf.String(), "chat found for webhook")
+	require.Equal(t, []LoggedError{
+		{
+			Time:    clock.t.Add(-time.Minute),
+			Level:   "warn",
+			Message: "failed to send",
+			Err:     `Post "https://api.telegram.org/botxxxxx/sendMessage": timeout`,
+			Fields:  map[string]string{"component": "bot", "chat_id": "123"},
+		},
+		{
+			Time:    clock.t,
+			Level:   "error",
+			Message: "corrupt record",
+			Fields:  map[string]string{"component": "bot", "key": "telegram/chats/1"},
+		},
+	}, r.errors())
+}
+
+func TestErrorTeeConcurrent(t *testing.T) {
+	r := newErrorRing(defaultLoggedErrors)
+	logger := r.tee(log.NewNopLogger())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				level.Warn(logger).Log("msg", "failed", "worker", i)
+				_ = r.errors()
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Len(t, r.errors(), defaultLoggedErrors)
+}
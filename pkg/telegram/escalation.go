@@ -0,0 +1,285 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandEscalateTo = "/escalate_to"
+	CommandEscalation = "/escalation"
+
+	// escalationTimeout is the longest an escalation check waits for Alertmanager.
+	escalationTimeout = 30 * time.Second
+
+	responseEscalateToUsage = "Usage: " + CommandEscalateTo + " @username to mention them in escalations, " + CommandEscalateTo + " off to stop."
+	responseEscalationUsage = "Usage: " + CommandEscalation + " 1h,4h to escalate alerts firing that long, " +
+		CommandEscalation + " default to use the bot's thresholds or " + CommandEscalation + " off to stop."
+)
+
+// telegramUsername is what Telegram allows as usernames, mentioned with an @.
+var telegramUsername = regexp.MustCompile(`^@[A-Za-z][A-Za-z0-9_]{4,31}$`)
+
+// WithEscalation sends another message about alerts still firing after each of the thresholds, like 1h and 4h,
+// once per threshold. Chats can choose their own thresholds with /escalation.
+func WithEscalation(thresholds ...time.Duration) BotOption {
+	return func(b *Bot) error {
+		t, err := normalizeThresholds(thresholds)
+		if err != nil {
+			return err
+		}
+		b.escalationThresholds = t
+		return nil
+	}
+}
+
+// normalizeThresholds sorts the thresholds and drops duplicates, failing for thresholds that aren't positive.
+func normalizeThresholds(thresholds []time.Duration) ([]time.Duration, error) {
+	var t []time.Duration
+	for _, d := range thresholds {
+		if d <= 0 {
+			return nil, fmt.Errorf("escalation thresholds must be positive, one is %s", d)
+		}
+		t = append(t, d)
+	}
+	sort.Slice(t, func(i, j int) bool { return t[i] < t[j] })
+	unique := t[:0]
+	for i, d := range t {
+		if i == 0 || t[i-1] != d {
+			unique = append(unique, d)
+		}
+	}
+	return unique, nil
+}
+
+// escalationThresholdsFor returns the thresholds the chat's alerts are escalated after, none if it doesn't escalate.
+func (b *Bot) escalationThresholdsFor(ci *ChatInfo) []time.Duration {
+	if ci.EscalationOff {
+		return nil
+	}
+	if len(ci.EscalationThresholds) > 0 {
+		return ci.EscalationThresholds
+	}
+	return b.escalationThresholds
+}
+
+// crossedThreshold returns the highest threshold the alert firing for d crossed, 0 if none.
+func crossedThreshold(thresholds []time.Duration, d time.Duration) time.Duration {
+	var crossed time.Duration
+	for _, t := range thresholds {
+		if d >= t {
+			crossed = t
+		}
+	}
+	return crossed
+}
+
+// checkEscalations escalates the alerts of every chat that crossed another threshold since the last check.
+// Which alerts a chat got is known from the records of the messages sent to it.
+func (b *Bot) checkEscalations(ctx context.Context, now time.Time) {
+	chats, err := b.chats.List()
+	if err != nil {
+		if !errors.Is(err, ErrChatNotFound) {
+			level.Warn(b.logger).Log("msg", "failed to list chats for escalations", "err", err)
+		}
+		return
+	}
+	var escalating []ChatInfo
+	for _, ci := range chats {
+		if !ci.Unreachable && !ci.Paused && len(b.escalationThresholdsFor(&ci)) > 0 {
+			escalating = append(escalating, ci)
+		}
+	}
+	if len(escalating) == 0 {
+		return
+	}
+
+	records, err := b.chats.ListMessages()
+	if err != nil && !errors.Is(err, ErrMessageStoreEmpty) {
+		level.Warn(b.logger).Log("msg", "failed to list messages for escalations", "err", err)
+		return
+	}
+	notified := map[int64]map[string]bool{}
+	for _, r := range records {
+		if notified[r.ChatID] == nil {
+			notified[r.ChatID] = map[string]bool{}
+		}
+		for _, fp := range r.Fingerprints {
+			notified[r.ChatID][fp] = true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, escalationTimeout)
+	defer cancel()
+	alerts, err := b.alertmanager.ListAlerts(ctx, correlationAllReceivers, false)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list alerts for escalations", "err", err)
+		return
+	}
+	firing := firingAlerts(templateAlerts(alerts))
+
+	for i := range escalating {
+		b.escalateChat(&escalating[i], b.chatAlerts(&escalating[i], firing), notified[escalating[i].Chat.ID], now)
+	}
+}
+
+// escalateChat sends the chat one message about its alerts that crossed another threshold
+// and remembers the thresholds crossed by the alerts still firing.
+func (b *Bot) escalateChat(ci *ChatInfo, firing template.Alerts, notified map[string]bool, now time.Time) {
+	thresholds := b.escalationThresholdsFor(ci)
+	escalated := map[string]time.Duration{}
+	var lines []string
+	for _, a := range firing {
+		if !notified[a.Fingerprint] || a.StartsAt.IsZero() {
+			continue
+		}
+		crossed := crossedThreshold(thresholds, now.Sub(a.StartsAt))
+		if crossed == 0 {
+			continue
+		}
+		escalated[a.Fingerprint] = crossed
+		if crossed > ci.Escalated[a.Fingerprint] {
+			lines = append(lines, fmt.Sprintf("⏰ %s has been firing for %s and is still unresolved", a.Labels["alertname"], formatExtension(crossed)))
+		}
+	}
+
+	if len(lines) > 0 {
+		sort.Strings(lines)
+		text := strings.Join(lines, "\n")
+		if ci.EscalationContact != "" {
+			text = text + "\n" + ci.EscalationContact
+		}
+		if _, err := b.send(ci.Chat, text, "escalation"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send escalation", "chat_id", ci.Chat.ID, "err", err)
+			// They're escalated on the next check.
+			return
+		}
+	}
+
+	if len(escalated) == len(ci.Escalated) && len(lines) == 0 {
+		return
+	}
+	if err := b.chats.SetEscalated(ci.Chat.ID, escalated); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to store escalated alerts", "chat_id", ci.Chat.ID, "err", err)
+	}
+}
+
+// SetEscalationContact sets who escalations in the chat mention, "" mentions nobody.
+func (s *ChatStore) SetEscalationContact(c *telebot.Chat, contact string) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.EscalationContact = contact
+		return nil
+	})
+}
+
+// SetEscalation sets after how long alerts of the chat are escalated, none uses the bot's thresholds.
+// With off the chat's alerts aren't escalated at all.
+func (s *ChatStore) SetEscalation(c *telebot.Chat, thresholds []time.Duration, off bool) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.EscalationThresholds = thresholds
+		ci.EscalationOff = off
+		return nil
+	})
+}
+
+// SetEscalated remembers the highest threshold each firing alert of the chat was escalated for.
+func (s *ChatStore) SetEscalated(id int64, escalated map[string]time.Duration) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		if len(escalated) == 0 {
+			escalated = nil
+		}
+		ci.Escalated = escalated
+		return nil
+	})
+}
+
+func (b *Bot) handleEscalateTo(message *telebot.Message) error {
+	contact := strings.TrimSpace(message.Payload)
+	switch {
+	case contact == "off":
+		contact = ""
+	case !telegramUsername.MatchString(contact):
+		_, err := b.reply(message, responseEscalateToUsage)
+		return err
+	}
+
+	if err := b.chats.SetEscalationContact(message.Chat, contact); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set escalation contact", "err", err)
+		_, err = b.replyStoreError(message, err, "set the escalation contact")
+		return err
+	}
+
+	if contact == "" {
+		_, err := b.reply(message, "Escalations in this chat won't mention anyone anymore.")
+		return err
+	}
+	_, err := b.reply(message, fmt.Sprintf("Escalations in this chat will mention %s.", contact))
+	return err
+}
+
+// formatThresholds lists thresholds like 1h, 4h.
+func formatThresholds(thresholds []time.Duration) string {
+	formatted := make([]string, 0, len(thresholds))
+	for _, t := range thresholds {
+		formatted = append(formatted, formatExtension(t))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func (b *Bot) handleEscalation(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	var thresholds []time.Duration
+	var off bool
+	switch payload {
+	case "":
+		_, err := b.reply(message, responseEscalationUsage)
+		return err
+	case "off":
+		off = true
+	case "default":
+	default:
+		var parsed []time.Duration
+		for _, field := range strings.Split(payload, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(field))
+			if err != nil {
+				_, err = b.reply(message, responseEscalationUsage)
+				return err
+			}
+			parsed = append(parsed, d)
+		}
+		var err error
+		if thresholds, err = normalizeThresholds(parsed); err != nil {
+			_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseEscalationUsage))
+			return err
+		}
+	}
+
+	if err := b.chats.SetEscalation(message.Chat, thresholds, off); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set escalation thresholds", "err", err)
+		_, err = b.replyStoreError(message, err, "set the escalation thresholds")
+		return err
+	}
+
+	switch {
+	case off:
+		_, err := b.reply(message, "Alerts in this chat won't be escalated anymore.")
+		return err
+	case len(thresholds) > 0:
+		_, err := b.reply(message, fmt.Sprintf("Alerts in this chat are escalated once they fire for %s.", formatThresholds(thresholds)))
+		return err
+	case len(b.escalationThresholds) > 0:
+		_, err := b.reply(message, fmt.Sprintf("Alerts in this chat are escalated once they fire for %s, like in all chats.", formatThresholds(b.escalationThresholds)))
+		return err
+	}
+	_, err := b.reply(message, "Alerts in this chat aren't escalated, the bot has no escalation thresholds.")
+	return err
+}
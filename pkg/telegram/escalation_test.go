@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// escalatingBot returns a bot escalating after 1h and 4h, with testChat notified about the alerts.
+func escalatingBot(t *testing.T, alerts ...*types.Alert) (*Bot, *fakeTelebot, *ChatStore, *alertmanagertest.Alertmanager) {
+	am := &alertmanagertest.Alertmanager{Alerts: alerts}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithEscalation(4*time.Hour, time.Hour, time.Hour))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	var fingerprints []string
+	for _, a := range alerts {
+		fingerprints = append(fingerprints, a.Fingerprint().String())
+	}
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1, SentAt: time.Now(), Fingerprints: fingerprints}))
+	return b, tb, chats, am
+}
+
+func TestEscalation(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Environment("prod").Build()
+	b, tb, chats, _ := escalatingBot(t, highCPU)
+	require.Equal(t, []time.Duration{time.Hour, 4 * time.Hour}, b.escalationThresholds)
+	clock := &fakeClock{t: highCPU.StartsAt.Add(59 * time.Minute)}
+
+	b.checkEscalations(context.Background(), clock.now())
+	require.Empty(t, tb.messages(), "no threshold is crossed yet")
+
+	clock.advance(2 * time.Minute)
+	b.checkEscalations(context.Background(), clock.now())
+	require.Len(t, tb.messages(), 1)
+	require.Equal(t, "⏰ HighCPU has been firing for 1h and is still unresolved", tb.lastText())
+
+	clock.advance(2 * time.Hour)
+	b.checkEscalations(context.Background(), clock.now())
+	require.Len(t, tb.messages(), 1, "every threshold is escalated once")
+
+	require.NoError(t, b.handleEscalateTo(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "@oncall_person"}))
+	clock.advance(time.Hour)
+	b.checkEscalations(context.Background(), clock.now())
+	require.Len(t, tb.messages(), 3)
+	require.Equal(t, "⏰ HighCPU has been firing for 4h and is still unresolved\n@oncall_person", tb.lastText())
+
+	clock.advance(10 * time.Hour)
+	b.checkEscalations(context.Background(), clock.now())
+	require.Len(t, tb.messages(), 3)
+
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{highCPU.Fingerprint().String(): 4 * time.Hour}, ci.Escalated)
+}
+
+func TestEscalationForgetsResolvedAlerts(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Environment("prod").Build()
+	b, tb, chats, am := escalatingBot(t, highCPU)
+	now := highCPU.StartsAt.Add(2 * time.Hour)
+	b.checkEscalations(context.Background(), now)
+	require.Len(t, tb.messages(), 1)
+
+	am.Alerts = nil
+	b.checkEscalations(context.Background(), now.Add(time.Minute))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.Escalated)
+}
+
+func TestEscalationOnlyNotifiedAlerts(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Environment("prod").Build()
+	b, tb, _, am := escalatingBot(t, highCPU)
+	am.Alerts = append(am.Alerts, alertmanagertest.Alert("DiskFull").Environment("prod").Build())
+
+	b.checkEscalations(context.Background(), highCPU.StartsAt.Add(2*time.Hour))
+	require.Len(t, tb.messages(), 1)
+	require.NotContains(t, tb.lastText(), "DiskFull", "the chat was never told about it")
+}
+
+func TestEscalationPerChat(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Environment("prod").Build()
+	b, tb, chats, _ := escalatingBot(t, highCPU)
+
+	require.NoError(t, b.handleEscalation(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "30m, 2h"}))
+	require.Equal(t, "Alerts in this chat are escalated once they fire for 30m, 2h.", tb.lastText())
+	b.checkEscalations(context.Background(), highCPU.StartsAt.Add(45*time.Minute))
+	require.Equal(t, "⏰ HighCPU has been firing for 30m and is still unresolved", tb.lastText())
+
+	require.NoError(t, b.handleEscalation(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	sent := len(tb.messages())
+	b.checkEscalations(context.Background(), highCPU.StartsAt.Add(5*time.Hour))
+	require.Len(t, tb.messages(), sent, "escalations are off in the chat")
+
+	require.NoError(t, b.handleEscalation(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "default"}))
+	require.Equal(t, "Alerts in this chat are escalated once they fire for 1h, 4h, like in all chats.", tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.False(t, ci.EscalationOff)
+	require.Empty(t, ci.EscalationThresholds)
+
+	require.NoError(t, b.handleEscalation(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "-1h"}))
+	require.Contains(t, tb.lastText(), "escalation thresholds must be positive")
+	require.NoError(t, b.handleEscalateTo(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "oncall"}))
+	require.Equal(t, responseEscalateToUsage, tb.lastText())
+}
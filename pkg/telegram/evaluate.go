@@ -0,0 +1,280 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// The outcomes of the rules of a Decision.
+const (
+	OutcomePass     = "pass"
+	OutcomeSuppress = "suppress"
+	// OutcomeRoute sends the alert to another chat, which may suppress it in turn.
+	OutcomeRoute = "route"
+)
+
+// The rules webhooks go through, in the order they apply.
+const (
+	ruleMaintenance     = "maintenance"
+	ruleUnreachable     = "unreachable"
+	ruleSubscription    = "subscription"
+	ruleUnlabeled       = "unlabeled"
+	ruleEnvironmentMute = "environment_mute"
+	ruleProjectMute     = "project_mute"
+	ruleMinSeverity     = "min_severity"
+	ruleResolved        = "resolved"
+	ruleSeverityRoute   = "severity_route"
+	ruleFailover        = "failover"
+)
+
+// apiEvaluateMaxBody is the most an alert posted to the evaluate endpoint may take.
+const apiEvaluateMaxBody = 1 << 20
+
+// RuleOutcome is what one rule did to an alert, and why.
+type RuleOutcome struct {
+	Rule    string `json:"rule"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason"`
+}
+
+// Decision is what the bot would do with an alert for a chat right now, with the rules it went through
+// in the order they apply. The rules after the one suppressing or routing the alert away aren't evaluated.
+// Inhibitions aren't, they're Alertmanager's business.
+type Decision struct {
+	ChatID    int64         `json:"chat_id"`
+	Delivered bool          `json:"delivered"`
+	Rules     []RuleOutcome `json:"rules"`
+	// Targets are the chats a message with the alert goes to, empty if it's suppressed everywhere.
+	Targets []int64 `json:"targets"`
+	// Routed are the decisions of the chats the alert is routed to, which apply their own filters.
+	Routed []Decision `json:"routed,omitempty"`
+}
+
+// suppressed returns the rule of the chat suppressing the alert, nil if none does.
+func (d Decision) suppressed() *RuleOutcome {
+	for i, r := range d.Rules {
+		if r.Outcome == OutcomeSuppress {
+			return &d.Rules[i]
+		}
+	}
+	return nil
+}
+
+// evaluateFilters runs the alert through the chat's mutes and minimum severity, the filters of chatAlerts.
+// It stops at the first one suppressing the alert and returns it, nil if the chat gets the alert.
+func (b *Bot) evaluateFilters(ci *ChatInfo, a template.Alert) ([]RuleOutcome, *suppression) {
+	rules := make([]RuleOutcome, 0, 3)
+	suppress := func(rule string, s *suppression) ([]RuleOutcome, *suppression) {
+		return append(rules, RuleOutcome{Rule: rule, Outcome: OutcomeSuppress, Reason: s.explain()}), s
+	}
+
+	env := muteValue(a.Labels[labelEnvironment], b.environments)
+	if contains(ci.MutedEnvironments, env) {
+		return suppress(ruleEnvironmentMute, &suppression{Label: labelEnvironment, Value: env})
+	}
+	rules = append(rules, RuleOutcome{Rule: ruleEnvironmentMute, Outcome: OutcomePass, Reason: fmt.Sprintf("%s '%s' isn't muted", labelEnvironment, env)})
+
+	pr := muteValue(a.Labels[labelProject], b.projects)
+	if contains(ci.MutedProjects, pr) {
+		return suppress(ruleProjectMute, &suppression{Label: labelProject, Value: pr})
+	}
+	rules = append(rules, RuleOutcome{Rule: ruleProjectMute, Outcome: OutcomePass, Reason: fmt.Sprintf("%s '%s' isn't muted", labelProject, pr)})
+
+	severity := a.Labels[labelSeverity]
+	if len(b.severityOrdering.atLeast(template.Alerts{a}, ci.MinSeverity)) == 0 {
+		return suppress(ruleMinSeverity, &suppression{Label: labelSeverity, Value: severity, MinSeverity: ci.MinSeverity})
+	}
+	var reason string
+	switch {
+	case !b.severityOrdering.known(ci.MinSeverity):
+		reason = "the chat has no minimum severity"
+	case !b.severityOrdering.known(severity):
+		reason = fmt.Sprintf("severity '%s' isn't known, the minimum severity doesn't apply to it", severity)
+	default:
+		reason = fmt.Sprintf("severity '%s' is at least the chat's minimum severity '%s'", severity, ci.MinSeverity)
+	}
+	return append(rules, RuleOutcome{Rule: ruleMinSeverity, Outcome: OutcomePass, Reason: reason}), nil
+}
+
+// evaluate tells what the bot would do with the alert in a webhook for the chat right now, without sending anything.
+func (b *Bot) evaluate(ci *ChatInfo, a template.Alert, now time.Time) (Decision, error) {
+	if m := b.activeMaintenance(now); m != nil {
+		reason := "maintenance is on, notifications are dropped until it's over. " + m.banner()
+		if b.maintenanceBuffering {
+			reason = "maintenance is on, it would be sent once it's over. " + m.banner()
+		}
+		return Decision{
+			ChatID:  ci.Chat.ID,
+			Rules:   []RuleOutcome{{Rule: ruleMaintenance, Outcome: OutcomeSuppress, Reason: reason}},
+			Targets: []int64{},
+		}, nil
+	}
+	d, err := b.evaluateChat(ci, a, false)
+	d.Rules = append([]RuleOutcome{{Rule: ruleMaintenance, Outcome: OutcomePass, Reason: "no maintenance is on"}}, d.Rules...)
+	return d, err
+}
+
+// evaluateChat is evaluate past maintenance, which only applies to webhooks as they come in.
+// Alerts that were routed by severity aren't routed again, like deliverWebhook does.
+func (b *Bot) evaluateChat(ci *ChatInfo, a template.Alert, severityRouted bool) (Decision, error) {
+	d := Decision{ChatID: ci.Chat.ID, Targets: []int64{}}
+	add := func(rule, outcome, reason string) {
+		d.Rules = append(d.Rules, RuleOutcome{Rule: rule, Outcome: outcome, Reason: reason})
+	}
+	route := func(chatID int64, severityRouted bool) error {
+		routed, err := b.evaluateRouted(chatID, a, severityRouted)
+		if err != nil {
+			return err
+		}
+		d.Routed = append(d.Routed, routed)
+		d.Targets = append(d.Targets, routed.Targets...)
+		d.Delivered = len(d.Targets) > 0
+		return nil
+	}
+
+	if ci.Unreachable {
+		add(ruleUnreachable, OutcomeSuppress, "the chat is marked unreachable")
+		return d, nil
+	}
+	add(ruleUnreachable, OutcomePass, "the chat is reachable")
+	if ci.Paused {
+		add(ruleSubscription, OutcomeSuppress, "the subscription of the chat expired, extend it with "+CommandExpire)
+		return d, nil
+	}
+	add(ruleSubscription, OutcomePass, "the chat is subscribed")
+
+	if missing := missingLabels(a); len(missing) == 0 {
+		add(ruleUnlabeled, OutcomePass, fmt.Sprintf("the alert has %s and %s labels", labelEnvironment, labelProject))
+	} else {
+		without := fmt.Sprintf("the alert has no %s label", strings.Join(missing, " or "))
+		switch {
+		case b.unlabeledPolicy == UnlabeledDrop:
+			add(ruleUnlabeled, OutcomeSuppress, without+", those alerts are dropped")
+			return d, nil
+		case b.unlabeledPolicy == UnlabeledAdmin && ci.Chat.ID != b.unlabeledChat:
+			add(ruleUnlabeled, OutcomeRoute, fmt.Sprintf("%s, those alerts go to the catch-all chat %d", without, b.unlabeledChat))
+			return d, route(b.unlabeledChat, severityRouted)
+		case b.unlabeledPolicy == UnlabeledAdmin:
+			add(ruleUnlabeled, OutcomePass, without+", this is the catch-all chat for those alerts")
+		case b.unlabeledPolicy == UnlabeledTag:
+			add(ruleUnlabeled, OutcomePass, without+", the message is tagged")
+		default:
+			add(ruleUnlabeled, OutcomePass, without+", it counts as "+otherValue)
+		}
+	}
+
+	filters, s := b.evaluateFilters(ci, a)
+	d.Rules = append(d.Rules, filters...)
+	if s != nil {
+		return d, nil
+	}
+
+	switch {
+	case a.Status != "resolved":
+		add(ruleResolved, OutcomePass, "the alert is firing")
+	case b.sendsResolved(ci):
+		add(ruleResolved, OutcomePass, "the chat gets resolved alerts")
+	default:
+		add(ruleResolved, OutcomeSuppress, "the alert is resolved and the chat doesn't get resolved alerts")
+		return d, nil
+	}
+
+	switch _, routed := routeBySeverity(ci.SeverityRoutes, template.Alerts{a}); {
+	case severityRouted:
+		add(ruleSeverityRoute, OutcomePass, "the alert was routed here by severity, it isn't routed again")
+	case len(routed) == 0:
+		add(ruleSeverityRoute, OutcomePass, fmt.Sprintf("no severity route of the chat is for severity '%s'", a.Labels[labelSeverity]))
+	default:
+		for i, r := range ci.SeverityRoutes {
+			if len(routed[i]) == 0 {
+				continue
+			}
+			if !r.Also {
+				add(ruleSeverityRoute, OutcomeRoute, "the alert is routed by "+r.String())
+				return d, route(r.ChatID, true)
+			}
+			add(ruleSeverityRoute, OutcomeRoute, "the alert is routed by "+r.String()+", and sent to the chat")
+			if err := route(r.ChatID, true); err != nil {
+				return d, err
+			}
+		}
+	}
+
+	target := ci.Chat.ID
+	switch {
+	case b.failingOver(ci):
+		add(ruleFailover, OutcomeRoute, fmt.Sprintf("%d sends to the chat failed in a row, its alerts go to the fallback chat %d", ci.FailedSends, ci.FallbackChatID))
+		target = ci.FallbackChatID
+	case ci.FallbackChatID != 0:
+		add(ruleFailover, OutcomePass, fmt.Sprintf("%d sends to the chat failed in a row, %d fail over to the fallback chat %d", ci.FailedSends, b.failoverThreshold, ci.FallbackChatID))
+	default:
+		add(ruleFailover, OutcomePass, "the chat has no fallback chat")
+	}
+	d.Targets = append([]int64{target}, d.Targets...)
+	d.Delivered = true
+	return d, nil
+}
+
+// evaluateRouted evaluates the alert for the chat it's routed to.
+func (b *Bot) evaluateRouted(chatID int64, a template.Alert, severityRouted bool) (Decision, error) {
+	ci, err := b.chats.GetChatInfo(chatID)
+	if errors.Is(err, ErrChatNotFound) {
+		return Decision{
+			ChatID:  chatID,
+			Rules:   []RuleOutcome{{Rule: ruleSubscription, Outcome: OutcomeSuppress, Reason: "the chat didn't subscribe"}},
+			Targets: []int64{},
+		}, nil
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+	return b.evaluateChat(ci, a, severityRouted)
+}
+
+// apiEvaluate evaluates the alert in the request body for the chat, the alert's status defaults to firing.
+func (b *Bot) apiEvaluate(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		b.writeAPI(w, http.StatusNotFound, apiError{Error: "chat not found"})
+		return
+	}
+	var a template.Alert
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiEvaluateMaxBody)).Decode(&a); err != nil {
+		b.writeAPI(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("failed to read the alert: %v", err)})
+		return
+	}
+	switch a.Status {
+	case "":
+		a.Status = "firing"
+	case "firing", "resolved":
+	default:
+		b.writeAPI(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("status must be firing or resolved, is %q", a.Status)})
+		return
+	}
+
+	ci, err := b.chats.GetChatInfo(id)
+	if errors.Is(err, ErrChatNotFound) {
+		b.writeAPI(w, http.StatusNotFound, apiError{Error: "chat not found"})
+		return
+	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat for the API", "chat_id", id, "err", err)
+		b.writeAPI(w, http.StatusInternalServerError, apiError{Error: "failed to get the chat"})
+		return
+	}
+	d, err := b.evaluate(ci, a, time.Now())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to evaluate alert for the API", "chat_id", id, "err", err)
+		b.writeAPI(w, http.StatusInternalServerError, apiError{Error: "failed to evaluate the alert"})
+		return
+	}
+	b.writeAPI(w, http.StatusOK, d)
+}
@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+)
+
+// postEvaluate posts the alert to the evaluate endpoint of the chat and decodes the response into v.
+func postEvaluate(t *testing.T, h http.Handler, chatID string, alert string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/chats/"+chatID+"/evaluate", bytes.NewBufferString(alert)))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	return rec.Code
+}
+
+// outcomes lists the rules of the decision as rule=outcome.
+func outcomes(d Decision) []string {
+	out := make([]string, 0, len(d.Rules))
+	for _, r := range d.Rules {
+		out = append(out, r.Rule+"="+r.Outcome)
+	}
+	return out
+}
+
+func TestEvaluate(t *testing.T) {
+	alert := func(status string, labels ...string) template.Alert {
+		a := template.Alert{Status: status, Labels: template.KV{}}
+		for i := 0; i+1 < len(labels); i += 2 {
+			a.Labels[labels[i]] = labels[i+1]
+		}
+		return a
+	}
+	firing := alert("firing", labelEnvironment, "prod", labelProject, "billing", labelSeverity, "critical")
+
+	for _, tc := range []struct {
+		name     string
+		opts     []BotOption
+		setup    func(t *testing.T, chats *ChatStore)
+		alert    template.Alert
+		outcomes []string
+		targets  []int64
+		reason   string
+	}{
+		{
+			name:     "delivered",
+			alert:    firing,
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=pass", "min_severity=pass", "resolved=pass", "severity_route=pass", "failover=pass"},
+			targets:  []int64{payments.ID},
+		},
+		{
+			name: "muted project",
+			setup: func(t *testing.T, chats *ChatStore) {
+				require.NoError(t, chats.MuteProjects(payments, []string{"billing"}, []string{"billing", "frontend", otherValue}))
+			},
+			alert:    firing,
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=suppress"},
+			targets:  []int64{},
+			reason:   "project 'billing' is muted",
+		},
+		{
+			name: "below minimum severity",
+			setup: func(t *testing.T, chats *ChatStore) {
+				ci, err := chats.GetChatInfo(payments.ID)
+				require.NoError(t, err)
+				ci.MinSeverity = "critical"
+				require.NoError(t, chats.putChatInfo(ci))
+			},
+			alert:    alert("firing", labelEnvironment, "prod", labelProject, "billing", labelSeverity, "warning"),
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=pass", "min_severity=suppress"},
+			targets:  []int64{},
+			reason:   "severity 'warning' is below the chat's minimum severity 'critical'",
+		},
+		{
+			name:     "resolved",
+			opts:     []BotOption{WithSendResolved(false)},
+			alert:    alert("resolved", labelEnvironment, "prod", labelProject, "billing"),
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=pass", "min_severity=pass", "resolved=suppress"},
+			targets:  []int64{},
+			reason:   "the alert is resolved and the chat doesn't get resolved alerts",
+		},
+		{
+			name:     "unlabeled dropped",
+			opts:     []BotOption{WithUnlabeledPolicy(UnlabeledDrop)},
+			alert:    alert("firing", labelEnvironment, "prod"),
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=suppress"},
+			targets:  []int64{},
+			reason:   "the alert has no project label, those alerts are dropped",
+		},
+		{
+			name:     "unlabeled to the catch-all chat",
+			opts:     []BotOption{WithUnlabeledPolicy(UnlabeledAdmin), WithUnlabeledChat(oncall.ID)},
+			alert:    alert("firing", labelSeverity, "critical"),
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=route"},
+			targets:  []int64{oncall.ID},
+		},
+		{
+			name: "routed by severity, muted in the target",
+			setup: func(t *testing.T, chats *ChatStore) {
+				require.NoError(t, chats.SetSeverityRoutes(payments.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: oncall.ID}}))
+				require.NoError(t, chats.MuteEnvironments(oncall, []string{"prod"}, []string{"prod", "staging", otherValue}))
+			},
+			alert:    firing,
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=pass", "min_severity=pass", "resolved=pass", "severity_route=route"},
+			targets:  []int64{},
+		},
+		{
+			name: "routed by severity and kept, failing over",
+			opts: []BotOption{WithFailover(1, time.Minute)},
+			setup: func(t *testing.T, chats *ChatStore) {
+				require.NoError(t, chats.SetSeverityRoutes(payments.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: oncall.ID, Also: true}}))
+				require.NoError(t, chats.SetFallback(payments, testChat.ID))
+				require.NoError(t, chats.RecordDelivery(payments.ID, false))
+			},
+			alert:    firing,
+			outcomes: []string{"maintenance=pass", "unreachable=pass", "subscription=pass", "unlabeled=pass", "environment_mute=pass", "project_mute=pass", "min_severity=pass", "resolved=pass", "severity_route=route", "failover=route"},
+			targets:  []int64{testChat.ID, oncall.ID},
+		},
+		{
+			name: "maintenance",
+			setup: func(t *testing.T, chats *ChatStore) {
+				require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now(), Reason: "network migration"}))
+			},
+			alert:    firing,
+			outcomes: []string{"maintenance=suppress"},
+			targets:  []int64{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _, chats := newTestBot(t, tc.opts...)
+			require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+			if tc.setup != nil {
+				tc.setup(t, chats)
+			}
+			ci, err := chats.GetChatInfo(payments.ID)
+			require.NoError(t, err)
+
+			d, err := b.evaluate(ci, tc.alert, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, tc.outcomes, outcomes(d))
+			require.Equal(t, tc.targets, d.Targets)
+			require.Equal(t, len(tc.targets) > 0, d.Delivered)
+			if tc.reason != "" {
+				require.Equal(t, tc.reason, d.suppressed().Reason)
+			}
+		})
+	}
+}
+
+func TestEvaluateRouted(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetSeverityRoutes(payments.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: oncall.ID}}))
+	require.NoError(t, chats.SetSeverityRoutes(oncall.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: payments.ID}}))
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+
+	d, err := b.evaluate(ci, template.Alert{Status: "firing", Labels: template.KV{labelEnvironment: "prod", labelProject: "billing", labelSeverity: "critical"}}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []int64{oncall.ID}, d.Targets)
+	require.Len(t, d.Routed, 1)
+	routed := d.Routed[0]
+	require.Equal(t, oncall.ID, routed.ChatID)
+	require.Equal(t, RuleOutcome{Rule: ruleSeverityRoute, Outcome: OutcomePass, Reason: "the alert was routed here by severity, it isn't routed again"}, routed.Rules[len(routed.Rules)-2])
+}
+
+func TestAPIEvaluate(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"staging"}, b.environmentsAndOther))
+	h := b.APIHandler()
+
+	var d Decision
+	require.Equal(t, http.StatusOK, postEvaluate(t, h, "-100", `{"labels": {"environment": "staging", "project": "billing"}, "startsAt": "2021-03-04T12:30:00Z"}`, &d))
+	require.False(t, d.Delivered)
+	require.Equal(t, payments.ID, d.ChatID)
+	require.Equal(t, RuleOutcome{Rule: ruleEnvironmentMute, Outcome: OutcomeSuppress, Reason: "environment 'staging' is muted"}, d.Rules[len(d.Rules)-1])
+
+	require.Equal(t, http.StatusOK, postEvaluate(t, h, "-100", `{"status": "firing", "labels": {"environment": "prod", "project": "billing"}}`, &d))
+	require.True(t, d.Delivered)
+	require.Equal(t, []int64{payments.ID}, d.Targets)
+
+	var apiErr apiError
+	require.Equal(t, http.StatusNotFound, postEvaluate(t, h, "-200", `{}`, &apiErr))
+	require.Equal(t, http.StatusBadRequest, postEvaluate(t, h, "-100", `{"status": "pending"}`, &apiErr))
+	require.Equal(t, `status must be firing or resolved, is "pending"`, apiErr.Error)
+	require.Equal(t, http.StatusBadRequest, postEvaluate(t, h, "-100", `{"labels": `, &apiErr))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/chats/-100/evaluate", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, "POST", rec.Header().Get("Allow"))
+}
@@ -0,0 +1,267 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandExpire = "/expire"
+
+	expireExtendUnique = "expire_extend"
+
+	// expiryWarning is how long before its subscription expires a chat is warned.
+	expiryWarning = 24 * time.Hour
+	// expiryExtension is how long the button of the warning extends the subscription by.
+	expiryExtension = 7 * 24 * time.Hour
+
+	responseExpireUsage = "Usage: " + CommandExpire + " 7d to stop sending alerts to this chat in 7 days, " + CommandExpire + " off to keep sending them."
+)
+
+// parseExpiry parses how long a subscription lasts, like 12h or 7d.
+func parseExpiry(s string) (time.Duration, error) {
+	md, err := model.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(md)
+	if d <= 0 {
+		return 0, fmt.Errorf("the duration must be positive, is %s", s)
+	}
+	return d, nil
+}
+
+// formatExpiry formats durations of whole days in days, like 7d, others like formatExtension.
+func formatExpiry(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return formatExtension(d)
+}
+
+// startExpiry returns how long the subscription of /start for 7d lasts and whether the payload asks for an expiry at all.
+func startExpiry(payload string) (time.Duration, bool, error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 || fields[0] != "for" {
+		return 0, false, nil
+	}
+	if len(fields) != 2 {
+		return 0, true, fmt.Errorf("expected a duration like %s for 7d", CommandStart)
+	}
+	d, err := parseExpiry(fields[1])
+	return d, true, err
+}
+
+// SetExpiry makes the chat's subscription expire at the given time, a zero time never.
+// The chat gets alerts again if it was paused because its subscription expired.
+func (s *ChatStore) SetExpiry(c *telebot.Chat, at time.Time) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.ExpiresAt = at
+		ci.ExpiryWarned = false
+		ci.Paused = false
+		ci.PausedSince = time.Time{}
+		return nil
+	})
+}
+
+// SetExpiryWarned remembers the chat was warned its subscription is about to expire.
+func (s *ChatStore) SetExpiryWarned(id int64) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.ExpiryWarned = true
+		return nil
+	})
+}
+
+// PauseChat stops sending alerts to the chat since the given time, keeping everything else about it.
+func (s *ChatStore) PauseChat(id int64, since time.Time) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Paused = true
+		ci.PausedSince = since
+		return nil
+	})
+}
+
+// formatExpiresAt tells when the chat's subscription expires, in the chat's timezone.
+func formatExpiresAt(ci *ChatInfo, now time.Time) string {
+	at := ci.ExpiresAt.In(chatLocation(ci)).Format("2006-01-02 15:04 MST")
+	if !ci.ExpiresAt.After(now) {
+		return at
+	}
+	return fmt.Sprintf("%s (in %s)", at, formatFiringDuration(ci.ExpiresAt.Sub(now)))
+}
+
+// expiryMarkup has the button extending the chat's subscription by expiryExtension.
+func expiryMarkup() *telebot.ReplyMarkup {
+	return &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: expireExtendUnique, Text: "Extend " + formatExpiry(expiryExtension), Data: formatExpiry(expiryExtension)},
+	}}}
+}
+
+// checkExpiries warns the chats whose subscription expires within expiryWarning, once,
+// and pauses the chats whose subscription expired, telling the admins.
+func (b *Bot) checkExpiries(now time.Time) {
+	chats, err := b.chats.List()
+	if err != nil {
+		if !errors.Is(err, ErrChatNotFound) {
+			level.Warn(b.logger).Log("msg", "failed to list chats for expiries", "err", err)
+		}
+		return
+	}
+	for _, ci := range chats {
+		if ci.ExpiresAt.IsZero() || ci.Paused {
+			continue
+		}
+		logger := log.With(b.logger, "chat_id", ci.Chat.ID, "expires_at", ci.ExpiresAt)
+		switch {
+		case !now.Before(ci.ExpiresAt):
+			b.expire(logger, &ci, now)
+		case !ci.ExpiryWarned && ci.ExpiresAt.Sub(now) <= expiryWarning:
+			b.warnExpiry(logger, &ci, now)
+		}
+	}
+}
+
+// warnExpiry tells the chat its subscription is about to expire and offers to extend it.
+func (b *Bot) warnExpiry(logger log.Logger, ci *ChatInfo, now time.Time) {
+	if err := b.chats.SetExpiryWarned(ci.Chat.ID); err != nil {
+		level.Warn(logger).Log("msg", "failed to record expiry warning", "err", err)
+		return
+	}
+	warning := fmt.Sprintf("This chat's subscription expires at %s, it won't get alerts after that.\n"+
+		"Extend it with the button or %s, or keep it with %s off.", formatExpiresAt(ci, now), CommandExpire, CommandExpire)
+	if _, err := b.send(ci.Chat, warning, "expiry", &telebot.SendOptions{ReplyMarkup: expiryMarkup()}); err != nil {
+		level.Warn(logger).Log("msg", "failed to warn chat about its expiry", "err", err)
+	}
+}
+
+// expire pauses the chat whose subscription expired and tells the chat and the admins.
+func (b *Bot) expire(logger log.Logger, ci *ChatInfo, now time.Time) {
+	if err := b.chats.PauseChat(ci.Chat.ID, now); err != nil {
+		level.Warn(logger).Log("msg", "failed to pause expired chat", "err", err)
+		return
+	}
+	level.Info(logger).Log("msg", "subscription expired, pausing the chat")
+
+	notice := fmt.Sprintf("This chat's subscription expired, it gets no alerts anymore.\n"+
+		"Use %s 7d or %s off to get them again.", CommandExpire, CommandExpire)
+	if _, err := b.send(ci.Chat, notice, "expiry"); err != nil {
+		level.Warn(logger).Log("msg", "failed to tell chat about its expiry", "err", err)
+	}
+	adminNotice := fmt.Sprintf("The subscription of %s expired, it's paused and gets no alerts until it's renewed with %s.",
+		chatName(ci.Chat), CommandExpire)
+	for _, admin := range b.admins {
+		b.SendAdminMessage(admin, adminNotice)
+	}
+}
+
+// extendExpiry extends the chat's subscription by d from when it expires, from now if it expired already.
+func (b *Bot) extendExpiry(chat *telebot.Chat, d time.Duration, now time.Time) (*ChatInfo, error) {
+	ci, err := b.chats.GetChatInfo(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	from := ci.ExpiresAt
+	if ci.Paused || from.Before(now) {
+		from = now
+	}
+	if err := b.chats.SetExpiry(chat, from.Add(d)); err != nil {
+		return nil, err
+	}
+	return b.chats.GetChatInfo(chat.ID)
+}
+
+func (b *Bot) handleExpire(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	now := time.Now()
+	switch payload {
+	case "":
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "get the expiry of this chat")
+			return err
+		}
+		if ci.ExpiresAt.IsZero() {
+			_, err = b.reply(message, b.responseText("expire_none", b.localResponseContext(message))+"\n"+b.responseText("expire_usage", b.localResponseContext(message)))
+			return err
+		}
+		_, err = b.reply(message, fmt.Sprintf("This chat's subscription expires at %s.\n%s", formatExpiresAt(ci, now), b.responseText("expire_usage", b.localResponseContext(message))))
+		return err
+	case "off":
+		if err := b.chats.SetExpiry(message.Chat, time.Time{}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to clear expiry", "err", err)
+			_, err = b.replyStoreError(message, err, "clear the expiry of this chat")
+			return err
+		}
+		_, err := b.respond(message, "expire_off", responseContext(message))
+		return err
+	}
+
+	d, err := parseExpiry(payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, b.responseText("expire_usage", b.localResponseContext(message))))
+		return err
+	}
+	if err := b.chats.SetExpiry(message.Chat, now.Add(d)); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set expiry", "err", err)
+		_, err = b.replyStoreError(message, err, "set the expiry of this chat")
+		return err
+	}
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the expiry of this chat")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("This chat's subscription expires at %s.", formatExpiresAt(ci, now)))
+	return err
+}
+
+// handleExpireButton extends the chat's subscription from the button of the expiry warning.
+func (b *Bot) handleExpireButton(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can extend the subscription.")
+		return
+	}
+	d, err := parseExpiry(c.Data)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to parse expiry button", "err", err)
+		respond("")
+		return
+	}
+
+	now := time.Now()
+	ci, err := b.extendExpiry(c.Message.Chat, d, now)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to extend expiry", "chat_id", c.Message.Chat.ID, "err", err)
+		respond(b.storeErrorReply(err, "extend the subscription"))
+		return
+	}
+	respond("")
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove extend button", "err", err)
+	}
+	reply := fmt.Sprintf("This chat's subscription was extended by %s, it expires at %s now.", formatExpiry(d), formatExpiresAt(ci, now))
+	if _, err := b.send(c.Message.Chat, reply, "button expiry"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send expiry confirmation", "err", err)
+	}
+}
@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestParseExpiry(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"7d":  7 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"1w":  7 * 24 * time.Hour,
+	} {
+		d, err := parseExpiry(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, d, in)
+	}
+	for _, in := range []string{"", "0d", "soon", "-1d"} {
+		_, err := parseExpiry(in)
+		require.Error(t, err, in)
+	}
+	require.Equal(t, "7d", formatExpiry(7*24*time.Hour))
+	require.Equal(t, "12h", formatExpiry(12*time.Hour))
+}
+
+func TestStartForSetsExpiry(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStart + " for 7d", Payload: "for 7d"}))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(7*24*time.Hour), ci.ExpiresAt, time.Minute)
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStart + " for ever", Payload: "for ever"}))
+	require.Contains(t, tb.lastText(), responseExpireUsage)
+}
+
+func TestExpireCommand(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleExpire(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "2d"}))
+	require.Contains(t, tb.lastText(), "This chat's subscription expires at ")
+	require.Contains(t, tb.lastText(), "(in 2 days)")
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(48*time.Hour), ci.ExpiresAt, time.Minute)
+
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Contains(t, tb.lastText(), "\nSubscription: expires at ")
+
+	require.NoError(t, b.handleExpire(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	require.Equal(t, "This chat's subscription doesn't expire anymore.", tb.lastText())
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.True(t, ci.ExpiresAt.IsZero())
+
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.NotContains(t, tb.lastText(), "Subscription:")
+
+	require.NoError(t, b.handleExpire(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "later"}))
+	require.Contains(t, tb.lastText(), responseExpireUsage)
+}
+
+func TestExpiryWarnsThenPauses(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	clock := &fakeClock{t: time.Now()}
+	require.NoError(t, chats.SetExpiry(sharedGroup, clock.now().Add(3*24*time.Hour)))
+
+	admin := strconv.Itoa(testAdmin.ID)
+	sentTo := func(to string) []sentMessage {
+		var sent []sentMessage
+		for _, m := range tb.messages() {
+			if m.to == to {
+				sent = append(sent, m)
+			}
+		}
+		return sent
+	}
+
+	b.checkExpiries(clock.now())
+	require.Empty(t, tb.messages(), "the chat isn't warned days before")
+
+	clock.advance(2*24*time.Hour + time.Hour)
+	b.checkExpiries(clock.now())
+	require.Len(t, sentTo("-100"), 1)
+	warning := sentTo("-100")[0]
+	require.Contains(t, warning.text(), "This chat's subscription expires at ")
+	require.Equal(t, []string{"7d"}, buttons(warning))
+
+	clock.advance(time.Hour)
+	b.checkExpiries(clock.now())
+	require.Len(t, tb.messages(), 1, "the chat is warned once")
+
+	d, err := b.processWebhook(context.Background(), suppressedWebhook(sharedGroup.ID, "firing", template.KV{"alertname": "DiskFull"}))
+	require.NoError(t, err)
+	require.Len(t, sentTo("-100"), 2, "alerts are sent until the subscription expires")
+
+	clock.advance(23 * time.Hour)
+	b.checkExpiries(clock.now())
+	require.Len(t, sentTo("-100"), 3)
+	require.Equal(t, "This chat's subscription expired, it gets no alerts anymore.\nUse /expire 7d or /expire off to get them again.", sentTo("-100")[2].text())
+	require.Len(t, sentTo(admin), 1)
+	require.Equal(t, "The subscription of payments-oncall (-100) expired, it's paused and gets no alerts until it's renewed with /expire.", sentTo(admin)[0].text())
+
+	ci, err := chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.True(t, ci.Paused, "the chat is paused, not deleted")
+	require.True(t, clock.now().Equal(ci.PausedSince))
+
+	d, err = b.processWebhook(context.Background(), suppressedWebhook(sharedGroup.ID, "firing", template.KV{"alertname": "DiskFull"}))
+	require.NoError(t, err)
+	require.Zero(t, d.Messages)
+	require.Len(t, sentTo("-100"), 3, "paused chats get no alerts")
+
+	b.checkExpiries(clock.now())
+	require.Len(t, sentTo(admin), 1, "the admins are told once")
+
+	require.NoError(t, b.handleExpire(&telebot.Message{Sender: testAdmin, Chat: sharedGroup, Payload: "off"}))
+	ci, err = chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.False(t, ci.Paused, "clearing the expiry renews the subscription")
+}
+
+func TestExpiryExtendButton(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	expiresAt := time.Now().Add(12 * time.Hour)
+	require.NoError(t, chats.SetExpiry(sharedGroup, expiresAt))
+	b.checkExpiries(time.Now())
+	data := buttons(tb.messages()[0])
+
+	b.handleExpireButton(&telebot.Callback{
+		Sender:  &telebot.User{ID: 999},
+		Message: &telebot.Message{ID: 1, Chat: sharedGroup},
+		Data:    data[0],
+	})
+	require.Equal(t, "Only admins can extend the subscription.", tb.responses[0].Text)
+
+	b.handleExpireButton(&telebot.Callback{
+		Sender:  testAdmin,
+		Message: &telebot.Message{ID: 1, Chat: sharedGroup},
+		Data:    data[0],
+	})
+	ci, err := chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.True(t, expiresAt.Add(7*24*time.Hour).Equal(ci.ExpiresAt), "the subscription is extended from when it expires")
+	require.False(t, ci.ExpiryWarned, "the chat is warned again before the new expiry")
+	require.Contains(t, tb.lastText(), "This chat's subscription was extended by 7d, it expires at ")
+	require.Len(t, tb.edited, 1, "the extend button is removed")
+}
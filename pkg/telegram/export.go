@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// exportModifier makes a command send its output as a Markdown file, like /alerts file.
+const exportModifier = "file"
+
+// alertsExportTemplate is the Markdown document /alerts file sends, plain Markdown without HTML.
+var alertsExportTemplate = texttemplate.Must(texttemplate.New("alerts_export").Funcs(texttemplate.FuncMap{
+	"code":   markdownCode,
+	"firing": formatFiringDuration,
+	"time":   func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05 UTC") },
+}).Parse(`# Alerts in {{ .Chat }}
+
+Exported {{ time .Exported }}, {{ len .Alerts }} alerts.
+{{ range .Alerts }}
+## {{ .Labels.alertname }}
+
+- Status: {{ .Status }}
+- Since: {{ time .StartsAt }}{{ if eq .Status "firing" }}, firing for {{ firing ($.Exported.Sub .StartsAt) }}{{ end }}
+{{- range .Labels.SortedPairs }}
+- {{ code .Name }}: {{ code .Value }}
+{{- end }}
+{{- range .Annotations.SortedPairs }}
+
+**{{ .Name }}**
+
+{{ .Value }}
+{{- end }}
+{{- if .GeneratorURL }}
+
+[Source]({{ .GeneratorURL }})
+{{- end }}
+{{ end }}`))
+
+// alertsExport is what alertsExportTemplate renders.
+type alertsExport struct {
+	Chat     string
+	Exported time.Time
+	Alerts   template.Alerts
+}
+
+// hasModifier returns whether the command's payload has the modifier as one of its words.
+func hasModifier(payload, modifier string) bool {
+	return contains(strings.Fields(payload), modifier)
+}
+
+// markdownCode formats s as inline code, with enough backticks around it for the ones inside.
+func markdownCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
+
+// renderAlertsExport renders the alerts as the Markdown document /alerts file sends, redacted like in the chat.
+func (b *Bot) renderAlertsExport(chat *telebot.Chat, chatInfo *ChatInfo, alerts []*types.Alert, now time.Time) (string, error) {
+	data := b.redactData(chatInfo, b.alertsData(alerts...))
+	sort.SliceStable(data.Alerts, func(i, j int) bool { return data.Alerts[i].StartsAt.After(data.Alerts[j].StartsAt) })
+
+	var buf bytes.Buffer
+	if err := alertsExportTemplate.Execute(&buf, alertsExport{Chat: chatName(chat), Exported: now, Alerts: data.Alerts}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// exportDocument is a Markdown document named after the command, the chat and when it was exported.
+func exportDocument(command string, chat *telebot.Chat, now time.Time, markdown string) *telebot.Document {
+	return &telebot.Document{
+		File:     telebot.FromReader(strings.NewReader(markdown)),
+		FileName: fmt.Sprintf("%s-%d-%s.md", command, chat.ID, now.UTC().Format("20060102T150405Z")),
+		MIME:     "text/markdown",
+	}
+}
@@ -0,0 +1,45 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestRenderAlertsExport(t *testing.T) {
+	b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	now := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+
+	highCPU := alertmanagertest.Alert("HighCPU").Environment("prod").Severity("critical").
+		Annotation("summary", "CPU usage is 95%").Annotation("runbook", "Check `top` first").Build()
+	highCPU.StartsAt = now.Add(-90 * time.Minute)
+	highCPU.GeneratorURL = "http://prometheus/graph?g0.expr=cpu"
+	diskFull := alertmanagertest.Alert("DiskFull").Project("billing").Build()
+	diskFull.StartsAt = now.Add(-3 * time.Hour)
+	diskFull.EndsAt = now.Add(-time.Hour)
+
+	out, err := b.renderAlertsExport(testChat, nil, []*types.Alert{diskFull, highCPU}, now)
+	require.NoError(t, err)
+	golden, err := ioutil.ReadFile("testdata/alerts_export.md")
+	require.NoError(t, err)
+	require.Equal(t, string(golden), out)
+}
+
+func TestHandleAlertsFile(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{alertmanagertest.Alert("HighCPU").Environment("prod").Build()}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/alerts file", Payload: "file"}))
+	msgs := tb.messages()
+	doc, ok := msgs[len(msgs)-1].what.(*telebot.Document)
+	require.True(t, ok, "the alerts are sent as a document")
+	require.Regexp(t, `^alerts-123-\d{8}T\d{6}Z\.md$`, doc.FileName)
+	require.Equal(t, "text/markdown", doc.MIME)
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandAlertmanagerURL = "/amurl"
+
+	responseAlertmanagerURLUsage = "Usage: " + CommandAlertmanagerURL + " https://alertmanager.example.com to link alerts and silences to another Alertmanager, " +
+		CommandAlertmanagerURL + " reset to use the default again."
+)
+
+// parseExternalURL validates a per-chat Alertmanager URL.
+func parseExternalURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("URL %q needs to start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// externalURL returns the Alertmanager URL to link to from the chat's messages.
+// A chat's own URL overrides the one the alerts came with.
+func externalURL(ci *ChatInfo, fallback string) string {
+	if ci != nil && ci.ExternalURL != "" {
+		return ci.ExternalURL
+	}
+	return fallback
+}
+
+// SetExternalURL overrides the Alertmanager URL for a chat, an empty URL resets it.
+func (s *ChatStore) SetExternalURL(c *telebot.Chat, externalURL string) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.ExternalURL = externalURL
+		return nil
+	})
+}
+
+func (b *Bot) handleAlertmanagerURL(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		_, err := b.reply(message, responseAlertmanagerURLUsage)
+		return err
+	}
+
+	var externalURL string
+	if payload != "reset" {
+		u, err := parseExternalURL(payload)
+		if err != nil {
+			_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseAlertmanagerURLUsage))
+			return err
+		}
+		externalURL = u
+	}
+
+	if err := b.chats.SetExternalURL(message.Chat, externalURL); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set Alertmanager URL", "err", err)
+		_, err = b.replyStoreError(message, err, "set Alertmanager URL")
+		return err
+	}
+
+	if externalURL == "" {
+		_, err := b.reply(message, "Alerts in this chat link to the default Alertmanager again.")
+		return err
+	}
+	_, err := b.reply(message, fmt.Sprintf("Alerts in this chat link to %s now.", externalURL))
+	return err
+}
@@ -0,0 +1,77 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const silenceLinkTemplate = `{{ define "telegram.default" }}{{ range .Alerts }}{{ $.ExternalURL }}/#/silences/new?filter=%7Balertname%3D%22{{ .Labels.alertname }}%22%7D{{ end }}{{ end }}`
+
+func TestParseExternalURL(t *testing.T) {
+	u, err := parseExternalURL("https://alertmanager-staging.example.com/")
+	require.NoError(t, err)
+	require.Equal(t, "https://alertmanager-staging.example.com", u)
+
+	for _, raw := range []string{"alertmanager.example.com", "ftp://alertmanager.example.com", "https://", "http://%zz"} {
+		_, err := parseExternalURL(raw)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestExternalURLPerChat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "silence.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(silenceLinkTemplate), 0o644))
+
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Scheme: "https", Host: "alertmanager.example.com"}, path))
+	prod := &telebot.Chat{ID: -1, Type: telebot.ChatGroup}
+	staging := &telebot.Chat{ID: -2, Type: telebot.ChatGroup}
+	require.NoError(t, chats.AddChat(prod, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(staging, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlertmanagerURL(&telebot.Message{Sender: testAdmin, Chat: staging, Payload: "https://alertmanager-staging.example.com/"}))
+
+	data := &template.Data{
+		Status:      "firing",
+		Alerts:      template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}}},
+		ExternalURL: "https://alertmanager.example.com",
+	}
+	for _, c := range []*telebot.Chat{prod, staging} {
+		_, err := b.processWebhook(context.Background(), alertmanager.TelegramWebhook{ChatID: c.ID, Message: webhook.Message{Data: data}})
+		require.NoError(t, err)
+	}
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 3)
+	require.Equal(t, "https://alertmanager.example.com/#/silences/new?filter=%7Balertname%3D%22HighCPU%22%7D", strings.TrimSpace(msgs[1].text()))
+	require.Equal(t, "https://alertmanager-staging.example.com/#/silences/new?filter=%7Balertname%3D%22HighCPU%22%7D", strings.TrimSpace(msgs[2].text()))
+	require.Equal(t, "https://alertmanager.example.com", data.ExternalURL, "shared webhook data must not change")
+
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: staging}))
+	require.Contains(t, tb.lastText(), "Alertmanager URL: https://alertmanager-staging.example.com")
+
+	require.NoError(t, b.handleAlertmanagerURL(&telebot.Message{Sender: testAdmin, Chat: staging, Payload: "reset"}))
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: staging}))
+	require.Contains(t, tb.lastText(), "Alertmanager URL: default (https://alertmanager.example.com)")
+}
+
+func TestAlertmanagerURLInvalid(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlertmanagerURL(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "alertmanager.example.com"}))
+	require.True(t, strings.HasSuffix(tb.lastText(), responseAlertmanagerURLUsage))
+
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.ExternalURL)
+}
@@ -0,0 +1,183 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandFallback = "/fallback"
+
+	defaultFailoverThreshold     = 3
+	defaultFailoverProbeInterval = time.Minute
+
+	responseFallbackUsage = "Usage: " + CommandFallback + " <chat_id> to send this chat's alerts there while it's unreachable, " +
+		CommandFallback + " off to stop."
+)
+
+// WithFailover redirects a chat's alerts to its fallback chat after threshold consecutive failed deliveries,
+// and checks every probeInterval if the chat is reachable again.
+func WithFailover(threshold int, probeInterval time.Duration) BotOption {
+	return func(b *Bot) error {
+		if threshold <= 0 {
+			return fmt.Errorf("the failover threshold must be positive, is %d", threshold)
+		}
+		if probeInterval <= 0 {
+			return fmt.Errorf("the failover probe interval must be positive, is %s", probeInterval)
+		}
+		b.failoverThreshold = threshold
+		b.failoverProbeInterval = probeInterval
+		return nil
+	}
+}
+
+// SetFallback sets the chat getting the alerts of c while c is unreachable, 0 removes it.
+func (s *ChatStore) SetFallback(c *telebot.Chat, fallbackID int64) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.FallbackChatID = fallbackID
+		return nil
+	})
+}
+
+// failingOver tells if the chat's alerts go to its fallback chat.
+func (b *Bot) failingOver(ci *ChatInfo) bool {
+	return ci.FallbackChatID != 0 && ci.FailedSends >= b.failoverThreshold
+}
+
+// fallbackHeader is put above the alerts of a chat delivered to its fallback chat.
+func fallbackHeader(chat *telebot.Chat) string {
+	return fmt.Sprintf("⚠️ delivered here because %s is unreachable\n\n", html.EscapeString(chatName(chat)))
+}
+
+// startFailover is called after a delivery to the chat failed. It tells if that made the chat fail over,
+// which the admins are told about once.
+func (b *Bot) startFailover(logger log.Logger, ci *ChatInfo) bool {
+	if ci.FallbackChatID == 0 || ci.FailedSends+1 < b.failoverThreshold {
+		return false
+	}
+	level.Warn(logger).Log("msg", "chat is unreachable, sending its alerts to the fallback chat", "fallback_chat_id", ci.FallbackChatID, "failed_sends", ci.FailedSends+1)
+	notice := fmt.Sprintf("Sending alerts to %s failed %d times in a row, they go to chat %d until it's reachable again.",
+		chatName(ci.Chat), ci.FailedSends+1, ci.FallbackChatID)
+	for _, admin := range b.admins {
+		b.SendAdminMessage(admin, notice)
+	}
+	return true
+}
+
+// probeFailedOver checks if the chats failing over are reachable again and sends them their alerts again if so.
+func (b *Bot) probeFailedOver() {
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats to probe", "err", err)
+		return
+	}
+	for _, ci := range chats {
+		if !b.failingOver(&ci) {
+			continue
+		}
+		logger := log.With(b.logger, "chat_id", ci.Chat.ID)
+		if err := b.telegram.Notify(ci.Chat, telebot.Typing); err != nil {
+			level.Debug(logger).Log("msg", "chat is still unreachable", "err", err)
+			continue
+		}
+		if err := b.chats.RecordDelivery(ci.Chat.ID, true); err != nil {
+			level.Warn(logger).Log("msg", "failed to record delivery", "err", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "chat is reachable again, sending its alerts there again")
+		notice := fmt.Sprintf("%s is reachable again, its alerts go there again.", chatName(ci.Chat))
+		for _, admin := range b.admins {
+			b.SendAdminMessage(admin, notice)
+		}
+	}
+}
+
+// runFailoverProbes probes the chats failing over every probe interval until the context is done.
+func (b *Bot) runFailoverProbes(ctx context.Context) {
+	ticker := time.NewTicker(b.failoverProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if b.leading() {
+				b.probeFailedOver()
+			}
+		}
+	}
+}
+
+func (b *Bot) handleFallback(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "get the fallback chat")
+			return err
+		}
+		if ci.FallbackChatID == 0 {
+			_, err = b.reply(message, "This chat has no fallback chat.\n"+responseFallbackUsage)
+			return err
+		}
+		_, err = b.reply(message, fmt.Sprintf("Alerts of this chat go to chat %d while it's unreachable.", ci.FallbackChatID))
+		return err
+	}
+
+	var fallbackID int64
+	if payload != "off" {
+		id, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			_, err = b.reply(message, responseFallbackUsage)
+			return err
+		}
+		if id == message.Chat.ID {
+			_, err = b.reply(message, "A chat can't be its own fallback chat.")
+			return err
+		}
+		if me := b.telegram.Me(); me != nil && id == int64(me.ID) {
+			_, err = b.reply(message, "The bot can't be a fallback chat.")
+			return err
+		}
+		if _, err := b.chats.GetChatInfo(id); err != nil {
+			if errors.Is(err, ErrChatNotFound) {
+				_, err = b.reply(message, fmt.Sprintf("Chat %d didn't subscribe, send %s there first.", id, CommandStart))
+				return err
+			}
+			_, err = b.replyStoreError(message, err, "check the fallback chat")
+			return err
+		}
+		loops, err := b.fallbackLoops(message.Chat.ID, id)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "check the fallback chat")
+			return err
+		}
+		if loops {
+			_, err = b.reply(message, fmt.Sprintf("Chat %d falls back to this chat already, alerts would go round in circles.", id))
+			return err
+		}
+		fallbackID = id
+	}
+
+	if err := b.chats.SetFallback(message.Chat, fallbackID); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set fallback chat", "err", err)
+		_, err = b.replyStoreError(message, err, "set the fallback chat")
+		return err
+	}
+
+	if fallbackID == 0 {
+		_, err := b.reply(message, "This chat has no fallback chat anymore.")
+		return err
+	}
+	_, err := b.reply(message, fmt.Sprintf("Alerts of this chat go to chat %d after %d failed deliveries in a row.", fallbackID, b.failoverThreshold))
+	return err
+}
@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var oncall = &telebot.Chat{ID: -200, Title: "oncall-fallback", Type: telebot.ChatGroup}
+
+func TestHandleFallback(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+
+	fallback := func(payload string) string {
+		require.NoError(t, b.handleFallback(&telebot.Message{Chat: payments, Sender: testAdmin, Text: CommandFallback + " " + payload, Payload: payload}))
+		msgs := tb.messages()
+		return msgs[len(msgs)-1].text()
+	}
+
+	require.Equal(t, "Chat -200 didn't subscribe, send /start there first.", fallback("-200"))
+	require.Equal(t, "A chat can't be its own fallback chat.", fallback("-100"))
+	require.Equal(t, responseFallbackUsage, fallback("oncall"))
+
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.Equal(t, "Alerts of this chat go to chat -200 after 3 failed deliveries in a row.", fallback("-200"))
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Equal(t, oncall.ID, ci.FallbackChatID)
+	require.Equal(t, "Alerts of this chat go to chat -200 while it's unreachable.", fallback(""))
+
+	require.Equal(t, "This chat has no fallback chat anymore.", fallback("off"))
+	ci, err = chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Zero(t, ci.FallbackChatID)
+}
+
+func TestFailoverAndRecovery(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithFailover(2, time.Minute), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetFallback(payments, oncall.ID))
+
+	admin := strconv.Itoa(testAdmin.ID)
+	sentTo := func(to string) []string {
+		var texts []string
+		for _, m := range tb.messages() {
+			if m.to == to {
+				texts = append(texts, m.text())
+			}
+		}
+		return texts
+	}
+	deliver := func() delivery {
+		d, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"}))
+		require.NoError(t, err)
+		return d
+	}
+
+	tb.setUnreachable("-100", true)
+	require.Error(t, deliver().Failed, "the first failure stays below the threshold")
+	require.Empty(t, sentTo("-200"))
+	require.Empty(t, sentTo(admin))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, deliver().Failed)
+	}
+	redirected := sentTo("-200")
+	require.Len(t, redirected, 2)
+	for _, text := range redirected {
+		require.True(t, strings.HasPrefix(text, "⚠️ delivered here because payments-oncall (-100) is unreachable\n\n"), text)
+	}
+	require.Equal(t, []string{"Sending alerts to payments-oncall (-100) failed 2 times in a row, they go to chat -200 until it's reachable again."}, sentTo(admin))
+
+	b.probeFailedOver()
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.True(t, b.failingOver(ci), "the chat is still unreachable")
+
+	tb.setUnreachable("-100", false)
+	b.probeFailedOver()
+	ci, err = chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.False(t, b.failingOver(ci))
+	require.Len(t, sentTo(admin), 2)
+	require.Equal(t, "payments-oncall (-100) is reachable again, its alerts go there again.", sentTo(admin)[1])
+
+	require.NoError(t, deliver().Failed)
+	require.Len(t, sentTo("-100"), 1)
+	require.Len(t, sentTo("-200"), 2)
+}
+
+func TestFailoverWithoutFallback(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithFailover(1, time.Minute), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+
+	tb.setUnreachable("-100", true)
+	for i := 0; i < 3; i++ {
+		d, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"}))
+		require.NoError(t, err)
+		require.Error(t, d.Failed)
+	}
+	require.Empty(t, tb.messages())
+
+	_, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithFailover(0, time.Minute))
+	require.Error(t, err)
+}
+
+func TestFailoverToUnsubscribedChat(t *testing.T) {
+	var logs bytes.Buffer
+	b, tb, chats := newTestBot(t,
+		WithLogger(log.NewLogfmtLogger(&logs)),
+		WithFailover(1, time.Minute),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+	)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetFallback(payments, oncall.ID))
+
+	tb.setUnreachable("-100", true)
+	for i := 0; i < 2; i++ {
+		d, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"}))
+		require.NoError(t, err)
+		require.NoError(t, d.Failed)
+	}
+	var redirected int
+	for _, m := range tb.messages() {
+		if m.to == "-200" {
+			redirected++
+		}
+	}
+	require.Equal(t, 2, redirected)
+	require.NotContains(t, logs.String(), "failed to record delivery", "deliveries to the fallback chat aren't recorded for it")
+	_, err := chats.GetChatInfo(oncall.ID)
+	require.ErrorIs(t, err, ErrChatNotFound)
+}
@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandFilters = "/filters"
+
+	responseReceivesNothing = "⚠️ With these settings this chat will receive no alerts at all."
+)
+
+// receivesNothing returns whether the chat's filters suppress every alert. It runs the filter deliveries use
+// over every combination of the configured environments, projects and severities, each with an
+// unconfigured or missing value, too.
+func (b *Bot) receivesNothing(ci *ChatInfo) bool {
+	envs := append(append([]string{}, b.environments...), "")
+	prs := append(append([]string{}, b.projects...), "")
+	severities := append(append([]string{}, b.severityOrdering.levels...), "")
+	for _, env := range envs {
+		for _, pr := range prs {
+			for _, severity := range severities {
+				a := template.Alert{Labels: template.KV{}}
+				if env != "" {
+					a.Labels[labelEnvironment] = env
+				}
+				if pr != "" {
+					a.Labels[labelProject] = pr
+				}
+				if severity != "" {
+					a.Labels[labelSeverity] = severity
+				}
+				if b.suppressedBy(ci, a) == nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// receivesNothingWarning returns the warning to add to a reply about changed filters
+// if the chat doesn't get any alerts anymore, "" if it does or its filters can't be read.
+func (b *Bot) receivesNothingWarning(chatID int64) string {
+	ci, err := b.chats.GetChatInfo(chatID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat", "chat_id", chatID, "err", err)
+		return ""
+	}
+	if !b.receivesNothing(ci) {
+		return ""
+	}
+	return "\n\n" + responseReceivesNothing
+}
+
+// formatFilters lists the settings deciding what a chat gets and how.
+func (b *Bot) formatFilters(ci *ChatInfo) string {
+	amURL := "default"
+	if b.templates != nil && b.templates.ExternalURL != nil {
+		amURL = fmt.Sprintf("default (%s)", b.templates.ExternalURL)
+	}
+	if ci.ExternalURL != "" {
+		amURL = ci.ExternalURL
+	}
+
+	issueButtons := "on"
+	if b.issueTracker == nil {
+		issueButtons = "not configured"
+	} else if !b.chatFormat(ci).IssueButtons {
+		issueButtons = "off"
+	}
+
+	redaction := "normal"
+	if ci.RedactStrict {
+		redaction = "strict"
+	}
+
+	maxAlerts := "all"
+	if max := b.maxAlertsFor(ci); max > 0 {
+		maxAlerts = fmt.Sprintf("%d", max)
+	}
+
+	minSeverity := "all"
+	if ci.MinSeverity != "" {
+		minSeverity = ci.MinSeverity
+	}
+
+	resolved := "on"
+	if !b.sendsResolved(ci) {
+		resolved = "off"
+	}
+
+	timezone := defaultTimezone
+	if ci.Timezone != "" {
+		timezone = ci.Timezone
+	}
+
+	out := fmt.Sprintf(
+		"Environments: %s\nProjects: %s\nMuted environments: %s\nMuted projects: %s\nMinimum severity: %s\nResolved alerts: %s\nTimezone: %s\nAlertmanager URL: %s\nIssue buttons: %s\nRedaction: %s\nAlerts per message: %s",
+		formatList(ci.AlertEnvironments),
+		formatList(ci.AlertProjects),
+		formatList(ci.MutedEnvironments),
+		formatList(ci.MutedProjects),
+		minSeverity,
+		resolved,
+		timezone,
+		amURL,
+		issueButtons,
+		redaction,
+		maxAlerts,
+	)
+	if ci.Receiver != "" {
+		out += "\nReceiver: " + ci.Receiver
+		switch n := b.receiverSharers(ci); n {
+		case 0:
+		case 1:
+			out += " (shared with 1 other chat)"
+		default:
+			out += fmt.Sprintf(" (shared with %d other chats)", n)
+		}
+	}
+	switch {
+	case ci.Paused:
+		out += fmt.Sprintf("\nSubscription: paused, expired at %s", formatExpiresAt(ci, time.Now()))
+	case !ci.ExpiresAt.IsZero():
+		out += fmt.Sprintf("\nSubscription: expires at %s", formatExpiresAt(ci, time.Now()))
+	}
+	if ci.Debounce > 0 {
+		out += "\nDebounce: " + formatExtension(ci.Debounce)
+	}
+	if n := len(ci.RepeatSuppressions); n > 0 {
+		out += fmt.Sprintf("\nRepeat suppressions: %d, see %s", n, CommandSuppressions)
+	}
+	return out
+}
+
+func (b *Bot) handleFilters(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		if !errors.Is(err, ErrChatNotFound) {
+			level.Warn(b.logger).Log("msg", "failed to get chat", "err", err)
+		}
+		_, err = b.replyStoreError(message, err, "get the filters of this chat")
+		return err
+	}
+
+	out := b.formatFilters(ci)
+	if b.receivesNothing(ci) {
+		out += "\n\n" + responseReceivesNothing
+	}
+	_, err = b.reply(message, out)
+	return err
+}
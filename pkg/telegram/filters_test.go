@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestReceivesNothing(t *testing.T) {
+	b, _, _ := newTestBot(t)
+
+	for _, tc := range []struct {
+		name    string
+		ci      ChatInfo
+		nothing bool
+	}{
+		{name: "nothing muted"},
+		{name: "all environments muted", ci: ChatInfo{MutedEnvironments: []string{"prod", "staging", "other"}}, nothing: true},
+		{name: "all projects muted", ci: ChatInfo{MutedProjects: []string{"billing", "frontend", "other"}}, nothing: true},
+		{name: "configured environments muted", ci: ChatInfo{MutedEnvironments: []string{"prod", "staging"}}},
+		{name: "partially muted", ci: ChatInfo{MutedEnvironments: []string{"prod"}, MutedProjects: []string{"billing", "other"}}},
+		{name: "critical only", ci: ChatInfo{MinSeverity: "critical", MutedEnvironments: []string{"staging", "other"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.nothing, b.receivesNothing(&tc.ci))
+		})
+	}
+}
+
+func TestMuteWarnsWhenNothingIsLeft(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute environment[prod, staging]"}))
+	require.NotContains(t, tb.lastText(), responseReceivesNothing, "alerts without a configured environment still come in")
+
+	require.NoError(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/mute environment[other]"}))
+	require.True(t, strings.HasSuffix(tb.lastText(), "\n\n"+responseReceivesNothing), tb.lastText())
+
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.True(t, strings.HasSuffix(tb.lastText(), "\n\n"+responseReceivesNothing))
+}
+
+func TestListsGolden(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(testChat, []string{"staging", "other", "staging"}, b.environmentsAndOther))
+	require.NoError(t, chats.MuteProjects(testChat, []string{"frontend"}, b.projectsAndOther))
+
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"other", "staging"}, ci.MutedEnvironments, "mutes are stored sorted")
+
+	var out []string
+	for _, handle := range []func(*telebot.Message) error{b.handleEnvironments, b.handleProjects, b.handleMutedEnvs, b.handleMutedPrs, b.handleFilters} {
+		require.NoError(t, handle(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+		out = append(out, tb.lastText())
+	}
+	golden, err := ioutil.ReadFile("testdata/lists.txt")
+	require.NoError(t, err)
+	require.Equal(t, string(golden), strings.Join(out, "\n---\n")+"\n")
+}
+
+func TestGetUniqueStrings(t *testing.T) {
+	require.Equal(t, []string{"b", "a", "c"}, getUniqueStrings([]string{"b", "a", "b", "c", "a"}))
+	require.Empty(t, getUniqueStrings(nil))
+	require.Equal(t, "none", formatList(nil))
+	require.Equal(t, "a, b, c", formatList([]string{"c", "a", "b"}))
+}
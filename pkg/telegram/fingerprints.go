@@ -0,0 +1,247 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	telegramFingerprintsDirectory = "telegram/fingerprints"
+
+	// defaultFingerprintTTL is how long fingerprints that weren't notified about again are kept.
+	defaultFingerprintTTL = 30 * 24 * time.Hour
+)
+
+// errFingerprintNotFound is returned for fingerprints the bot never notified about.
+var errFingerprintNotFound = errors.New("fingerprint not found")
+
+// FingerprintRecord is when the bot first and last notified about an alert, by its fingerprint, and how often.
+type FingerprintRecord struct {
+	Fingerprint   string
+	FirstNotified time.Time
+	LastNotified  time.Time
+	Notifications int
+}
+
+// WithNewAlertWindow marks the alerts of notifications as new if the bot first notified about them
+// at most this long ago, and the others with when that was and how often it did since.
+// It writes every notified fingerprint to the store, so 0 turns it off, which is the default.
+func WithNewAlertWindow(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d < 0 {
+			return fmt.Errorf("the new alert window must not be negative, is %s", d)
+		}
+		b.newAlertWindow = d
+		return nil
+	}
+}
+
+// WithFingerprintTTL sets how long the bot remembers the first notification about an alert
+// after it last notified about it. Alerts firing again after that are new again.
+func WithFingerprintTTL(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d <= 0 {
+			return fmt.Errorf("the fingerprint TTL must be positive, is %s", d)
+		}
+		b.fingerprintTTL = d
+		return nil
+	}
+}
+
+func fingerprintKey(fingerprint string) string {
+	return fmt.Sprintf("%s/%s", telegramFingerprintsDirectory, fingerprint)
+}
+
+// GetFingerprints returns the records of the fingerprints the bot notified about before, the others are left out.
+// Records written since the store was compacted are at a key each, they're newer than those in the segments.
+func (s *ChatStore) GetFingerprints(fingerprints []string) (map[string]FingerprintRecord, error) {
+	records := make(map[string]FingerprintRecord, len(fingerprints))
+	segments := map[string]map[string]FingerprintRecord{}
+	for _, fp := range fingerprints {
+		kv, err := s.get(fingerprintKey(fp), errFingerprintNotFound)
+		if errors.Is(err, errFingerprintNotFound) {
+			key := fingerprintSegmentKey(fp)
+			segment, ok := segments[key]
+			if !ok {
+				if segment, err = s.fingerprintSegment(key); err != nil {
+					return nil, err
+				}
+				segments[key] = segment
+			}
+			if r, ok := segment[fp]; ok {
+				records[fp] = r
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r FingerprintRecord
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return nil, err
+		}
+		records[fp] = r
+	}
+	return records, nil
+}
+
+// RecordNotified counts a notification about each of the fingerprints at the given time,
+// the first one of a fingerprint also sets when it was first notified about.
+func (s *ChatStore) RecordNotified(fingerprints []string, at time.Time) error {
+	s.fingerprintsMu.Lock()
+	defer s.fingerprintsMu.Unlock()
+	known, err := s.GetFingerprints(fingerprints)
+	if err != nil {
+		return err
+	}
+	for _, fp := range fingerprints {
+		r, ok := known[fp]
+		if !ok {
+			r = FingerprintRecord{Fingerprint: fp, FirstNotified: at}
+		}
+		r.LastNotified = at
+		r.Notifications++
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := s.put(fingerprintKey(fp), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneFingerprints removes the fingerprints last notified about before the given time and returns how many there were.
+// Records that can't be read are skipped.
+func (s *ChatStore) PruneFingerprints(before time.Time) (int, error) {
+	s.fingerprintsMu.Lock()
+	defer s.fingerprintsMu.Unlock()
+	a, err := s.scanFingerprints()
+	if err != nil {
+		return 0, err
+	}
+	var old []areaEntry
+	for _, e := range a.entries() {
+		if e.at.Before(before) {
+			old = append(old, e)
+		}
+	}
+	if _, err := s.removeEntries(AreaFingerprints, old, nil); err != nil {
+		return 0, err
+	}
+	return len(old), nil
+}
+
+// alertSeen is what templates get to know about when the bot first notified about a firing alert,
+// as {{ with index $.Seen .Fingerprint }}.
+type alertSeen struct {
+	// New is true for alerts first notified about within the new alert window, including the ones never notified about.
+	New bool
+	// FirstNotified is when the bot first notified about the alert, zero for alerts never notified about.
+	FirstNotified time.Time
+	// Notifications is how often the bot notified about the alert before.
+	Notifications int
+}
+
+// alertsSeen tells for each firing alert whether it's new at now or when it was first notified about.
+func alertsSeen(alerts template.Alerts, records map[string]FingerprintRecord, window time.Duration, now time.Time) map[string]*alertSeen {
+	seen := map[string]*alertSeen{}
+	for _, a := range alerts {
+		if a.Status != "firing" || a.Fingerprint == "" {
+			continue
+		}
+		r, ok := records[a.Fingerprint]
+		if !ok {
+			seen[a.Fingerprint] = &alertSeen{New: true}
+			continue
+		}
+		seen[a.Fingerprint] = &alertSeen{
+			New:           now.Sub(r.FirstNotified) <= window,
+			FirstNotified: r.FirstNotified,
+			Notifications: r.Notifications,
+		}
+	}
+	return seen
+}
+
+// fingerprints returns the fingerprints of the alerts that have one.
+func fingerprints(alerts template.Alerts) []string {
+	var fps []string
+	for _, a := range alerts {
+		if a.Fingerprint != "" {
+			fps = append(fps, a.Fingerprint)
+		}
+	}
+	return getUniqueStrings(fps)
+}
+
+// seenAlerts looks up which of the firing alerts are new, it's nil if new alerts aren't marked.
+func (b *Bot) seenAlerts(logger log.Logger, alerts template.Alerts, now time.Time) map[string]*alertSeen {
+	if b.newAlertWindow <= 0 {
+		return nil
+	}
+	records, err := b.chats.GetFingerprints(fingerprints(firingAlerts(alerts)))
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to get first notifications of alerts", "err", err)
+		return nil
+	}
+	return alertsSeen(alerts, records, b.newAlertWindow, now)
+}
+
+// recordNotified remembers that the alerts were notified about, if new alerts are marked.
+func (b *Bot) recordNotified(logger log.Logger, alerts template.Alerts, now time.Time) {
+	if b.newAlertWindow <= 0 {
+		return
+	}
+	if err := b.chats.RecordNotified(fingerprints(alerts), now); err != nil {
+		level.Warn(logger).Log("msg", "failed to record notified alerts", "err", err)
+	}
+}
+
+// pruneFingerprints forgets the alerts not notified about for the fingerprint TTL, with the other cleanups.
+func (b *Bot) pruneFingerprints(now time.Time) {
+	if b.newAlertWindow <= 0 {
+		return
+	}
+	pruned, err := b.chats.PruneFingerprints(now.Add(-b.fingerprintTTL))
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to prune notified fingerprints", "err", err)
+		return
+	}
+	if pruned > 0 {
+		level.Debug(b.logger).Log("msg", "pruned notified fingerprints", "count", pruned)
+	}
+}
+
+// seenSummary is the line under a message telling how many of its firing alerts are new and how many were
+// notified about before, like "🆕 2 new, 🔁 1 seen before". It's "" if that isn't known for any of them.
+func seenSummary(alerts template.Alerts, seen map[string]*alertSeen) string {
+	var fresh, before int
+	for _, a := range alerts {
+		s, ok := seen[a.Fingerprint]
+		if a.Status != "firing" || !ok {
+			continue
+		}
+		if s.New {
+			fresh++
+		} else {
+			before++
+		}
+	}
+	var parts []string
+	if fresh > 0 {
+		parts = append(parts, fmt.Sprintf("🆕 %d new", fresh))
+	}
+	if before > 0 {
+		parts = append(parts, fmt.Sprintf("🔁 %d seen before", before))
+	}
+	return strings.Join(parts, ", ")
+}
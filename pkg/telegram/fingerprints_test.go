@@ -0,0 +1,114 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintRecords(t *testing.T) {
+	_, _, chats := newTestBot(t)
+	first := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	records, err := chats.GetFingerprints([]string{"a"})
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	require.NoError(t, chats.RecordNotified([]string{"a"}, first))
+	require.NoError(t, chats.RecordNotified([]string{"a", "b"}, first.Add(time.Hour)))
+
+	records, err = chats.GetFingerprints([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]FingerprintRecord{
+		"a": {Fingerprint: "a", FirstNotified: first, LastNotified: first.Add(time.Hour), Notifications: 2},
+		"b": {Fingerprint: "b", FirstNotified: first.Add(time.Hour), LastNotified: first.Add(time.Hour), Notifications: 1},
+	}, records)
+
+	require.NoError(t, chats.RecordNotified([]string{"b"}, first.Add(3*time.Hour)))
+	pruned, err := chats.PruneFingerprints(first.Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	records, err = chats.GetFingerprints([]string{"a", "b"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Contains(t, records, "b")
+}
+
+func TestAlertsSeen(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	alerts := template.Alerts{
+		{Status: "firing", Fingerprint: "never"},
+		{Status: "firing", Fingerprint: "recent"},
+		{Status: "firing", Fingerprint: "old"},
+		{Status: "resolved", Fingerprint: "resolved"},
+		{Status: "firing"},
+	}
+	records := map[string]FingerprintRecord{
+		"recent":   {FirstNotified: now.Add(-30 * time.Minute), Notifications: 2},
+		"old":      {FirstNotified: now.Add(-48 * time.Hour), Notifications: 7},
+		"resolved": {FirstNotified: now.Add(-48 * time.Hour), Notifications: 3},
+	}
+
+	seen := alertsSeen(alerts, records, time.Hour, now)
+	require.Equal(t, map[string]*alertSeen{
+		"never":  {New: true},
+		"recent": {New: true, FirstNotified: now.Add(-30 * time.Minute), Notifications: 2},
+		"old":    {FirstNotified: now.Add(-48 * time.Hour), Notifications: 7},
+	}, seen)
+	require.Equal(t, "🆕 2 new, 🔁 1 seen before", seenSummary(alerts, seen))
+	require.Equal(t, "", seenSummary(alerts, nil))
+}
+
+func TestWebhookNewAndSeenBefore(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithNewAlertWindow(time.Hour),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}, Fingerprint: "cpu", StartsAt: time.Now()})
+	for i := 0; i < 2; i++ {
+		_, err := b.processWebhook(context.Background(), w)
+		require.NoError(t, err)
+		text := tb.messages()[i].text()
+		require.Contains(t, text, "\n🆕 new\n", "repeats within the window are still new")
+		require.True(t, strings.HasSuffix(text, "\n\n🆕 1 new"), text)
+	}
+
+	// The alert was first notified about before the window.
+	first := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	pruned, err := chats.PruneFingerprints(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	require.NoError(t, chats.RecordNotified([]string{"cpu"}, first))
+	require.NoError(t, chats.RecordNotified([]string{"cpu"}, first.Add(time.Hour)))
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	text := tb.lastText()
+	require.Contains(t, text, "\nseen before: first at 2021-03-01 10:00 UTC, 2 notifications\n")
+	require.True(t, strings.HasSuffix(text, "\n\n🔁 1 seen before"), text)
+
+	records, err := chats.GetFingerprints([]string{"cpu"})
+	require.NoError(t, err)
+	require.Equal(t, 3, records["cpu"].Notifications)
+	require.Equal(t, first, records["cpu"].FirstNotified)
+}
+
+func TestWebhookWithoutNewAlertWindow(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}, Fingerprint: "cpu", StartsAt: time.Now()})
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.NotContains(t, tb.lastText(), "new")
+
+	records, err := chats.GetFingerprints([]string{"cpu"})
+	require.NoError(t, err)
+	require.Empty(t, records, "fingerprints aren't written without the window")
+}
@@ -0,0 +1,236 @@
+package telegram
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// floodRampStart is the gap between the first sends after a pause of all chats, halved with every send
+	// until it's below floodRampMin, so that the queued messages don't go out in one burst.
+	floodRampStart = 2 * time.Second
+	floodRampMin   = 50 * time.Millisecond
+)
+
+// errFloodWaitAborted is returned by sends that were waiting for flood control when Run was aborted.
+var errFloodWaitAborted = errors.New("aborted while waiting for Telegram's flood control")
+
+// WithFloodWait makes a flood error of Telegram pause sending until its retry-after elapsed, instead of sending on
+// and extending the penalty. Sends, edits and deletes wait during the pause, so webhooks queue up and commands are
+// answered late. Shutdown giving up on the queued webhooks ends the waits.
+func WithFloodWait(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.floodWaitEnabled = enabled
+		return nil
+	}
+}
+
+// floodWait holds back sends while Telegram's flood control is on.
+// A flood error pauses all chats, Telegram limits how many messages the bot sends in total.
+// If the same chat gets another one after other chats got messages, it's that chat that is limited,
+// like a group with slow mode, and only the chat is paused.
+type floodWait struct {
+	logger log.Logger
+	gauge  prometheus.Gauge
+	now    func() time.Time
+	// sleep waits for the duration, returning false if abort was closed first.
+	sleep func(d time.Duration, abort <-chan struct{}) bool
+
+	mu sync.Mutex
+	// abort ends the waits when it's closed, nil waits can't be aborted.
+	abort <-chan struct{}
+	// until is when the pause of all chats ends, zero if there's none.
+	until time.Time
+	// chats are when the pauses of single chats end.
+	chats map[string]time.Time
+	// lastFlood is the chat of the last flood error, othersSent tells if another chat got a message since.
+	lastFlood  string
+	othersSent bool
+	// ramp is the gap after the next send, zero once the ramp-up after a pause is over.
+	// nextSend is when the ramp-up lets the next send go.
+	ramp     time.Duration
+	nextSend time.Time
+}
+
+func newFloodWait(logger log.Logger, gauge prometheus.Gauge) *floodWait {
+	return &floodWait{logger: logger, gauge: gauge, now: time.Now, sleep: sleepUnless, chats: map[string]time.Time{}}
+}
+
+// sleepUnless sleeps for d unless abort is closed first, which it returns false for.
+func sleepUnless(d time.Duration, abort <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-abort:
+		return false
+	}
+}
+
+// abortOn makes closing abort end the current and later waits with errFloodWaitAborted, nil stops that.
+// Run sets it to its delivery context, so that aborting it isn't held up by sends waiting for the pause to end.
+func (f *floodWait) abortOn(abort <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.abort = abort
+}
+
+// wait blocks until the chat may be sent to, or returns errFloodWaitAborted if the wait was aborted.
+func (f *floodWait) wait(chat string) error {
+	for {
+		d, abort := f.delay(chat)
+		if d <= 0 {
+			return nil
+		}
+		if !f.sleep(d, abort) {
+			return errFloodWaitAborted
+		}
+	}
+}
+
+// delay returns how long the chat has to wait, reserving the send if it doesn't, and what aborts the wait.
+func (f *floodWait) delay(chat string) (time.Duration, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := f.delayLocked(chat)
+	return d, f.abort
+}
+
+func (f *floodWait) delayLocked(chat string) time.Duration {
+	now := f.now()
+
+	if !f.until.IsZero() {
+		if now.Before(f.until) {
+			return f.until.Sub(now)
+		}
+		level.Info(f.logger).Log("msg", "resuming sends after Telegram's flood control")
+		f.until = time.Time{}
+		f.gauge.Set(0)
+		f.ramp = floodRampStart
+		f.nextSend = now
+	}
+	if until, ok := f.chats[chat]; ok {
+		if now.Before(until) {
+			return until.Sub(now)
+		}
+		delete(f.chats, chat)
+	}
+	if now.Before(f.nextSend) {
+		return f.nextSend.Sub(now)
+	}
+	if f.ramp > 0 {
+		f.nextSend = now.Add(f.ramp)
+		f.ramp = f.ramp / 2
+		if f.ramp < floodRampMin {
+			f.ramp = 0
+		}
+	}
+	return 0
+}
+
+// observe pauses sending if the send to the chat failed because of flood control.
+func (f *floodWait) observe(chat string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		if chat != f.lastFlood {
+			f.othersSent = true
+		}
+		return
+	}
+	var flood telebot.FloodError
+	if !errors.As(err, &flood) {
+		return
+	}
+
+	retryAfter := time.Duration(flood.RetryAfter) * time.Second
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	until := f.now().Add(retryAfter)
+	chatLimited := chat == f.lastFlood && f.othersSent
+	f.lastFlood, f.othersSent = chat, false
+
+	if chatLimited {
+		if until.After(f.chats[chat]) {
+			level.Warn(f.logger).Log("msg", "pausing sends to chat because of Telegram's flood control", "chat_id", chat, "retry_after", retryAfter)
+			f.chats[chat] = until
+		}
+		return
+	}
+	if until.After(f.until) {
+		level.Warn(f.logger).Log("msg", "pausing sends to all chats because of Telegram's flood control", "chat_id", chat, "retry_after", retryAfter)
+		f.until = until
+		f.gauge.Set(retryAfter.Seconds())
+	}
+}
+
+// floodTelebot waits for flood control before every call that sends or changes a message.
+type floodTelebot struct {
+	Telebot
+	flood *floodWait
+}
+
+// editableChat returns the chat of the message as a recipient, for flood control.
+func editableChat(msg telebot.Editable) string {
+	_, chatID := msg.MessageSig()
+	return strconv.FormatInt(chatID, 10)
+}
+
+func (t floodTelebot) Send(to telebot.Recipient, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	chat := to.Recipient()
+	if err := t.flood.wait(chat); err != nil {
+		return nil, err
+	}
+	sent, err := t.Telebot.Send(to, what, options...)
+	t.flood.observe(chat, err)
+	return sent, err
+}
+
+func (t floodTelebot) Forward(to telebot.Recipient, msg telebot.Editable, options ...interface{}) (*telebot.Message, error) {
+	chat := to.Recipient()
+	if err := t.flood.wait(chat); err != nil {
+		return nil, err
+	}
+	sent, err := t.Telebot.Forward(to, msg, options...)
+	t.flood.observe(chat, err)
+	return sent, err
+}
+
+func (t floodTelebot) Edit(msg telebot.Editable, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	chat := editableChat(msg)
+	if err := t.flood.wait(chat); err != nil {
+		return nil, err
+	}
+	edited, err := t.Telebot.Edit(msg, what, options...)
+	t.flood.observe(chat, err)
+	return edited, err
+}
+
+func (t floodTelebot) EditReplyMarkup(msg telebot.Editable, markup *telebot.ReplyMarkup) (*telebot.Message, error) {
+	chat := editableChat(msg)
+	if err := t.flood.wait(chat); err != nil {
+		return nil, err
+	}
+	edited, err := t.Telebot.EditReplyMarkup(msg, markup)
+	t.flood.observe(chat, err)
+	return edited, err
+}
+
+func (t floodTelebot) Delete(msg telebot.Editable) error {
+	chat := editableChat(msg)
+	if err := t.flood.wait(chat); err != nil {
+		return err
+	}
+	err := t.Telebot.Delete(msg)
+	t.flood.observe(chat, err)
+	return err
+}
@@ -0,0 +1,176 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"go.uber.org/goleak"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func floodError(retryAfter int) error {
+	return telebot.FloodError{APIError: telebot.NewAPIError(429, "Too Many Requests: retry after"), RetryAfter: retryAfter}
+}
+
+// newFloodWaitBot returns a bot waiting for flood control on a fake clock, and what it slept.
+func newFloodWaitBot(t *testing.T) (*Bot, *fakeTelebot, *[]time.Duration) {
+	b, tb, _ := newTestBot(t, WithFloodWait(true))
+	clock := &fakeClock{t: time.Now()}
+	var slept []time.Duration
+	b.flood.now = clock.now
+	b.flood.sleep = func(d time.Duration, _ <-chan struct{}) bool {
+		slept = append(slept, d)
+		clock.advance(d)
+		return true
+	}
+	return b, tb, &slept
+}
+
+func TestFloodWaitPausesAllChats(t *testing.T) {
+	b, tb, slept := newFloodWaitBot(t)
+	other := &telebot.Chat{ID: 456}
+
+	tb.sendErr = func() error { return floodError(30) }
+	_, err := b.telegram.Send(payments, "first")
+	var flood telebot.FloodError
+	require.True(t, errors.As(err, &flood), "the flood error is returned")
+	require.Equal(t, float64(30), testutil.ToFloat64(b.floodWaitSeconds))
+
+	tb.sendErr = nil
+	for i := 0; i < 3; i++ {
+		_, err = b.telegram.Send(other, "queued")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []time.Duration{30 * time.Second, 2 * time.Second, time.Second}, *slept,
+		"other chats wait for the pause to end, then sends ramp up")
+	require.Equal(t, float64(0), testutil.ToFloat64(b.floodWaitSeconds))
+	require.Len(t, tb.messages(), 3)
+
+	// The ramp-up halves the gap until it's gone.
+	for i := 0; i < 10; i++ {
+		_, err = b.telegram.Send(other, "more")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []time.Duration{30 * time.Second, 2 * time.Second, time.Second,
+		500 * time.Millisecond, 250 * time.Millisecond, 125 * time.Millisecond, 62500 * time.Microsecond}, *slept)
+}
+
+func TestFloodWaitPausesLimitedChat(t *testing.T) {
+	b, tb, slept := newFloodWaitBot(t)
+	other := &telebot.Chat{ID: 456}
+
+	tb.sendErr = func() error { return floodError(1) }
+	_, err := b.telegram.Send(payments, "first")
+	require.Error(t, err)
+	tb.sendErr = nil
+	_, err = b.telegram.Send(other, "delivered")
+	require.NoError(t, err)
+	b.flood.ramp, b.flood.nextSend = 0, time.Time{} // Skip the ramp-up.
+	*slept = nil
+
+	// The same chat is flooded again while others got messages: only it is limited.
+	tb.sendErr = func() error { return floodError(60) }
+	_, err = b.telegram.Send(payments, "second")
+	require.Error(t, err)
+	require.Equal(t, float64(0), testutil.ToFloat64(b.floodWaitSeconds), "the chat's pause isn't global")
+	tb.sendErr = nil
+
+	_, err = b.telegram.Send(other, "not held back")
+	require.NoError(t, err)
+	require.Empty(t, *slept)
+
+	_, err = b.telegram.Send(payments, "held back")
+	require.NoError(t, err)
+	require.Equal(t, []time.Duration{60 * time.Second}, *slept)
+}
+
+func TestFloodWaitCoversEdits(t *testing.T) {
+	b, tb, slept := newFloodWaitBot(t)
+	sent, err := b.telegram.Send(payments, "alerts")
+	require.NoError(t, err)
+
+	tb.sendErr = func() error { return floodError(30) }
+	_, err = b.telegram.Send(payments, "first")
+	require.Error(t, err)
+	tb.sendErr = nil
+
+	_, err = b.telegram.Edit(sent, "resolved")
+	require.NoError(t, err)
+	require.Equal(t, []time.Duration{30 * time.Second}, *slept, "edits wait for the pause, too")
+	_, err = b.telegram.EditReplyMarkup(sent, nil)
+	require.NoError(t, err)
+	_, err = b.telegram.Forward(&telebot.Chat{ID: 456}, sent)
+	require.NoError(t, err)
+	require.NoError(t, b.telegram.Delete(sent))
+	require.Len(t, *slept, 4, "and so do the sends during the ramp-up after it")
+	require.Len(t, tb.editedTexts, 1)
+	require.Len(t, tb.edited, 1)
+	require.Len(t, tb.forwarded, 1)
+	require.Len(t, tb.deleted, 1)
+}
+
+func TestFloodWaitAborts(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithFloodWait(true))
+	tb.sendErr = func() error { return floodError(600) }
+	_, err := b.telegram.Send(payments, "first")
+	require.Error(t, err)
+	tb.sendErr = nil
+
+	abort := make(chan struct{})
+	b.flood.abortOn(abort)
+	done := make(chan error)
+	go func() {
+		_, err := b.telegram.Send(payments, "waiting")
+		done <- err
+	}()
+	close(abort)
+	select {
+	case err := <-done:
+		require.Equal(t, errFloodWaitAborted, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the send kept waiting for flood control after it was aborted")
+	}
+	require.Empty(t, tb.messages())
+}
+
+func TestFloodWaitDoesntBlockShutdown(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	b, tb, chats := newTestBot(t, WithFloodWait(true), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	tb.sendErr = func() error { return floodError(600) }
+	_, err := b.telegram.Send(payments, "first")
+	require.Error(t, err)
+	tb.sendErr = nil
+
+	webhooks := make(chan alertmanager.TelegramWebhook, 1)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background(), ChannelSource(webhooks)) }()
+	webhooks <- suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"})
+	require.Eventually(t, func() bool { return len(webhooks) == 0 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, b.Shutdown(ctx), "the send waiting for the pause is aborted")
+	require.NoError(t, <-done)
+	require.Empty(t, tb.messages())
+}
+
+func TestFloodWaitDisabled(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.Nil(t, b.flood)
+
+	tb.sendErr = func() error { return floodError(600) }
+	_, err := b.telegram.Send(payments, "first")
+	require.Error(t, err)
+	tb.sendErr = nil
+	_, err = b.telegram.Send(payments, "second")
+	require.NoError(t, err, "without flood wait, sends go on right away")
+}
@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandFormat = "/format"
+
+	formatCompact = "compact"
+	formatNormal  = "normal"
+	formatVerbose = "verbose"
+
+	responseFormatUsage = "Usage: " + CommandFormat + " " + formatCompact + "|" + formatNormal + "|" + formatVerbose
+)
+
+// formatPresets are the formats /format offers. Normal renders telegram.default, the others telegram.<preset>.
+var formatPresets = []string{formatCompact, formatNormal, formatVerbose}
+
+// presetTemplates defines the templates of the format presets besides normal. They're parsed before the configured
+// templates, which can define them as well to override them.
+const presetTemplates = `{{ define "telegram.compact" }}{{ range .Alerts }}{{ if eq .Status "firing" }}{{ severityEmoji (label "severity" .) }}{{ else }}✅{{ end }} <b>{{ label "alertname" . }}</b>{{ with label "environment" . }} · {{ . }}{{ end }} · {{ if eq .Status "firing" }}{{ firingFor .StartsAt }}{{ else }}resolved after {{ or (resolvedAfter $.ResolvedAfter .Fingerprint) (duration .StartsAt .EndsAt) }}{{ end }}
+{{ end }}{{ end }}
+
+{{ define "telegram.verbose" }}
+{{ range .Alerts }}
+{{ if eq .Status "firing" }}{{ severityEmoji (label "severity" .) }} <b>{{ label "alertname" . }}</b> FIRING{{ else }}✅ <b>{{ label "alertname" . }}</b> RESOLVED{{ end }}
+<b>Labels:</b>{{ range .Labels.SortedPairs }}
+    {{ .Name }}: {{ .Value }}{{ end }}
+<b>Annotations:</b>{{ range .Annotations.SortedPairs }}
+    {{ .Name }}: {{ .Value }}{{ end }}
+<b>Started:</b> {{ (.StartsAt.In $.Chat.Location).Format "2006-01-02 15:04:05 MST" }}{{ if eq .Status "firing" }}
+<b>Firing for:</b> {{ firingFor .StartsAt }}{{ else }}
+<b>Ended:</b> {{ (.EndsAt.In $.Chat.Location).Format "2006-01-02 15:04:05 MST" }}
+<b>Fired for:</b> {{ or (resolvedAfter $.ResolvedAfter .Fingerprint) (duration .StartsAt .EndsAt) }}{{ end }}{{ with .GeneratorURL }}
+<b>Source:</b> {{ . }}{{ end }}{{ with .Fingerprint }}
+<b>Fingerprint:</b> {{ . }}{{ end }}
+{{ end }}
+{{ end }}
+`
+
+// SetFormat sets the format preset of the chat's notifications, "" for normal.
+func (s *ChatStore) SetFormat(id int64, preset string) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Format = preset
+		return nil
+	})
+}
+
+// formatTemplate is the template rendering the alerts of chats with the preset.
+func formatTemplate(preset string) string {
+	switch preset {
+	case formatCompact, formatVerbose:
+		return "telegram." + preset
+	}
+	return "telegram.default"
+}
+
+// formatExample is the alert /format renders in each preset.
+func (b *Bot) formatExample(now time.Time) *template.Data {
+	labels := template.KV{"alertname": "HighCPU", labelSeverity: "warning"}
+	if len(b.environments) > 0 {
+		labels[labelEnvironment] = b.environments[0]
+	}
+	if len(b.projects) > 0 {
+		labels[labelProject] = b.projects[0]
+	}
+	return &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{
+			Status:      "firing",
+			Labels:      labels,
+			Annotations: template.KV{"summary": "CPU usage is above 90%"},
+			StartsAt:    now.Add(-42 * time.Minute),
+			Fingerprint: "0123456789abcdef",
+		}},
+		CommonLabels: labels,
+	}
+}
+
+func (b *Bot) handleFormat(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the format of this chat")
+		return err
+	}
+
+	payload := strings.ToLower(strings.TrimSpace(message.Payload))
+	if payload == "" {
+		current := ci.Format
+		if current == "" {
+			current = formatNormal
+		}
+		var out strings.Builder
+		fmt.Fprintf(&out, "Notifications to this chat are formatted %s.\n%s", current, responseFormatUsage)
+		for _, preset := range formatPresets {
+			td := b.newTemplateData(ci, b.formatExample(time.Now()))
+			td.Chat.Format = preset
+			fmt.Fprintf(&out, "\n\n<b>%s</b>\n%s", preset, strings.Trim(b.renderAlertsOrPlain(b.logger, td), "\n"))
+		}
+		_, err = b.reply(message, out.String(), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+		return err
+	}
+
+	valid := false
+	for _, p := range formatPresets {
+		valid = valid || p == payload
+	}
+	if !valid {
+		_, err = b.reply(message, responseFormatUsage)
+		return err
+	}
+
+	stored := payload
+	if payload == formatNormal {
+		stored = ""
+	}
+	if err := b.chats.SetFormat(message.Chat.ID, stored); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set format", "err", err)
+		_, err = b.replyStoreError(message, err, "set the format of this chat")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Notifications to this chat are formatted %s now.", payload))
+	return err
+}
@@ -0,0 +1,106 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// sinceOutput matches what the since func renders down to (milli|micro)seconds, which changes while the test runs.
+var sinceOutput = regexp.MustCompile(`(<b>(Duration|Ended):</b>) [^\n]*second[^\n]*`)
+
+func TestFormatPresetsGolden(t *testing.T) {
+	b, _, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+
+	started := time.Now().Add(-42 * time.Minute)
+	resolvedAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	data := &template.Data{Status: "firing", Alerts: template.Alerts{
+		{
+			Status:       "firing",
+			Labels:       template.KV{"alertname": "HighCPU", "severity": "critical", "environment": "prod", "project": "billing"},
+			Annotations:  template.KV{"summary": "CPU is <busy>", "runbook": "https://runbooks.example.com/cpu"},
+			StartsAt:     started,
+			GeneratorURL: "http://prometheus:9090/graph?g0.expr=cpu&g0.tab=1",
+			Fingerprint:  "c0ffee",
+		},
+		{
+			Status:      "resolved",
+			Labels:      template.KV{"alertname": "DiskFull", "environment": "staging"},
+			Annotations: template.KV{"summary": "Disk is full"},
+			StartsAt:    resolvedAt.Add(-42 * time.Minute),
+			EndsAt:      resolvedAt,
+			Fingerprint: "d15c",
+		},
+	}}
+
+	var out []string
+	for _, preset := range formatPresets {
+		ci.Format = preset
+		text, err := b.renderAlerts(ci, data)
+		require.NoError(t, err, preset)
+		text = strings.Replace(text, started.UTC().Format("2006-01-02 15:04:05 MST"), "<started>", -1)
+		text = sinceOutput.ReplaceAllString(text, "$1 <since>")
+		out = append(out, "== "+preset+" ==\n"+text)
+	}
+	golden, err := ioutil.ReadFile("testdata/format_presets.html")
+	require.NoError(t, err)
+	require.Equal(t, string(golden), strings.Join(out, "\n---\n")+"\n")
+}
+
+func TestFormatCommand(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	format := b.middleware(b.handleFormat)
+
+	format(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandFormat})
+	text := tb.lastText()
+	require.True(t, strings.HasPrefix(text, "Notifications to this chat are formatted normal.\n"+responseFormatUsage), text)
+	require.Contains(t, text, "\n\n<b>compact</b>\n🔥 <b>HighCPU</b> · prod · 42 minutes\n\n<b>normal</b>\n")
+	require.Contains(t, text, "\n\n<b>verbose</b>\n")
+
+	format(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandFormat + " tiny", Payload: "tiny"})
+	require.Equal(t, responseFormatUsage, tb.lastText())
+
+	format(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandFormat + " Compact", Payload: "Compact"})
+	require.Equal(t, "Notifications to this chat are formatted compact now.", tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, formatCompact, ci.Format)
+
+	_, err = b.processWebhook(context.Background(), mixedWebhook("firing", template.Alert{
+		Status: "firing", Labels: template.KV{"alertname": "HighCPU", "environment": "prod"}, StartsAt: time.Now().Add(-5 * time.Minute),
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "🔥 <b>HighCPU</b> · prod · 5 minutes", tb.lastText())
+
+	format(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandFormat + " normal", Payload: "normal"})
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.Format, "normal is stored as the default")
+}
+
+func TestFormatPresetOverriddenByTemplates(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "custom.tmpl")
+	require.NoError(t, ioutil.WriteFile(custom, []byte(`{{ define "telegram.default" }}default{{ end }}{{ define "telegram.compact" }}own compact{{ end }}`), 0o644))
+	b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, custom))
+
+	data := &template.Data{Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}}}}
+	out, err := b.renderAlerts(&ChatInfo{Format: formatCompact}, data)
+	require.NoError(t, err)
+	require.Equal(t, "own compact", out)
+	out, err = b.renderAlerts(&ChatInfo{Format: formatVerbose}, data)
+	require.NoError(t, err)
+	require.Contains(t, out, "<b>HighCPU</b> FIRING", "presets the templates don't define are kept")
+}
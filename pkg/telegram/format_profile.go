@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// FormatProfile is how messages to the chats of a type are formatted, like shorter ones in private chats.
+// The zero value keeps the bot's defaults.
+type FormatProfile struct {
+	// MaxAlerts is the number of alerts a message shows in full, 0 keeps the bot's default and -1 shows all.
+	MaxAlerts int `json:"max_alerts,omitempty"`
+	// HideAnnotations drops the annotations of alerts, like their descriptions.
+	HideAnnotations bool `json:"hide_annotations,omitempty"`
+	// HideButtons leaves out the "Create issue" and "Show all" buttons.
+	HideButtons bool `json:"hide_buttons,omitempty"`
+	// NoDigest leaves out the summary of the alerts a message doesn't show in full.
+	NoDigest bool `json:"no_digest,omitempty"`
+}
+
+// chatFormat is how messages to a chat are formatted, once its overrides, its type's profile and the defaults are resolved.
+type chatFormat struct {
+	// MaxAlerts is the number of alerts a message shows in full, 0 shows all.
+	MaxAlerts    int
+	Annotations  bool
+	IssueButtons bool
+	ShowAll      bool
+	Digest       bool
+}
+
+// WithFormatProfiles sets how messages to private chats, groups, supergroups and channels are formatted.
+// What a chat sets itself, like with /max_alerts, overrides the profile of its type.
+func WithFormatProfiles(profiles map[telebot.ChatType]FormatProfile) BotOption {
+	return func(b *Bot) error {
+		for chatType, p := range profiles {
+			switch chatType {
+			case telebot.ChatPrivate, telebot.ChatGroup, telebot.ChatSuperGroup, telebot.ChatChannel:
+			default:
+				return fmt.Errorf("unknown chat type %q of a format profile, use private, group, supergroup or channel", chatType)
+			}
+			if p.MaxAlerts < -1 {
+				return fmt.Errorf("max alerts of the %s format profile must be -1 for all or more, is %d", chatType, p.MaxAlerts)
+			}
+		}
+		b.formatProfiles = profiles
+		return nil
+	}
+}
+
+// resolveFormat resolves how messages to the chat are formatted: what the chat set overrides the profile of its type,
+// which overrides the bot's defaults.
+func resolveFormat(ci *ChatInfo, profiles map[telebot.ChatType]FormatProfile, maxAlerts int) chatFormat {
+	f := chatFormat{MaxAlerts: maxAlerts, Annotations: true, IssueButtons: true, ShowAll: true, Digest: true}
+	if ci == nil {
+		return f
+	}
+
+	if ci.Chat != nil {
+		if p, ok := profiles[ci.Chat.Type]; ok {
+			switch {
+			case p.MaxAlerts > 0:
+				f.MaxAlerts = p.MaxAlerts
+			case p.MaxAlerts == -1:
+				f.MaxAlerts = 0
+			}
+			f.Annotations = !p.HideAnnotations
+			f.IssueButtons = !p.HideButtons
+			f.ShowAll = !p.HideButtons
+			f.Digest = !p.NoDigest
+		}
+	}
+
+	if ci.MaxAlertsPerMessage > 0 {
+		f.MaxAlerts = ci.MaxAlertsPerMessage
+	}
+	if ci.IssueButtonsOff {
+		f.IssueButtons = false
+	}
+	return f
+}
+
+// chatFormat is how messages to the chat are formatted.
+func (b *Bot) chatFormat(ci *ChatInfo) chatFormat {
+	return resolveFormat(ci, b.formatProfiles, b.maxAlerts)
+}
+
+// withoutAnnotations returns copies of the alerts without annotations.
+func withoutAnnotations(data *template.Data) *template.Data {
+	out := *data
+	out.Alerts = make(template.Alerts, len(data.Alerts))
+	for i, a := range data.Alerts {
+		a.Annotations = template.KV{}
+		out.Alerts[i] = a
+	}
+	out.CommonAnnotations = template.KV{}
+	return &out
+}
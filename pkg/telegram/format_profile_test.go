@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestResolveFormat(t *testing.T) {
+	profiles := map[telebot.ChatType]FormatProfile{
+		telebot.ChatPrivate: {MaxAlerts: 3, HideAnnotations: true, HideButtons: true, NoDigest: true},
+		telebot.ChatChannel: {MaxAlerts: -1},
+	}
+	defaults := chatFormat{MaxAlerts: 10, Annotations: true, IssueButtons: true, ShowAll: true, Digest: true}
+
+	for _, tc := range []struct {
+		name     string
+		ci       *ChatInfo
+		expected chatFormat
+	}{
+		{name: "no chat", expected: defaults},
+		{name: "type without profile", ci: &ChatInfo{Chat: sharedGroup}, expected: defaults},
+		{
+			name:     "type profile",
+			ci:       &ChatInfo{Chat: testChat},
+			expected: chatFormat{MaxAlerts: 3},
+		},
+		{
+			name:     "-1 shows all",
+			ci:       &ChatInfo{Chat: &telebot.Chat{ID: -1001, Type: telebot.ChatChannel}},
+			expected: chatFormat{MaxAlerts: 0, Annotations: true, IssueButtons: true, ShowAll: true, Digest: true},
+		},
+		{
+			name:     "chat overrides its type",
+			ci:       &ChatInfo{Chat: testChat, MaxAlertsPerMessage: 7},
+			expected: chatFormat{MaxAlerts: 7},
+		},
+		{
+			name:     "chat overrides the default",
+			ci:       &ChatInfo{Chat: sharedGroup, MaxAlertsPerMessage: 7, IssueButtonsOff: true},
+			expected: chatFormat{MaxAlerts: 7, Annotations: true, ShowAll: true, Digest: true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, resolveFormat(tc.ci, profiles, 10))
+		})
+	}
+}
+
+func TestFormatProfilesInWebhooks(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithMaxAlerts(5),
+		WithFormatProfiles(map[telebot.ChatType]FormatProfile{
+			telebot.ChatPrivate: {MaxAlerts: 2, HideAnnotations: true, HideButtons: true, NoDigest: true},
+			telebot.ChatGroup:   {MaxAlerts: -1},
+		}),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	alerts := alertsNamed(map[string]int{"HighCPU": 10})
+	for i := range alerts {
+		alerts[i].Annotations = template.KV{"summary": "CPU is busy"}
+	}
+	for _, chat := range []*telebot.Chat{testChat, sharedGroup} {
+		w := alertmanager.TelegramWebhook{ChatID: chat.ID, Message: webhook.Message{Data: &template.Data{Status: "firing", Alerts: alerts}}}
+		_, err := b.processWebhook(context.Background(), w)
+		require.NoError(t, err)
+	}
+
+	private, group := tb.messages()[0], tb.messages()[1]
+	require.Equal(t, 2, strings.Count(private.text(), "HighCPU"), "private chats show 2 alerts")
+	require.NotContains(t, private.text(), "CPU is busy")
+	require.NotContains(t, private.text(), "more", "private chats get no digest")
+	require.Nil(t, private.options[0].(*telebot.SendOptions).ReplyMarkup, "private chats get no buttons")
+
+	require.Equal(t, 10, strings.Count(group.text(), "HighCPU"), "groups show all alerts")
+	require.Contains(t, group.text(), "CPU is busy")
+
+	_, err := NewBotWithTelegram(nil, &fakeTelebot{}, testAdmin.ID, WithFormatProfiles(map[telebot.ChatType]FormatProfile{"bot": {}}))
+	require.Error(t, err)
+}
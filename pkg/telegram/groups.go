@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// groupedModifier makes /alerts list the alerts by Alertmanager's alert groups, like /alerts grouped.
+const groupedModifier = "grouped"
+
+// alertGroup is an alert group with its alerts converted, ready to render.
+type alertGroup struct {
+	labels model.LabelSet
+	alerts []*types.Alert
+}
+
+// alertGroups converts the groups, leaving out empty ones, the groups are sorted by their labels
+// and the alerts of each group by when they started firing, the oldest first.
+func alertGroups(groups []*models.AlertGroup) []alertGroup {
+	converted := make([]alertGroup, 0, len(groups))
+	for _, g := range groups {
+		if g == nil || len(g.Alerts) == 0 {
+			continue
+		}
+		ag := alertGroup{labels: make(model.LabelSet, len(g.Labels)), alerts: alertmanager.GroupAlerts(g)}
+		for name, value := range g.Labels {
+			ag.labels[model.LabelName(name)] = model.LabelValue(value)
+		}
+		sort.SliceStable(ag.alerts, func(i, j int) bool {
+			if !ag.alerts[i].StartsAt.Equal(ag.alerts[j].StartsAt) {
+				return ag.alerts[i].StartsAt.Before(ag.alerts[j].StartsAt)
+			}
+			return ag.alerts[i].Name() < ag.alerts[j].Name()
+		})
+		converted = append(converted, ag)
+	}
+	sort.SliceStable(converted, func(i, j int) bool { return converted[i].labels.String() < converted[j].labels.String() })
+	return converted
+}
+
+// groupHeading names the group by its labels, like:
+// <b>alertname=HighCPU, environment=prod</b> (3 alerts)
+func groupHeading(g alertGroup) string {
+	names := make([]string, 0, len(g.labels))
+	for name := range g.labels {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, g.labels[model.LabelName(name)]))
+	}
+	heading := "all alerts"
+	if len(pairs) > 0 {
+		heading = strings.Join(pairs, ", ")
+	}
+	return fmt.Sprintf("<b>%s</b> (%d alerts)", html.EscapeString(heading), len(g.alerts))
+}
+
+// groupedAlertLine condenses an alert of a group to a line with the labels the group doesn't share, like:
+// • 🔥 HighCPU instance=web-1, firing for 2 hours
+func groupedAlertLine(a *types.Alert, group model.LabelSet, now time.Time, emoji func(severity string) string) string {
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		if _, ok := group[name]; ok || name == model.AlertNameLabel {
+			continue
+		}
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, a.Labels[model.LabelName(name)]))
+	}
+
+	line := "• "
+	if emoji != nil {
+		line += emoji(string(a.Labels["severity"])) + " "
+	}
+	line += "<code>" + html.EscapeString(a.Name()) + "</code>"
+	if len(pairs) > 0 {
+		line += " " + html.EscapeString(strings.Join(pairs, " "))
+	}
+	return line + ", firing for " + formatFiringDuration(now.Sub(a.StartsAt))
+}
+
+// renderAlertGroups renders a section per group: its labels as a heading and its alerts condensed beneath.
+// The groups share the budget, what a group doesn't use is left to the following ones,
+// a group not fitting its share ends with how many of its alerts are left out.
+func renderAlertGroups(groups []alertGroup, now time.Time, budget int, emoji func(severity string) string) string {
+	var total int
+	for _, g := range groups {
+		total += len(g.alerts)
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "<b>%d alerts in %d groups</b>\n", total, len(groups))
+
+	for i, g := range groups {
+		share := (budget - out.Len()) / (len(groups) - i)
+		section := "\n" + groupHeading(g) + "\n"
+		for j, a := range g.alerts {
+			line := groupedAlertLine(a, g.labels, now, emoji) + "\n"
+			rest := len(g.alerts) - j - 1
+			more := ""
+			if rest > 0 {
+				more = fmt.Sprintf("(+%d more alerts in this group)\n", rest)
+			}
+			if len(section)+len(line)+len(more) > share {
+				section += fmt.Sprintf("(+%d more alerts in this group)\n", len(g.alerts)-j)
+				break
+			}
+			section += line
+		}
+		out.WriteString(section)
+	}
+	return out.String()
+}
+
+func (b *Bot) handleGroupedAlerts(message *telebot.Message, receiver string) error {
+	groups, err := b.alertmanager.ListAlertGroups(context.TODO(), receiver)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list alert groups", "err", err)
+		_, err = b.replyError(message, "failed to list alert groups", fmt.Sprintf("failed to list alert groups... %v", err))
+		return err
+	}
+
+	converted := alertGroups(groups)
+	if len(converted) == 0 {
+		_, err = b.respond(message, "no_alerts", responseContext(message))
+		return err
+	}
+
+	out := renderAlertGroups(converted, time.Now(), telegramMessageMaxLength, b.severityEmojiFunc())
+	_, err = b.replyFormatted(message, b.truncateMessage(out), &telebot.SendOptions{
+		ParseMode: telebot.ModeHTML,
+	})
+	return err
+}
@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// alertGroupsFixture returns three groups: HighCPU in prod and staging, and DiskFull.
+func alertGroupsFixture(now time.Time) []*models.AlertGroup {
+	firing := func(b *alertmanagertest.AlertBuilder, d time.Duration) *types.Alert {
+		a := b.Build()
+		a.StartsAt = now.Add(-d)
+		a.EndsAt = now.Add(time.Hour)
+		return a
+	}
+	const receiver = "/webhooks/telegram/123"
+	return []*models.AlertGroup{
+		alertmanagertest.Group(receiver, model.LabelSet{"alertname": "HighCPU", "environment": "staging"},
+			firing(alertmanagertest.Alert("HighCPU").Environment("staging").Severity("warning").Label("instance", "web-3"), 10*time.Minute),
+		),
+		alertmanagertest.Group(receiver, model.LabelSet{"alertname": "HighCPU", "environment": "prod"},
+			firing(alertmanagertest.Alert("HighCPU").Environment("prod").Severity("critical").Label("instance", "web-2"), 30*time.Minute),
+			firing(alertmanagertest.Alert("HighCPU").Environment("prod").Severity("critical").Label("instance", "web-1"), 2*time.Hour),
+		),
+		alertmanagertest.Group(receiver, model.LabelSet{"alertname": "DiskFull"},
+			firing(alertmanagertest.Alert("DiskFull").Project("billing").Label("device", "/dev/sda1"), 3*time.Hour),
+		),
+		alertmanagertest.Group(receiver, model.LabelSet{"alertname": "Watchdog"}),
+	}
+}
+
+func TestRenderAlertGroups(t *testing.T) {
+	now := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	b, _, _ := newTestBot(t, WithSeverityEmoji(map[string]string{"critical": "🔥", "warning": "⚠️"}))
+
+	out := renderAlertGroups(alertGroups(alertGroupsFixture(now)), now, telegramMessageMaxLength, b.severityEmojiFunc())
+	golden, err := ioutil.ReadFile("testdata/alerts_grouped.html")
+	require.NoError(t, err)
+	require.Equal(t, string(golden), out)
+}
+
+func TestRenderAlertGroupsCollapse(t *testing.T) {
+	now := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	var alerts []*types.Alert
+	for i := 0; i < 100; i++ {
+		a := alertmanagertest.Alert("TargetDown").Label("instance", "node-exporter-"+string(rune('a'+i%26))+string(rune('a'+i/26))).Build()
+		a.StartsAt = now.Add(-time.Hour)
+		alerts = append(alerts, a)
+	}
+	groups := alertGroups([]*models.AlertGroup{
+		alertmanagertest.Group("ops", model.LabelSet{"alertname": "TargetDown"}, alerts...),
+		alertmanagertest.Group("ops", model.LabelSet{"alertname": "Watchdog"}, alertmanagertest.Alert("Watchdog").Build()),
+	})
+
+	out := renderAlertGroups(groups, now, 1000, nil)
+	require.LessOrEqual(t, len(out), 1000)
+	require.Contains(t, out, "<b>101 alerts in 2 groups</b>")
+	require.Regexp(t, `\(\+\d+ more alerts in this group\)\n\n<b>alertname=Watchdog</b> \(1 alerts\)\n• <code>Watchdog</code>`, out,
+		"the big group collapses, the small one is still listed")
+}
+
+func TestHandleAlertsGrouped(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{AlertGroups: alertGroupsFixture(time.Now())}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/alerts grouped", Payload: "grouped"}))
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListAlertGroups))
+	require.Equal(t, 0, am.CallCount(alertmanagertest.MethodListAlerts))
+	require.Contains(t, tb.lastText(), "4 alerts in 3 groups")
+
+	am.AlertGroups = nil
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/alerts grouped", Payload: "grouped"}))
+	require.Equal(t, "No alerts right now! 🎉", tb.lastText())
+}
@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// selfCheckTimeout bounds the request the webhook self-check sends through the public URL.
+const selfCheckTimeout = 5 * time.Second
+
+// WithWebhookSelfCheck signs the no-op webhooks the bot sends itself through its public URL with secret,
+// to check in /status and /readyz that Alertmanager can reach it. Without a public URL there's no self-check.
+func WithWebhookSelfCheck(secret []byte) BotOption {
+	return func(b *Bot) error {
+		b.selfCheckSecret = secret
+		return nil
+	}
+}
+
+// Check is the outcome of checking something the bot needs to work.
+type Check struct {
+	Name string
+	// Err is why the check failed, nil if it passed.
+	Err error
+	// Latency is how long the check took, if that's telling.
+	Latency time.Duration
+	// Advisory checks are reported but don't make the bot unready, their failure may be outside of it.
+	Advisory bool
+}
+
+func (c Check) String() string {
+	if c.Err != nil {
+		return fmt.Sprintf("%s: %v", c.Name, c.Err)
+	}
+	if c.Latency > 0 {
+		return fmt.Sprintf("%s: OK (%s)", c.Name, formatLatency(c.Latency))
+	}
+	return c.Name + ": OK"
+}
+
+// Checks checks that the templates still render and, with a public URL, that the webhook route is reachable through it.
+func (b *Bot) Checks(ctx context.Context) []Check {
+	checks := []Check{b.checkTemplates()}
+	if b.publicURL != "" && len(b.selfCheckSecret) > 0 {
+		checks = append(checks, b.checkWebhookRoute(ctx))
+	}
+	return checks
+}
+
+// cannedAlerts is what the template check renders, a firing and a resolved alert.
+func cannedAlerts(now time.Time) *template.Data {
+	return &template.Data{
+		Receiver: "telegram",
+		Status:   "firing",
+		Alerts: template.Alerts{
+			{
+				Status:      "firing",
+				Labels:      template.KV{"alertname": "TemplateCheck", "severity": "warning"},
+				Annotations: template.KV{"summary": "Checking that the templates render"},
+				StartsAt:    now.Add(-time.Hour),
+				Fingerprint: "0000000000000001",
+			},
+			{
+				Status:      "resolved",
+				Labels:      template.KV{"alertname": "TemplateCheck", "severity": "critical"},
+				Annotations: template.KV{"summary": "Checking that the templates render"},
+				StartsAt:    now.Add(-2 * time.Hour),
+				EndsAt:      now.Add(-time.Hour),
+				Fingerprint: "0000000000000002",
+			},
+		},
+		GroupLabels:       template.KV{"alertname": "TemplateCheck"},
+		CommonLabels:      template.KV{"alertname": "TemplateCheck"},
+		CommonAnnotations: template.KV{"summary": "Checking that the templates render"},
+	}
+}
+
+// checkTemplates renders the default template with canned alerts.
+func (b *Bot) checkTemplates() (check Check) {
+	check = Check{Name: "templates"}
+	if b.templates == nil {
+		check.Err = errNoTemplates
+		return check
+	}
+	// A template calling a func on a missing value panics rather than failing.
+	defer func() {
+		if r := recover(); r != nil {
+			check.Err = fmt.Errorf("template panicked: %v", r)
+		}
+	}()
+
+	out, err := b.renderAlerts(nil, cannedAlerts(time.Now()))
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	if strings.TrimSpace(out) == "" {
+		check.Err = fmt.Errorf("template rendered nothing")
+	}
+	return check
+}
+
+// checkWebhookRoute sends a signed no-op webhook through the public URL, which the webhook handler answers without delivering it.
+// It's advisory: the public URL may lead to another replica, or be down while Alertmanager still reaches the bot.
+func (b *Bot) checkWebhookRoute(ctx context.Context) Check {
+	check := Check{Name: "webhook", Advisory: true}
+
+	body, err := json.Marshal(alertmanager.SelfCheckWebhook(time.Now()))
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.publicURL+"/webhooks/telegram/0", bytes.NewReader(body))
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(alertmanager.SignatureHeader, alertmanager.SignWebhook(b.selfCheckSecret, body))
+
+	start := time.Now()
+	resp, err := b.selfCheckClient.Do(req)
+	if err != nil {
+		check.Err = fmt.Errorf("%s is unreachable: %w", b.publicURL, err)
+		return check
+	}
+	resp.Body.Close()
+	check.Latency = time.Since(start)
+
+	if resp.StatusCode != http.StatusNoContent {
+		// Anything else means the request reached something that isn't this bot's webhook handler.
+		check.Err = fmt.Errorf("%s answered %s, not the bot's self-check reply", b.publicURL, resp.Status)
+	}
+	return check
+}
+
+// statusChecks lists the checks for /status in Markdown.
+func statusChecks(checks []Check) string {
+	out := "*Checks*"
+	for _, c := range checks {
+		out += "\n" + escapeMarkdown(c.String())
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var selfCheckSecret = []byte("s3cret")
+
+// selfCheckServer serves the webhook handler like the bot's public URL does.
+func selfCheckServer(t *testing.T, webhooks chan alertmanager.TelegramWebhook) *httptest.Server {
+	m := http.NewServeMux()
+	m.Handle("/webhooks/telegram/", alertmanager.HandleSelfCheck(selfCheckSecret,
+		alertmanager.HandleTelegramWebhook(log.NewNopLogger(), prometheus.NewCounter(prometheus.CounterOpts{Name: "webhooks"}), webhooks)))
+	s := httptest.NewServer(m)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestCheckTemplates(t *testing.T) {
+	b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.Equal(t, []Check{{Name: "templates"}}, b.Checks(context.Background()))
+
+	broken := filepath.Join(t.TempDir(), "broken.tmpl")
+	require.NoError(t, ioutil.WriteFile(broken, []byte(`{{ define "telegram.default" }}{{ .Commonlabels.alertname }}{{ end }}`), 0o644))
+	b, _, _ = newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, broken))
+	checks := b.Checks(context.Background())
+	require.Len(t, checks, 1)
+	require.Error(t, checks[0].Err)
+	require.Contains(t, checks[0].String(), "templates: ")
+	require.Contains(t, checks[0].String(), "Commonlabels")
+}
+
+func TestCheckWebhookRoute(t *testing.T) {
+	webhooks := make(chan alertmanager.TelegramWebhook, 1)
+	public := selfCheckServer(t, webhooks)
+	notTheBot := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(notTheBot.Close)
+
+	for _, tc := range []struct {
+		name      string
+		publicURL string
+		secret    []byte
+		err       string
+	}{
+		{name: "reachable", publicURL: public.URL, secret: selfCheckSecret},
+		{name: "another secret", publicURL: public.URL, secret: []byte("other replica"), err: "401 Unauthorized"},
+		{name: "something else answers", publicURL: notTheBot.URL, secret: selfCheckSecret, err: "404 Not Found"},
+		{name: "unreachable", publicURL: "http://127.0.0.1:1", secret: selfCheckSecret, err: "is unreachable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _, _ := newTestBot(t,
+				WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+				WithPublicURL(tc.publicURL),
+				WithWebhookSelfCheck(tc.secret),
+			)
+			checks := b.Checks(context.Background())
+			require.Len(t, checks, 2)
+			webhook := checks[1]
+			require.Equal(t, "webhook", webhook.Name)
+			require.True(t, webhook.Advisory, "an unreachable public URL doesn't make the bot unready")
+			if tc.err == "" {
+				require.NoError(t, webhook.Err)
+				require.NotZero(t, webhook.Latency)
+				require.Regexp(t, `^webhook: OK \(.+\)$`, webhook.String())
+			} else {
+				require.Error(t, webhook.Err)
+				require.Contains(t, webhook.Err.Error(), tc.err)
+			}
+			require.Empty(t, webhooks, "self-checks aren't delivered")
+		})
+	}
+
+	t.Run("no public URL", func(t *testing.T) {
+		b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithWebhookSelfCheck(selfCheckSecret))
+		require.Len(t, b.Checks(context.Background()), 1)
+	})
+}
+
+func TestStatusShowsChecks(t *testing.T) {
+	webhooks := make(chan alertmanager.TelegramWebhook, 1)
+	public := selfCheckServer(t, webhooks)
+
+	uptime := strfmt.DateTime(time.Now().Add(-time.Hour))
+	version := "0.21.0"
+	am := &alertmanagertest.Alertmanager{AlertmanagerStatus: &models.AlertmanagerStatus{
+		Uptime:      &uptime,
+		VersionInfo: &models.VersionInfo{Version: &version},
+	}}
+	b, tb, _ := newTestBot(t,
+		WithAlertmanager(am),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithPublicURL(public.URL),
+		WithWebhookSelfCheck(selfCheckSecret),
+	)
+
+	require.NoError(t, b.handleStatus(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Contains(t, tb.lastText(), "*Checks*\ntemplates: OK\nwebhook: OK (")
+	require.Contains(t, tb.lastText(), "\nUnlabeled alerts: delivered as other\n")
+}
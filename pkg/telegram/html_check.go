@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// telegramHTMLTags are the tags Telegram's HTML parse mode knows.
+var telegramHTMLTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "a": true, "code": true, "pre": true,
+	"span": true, "tg-spoiler": true, "blockquote": true,
+}
+
+// telegramHTMLEntity matches the entities Telegram's HTML parse mode knows, at the start of a string.
+var telegramHTMLEntity = regexp.MustCompile(`^&(lt|gt|amp|quot|#[0-9]+|#x[0-9a-fA-F]+);`)
+
+// telegramHTMLTag matches the tags Telegram knows, to drop them from the plain version of a message.
+var telegramHTMLTag = regexp.MustCompile(`</?(b|strong|i|em|u|ins|s|strike|del|a|code|pre|span|tg-spoiler|blockquote)(\s[^<>]*)?>`)
+
+// WithHTMLCheck checks messages sent in HTML parse mode before sending them.
+// A message Telegram can't parse, like one with an annotation containing a raw <nil> that a template didn't escape,
+// is sent as escaped plain text instead of getting rejected.
+func WithHTMLCheck(check bool) BotOption {
+	return func(b *Bot) error {
+		b.htmlCheck = check
+		return nil
+	}
+}
+
+// checkTelegramHTML returns why Telegram can't parse s in HTML parse mode, nil if it can:
+// only the tags it knows are used, they're properly nested and closed, and every < > & is part of a tag or an entity.
+func checkTelegramHTML(s string) error {
+	var open []string
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '<':
+			end := strings.IndexAny(s[i+1:], "<>")
+			if end < 0 || s[i+1+end] != '>' {
+				return fmt.Errorf("unescaped < at byte %d", i)
+			}
+			tag := s[i+1 : i+1+end]
+			closing := strings.HasPrefix(tag, "/")
+			fields := strings.Fields(strings.TrimPrefix(tag, "/"))
+			if len(fields) == 0 || !telegramHTMLTags[strings.ToLower(fields[0])] {
+				return fmt.Errorf("unsupported tag <%s> at byte %d", tag, i)
+			}
+			name := strings.ToLower(fields[0])
+			if closing {
+				if len(open) == 0 || open[len(open)-1] != name {
+					return fmt.Errorf("unexpected end tag </%s> at byte %d", name, i)
+				}
+				open = open[:len(open)-1]
+			} else {
+				open = append(open, name)
+			}
+			i += end + 1
+		case '>':
+			return fmt.Errorf("unescaped > at byte %d", i)
+		case '&':
+			entity := telegramHTMLEntity.FindString(s[i:])
+			if entity == "" {
+				return fmt.Errorf("unescaped & at byte %d", i)
+			}
+			i += len(entity) - 1
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("tag <%s> isn't closed", open[len(open)-1])
+	}
+	return nil
+}
+
+// escapedPlainHTML drops the tags of s and escapes everything else, so that Telegram's HTML parse mode shows it as plain text.
+func escapedPlainHTML(s string) string {
+	return html.EscapeString(html.UnescapeString(telegramHTMLTag.ReplaceAllString(s, "")))
+}
+
+// checkedHTML returns the text to send, the escaped plain version of it if the parse mode is HTML and Telegram couldn't parse it.
+func (b *Bot) checkedHTML(logger log.Logger, text string, mode telebot.ParseMode) string {
+	if !b.htmlCheck || mode != telebot.ModeHTML {
+		return text
+	}
+	err := checkTelegramHTML(text)
+	if err == nil {
+		return text
+	}
+	level.Warn(logger).Log("msg", "message isn't valid HTML for Telegram, sending it as plain text", "err", err)
+	b.htmlFallbacks.Inc()
+	return escapedPlainHTML(text)
+}
@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestCheckTelegramHTML(t *testing.T) {
+	valid := []string{
+		"plain text",
+		"<b>HighCPU</b> &lt;nil&gt; &amp; &quot;quoted&quot; &#39;single&#39; &#x2F;",
+		`<a href="http://example.com/?a=1&amp;b=2">link</a> <pre><code class="language-go">x</code></pre>`,
+		"<B>upper case</B>",
+	}
+	for _, s := range valid {
+		require.NoError(t, checkTelegramHTML(s), s)
+	}
+
+	invalid := map[string]string{
+		"summary: <nil>":            "unsupported tag <nil>",
+		"map[foo:<bar>]":            "unsupported tag <bar>",
+		"List<String> failed":       "unsupported tag <String>",
+		"x < 5":                     "unescaped <",
+		"a > b":                     "unescaped >",
+		"Tom & Jerry":               "unescaped &",
+		"&nbsp;":                    "unescaped &",
+		"<b>bold":                   "tag <b> isn't closed",
+		"<b><i>crossed</b></i>":     "unexpected end tag </b>",
+		"</code>":                   "unexpected end tag </code>",
+		"<b>HighCPU</b> <script>":   "unsupported tag <script>",
+		"<b>HighCPU</b> <<b>x</b>>": "unescaped <",
+	}
+	for s, reason := range invalid {
+		err := checkTelegramHTML(s)
+		require.Error(t, err, s)
+		require.Contains(t, err.Error(), reason, s)
+	}
+}
+
+func TestEscapedPlainHTML(t *testing.T) {
+	s := "<b>HighCPU</b> summary: <nil> &amp; Tom & Jerry, x < 5"
+	escaped := escapedPlainHTML(s)
+	require.Equal(t, "HighCPU summary: &lt;nil&gt; &amp; Tom &amp; Jerry, x &lt; 5", escaped)
+	require.NoError(t, checkTelegramHTML(escaped))
+}
+
+// htmlWebhook has an alert whose annotations contain raw HTML, a stray < and &.
+func htmlWebhook() template.Alerts {
+	return template.Alerts{{
+		Status: "firing",
+		Labels: template.KV{"alertname": "PanicInHandler"},
+		Annotations: template.KV{
+			"summary": "handler returned <nil> for map[foo:<bar>]",
+			"details": "x < 5 && List<String> <b>unclosed",
+		},
+		Fingerprint: "panic",
+	}}
+}
+
+func TestWebhookHTMLEscapedByDefaultTemplate(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	fallbacks := testutil.ToFloat64(b.htmlFallbacks)
+
+	w := outboxWebhook("PanicInHandler")
+	w.Message.Alerts = htmlWebhook()
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	text := tb.lastText()
+	require.NoError(t, checkTelegramHTML(text))
+	require.Contains(t, text, "<b>PanicInHandler</b>", "the template's own tags are kept")
+	require.Contains(t, text, "&lt;nil&gt;")
+	require.Equal(t, fallbacks, testutil.ToFloat64(b.htmlFallbacks))
+}
+
+func TestWebhookInvalidHTMLFallsBackToPlainText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unsafe.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}`+
+		`{{ range .Alerts }}<b>{{ .Labels.alertname }}</b>: {{ .Annotations.summary | safeHtml }}, {{ .Annotations.details | safeHtml }}{{ end }}`+
+		`{{ end }}`), 0o600))
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, path))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	fallbacks := testutil.ToFloat64(b.htmlFallbacks)
+
+	w := outboxWebhook("PanicInHandler")
+	w.Message.Alerts = htmlWebhook()
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 1, "the alert isn't dropped")
+	require.Equal(t, "PanicInHandler: handler returned &lt;nil&gt; for map[foo:&lt;bar&gt;], x &lt; 5 &amp;&amp; List&lt;String&gt; unclosed", msgs[0].text())
+	require.NoError(t, checkTelegramHTML(msgs[0].text()))
+	require.Equal(t, fallbacks+1, testutil.ToFloat64(b.htmlFallbacks))
+
+	// Without the check the message is sent as rendered, for Telegram to reject.
+	b.htmlCheck = false
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Contains(t, tb.lastText(), "<nil>")
+}
+
+func TestReplyFormattedInvalidHTML(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	_, err := b.replyFormatted(&telebot.Message{Chat: testChat}, "<b>3 alerts</b> in List<String>", &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+	require.NoError(t, err)
+	require.Equal(t, "3 alerts in List&lt;String&gt;", tb.lastText())
+}
@@ -0,0 +1,204 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandLang = "/lang"
+
+	// defaultLanguage is what the built-in responses are written in.
+	defaultLanguage = "en"
+	// languageAuto makes /lang follow the language of the user's Telegram client again.
+	languageAuto = "auto"
+
+	responseLangUsage = "Usage: " + CommandLang + " <language>|" + languageAuto + ", like " + CommandLang + " de"
+)
+
+// responseBundles are the translations of the canned replies by language code, like "de" or "pt-BR".
+// Responses a bundle doesn't translate are sent in English. "help_intro" and "help_commands" translate
+// the introduction of /help and the line above its commands, which are listed in English.
+var responseBundles = map[string]map[string]string{
+	"de": {
+		"start_private":         "Hallo{{ with .FirstName }}, {{ . }}{{ end }}! Ab jetzt halte ich dich auf dem Laufenden!\n" + CommandHelp,
+		"start_group":           "Hallo! Ab jetzt halte ich euch alle auf dem Laufenden!\n" + CommandHelp,
+		"start_failed":          "Ich kann diesen Chat nicht zu den Abonnenten hinzufügen.",
+		"stop":                  "Alles klar, {{ .FirstName }}! Ich melde mich nicht mehr.\n" + CommandHelp,
+		"stop_failed":           "Ich kann diesen Chat nicht von den Abonnenten entfernen.",
+		"no_alerts":             "Gerade gibt es keine Alerts! 🎉",
+		"reply_context_unknown": "Ich weiß nicht, um welche Alerts es in der beantworteten Nachricht ging.\nBenutze stattdessen " + CommandMute + " environment[...] project[...].",
+		"help_intro":            "Ich bin ein Prometheus-AlertManager-Bot für Telegram und benachrichtige dich über Alerts.\nDu kannst mich auch nach " + CommandStatus + ", " + CommandAlerts + " und " + CommandSilences + " fragen.",
+		"help_commands":         "Verfügbare Befehle:",
+	},
+	"ru": {
+		"start_private":         "Привет{{ with .FirstName }}, {{ . }}{{ end }}! Теперь я буду держать тебя в курсе!\n" + CommandHelp,
+		"start_group":           "Привет! Теперь я буду держать вас всех в курсе!\n" + CommandHelp,
+		"start_failed":          "Не получается добавить этот чат в список подписчиков.",
+		"stop":                  "Хорошо, {{ .FirstName }}! Больше не буду писать.\n" + CommandHelp,
+		"stop_failed":           "Не получается удалить этот чат из списка подписчиков.",
+		"no_alerts":             "Сейчас алертов нет! 🎉",
+		"reply_context_unknown": "Не знаю, о каких алертах было сообщение, на которое вы ответили.\nИспользуйте " + CommandMute + " environment[...] project[...].",
+		"help_intro":            "Я бот Prometheus AlertManager для Telegram и сообщаю об алертах.\nМожно спросить у меня " + CommandStatus + ", " + CommandAlerts + " и " + CommandSilences + ".",
+		"help_commands":         "Доступные команды:",
+	},
+}
+
+// bundledResponses are the parsed responseBundles, by language and name.
+var bundledResponses = parseResponseBundles()
+
+func parseResponseBundles() map[string]map[string]*template.Template {
+	parsed := make(map[string]map[string]*template.Template, len(responseBundles))
+	for lang, bundle := range responseBundles {
+		parsed[lang] = make(map[string]*template.Template, len(bundle))
+		for name, text := range bundle {
+			parsed[lang][name] = template.Must(template.New(lang + "/" + name).Parse(text))
+		}
+	}
+	return parsed
+}
+
+// bundleLanguage returns the bundle for a language code like de-AT: its own, the one of its language (de),
+// or "" if there is none and the responses are sent in English.
+func bundleLanguage(code string) string {
+	code = strings.Replace(strings.TrimSpace(code), "_", "-", -1)
+	for code != "" {
+		for lang := range responseBundles {
+			if strings.EqualFold(lang, code) {
+				return lang
+			}
+		}
+		i := strings.LastIndex(code, "-")
+		if i < 0 {
+			break
+		}
+		code = code[:i]
+	}
+	return ""
+}
+
+// bundleLanguages are the languages /lang can set, English first.
+func bundleLanguages() []string {
+	langs := make([]string, 0, len(responseBundles))
+	for lang := range responseBundles {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return append([]string{defaultLanguage}, langs...)
+}
+
+// SetLanguage sets the language of a chat's responses, "" for English. Explicit languages were chosen with /lang
+// and are kept when the chat subscribes again, the others were detected from the user's Telegram client.
+func (s *ChatStore) SetLanguage(id int64, lang string, explicit bool) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Language = lang
+		ci.LanguageExplicit = explicit
+		return nil
+	})
+}
+
+// chatLanguage returns the bundle of the chat's language, "" for English or if it can't be read.
+func (b *Bot) chatLanguage(chat *telebot.Chat) string {
+	if chat == nil || b.chats == nil {
+		return ""
+	}
+	ci, err := b.chats.GetChatInfo(chat.ID)
+	if err != nil {
+		return ""
+	}
+	return bundleLanguage(ci.Language)
+}
+
+// localResponseContext is responseContext in the language of the chat.
+func (b *Bot) localResponseContext(message *telebot.Message) ResponseContext {
+	ctx := responseContext(message)
+	ctx.Language = b.chatLanguage(message.Chat)
+	return ctx
+}
+
+// detectLanguage sets the language of a private chat that just subscribed to the one of the sender's Telegram client,
+// if there's a bundle for it. A language chosen with /lang before is kept, groups keep English.
+func (b *Bot) detectLanguage(message *telebot.Message, previous *ChatInfo) error {
+	if message.Chat.Type != telebot.ChatPrivate || message.Sender == nil {
+		return nil
+	}
+	if previous != nil && previous.LanguageExplicit {
+		return b.chats.SetLanguage(message.Chat.ID, previous.Language, true)
+	}
+	lang := bundleLanguage(message.Sender.LanguageCode)
+	if lang == "" {
+		return nil
+	}
+	return b.chats.SetLanguage(message.Chat.ID, lang, false)
+}
+
+// localizedHelp is the /help text in the language, with the commands listed in English.
+func localizedHelp(lang string) string {
+	bundle := responseBundles[lang]
+	intro, commands := bundle["help_intro"], bundle["help_commands"]
+	const listed = "Available commands:"
+	i := strings.Index(ResponseHelp, listed)
+	if intro == "" || commands == "" || i < 0 {
+		return ResponseHelp
+	}
+	return "\n" + intro + "\n\n" + commands + ResponseHelp[i+len(listed):]
+}
+
+func (b *Bot) handleLang(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the language of this chat")
+		return err
+	}
+
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		current := defaultLanguage
+		if lang := bundleLanguage(ci.Language); lang != "" {
+			current = lang
+		}
+		how := "the default"
+		switch {
+		case ci.LanguageExplicit:
+			how = "set with " + CommandLang
+		case ci.Language != "":
+			how = "detected from your Telegram app"
+		}
+		_, err = b.reply(message, fmt.Sprintf("This chat's language is %s (%s). Available: %s\n%s",
+			current, how, strings.Join(bundleLanguages(), ", "), responseLangUsage))
+		return err
+	}
+
+	var lang string
+	explicit := true
+	switch {
+	case strings.EqualFold(payload, languageAuto):
+		explicit = false
+		if message.Chat.Type == telebot.ChatPrivate && message.Sender != nil {
+			lang = bundleLanguage(message.Sender.LanguageCode)
+		}
+	case strings.EqualFold(payload, defaultLanguage):
+	default:
+		if lang = bundleLanguage(payload); lang == "" {
+			_, err = b.reply(message, fmt.Sprintf("There are no responses in %q, available are %s.\n%s",
+				payload, strings.Join(bundleLanguages(), ", "), responseLangUsage))
+			return err
+		}
+	}
+
+	if err := b.chats.SetLanguage(message.Chat.ID, lang, explicit); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set language", "err", err)
+		_, err = b.replyStoreError(message, err, "set the language of this chat")
+		return err
+	}
+	if lang == "" {
+		lang = defaultLanguage
+	}
+	_, err = b.reply(message, fmt.Sprintf("This chat's language is %s now.", lang))
+	return err
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestBundleLanguage(t *testing.T) {
+	for code, expected := range map[string]string{
+		"ru":    "ru",
+		"de-AT": "de",
+		"DE_ch": "de",
+		"pt-BR": "",
+		"en":    "",
+		"":      "",
+	} {
+		require.Equal(t, expected, bundleLanguage(code), code)
+	}
+}
+
+func TestLanguageDetectedAtStart(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+
+	russian := &telebot.User{ID: 123, FirstName: "Ivan", LanguageCode: "ru"}
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandStart}))
+	require.Equal(t, "Привет, Ivan! Теперь я буду держать тебя в курсе!\n"+CommandHelp, tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, "ru", ci.Language)
+	require.False(t, ci.LanguageExplicit)
+
+	require.NoError(t, b.handleHelp(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandHelp}))
+	require.Contains(t, tb.lastText(), "Доступные команды:\n"+CommandStart+" - ")
+
+	brazilian := &telebot.User{ID: 456, FirstName: "Ana", LanguageCode: "pt-BR"}
+	private := &telebot.Chat{ID: 456, Type: telebot.ChatPrivate}
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: brazilian, Chat: private, Text: CommandStart}))
+	require.Equal(t, "Hey, Ana! I will now keep you up to date!\n"+CommandHelp, tb.lastText(), "there's no bundle for pt")
+	ci, err = chats.GetChatInfo(private.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.Language)
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: russian, Chat: sharedGroup, Text: CommandStart}))
+	require.Equal(t, "Hey! I will now keep you all up to date!\n"+CommandHelp, tb.lastText(), "groups keep English")
+	require.NoError(t, b.handleHelp(&telebot.Message{Sender: russian, Chat: sharedGroup, Text: CommandHelp}))
+	require.Equal(t, ResponseHelp, tb.lastText())
+}
+
+func TestExplicitLanguageSurvivesDetection(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	russian := &telebot.User{ID: 123, FirstName: "Ivan", LanguageCode: "ru"}
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandStart}))
+
+	lang := b.middleware(b.handleLang)
+	lang(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandLang + " de-AT", Payload: "de-AT"})
+	require.Equal(t, "This chat's language is de now.", tb.lastText())
+
+	lang(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandLang + " fr", Payload: "fr"})
+	require.True(t, strings.HasPrefix(tb.lastText(), `There are no responses in "fr", available are en, de, ru.`), tb.lastText())
+
+	// Subscribing again detects ru, but the language chosen with /lang wins.
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandStart}))
+	require.Equal(t, "Hallo, Ivan! Ab jetzt halte ich dich auf dem Laufenden!\n"+CommandHelp, tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, "de", ci.Language)
+	require.True(t, ci.LanguageExplicit)
+
+	lang(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandLang})
+	require.True(t, strings.HasPrefix(tb.lastText(), "This chat's language is de (set with "+CommandLang+")."), tb.lastText())
+
+	lang(&telebot.Message{Sender: russian, Chat: testChat, Text: CommandLang + " auto", Payload: "auto"})
+	require.Equal(t, "This chat's language is ru now.", tb.lastText())
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.False(t, ci.LanguageExplicit)
+}
@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// idRow is a line of the /id response.
+type idRow struct {
+	name  string
+	value string
+}
+
+// idRows collects every ID of a message that is useful for configuring the bot:
+// the sender, the chat, where a forwarded message came from and who sent the message replied to.
+// Telegram's thread IDs of forum topics aren't available to the bot yet.
+func idRows(message *telebot.Message) []idRow {
+	var rows []idRow
+	if message.Sender != nil {
+		rows = append(rows, idRow{"Your ID", fmt.Sprintf("%d", message.Sender.ID)})
+	}
+	if message.Chat != nil && message.Chat.Type != telebot.ChatPrivate {
+		rows = append(rows, idRow{"Chat ID", fmt.Sprintf("%d", message.Chat.ID)})
+		if message.Chat.Title != "" {
+			rows = append(rows, idRow{"Chat title", message.Chat.Title})
+		}
+	}
+
+	if c := message.OriginalChat; c != nil {
+		rows = append(rows, idRow{"Forwarded from chat", fmt.Sprintf("%d", c.ID)})
+		if c.Title != "" {
+			rows = append(rows, idRow{"Forwarded chat title", c.Title})
+		}
+		if message.OriginalMessageID != 0 {
+			rows = append(rows, idRow{"Forwarded message ID", fmt.Sprintf("%d", message.OriginalMessageID)})
+		}
+	}
+	if u := message.OriginalSender; u != nil {
+		rows = append(rows, idRow{"Forwarded from user", fmt.Sprintf("%d", u.ID)})
+	}
+
+	if r := message.ReplyTo; r != nil {
+		rows = append(rows, idRow{"Replied message ID", fmt.Sprintf("%d", r.ID)})
+		if r.Sender != nil {
+			rows = append(rows, idRow{"Replied to user", fmt.Sprintf("%d", r.Sender.ID)})
+		}
+		if r.OriginalChat != nil {
+			rows = append(rows, idRow{"Replied forwarded from", fmt.Sprintf("%d", r.OriginalChat.ID)})
+		}
+	}
+	return rows
+}
+
+// formatIDRows aligns the rows as a table in a Markdown code block.
+func formatIDRows(rows []idRow) string {
+	var width int
+	for _, r := range rows {
+		if len(r.name) > width {
+			width = len(r.name)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("```\n")
+	for _, r := range rows {
+		// A backtick would end the code block.
+		value := strings.Replace(r.value, "`", "'", -1)
+		b.WriteString(fmt.Sprintf("%-*s  %s\n", width, r.name, value))
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// handleChannelPost answers /id in channels, where Telegram doesn't send commands as such.
+func (b *Bot) handleChannelPost(message *telebot.Message) {
+	if b.skipOwnMessage(message) {
+		return
+	}
+	command := strings.Split(strings.TrimSpace(message.Text), " ")[0]
+	if command != CommandID && !strings.HasPrefix(command, CommandID+"@") {
+		return
+	}
+	b.commandEvents(CommandID)
+	if err := b.handleID(message); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to handle command", "err", err)
+	}
+}
@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestHandleIDPrivate(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.NoError(t, b.handleID(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandID}))
+	require.Equal(t, "```\nYour ID  123\n```", tb.lastText())
+}
+
+func TestHandleIDForwardedFromChannel(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.NoError(t, b.handleID(&telebot.Message{
+		Sender:            testAdmin,
+		Chat:              testChat,
+		Text:              CommandID,
+		OriginalChat:      &telebot.Chat{ID: -1001111, Title: "Status `updates`", Type: telebot.ChatChannel},
+		OriginalMessageID: 42,
+	}))
+	require.Equal(t, "```\n"+
+		"Your ID               123\n"+
+		"Forwarded from chat   -1001111\n"+
+		"Forwarded chat title  Status 'updates'\n"+
+		"Forwarded message ID  42\n"+
+		"```", tb.lastText())
+}
+
+func TestHandleIDReplyInSupergroup(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	group := &telebot.Chat{ID: -1002222, Title: "oncall", Type: telebot.ChatSuperGroup}
+	require.NoError(t, b.handleID(&telebot.Message{
+		Sender:  testAdmin,
+		Chat:    group,
+		Text:    CommandID,
+		ReplyTo: &telebot.Message{ID: 7, Sender: &telebot.User{ID: 456}, Chat: group},
+	}))
+	require.Equal(t, "```\n"+
+		"Your ID             123\n"+
+		"Chat ID             -1002222\n"+
+		"Chat title          oncall\n"+
+		"Replied message ID  7\n"+
+		"Replied to user     456\n"+
+		"```", tb.lastText())
+}
+
+func TestHandleIDChannelPost(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	channel := &telebot.Chat{ID: -1003333, Title: "status", Type: telebot.ChatChannel}
+
+	b.handleChannelPost(&telebot.Message{Chat: channel, Text: "hello"})
+	require.Empty(t, tb.messages())
+
+	b.handleChannelPost(&telebot.Message{Chat: channel, Text: "/id@alertmanager_bot"})
+	require.Equal(t, "```\nChat ID     -1003333\nChat title  status\n```", tb.lastText())
+}
+
+func TestHandleIDNotAdmin(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	stranger := &telebot.User{ID: 999}
+	chat := &telebot.Chat{ID: 999, Type: telebot.ChatPrivate}
+
+	b.middleware(b.handleID)(&telebot.Message{Sender: stranger, Chat: chat, Text: "/id@alertmanager_bot"})
+	require.Equal(t, "```\nYour ID  999\n```", tb.lastText())
+
+	b.middleware(b.handleHelp)(&telebot.Message{Sender: stranger, Chat: chat, Text: CommandHelp})
+	require.Len(t, tb.messages(), 1)
+}
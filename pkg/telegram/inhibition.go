@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// inhibitionLookupTimeout bounds looking up the inhibition state of a webhook's alerts,
+// if Alertmanager is slower the alerts are delivered as they are.
+const inhibitionLookupTimeout = 2 * time.Second
+
+// WithDeliverInhibited delivers alerts Alertmanager inhibits, which are dropped by default.
+func WithDeliverInhibited(deliver bool) BotOption {
+	return func(b *Bot) error {
+		b.deliverInhibited = deliver
+		return nil
+	}
+}
+
+// withoutInhibited drops the firing alerts Alertmanager currently inhibits.
+// Webhooks don't carry the inhibition state, so it's looked up by fingerprint.
+func (b *Bot) withoutInhibited(ctx context.Context, logger log.Logger, alerts template.Alerts) template.Alerts {
+	if b.deliverInhibited || b.alertmanager == nil || len(alerts.Firing()) == 0 {
+		return alerts
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inhibitionLookupTimeout)
+	defer cancel()
+	statuses, err := b.alertmanager.AlertStatuses(ctx, ".*")
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to look up inhibited alerts, delivering all", "err", err)
+		return alerts
+	}
+
+	kept := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		if a.Status == "firing" && statuses[a.Fingerprint].Inhibited() {
+			level.Debug(logger).Log("msg", "dropping inhibited alert", "fingerprint", a.Fingerprint, "inhibited_by", strings.Join(statuses[a.Fingerprint].InhibitedBy, ","))
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// tmplInhibitedAlerts renders each alert followed by the alerts inhibiting it.
+func (b *Bot) tmplInhibitedAlerts(chatInfo *ChatInfo, alerts []alertmanager.InhibitedAlert) (string, error) {
+	var out strings.Builder
+	for _, a := range alerts {
+		rendered, err := b.tmplAlerts(chatInfo, a.Alert)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(strings.TrimRight(rendered, "\n"))
+		out.WriteString("\n")
+		out.WriteString(inhibitedByLine(a.InhibitedBy))
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// inhibitedByLine tells which alerts inhibit an alert, like:
+// 🔕 inhibited by InstanceDown, NodeUnreachable
+func inhibitedByLine(by []string) string {
+	names := make([]string, 0, len(by))
+	for _, name := range by {
+		names = append(names, fmt.Sprintf("<code>%s</code>", html.EscapeString(name)))
+	}
+	return "🔕 inhibited by " + strings.Join(names, ", ")
+}
+
+func (b *Bot) handleInhibitedAlerts(message *telebot.Message, receiver string) error {
+	alerts, err := b.alertmanager.ListInhibitedAlerts(context.TODO(), receiver)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list inhibited alerts", "err", err)
+		_, err = b.respondFailed(message, "list inhibited alerts", err)
+		return err
+	}
+
+	if len(alerts) == 0 {
+		_, err = b.reply(message, "No inhibited alerts right now.")
+		return err
+	}
+
+	chatInfo, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat", "err", err)
+	}
+
+	out, err := b.tmplInhibitedAlerts(chatInfo, alerts)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to template alerts", "err", err)
+		return nil
+	}
+
+	_, err = b.replyFormatted(message, b.truncateMessage(out), &telebot.SendOptions{
+		ParseMode: telebot.ModeHTML,
+	})
+	return err
+}
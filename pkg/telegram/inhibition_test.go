@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func inhibitionWebhook() alertmanager.TelegramWebhook {
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"alertname": "InstanceDown"}, Fingerprint: "1111111111111111"},
+			{Status: "firing", Labels: template.KV{"alertname": "HighLatency"}, Fingerprint: "2222222222222222"},
+		},
+	}}}
+}
+
+var inhibitionStatuses = map[string]alertmanager.AlertStatus{
+	"1111111111111111": {Fingerprint: "1111111111111111", State: "active"},
+	"2222222222222222": {Fingerprint: "2222222222222222", State: "suppressed", InhibitedBy: []string{"1111111111111111"}},
+}
+
+func TestWebhookDropsInhibitedAlerts(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []BotOption
+		am      *alertmanagertest.Alertmanager
+		records int
+	}{
+		{name: "inhibited dropped", am: &alertmanagertest.Alertmanager{Statuses: inhibitionStatuses}, records: 1},
+		{name: "deliver inhibited", opts: []BotOption{WithDeliverInhibited(true)}, am: &alertmanagertest.Alertmanager{Statuses: inhibitionStatuses}, records: 2},
+		{name: "lookup failed", am: &alertmanagertest.Alertmanager{Err: errors.New("connection refused")}, records: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]BotOption{
+				WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+				WithAlertmanager(tc.am),
+			}, tc.opts...)
+			b, tb, chats := newTestBot(t, opts...)
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+			d, err := b.processWebhook(context.Background(), inhibitionWebhook())
+			require.NoError(t, err)
+			require.Equal(t, 1, d.Messages)
+			require.Contains(t, tb.lastText(), "InstanceDown")
+
+			record, err := chats.GetMessage(testChat.ID, 1)
+			require.NoError(t, err)
+			require.Len(t, record.Fingerprints, tc.records)
+		})
+	}
+}
+
+func TestWebhookAllInhibited(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Statuses: map[string]alertmanager.AlertStatus{
+		"1111111111111111": {InhibitedBy: []string{"ffffffffffffffff"}},
+		"2222222222222222": {InhibitedBy: []string{"ffffffffffffffff"}},
+	}}
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	d, err := b.processWebhook(context.Background(), inhibitionWebhook())
+	require.NoError(t, err)
+	require.Equal(t, 0, d.Messages)
+	require.Empty(t, tb.messages())
+}
+
+func TestHandleAlertsInhibited(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Inhibited: []alertmanager.InhibitedAlert{{
+		Alert: &types.Alert{Alert: model.Alert{
+			Labels:   model.LabelSet{"alertname": "HighLatency"},
+			StartsAt: time.Now().Add(-time.Minute),
+		}},
+		InhibitedBy: []string{"InstanceDown", "9999999999999999"},
+	}}}
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "inhibited"}))
+	text := tb.lastText()
+	require.Contains(t, text, "HighLatency")
+	require.Contains(t, text, "🔕 inhibited by <code>InstanceDown</code>, <code>9999999999999999</code>")
+
+	am.Inhibited = nil
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "inhibited"}))
+	require.Equal(t, "No inhibited alerts right now.", tb.lastText())
+}
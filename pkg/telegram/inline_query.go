@@ -0,0 +1,191 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// inlineQueryMaxResults is the most alerts an inline query answers with.
+	inlineQueryMaxResults = 10
+	// inlineQueryTimeout is the longest an inline query waits for Alertmanager, Telegram drops slower answers anyway.
+	inlineQueryTimeout = 5 * time.Second
+	// inlineQueryCacheTime is how long Telegram may answer the same query of the same sender from its cache.
+	inlineQueryCacheTime = 10 * time.Second
+	// inlineResultMaxTitle is how long the titles of inline results get before they're cut.
+	inlineResultMaxTitle = 64
+)
+
+// inlineQuery is what an inline query like "HighCPU prod severity=critical" looks for.
+type inlineQuery struct {
+	// words each have to be part of the alertname or equal a label value, ignoring case.
+	words []string
+	// labels have to equal the alert's labels.
+	labels map[string]string
+}
+
+// parseInlineQuery parses the words and label=value pairs of an inline query. An empty query matches all alerts.
+func parseInlineQuery(text string) (inlineQuery, error) {
+	q := inlineQuery{labels: map[string]string{}}
+	for _, field := range strings.Fields(text) {
+		i := strings.Index(field, "=")
+		if i == -1 {
+			q.words = append(q.words, strings.ToLower(field))
+			continue
+		}
+		name, value := field[:i], strings.Trim(field[i+1:], `"`)
+		if name == "" || value == "" {
+			return q, fmt.Errorf("%q isn't a label=value filter", field)
+		}
+		q.labels[name] = value
+	}
+	return q, nil
+}
+
+// matches returns whether the alert has every label of the query and every word matches it.
+func (q inlineQuery) matches(a *types.Alert) bool {
+	for name, value := range q.labels {
+		if string(a.Labels[model.LabelName(name)]) != value {
+			return false
+		}
+	}
+	alertname := strings.ToLower(string(a.Labels["alertname"]))
+words:
+	for _, w := range q.words {
+		if strings.Contains(alertname, w) {
+			continue
+		}
+		for _, v := range a.Labels {
+			if strings.EqualFold(string(v), w) {
+				continue words
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// inlineResultTitle is the alertname and environment of the alert, like "HighCPU · prod".
+func inlineResultTitle(a *types.Alert) string {
+	title := string(a.Labels["alertname"])
+	if env := a.Labels[labelEnvironment]; env != "" {
+		title = title + " · " + string(env)
+	}
+	if r := []rune(title); len(r) > inlineResultMaxTitle {
+		title = string(r[:inlineResultMaxTitle-1]) + "…"
+	}
+	return title
+}
+
+// inlineResultDescription tells what else there is to know about the alert at a glance.
+func inlineResultDescription(a *types.Alert, now time.Time) string {
+	var parts []string
+	if s := a.Labels[labelSeverity]; s != "" {
+		parts = append(parts, string(s))
+	}
+	if p := a.Labels[labelProject]; p != "" {
+		parts = append(parts, string(p))
+	}
+	parts = append(parts, "firing for "+formatFiringDuration(now.Sub(a.StartsAt)))
+	return strings.Join(parts, ", ")
+}
+
+// inlineResults answers with an article per alert, the most recent alerts first, that posts the templated alert.
+func (b *Bot) inlineResults(alerts []*types.Alert, now time.Time) telebot.Results {
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].StartsAt.After(alerts[j].StartsAt) })
+	if len(alerts) > inlineQueryMaxResults {
+		alerts = alerts[:inlineQueryMaxResults]
+	}
+
+	results := make(telebot.Results, 0, len(alerts))
+	for _, a := range alerts {
+		text, err := b.tmplAlerts(nil, a)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to template alert for inline query", "err", err)
+			text = html.EscapeString(a.Labels.String())
+		}
+		result := &telebot.ArticleResult{
+			Title:       inlineResultTitle(a),
+			Description: inlineResultDescription(a, now),
+		}
+		result.SetResultID(a.Fingerprint().String())
+		result.SetContent(&telebot.InputTextMessageContent{
+			Text:           b.truncateMessage(strings.TrimSpace(text)),
+			ParseMode:      telebot.ModeHTML,
+			DisablePreview: true,
+		})
+		results = append(results, result)
+	}
+	return results
+}
+
+// inlineNotice is a single result telling why there are no alerts to choose from.
+func inlineNotice(id string, title string, text string) telebot.Results {
+	result := &telebot.ArticleResult{Title: title, Description: text}
+	result.SetResultID(id)
+	result.SetContent(&telebot.InputTextMessageContent{Text: text})
+	return telebot.Results{result}
+}
+
+// handleInlineQuery answers inline queries like "@alertbot HighCPU prod" with the matching alerts,
+// for admins to post into any chat, even those the bot isn't a member of.
+// Inline mode has to be turned on for the bot with BotFather.
+func (b *Bot) handleInlineQuery(q *telebot.Query) {
+	answer := func(results telebot.Results) {
+		err := b.telegram.Answer(q, &telebot.QueryResponse{
+			Results:    results,
+			CacheTime:  int(inlineQueryCacheTime.Seconds()),
+			IsPersonal: true,
+		})
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to answer inline query", "err", err)
+		}
+	}
+
+	if !b.isAdminID(q.From.ID) {
+		level.Info(b.logger).Log(
+			"msg", "dropping inline query from forbidden sender",
+			"sender_id", q.From.ID,
+			"sender_username", q.From.Username,
+		)
+		b.commandsCounter.WithLabelValues("dropped").Inc()
+		answer(inlineNotice("unauthorized", "Unauthorized", "Only the bot's admins can query alerts."))
+		return
+	}
+
+	query, err := parseInlineQuery(q.Text)
+	if err != nil {
+		answer(inlineNotice("invalid", "Invalid query", fmt.Sprintf("%v, search like: HighCPU prod severity=critical", err)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inlineQueryTimeout)
+	defer cancel()
+	alerts, err := b.alertmanager.ListAlerts(ctx, correlationAllReceivers, false)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list alerts for inline query", "err", err)
+		answer(inlineNotice("failed", "Failed to list alerts", fmt.Sprintf("failed to list alerts... %v", err)))
+		return
+	}
+
+	var matching []*types.Alert
+	for _, a := range alerts {
+		if query.matches(a) {
+			matching = append(matching, a)
+		}
+	}
+	if len(matching) == 0 {
+		answer(inlineNotice("none", "No alerts", "No alerts match right now! 🎉"))
+		return
+	}
+	answer(b.inlineResults(matching, time.Now()))
+}
@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestParseInlineQuery(t *testing.T) {
+	q, err := parseInlineQuery(`  HighCPU prod severity=critical team="sre" `)
+	require.NoError(t, err)
+	require.Equal(t, []string{"highcpu", "prod"}, q.words)
+	require.Equal(t, map[string]string{"severity": "critical", "team": "sre"}, q.labels)
+
+	q, err = parseInlineQuery("")
+	require.NoError(t, err)
+	require.Empty(t, q.words)
+	require.Empty(t, q.labels)
+
+	for _, text := range []string{"=prod", "severity=", `team=""`} {
+		_, err := parseInlineQuery(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestInlineQueryMatches(t *testing.T) {
+	alert := alertmanagertest.Alert("HighCPU").Environment("prod").Severity("critical").Build()
+
+	for text, want := range map[string]bool{
+		"":                         true,
+		"cpu":                      true,
+		"highcpu PROD":             true,
+		"HighCPU staging":          false,
+		"severity=critical":        true,
+		"cpu severity=warning":     false,
+		"environment=prod project": false,
+		"DiskFull":                 false,
+	} {
+		q, err := parseInlineQuery(text)
+		require.NoError(t, err)
+		require.Equal(t, want, q.matches(alert), text)
+	}
+}
+
+func TestInlineResults(t *testing.T) {
+	b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+
+	var alerts []*types.Alert
+	for i := 0; i < 12; i++ {
+		alerts = append(alerts, alertmanagertest.Alert("HighCPU").Environment("prod").Project("billing").Severity("warning").
+			Label("instance", string(rune('a'+i))).FiringFor(time.Duration(i+1)*time.Minute).Build())
+	}
+	long := alertmanagertest.Alert(strings.Repeat("VeryLongAlertname", 5)).Annotation("description", strings.Repeat("x", 5000)).FiringFor(time.Second).Build()
+	alerts = append(alerts, long)
+
+	results := b.inlineResults(alerts, time.Now())
+	require.Len(t, results, inlineQueryMaxResults)
+
+	first := results[0].(*telebot.ArticleResult)
+	require.Equal(t, long.Fingerprint().String(), first.ResultID(), "the most recent alert comes first")
+	require.Len(t, []rune(first.Title), inlineResultMaxTitle)
+	require.True(t, strings.HasSuffix(first.Title, "…"))
+	require.LessOrEqual(t, len((*first.Content).(*telebot.InputTextMessageContent).Text), telegramMessageMaxLength)
+
+	second := results[1].(*telebot.ArticleResult)
+	require.Equal(t, "HighCPU · prod", second.Title)
+	require.Equal(t, "warning, billing, firing for 1 minute", second.Description)
+	content := (*second.Content).(*telebot.InputTextMessageContent)
+	require.Equal(t, telebot.ModeHTML, content.ParseMode)
+	require.Contains(t, content.Text, "<b>HighCPU</b>")
+}
+
+func TestHandleInlineQuery(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{
+		alertmanagertest.Alert("HighCPU").Environment("prod").Build(),
+		alertmanagertest.Alert("HighCPU").Environment("staging").Build(),
+		alertmanagertest.Alert("DiskFull").Environment("prod").Build(),
+	}}
+	b, tb, _ := newTestBot(t, WithAlertmanager(am), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+
+	b.handleInlineQuery(&telebot.Query{ID: "1", From: *testAdmin, Text: "HighCPU prod"})
+	require.Len(t, tb.answers, 1)
+	require.Equal(t, "1", tb.answers[0].QueryID)
+	require.True(t, tb.answers[0].IsPersonal)
+	require.Len(t, tb.answers[0].Results, 1)
+	require.Equal(t, "HighCPU · prod", tb.answers[0].Results[0].(*telebot.ArticleResult).Title)
+
+	b.handleInlineQuery(&telebot.Query{ID: "2", From: telebot.User{ID: 999}, Text: "HighCPU"})
+	require.Len(t, tb.answers[1].Results, 1)
+	require.Equal(t, "unauthorized", tb.answers[1].Results[0].ResultID())
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListAlerts), "others can't list alerts")
+
+	b.handleInlineQuery(&telebot.Query{ID: "3", From: *testAdmin, Text: "Watchdog"})
+	require.Equal(t, "none", tb.answers[2].Results[0].ResultID())
+
+	b.handleInlineQuery(&telebot.Query{ID: "4", From: *testAdmin, Text: "severity="})
+	require.Equal(t, "invalid", tb.answers[3].Results[0].ResultID())
+
+	am.Err = errors.New("connection refused")
+	b.handleInlineQuery(&telebot.Query{ID: "5", From: *testAdmin, Text: ""})
+	require.Equal(t, "failed", tb.answers[4].Results[0].ResultID())
+}
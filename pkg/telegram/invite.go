@@ -0,0 +1,323 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandInvite = "/invite"
+
+	telegramInvitesDirectory = "telegram/invites"
+
+	defaultInviteTTL = 7 * 24 * time.Hour
+	// maxInviteUseAttempts is how often UseInvite tries to use an invite that's used by others meanwhile.
+	maxInviteUseAttempts = 5
+
+	responseInviteUsage   = "Usage: " + CommandInvite + " environment[prod] project[billing] severity[critical] uses[1], every part is optional."
+	responseInviteInvalid = "Sorry, this invite link is invalid or expired, ask an admin for a new one."
+)
+
+var (
+	// ErrInviteInvalid is returned for invites that don't exist, expired or were used up.
+	ErrInviteInvalid = errors.New("the invite is invalid or expired")
+	// ErrInviteConflict is returned by UseInvite when the invite kept being used by others meanwhile.
+	ErrInviteConflict = errors.New("the invite was used by someone else at the same time, try again")
+)
+
+// Invite lets whoever opens its deep link subscribe their private chat to the alerts it selects,
+// without being an admin.
+type Invite struct {
+	Token string
+	Setup ChatSetup
+	// Uses is how many more chats can subscribe with the invite.
+	Uses      int
+	ExpiresAt time.Time
+	CreatedBy int
+}
+
+// WithInviteTTL sets how long invites created with /invite can be used.
+func WithInviteTTL(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d <= 0 {
+			return fmt.Errorf("the invite TTL must be positive, is %s", d)
+		}
+		b.inviteTTL = d
+		return nil
+	}
+}
+
+func inviteKey(token string) string {
+	return telegramInvitesDirectory + "/" + token
+}
+
+// AddInvite stores a new invite.
+func (s *ChatStore) AddInvite(inv Invite) error {
+	value, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return s.put(inviteKey(inv.Token), value)
+}
+
+// UseInvite uses the invite once and returns it, removing it once it's used up.
+// Unknown, expired and used up invites fail with ErrInviteInvalid. Each use is written only if no one else
+// used the invite since it was read, otherwise it's tried again, up to maxInviteUseAttempts times before
+// giving up with ErrInviteConflict. An invite is thus never used more often than it allows.
+func (s *ChatStore) UseInvite(token string, now time.Time) (*Invite, error) {
+	for attempt := 0; attempt < maxInviteUseAttempts; attempt++ {
+		kv, err := s.get(inviteKey(token), ErrInviteInvalid)
+		if err != nil {
+			return nil, err
+		}
+		var inv Invite
+		if err := decode(kv.Key, kv.Value, &inv); err != nil {
+			return nil, err
+		}
+		if !now.Before(inv.ExpiresAt) || inv.Uses < 1 {
+			if err := s.delete(inviteKey(token)); err != nil {
+				return nil, err
+			}
+			return nil, ErrInviteInvalid
+		}
+
+		inv.Uses--
+		if inv.Uses == 0 {
+			err = s.atomicDelete(inviteKey(token), kv)
+		} else {
+			var value []byte
+			if value, err = json.Marshal(inv); err == nil {
+				err = s.atomicPut(inviteKey(token), value, kv)
+			}
+		}
+		if errors.Is(err, errKeyModified) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &inv, nil
+	}
+	return nil, ErrInviteConflict
+}
+
+// ReturnInvite gives back a use of an invite UseInvite returned, when subscribing with it failed.
+// An invite that was used up meanwhile is stored again with the one use.
+func (s *ChatStore) ReturnInvite(inv Invite) error {
+	for attempt := 0; attempt < maxInviteUseAttempts; attempt++ {
+		kv, err := s.get(inviteKey(inv.Token), ErrInviteInvalid)
+		if err != nil && !errors.Is(err, ErrInviteInvalid) {
+			return err
+		}
+		stored := inv
+		stored.Uses = 0
+		if kv != nil {
+			if err := decode(kv.Key, kv.Value, &stored); err != nil {
+				return err
+			}
+		}
+		stored.Uses++
+		value, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		err = s.atomicPut(inviteKey(inv.Token), value, kv)
+		if !errors.Is(err, errKeyModified) {
+			return err
+		}
+	}
+	return ErrInviteConflict
+}
+
+// PruneInvites removes the expired invites and returns how many there were.
+func (s *ChatStore) PruneInvites(now time.Time) (int, error) {
+	kvPairs, err := s.list(telegramInvitesDirectory, nil)
+	if err != nil {
+		return 0, err
+	}
+	var pruned int
+	for _, kv := range kvPairs {
+		var inv Invite
+		if err := decode(kv.Key, kv.Value, &inv); err != nil {
+			return pruned, err
+		}
+		if now.Before(inv.ExpiresAt) {
+			continue
+		}
+		if err := s.delete(inviteKey(inv.Token)); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// pruneInvites removes the expired invites, with the other cleanups.
+func (b *Bot) pruneInvites(now time.Time) {
+	pruned, err := b.chats.PruneInvites(now)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to prune expired invites", "err", err)
+		return
+	}
+	if pruned > 0 {
+		level.Debug(b.logger).Log("msg", "pruned expired invites", "count", pruned)
+	}
+}
+
+// parseInvite parses what /invite selects, everything that isn't given is all there is.
+func (b *Bot) parseInvite(payload string) (ChatSetup, int, error) {
+	setup := b.defaultSetup()
+	uses := 1
+	groups, err := parseBracketArgs(payload)
+	if err != nil {
+		return setup, 0, err
+	}
+	for _, g := range groups {
+		values := g.Values
+		if len(values) == 0 {
+			return setup, 0, fmt.Errorf("%s[] is empty", g.Keyword)
+		}
+
+		switch g.Keyword {
+		case labelEnvironment:
+			for _, v := range values {
+				if !contains(b.environmentsAndOther, v) {
+					return setup, 0, fmt.Errorf("unknown environment %q", v)
+				}
+			}
+			setup.Environments = values
+		case labelProject:
+			for _, v := range values {
+				if !contains(b.projectsAndOther, v) {
+					return setup, 0, fmt.Errorf("unknown project %q", v)
+				}
+			}
+			setup.Projects = values
+		case labelSeverity:
+			if len(values) > 1 || !b.severityOrdering.known(values[0]) {
+				return setup, 0, fmt.Errorf("severity must be %s", b.severityOrdering.describe())
+			}
+			setup.MinSeverity = values[0]
+		case "uses":
+			n, err := strconv.Atoi(values[0])
+			if err != nil || n < 1 {
+				return setup, 0, fmt.Errorf("uses must be a positive number")
+			}
+			uses = n
+		default:
+			return setup, 0, fmt.Errorf("unknown %s[]", g.Keyword)
+		}
+	}
+	return setup, uses, nil
+}
+
+// newInviteToken returns a random token for a deep link, which allows up to 64 letters, digits, _ and -.
+func newInviteToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// inviteStart returns whether the message is a /start with an invite token in a private chat,
+// which users who aren't admins may send.
+func inviteStart(m *telebot.Message) bool {
+	return m.Chat != nil && m.Chat.Type == telebot.ChatPrivate && commandName(m) == CommandStart && strings.TrimSpace(m.Payload) != "" &&
+		!strings.HasPrefix(strings.TrimSpace(m.Payload), "for ")
+}
+
+func (b *Bot) handleInvite(message *telebot.Message) error {
+	setup, uses, err := b.parseInvite(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, b.responseText("invite_usage", b.localResponseContext(message))))
+		return err
+	}
+	token, err := newInviteToken()
+	if err != nil {
+		_, err = b.respondFailed(message, "create the invite", err)
+		return err
+	}
+
+	inv := Invite{Token: token, Setup: setup, Uses: uses, ExpiresAt: time.Now().Add(b.inviteTTL), CreatedBy: message.Sender.ID}
+	if err := b.chats.AddInvite(inv); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to store invite", "err", err)
+		_, err = b.replyStoreError(message, err, "store the invite")
+		return err
+	}
+
+	link := CommandStart + " " + token
+	if me := b.telegram.Me(); me != nil && me.Username != "" {
+		link = fmt.Sprintf("https://t.me/%s?start=%s", me.Username, token)
+	}
+	_, err = b.reply(message, fmt.Sprintf("%s\n\nWhoever opens it gets alerts in their private chat for\nEnvironments: %s\nProjects: %s\nMinimum severity: %s\n\nIt can be used %d times until %s.",
+		link, strings.Join(setup.Environments, ", "), strings.Join(setup.Projects, ", "), orAll(setup.MinSeverity), uses,
+		inv.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC")))
+	return err
+}
+
+func orAll(s string) string {
+	if s == "" {
+		return "all"
+	}
+	return s
+}
+
+// startInvited subscribes the private chat with the settings of the invite in the /start payload.
+// It returns false if the invite can't be used, after telling why.
+func (b *Bot) startInvited(message *telebot.Message) (bool, error) {
+	inv, err := b.chats.UseInvite(strings.TrimSpace(message.Payload), time.Now())
+	if err != nil {
+		if !errors.Is(err, ErrInviteInvalid) {
+			level.Warn(b.logger).Log("msg", "failed to use invite", "err", err)
+			_, err = b.replyStoreError(message, err, "check the invite")
+			return false, err
+		}
+		_, err = b.respond(message, "invite_invalid", responseContext(message))
+		return false, err
+	}
+
+	if err := b.subscribeInvited(message.Chat, inv.Setup); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to subscribe with an invite", "err", err)
+		if err := b.chats.ReturnInvite(*inv); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to give back the invite's use", "err", err)
+		}
+		_, err = b.replyStoreError(message, err, "subscribe this chat")
+		return true, err
+	}
+	level.Info(b.logger).Log(
+		"msg", "user subscribed with an invite",
+		"username", message.Sender.Username,
+		"user_id", message.Sender.ID,
+		"chat_id", message.Chat.ID,
+		"invited_by", inv.CreatedBy,
+	)
+	_, err = b.reply(message, setupSummary(inv.Setup))
+	return true, err
+}
+
+// subscribeInvited subscribes the chat with the invite's settings. A chat that's already subscribed only
+// gets them applied, everything else about it and its timezone are kept.
+func (b *Bot) subscribeInvited(chat *telebot.Chat, setup ChatSetup) error {
+	ci, err := b.chats.GetChatInfo(chat.ID)
+	switch {
+	case errors.Is(err, ErrChatNotFound):
+		if err := b.chats.AddChat(chat, b.environmentsAndOther, b.projectsAndOther); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case ci.Timezone != "":
+		setup.Timezone = ci.Timezone
+	}
+	return b.chats.ApplySetup(chat.ID, setup, b.environmentsAndOther, b.projectsAndOther)
+}
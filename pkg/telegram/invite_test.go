@@ -0,0 +1,216 @@
+package telegram
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var (
+	invitedUser = &telebot.User{ID: 321, Username: "tyrell", FirstName: "Tyrell"}
+	invitedChat = &telebot.Chat{ID: 321, Username: "tyrell", Type: telebot.ChatPrivate}
+	inviteLink  = regexp.MustCompile(`https://t\.me/alertmanager_bot\?start=([0-9a-f]{32})`)
+)
+
+// invite runs /invite with the payload and returns the token of the link it replied with.
+func invite(t *testing.T, b *Bot, tb *fakeTelebot, payload string) string {
+	t.Helper()
+	require.NoError(t, b.handleInvite(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: payload}))
+	match := inviteLink.FindStringSubmatch(tb.lastText())
+	require.NotNil(t, match, tb.lastText())
+	return match[1]
+}
+
+// startWith sends /start with the token as a private chat of the invited user, through the middleware.
+func startWith(b *Bot, tb *fakeTelebot, token string) {
+	b.middleware(b.handleStart)(&telebot.Message{Sender: invitedUser, Chat: invitedChat, Text: CommandStart + " " + token, Payload: token})
+}
+
+func TestInvite(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	tb.me = testBotUser
+
+	token := invite(t, b, tb, "environment[prod] project[billing] severity[critical]")
+	require.Contains(t, tb.lastText(), "Environments: prod\nProjects: billing\nMinimum severity: critical")
+	require.Contains(t, tb.lastText(), "It can be used 1 times until")
+
+	startWith(b, tb, token)
+	ci, err := chats.GetChatInfo(invitedChat.ID)
+	require.NoError(t, err, "the invited user's chat is subscribed without them being an admin")
+	require.Equal(t, []string{"prod"}, ci.AlertEnvironments)
+	require.Equal(t, []string{"billing"}, ci.AlertProjects)
+	require.Equal(t, "critical", ci.MinSeverity)
+	require.Contains(t, tb.lastText(), "Setup done")
+
+	require.NoError(t, chats.RemoveChat(invitedChat))
+	startWith(b, tb, token)
+	require.Equal(t, responseInviteInvalid, tb.lastText(), "invites are single-use by default")
+	require.False(t, subscribed(t, chats, invitedChat.ID))
+
+	b.middleware(b.handleStart)(&telebot.Message{Sender: invitedUser, Chat: invitedChat, Text: CommandStart})
+	require.False(t, subscribed(t, chats, invitedChat.ID), "without an invite only admins can subscribe")
+}
+
+func TestInviteLimitedUses(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	tb.me = testBotUser
+	token := invite(t, b, tb, "uses[2]")
+
+	for i := 0; i < 2; i++ {
+		startWith(b, tb, token)
+		require.True(t, subscribed(t, chats, invitedChat.ID))
+		require.NoError(t, chats.RemoveChat(invitedChat))
+	}
+	startWith(b, tb, token)
+	require.Equal(t, responseInviteInvalid, tb.lastText())
+}
+
+func TestInviteExpiry(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithInviteTTL(time.Hour))
+	tb.me = testBotUser
+	now := time.Now()
+	require.NoError(t, chats.AddInvite(Invite{Token: "expired", Uses: 1, ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, chats.AddInvite(Invite{Token: "valid", Uses: 1, ExpiresAt: now.Add(time.Minute)}))
+
+	_, err := chats.UseInvite("expired", now)
+	require.ErrorIs(t, err, ErrInviteInvalid)
+	_, err = chats.UseInvite("unknown", now)
+	require.ErrorIs(t, err, ErrInviteInvalid)
+
+	pruned, err := chats.PruneInvites(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned, "the expired invite was removed when it was used")
+	_, err = chats.UseInvite("valid", now)
+	require.ErrorIs(t, err, ErrInviteInvalid)
+
+	// Admins fall back to the plain /start.
+	b.middleware(b.handleStart)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStart + " expired", Payload: "expired"})
+	require.True(t, subscribed(t, chats, testChat.ID))
+}
+
+func TestInviteForSubscribedChat(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	tb.me = testBotUser
+	require.NoError(t, chats.AddChat(invitedChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetLanguage(invitedChat.ID, "de", true))
+	require.NoError(t, chats.SetFallback(invitedChat, oncall.ID))
+	require.NoError(t, chats.UpdateChat(invitedChat.ID, func(ci *ChatInfo) error {
+		ci.Timezone = "Europe/Berlin"
+		return nil
+	}))
+
+	startWith(b, tb, invite(t, b, tb, "environment[prod] severity[critical]"))
+	ci, err := chats.GetChatInfo(invitedChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod"}, ci.AlertEnvironments)
+	require.Equal(t, "critical", ci.MinSeverity)
+	require.Equal(t, "de", ci.Language, "the invite's settings are applied to the subscribed chat, nothing else changes")
+	require.Equal(t, oncall.ID, ci.FallbackChatID)
+	require.Equal(t, "Europe/Berlin", ci.Timezone)
+}
+
+// chatWriteFailingKV is a memoryKV whose writes of a chat fail.
+type chatWriteFailingKV struct {
+	*memoryKV
+	chatID int64
+}
+
+func (k *chatWriteFailingKV) Put(key string, value []byte, opts *store.WriteOptions) error {
+	if key == chatKey(k.chatID) {
+		return errors.New("Unexpected response code: 503")
+	}
+	return k.memoryKV.Put(key, value, opts)
+}
+
+func TestInviteGivenBackWhenSubscribingFails(t *testing.T) {
+	kv := &chatWriteFailingKV{memoryKV: newMemoryKV(), chatID: invitedChat.ID}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{me: testBotUser}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithEnvironments("prod,staging"), WithProjects("billing,frontend"))
+	require.NoError(t, err)
+	token := invite(t, b, tb, "")
+
+	startWith(b, tb, token)
+	require.False(t, subscribed(t, chats, invitedChat.ID))
+	require.Equal(t, "I can't reach my store right now, try again in a bit.", tb.lastText())
+
+	kv.chatID = 0
+	startWith(b, tb, token)
+	require.True(t, subscribed(t, chats, invitedChat.ID), "the use of the invite was given back when subscribing failed")
+}
+
+// barrierKV holds the first reads of a key until all of them came in, like users opening an invite at the same time.
+type barrierKV struct {
+	*memoryKV
+	mu      sync.Mutex
+	waiting int
+	read    sync.WaitGroup
+}
+
+func (k *barrierKV) Get(key string) (*store.KVPair, error) {
+	kv, err := k.memoryKV.Get(key)
+	k.mu.Lock()
+	wait := k.waiting > 0
+	if wait {
+		k.waiting--
+		k.read.Done()
+	}
+	k.mu.Unlock()
+	if wait {
+		k.read.Wait()
+	}
+	return kv, err
+}
+
+func TestInviteConcurrentUses(t *testing.T) {
+	const users = 2
+	kv := &barrierKV{memoryKV: newMemoryKV(), waiting: users}
+	kv.read.Add(users)
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, chats.AddInvite(Invite{Token: "single", Uses: 1, ExpiresAt: now.Add(time.Hour)}))
+
+	results := make(chan error, users)
+	for i := 0; i < users; i++ {
+		go func() {
+			_, err := chats.UseInvite("single", now)
+			results <- err
+		}()
+	}
+	var used int
+	for i := 0; i < users; i++ {
+		if err := <-results; err != nil {
+			require.ErrorIs(t, err, ErrInviteInvalid)
+			continue
+		}
+		used++
+	}
+	require.Equal(t, 1, used, "a single-use invite is used once, even by users opening it at the same time")
+}
+
+func TestParseInvite(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	for payload, want := range map[string]string{
+		"environment[dev]":     `unknown environment "dev"`,
+		"severity[urgent]":     "severity must be one of info, warning, critical",
+		"uses[0]":              "uses must be a positive number",
+		"project[]":            "project[] is empty",
+		"team[payments]":       "unknown team[]",
+		"environment[staging]": "",
+	} {
+		_, _, err := b.parseInvite(payload)
+		if want == "" {
+			require.NoError(t, err, payload)
+			continue
+		}
+		require.EqualError(t, err, want, payload)
+	}
+}
@@ -0,0 +1,228 @@
+package telegram
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandIssueButtons = "/issue_buttons"
+
+	IssueTrackerGitLab = "gitlab"
+	IssueTrackerJira   = "jira"
+
+	// issueURLMaxLength keeps prefilled issue links below what browsers and the trackers accept in a query.
+	issueURLMaxLength = 2000
+	// issueButtonsMax is the number of alerts in a message that get their own button.
+	issueButtonsMax = 5
+)
+
+// Issue is the content of a prefilled issue.
+type Issue struct {
+	Summary     string
+	Description string
+	Labels      []string
+}
+
+// IssueTracker builds links that open a tracker's new issue form prefilled with an Issue.
+type IssueTracker interface {
+	NewIssueURL(Issue) string
+}
+
+// NewIssueTracker returns the IssueTracker of the given kind.
+func NewIssueTracker(kind string, baseURL string, projectKey string) (IssueTracker, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issue tracker URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("issue tracker URL %q needs a scheme and host", baseURL)
+	}
+	if projectKey == "" {
+		return nil, fmt.Errorf("issue tracker project is empty")
+	}
+
+	switch strings.ToLower(kind) {
+	case IssueTrackerGitLab:
+		return &gitLabTracker{base: u, project: projectKey}, nil
+	case IssueTrackerJira:
+		return &jiraTracker{base: u, project: projectKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown issue tracker %q, supported are %s and %s", kind, IssueTrackerGitLab, IssueTrackerJira)
+	}
+}
+
+type gitLabTracker struct {
+	base    *url.URL
+	project string
+}
+
+// NewIssueURL returns a link like https://gitlab.example.com/group/project/-/issues/new?issue[title]=...
+// GitLab has no query parameter for labels, so they are added as quick actions to the description.
+func (t *gitLabTracker) NewIssueURL(issue Issue) string {
+	u := *t.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.Trim(t.project, "/") + "/-/issues/new"
+
+	var quickActions string
+	for _, l := range issue.Labels {
+		quickActions = quickActions + fmt.Sprintf("\n/label ~\"%s\"", l)
+	}
+
+	return fitIssueURL(issue.Description, func(description string) string {
+		q := url.Values{}
+		q.Set("issue[title]", issue.Summary)
+		q.Set("issue[description]", description+quickActions)
+		u.RawQuery = q.Encode()
+		return u.String()
+	})
+}
+
+type jiraTracker struct {
+	base    *url.URL
+	project string
+}
+
+// NewIssueURL returns a link like https://jira.example.com/secure/CreateIssueDetails!init.jspa?pid=10000&...
+// Jira needs the numeric project ID as project.
+func (t *jiraTracker) NewIssueURL(issue Issue) string {
+	u := *t.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/secure/CreateIssueDetails!init.jspa"
+
+	return fitIssueURL(issue.Description, func(description string) string {
+		q := url.Values{}
+		q.Set("pid", t.project)
+		q.Set("issuetype", "1")
+		q.Set("summary", issue.Summary)
+		q.Set("description", description)
+		for _, l := range issue.Labels {
+			q.Add("labels", l)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	})
+}
+
+// fitIssueURL builds the URL with the description shortened until it fits issueURLMaxLength.
+// The description is cut on runes, so the encoding stays valid.
+func fitIssueURL(description string, build func(description string) string) string {
+	link := build(description)
+	if len(link) <= issueURLMaxLength {
+		return link
+	}
+
+	runes := []rune(description)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if len(build(string(runes[:mid])+"…")) <= issueURLMaxLength {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return build(string(runes[:lo]) + "…")
+}
+
+// issueFromAlert creates the issue content for an alert:
+// summary is the alertname and environment, the description its annotations, and the severity a label.
+func issueFromAlert(a template.Alert) Issue {
+	summary := a.Labels["alertname"]
+	if env := a.Labels[labelEnvironment]; env != "" {
+		summary = fmt.Sprintf("%s (%s)", summary, env)
+	}
+
+	keys := make([]string, 0, len(a.Annotations))
+	for k := range a.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var description string
+	for _, k := range keys {
+		description = description + fmt.Sprintf("%s: %s\n", k, a.Annotations[k])
+	}
+	if a.GeneratorURL != "" {
+		description = description + fmt.Sprintf("source: %s\n", a.GeneratorURL)
+	}
+
+	var labels []string
+	if severity := a.Labels["severity"]; severity != "" {
+		labels = append(labels, severity)
+	}
+
+	return Issue{Summary: summary, Description: strings.TrimSpace(description), Labels: labels}
+}
+
+// issueButtons returns a "Create issue" button for each of the first alerts of a message.
+func issueButtons(tracker IssueTracker, alerts template.Alerts) *telebot.ReplyMarkup {
+	if tracker == nil || len(alerts) == 0 {
+		return nil
+	}
+
+	var rows [][]telebot.InlineButton
+	for i, a := range alerts {
+		if i == issueButtonsMax {
+			break
+		}
+		text := "Create issue"
+		if len(alerts) > 1 {
+			text = fmt.Sprintf("Create issue: %s", a.Labels["alertname"])
+		}
+		rows = append(rows, []telebot.InlineButton{{Text: text, URL: tracker.NewIssueURL(issueFromAlert(a))}})
+	}
+	return &telebot.ReplyMarkup{InlineKeyboard: rows}
+}
+
+// WithIssueTracker attaches "Create issue" buttons linking to a prefilled GitLab or Jira issue to alert notifications.
+// For GitLab projectKey is the project path, like group/project, for Jira the numeric project ID.
+func WithIssueTracker(kind string, baseURL string, projectKey string) BotOption {
+	return func(b *Bot) error {
+		tracker, err := NewIssueTracker(kind, baseURL, projectKey)
+		if err != nil {
+			return err
+		}
+		b.issueTracker = tracker
+		b.config.IssueTracker = fmt.Sprintf("%s %s (%s)", strings.ToLower(kind), maskURL(baseURL), projectKey)
+		return nil
+	}
+}
+
+// SetIssueButtons opts a chat in or out of issue buttons on its alert notifications.
+func (s *ChatStore) SetIssueButtons(c *telebot.Chat, enabled bool) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.IssueButtonsOff = !enabled
+		return nil
+	})
+}
+
+func (b *Bot) handleIssueButtons(message *telebot.Message) error {
+	var enabled bool
+	switch strings.TrimSpace(message.Payload) {
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		_, err := b.reply(message, "Usage: "+CommandIssueButtons+" on|off")
+		return err
+	}
+
+	if err := b.chats.SetIssueButtons(message.Chat, enabled); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set issue buttons", "err", err)
+		_, err = b.replyStoreError(message, err, "set issue buttons")
+		return err
+	}
+
+	if enabled {
+		_, err := b.reply(message, "Alert notifications in this chat will have issue buttons.")
+		return err
+	}
+	_, err := b.reply(message, "Alert notifications in this chat won't have issue buttons anymore.")
+	return err
+}
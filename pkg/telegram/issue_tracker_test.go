@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var issueAlert = template.Alert{
+	Status:       "firing",
+	Labels:       template.KV{"alertname": "HighCPU", labelEnvironment: "prod", "severity": "critical"},
+	Annotations:  template.KV{"summary": "CPU at 99% & rising", "runbook": "https://example.com/runbook?a=b"},
+	GeneratorURL: "http://prometheus:9090/graph",
+}
+
+func TestGitLabIssueURL(t *testing.T) {
+	tracker, err := NewIssueTracker("gitlab", "https://gitlab.example.com/", "ops/alerts")
+	require.NoError(t, err)
+
+	u, err := url.Parse(tracker.NewIssueURL(issueFromAlert(issueAlert)))
+	require.NoError(t, err)
+	require.Equal(t, "gitlab.example.com", u.Host)
+	require.Equal(t, "/ops/alerts/-/issues/new", u.Path)
+	require.Equal(t, "HighCPU (prod)", u.Query().Get("issue[title]"))
+	require.Equal(t,
+		"runbook: https://example.com/runbook?a=b\nsummary: CPU at 99% & rising\nsource: http://prometheus:9090/graph\n/label ~\"critical\"",
+		u.Query().Get("issue[description]"),
+	)
+}
+
+func TestJiraIssueURL(t *testing.T) {
+	tracker, err := NewIssueTracker("jira", "https://jira.example.com", "10000")
+	require.NoError(t, err)
+
+	u, err := url.Parse(tracker.NewIssueURL(issueFromAlert(issueAlert)))
+	require.NoError(t, err)
+	require.Equal(t, "/secure/CreateIssueDetails!init.jspa", u.Path)
+	require.Equal(t, "10000", u.Query().Get("pid"))
+	require.Equal(t, "HighCPU (prod)", u.Query().Get("summary"))
+	require.Equal(t, []string{"critical"}, u.Query()["labels"])
+	require.Contains(t, u.Query().Get("description"), "summary: CPU at 99% & rising")
+}
+
+func TestIssueURLTruncated(t *testing.T) {
+	for _, kind := range []string{IssueTrackerGitLab, IssueTrackerJira} {
+		t.Run(kind, func(t *testing.T) {
+			tracker, err := NewIssueTracker(kind, "https://tracker.example.com", "1")
+			require.NoError(t, err)
+
+			link := tracker.NewIssueURL(Issue{
+				Summary:     "HighCPU",
+				Description: strings.Repeat("häßlich & <lang> ", 500),
+				Labels:      []string{"critical"},
+			})
+			require.LessOrEqual(t, len(link), issueURLMaxLength)
+
+			u, err := url.Parse(link)
+			require.NoError(t, err)
+			description := u.Query().Get("description")
+			if kind == IssueTrackerGitLab {
+				description = u.Query().Get("issue[description]")
+				require.True(t, strings.HasSuffix(description, "…\n/label ~\"critical\""))
+			} else {
+				require.True(t, strings.HasSuffix(description, "…"))
+			}
+			require.True(t, utf8.ValidString(description))
+			require.True(t, strings.HasPrefix(description, "häßlich & <lang>"))
+		})
+	}
+}
+
+func TestNewIssueTrackerInvalid(t *testing.T) {
+	_, err := NewIssueTracker("redmine", "https://redmine.example.com", "1")
+	require.Error(t, err)
+	_, err = NewIssueTracker("gitlab", "gitlab.example.com", "ops/alerts")
+	require.Error(t, err)
+	_, err = NewIssueTracker("jira", "https://jira.example.com", "")
+	require.Error(t, err)
+}
+
+func TestIssueButtons(t *testing.T) {
+	tracker, err := NewIssueTracker("gitlab", "https://gitlab.example.com", "ops/alerts")
+	require.NoError(t, err)
+
+	require.Nil(t, issueButtons(nil, template.Alerts{issueAlert}))
+
+	markup := issueButtons(tracker, template.Alerts{issueAlert})
+	require.Len(t, markup.InlineKeyboard, 1)
+	require.Equal(t, "Create issue", markup.InlineKeyboard[0][0].Text)
+
+	alerts := make(template.Alerts, 8)
+	for i := range alerts {
+		alerts[i] = issueAlert
+	}
+	markup = issueButtons(tracker, alerts)
+	require.Len(t, markup.InlineKeyboard, issueButtonsMax)
+	require.Equal(t, "Create issue: HighCPU", markup.InlineKeyboard[0][0].Text)
+}
+
+func TestWebhookIssueButtonsOptOut(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithIssueTracker("gitlab", "https://gitlab.example.com", "ops/alerts"),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{issueAlert},
+	}}}
+
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	opts := tb.messages()[0].options[0].(*telebot.SendOptions)
+	require.NotNil(t, opts.ReplyMarkup)
+
+	require.NoError(t, b.handleIssueButtons(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	opts = tb.messages()[2].options[0].(*telebot.SendOptions)
+	require.Nil(t, opts.ReplyMarkup)
+}
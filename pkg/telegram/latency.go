@@ -0,0 +1,626 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/prometheus/alertmanager/types"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	latencyWindowLength = 10 * time.Minute
+	latencyWindowSlots  = 10
+)
+
+// latencyBuckets are the upper bounds of the histogram buckets, doubling from 1ms to about 65s.
+// Slower observations land in one more bucket above the last bound.
+var latencyBuckets = func() []time.Duration {
+	bounds := make([]time.Duration, 17)
+	for i := range bounds {
+		bounds[i] = time.Millisecond << uint(i)
+	}
+	return bounds
+}()
+
+// latencyWindow estimates percentiles of the latencies observed during the last window.
+// It keeps a histogram per slot of the window, so observations expire a slot at a time.
+type latencyWindow struct {
+	window time.Duration
+	width  time.Duration
+
+	mu    sync.Mutex
+	slots []latencySlot
+}
+
+type latencySlot struct {
+	start  time.Time
+	counts []uint64
+}
+
+func newLatencyWindow(window time.Duration, slots int) *latencyWindow {
+	w := &latencyWindow{window: window, width: window / time.Duration(slots), slots: make([]latencySlot, slots)}
+	for i := range w.slots {
+		w.slots[i].counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	return w
+}
+
+func latencyBucket(d time.Duration) int {
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+func (w *latencyWindow) observe(d time.Duration, now time.Time) {
+	start := now.Truncate(w.width)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	slot := &w.slots[int(start.UnixNano()/int64(w.width))%len(w.slots)]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		for i := range slot.counts {
+			slot.counts[i] = 0
+		}
+	}
+	slot.counts[latencyBucket(d)]++
+}
+
+// since observes the time passed since start.
+func (w *latencyWindow) since(start time.Time) {
+	now := time.Now()
+	w.observe(now.Sub(start), now)
+}
+
+// quantile estimates the q-quantile of the window's observations, interpolating linearly
+// within the bucket it falls into. It returns false if nothing was observed.
+func (w *latencyWindow) quantile(q float64, now time.Time) (time.Duration, bool) {
+	w.mu.Lock()
+	counts := make([]uint64, len(latencyBuckets)+1)
+	var total uint64
+	for _, slot := range w.slots {
+		if !slot.start.After(now.Add(-w.window)) {
+			continue
+		}
+		for i, c := range slot.counts {
+			counts[i] += c
+			total += c
+		}
+	}
+	w.mu.Unlock()
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := math.Max(1, math.Ceil(q*float64(total)))
+	var below float64
+	for i, c := range counts {
+		if c == 0 || below+float64(c) < rank {
+			below += float64(c)
+			continue
+		}
+		if i == len(latencyBuckets) {
+			return latencyBuckets[len(latencyBuckets)-1], true
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		upper := latencyBuckets[i]
+		return lower + time.Duration((rank-below)/float64(c)*float64(upper-lower)), true
+	}
+	return latencyBuckets[len(latencyBuckets)-1], true
+}
+
+// latencyStats are the latencies /status reports.
+type latencyStats struct {
+	storeReads    *latencyWindow
+	storeWrites   *latencyWindow
+	listAlerts    *latencyWindow
+	telegramSends *latencyWindow
+}
+
+func newLatencyStats() *latencyStats {
+	return &latencyStats{
+		storeReads:    newLatencyWindow(latencyWindowLength, latencyWindowSlots),
+		storeWrites:   newLatencyWindow(latencyWindowLength, latencyWindowSlots),
+		listAlerts:    newLatencyWindow(latencyWindowLength, latencyWindowSlots),
+		telegramSends: newLatencyWindow(latencyWindowLength, latencyWindowSlots),
+	}
+}
+
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// status is the latency section of /status.
+func (s *latencyStats) status(now time.Time) string {
+	out := fmt.Sprintf("*Latency (p50/p95, last %s)*", formatExtension(latencyWindowLength))
+	for _, l := range []struct {
+		name   string
+		window *latencyWindow
+	}{
+		{"Store reads", s.storeReads},
+		{"Store writes", s.storeWrites},
+		{"ListAlerts", s.listAlerts},
+		{"Telegram sends", s.telegramSends},
+	} {
+		p50, ok := l.window.quantile(0.5, now)
+		if !ok {
+			out += fmt.Sprintf("\n%s: none", l.name)
+			continue
+		}
+		p95, _ := l.window.quantile(0.95, now)
+		out += fmt.Sprintf("\n%s: %s / %s", l.name, formatLatency(p50), formatLatency(p95))
+	}
+	return out
+}
+
+// timedChatStore times the reads and writes of the BotChatStore it wraps.
+type timedChatStore struct {
+	BotChatStore
+	reads  *latencyWindow
+	writes *latencyWindow
+}
+
+func (s timedChatStore) List() ([]ChatInfo, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.List()
+}
+
+func (s timedChatStore) Get(id telebot.ChatID) (*telebot.Chat, error, *store.KVPair) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.Get(id)
+}
+
+func (s timedChatStore) AddChat(c *telebot.Chat, allEnvs []string, allPrs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.AddChat(c, allEnvs, allPrs)
+}
+
+func (s timedChatStore) RemoveChat(c *telebot.Chat) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RemoveChat(c)
+}
+
+func (s timedChatStore) MuteEnvironments(c *telebot.Chat, envs []string, allEnvs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.MuteEnvironments(c, envs, allEnvs)
+}
+
+func (s timedChatStore) MuteProjects(c *telebot.Chat, prs []string, allPrs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.MuteProjects(c, prs, allPrs)
+}
+
+func (s timedChatStore) UnmuteEnvironment(c *telebot.Chat, env string, allEnvs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.UnmuteEnvironment(c, env, allEnvs)
+}
+
+func (s timedChatStore) UnmuteProject(c *telebot.Chat, pr string, allPrs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.UnmuteProject(c, pr, allPrs)
+}
+
+func (s timedChatStore) MutedEnvironments(c *telebot.Chat) ([]string, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.MutedEnvironments(c)
+}
+
+func (s timedChatStore) MutedProjects(c *telebot.Chat) ([]string, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.MutedProjects(c)
+}
+
+func (s timedChatStore) AddMessage(r MessageRecord) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.AddMessage(r)
+}
+
+func (s timedChatStore) GetMessage(chatID int64, messageID int) (*MessageRecord, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetMessage(chatID, messageID)
+}
+
+func (s timedChatStore) MigrateChat(from int64, to int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.MigrateChat(from, to)
+}
+
+func (s timedChatStore) RecordDelivery(chatID int64, delivered bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RecordDelivery(chatID, delivered)
+}
+
+func (s timedChatStore) GetChatInfo(id int64) (*ChatInfo, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetChatInfo(id)
+}
+
+func (s timedChatStore) SetIssueButtons(c *telebot.Chat, on bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetIssueButtons(c, on)
+}
+
+func (s timedChatStore) SetExternalURL(c *telebot.Chat, url string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetExternalURL(c, url)
+}
+
+func (s timedChatStore) ListMessages() ([]MessageRecord, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.ListMessages()
+}
+
+func (s timedChatStore) RemoveMessage(chatID int64, messageID int) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RemoveMessage(chatID, messageID)
+}
+
+func (s timedChatStore) PruneMessages(max int) (int, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.PruneMessages(max)
+}
+
+func (s timedChatStore) SetRedactStrict(c *telebot.Chat, strict bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetRedactStrict(c, strict)
+}
+
+func (s timedChatStore) SetMaxAlerts(c *telebot.Chat, max int) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetMaxAlerts(c, max)
+}
+
+func (s timedChatStore) SetFallback(c *telebot.Chat, fallbackID int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetFallback(c, fallbackID)
+}
+
+func (s timedChatStore) SetArchiveChat(c *telebot.Chat, archiveID int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetArchiveChat(c, archiveID)
+}
+
+func (s timedChatStore) SetSendResolved(c *telebot.Chat, enabled bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetSendResolved(c, enabled)
+}
+
+func (s timedChatStore) SetParseMode(c *telebot.Chat, mode string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetParseMode(c, mode)
+}
+
+func (s timedChatStore) SetProtected(c *telebot.Chat, protected bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetProtected(c, protected)
+}
+
+func (s timedChatStore) AddOutbox(e OutboxEntry) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.AddOutbox(e)
+}
+
+func (s timedChatStore) RemoveOutbox(chatID int64, seq int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RemoveOutbox(chatID, seq)
+}
+
+func (s timedChatStore) ListOutbox() ([]OutboxEntry, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.ListOutbox()
+}
+
+func (s timedChatStore) SetEscalationContact(c *telebot.Chat, contact string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetEscalationContact(c, contact)
+}
+
+func (s timedChatStore) SetEscalation(c *telebot.Chat, thresholds []time.Duration, off bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetEscalation(c, thresholds, off)
+}
+
+func (s timedChatStore) SetEscalated(id int64, escalated map[string]time.Duration) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetEscalated(id, escalated)
+}
+
+func (s timedChatStore) SetTags(c *telebot.Chat, tags []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetTags(c, tags)
+}
+
+func (s timedChatStore) AddInvite(inv Invite) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.AddInvite(inv)
+}
+
+func (s timedChatStore) UseInvite(token string, now time.Time) (*Invite, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.UseInvite(token, now)
+}
+
+func (s timedChatStore) ReturnInvite(inv Invite) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ReturnInvite(inv)
+}
+
+func (s timedChatStore) PruneInvites(now time.Time) (int, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.PruneInvites(now)
+}
+
+func (s timedChatStore) RecordBlockedSend(chatID int64, at time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RecordBlockedSend(chatID, at)
+}
+
+func (s timedChatStore) ClearBlockedSends(chatID int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ClearBlockedSends(chatID)
+}
+
+func (s timedChatStore) SetExpiry(c *telebot.Chat, at time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetExpiry(c, at)
+}
+
+func (s timedChatStore) SetExpiryWarned(id int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetExpiryWarned(id)
+}
+
+func (s timedChatStore) PauseChat(id int64, since time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.PauseChat(id, since)
+}
+
+func (s timedChatStore) KeyCounts() (map[string]int, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.KeyCounts()
+}
+
+func (s timedChatStore) SetLanguage(id int64, lang string, explicit bool) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetLanguage(id, lang, explicit)
+}
+
+func (s timedChatStore) ClaimReceiver(id int64, receiver string) ([]*telebot.Chat, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ClaimReceiver(id, receiver)
+}
+
+func (s timedChatStore) SetReceiver(id int64, receiver string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetReceiver(id, receiver)
+}
+
+func (s timedChatStore) SetEcho(id int64, until time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetEcho(id, until)
+}
+
+func (s timedChatStore) LastConfig() (*StoredConfig, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.LastConfig()
+}
+
+func (s timedChatStore) SaveConfig(c StoredConfig) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SaveConfig(c)
+}
+
+func (s timedChatStore) ForEach(fn func(ci *ChatInfo) error) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ForEach(fn)
+}
+
+func (s timedChatStore) SetFormat(id int64, preset string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetFormat(id, preset)
+}
+
+func (s timedChatStore) SetSeverityRoutes(id int64, routes []SeverityRoute) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetSeverityRoutes(id, routes)
+}
+
+func (s timedChatStore) GetFingerprints(fingerprints []string) (map[string]FingerprintRecord, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetFingerprints(fingerprints)
+}
+
+func (s timedChatStore) RecordNotified(fingerprints []string, at time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RecordNotified(fingerprints, at)
+}
+
+func (s timedChatStore) PruneFingerprints(before time.Time) (int, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.PruneFingerprints(before)
+}
+
+func (s timedChatStore) ListRoutes() ([]Route, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.ListRoutes()
+}
+
+func (s timedChatStore) AddRoute(r Route) (Route, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.AddRoute(r)
+}
+
+func (s timedChatStore) RemoveRoute(id int) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RemoveRoute(id)
+}
+
+func (s timedChatStore) GetPollState(id int64) (*PollState, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetPollState(id)
+}
+
+func (s timedChatStore) SetPollState(id int64, state PollState) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetPollState(id, state)
+}
+
+func (s timedChatStore) ChatData(id int64) (*ChatData, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.ChatData(id)
+}
+
+func (s timedChatStore) ForgetChat(id int64) (map[string]int, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ForgetChat(id)
+}
+
+func (s timedChatStore) Flush() error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.Flush()
+}
+
+func (s timedChatStore) CheckChatKeys() ([]ChatKeyProblem, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.CheckChatKeys()
+}
+
+func (s timedChatStore) RepairChatKeys() ([]ChatKeyProblem, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RepairChatKeys()
+}
+
+func (s timedChatStore) SetUnreachable(id int64, since time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetUnreachable(id, since)
+}
+
+func (s timedChatStore) SoftDeleteChat(id int64) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SoftDeleteChat(id)
+}
+
+func (s timedChatStore) SetDebug(c *telebot.Chat, until time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetDebug(c, until)
+}
+
+func (s timedChatStore) ApplySetup(id int64, setup ChatSetup, allEnvs []string, allPrs []string) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ApplySetup(id, setup, allEnvs, allPrs)
+}
+
+func (s timedChatStore) GetMaintenance() (*Maintenance, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetMaintenance()
+}
+
+func (s timedChatStore) SetMaintenance(m Maintenance) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetMaintenance(m)
+}
+
+func (s timedChatStore) ClearMaintenance() error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.ClearMaintenance()
+}
+
+func (s timedChatStore) ListWatchers() ([]Watcher, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.ListWatchers()
+}
+
+func (s timedChatStore) SetWatcher(id int64, on bool, since time.Time) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetWatcher(id, on, since)
+}
+
+func (s timedChatStore) SetDebounce(id int64, d time.Duration) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetDebounce(id, d)
+}
+
+func (s timedChatStore) CheckArea(area string) (AreaStats, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.CheckArea(area)
+}
+
+func (s timedChatStore) EnforceQuota(area string, q AreaQuota, now time.Time) (AreaStats, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.EnforceQuota(area, q, now)
+}
+
+func (s timedChatStore) CompactArea(area string) (AreaStats, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.CompactArea(area)
+}
+
+// timedAlertmanager times listing the alerts of the Alertmanager it wraps.
+type timedAlertmanager struct {
+	Alertmanager
+	listAlerts *latencyWindow
+}
+
+func (a timedAlertmanager) ListAlerts(ctx context.Context, receiver string, silenced bool) ([]*types.Alert, error) {
+	defer a.listAlerts.since(time.Now())
+	return a.Alertmanager.ListAlerts(ctx, receiver, silenced)
+}
+
+// timedTelebot times the messages sent by the Telebot it wraps.
+type timedTelebot struct {
+	Telebot
+	sends *latencyWindow
+}
+
+func (t timedTelebot) Send(to telebot.Recipient, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	defer t.sends.since(time.Now())
+	return t.Telebot.Send(to, what, options...)
+}
+
+func (s timedChatStore) GetUnknownChats() ([]UnknownChat, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetUnknownChats()
+}
+
+func (s timedChatStore) SetUnknownChats(chats []UnknownChat) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetUnknownChats(chats)
+}
+
+func (s timedChatStore) UpdateChat(id int64, fn func(ci *ChatInfo) error) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.UpdateChat(id, fn)
+}
+
+func (s timedChatStore) SetRepeatSuppressions(id int64, rules []RepeatSuppression) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.SetRepeatSuppressions(id, rules)
+}
+
+func (s timedChatStore) GetRepeats(chatID int64) (map[string]RepeatRecord, error) {
+	defer s.reads.since(time.Now())
+	return s.BotChatStore.GetRepeats(chatID)
+}
+
+func (s timedChatStore) RecordRepeats(records []RepeatRecord) error {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.RecordRepeats(records)
+}
+
+func (s timedChatStore) PruneRepeats(now time.Time) (int, error) {
+	defer s.writes.since(time.Now())
+	return s.BotChatStore.PruneRepeats(now)
+}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyWindowQuantile(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 30, 0, time.UTC)
+	w := newLatencyWindow(10*time.Minute, 10)
+
+	_, ok := w.quantile(0.5, now)
+	require.False(t, ok, "nothing observed")
+
+	// 90 fast observations in (4ms, 8ms], 10 slow ones in (512ms, 1.024s].
+	for i := 0; i < 90; i++ {
+		w.observe(6*time.Millisecond, now)
+	}
+	for i := 0; i < 10; i++ {
+		w.observe(700*time.Millisecond, now)
+	}
+
+	p50, ok := w.quantile(0.5, now)
+	require.True(t, ok)
+	bucket := float64(4 * time.Millisecond)
+	require.Equal(t, 4*time.Millisecond+time.Duration(50.0/90*bucket), p50, "interpolated within the bucket")
+	p90, _ := w.quantile(0.9, now)
+	require.Equal(t, 8*time.Millisecond, p90, "the 90th observation is the last of its bucket")
+	p95, _ := w.quantile(0.95, now)
+	require.Equal(t, 512*time.Millisecond+(1024-512)*time.Millisecond/2, p95)
+	p100, _ := w.quantile(1, now)
+	require.Equal(t, 1024*time.Millisecond, p100)
+
+	w.observe(5*time.Minute, now)
+	max, _ := w.quantile(1, now)
+	require.Equal(t, latencyBuckets[len(latencyBuckets)-1], max, "slower than the last bucket")
+}
+
+func TestLatencyWindowExpiry(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	w := newLatencyWindow(10*time.Minute, 10)
+
+	w.observe(time.Second, start)
+	w.observe(time.Millisecond, start.Add(5*time.Minute))
+
+	p100, _ := w.quantile(1, start.Add(9*time.Minute))
+	require.Equal(t, 1024*time.Millisecond, p100, "both are in the window")
+
+	p100, _ = w.quantile(1, start.Add(10*time.Minute))
+	require.Equal(t, time.Millisecond, p100, "the slow one expired with its slot")
+
+	_, ok := w.quantile(1, start.Add(15*time.Minute))
+	require.False(t, ok, "everything expired")
+
+	// A slot coming round again starts over.
+	w.observe(time.Millisecond, start.Add(20*time.Minute))
+	p100, _ = w.quantile(1, start.Add(20*time.Minute))
+	require.Equal(t, time.Millisecond, p100)
+}
+
+func TestLatencyStatus(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	// Going through the bot's store and Telegram times them, the test's don't.
+	_, err := b.chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	_, err = b.telegram.Send(testChat, "hi")
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1)
+
+	status := b.latency.status(time.Now())
+	require.Contains(t, status, "*Latency (p50/p95, last 10m)*")
+	require.Regexp(t, `\nStore reads: [0-9.]+[µm]?s / [0-9.]+[µm]?s\n`, status)
+	require.Contains(t, status, "\nStore writes: none\n")
+	require.Contains(t, status, "\nListAlerts: none\n")
+	require.Regexp(t, `\nTelegram sends: [0-9.]+[µm]?s / [0-9.]+[µm]?s$`, status)
+}
@@ -0,0 +1,94 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// The policies for notifications that waited too long to be sent.
+const (
+	// LoadSheddingDrop drops them.
+	LoadSheddingDrop = "drop"
+	// LoadSheddingMerge merges them with the notifications queued behind them for the same chat.
+	LoadSheddingMerge = "merge"
+)
+
+// WithLoadShedding keeps a backlog, like one built up while Telegram rate limits the bot, from turning into
+// a wall of stale notifications: those that waited longer than maxAge since their webhook came in are dropped
+// or merged with the others queued for their chat into one message, depending on the policy. 0 sends all of them.
+func WithLoadShedding(maxAge time.Duration, policy string) BotOption {
+	return func(b *Bot) error {
+		if maxAge < 0 {
+			return fmt.Errorf("the maximum age of notifications must not be negative, is %s", maxAge)
+		}
+		switch policy {
+		case LoadSheddingDrop, LoadSheddingMerge:
+		default:
+			return fmt.Errorf("unknown load shedding policy %q, use %s or %s", policy, LoadSheddingDrop, LoadSheddingMerge)
+		}
+		b.shedMaxAge = maxAge
+		b.shedMerge = policy == LoadSheddingMerge
+		return nil
+	}
+}
+
+// shedLoad drops the webhook if it waited too long or merges it with those waiting behind it, acking all it took.
+// shed is false if the webhook is still to be processed, as it's recent or there's nothing to merge it with.
+func (b *Bot) shedLoad(ctx context.Context, q *chatQueues, w queuedWebhook) (shed bool, err error) {
+	if b.shedMaxAge <= 0 {
+		return false, nil
+	}
+	waited := q.now().Sub(w.received)
+	if waited <= b.shedMaxAge {
+		return false, nil
+	}
+	logger := b.webhookLogger(w.TelegramWebhook, nil)
+
+	if !b.shedMerge {
+		level.Warn(logger).Log("msg", "dropping notification that waited too long", "waited", waited)
+		b.notificationsShed.Inc()
+		ack(w.TelegramWebhook, nil)
+		return true, nil
+	}
+
+	rest := q.rest(w.ChatID)
+	if len(rest) == 0 {
+		return false, nil
+	}
+	queued := append([]queuedWebhook{w}, rest...)
+	merged := newBufferedChat()
+	for _, qw := range queued {
+		if qw.Message.Data != nil {
+			merged.add(qw.TelegramWebhook)
+		}
+	}
+	if merged.last.Message.Data == nil {
+		for _, qw := range queued {
+			ack(qw.TelegramWebhook, nil)
+		}
+		return true, nil
+	}
+
+	header := fmt.Sprintf("<b>⏳ %d delayed notifications combined</b>, the oldest waited %s", len(queued), formatExtension(waited.Round(time.Second)))
+	if merged.dropped > 0 {
+		header = header + fmt.Sprintf(", %d more alerts were dropped", merged.dropped)
+	}
+	mergedWebhook := merged.webhook(w.ChatID)
+	if tally := b.severityTally(mergedWebhook.Message.Alerts); tally != "" {
+		header = header + "\n" + tally
+	}
+	level.Warn(logger).Log("msg", "merging notifications that waited too long", "count", len(queued), "waited", waited)
+	b.notificationsMerged.Add(float64(len(queued)))
+	d, err := b.deliverWebhook(unsplit(ctx), mergedWebhook, header+"\n\n")
+	failed := d.Failed
+	if err != nil {
+		failed = err
+	}
+	for _, qw := range queued {
+		ack(qw.TelegramWebhook, failed)
+	}
+	return true, err
+}
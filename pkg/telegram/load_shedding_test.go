@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// rateLimitedBacklog queues four webhooks for testChat and makes Telegram answer the first with a 429
+// that takes ten minutes, the others wait that long behind it. It returns what every webhook was acked with.
+func rateLimitedBacklog(t *testing.T, b *Bot, tb *fakeTelebot) map[string]error {
+	clock := &fakeClock{t: time.Now()}
+	b.webhookQueues.now = clock.now
+
+	limited := true
+	tb.beforeSend = func() {
+		if limited {
+			clock.advance(10 * time.Minute)
+		}
+	}
+	tb.sendErr = func() error {
+		if limited {
+			limited = false
+			return telebot.FloodError{APIError: telebot.NewAPIError(429, "Too Many Requests: retry after 600"), RetryAfter: 600}
+		}
+		return nil
+	}
+
+	acked := map[string]error{}
+	queue := func(name string, status string, alerts ...template.Alert) {
+		b.webhookQueues.push(alertmanager.TelegramWebhook{
+			ChatID:  testChat.ID,
+			Message: webhook.Message{GroupKey: name, Data: &template.Data{Status: status, Alerts: alerts}},
+			Ack:     func(err error) { acked[name] = err },
+		})
+	}
+	highCPU := template.Alert{Status: "firing", Fingerprint: "a", Labels: template.KV{"alertname": "HighCPU"}}
+	diskFull := template.Alert{Status: "firing", Fingerprint: "b", Labels: template.KV{"alertname": "DiskFull"}}
+	resolved := highCPU
+	resolved.Status = "resolved"
+
+	queue("first", "firing", template.Alert{Status: "firing", Fingerprint: "c", Labels: template.KV{"alertname": "Watchdog"}})
+	queue("second", "firing", highCPU)
+	queue("third", "firing", diskFull, highCPU)
+	queue("fourth", "resolved", resolved)
+
+	require.NoError(t, b.drain(context.Background(), b.webhookQueues, testChat.ID))
+	return acked
+}
+
+func TestLoadSheddingMerge(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithLoadShedding(5*time.Minute, LoadSheddingMerge))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	merged := testutil.ToFloat64(b.notificationsMerged)
+
+	acked := rateLimitedBacklog(t, b, tb)
+
+	var flood telebot.FloodError
+	require.True(t, errors.As(acked["first"], &flood), "the rate limited notification failed")
+	require.Equal(t, map[string]error{"first": acked["first"], "second": nil, "third": nil, "fourth": nil}, acked)
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 1, "the backlog is sent as one message")
+	text := msgs[0].text()
+	require.True(t, strings.HasPrefix(text, "<b>⏳ 3 delayed notifications combined</b>, the oldest waited 10m\n\n"), text)
+	require.Equal(t, 1, strings.Count(text, "HighCPU"), "alerts are merged by fingerprint: %s", text)
+	require.Contains(t, text, "DiskFull")
+	require.Equal(t, float64(3), testutil.ToFloat64(b.notificationsMerged)-merged)
+}
+
+func TestLoadSheddingDrop(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithLoadShedding(5*time.Minute, LoadSheddingDrop))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	shed := testutil.ToFloat64(b.notificationsShed)
+
+	acked := rateLimitedBacklog(t, b, tb)
+	require.Len(t, acked, 4)
+	require.Empty(t, tb.messages(), "stale notifications aren't sent")
+	require.Equal(t, float64(3), testutil.ToFloat64(b.notificationsShed)-shed)
+}
+
+func TestLoadSheddingOff(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	rateLimitedBacklog(t, b, tb)
+	require.Len(t, tb.messages(), 3, "every notification is sent however late")
+}
+
+func TestLoadSheddingOptions(t *testing.T) {
+	b := &Bot{}
+	require.EqualError(t, WithLoadShedding(time.Minute, "collapse")(b), `unknown load shedding policy "collapse", use drop or merge`)
+	require.Error(t, WithLoadShedding(-time.Minute, LoadSheddingDrop)(b))
+}
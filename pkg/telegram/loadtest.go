@@ -0,0 +1,333 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandLoadTest = "/loadtest"
+
+	// loadTestReceiver marks webhooks generated by a load test.
+	loadTestReceiver       = "alertmanager-bot-loadtest"
+	syntheticMessageHeader = "<b>🧪 SYNTHETIC LOAD TEST - not a real alert</b>\n\n"
+
+	loadTestMaxWebhooks = 1000
+	loadTestMaxDuration = 10 * time.Minute
+
+	responseLoadTestUsage    = "Usage: " + CommandLoadTest + " <webhooks> <duration> [seed], for example " + CommandLoadTest + " 200 10s"
+	responseLoadTestDisabled = "Load tests are disabled for this bot."
+	responseLoadTestRunning  = "A load test is already running."
+	responseLoadTestStopped  = "The bot isn't taking webhooks right now, so there's nothing to load test."
+)
+
+var (
+	loadTestAlertnames = []string{"HighCPU", "HighMemory", "DiskFull", "InstanceDown", "HighLatency", "HighErrorRate"}
+	loadTestSeverities = []string{"critical", "warning", "info"}
+)
+
+// WithLoadTestEnabled allows admins to generate synthetic webhooks with /loadtest.
+func WithLoadTestEnabled(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.loadTestEnabled = enabled
+		return nil
+	}
+}
+
+// loadTestGenerator creates synthetic webhooks, the same seed always creates the same webhooks.
+type loadTestGenerator struct {
+	rand         *rand.Rand
+	environments []string
+	projects     []string
+}
+
+func newLoadTestGenerator(seed int64, environments []string, projects []string) *loadTestGenerator {
+	return &loadTestGenerator{
+		rand:         rand.New(rand.NewSource(seed)),
+		environments: environments,
+		projects:     projects,
+	}
+}
+
+func (g *loadTestGenerator) pick(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[g.rand.Intn(len(values))]
+}
+
+// next returns the webhook with 1 to 3 alerts for the chat.
+func (g *loadTestGenerator) next(chatID int64) alertmanager.TelegramWebhook {
+	status := "firing"
+	if g.rand.Intn(4) == 0 {
+		status = "resolved"
+	}
+
+	alerts := make(template.Alerts, 1+g.rand.Intn(3))
+	for i := range alerts {
+		labels := template.KV{
+			"alertname": g.pick(loadTestAlertnames),
+			"severity":  g.pick(loadTestSeverities),
+			"instance":  fmt.Sprintf("node-%02d", g.rand.Intn(20)),
+			"synthetic": "true",
+		}
+		if env := g.pick(g.environments); env != "" {
+			labels[labelEnvironment] = env
+		}
+		if pr := g.pick(g.projects); pr != "" {
+			labels[labelProject] = pr
+		}
+		alerts[i] = template.Alert{
+			Status:      status,
+			Labels:      labels,
+			Annotations: template.KV{"summary": "Synthetic alert generated by " + CommandLoadTest},
+			Fingerprint: fmt.Sprintf("%016x", g.rand.Uint64()),
+		}
+	}
+
+	return alertmanager.TelegramWebhook{
+		ChatID: chatID,
+		Message: webhook.Message{Data: &template.Data{
+			Receiver:     loadTestReceiver,
+			Status:       status,
+			Alerts:       alerts,
+			CommonLabels: template.KV{"synthetic": "true"},
+		}},
+	}
+}
+
+func isSyntheticWebhook(w alertmanager.TelegramWebhook) bool {
+	return w.Message.Data != nil && w.Message.Receiver == loadTestReceiver
+}
+
+// loadTestReport sums up the deliveries of a load test.
+type loadTestReport struct {
+	Webhooks    int
+	Sent        int
+	Split       int
+	RateLimited int
+	// Dropped are the webhooks that weren't sent: filtered, held, shed, merged into others or failed otherwise.
+	Dropped int
+	// Unfinished are the webhooks that weren't acked yet when the bot stopped.
+	Unfinished int
+}
+
+// add counts a webhook by its ack and what the workers delivered for it.
+func (r *loadTestReport) add(d delivery, err error) {
+	var flood telebot.FloodError
+	switch {
+	case err == nil && d.Messages > 0:
+		r.Sent++
+		if d.Messages > 1 || d.Truncated {
+			r.Split++
+		}
+	case errors.As(err, &flood):
+		r.RateLimited++
+	default:
+		r.Dropped++
+	}
+}
+
+func (r loadTestReport) String() string {
+	s := fmt.Sprintf(
+		"Webhooks: %d\nSent: %d\nSplit or truncated: %d\nRate limited: %d\nDropped: %d",
+		r.Webhooks, r.Sent, r.Split, r.RateLimited, r.Dropped,
+	)
+	if r.Unfinished > 0 {
+		s += fmt.Sprintf("\nUnfinished: %d", r.Unfinished)
+	}
+	return s
+}
+
+// loadTestRun collects the outcome of the webhooks of a load test as they're acked.
+// The workers record what they delivered for a webhook before acking it, by its group key.
+type loadTestRun struct {
+	mu         sync.Mutex
+	deliveries map[string]delivery
+	report     loadTestReport
+	pending    int
+	// acked gets a value for every ack.
+	acked chan struct{}
+}
+
+func newLoadTestRun(n int) *loadTestRun {
+	return &loadTestRun{deliveries: map[string]delivery{}, acked: make(chan struct{}, n)}
+}
+
+// record keeps what the workers delivered for the webhook with the group key.
+func (r *loadTestRun) record(key string, d delivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[key] = d
+}
+
+// enqueued counts a webhook handed to the bot.
+func (r *loadTestRun) enqueued() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Webhooks++
+	r.pending++
+}
+
+// ack returns the Ack of the webhook with the group key, counting the webhook's outcome once it's called.
+func (r *loadTestRun) ack(key string) func(error) {
+	return func(err error) {
+		r.mu.Lock()
+		r.report.add(r.deliveries[key], err)
+		delete(r.deliveries, key)
+		r.pending--
+		r.mu.Unlock()
+		r.acked <- struct{}{}
+	}
+}
+
+// result returns the report, with the webhooks still waiting for their ack as unfinished.
+func (r *loadTestRun) result() loadTestReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report
+	report.Unfinished = r.pending
+	return report
+}
+
+// observeLoadTest records what was delivered for a webhook of the running load test.
+func (b *Bot) observeLoadTest(w alertmanager.TelegramWebhook, d delivery) {
+	if !isSyntheticWebhook(w) {
+		return
+	}
+	b.loadTestMu.Lock()
+	run := b.loadTest
+	b.loadTestMu.Unlock()
+	if run != nil {
+		run.record(w.Message.GroupKey, d)
+	}
+}
+
+// startLoadTest returns the run of a load test of n webhooks, or nil if one is already running.
+func (b *Bot) startLoadTest(n int) *loadTestRun {
+	b.loadTestMu.Lock()
+	defer b.loadTestMu.Unlock()
+	if b.loadTest != nil {
+		return nil
+	}
+	b.loadTest = newLoadTestRun(n)
+	return b.loadTest
+}
+
+// finishLoadTest lets the next load test start.
+func (b *Bot) finishLoadTest() {
+	b.loadTestMu.Lock()
+	defer b.loadTestMu.Unlock()
+	b.loadTest = nil
+}
+
+// runLoadTest feeds the run's n generated webhooks through the running bot's webhook pipeline, evenly
+// spread over the duration, and waits for their acks until ctx, Run's context, is done.
+func (b *Bot) runLoadTest(ctx context.Context, run *loadTestRun, chatID int64, n int, duration time.Duration, seed int64) loadTestReport {
+	defer b.finishLoadTest()
+	gen := newLoadTestGenerator(seed, b.environments, b.projects)
+
+	var tick <-chan time.Time
+	if interval := duration / time.Duration(n); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for i := 0; i < n; i++ {
+		if i > 0 && tick != nil {
+			select {
+			case <-ctx.Done():
+				return run.result()
+			case <-tick:
+			}
+		}
+		w := gen.next(chatID)
+		w.Message.GroupKey = fmt.Sprintf("%s/%d/%d", loadTestReceiver, seed, i)
+		w.Ack = run.ack(w.Message.GroupKey)
+		select {
+		case <-ctx.Done():
+			return run.result()
+		case b.syntheticWebhooks <- w:
+			run.enqueued()
+		}
+	}
+
+	for i := 0; i < run.result().Webhooks; i++ {
+		select {
+		case <-ctx.Done():
+			return run.result()
+		case <-run.acked:
+		}
+	}
+	return run.result()
+}
+
+// parseLoadTestPayload parses "<webhooks> <duration> [seed]".
+func parseLoadTestPayload(payload string) (n int, duration time.Duration, seed int64, err error) {
+	fields := strings.Fields(payload)
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, 0, 0, fmt.Errorf("expected webhooks and duration")
+	}
+	n, err = strconv.Atoi(fields[0])
+	if err != nil || n < 1 || n > loadTestMaxWebhooks {
+		return 0, 0, 0, fmt.Errorf("webhooks must be a number between 1 and %d", loadTestMaxWebhooks)
+	}
+	duration, err = time.ParseDuration(fields[1])
+	if err != nil || duration < 0 || duration > loadTestMaxDuration {
+		return 0, 0, 0, fmt.Errorf("duration must be between 0s and %s", loadTestMaxDuration)
+	}
+	seed = time.Now().UnixNano()
+	if len(fields) == 3 {
+		seed, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("seed must be a number")
+		}
+	}
+	return n, duration, seed, nil
+}
+
+func (b *Bot) handleLoadTest(message *telebot.Message) error {
+	if !b.loadTestEnabled {
+		_, err := b.reply(message, responseLoadTestDisabled)
+		return err
+	}
+
+	n, duration, seed, err := parseLoadTestPayload(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseLoadTestUsage))
+		return err
+	}
+
+	ctx := b.runContext()
+	if ctx == nil || ctx.Err() != nil {
+		_, err := b.reply(message, responseLoadTestStopped)
+		return err
+	}
+	run := b.startLoadTest(n)
+	if run == nil {
+		_, err := b.reply(message, responseLoadTestRunning)
+		return err
+	}
+
+	_, err = b.reply(message, fmt.Sprintf("Starting load test with %d synthetic webhooks over %s (seed %d)", n, duration, seed))
+	if err != nil {
+		b.finishLoadTest()
+		return err
+	}
+
+	report := b.runLoadTest(ctx, run, message.Chat.ID, n, duration, seed)
+	_, err = b.reply(message, fmt.Sprintf("Load test finished (seed %d)\n%s", seed, report))
+	return err
+}
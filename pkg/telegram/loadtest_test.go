@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"go.uber.org/goleak"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestLoadTestGeneratorDeterministic(t *testing.T) {
+	envs, prs := []string{"prod", "staging"}, []string{"billing"}
+	a := newLoadTestGenerator(42, envs, prs)
+	b := newLoadTestGenerator(42, envs, prs)
+
+	for i := 0; i < 20; i++ {
+		wa, wb := a.next(testChat.ID), b.next(testChat.ID)
+		require.Equal(t, wa, wb)
+		require.True(t, isSyntheticWebhook(wa))
+		for _, alert := range wa.Message.Alerts {
+			require.Contains(t, envs, alert.Labels[labelEnvironment])
+			require.Equal(t, "billing", alert.Labels[labelProject])
+		}
+	}
+	require.NotEqual(t, newLoadTestGenerator(1, envs, prs).next(testChat.ID), newLoadTestGenerator(2, envs, prs).next(testChat.ID))
+}
+
+func TestParseLoadTestPayload(t *testing.T) {
+	n, d, seed, err := parseLoadTestPayload("200 10s 7")
+	require.NoError(t, err)
+	require.Equal(t, 200, n)
+	require.Equal(t, "10s", d.String())
+	require.Equal(t, int64(7), seed)
+
+	for _, payload := range []string{"", "200", "0 10s", "5000 10s", "10 forever", "10 1h", "10 1s seed"} {
+		_, _, _, err := parseLoadTestPayload(payload)
+		require.Error(t, err, payload)
+	}
+}
+
+func TestLoadTestDisabled(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.NoError(t, b.handleLoadTest(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "10 0s"}))
+	require.Equal(t, responseLoadTestDisabled, tb.lastText())
+	require.Len(t, tb.messages(), 1)
+}
+
+// runLoadTestBot runs the bot until the test ends.
+func runLoadTestBot(t *testing.T, b *Bot) {
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background(), ChannelSource(make(chan alertmanager.TelegramWebhook))) }()
+	require.Eventually(t, func() bool { return b.runContext() != nil }, time.Second, time.Millisecond)
+	t.Cleanup(func() {
+		require.NoError(t, b.Shutdown(context.Background()))
+		require.NoError(t, <-done)
+	})
+}
+
+func TestLoadTestReport(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithLoadTestEnabled(true),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleLoadTest(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "3 0s 1"}))
+	require.Equal(t, responseLoadTestStopped, tb.lastText(), "load tests need a running bot")
+
+	runLoadTestBot(t, b)
+	var sends int
+	tb.mu.Lock()
+	tb.sendErr = func() error {
+		sends++
+		if sends%5 == 0 {
+			return telebot.FloodError{APIError: &telebot.APIError{Code: 429}, RetryAfter: 0}
+		}
+		return nil
+	}
+	tb.mu.Unlock()
+
+	report := b.runLoadTest(b.runContext(), b.startLoadTest(20), testChat.ID, 20, 0, 42)
+	require.Equal(t, loadTestReport{Webhooks: 20, Sent: 16, RateLimited: 4}, report)
+
+	for _, m := range tb.messages()[1:] {
+		require.True(t, strings.HasPrefix(m.text(), syntheticMessageHeader))
+	}
+
+	tb.mu.Lock()
+	tb.sendErr = nil
+	tb.mu.Unlock()
+	require.NoError(t, b.handleLoadTest(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "3 0s 1"}))
+	require.Equal(t, "Load test finished (seed 1)\nWebhooks: 3\nSent: 3\nSplit or truncated: 0\nRate limited: 0\nDropped: 0", tb.lastText())
+}
+
+func TestLoadTestStopsWithShutdown(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithLoadTestEnabled(true),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background(), ChannelSource(make(chan alertmanager.TelegramWebhook))) }()
+	require.Eventually(t, func() bool { return b.runContext() != nil }, time.Second, time.Millisecond)
+
+	reported := make(chan error, 1)
+	go func() {
+		reported <- b.handleLoadTest(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "100 1m 1"})
+	}()
+	require.Eventually(t, func() bool {
+		b.loadTestMu.Lock()
+		defer b.loadTestMu.Unlock()
+		return b.loadTest != nil && b.loadTest.result().Sent == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, b.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+	require.NoError(t, <-reported)
+	require.Contains(t, tb.lastText(), "Load test finished (seed 1)\nWebhooks: 1\nSent: 1")
+}
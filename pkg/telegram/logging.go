@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandDebug = "/debug"
+
+	// defaultChatDebugDuration is how long /debug on lasts without a duration, chatDebugMaxDuration the longest allowed.
+	defaultChatDebugDuration = time.Hour
+	chatDebugMaxDuration     = 24 * time.Hour
+
+	responseDebugUsage = "Usage: " + CommandDebug + " on [duration]|off, for example " + CommandDebug + " on 30m"
+)
+
+// WithDebugLogger sets the logger used for chats with /debug on.
+// It should log all levels, so debug logs of those chats show up whatever the bot's log level is.
+func WithDebugLogger(l log.Logger) BotOption {
+	return func(b *Bot) error {
+		b.debugLogger = l
+		return nil
+	}
+}
+
+// chatLogger returns the logger for a chat: the debug logger while the chat has debugging on, the bot's logger otherwise.
+func (b *Bot) chatLogger(ci *ChatInfo) log.Logger {
+	if ci != nil && b.debugLogger != nil && ci.DebugUntil.After(time.Now()) {
+		return log.With(b.debugLogger, "chat_debug", true)
+	}
+	return b.logger
+}
+
+// messageLogger decorates the chat's logger with the fields identifying a message.
+func (b *Bot) messageLogger(m *telebot.Message) log.Logger {
+	var ci *ChatInfo
+	var keyvals []interface{}
+	if m.Chat != nil {
+		ci, _ = b.chats.GetChatInfo(m.Chat.ID)
+		keyvals = append(keyvals, "chat_id", m.Chat.ID)
+	}
+	if m.Sender != nil {
+		keyvals = append(keyvals, "user_id", m.Sender.ID)
+	}
+	if command := strings.Split(m.Text, " ")[0]; strings.HasPrefix(command, "/") {
+		keyvals = append(keyvals, "command", strings.Split(command, "@")[0])
+	}
+	return log.With(b.chatLogger(ci), keyvals...)
+}
+
+// webhookLogger decorates the chat's logger with the fields identifying a webhook, ci may be nil if the chat isn't known yet.
+func (b *Bot) webhookLogger(w alertmanager.TelegramWebhook, ci *ChatInfo) log.Logger {
+	keyvals := []interface{}{"chat_id", w.ChatID}
+	if w.Message.GroupKey != "" {
+		keyvals = append(keyvals, "group_key", w.Message.GroupKey)
+	}
+	return log.With(b.chatLogger(ci), keyvals...)
+}
+
+// SetDebug turns debug logging on for a chat until the given time, a zero time turns it off.
+func (s *ChatStore) SetDebug(c *telebot.Chat, until time.Time) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.DebugUntil = until
+		return nil
+	})
+}
+
+// parseDebugPayload parses "on [duration]" or "off" into the time debugging ends.
+func parseDebugPayload(payload string, now time.Time) (time.Time, error) {
+	return parseTimedSwitch(payload, now, defaultChatDebugDuration, chatDebugMaxDuration)
+}
+
+// parseTimedSwitch parses "on [duration]" or "off" into the time something turned on ends, zero for off.
+// On lasts def without a duration and max at most.
+func parseTimedSwitch(payload string, now time.Time, def time.Duration, max time.Duration) (time.Time, error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 1 && fields[0] == "off" {
+		return time.Time{}, nil
+	}
+	if len(fields) == 0 || len(fields) > 2 || fields[0] != "on" {
+		return time.Time{}, fmt.Errorf("expected on or off")
+	}
+
+	duration := def
+	if len(fields) == 2 {
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 || d > max {
+			return time.Time{}, fmt.Errorf("duration must be between 0s and %s", max)
+		}
+		duration = d
+	}
+	return now.Add(duration), nil
+}
+
+func (b *Bot) handleDebug(message *telebot.Message) error {
+	until, err := parseDebugPayload(message.Payload, time.Now())
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseDebugUsage))
+		return err
+	}
+
+	if err := b.chats.SetDebug(message.Chat, until); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set debug logging", "err", err)
+		_, err = b.replyStoreError(message, err, "set debug logging")
+		return err
+	}
+
+	if until.IsZero() {
+		_, err = b.reply(message, "Debug logging for this chat is off.")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Debug logging for this chat is on until %s.", until.UTC().Format("2006-01-02 15:04 UTC")))
+	return err
+}
@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// recordingLogger keeps every log line as a map of its fields.
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []map[string]string
+}
+
+func (l *recordingLogger) Log(keyvals ...interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record := map[string]string{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		record[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+	}
+	l.records = append(l.records, record)
+	return nil
+}
+
+// find returns the first record with the message.
+func (l *recordingLogger) find(msg string) map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records {
+		if r["msg"] == msg {
+			return r
+		}
+	}
+	return nil
+}
+
+func newLoggingTestBot(t *testing.T, opts ...BotOption) (*Bot, *fakeTelebot, *ChatStore, *recordingLogger) {
+	logs := &recordingLogger{}
+	opts = append(opts,
+		WithLogger(level.NewFilter(logs, level.AllowInfo())),
+		WithDebugLogger(logs),
+	)
+	b, tb, chats := newTestBot(t, opts...)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	return b, tb, chats, logs
+}
+
+func TestMiddlewareLogFields(t *testing.T) {
+	b, _, _, logs := newLoggingTestBot(t)
+	failing := func(*telebot.Message) error { return fmt.Errorf("boom") }
+
+	b.middleware(failing)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/filters@alertmanager_bot now"})
+	r := logs.find("failed to handle command")
+	require.NotNil(t, r)
+	require.Equal(t, "123", r["chat_id"])
+	require.Equal(t, "123", r["user_id"])
+	require.Equal(t, "/filters", r["command"])
+	require.Equal(t, "boom", r["err"])
+
+	// The bot logs at info level, so debug logs are filtered.
+	require.Nil(t, logs.find("message received"))
+}
+
+func TestChatDebugElevatesLogs(t *testing.T) {
+	b, tb, chats, logs := newLoggingTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+
+	require.NoError(t, b.handleDebug(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "on 10m"}))
+	require.Contains(t, tb.lastText(), "Debug logging for this chat is on until")
+
+	b.middleware(b.handleFilters)(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/filters"})
+	r := logs.find("message received")
+	require.NotNil(t, r)
+	require.Equal(t, "debug", r["level"])
+	require.Equal(t, "true", r["chat_debug"])
+	require.Equal(t, "/filters", r["command"])
+
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{
+		GroupKey: `{}:{alertname="HighCPU"}`,
+		Data: &template.Data{
+			Status: "firing",
+			Alerts: template.Alerts{issueAlert},
+		},
+	}}
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	r = logs.find("rendered message")
+	require.NotNil(t, r)
+	require.Equal(t, "123", r["chat_id"])
+	require.Equal(t, `{}:{alertname="HighCPU"}`, r["group_key"])
+	require.Contains(t, r["body"], "HighCPU")
+
+	// Debugging expires on its own.
+	require.NoError(t, chats.SetDebug(testChat, time.Now().Add(-time.Second)))
+	logs.records = nil
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Nil(t, logs.find("rendered message"))
+}
+
+func TestParseDebugPayload(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	until, err := parseDebugPayload("on", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(defaultChatDebugDuration), until)
+
+	until, err = parseDebugPayload("on 30m", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(30*time.Minute), until)
+
+	until, err = parseDebugPayload("off", now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	for _, payload := range []string{"", "maybe", "on 48h", "on -1m", "on soon", "off 1h"} {
+		_, err := parseDebugPayload(payload, now)
+		require.Error(t, err, payload)
+	}
+}
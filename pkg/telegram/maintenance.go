@@ -0,0 +1,375 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandMaintenance = "/maintenance"
+
+	// telegramMaintenanceKey keeps the maintenance in the store, so it survives restarts and all replicas see it.
+	telegramMaintenanceKey = "telegram/maintenance"
+
+	// maintenanceBufferMax is the number of alerts kept per chat during maintenance, later ones are dropped.
+	maintenanceBufferMax = 500
+
+	responseMaintenanceUsage = "Usage: " + CommandMaintenance + ` on [duration] ["reason"]|off, for example ` + CommandMaintenance + ` on 2h "network migration"`
+)
+
+// Maintenance holds all alert notifications until it's turned off or expires.
+type Maintenance struct {
+	Reason string `json:",omitempty"`
+	Since  time.Time
+	// Until is when the maintenance expires, zero if it doesn't.
+	Until time.Time `json:",omitempty"`
+}
+
+// Active tells if the maintenance hasn't expired yet.
+func (m Maintenance) Active(now time.Time) bool {
+	return m.Until.IsZero() || now.Before(m.Until)
+}
+
+// banner tells about the maintenance, like: 🛠 Maintenance since 2021-03-01 10:00 UTC until 2021-03-01 12:00 UTC: network migration
+func (m Maintenance) banner() string {
+	const layout = "2006-01-02 15:04 MST"
+	banner := "🛠 Maintenance since " + m.Since.UTC().Format(layout)
+	if !m.Until.IsZero() {
+		banner = banner + " until " + m.Until.UTC().Format(layout)
+	}
+	if m.Reason != "" {
+		banner = banner + ": " + m.Reason
+	}
+	return banner
+}
+
+// WithMaintenanceBuffering keeps the notifications held during maintenance and sends them when it's over,
+// if enabled, which is the default. Otherwise they're dropped.
+func WithMaintenanceBuffering(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.maintenanceBuffering = enabled
+		return nil
+	}
+}
+
+// GetMaintenance returns the maintenance, nil if there is none.
+func (s *ChatStore) GetMaintenance() (*Maintenance, error) {
+	kv, err := s.get(telegramMaintenanceKey, errKeyMissing)
+	if err != nil {
+		if err == errKeyMissing {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m Maintenance
+	if err := decode(telegramMaintenanceKey, kv.Value, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SetMaintenance starts a maintenance, replacing the current one.
+func (s *ChatStore) SetMaintenance(m Maintenance) error {
+	value, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.put(telegramMaintenanceKey, value)
+}
+
+// ClearMaintenance ends the maintenance.
+func (s *ChatStore) ClearMaintenance() error {
+	return s.delete(telegramMaintenanceKey)
+}
+
+// bufferedChat is what a chat got during maintenance, or the notifications merged by load shedding.
+type bufferedChat struct {
+	last   alertmanager.TelegramWebhook
+	keys   []string
+	alerts map[string]template.Alert
+	// dropped counts the alerts that didn't fit into the buffer.
+	dropped int
+}
+
+// maintenanceBuffer keeps the alerts of held notifications by chat, in memory only.
+// Each alert is kept once, with the latest status it was sent with.
+type maintenanceBuffer struct {
+	mu    sync.Mutex
+	chats map[int64]*bufferedChat
+}
+
+func newMaintenanceBuffer() *maintenanceBuffer {
+	return &maintenanceBuffer{chats: map[int64]*bufferedChat{}}
+}
+
+func newBufferedChat() *bufferedChat {
+	return &bufferedChat{alerts: map[string]template.Alert{}}
+}
+
+// add keeps the alerts of the webhook, replacing those the chat got before with their latest status.
+func (c *bufferedChat) add(w alertmanager.TelegramWebhook) {
+	c.last = w
+	for i, a := range w.Message.Alerts {
+		key := a.Fingerprint
+		if key == "" {
+			key = fmt.Sprintf("%p/%d", w.Message.Data, i)
+		}
+		if _, ok := c.alerts[key]; !ok {
+			if len(c.keys) >= maintenanceBufferMax {
+				c.dropped++
+				continue
+			}
+			c.keys = append(c.keys, key)
+		}
+		c.alerts[key] = a
+	}
+}
+
+// webhook returns a webhook to the chat with all its alerts, firing if any of them is.
+func (c *bufferedChat) webhook(chatID int64) alertmanager.TelegramWebhook {
+	alerts := make(template.Alerts, 0, len(c.keys))
+	for _, key := range c.keys {
+		alerts = append(alerts, c.alerts[key])
+	}
+	status := "resolved"
+	if len(alerts.Firing()) > 0 {
+		status = "firing"
+	}
+
+	data := *c.last.Message.Data
+	data.Status = status
+	data.Alerts = alerts
+	return alertmanager.TelegramWebhook{
+		ChatID:  chatID,
+		Message: webhook.Message{Data: &data, Version: c.last.Message.Version, GroupKey: c.last.Message.GroupKey},
+	}
+}
+
+func (m *maintenanceBuffer) add(w alertmanager.TelegramWebhook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.chats[w.ChatID]
+	if !ok {
+		c = newBufferedChat()
+		m.chats[w.ChatID] = c
+	}
+	c.add(w)
+}
+
+func (m *maintenanceBuffer) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.chats)
+}
+
+// take empties the buffer and returns a webhook per chat with all its alerts.
+func (m *maintenanceBuffer) take() ([]alertmanager.TelegramWebhook, map[int64]int) {
+	m.mu.Lock()
+	chats := m.chats
+	m.chats = map[int64]*bufferedChat{}
+	m.mu.Unlock()
+
+	ids := make([]int64, 0, len(chats))
+	for id := range chats {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	webhooks := make([]alertmanager.TelegramWebhook, 0, len(ids))
+	dropped := map[int64]int{}
+	for _, id := range ids {
+		c := chats[id]
+		webhooks = append(webhooks, c.webhook(id))
+		if c.dropped > 0 {
+			dropped[id] = c.dropped
+		}
+	}
+	return webhooks, dropped
+}
+
+// activeMaintenance returns the maintenance if there is one that hasn't expired.
+func (b *Bot) activeMaintenance(now time.Time) *Maintenance {
+	m, err := b.chats.GetMaintenance()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get maintenance, sending notifications", "err", err)
+		return nil
+	}
+	if m == nil || !m.Active(now) {
+		return nil
+	}
+	return m
+}
+
+// holdForMaintenance tells if a webhook is held back because of maintenance, buffering it if enabled.
+func (b *Bot) holdForMaintenance(w alertmanager.TelegramWebhook, now time.Time) bool {
+	if w.Message.Data == nil || b.activeMaintenance(now) == nil {
+		return false
+	}
+	logger := b.webhookLogger(w, nil)
+	if !b.maintenanceBuffering {
+		level.Info(logger).Log("msg", "dropping notification during maintenance")
+		return true
+	}
+	level.Debug(logger).Log("msg", "holding notification during maintenance")
+	b.maintenanceBuffer.add(w)
+	return true
+}
+
+// releaseMaintenance sends a catch-up digest to every chat that got notifications during maintenance.
+func (b *Bot) releaseMaintenance(ctx context.Context, m *Maintenance) (int, error) {
+	webhooks, dropped := b.maintenanceBuffer.take()
+	var sent int
+	for _, w := range webhooks {
+		header := fmt.Sprintf("<b>🛠 Catch-up after maintenance</b>, %d alerts came in meanwhile", len(w.Message.Alerts))
+		if m != nil && m.Reason != "" {
+			header = fmt.Sprintf("<b>🛠 Catch-up after maintenance (%s)</b>, %d alerts came in meanwhile", html.EscapeString(m.Reason), len(w.Message.Alerts))
+		}
+		if n := dropped[w.ChatID]; n > 0 {
+			header = header + fmt.Sprintf(", %d more were dropped", n)
+		}
+		if tally := b.severityTally(w.Message.Alerts); tally != "" {
+			header = header + "\n" + tally
+		}
+		d, err := b.deliverWebhook(unsplit(ctx), w, header+"\n\n")
+		if err != nil {
+			return sent, err
+		}
+		sent += d.Messages
+	}
+	return sent, nil
+}
+
+// endMaintenance clears the maintenance, unpins its banner and sends the catch-up digests.
+func (b *Bot) endMaintenance(ctx context.Context, m *Maintenance) (int, error) {
+	if err := b.chats.ClearMaintenance(); err != nil {
+		return 0, err
+	}
+	for _, admin := range b.admins {
+		if err := b.telegram.Unpin(&telebot.Chat{ID: int64(admin)}); err != nil {
+			level.Debug(b.logger).Log("msg", "failed to unpin maintenance banner", "admin_id", admin, "err", err)
+		}
+	}
+	return b.releaseMaintenance(ctx, m)
+}
+
+// checkMaintenance ends an expired maintenance, and releases held notifications
+// once another replica ended the maintenance.
+func (b *Bot) checkMaintenance(ctx context.Context, now time.Time) {
+	m, err := b.chats.GetMaintenance()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get maintenance", "err", err)
+		return
+	}
+
+	switch {
+	case m != nil && !m.Active(now):
+		level.Info(b.logger).Log("msg", "maintenance expired", "reason", m.Reason)
+		if _, err := b.endMaintenance(ctx, m); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to end maintenance", "err", err)
+		}
+	case m == nil && b.maintenanceBuffer.len() > 0:
+		if _, err := b.releaseMaintenance(ctx, nil); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to release notifications held for maintenance", "err", err)
+		}
+	}
+}
+
+// parseMaintenancePayload parses `on [duration] ["reason"]` or `off`.
+func parseMaintenancePayload(payload string, now time.Time) (on bool, m Maintenance, err error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 1 && fields[0] == "off" {
+		return false, m, nil
+	}
+	if len(fields) == 0 || fields[0] != "on" {
+		return false, m, fmt.Errorf("expected on or off")
+	}
+
+	m.Since = now
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(payload), "on"))
+	if len(fields) > 1 {
+		if d, err := time.ParseDuration(fields[1]); err == nil {
+			if d <= 0 {
+				return false, m, fmt.Errorf("duration must be positive")
+			}
+			m.Until = now.Add(d)
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		}
+	}
+	m.Reason = strings.Trim(rest, `"“”`)
+	return true, m, nil
+}
+
+func (b *Bot) handleMaintenance(message *telebot.Message) error {
+	if strings.TrimSpace(message.Payload) == "" {
+		text := "No maintenance right now."
+		if m := b.activeMaintenance(time.Now()); m != nil {
+			text = m.banner()
+		}
+		_, err := b.reply(message, text+"\n"+responseMaintenanceUsage)
+		return err
+	}
+
+	on, m, err := parseMaintenancePayload(message.Payload, time.Now())
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseMaintenanceUsage))
+		return err
+	}
+
+	if !on {
+		current, err := b.chats.GetMaintenance()
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get maintenance", "err", err)
+		}
+		sent, err := b.endMaintenance(context.TODO(), current)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to end maintenance", "err", err)
+			_, err = b.replyStoreError(message, err, "end maintenance")
+			return err
+		}
+		_, err = b.reply(message, fmt.Sprintf("Maintenance is over, sent %d catch-up messages.", sent))
+		return err
+	}
+
+	if err := b.chats.SetMaintenance(m); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to start maintenance", "err", err)
+		_, err = b.replyStoreError(message, err, "start maintenance")
+		return err
+	}
+	level.Info(b.logger).Log("msg", "maintenance started", "reason", m.Reason, "until", m.Until, "user_id", message.Sender.ID)
+
+	for _, admin := range b.admins {
+		banner, err := b.send(&telebot.User{ID: admin}, m.banner(), "command "+CommandMaintenance)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send maintenance banner", "admin_id", admin, "err", err)
+			continue
+		}
+		if err := b.telegram.Pin(banner, telebot.Silent); err != nil {
+			level.Debug(b.logger).Log("msg", "failed to pin maintenance banner", "admin_id", admin, "err", err)
+		}
+	}
+
+	held := "held and sent as a digest when it's over"
+	if !b.maintenanceBuffering {
+		held = "dropped"
+	}
+	_, err = b.reply(message, fmt.Sprintf("%s\nAlert notifications are %s.", m.banner(), held))
+	return err
+}
+
+// escapeMarkdown escapes what Telegram's Markdown mode would interpret.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`).Replace(s)
+}
@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func maintenanceWebhook(status string, alertname string) alertmanager.TelegramWebhook {
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: status,
+		Alerts: template.Alerts{{Status: status, Labels: template.KV{"alertname": alertname}, Fingerprint: alertname}},
+	}}}
+}
+
+func TestMaintenanceStore(t *testing.T) {
+	_, _, chats := newTestBot(t)
+
+	m, err := chats.GetMaintenance()
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	since := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, chats.SetMaintenance(Maintenance{Reason: "network migration", Since: since, Until: since.Add(2 * time.Hour)}))
+	m, err = chats.GetMaintenance()
+	require.NoError(t, err)
+	require.Equal(t, "network migration", m.Reason)
+	require.True(t, m.Active(since.Add(time.Hour)))
+	require.False(t, m.Active(since.Add(3*time.Hour)))
+	require.Equal(t, "🛠 Maintenance since 2021-03-01 10:00 UTC until 2021-03-01 12:00 UTC: network migration", m.banner())
+
+	require.NoError(t, chats.ClearMaintenance())
+	require.NoError(t, chats.ClearMaintenance())
+	m, err = chats.GetMaintenance()
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
+
+func TestParseMaintenancePayload(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	on, m, err := parseMaintenancePayload(`on 2h "network migration"`, now)
+	require.NoError(t, err)
+	require.True(t, on)
+	require.Equal(t, Maintenance{Reason: "network migration", Since: now, Until: now.Add(2 * time.Hour)}, m)
+
+	on, m, err = parseMaintenancePayload(`on upgrading the cluster`, now)
+	require.NoError(t, err)
+	require.True(t, on)
+	require.Equal(t, Maintenance{Reason: "upgrading the cluster", Since: now}, m)
+
+	on, _, err = parseMaintenancePayload("off", now)
+	require.NoError(t, err)
+	require.False(t, on)
+
+	for _, payload := range []string{"maybe", "on -1h", "off now"} {
+		_, _, err := parseMaintenancePayload(payload, now)
+		require.Error(t, err, payload)
+	}
+}
+
+func TestMaintenanceHoldsAndReleases(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMaintenance(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: `on 2h "network migration"`}))
+	require.Len(t, tb.pinned, 1)
+	require.Contains(t, tb.lastText(), "held and sent as a digest")
+	sentBefore := len(tb.messages())
+
+	now := time.Now()
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("firing", "HighCPU"), now))
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("firing", "DiskFull"), now))
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("resolved", "HighCPU"), now))
+	require.Len(t, tb.messages(), sentBefore)
+
+	require.NoError(t, b.handleMaintenance(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	msgs := tb.messages()
+	require.Len(t, msgs, sentBefore+2)
+	digest := msgs[len(msgs)-2].text()
+	require.True(t, strings.HasPrefix(digest, "<b>🛠 Catch-up after maintenance (network migration)</b>, 2 alerts came in meanwhile"), digest)
+	require.Contains(t, digest, "DiskFull")
+	require.Equal(t, "Maintenance is over, sent 1 catch-up messages.", msgs[len(msgs)-1].text())
+	require.Equal(t, 1, tb.unpinned)
+
+	require.False(t, b.holdForMaintenance(maintenanceWebhook("firing", "HighCPU"), now))
+}
+
+func TestMaintenanceDrop(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithMaintenanceBuffering(false), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now()}))
+
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("firing", "HighCPU"), time.Now()))
+	sent, err := b.endMaintenance(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, sent)
+	require.Empty(t, tb.messages())
+}
+
+func TestMaintenanceExpires(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	now := time.Now()
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: now, Until: now.Add(time.Hour)}))
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("firing", "HighCPU"), now))
+
+	b.checkMaintenance(context.Background(), now.Add(30*time.Minute))
+	require.Empty(t, tb.messages())
+
+	b.checkMaintenance(context.Background(), now.Add(2*time.Hour))
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "<b>🛠 Catch-up after maintenance</b>, 1 alerts came in meanwhile")
+	m, err := chats.GetMaintenance()
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
+
+func TestMaintenanceReleasedByAnotherReplica(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now()}))
+	require.True(t, b.holdForMaintenance(maintenanceWebhook("firing", "HighCPU"), time.Now()))
+
+	// Another replica handled /maintenance off.
+	require.NoError(t, chats.ClearMaintenance())
+	b.checkMaintenance(context.Background(), time.Now())
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "HighCPU")
+}
+
+func TestMaintenanceBufferLimit(t *testing.T) {
+	buffer := newMaintenanceBuffer()
+	for i := 0; i < maintenanceBufferMax+3; i++ {
+		w := maintenanceWebhook("firing", "Alert"+strings.Repeat("x", i))
+		buffer.add(w)
+	}
+	webhooks, dropped := buffer.take()
+	require.Len(t, webhooks, 1)
+	require.Len(t, webhooks[0].Message.Alerts, maintenanceBufferMax)
+	require.Equal(t, 3, dropped[testChat.ID])
+	require.Equal(t, 0, buffer.len())
+}
@@ -0,0 +1,233 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// telegramMessagesDirectory kept the records of messages at a key each, before they were batched by chat.
+	telegramMessagesDirectory = "telegram/messages"
+	// telegramMessageBatchesDirectory keeps the records of the messages sent to each chat, a key per chat.
+	telegramMessageBatchesDirectory = "telegram/message_batches"
+)
+
+const (
+	labelEnvironment = "environment"
+	labelProject     = "project"
+)
+
+// MessageRecord is what we remember about an alert message sent to a chat,
+// so that replies to that message can be related back to its alerts.
+type MessageRecord struct {
+	ChatID       int64
+	MessageID    int
+	SentAt       time.Time
+	Environments []string
+	Projects     []string
+	Fingerprints []string
+	// DeleteAttempts and NextDeleteAt keep track of failed attempts to delete the message.
+	DeleteAttempts int       `json:",omitempty"`
+	NextDeleteAt   time.Time `json:",omitempty"`
+	// Text, ParseMode and ReplyMarkup are what was sent, kept with WithSilenceSync to edit the message
+	// and for chats with an archive chat to copy it there.
+	Text        string               `json:",omitempty"`
+	ParseMode   telebot.ParseMode    `json:",omitempty"`
+	ReplyMarkup *telebot.ReplyMarkup `json:",omitempty"`
+	// SilencedBy are the IDs of the silences the message was edited for.
+	SilencedBy []string `json:",omitempty"`
+	// Archived is set once the message was copied to the archive chat of its chat.
+	Archived bool `json:",omitempty"`
+}
+
+// newMessageRecord collects the environments, projects and fingerprints of the alerts in a sent message.
+func newMessageRecord(m *telebot.Message, alerts template.Alerts) MessageRecord {
+	var envs, prs, fps []string
+	for _, a := range alerts {
+		if env := a.Labels[labelEnvironment]; env != "" {
+			envs = append(envs, env)
+		}
+		if pr := a.Labels[labelProject]; pr != "" {
+			prs = append(prs, pr)
+		}
+		if a.Fingerprint != "" {
+			fps = append(fps, a.Fingerprint)
+		}
+	}
+
+	return MessageRecord{
+		ChatID:       m.Chat.ID,
+		MessageID:    m.ID,
+		SentAt:       m.Time(),
+		Environments: getUniqueStrings(envs),
+		Projects:     getUniqueStrings(prs),
+		Fingerprints: getUniqueStrings(fps),
+	}
+}
+
+// messageKey is the key an earlier version stored the record of a message at, before the records of a chat
+// were batched. Those records are still read, and moved into the chat's batch when it's first written.
+func messageKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%s/%d/%d", telegramMessagesDirectory, chatID, messageID)
+}
+
+// messageBatchKey is the key the records of the messages sent to a chat are stored at.
+func messageBatchKey(chatID int64) string {
+	return fmt.Sprintf("%s/%d", telegramMessageBatchesDirectory, chatID)
+}
+
+// AddMessage stores the record of a sent alert message, written behind unless the store has WithSyncWrites.
+func (s *ChatStore) AddMessage(r MessageRecord) error {
+	return s.writeMessageBehind(r.ChatID, r.MessageID, &r)
+}
+
+// GetMessage returns the record of a sent alert message or ErrMessageNotFound.
+// Buffered records are looked up in the buffer, which isn't flushed for it.
+func (s *ChatStore) GetMessage(chatID int64, messageID int) (*MessageRecord, error) {
+	if s.writes != nil {
+		if r, ok := s.writes.record(chatID, messageID); ok {
+			if r == nil {
+				return nil, ErrMessageNotFound
+			}
+			return r, nil
+		}
+	}
+
+	batch, err := s.messageBatch(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := batch[messageID]; ok {
+		return &r, nil
+	}
+	if s.batched(chatID) {
+		return nil, ErrMessageNotFound
+	}
+
+	key := messageKey(chatID, messageID)
+	kv, err := s.get(key, ErrMessageNotFound)
+	if err != nil {
+		return nil, err
+	}
+	var r MessageRecord
+	if err := decode(key, kv.Value, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// messageBatch returns the stored records of the messages sent to the chat, by message ID.
+func (s *ChatStore) messageBatch(chatID int64) (map[int]MessageRecord, error) {
+	key := messageBatchKey(chatID)
+	kv, err := s.get(key, errKeyMissing)
+	if errors.Is(err, errKeyMissing) {
+		return map[int]MessageRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []MessageRecord
+	if err := decode(key, kv.Value, &records); err != nil {
+		return nil, err
+	}
+	batch := make(map[int]MessageRecord, len(records))
+	for _, r := range records {
+		batch[r.MessageID] = r
+	}
+	return batch, nil
+}
+
+// putMessageBatch writes the records of the messages sent to the chat, deleting their key once there are none.
+func (s *ChatStore) putMessageBatch(chatID int64, batch map[int]MessageRecord) error {
+	key := messageBatchKey(chatID)
+	if len(batch) == 0 {
+		return s.delete(key)
+	}
+	records := make([]MessageRecord, 0, len(batch))
+	for _, r := range batch {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].MessageID < records[j].MessageID })
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return s.put(key, value)
+}
+
+// batched tells if the chat has no records stored at a key each anymore, as far as this store knows.
+func (s *ChatStore) batched(chatID int64) bool {
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+	return s.batchedChats[chatID]
+}
+
+// writeMessages writes the changes to the records of the messages sent to the chat with a single write of its
+// batch, nil removes a record. The first time, the chat's records stored at a key each are moved into the batch.
+// A batch that can't be read is replaced, rather than keeping all later records from being written.
+func (s *ChatStore) writeMessages(chatID int64, changes map[int]*MessageRecord) error {
+	s.messagesMu.Lock()
+	defer s.messagesMu.Unlock()
+
+	batch, err := s.messageBatch(chatID)
+	var corrupt *ErrCorruptRecord
+	if errors.As(err, &corrupt) {
+		level.Warn(s.health.logger).Log("msg", "replacing the message records of the chat that can't be read", "chat_id", chatID, "err", err)
+		batch, err = map[int]MessageRecord{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var loose []string
+	if !s.batchedChats[chatID] {
+		// The trailing slash keeps the prefix from matching chats whose ID starts with this one's.
+		prefix := fmt.Sprintf("%s/%d/", telegramMessagesDirectory, chatID)
+		_, err := s.listRecords(prefix, func(kv *store.KVPair) error {
+			if !strings.HasPrefix(kv.Key, prefix) {
+				return nil
+			}
+			var r MessageRecord
+			if err := decode(kv.Key, kv.Value, &r); err != nil {
+				return err
+			}
+			if _, ok := batch[r.MessageID]; !ok {
+				batch[r.MessageID] = r
+			}
+			loose = append(loose, kv.Key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for messageID, r := range changes {
+		if r == nil {
+			delete(batch, messageID)
+			continue
+		}
+		batch[messageID] = *r
+	}
+	// The batch is written before the records at a key each are deleted, a failure in between leaves records
+	// twice, which reads the same as once.
+	if err := s.putMessageBatch(chatID, batch); err != nil {
+		return err
+	}
+	for _, key := range loose {
+		if err := s.delete(key); err != nil {
+			return err
+		}
+	}
+	s.batchedChats[chatID] = true
+	return nil
+}
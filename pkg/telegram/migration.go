@@ -0,0 +1,173 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandStoreCheck = "/store_check"
+
+	// failedSendsStale is the number of consecutive failed deliveries after which
+	// a pre-migration group record is considered stale.
+	failedSendsStale = 3
+)
+
+// MigrateChat moves a chat's record to the ID of the supergroup it was migrated to.
+// All settings of the old record are kept, even if /start was already run in the supergroup.
+func (s *ChatStore) MigrateChat(from int64, to int64) error {
+	old, err := s.GetChatInfo(from)
+	if err != nil {
+		return err
+	}
+
+	migrated := *old
+	chat := *old.Chat
+	chat.ID = to
+	chat.Type = telebot.ChatSuperGroup
+	migrated.Chat = &chat
+	migrated.FailedSends = 0
+
+	existing, err := s.GetChatInfo(to)
+	if err != nil && !errors.Is(err, ErrChatNotFound) {
+		return err
+	}
+	if existing != nil {
+		migrated.Chat = existing.Chat
+	}
+
+	if err := s.putChatInfo(&migrated); err != nil {
+		return err
+	}
+	return s.delete(chatKey(from))
+}
+
+// RecordDelivery updates the count of consecutive failed deliveries to a chat.
+// The store is only written if the count changes.
+func (s *ChatStore) RecordDelivery(chatID int64, delivered bool) error {
+	return s.UpdateChat(chatID, func(ci *ChatInfo) error {
+		if delivered {
+			ci.FailedSends = 0
+		} else {
+			ci.FailedSends++
+		}
+		return nil
+	})
+}
+
+// handleMigration is called by telebot when a group was migrated to a supergroup.
+func (b *Bot) handleMigration(from int64, to int64) {
+	if err := b.chats.MigrateChat(from, to); err != nil {
+		if errors.Is(err, ErrChatNotFound) {
+			return
+		}
+		level.Warn(b.logger).Log("msg", "failed to migrate chat to supergroup", "from", from, "to", to, "err", err)
+		return
+	}
+	level.Info(b.logger).Log("msg", "migrated chat to supergroup", "from", from, "to", to)
+}
+
+// isMigratedError returns whether sending failed because the group is a supergroup now.
+// The telebot version in use doesn't expose the new chat ID with this error,
+// so the record is only moved once the migration update arrives.
+func isMigratedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "group chat was upgraded to a supergroup chat")
+}
+
+// isSupergroupID returns whether id has the -100 prefix of supergroups and channels.
+func isSupergroupID(id int64) bool {
+	return strings.HasPrefix(strconv.FormatInt(id, 10), "-100")
+}
+
+type storeCheckFinding struct {
+	ChatID int64
+	Title  string
+	Reason string
+}
+
+// findStaleMigrationRecords flags group records that look left behind by a migration to a supergroup:
+// an old-format group ID that either shares its title with a supergroup record or keeps failing.
+func findStaleMigrationRecords(chats []ChatInfo) []storeCheckFinding {
+	supergroups := map[string]int64{}
+	for _, ci := range chats {
+		if ci.Chat != nil && isSupergroupID(ci.Chat.ID) && ci.Chat.Title != "" {
+			supergroups[ci.Chat.Title] = ci.Chat.ID
+		}
+	}
+
+	var findings []storeCheckFinding
+	for _, ci := range chats {
+		if ci.Chat == nil || ci.Chat.ID >= 0 || isSupergroupID(ci.Chat.ID) {
+			continue
+		}
+		if id, ok := supergroups[ci.Chat.Title]; ok && ci.Chat.Title != "" {
+			findings = append(findings, storeCheckFinding{
+				ChatID: ci.Chat.ID,
+				Title:  ci.Chat.Title,
+				Reason: fmt.Sprintf("probably migrated to supergroup %d with the same title", id),
+			})
+			continue
+		}
+		if ci.FailedSends >= failedSendsStale {
+			findings = append(findings, storeCheckFinding{
+				ChatID: ci.Chat.ID,
+				Title:  ci.Chat.Title,
+				Reason: fmt.Sprintf("old group ID with %d failed deliveries in a row", ci.FailedSends),
+			})
+		}
+	}
+	return findings
+}
+
+func (b *Bot) handleStoreCheck(message *telebot.Message) error {
+	// The keys are checked first, a record that can't be read fails listing the chats.
+	problems, err := b.chats.CheckChatKeys()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to check the keys of chat records", "err", err)
+		_, err = b.replyStoreError(message, err, "check the keys of chat records")
+		return err
+	}
+	var out string
+	if len(problems) > 0 {
+		out = "Found chat records stored at the wrong key, " + CommandStoreRepair + " shows what repairing them does:\n" +
+			formatChatKeyProblems(problems) + "\n"
+	}
+	var areas []AreaStats
+	for _, area := range storeAreaNames {
+		stats, err := b.chats.CheckArea(area)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to check the store area", "area", area, "err", err)
+			_, err = b.replyStoreError(message, err, "check the "+area+" area of the store")
+			return err
+		}
+		areas = append(areas, stats)
+	}
+	out += formatAreaProblems(areas)
+
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats from chat store", "err", err)
+		_, err = b.reply(message, out+"I can't list the subscribed chats.")
+		return err
+	}
+
+	findings := findStaleMigrationRecords(chats)
+	if len(findings) == 0 && out == "" {
+		_, err = b.reply(message, "No problems found in the store.")
+		return err
+	}
+
+	if len(findings) > 0 {
+		out = out + "Found suspicious chat records:\n"
+	}
+	for _, f := range findings {
+		out = out + fmt.Sprintf("%d (%s): %s\n", f.ChatID, f.Title, f.Reason)
+	}
+	_, err = b.reply(message, out)
+	return err
+}
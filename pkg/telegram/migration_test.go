@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestMigrationUpdate(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	group := &telebot.Chat{ID: -1234, Title: "oncall", Type: telebot.ChatGroup}
+	require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(group, []string{"staging"}, b.environmentsAndOther))
+
+	tb, err := telebot.NewBot(telebot.Settings{Offline: true, Synchronous: true})
+	require.NoError(t, err)
+	tb.Handle(telebot.OnMigration, b.handleMigration)
+	tb.ProcessUpdate(telebot.Update{Message: &telebot.Message{Chat: group, MigrateTo: -1001234}})
+
+	_, err = chats.GetChatInfo(-1234)
+	require.Equal(t, ChatNotFoundErr, err)
+
+	migrated, err := chats.GetChatInfo(-1001234)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1001234), migrated.Chat.ID)
+	require.Equal(t, telebot.ChatSuperGroup, migrated.Chat.Type)
+	require.Equal(t, "oncall", migrated.Chat.Title)
+	require.Equal(t, []string{"staging"}, migrated.MutedEnvironments)
+}
+
+func TestMigrateChatMergesRestartedSupergroup(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	group := &telebot.Chat{ID: -1234, Title: "oncall", Type: telebot.ChatGroup}
+	supergroup := &telebot.Chat{ID: -1001234, Title: "oncall (new)", Type: telebot.ChatSuperGroup}
+	require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteProjects(group, []string{"frontend"}, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(supergroup, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, chats.MigrateChat(group.ID, supergroup.ID))
+
+	list, err := chats.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, supergroup, list[0].Chat)
+	require.Equal(t, []string{"frontend"}, list[0].MutedProjects)
+}
+
+func TestMigrateUnknownChat(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	b.handleMigration(-1, -1001)
+
+	_, err := chats.GetChatInfo(-1001)
+	require.Equal(t, ChatNotFoundErr, err)
+}
+
+func TestRecordDelivery(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, chats.RecordDelivery(testChat.ID, false))
+	require.NoError(t, chats.RecordDelivery(testChat.ID, false))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, ci.FailedSends)
+
+	require.NoError(t, chats.RecordDelivery(testChat.ID, true))
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, ci.FailedSends)
+}
+
+func TestFindStaleMigrationRecords(t *testing.T) {
+	chats := []ChatInfo{
+		{Chat: &telebot.Chat{ID: -1234, Title: "payments", Type: telebot.ChatGroup}},
+		{Chat: &telebot.Chat{ID: -1001234, Title: "payments", Type: telebot.ChatSuperGroup}},
+		{Chat: &telebot.Chat{ID: -5678, Title: "frontend", Type: telebot.ChatGroup}, FailedSends: 5},
+		{Chat: &telebot.Chat{ID: -9999, Title: "healthy", Type: telebot.ChatGroup}, FailedSends: 1},
+		{Chat: &telebot.Chat{ID: -1005678, Title: "failing", Type: telebot.ChatSuperGroup}, FailedSends: 10},
+		{Chat: &telebot.Chat{ID: 42, Username: "elliot", Type: telebot.ChatPrivate}, FailedSends: 10},
+	}
+
+	findings := findStaleMigrationRecords(chats)
+	require.Len(t, findings, 2)
+	require.Equal(t, int64(-1234), findings[0].ChatID)
+	require.Contains(t, findings[0].Reason, "-1001234")
+	require.Equal(t, int64(-5678), findings[1].ChatID)
+	require.Contains(t, findings[1].Reason, "5 failed deliveries")
+}
+
+func TestIsMigratedError(t *testing.T) {
+	require.True(t, isMigratedError(errors.New("telegram unknown: Bad Request: group chat was upgraded to a supergroup chat (400)")))
+	require.False(t, isMigratedError(telebot.ErrChatNotFound))
+	require.False(t, isMigratedError(nil))
+}
@@ -0,0 +1,206 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandMutePreview = "/mute_preview"
+
+	mutePreviewUnique = "mute_preview"
+	// mutePreviewDataMax is what fits into the data of a callback button next to its unique.
+	mutePreviewDataMax = 48
+	// otherValue is what alerts of environments and projects that aren't configured count as.
+	otherValue = "other"
+
+	responseMutePreviewUsage = "Usage: " + CommandMutePreview + " environment[prod] project[billing]"
+)
+
+// muteValue is the value a label counts as for mutes, values that aren't configured are "other".
+func muteValue(value string, configured []string) string {
+	if contains(configured, value) {
+		return value
+	}
+	return otherValue
+}
+
+// chatAlerts keeps the alerts the chat gets: those it didn't mute and at least as severe as its minimum.
+// Webhooks and /mute_preview both filter with it, so a preview can't differ from what gets delivered.
+func (b *Bot) chatAlerts(ci *ChatInfo, alerts template.Alerts) template.Alerts {
+	kept := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		if b.suppressedBy(ci, a) == nil {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// templateAlerts turns alerts of the Alertmanager API into the alerts webhooks carry.
+func templateAlerts(alerts []*types.Alert) template.Alerts {
+	out := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		labels := template.KV{}
+		for k, v := range a.Labels {
+			labels[string(k)] = string(v)
+		}
+		annotations := template.KV{}
+		for k, v := range a.Annotations {
+			annotations[string(k)] = string(v)
+		}
+		out = append(out, template.Alert{
+			Status:       string(a.Status()),
+			Labels:       labels,
+			Annotations:  annotations,
+			StartsAt:     a.StartsAt,
+			EndsAt:       a.EndsAt,
+			GeneratorURL: a.GeneratorURL,
+			Fingerprint:  a.Fingerprint().String(),
+		})
+	}
+	return out
+}
+
+// previewMute returns the alerts the chat gets now and those of them the mute would suppress.
+func (b *Bot) previewMute(ci *ChatInfo, firing template.Alerts, envs []string, prs []string) (template.Alerts, template.Alerts) {
+	muted := *ci
+	muted.MutedEnvironments = append([]string{}, ci.MutedEnvironments...)
+	muted.MutedProjects = append([]string{}, ci.MutedProjects...)
+	muted.MuteEnvironments(envs, b.environmentsAndOther)
+	muted.MuteProjects(prs, b.projectsAndOther)
+
+	current := b.chatAlerts(ci, firing)
+	kept := map[string]bool{}
+	for _, a := range b.chatAlerts(&muted, current) {
+		kept[a.Fingerprint] = true
+	}
+	var suppressed template.Alerts
+	for _, a := range current {
+		if !kept[a.Fingerprint] {
+			suppressed = append(suppressed, a)
+		}
+	}
+	return current, suppressed
+}
+
+// formatMutePreview reads like "This would suppress 14 of 22 currently-firing alerts: HighCPU ×8, DiskFull ×6".
+func formatMutePreview(current template.Alerts, suppressed template.Alerts) string {
+	if len(suppressed) == 0 {
+		return fmt.Sprintf("This would suppress none of %d currently-firing alerts.", len(current))
+	}
+	names, counts := countAlertnames(suppressed)
+	groups := make([]string, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, fmt.Sprintf("%s ×%d", html.EscapeString(name), counts[name]))
+	}
+	return fmt.Sprintf("This would suppress %d of %d currently-firing alerts: %s",
+		len(suppressed), len(current), strings.Join(groups, ", "))
+}
+
+// mutePreviewData encodes the targets of a mute into callback data as "envs;projects".
+func mutePreviewData(envs []string, prs []string) string {
+	return strings.Join(envs, ",") + ";" + strings.Join(prs, ",")
+}
+
+func parseMutePreviewData(data string) ([]string, []string) {
+	parts := strings.SplitN(data, ";", 2)
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, ",")
+	}
+	if len(parts) != 2 {
+		return nil, nil
+	}
+	return split(parts[0]), split(parts[1])
+}
+
+func (b *Bot) handleMutePreview(message *telebot.Message) error {
+	envs, prs, err := parseMuteTargets(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseMutePreviewUsage))
+		return err
+	}
+
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the filters of this chat")
+		return err
+	}
+	receiver := b.resolveReceiver(context.TODO(), ci).Name
+	alerts, err := b.alertmanager.ListAlerts(context.TODO(), receiver, false)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list alerts", "err", err)
+		_, err = b.replyError(message, "failed to list alerts", fmt.Sprintf("failed to list alerts... %v", err))
+		return err
+	}
+
+	current, suppressed := b.previewMute(ci, templateAlerts(alerts), envs, prs)
+	text := formatMutePreview(current, suppressed)
+
+	data := mutePreviewData(envs, prs)
+	if len(data) > mutePreviewDataMax {
+		_, err = b.replyFormatted(message, text+"\n\nUse "+CommandMute+" to apply it.", &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+		return err
+	}
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: mutePreviewUnique, Text: "Mute " + describeTargets(envs, prs), Data: data},
+	}}}
+	_, err = b.replyFormatted(message, text, &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleMutePreviewConfirm(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can mute this chat.")
+		return
+	}
+
+	envs, prs := parseMutePreviewData(c.Data)
+	chat := c.Message.Chat
+	if len(envs) > 0 {
+		if err := b.chats.MuteEnvironments(chat, envs, b.environmentsAndOther); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to mute environments", "chat_id", chat.ID, "err", err)
+			respond(b.storeErrorReply(err, "mute environments"))
+			return
+		}
+	}
+	if len(prs) > 0 {
+		if err := b.chats.MuteProjects(chat, prs, b.projectsAndOther); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to mute projects", "chat_id", chat.ID, "err", err)
+			respond(b.storeErrorReply(err, "mute projects"))
+			return
+		}
+	}
+	respond("")
+
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove mute preview button", "err", err)
+	}
+	if _, err := b.send(chat, "Muted "+describeTargets(envs, prs)+b.receivesNothingWarning(chat.ID), "button mute preview"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send mute confirmation", "err", err)
+	}
+}
@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func previewAlert(name string, env string, project string, severity string) *types.Alert {
+	labels := model.LabelSet{"alertname": model.LabelValue(name), labelSeverity: model.LabelValue(severity)}
+	if env != "" {
+		labels[labelEnvironment] = model.LabelValue(env)
+	}
+	if project != "" {
+		labels[labelProject] = model.LabelValue(project)
+	}
+	return &types.Alert{Alert: model.Alert{Labels: labels, StartsAt: time.Now().Add(-time.Hour), EndsAt: time.Now().Add(time.Hour)}}
+}
+
+func TestParseMuteTargets(t *testing.T) {
+	envs, prs, err := parseMuteTargets("environment[prod] project[billing, frontend]")
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod"}, envs)
+	require.Equal(t, []string{"billing", "frontend"}, prs)
+
+	envs, prs, err = parseMuteTargets("project[billing]")
+	require.NoError(t, err)
+	require.Empty(t, envs)
+	require.Equal(t, []string{"billing"}, prs)
+
+	_, _, err = parseMuteTargets("prod")
+	require.Error(t, err)
+}
+
+// TestMutePreviewMatchesDelivery checks that a confirmed preview suppresses exactly what webhooks stop delivering.
+func TestMutePreviewMatchesDelivery(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{
+		previewAlert("HighCPU", "prod", "billing", "critical"),
+		previewAlert("HighCPU", "prod", "frontend", "critical"),
+		previewAlert("DiskFull", "staging", "billing", "warning"),
+		previewAlert("DiskFull", "qa", "billing", "critical"),
+		previewAlert("Heartbeat", "staging", "frontend", "info"),
+		previewAlert("NoLabels", "", "", "critical"),
+	}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteProjects(testChat, []string{"frontend"}, b.projectsAndOther))
+
+	require.NoError(t, b.handleMutePreview(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "environment[prod, other]"}))
+	require.Equal(t, "This would suppress 3 of 4 currently-firing alerts: DiskFull ×1, HighCPU ×1, NoLabels ×1", tb.lastText())
+	data := buttons(tb.messages()[len(tb.messages())-1])
+	require.Equal(t, []string{"prod,other;"}, data)
+
+	b.handleMutePreviewConfirm(&telebot.Callback{
+		Sender:  testAdmin,
+		Message: &telebot.Message{ID: len(tb.messages()), Chat: testChat},
+		Data:    data[0],
+	})
+	require.Equal(t, "Muted environments prod, other", tb.lastText())
+	muted, err := chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	sort.Strings(muted)
+	require.Equal(t, []string{"other", "prod"}, muted)
+
+	firing := templateAlerts(am.Alerts)
+	_, err = b.processWebhook(context.Background(), alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: firing,
+	}}})
+	require.NoError(t, err)
+	record, err := chats.GetMessage(testChat.ID, len(tb.messages()))
+	require.NoError(t, err)
+	require.Equal(t, []string{firing[2].Fingerprint}, record.Fingerprints)
+
+	require.NoError(t, b.handleMutePreview(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "environment[prod]"}))
+	require.Equal(t, "This would suppress none of 1 currently-firing alerts.", tb.lastText())
+}
+
+func TestMutePreviewConfirmAdminsOnly(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	b.handleMutePreviewConfirm(&telebot.Callback{
+		Sender:  &telebot.User{ID: 999},
+		Message: &telebot.Message{ID: 1, Chat: testChat},
+		Data:    "prod;",
+	})
+	require.Equal(t, "Only admins can mute this chat.", tb.responses[0].Text)
+	muted, err := chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	require.Empty(t, muted)
+}
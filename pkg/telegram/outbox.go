@@ -0,0 +1,153 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+const (
+	telegramOutboxDirectory = "telegram/outbox"
+
+	defaultOutboxMaxAge = 6 * time.Hour
+)
+
+// OutboxEntry is a webhook the bot took in but didn't deliver yet, kept with WithDurableOutbox.
+type OutboxEntry struct {
+	ChatID     int64
+	Seq        int64
+	ReceivedAt time.Time
+	Message    webhook.Message
+}
+
+// WithDurableOutbox writes every webhook to the store before it's delivered and removes it once it's delivered,
+// so that webhooks the bot took in but didn't deliver before it died are delivered when it's started again.
+// It doubles the store writes, so it's off by default.
+func WithDurableOutbox(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.durableOutbox = enabled
+		return nil
+	}
+}
+
+// WithOutboxMaxAge sets how old webhooks left in the outbox can be and still be delivered on startup,
+// older ones are dropped so that alerts of long ago don't come in all of a sudden.
+func WithOutboxMaxAge(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d <= 0 {
+			return fmt.Errorf("the maximum age of outbox entries must be positive, is %s", d)
+		}
+		b.outboxMaxAge = d
+		return nil
+	}
+}
+
+func outboxKey(chatID int64, seq int64) string {
+	return fmt.Sprintf("%s/%d/%d", telegramOutboxDirectory, chatID, seq)
+}
+
+// AddOutbox stores a webhook that's about to be delivered.
+func (s *ChatStore) AddOutbox(e OutboxEntry) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.put(outboxKey(e.ChatID, e.Seq), value)
+}
+
+// RemoveOutbox removes a webhook from the outbox once it's delivered. The removal is written behind,
+// after the records of the messages the webhook was delivered in.
+func (s *ChatStore) RemoveOutbox(chatID int64, seq int64) error {
+	return s.writeBehind(outboxKey(chatID, seq), nil)
+}
+
+// ListOutbox returns the webhooks in the outbox in the order they came in, skipping those that can't be read.
+func (s *ChatStore) ListOutbox() ([]OutboxEntry, error) {
+	entries := []OutboxEntry{}
+	_, err := s.listRecords(telegramOutboxDirectory, func(kv *store.KVPair) error {
+		var e OutboxEntry
+		if err := decode(kv.Key, kv.Value, &e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
+
+// outboxAck removes the webhook from the outbox once it's acked as delivered, before passing the ack on.
+// Webhooks that failed, were dropped because the bot stopped or were left because the replica became
+// a follower stay in the outbox to be delivered by the next leader to start.
+func (b *Bot) outboxAck(e OutboxEntry, next func(error)) func(error) {
+	return func(err error) {
+		if err == nil {
+			if err := b.chats.RemoveOutbox(e.ChatID, e.Seq); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to remove webhook from the outbox", "chat_id", e.ChatID, "seq", e.Seq, "err", err)
+			}
+		}
+		if next != nil {
+			next(err)
+		}
+	}
+}
+
+// writeAhead stores the webhook in the outbox before it's queued. If it can't be stored it's delivered anyway.
+// Load test webhooks aren't stored, they'd be replayed by a later run without the test to ack them to.
+func (b *Bot) writeAhead(w alertmanager.TelegramWebhook, now time.Time) alertmanager.TelegramWebhook {
+	if !b.durableOutbox || isSyntheticWebhook(w) {
+		return w
+	}
+	e := OutboxEntry{ChatID: w.ChatID, Seq: atomic.AddInt64(&b.outboxSeq, 1), ReceivedAt: now, Message: w.Message}
+	if err := b.chats.AddOutbox(e); err != nil {
+		level.Warn(b.webhookLogger(w, nil)).Log("msg", "failed to write webhook to the outbox", "err", err)
+		return w
+	}
+	w.Ack = b.outboxAck(e, w.Ack)
+	return w
+}
+
+// replayOutbox returns the webhooks left in the outbox by an earlier run to deliver them again,
+// dropping those older than the maximum age. Only the leader replays them, followers leave them be.
+func (b *Bot) replayOutbox(now time.Time) []alertmanager.TelegramWebhook {
+	if !b.durableOutbox || !b.leading() {
+		return nil
+	}
+	entries, err := b.chats.ListOutbox()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list the outbox", "err", err)
+		return nil
+	}
+
+	var webhooks []alertmanager.TelegramWebhook
+	for _, e := range entries {
+		if now.Sub(e.ReceivedAt) > b.outboxMaxAge {
+			level.Info(b.logger).Log("msg", "dropping expired webhook from the outbox", "chat_id", e.ChatID, "received_at", e.ReceivedAt)
+			b.outboxExpired.Inc()
+			if err := b.chats.RemoveOutbox(e.ChatID, e.Seq); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to remove webhook from the outbox", "chat_id", e.ChatID, "seq", e.Seq, "err", err)
+			}
+			continue
+		}
+		b.outboxReplayed.Inc()
+		webhooks = append(webhooks, alertmanager.TelegramWebhook{
+			ChatID:  e.ChatID,
+			Message: e.Message,
+			Ack:     b.outboxAck(e, nil),
+		})
+	}
+	if len(webhooks) > 0 {
+		level.Info(b.logger).Log("msg", "delivering webhooks left in the outbox", "count", len(webhooks))
+	}
+	return webhooks
+}
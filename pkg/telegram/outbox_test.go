@@ -0,0 +1,146 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+func outboxWebhook(alertname string) alertmanager.TelegramWebhook {
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{GroupKey: alertname, Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"alertname": alertname}, Fingerprint: alertname}},
+	}}}
+}
+
+func outboxLen(t *testing.T, chats *ChatStore) int {
+	entries, err := chats.ListOutbox()
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestOutboxReplaysAfterCrash(t *testing.T) {
+	opts := []BotOption{WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithDurableOutbox(true)}
+	b, tb, chats := newTestBot(t, opts...)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	replayed := testutil.ToFloat64(b.outboxReplayed)
+
+	// The first run dies while sending: its send hangs and fails in the end.
+	sending, crash := make(chan struct{}), make(chan struct{})
+	tb.beforeSend = func() {
+		close(sending)
+		<-crash
+	}
+	tb.sendErr = func() error { return errors.New("connection reset by peer") }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	webhooks := make(chan alertmanager.TelegramWebhook, 1)
+	go func() { done <- b.sendWebhook(ctx, ctx, webhooks) }()
+	defer func() {
+		close(crash)
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	webhooks <- outboxWebhook("HighCPU")
+	<-sending
+	require.Equal(t, 1, outboxLen(t, chats), "the webhook is in the outbox while it's sent")
+
+	// The restarted bot delivers it.
+	tb2 := &fakeTelebot{}
+	restarted, err := NewBotWithTelegram(chats, tb2, testAdmin.ID, append([]BotOption{WithEnvironments("prod,staging"), WithProjects("billing,frontend")}, opts...)...)
+	require.NoError(t, err)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done2 := make(chan error)
+	go func() { done2 <- restarted.sendWebhook(ctx2, ctx2, make(chan alertmanager.TelegramWebhook)) }()
+
+	require.Eventually(t, func() bool { return len(tb2.messages()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Contains(t, tb2.lastText(), "HighCPU")
+	require.Eventually(t, func() bool { return outboxLen(t, chats) == 0 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(1), testutil.ToFloat64(restarted.outboxReplayed)-replayed)
+
+	cancel2()
+	require.NoError(t, <-done2)
+}
+
+func TestOutboxKeepsDroppedWebhooks(t *testing.T) {
+	b, _, chats := newTestBot(t, WithDurableOutbox(true))
+	now := time.Now()
+
+	var acked []error
+	w := outboxWebhook("HighCPU")
+	w.Ack = func(err error) { acked = append(acked, err) }
+	w = b.writeAhead(w, now)
+	require.Equal(t, 1, outboxLen(t, chats))
+
+	ack(w, errWebhookDropped)
+	require.Equal(t, 1, outboxLen(t, chats), "webhooks dropped on stop are delivered on the next start")
+	ack(w, nil)
+	require.Equal(t, 0, outboxLen(t, chats))
+	require.Equal(t, []error{errWebhookDropped, nil}, acked)
+
+	plain, _, plainChats := newTestBot(t)
+	plain.writeAhead(outboxWebhook("HighCPU"), now)
+	require.Equal(t, 0, outboxLen(t, plainChats), "the outbox is off by default")
+}
+
+func TestOutboxKeepsFailedWebhooks(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithDurableOutbox(true))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	tb.sendErr = func() error { return errors.New("connection reset by peer") }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	webhooks := make(chan alertmanager.TelegramWebhook)
+	go func() { done <- b.sendWebhook(ctx, ctx, webhooks) }()
+	acked := make(chan error, 1)
+	w := outboxWebhook("HighCPU")
+	w.Ack = func(err error) { acked <- err }
+	webhooks <- w
+	require.Error(t, <-acked)
+	cancel()
+	require.NoError(t, <-done)
+	require.Equal(t, 1, outboxLen(t, chats), "webhooks that failed are delivered on the next start")
+}
+
+func TestOutboxExpires(t *testing.T) {
+	b, _, chats := newTestBot(t, WithDurableOutbox(true), WithOutboxMaxAge(time.Hour))
+	expired := testutil.ToFloat64(b.outboxExpired)
+	now := time.Now()
+
+	old := outboxWebhook("DiskFull")
+	require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: old.ChatID, Seq: 1, ReceivedAt: now.Add(-2 * time.Hour), Message: old.Message}))
+	recent := outboxWebhook("HighCPU")
+	require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: recent.ChatID, Seq: 2, ReceivedAt: now.Add(-time.Minute), Message: recent.Message}))
+
+	replay := b.replayOutbox(now)
+	require.Len(t, replay, 1)
+	require.Equal(t, "HighCPU", replay[0].Message.Alerts[0].Labels["alertname"])
+	require.Equal(t, float64(1), testutil.ToFloat64(b.outboxExpired)-expired)
+	require.Equal(t, 1, outboxLen(t, chats), "the expired webhook is removed")
+
+	ack(replay[0], nil)
+	require.Equal(t, 0, outboxLen(t, chats))
+}
+
+func TestOutboxOrder(t *testing.T) {
+	_, _, chats := newTestBot(t)
+	for _, seq := range []int64{30, 4, 200} {
+		require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: -100, Seq: seq, Message: outboxWebhook("A").Message}))
+	}
+	entries, err := chats.ListOutbox()
+	require.NoError(t, err)
+	var seqs []int64
+	for _, e := range entries {
+		seqs = append(seqs, e.Seq)
+	}
+	require.Equal(t, []int64{4, 30, 200}, seqs)
+}
@@ -0,0 +1,217 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandMaxAlerts = "/max_alerts"
+
+	// overflowSummaryMaxLength is the most the summary of alerts not shown adds to a message.
+	overflowSummaryMaxLength = 512
+	// overflowListingsMax is the number of full listings kept for "Show all" buttons.
+	overflowListingsMax = 100
+	showAllUnique       = "show_all"
+
+	// telegramMessageMaxLength is what Telegram accepts per message, in bytes.
+	telegramMessageMaxLength = 4095
+)
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// WithMaxAlerts sets how many alerts a message shows in full, the rest is summarised.
+// 0 shows all alerts. Chats can override it with /max_alerts.
+func WithMaxAlerts(n int) BotOption {
+	return func(b *Bot) error {
+		if n < 0 {
+			return fmt.Errorf("max alerts per message must not be negative, is %d", n)
+		}
+		b.maxAlerts = n
+		return nil
+	}
+}
+
+// maxAlertsFor returns the number of alerts a message to the chat shows in full, 0 for all.
+func (b *Bot) maxAlertsFor(ci *ChatInfo) int {
+	return b.chatFormat(ci).MaxAlerts
+}
+
+// splitOverflow returns the first max alerts to show and the overflowing rest.
+func splitOverflow(alerts template.Alerts, max int) (template.Alerts, template.Alerts) {
+	if max <= 0 || len(alerts) <= max {
+		return alerts, nil
+	}
+	return alerts[:max], alerts[max:]
+}
+
+// summarizeOverflow groups alerts by alertname, the most frequent first, like:
+// …and 67 more: 40× KubePodCrashLooping, 15× TargetDown, …
+// The summary is at most maxLength bytes long, leaving out the least frequent alertnames.
+// With emoji each alertname is marked with the emoji of its most severe alert.
+func summarizeOverflow(alerts template.Alerts, maxLength int, emoji func(alerts template.Alerts) string) string {
+	if len(alerts) == 0 {
+		return ""
+	}
+
+	names, counts := countAlertnames(alerts)
+	summary := fmt.Sprintf("…and %d more", len(alerts))
+	const ellipsis = ", …"
+	byName := map[string]template.Alerts{}
+	if emoji != nil {
+		for _, a := range alerts {
+			byName[a.Labels["alertname"]] = append(byName[a.Labels["alertname"]], a)
+		}
+	}
+	for i, name := range names {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		var mark string
+		if emoji != nil {
+			mark = emoji(byName[name]) + " "
+		}
+		group := fmt.Sprintf("%s%d× %s%s", sep, counts[name], mark, html.EscapeString(name))
+		last := i == len(names)-1
+		if len(summary)+len(group) > maxLength || (!last && len(summary)+len(group)+len(ellipsis) > maxLength) {
+			if len(summary)+len(ellipsis) <= maxLength {
+				summary = summary + ellipsis
+			}
+			break
+		}
+		summary = summary + group
+	}
+	return summary
+}
+
+// countAlertnames counts the alerts by alertname, returning the names the most frequent first.
+func countAlertnames(alerts template.Alerts) ([]string, map[string]int) {
+	counts := map[string]int{}
+	for _, a := range alerts {
+		counts[a.Labels["alertname"]]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names, counts
+}
+
+// plainText turns a message rendered for Telegram's HTML mode into plain text.
+func plainText(s string) string {
+	return html.UnescapeString(htmlTags.ReplaceAllString(s, ""))
+}
+
+// overflowListings keeps the full listings of the latest messages with too many alerts,
+// so that their "Show all" buttons can send them. Listings are lost on restart.
+type overflowListings struct {
+	mu       sync.Mutex
+	max      int
+	keys     []string
+	listings map[string]string
+}
+
+func newOverflowListings(max int) *overflowListings {
+	return &overflowListings{max: max, listings: map[string]string{}}
+}
+
+// add keeps the listing, forgetting the oldest if there are too many, and returns its key.
+func (o *overflowListings) add(listing string) string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	key := hex.EncodeToString(buf)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.keys = append(o.keys, key)
+	o.listings[key] = listing
+	for len(o.keys) > o.max {
+		delete(o.listings, o.keys[0])
+		o.keys = o.keys[1:]
+	}
+	return key
+}
+
+func (o *overflowListings) get(key string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	listing, ok := o.listings[key]
+	return listing, ok
+}
+
+// showAllButton returns the button sending the full listing as a document.
+func showAllButton(key string) telebot.InlineButton {
+	return telebot.InlineButton{Unique: showAllUnique, Text: "Show all", Data: key}
+}
+
+func (b *Bot) handleShowAll(c *telebot.Callback) {
+	listing, ok := b.overflowListings.get(c.Data)
+	if !ok {
+		if err := b.telegram.Respond(c, &telebot.CallbackResponse{Text: "This listing is no longer available."}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+		return
+	}
+	if err := b.telegram.Respond(c); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+	}
+	if c.Message == nil || c.Message.Chat == nil {
+		return
+	}
+
+	doc := &telebot.Document{
+		File:     telebot.FromReader(strings.NewReader(listing)),
+		FileName: "alerts.txt",
+		MIME:     "text/plain",
+	}
+	if _, err := b.send(c.Message.Chat, doc, "button show all"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send all alerts", "err", err)
+	}
+}
+
+// SetMaxAlerts sets the number of alerts a message to the chat shows in full, 0 uses the bot's default.
+func (s *ChatStore) SetMaxAlerts(c *telebot.Chat, max int) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.MaxAlertsPerMessage = max
+		return nil
+	})
+}
+
+func (b *Bot) handleMaxAlerts(message *telebot.Message) error {
+	max, err := strconv.Atoi(strings.TrimSpace(message.Payload))
+	if err != nil || max < 0 {
+		_, err := b.reply(message, "Usage: "+CommandMaxAlerts+" <number>, 0 uses the default")
+		return err
+	}
+
+	if err := b.chats.SetMaxAlerts(message.Chat, max); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set max alerts", "err", err)
+		_, err = b.replyStoreError(message, err, "set max alerts")
+		return err
+	}
+
+	if max == 0 {
+		_, err = b.reply(message, "Messages in this chat show the default number of alerts again.")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Messages in this chat show %d alerts at most, the rest is summarised.", max))
+	return err
+}
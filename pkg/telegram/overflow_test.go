@@ -0,0 +1,123 @@
+package telegram
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func alertsNamed(counts map[string]int) template.Alerts {
+	var alerts template.Alerts
+	for name, n := range counts {
+		for i := 0; i < n; i++ {
+			alerts = append(alerts, template.Alert{Status: "firing", Labels: template.KV{"alertname": name}})
+		}
+	}
+	return alerts
+}
+
+func TestSplitOverflow(t *testing.T) {
+	alerts := alertsNamed(map[string]int{"A": 3})
+	shown, overflow := splitOverflow(alerts, 0)
+	require.Len(t, shown, 3)
+	require.Empty(t, overflow)
+
+	shown, overflow = splitOverflow(alerts, 2)
+	require.Len(t, shown, 2)
+	require.Len(t, overflow, 1)
+}
+
+func TestSummarizeOverflow(t *testing.T) {
+	alerts := alertsNamed(map[string]int{"KubePodCrashLooping": 40, "TargetDown": 15, "DiskFull": 6, "CPUThrottling": 6})
+	require.Equal(t,
+		"…and 67 more: 40× KubePodCrashLooping, 15× TargetDown, 6× CPUThrottling, 6× DiskFull",
+		summarizeOverflow(alerts, overflowSummaryMaxLength, nil),
+	)
+	require.Equal(t, "…and 67 more: 40× KubePodCrashLooping, 15× TargetDown, …", summarizeOverflow(alerts, 70, nil))
+	require.Equal(t, "…and 67 more, …", summarizeOverflow(alerts, 20, nil))
+	require.Equal(t, "", summarizeOverflow(nil, overflowSummaryMaxLength, nil))
+
+	require.Equal(t, "…and 1 more: 1× &lt;script&gt;", summarizeOverflow(alertsNamed(map[string]int{"<script>": 1}), 100, nil))
+
+	many := template.Alerts{}
+	for i := 0; i < 500; i++ {
+		many = append(many, template.Alert{Labels: template.KV{"alertname": strings.Repeat("x", i%50) + "Alert"}})
+	}
+	require.LessOrEqual(t, len(summarizeOverflow(many, overflowSummaryMaxLength, nil)), overflowSummaryMaxLength)
+}
+
+func TestWebhookOverflow(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithMaxAlerts(5),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	alerts := append(alertsNamed(map[string]int{"HighCPU": 5}), alertsNamed(map[string]int{"KubePodCrashLooping": 40, "TargetDown": 35})...)
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{Status: "firing", Alerts: alerts}}}
+
+	d, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.False(t, d.Truncated)
+
+	sent := tb.messages()[0]
+	require.Equal(t, 5, strings.Count(sent.text(), "HighCPU"))
+	require.NotContains(t, sent.text(), "TargetDown</b>")
+	require.True(t, strings.HasSuffix(sent.text(), "\n\n…and 75 more: 40× KubePodCrashLooping, 35× TargetDown"))
+	require.LessOrEqual(t, len(sent.text()), telegramMessageMaxLength)
+
+	markup := sent.options[0].(*telebot.SendOptions).ReplyMarkup
+	button := markup.InlineKeyboard[len(markup.InlineKeyboard)-1][0]
+	require.Equal(t, "Show all", button.Text)
+
+	b.handleShowAll(&telebot.Callback{Data: button.Data, Message: &telebot.Message{Chat: testChat}})
+	doc, ok := tb.messages()[1].what.(*telebot.Document)
+	require.True(t, ok)
+	content, err := ioutil.ReadAll(doc.File.FileReader)
+	require.NoError(t, err)
+	require.Equal(t, 40, strings.Count(string(content), "KubePodCrashLooping"))
+	require.NotContains(t, string(content), "<b>")
+
+	b.handleShowAll(&telebot.Callback{Data: "unknown", Message: &telebot.Message{Chat: testChat}})
+	require.Len(t, tb.messages(), 2)
+	require.Equal(t, "This listing is no longer available.", tb.responses[len(tb.responses)-1].Text)
+}
+
+func TestWebhookTruncatedAlerts(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{
+		Data:            &template.Data{Status: "firing", Alerts: alertsNamed(map[string]int{"HighCPU": 2})},
+		TruncatedAlerts: 998,
+	}}
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(tb.lastText(), "\n\n<i>998 more alerts were left out of this webhook.</i>"))
+}
+
+func TestMaxAlertsPerChat(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithMaxAlerts(20))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMaxAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "3"}))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3, b.maxAlertsFor(ci))
+
+	require.NoError(t, b.handleMaxAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "0"}))
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, 20, b.maxAlertsFor(ci))
+
+	require.NoError(t, b.handleMaxAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "many"}))
+	require.True(t, strings.HasPrefix(tb.lastText(), "Usage: "))
+}
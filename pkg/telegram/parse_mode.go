@@ -0,0 +1,254 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const CommandParseMode = "/parsemode"
+
+// The parse modes a chat can choose, HTML is the default and what templates render.
+const (
+	ParseModeHTML       = "html"
+	ParseModeMarkdownV2 = "markdownv2"
+	ParseModePlain      = "plain"
+)
+
+var parseModes = []string{ParseModeHTML, ParseModeMarkdownV2, ParseModePlain}
+
+var (
+	// htmlTag matches the tags of Telegram's HTML, capturing if it's closing, its name and attributes.
+	htmlTag  = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)([^>]*)>`)
+	hrefAttr = regexp.MustCompile(`href\s*=\s*"([^"]*)"`)
+	// markdownLink matches a link of Telegram's legacy Markdown at the start of the text.
+	markdownLink = regexp.MustCompile(`^\[([^\]]*)\]\(([^)]*)\)`)
+
+	markdownV2Escaper     = strings.NewReplacer(`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`)
+	markdownV2CodeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+	markdownV2URLEscaper  = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+)
+
+// chatParseMode returns the parse mode the chat chose, HTML if it didn't.
+func chatParseMode(ci *ChatInfo) string {
+	if ci == nil || ci.ParseMode == "" {
+		return ParseModeHTML
+	}
+	return ci.ParseMode
+}
+
+// parseModeOf returns the parse mode of the chat, HTML if it's unknown.
+func (b *Bot) parseModeOf(chatID int64) string {
+	ci, err := b.chats.GetChatInfo(chatID)
+	if err != nil {
+		return ParseModeHTML
+	}
+	return chatParseMode(ci)
+}
+
+// formatFor converts text formatted in format, HTML or legacy Markdown, to the parse mode of a chat.
+// HTML chats get the text as is, the others get it converted from HTML, like rendered templates are.
+func formatFor(mode string, text string, format telebot.ParseMode) (string, telebot.ParseMode) {
+	if mode == ParseModeHTML || mode == "" {
+		return text, format
+	}
+	if format == telebot.ModeMarkdown {
+		text = markdownToHTML(text)
+	}
+	if mode == ParseModePlain {
+		return plainText(text), telebot.ModeDefault
+	}
+	return htmlToMarkdownV2(text), telebot.ModeMarkdownV2
+}
+
+// formatTruncated converts header, body and footer like formatFor and truncates the body until the converted text
+// fits in a message, which escaping can make longer than the text it converts. It tells if the body was truncated.
+func (b *Bot) formatTruncated(mode string, header, body, footer string, format telebot.ParseMode) (string, telebot.ParseMode, bool) {
+	if mode != ParseModeHTML && mode != "" && format == telebot.ModeMarkdown {
+		// The body is truncated at its alerts as HTML, which it's converted through anyway.
+		header, body, footer, format = markdownToHTML(header), markdownToHTML(body), markdownToHTML(footer), telebot.ModeHTML
+	}
+	max := telegramMessageMaxLength - len(header) - len(footer)
+	for {
+		truncated := b.truncateMessageTo(body, max)
+		text, parseMode := formatFor(mode, header+truncated+footer, format)
+		if len(text) <= telegramMessageMaxLength || max <= 0 {
+			return text, parseMode, truncated != body
+		}
+		max -= len(text) - telegramMessageMaxLength
+	}
+}
+
+// replyFormatted replies with text, formatted as options.ParseMode says, in the chat's parse mode.
+func (b *Bot) replyFormatted(message *telebot.Message, text string, options *telebot.SendOptions) (*telebot.Message, error) {
+	converted := *options
+	text, converted.ParseMode, _ = b.formatTruncated(b.parseModeOf(message.Chat.ID), "", text, "", options.ParseMode)
+	text = b.checkedHTML(b.logger, text, converted.ParseMode)
+	return b.reply(message, text, &converted)
+}
+
+// htmlToMarkdownV2 converts Telegram's HTML to MarkdownV2, escaping the text and dropping unknown tags.
+func htmlToMarkdownV2(s string) string {
+	var out strings.Builder
+	var links []string
+	var code, pre int
+
+	text := func(t string) {
+		t = html.UnescapeString(t)
+		if code > 0 || pre > 0 {
+			out.WriteString(markdownV2CodeEscaper.Replace(t))
+			return
+		}
+		out.WriteString(markdownV2Escaper.Replace(t))
+	}
+
+	last := 0
+	for _, m := range htmlTag.FindAllStringSubmatchIndex(s, -1) {
+		text(s[last:m[0]])
+		last = m[1]
+
+		closing := m[3] > m[2]
+		switch strings.ToLower(s[m[4]:m[5]]) {
+		case "b", "strong":
+			out.WriteString("*")
+		case "i", "em":
+			out.WriteString("_")
+		case "u", "ins":
+			out.WriteString("__")
+		case "s", "strike", "del":
+			out.WriteString("~")
+		case "code":
+			// Code in a pre block only names the language.
+			if pre == 0 {
+				out.WriteString("`")
+			}
+			if closing {
+				code--
+			} else {
+				code++
+			}
+		case "pre":
+			if closing {
+				out.WriteString("\n```")
+				pre--
+			} else {
+				out.WriteString("```\n")
+				pre++
+			}
+		case "a":
+			if !closing {
+				var href string
+				if attr := hrefAttr.FindStringSubmatch(s[m[6]:m[7]]); attr != nil {
+					href = html.UnescapeString(attr[1])
+				}
+				links = append(links, href)
+				out.WriteString("[")
+				continue
+			}
+			if len(links) == 0 {
+				continue
+			}
+			href := links[len(links)-1]
+			links = links[:len(links)-1]
+			out.WriteString("](" + markdownV2URLEscaper.Replace(href) + ")")
+		case "br":
+			out.WriteString("\n")
+		}
+	}
+	text(s[last:])
+	return out.String()
+}
+
+// markdownToHTML converts Telegram's legacy Markdown to HTML, closing what's left open.
+func markdownToHTML(s string) string {
+	var out strings.Builder
+	var bold, italic bool
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("_*`[", s[i+1]) >= 0:
+			out.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i++
+		case strings.HasPrefix(s[i:], "```"):
+			end := strings.Index(s[i+3:], "```")
+			if end < 0 {
+				out.WriteString(html.EscapeString(s[i:]))
+				i = len(s)
+				continue
+			}
+			out.WriteString("<pre>" + html.EscapeString(s[i+3:i+3+end]) + "</pre>")
+			i += 3 + end + 2
+		case c == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				out.WriteString("`")
+				continue
+			}
+			out.WriteString("<code>" + html.EscapeString(s[i+1:i+1+end]) + "</code>")
+			i += end + 1
+		case c == '*' && bold:
+			out.WriteString("</b>")
+			bold = false
+		case c == '*':
+			out.WriteString("<b>")
+			bold = true
+		case c == '_' && italic:
+			out.WriteString("</i>")
+			italic = false
+		case c == '_':
+			out.WriteString("<i>")
+			italic = true
+		case c == '[' && markdownLink.MatchString(s[i:]):
+			link := markdownLink.FindStringSubmatch(s[i:])
+			out.WriteString(`<a href="` + html.EscapeString(link[2]) + `">` + html.EscapeString(link[1]) + "</a>")
+			i += len(link[0]) - 1
+		default:
+			out.WriteString(html.EscapeString(s[i : i+1]))
+		}
+	}
+	if italic {
+		out.WriteString("</i>")
+	}
+	if bold {
+		out.WriteString("</b>")
+	}
+	return out.String()
+}
+
+// SetParseMode sets the parse mode of the chat, HTML if it's empty.
+func (s *ChatStore) SetParseMode(c *telebot.Chat, mode string) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.ParseMode = mode
+		return nil
+	})
+}
+
+func (b *Bot) handleParseMode(message *telebot.Message) error {
+	mode := strings.ToLower(strings.TrimSpace(message.Payload))
+	valid := false
+	for _, m := range parseModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		_, err := b.reply(message, "Usage: "+CommandParseMode+" "+strings.Join(parseModes, "|"))
+		return err
+	}
+
+	stored := mode
+	if mode == ParseModeHTML {
+		stored = ""
+	}
+	if err := b.chats.SetParseMode(message.Chat, stored); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set parse mode", "err", err)
+		_, err = b.replyStoreError(message, err, "set the parse mode")
+		return err
+	}
+
+	_, err := b.reply(message, fmt.Sprintf("Messages to this chat are formatted as %s now.", mode))
+	return err
+}
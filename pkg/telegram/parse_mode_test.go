@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestFormatFor(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mode     string
+		text     string
+		format   telebot.ParseMode
+		expected string
+		parse    telebot.ParseMode
+	}{
+		{
+			name:     "html as is",
+			mode:     ParseModeHTML,
+			text:     "<b>HighCPU</b> on a &lt;b&gt; host",
+			format:   telebot.ModeHTML,
+			expected: "<b>HighCPU</b> on a &lt;b&gt; host",
+			parse:    telebot.ModeHTML,
+		},
+		{
+			name:     "html chats keep markdown replies",
+			mode:     ParseModeHTML,
+			text:     "*Status*",
+			format:   telebot.ModeMarkdown,
+			expected: "*Status*",
+			parse:    telebot.ModeMarkdown,
+		},
+		{
+			name:     "html to markdownv2",
+			mode:     ParseModeMarkdownV2,
+			text:     `<b>HighCPU</b> (1.5x) on <a href="https://am.example.com/#/alerts?a=(b)">api-1</a>` + "\n<i>load_avg</i> <code>x*y`</code>",
+			format:   telebot.ModeHTML,
+			expected: `*HighCPU* \(1\.5x\) on [api\-1](https://am.example.com/#/alerts?a=(b\))` + "\n_load\\_avg_ `x*y\\``",
+			parse:    telebot.ModeMarkdownV2,
+		},
+		{
+			name:     "pre blocks to markdownv2",
+			mode:     ParseModeMarkdownV2,
+			text:     `<pre><code class="language-yaml">url: a_b &amp; c</code></pre>`,
+			format:   telebot.ModeHTML,
+			expected: "```\nurl: a_b & c\n```",
+			parse:    telebot.ModeMarkdownV2,
+		},
+		{
+			name:     "markdown to markdownv2",
+			mode:     ParseModeMarkdownV2,
+			text:     "*AlertManager*\nVersion: 0.21.0\n```alertname=\"Disk_Full\"```\n[docs](https://example.com) a\\_b",
+			format:   telebot.ModeMarkdown,
+			expected: "*AlertManager*\nVersion: 0\\.21\\.0\n```\nalertname=\"Disk_Full\"\n```\n[docs](https://example.com) a\\_b",
+			parse:    telebot.ModeMarkdownV2,
+		},
+		{
+			name:     "plain strips html",
+			mode:     ParseModePlain,
+			text:     "<b>HighCPU</b> &amp; <i>load</i>\n<a href=\"https://example.com\">more</a>",
+			format:   telebot.ModeHTML,
+			expected: "HighCPU & load\nmore",
+			parse:    telebot.ModeDefault,
+		},
+		{
+			name:     "plain strips markdown",
+			mode:     ParseModePlain,
+			text:     "*Checks*\ntemplates: OK\n`code` a\\_b",
+			format:   telebot.ModeMarkdown,
+			expected: "Checks\ntemplates: OK\ncode a_b",
+			parse:    telebot.ModeDefault,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text, parse := formatFor(tc.mode, tc.text, tc.format)
+			require.Equal(t, tc.expected, text)
+			require.Equal(t, tc.parse, parse)
+		})
+	}
+}
+
+func TestParseModeNotifications(t *testing.T) {
+	webhook := mixedWebhook("firing", template.Alert{
+		Status:      "firing",
+		Labels:      template.KV{"alertname": "High_CPU", "severity": "critical"},
+		Annotations: template.KV{"summary": "Load is 2.5 <b>times</b> the usual"},
+	})
+
+	for _, tc := range []struct {
+		mode     string
+		parse    telebot.ParseMode
+		contains string
+		excludes string
+	}{
+		{mode: ParseModeHTML, parse: telebot.ModeHTML, contains: "<b>High_CPU</b>"},
+		{mode: ParseModeMarkdownV2, parse: telebot.ModeMarkdownV2, contains: `*High\_CPU*`, excludes: "<b>"},
+		{mode: ParseModePlain, parse: telebot.ModeDefault, contains: "🔥 High_CPU 🔥\n", excludes: "<b>High_CPU"},
+		{mode: ParseModePlain, parse: telebot.ModeDefault, contains: "Load is 2.5 <b>times</b> the usual", excludes: "&lt;"},
+	} {
+		t.Run(tc.mode+" "+tc.contains, func(t *testing.T) {
+			b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, b.handleParseMode(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: tc.mode}))
+			require.Equal(t, "Messages to this chat are formatted as "+tc.mode+" now.", tb.lastText())
+
+			_, err := b.processWebhook(context.Background(), webhook)
+			require.NoError(t, err)
+
+			sent := tb.messages()[len(tb.messages())-1]
+			require.Equal(t, tc.parse, sent.options[0].(*telebot.SendOptions).ParseMode)
+			require.Contains(t, sent.text(), tc.contains)
+			if tc.excludes != "" {
+				require.False(t, strings.Contains(sent.text(), tc.excludes), sent.text())
+			}
+		})
+	}
+}
+
+func TestParseModeNotificationsNearLimit(t *testing.T) {
+	var alerts []template.Alert
+	for i := 0; i < 30; i++ {
+		alerts = append(alerts, template.Alert{
+			Status:      "firing",
+			Labels:      template.KV{"alertname": fmt.Sprintf("Disk_Full_%d", i)},
+			Annotations: template.KV{"summary": strings.Repeat("v1.2-rc.3 ", 12)},
+		})
+	}
+	webhook := mixedWebhook("firing", alerts...)
+
+	for _, mode := range []string{ParseModeHTML, ParseModeMarkdownV2} {
+		t.Run(mode, func(t *testing.T) {
+			b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithMaxAlerts(100))
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, b.handleParseMode(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: mode}))
+
+			d, err := b.processWebhook(context.Background(), webhook)
+			require.NoError(t, err)
+			require.True(t, d.Truncated)
+			sent := tb.lastText()
+			require.LessOrEqual(t, len(sent), telegramMessageMaxLength, "the message is truncated once it's converted")
+			require.Greater(t, len(sent), telegramMessageMaxLength-1000, "the message isn't truncated more than needed")
+			require.Contains(t, sent, "Disk")
+		})
+	}
+
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetParseMode(testChat, ParseModeMarkdownV2))
+	_, err := b.replyFormatted(&telebot.Message{Chat: testChat}, b.truncateMessage(strings.Repeat("<b>v1.2</b>\n\n", 400)), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(tb.lastText()), telegramMessageMaxLength)
+}
+
+func TestHandleParseMode(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleParseMode(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "markdown"}))
+	require.Equal(t, "Usage: /parsemode html|markdownv2|plain", tb.lastText())
+
+	require.NoError(t, b.handleParseMode(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "Plain"}))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, ParseModePlain, ci.ParseMode)
+
+	require.NoError(t, b.handleID(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	sent := tb.messages()[len(tb.messages())-1]
+	require.Equal(t, telebot.ModeDefault, sent.options[0].(*telebot.SendOptions).ParseMode, "canned replies follow the chat's mode")
+	require.False(t, strings.Contains(sent.text(), "`"), sent.text())
+
+	require.NoError(t, b.handleParseMode(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "html"}))
+	ci, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.ParseMode)
+}
@@ -0,0 +1,274 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+const (
+	telegramPollDirectory = "telegram/poll"
+
+	// defaultPollPace is the least time between two requests of a poll to Alertmanager.
+	defaultPollPace = 200 * time.Millisecond
+	// pollWebhookQuiet is how long a chat must go without webhooks before it's polled for,
+	// so that chats getting webhooks aren't notified twice.
+	pollWebhookQuiet = 24 * time.Hour
+	// pollTimeout is the longest a chat's poll waits for Alertmanager.
+	pollTimeout = 30 * time.Second
+)
+
+// errNoPollState is returned for chats that were never polled for.
+var errNoPollState = errors.New("no poll state")
+
+// WithPollAlerts asks Alertmanager for the alerts of each chat's receiver every interval and notifies the chat
+// about those that started firing or resolved since, for Alertmanagers that can't send webhooks. 0 doesn't.
+// Chats that got a webhook within the last day aren't polled for.
+func WithPollAlerts(interval time.Duration) BotOption {
+	return func(b *Bot) error {
+		if interval < 0 {
+			return fmt.Errorf("the poll interval must not be negative, is %s", interval)
+		}
+		b.pollInterval = interval
+		return nil
+	}
+}
+
+// PollState is what the last poll saw of a chat's alerts.
+type PollState struct {
+	// Alerts are the active alerts of the chat's receiver, by fingerprint.
+	Alerts map[string]template.Alert `json:",omitempty"`
+	// LastWebhook is when the chat last got a webhook.
+	LastWebhook time.Time `json:",omitempty"`
+	// Baseline takes the alerts of the next poll as seen without notifying about them,
+	// for chats that got webhooks until then.
+	Baseline bool `json:",omitempty"`
+}
+
+func pollStateKey(id int64) string {
+	return fmt.Sprintf("%s/%d", telegramPollDirectory, id)
+}
+
+// GetPollState returns what the last poll saw of the chat's alerts, an empty state if it was never polled for.
+func (s *ChatStore) GetPollState(id int64) (*PollState, error) {
+	kv, err := s.get(pollStateKey(id), errNoPollState)
+	if errors.Is(err, errNoPollState) {
+		return &PollState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state PollState
+	if err := decode(kv.Key, kv.Value, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetPollState stores what the last poll saw of the chat's alerts.
+func (s *ChatStore) SetPollState(id int64, state PollState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.put(pollStateKey(id), value)
+}
+
+// webhookActivity remembers when each chat last got a webhook, for the poll to leave it alone.
+// It's only written to the store by the poll, so that webhooks cost no extra writes.
+type webhookActivity struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+func newWebhookActivity() *webhookActivity {
+	return &webhookActivity{last: map[int64]time.Time{}}
+}
+
+func (a *webhookActivity) webhook(chatID int64, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last[chatID] = at
+}
+
+func (a *webhookActivity) lastWebhook(chatID int64) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last[chatID]
+}
+
+// diffPolled returns the alerts that started firing since the last poll, followed by those that are gone,
+// which are resolved as of now. Both are sorted by fingerprint.
+func diffPolled(seen, current map[string]template.Alert, now time.Time) template.Alerts {
+	var firing, resolved template.Alerts
+	for fp, a := range current {
+		if _, ok := seen[fp]; !ok {
+			firing = append(firing, a)
+		}
+	}
+	for fp, a := range seen {
+		if _, ok := current[fp]; !ok {
+			a.Status = "resolved"
+			a.EndsAt = now
+			resolved = append(resolved, a)
+		}
+	}
+	byFingerprint := func(alerts template.Alerts) {
+		sort.Slice(alerts, func(i, j int) bool { return alerts[i].Fingerprint < alerts[j].Fingerprint })
+	}
+	byFingerprint(firing)
+	byFingerprint(resolved)
+	return append(firing, resolved...)
+}
+
+// pollWebhook is the synthetic webhook telling the chat about the alerts that changed.
+func pollWebhook(chatID int64, receiver string, alerts template.Alerts) alertmanager.TelegramWebhook {
+	labels := make([]template.KV, len(alerts))
+	annotations := make([]template.KV, len(alerts))
+	status := "resolved"
+	for i, a := range alerts {
+		labels[i], annotations[i] = a.Labels, a.Annotations
+		if a.Status == "firing" {
+			status = "firing"
+		}
+	}
+	return alertmanager.TelegramWebhook{
+		ChatID: chatID,
+		Message: webhook.Message{
+			Data: &template.Data{
+				Receiver:          receiver,
+				Status:            status,
+				Alerts:            alerts,
+				GroupLabels:       template.KV{},
+				CommonLabels:      commonKV(labels),
+				CommonAnnotations: commonKV(annotations),
+			},
+			Version:  "4",
+			GroupKey: "poll:" + receiver,
+		},
+	}
+}
+
+func (b *Bot) runPollAlerts(ctx context.Context) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+	for {
+		if b.leading() {
+			if err := b.pollAlerts(ctx, time.Now()); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to poll alerts", "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollAlerts polls Alertmanager for the alerts of every chat that gets no webhooks, waiting the poll pace
+// between two requests. Nothing is polled for during maintenance, the changes are noticed after it.
+func (b *Bot) pollAlerts(ctx context.Context, now time.Time) error {
+	if b.activeMaintenance(now) != nil {
+		return nil
+	}
+	chats, err := b.chats.List()
+	if err != nil {
+		return err
+	}
+
+	var last time.Time
+	for _, ci := range chats {
+		if ci.Unreachable || ci.Paused {
+			continue
+		}
+		logger := log.With(b.logger, "chat_id", ci.Chat.ID)
+		state, err := b.chats.GetPollState(ci.Chat.ID)
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to get poll state", "err", err)
+			continue
+		}
+		if b.skipPoll(logger, ci.Chat.ID, state, now) {
+			continue
+		}
+
+		if wait := b.pollPace - time.Since(last); !last.IsZero() && wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		last = time.Now()
+		if err := b.pollChat(ctx, logger, ci, state, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipPoll tells if the chat got a webhook recently and isn't polled for. The next poll after webhooks stop
+// coming in only notes the chat's alerts, the webhooks told about them already.
+func (b *Bot) skipPoll(logger log.Logger, chatID int64, state *PollState, now time.Time) bool {
+	lastWebhook := state.LastWebhook
+	if at := b.webhookActivity.lastWebhook(chatID); at.After(lastWebhook) {
+		lastWebhook = at
+	}
+	if lastWebhook.IsZero() || now.Sub(lastWebhook) >= pollWebhookQuiet {
+		return false
+	}
+	if !lastWebhook.Equal(state.LastWebhook) || !state.Baseline || state.Alerts != nil {
+		level.Debug(logger).Log("msg", "not polling for chat getting webhooks", "last_webhook", lastWebhook)
+		if err := b.chats.SetPollState(chatID, PollState{LastWebhook: lastWebhook, Baseline: true}); err != nil {
+			level.Warn(logger).Log("msg", "failed to set poll state", "err", err)
+		}
+	}
+	return true
+}
+
+// pollChat notifies the chat about the alerts of its receiver that changed since the last poll.
+// The state is only saved once the chat was notified, so a failed notification is retried by the next poll.
+// Only errors that should stop the bot are returned.
+func (b *Bot) pollChat(ctx context.Context, logger log.Logger, ci ChatInfo, state *PollState, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	receiver := b.resolveReceiver(ctx, &ci).Name
+	alerts, err := b.alertmanager.ListAlerts(ctx, receiver, false)
+	if err != nil {
+		level.Warn(logger).Log("msg", "failed to list alerts for poll", "receiver", receiver, "err", err)
+		return nil
+	}
+	current := map[string]template.Alert{}
+	for _, a := range templateAlerts(alerts) {
+		if a.Status != "resolved" {
+			current[a.Fingerprint] = a
+		}
+	}
+
+	if changed := diffPolled(state.Alerts, current, now); len(changed) > 0 && !state.Baseline {
+		level.Debug(logger).Log("msg", "notifying about polled alerts", "receiver", receiver, "changed", len(changed))
+		d, err := b.processWebhook(ctx, pollWebhook(ci.Chat.ID, receiver, changed))
+		if err != nil {
+			return err
+		}
+		if d.Failed != nil {
+			level.Warn(logger).Log("msg", "failed to notify about polled alerts, retrying with the next poll", "err", d.Failed)
+			return nil
+		}
+	}
+	if err := b.chats.SetPollState(ci.Chat.ID, PollState{Alerts: current, LastWebhook: state.LastWebhook}); err != nil {
+		level.Warn(logger).Log("msg", "failed to set poll state", "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+)
+
+func TestDiffPolled(t *testing.T) {
+	now := time.Now()
+	a := template.Alert{Status: "firing", Fingerprint: "a"}
+	b := template.Alert{Status: "firing", Fingerprint: "b"}
+	c := template.Alert{Status: "firing", Fingerprint: "c"}
+
+	changed := diffPolled(map[string]template.Alert{"a": a, "b": b}, map[string]template.Alert{"b": b, "c": c}, now)
+	require.Len(t, changed, 2)
+	require.Equal(t, c, changed[0], "the new firing alerts go first")
+	require.Equal(t, "a", changed[1].Fingerprint)
+	require.Equal(t, "resolved", changed[1].Status)
+	require.Equal(t, now, changed[1].EndsAt)
+
+	require.Empty(t, diffPolled(map[string]template.Alert{"a": a}, map[string]template.Alert{"a": a}, now))
+}
+
+func TestPollAlerts(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Project("billing").Annotation("summary", "CPU is busy").Build()
+	diskFull := alertmanagertest.Alert("DiskFull").Project("billing").Build()
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{highCPU}}
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithAlertmanager(am),
+		WithPollAlerts(time.Minute),
+	)
+	b.pollPace = 0
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ctx := context.Background()
+
+	// The first poll notifies about the alerts firing already.
+	require.NoError(t, b.pollAlerts(ctx, time.Now()))
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "HighCPU")
+	require.Contains(t, tb.lastText(), "CPU is busy")
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListAlerts))
+
+	require.NoError(t, b.pollAlerts(ctx, time.Now()))
+	require.Len(t, tb.messages(), 1, "nothing changed")
+
+	// The second cycle sees HighCPU resolve and DiskFull fire.
+	am.Alerts = []*types.Alert{diskFull}
+	require.NoError(t, b.pollAlerts(ctx, time.Now()))
+	msgs := tb.messages()
+	require.Len(t, msgs, 3, "firing and resolved alerts are sent apart")
+	require.Contains(t, msgs[1].text(), "DiskFull")
+	require.NotContains(t, msgs[1].text(), "HighCPU")
+	require.Contains(t, msgs[2].text(), "HighCPU")
+	require.Contains(t, msgs[2].text(), "resolved")
+
+	state, err := chats.GetPollState(testChat.ID)
+	require.NoError(t, err)
+	require.Len(t, state.Alerts, 1)
+	require.Equal(t, "DiskFull", state.Alerts[diskFull.Fingerprint().String()].Labels["alertname"])
+
+	// The seen alerts are kept in the store, a restarted bot doesn't notify about them again.
+	b.webhookActivity = newWebhookActivity()
+	require.NoError(t, b.pollAlerts(ctx, time.Now()))
+	require.Len(t, tb.messages(), 3)
+}
+
+func TestPollAlertsRetriesFailedNotifications(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{alertmanagertest.Alert("HighCPU").Build()}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithPollAlerts(time.Minute))
+	b.pollPace = 0
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	tb.sendErr = func() error { return errors.New("telegram: internal server error (500)") }
+	require.NoError(t, b.pollAlerts(context.Background(), time.Now()))
+	state, err := chats.GetPollState(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, state.Alerts, "the alerts aren't seen until the chat was told about them")
+
+	tb.sendErr = nil
+	require.NoError(t, b.pollAlerts(context.Background(), time.Now()))
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "HighCPU")
+}
+
+func TestPollAlertsSkipsChatsGettingWebhooks(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Build()
+	diskFull := alertmanagertest.Alert("DiskFull").Build()
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{highCPU}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithPollAlerts(time.Minute))
+	b.pollPace = 0
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ctx := context.Background()
+
+	now := time.Now()
+	b.webhookActivity.webhook(testChat.ID, now)
+	require.NoError(t, b.pollAlerts(ctx, now.Add(time.Hour)))
+	require.Zero(t, am.CallCount(alertmanagertest.MethodListAlerts))
+	require.Empty(t, tb.messages())
+
+	// The last webhook is kept in the store, so a restart doesn't start polling for the chat.
+	b.webhookActivity = newWebhookActivity()
+	require.NoError(t, b.pollAlerts(ctx, now.Add(2*time.Hour)))
+	require.Zero(t, am.CallCount(alertmanagertest.MethodListAlerts))
+
+	// Once webhooks stopped coming in, the first poll only notes the alerts the webhooks told about.
+	require.NoError(t, b.pollAlerts(ctx, now.Add(25*time.Hour)))
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListAlerts))
+	require.Empty(t, tb.messages())
+
+	am.Alerts = []*types.Alert{highCPU, diskFull}
+	require.NoError(t, b.pollAlerts(ctx, now.Add(26*time.Hour)))
+	require.Len(t, tb.messages(), 1)
+	require.Contains(t, tb.lastText(), "DiskFull")
+	require.NotContains(t, tb.lastText(), "HighCPU")
+}
+
+func TestPollAlertsPace(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{}
+	b, _, chats := newTestBot(t, WithAlertmanager(am), WithPollAlerts(time.Minute))
+	b.pollPace = 50 * time.Millisecond
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	start := time.Now()
+	require.NoError(t, b.pollAlerts(context.Background(), time.Now()))
+	require.Equal(t, 2, am.CallCount(alertmanagertest.MethodListAlerts))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
+
+func TestPollAlertsDuringMaintenance(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{alertmanagertest.Alert("HighCPU").Build()}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithPollAlerts(time.Minute))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now(), Until: time.Now().Add(time.Hour)}))
+
+	require.NoError(t, b.pollAlerts(context.Background(), time.Now()))
+	require.Zero(t, am.CallCount(alertmanagertest.MethodListAlerts))
+	require.Empty(t, tb.messages())
+}
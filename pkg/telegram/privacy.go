@@ -0,0 +1,329 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandMyData   = "/mydata"
+	CommandForgetMe = "/forgetme"
+
+	forgetMeUnique = "forget_me"
+)
+
+// The categories of what the store keeps about a chat, in the order /mydata and /forgetme list them.
+const (
+	chatDataChat     = "chat"
+	chatDataMessages = "messages"
+	chatDataOutbox   = "outbox"
+	chatDataRemoved  = "removed chat"
+	chatDataPoll     = "poll state"
+	chatDataRepeats  = "repeat deliveries"
+	chatDataWatcher  = "subscription watcher"
+	chatDataSentLog  = "sent log"
+)
+
+var chatDataCategories = []string{chatDataChat, chatDataMessages, chatDataOutbox, chatDataRemoved, chatDataPoll, chatDataRepeats, chatDataWatcher, chatDataSentLog}
+
+// ChatData is everything the store keeps about a chat, as /mydata exports it.
+type ChatData struct {
+	Chat      *ChatInfo       `json:"chat,omitempty"`
+	Messages  []MessageRecord `json:"messages,omitempty"`
+	Outbox    []OutboxEntry   `json:"outbox,omitempty"`
+	Removed   *ChatInfo       `json:"removed_chat,omitempty"`
+	PollState *PollState      `json:"poll_state,omitempty"`
+	Repeats   []RepeatRecord  `json:"repeats,omitempty"`
+	Watcher   *Watcher        `json:"watcher,omitempty"`
+	SentLog   []SentMessage   `json:"sent_log,omitempty"`
+}
+
+// sentLogForgetter is a SentLog that can forget what was sent to a chat.
+type sentLogForgetter interface {
+	SentLog
+	// Forget removes the messages recorded for the chat and returns how many there were.
+	Forget(chatID int64) (int, error)
+}
+
+// Forget removes the chat's log and returns how many messages it had.
+func (s *KVSink) Forget(chatID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent, err := s.Sent(chatID)
+	if err != nil || len(sent) == 0 {
+		return 0, err
+	}
+	return len(sent), s.kv.Delete(s.key(chatID))
+}
+
+// chatKeys returns the keys the store has for the chat, by category. Records of all chats, like the routes and
+// fingerprints, aren't any chat's.
+func (s *ChatStore) chatKeys(id int64) (map[string][]string, error) {
+	// The chat's buffered records are written first, for them to be read and removed too.
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	keys := map[string][]string{}
+	for category, key := range map[string]string{
+		chatDataChat:     chatKey(id),
+		chatDataRemoved:  removedChatKey(id),
+		chatDataPoll:     pollStateKey(id),
+		chatDataMessages: messageBatchKey(id),
+		chatDataWatcher:  watcherKey(id),
+	} {
+		_, err := s.get(key, errKeyMissing)
+		if errors.Is(err, errKeyMissing) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys[category] = []string{key}
+	}
+	for category, dir := range map[string]string{
+		chatDataMessages: telegramMessagesDirectory,
+		chatDataOutbox:   telegramOutboxDirectory,
+		chatDataRepeats:  telegramRepeatsDirectory,
+	} {
+		// The trailing slash keeps the prefix from matching chats whose ID starts with this one's.
+		prefix := fmt.Sprintf("%s/%d/", dir, id)
+		kvPairs, err := s.list(prefix, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvPairs {
+			if strings.HasPrefix(kv.Key, prefix) {
+				keys[category] = append(keys[category], kv.Key)
+			}
+		}
+		sort.Strings(keys[category])
+	}
+	return keys, nil
+}
+
+// ChatData returns everything the store keeps about the chat, without the sent log, which is kept by a sink.
+func (s *ChatStore) ChatData(id int64) (*ChatData, error) {
+	keys, err := s.chatKeys(id)
+	if err != nil {
+		return nil, err
+	}
+	var data ChatData
+	for _, category := range chatDataCategories {
+		for _, key := range keys[category] {
+			kv, err := s.get(key, errKeyMissing)
+			if errors.Is(err, errKeyMissing) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch category {
+			case chatDataChat:
+				data.Chat = &ChatInfo{}
+				err = decode(key, kv.Value, data.Chat)
+			case chatDataRemoved:
+				data.Removed = &ChatInfo{}
+				err = decode(key, kv.Value, data.Removed)
+			case chatDataPoll:
+				data.PollState = &PollState{}
+				err = decode(key, kv.Value, data.PollState)
+			case chatDataMessages:
+				if key == messageBatchKey(id) {
+					var records []MessageRecord
+					err = decode(key, kv.Value, &records)
+					data.Messages = append(data.Messages, records...)
+					break
+				}
+				var r MessageRecord
+				err = decode(key, kv.Value, &r)
+				data.Messages = append(data.Messages, r)
+			case chatDataOutbox:
+				var e OutboxEntry
+				err = decode(key, kv.Value, &e)
+				data.Outbox = append(data.Outbox, e)
+			case chatDataRepeats:
+				var r RepeatRecord
+				err = decode(key, kv.Value, &r)
+				data.Repeats = append(data.Repeats, r)
+			case chatDataWatcher:
+				data.Watcher = &Watcher{}
+				err = decode(key, kv.Value, data.Watcher)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &data, nil
+}
+
+// ForgetChat removes every key the store has for the chat, which unsubscribes it, and returns how many
+// records it removed by category.
+func (s *ChatStore) ForgetChat(id int64) (map[string]int, error) {
+	keys, err := s.chatKeys(id)
+	if err != nil {
+		return nil, err
+	}
+	removed := map[string]int{}
+	for category, categoryKeys := range keys {
+		for _, key := range categoryKeys {
+			records := 1
+			if key == messageBatchKey(id) {
+				// A batch that can't be read counts as a record.
+				if batch, err := s.messageBatch(id); err == nil {
+					records = len(batch)
+				}
+			}
+			if err := s.delete(key); err != nil {
+				return removed, err
+			}
+			removed[category] += records
+		}
+	}
+	return removed, nil
+}
+
+// ownPrivacyCommand tells if the message is /mydata or /forgetme sent by the user of a private chat,
+// who may ask about their data without being an admin.
+func ownPrivacyCommand(m *telebot.Message) bool {
+	command := strings.Split(strings.Fields(m.Text + " ")[0], "@")[0]
+	return (command == CommandMyData || command == CommandForgetMe) &&
+		m.Chat != nil && m.Sender != nil && m.Chat.Type == telebot.ChatPrivate && m.Chat.ID == int64(m.Sender.ID)
+}
+
+// formatChatData sums up the chat's data for /mydata.
+func formatChatData(data *ChatData) string {
+	lines := []string{"This is what the bot stores about this chat:"}
+	if ci := data.Chat; ci != nil {
+		lines = append(lines,
+			fmt.Sprintf("Subscribed as %s, to environments %s and projects %s", chatName(ci.Chat), formatList(ci.AlertEnvironments), formatList(ci.AlertProjects)),
+			fmt.Sprintf("Muted environments %s and projects %s", formatList(ci.MutedEnvironments), formatList(ci.MutedProjects)),
+			fmt.Sprintf("Failed deliveries in a row: %d", ci.FailedSends),
+		)
+		if len(ci.Tags) > 0 {
+			lines = append(lines, "Tags: "+formatList(ci.Tags))
+		}
+	} else {
+		lines = append(lines, "Not subscribed")
+	}
+	if data.Removed != nil {
+		lines = append(lines, "A record of the chat from before reconciliation removed it")
+	}
+	lines = append(lines,
+		fmt.Sprintf("Notifications tracked: %d", len(data.Messages)),
+		fmt.Sprintf("Webhooks waiting to be delivered: %d", len(data.Outbox)),
+		fmt.Sprintf("Messages in the sent log: %d", len(data.SentLog)),
+	)
+	if data.PollState != nil {
+		lines = append(lines, fmt.Sprintf("Alerts seen by polling Alertmanager: %d", len(data.PollState.Alerts)))
+	}
+	if len(data.Repeats) > 0 {
+		lines = append(lines, fmt.Sprintf("Deliveries tracked for repeat suppressions: %d", len(data.Repeats)))
+	}
+	if data.Watcher != nil {
+		lines = append(lines, "Told about the changes of subscriptions since "+data.Watcher.Since.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	lines = append(lines, "", "The attached JSON document has all of it, "+CommandForgetMe+" erases it.")
+	return strings.Join(lines, "\n")
+}
+
+// formatForgotten tells how many records of each category /forgetme removed.
+func formatForgotten(removed map[string]int) string {
+	counts := make([]string, 0, len(chatDataCategories))
+	for _, category := range chatDataCategories {
+		counts = append(counts, fmt.Sprintf("%s %d", category, removed[category]))
+	}
+	return "Erased everything the bot stored about this chat: " + strings.Join(counts, ", ") +
+		".\nThe chat gets no more alerts, " + CommandStart + " subscribes it again."
+}
+
+func (b *Bot) handleMyData(message *telebot.Message) error {
+	now := time.Now()
+	data, err := b.chats.ChatData(message.Chat.ID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat data", "err", err)
+		_, err = b.replyStoreError(message, err, "get the data of this chat")
+		return err
+	}
+	if sentLog := b.sentLog(); sentLog != nil {
+		if data.SentLog, err = sentLog.Sent(message.Chat.ID); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to get sent log", "err", err)
+		}
+	}
+
+	out, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		_, err = b.respondFailed(message, "export the data of this chat", err)
+		return err
+	}
+	if _, err := b.reply(message, formatChatData(data)); err != nil {
+		return err
+	}
+	_, err = b.reply(message, &telebot.Document{
+		File:     telebot.FromReader(strings.NewReader(string(out) + "\n")),
+		FileName: fmt.Sprintf("mydata-%d-%s.json", message.Chat.ID, now.UTC().Format("20060102T150405Z")),
+		MIME:     "application/json",
+	})
+	return err
+}
+
+func (b *Bot) handleForgetMe(message *telebot.Message) error {
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: forgetMeUnique, Text: "Erase all data of this chat", Data: strconv.FormatInt(message.Chat.ID, 10)},
+	}}}
+	_, err := b.reply(message, "This erases everything the bot stores about this chat and unsubscribes it. "+
+		"It can't be undone.", &telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleForgetMeConfirm(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil || c.Sender == nil || c.Data != strconv.FormatInt(c.Message.Chat.ID, 10) {
+		respond("")
+		return
+	}
+	chat := c.Message.Chat
+	if !b.isAdminID(c.Sender.ID) && !(chat.Type == telebot.ChatPrivate && chat.ID == int64(c.Sender.ID)) {
+		respond("Only admins can erase the data of this chat.")
+		return
+	}
+
+	removed, err := b.chats.ForgetChat(chat.ID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to forget chat", "chat_id", chat.ID, "err", err)
+		respond(b.storeErrorReply(err, "erase the data of this chat"))
+		return
+	}
+	if forgetter, ok := b.sentLog().(sentLogForgetter); ok {
+		if removed[chatDataSentLog], err = forgetter.Forget(chat.ID); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to forget sent log", "chat_id", chat.ID, "err", err)
+		}
+	}
+	level.Info(b.logger).Log("msg", "erased the data of a chat", "chat_id", chat.ID, "sender_id", c.Sender.ID)
+	respond("")
+
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove forget button", "err", err)
+	}
+	if _, err := b.send(chat, formatForgotten(removed), "button forget me"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send forget confirmation", "err", err)
+	}
+}
@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// storeChatData stores a record of every category for the chat.
+func storeChatData(t *testing.T, b *Bot, chats *ChatStore, chat *telebot.Chat) {
+	t.Helper()
+	require.NoError(t, chats.AddChat(chat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: chat.ID, MessageID: 1, SentAt: time.Now()}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: chat.ID, MessageID: 2, SentAt: time.Now()}))
+	require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: chat.ID, Seq: 1, Message: webhook.Message{Data: &template.Data{}}}))
+	require.NoError(t, chats.SetPollState(chat.ID, PollState{Alerts: map[string]template.Alert{"a": {Status: "firing"}}}))
+}
+
+func TestForgetChat(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	// 1234 starts with the ID of testChat, its records are another chat's.
+	other := &telebot.Chat{ID: 1234, Type: telebot.ChatPrivate}
+	storeChatData(t, b, chats, testChat)
+	storeChatData(t, b, chats, other)
+	for _, chat := range []*telebot.Chat{testChat, other} {
+		require.NoError(t, chats.SetWatcher(chat.ID, true, time.Now()))
+	}
+	require.NoError(t, chats.SoftDeleteChat(testChat.ID))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.Flush())
+	before, err := chats.KeyCounts()
+	require.NoError(t, err)
+
+	data, err := chats.ChatData(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, testChat.ID, data.Chat.Chat.ID)
+	require.Equal(t, testChat.ID, data.Removed.Chat.ID)
+	require.Len(t, data.Messages, 2)
+	require.Len(t, data.Outbox, 1)
+	require.Len(t, data.PollState.Alerts, 1)
+	require.Equal(t, testChat.ID, data.Watcher.ChatID)
+
+	removed, err := chats.ForgetChat(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{chatDataChat: 1, chatDataMessages: 2, chatDataOutbox: 1, chatDataRemoved: 1, chatDataPoll: 1, chatDataWatcher: 1}, removed)
+
+	keys, err := chats.chatKeys(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, keys, "no keys of the chat remain")
+	after, err := chats.KeyCounts()
+	require.NoError(t, err)
+	for dir, n := range before {
+		// A key each, but the chat's message records are in a single batch.
+		require.Equal(t, n-map[string]int{
+			telegramChatsDirectory:          1,
+			telegramMessageBatchesDirectory: 1,
+			telegramOutboxDirectory:         1,
+			telegramRemovedChatsDirectory:   1,
+			telegramPollDirectory:           1,
+		}[dir], after[dir], dir)
+	}
+
+	data, err = chats.ChatData(other.ID)
+	require.NoError(t, err)
+	require.NotNil(t, data.Chat)
+	require.NotNil(t, data.Watcher)
+	require.Len(t, data.Messages, 2)
+	require.Len(t, data.Outbox, 1)
+}
+
+func TestMyData(t *testing.T) {
+	sink, err := NewKVSink(newMemoryKV(), "telegram/sent_log", 10)
+	require.NoError(t, err)
+	b, tb, chats := newTestBot(t, WithMessageSinks(sink))
+	storeChatData(t, b, chats, testChat)
+	require.NoError(t, sink.Record(context.Background(), SentMessage{ChatID: testChat.ID, MessageID: 1, Text: "HighCPU"}))
+
+	require.NoError(t, b.handleMyData(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandMyData}))
+	msgs := tb.messages()
+	require.Len(t, msgs, 2)
+	require.Equal(t, strings.Join([]string{
+		"This is what the bot stores about this chat:",
+		"Subscribed as @elliot (123), to environments other, prod, staging and projects billing, frontend, other",
+		"Muted environments none and projects none",
+		"Failed deliveries in a row: 0",
+		"Notifications tracked: 2",
+		"Webhooks waiting to be delivered: 1",
+		"Messages in the sent log: 1",
+		"Alerts seen by polling Alertmanager: 1",
+		"",
+		"The attached JSON document has all of it, /forgetme erases it.",
+	}, "\n"), msgs[0].text())
+
+	doc, ok := msgs[1].what.(*telebot.Document)
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(doc.FileName, "mydata-123-"), doc.FileName)
+	require.Equal(t, "application/json", doc.MIME)
+}
+
+func TestForgetMe(t *testing.T) {
+	sink, err := NewKVSink(newMemoryKV(), "telegram/sent_log", 10)
+	require.NoError(t, err)
+	b, tb, chats := newTestBot(t, WithMessageSinks(sink))
+	storeChatData(t, b, chats, testChat)
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, sink.Record(context.Background(), SentMessage{ChatID: testChat.ID, MessageID: 1, Text: "HighCPU"}))
+	require.NoError(t, chats.SetWatcher(testChat.ID, true, time.Now()))
+	// testAdmin is the user of testChat.
+	owner := testAdmin
+
+	require.NoError(t, b.handleForgetMe(&telebot.Message{Sender: owner, Chat: testChat, Text: CommandForgetMe}))
+	data := buttons(tb.messages()[len(tb.messages())-1])
+	require.Equal(t, []string{"123"}, data)
+
+	// Others can't erase the chat's data, nor can the button erase another chat's.
+	b.handleForgetMeConfirm(&telebot.Callback{Sender: &telebot.User{ID: 999}, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: data[0]})
+	require.Equal(t, "Only admins can erase the data of this chat.", tb.responses[0].Text)
+	b.handleForgetMeConfirm(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: sharedGroup}, Data: data[0]})
+	_, err = chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+
+	b.handleForgetMeConfirm(&telebot.Callback{Sender: owner, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: data[0]})
+	require.Equal(t, "Erased everything the bot stored about this chat: chat 1, messages 2, outbox 1, removed chat 0, poll state 1, repeat deliveries 0, subscription watcher 1, sent log 1.\n"+
+		"The chat gets no more alerts, /start subscribes it again.", tb.lastText())
+	keys, err := chats.chatKeys(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	watchers, err := chats.ListWatchers()
+	require.NoError(t, err)
+	require.Empty(t, watchers, "the chat isn't told about subscriptions anymore")
+	sent, err := sink.Sent(testChat.ID)
+	require.NoError(t, err)
+	require.Empty(t, sent)
+	_, err = chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err, "other chats are kept")
+}
+
+func TestPrivacyCommandsForNonAdmins(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	user := &telebot.User{ID: 42}
+	private := &telebot.Chat{ID: 42, Type: telebot.ChatPrivate}
+	var handled []string
+	handler := b.middleware(func(m *telebot.Message) error {
+		handled = append(handled, m.Text)
+		return nil
+	})
+
+	handler(&telebot.Message{Sender: user, Chat: private, Text: CommandMyData})
+	handler(&telebot.Message{Sender: user, Chat: private, Text: CommandForgetMe + "@alertmanager_bot"})
+	handler(&telebot.Message{Sender: user, Chat: private, Text: CommandAlerts})
+	handler(&telebot.Message{Sender: user, Chat: sharedGroup, Text: CommandMyData})
+	handler(&telebot.Message{Sender: &telebot.User{ID: 999}, Chat: private, Text: CommandMyData})
+	require.Equal(t, []string{CommandMyData, CommandForgetMe + "@alertmanager_bot"}, handled,
+		"users can ask about the data of their own private chat only")
+}
@@ -0,0 +1,265 @@
+package telegram
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/hako/durafmt"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandProtect = "/protect"
+
+	protectConfirmUnique = "protect_confirm"
+	// protectConfirmTimeout is how long a second admin has to confirm a change of a protected chat.
+	protectConfirmTimeout = 5 * time.Minute
+)
+
+var (
+	errChangeUnknown = errors.New("the change is unknown or expired")
+	errSameConfirmer = errors.New("the change needs another admin to confirm it")
+)
+
+// WithGlobalAdmins makes admins global admins, who can protect chats and change protected chats on their own.
+// The admin the bot is created with is a global admin, too.
+func WithGlobalAdmins(ids ...int) BotOption {
+	return func(b *Bot) error {
+		b.globalAdmins = append(b.globalAdmins, ids...)
+		sort.Ints(b.globalAdmins)
+		return nil
+	}
+}
+
+// isGlobalAdmin returns whether id is one of the global admins.
+func (b *Bot) isGlobalAdmin(id int) bool {
+	i := sort.SearchInts(b.globalAdmins, id)
+	return i < len(b.globalAdmins) && b.globalAdmins[i] == id
+}
+
+// protectedChange is a command changing a protected chat, waiting for a second admin to confirm it.
+type protectedChange struct {
+	message *telebot.Message
+	run     func(*telebot.Message) error
+	expires time.Time
+}
+
+// protectedChanges keeps the changes waiting to be confirmed by key, in memory only.
+type protectedChanges struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	now     func() time.Time
+	changes map[string]*protectedChange
+	// initiatorConfirms lets the admin who ran the command confirm it, too.
+	initiatorConfirms bool
+}
+
+func newProtectedChanges(timeout time.Duration) *protectedChanges {
+	return &protectedChanges{timeout: timeout, now: time.Now, changes: map[string]*protectedChange{}}
+}
+
+// add keeps the change until it's confirmed or expires, forgetting the expired ones, and returns its key.
+func (p *protectedChanges) add(message *telebot.Message, run func(*telebot.Message) error) string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	key := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for k, c := range p.changes {
+		if now.After(c.expires) {
+			delete(p.changes, k)
+		}
+	}
+	p.changes[key] = &protectedChange{message: message, run: run, expires: now.Add(p.timeout)}
+	return key
+}
+
+// confirm returns the change and forgets it, unless it's unknown, expired or it's the initiator confirming.
+func (p *protectedChanges) confirm(key string, confirmer int) (*protectedChange, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.changes[key]
+	if !ok {
+		return nil, errChangeUnknown
+	}
+	if p.now().After(c.expires) {
+		delete(p.changes, key)
+		return nil, errChangeUnknown
+	}
+	if c.message.Sender.ID == confirmer && !p.initiatorConfirms {
+		return nil, errSameConfirmer
+	}
+	delete(p.changes, key)
+	return c, nil
+}
+
+// commandName returns the command of the message, without arguments and the bot's username.
+func commandName(m *telebot.Message) string {
+	return strings.Split(strings.Split(m.Text, " ")[0], "@")[0]
+}
+
+// reportToGlobalAdmins logs what happened to a protected chat and tells the global admins.
+func (b *Bot) reportToGlobalAdmins(msg string, m *telebot.Message, notice string) {
+	level.Warn(b.logger).Log(
+		"msg", msg,
+		"command", commandName(m),
+		"chat_id", m.Chat.ID,
+		"sender_id", m.Sender.ID,
+		"sender_username", m.Sender.Username,
+	)
+	for _, admin := range b.globalAdmins {
+		b.SendAdminMessage(admin, notice)
+	}
+}
+
+// senderName names the sender of a message for the global admins.
+func senderName(u *telebot.User) string {
+	if u.Username != "" {
+		return fmt.Sprintf("@%s (%d)", u.Username, u.ID)
+	}
+	return fmt.Sprintf("%d", u.ID)
+}
+
+// protected guards a command changing the chat: in protected chats only global admins run it right away,
+// other admins need a second admin to confirm it with a button. If it can't be told whether the chat is protected,
+// the command isn't run.
+func (b *Bot) protected(next func(*telebot.Message) error) func(*telebot.Message) error {
+	return func(message *telebot.Message) error {
+		if b.isGlobalAdmin(message.Sender.ID) {
+			return next(message)
+		}
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if errors.Is(err, ErrChatNotFound) {
+			// Chats that aren't subscribed can't be protected.
+			return next(message)
+		}
+		if err != nil {
+			level.Warn(b.messageLogger(message)).Log("msg", "failed to check if the chat is protected", "err", err)
+			_, err = b.replyStoreError(message, err, "check if this chat is protected")
+			return err
+		}
+		if !ci.Protected {
+			return next(message)
+		}
+
+		command := commandName(message)
+		b.reportToGlobalAdmins("change of a protected chat needs a second admin", message,
+			fmt.Sprintf("%s used %s in the protected chat %s, it's done once a second admin confirms it.",
+				senderName(message.Sender), command, chatName(message.Chat)))
+
+		key := b.protectedChanges.add(message, next)
+		markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+			{Unique: protectConfirmUnique, Text: "Confirm " + command, Data: key},
+		}}}
+		_, err = b.reply(message,
+			fmt.Sprintf("🔒 This chat is protected, a second admin has to confirm %s within %s.", command, durafmt.Parse(protectConfirmTimeout)),
+			&telebot.SendOptions{ReplyMarkup: markup})
+		return err
+	}
+}
+
+func (b *Bot) handleProtectConfirm(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+	removeButton := func() {
+		if c.Message == nil {
+			return
+		}
+		if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove protected change button", "err", err)
+		}
+	}
+
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can confirm changes of this chat.")
+		return
+	}
+
+	change, err := b.protectedChanges.confirm(c.Data, c.Sender.ID)
+	switch {
+	case errors.Is(err, errSameConfirmer):
+		respond("A second admin has to confirm this.")
+		return
+	case err != nil:
+		respond("This change has expired, run the command again.")
+		removeButton()
+		return
+	}
+	respond("")
+	removeButton()
+
+	m := change.message
+	level.Info(b.logger).Log(
+		"msg", "change of a protected chat confirmed",
+		"command", commandName(m),
+		"chat_id", m.Chat.ID,
+		"sender_id", m.Sender.ID,
+		"confirmer_id", c.Sender.ID,
+	)
+	notice := fmt.Sprintf("%s confirmed %s in the protected chat %s, used by %s.",
+		senderName(c.Sender), commandName(m), chatName(m.Chat), senderName(m.Sender))
+	for _, admin := range b.globalAdmins {
+		b.SendAdminMessage(admin, notice)
+	}
+	if err := change.run(m); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to handle confirmed command", "err", err)
+	}
+}
+
+// SetProtected sets if changing the chat needs a global admin or a second admin's confirmation.
+func (s *ChatStore) SetProtected(c *telebot.Chat, protected bool) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.Protected = protected
+		return nil
+	})
+}
+
+func (b *Bot) handleProtect(message *telebot.Message) error {
+	var protected bool
+	switch strings.TrimSpace(message.Payload) {
+	case "on":
+		protected = true
+	case "off":
+		protected = false
+	default:
+		_, err := b.respond(message, "protect_usage", responseContext(message))
+		return err
+	}
+
+	if !b.isGlobalAdmin(message.Sender.ID) {
+		b.reportToGlobalAdmins("unauthorised change of chat protection", message,
+			fmt.Sprintf("%s tried to turn protection of the chat %s %s, but isn't a global admin.",
+				senderName(message.Sender), chatName(message.Chat), message.Payload))
+		_, err := b.respond(message, "protect_admins_only", responseContext(message))
+		return err
+	}
+
+	if err := b.chats.SetProtected(message.Chat, protected); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set chat protection", "err", err)
+		_, err = b.replyStoreError(message, err, "set the chat protection")
+		return err
+	}
+
+	if protected {
+		_, err := b.respond(message, "protect_on", responseContext(message))
+		return err
+	}
+	_, err := b.respond(message, "protect_off", responseContext(message))
+	return err
+}
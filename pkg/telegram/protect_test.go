@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var (
+	secondAdmin = &telebot.User{ID: 456, Username: "darlene"}
+	thirdAdmin  = &telebot.User{ID: 789, Username: "angela"}
+	sharedGroup = &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+)
+
+// protectedGroup returns a bot with testAdmin as the global admin and a protected group subscribed.
+func protectedGroup(t *testing.T) (*Bot, *fakeTelebot, *ChatStore) {
+	b, tb, chats := newTestBot(t, WithExtraAdmins(secondAdmin.ID, thirdAdmin.ID))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, b.handleProtect(&telebot.Message{Sender: testAdmin, Chat: sharedGroup, Payload: "on"}))
+	return b, tb, chats
+}
+
+// confirmButton returns the data of the Confirm button of the last message sent.
+func confirmButton(t *testing.T, tb *fakeTelebot) string {
+	msgs := tb.messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		for _, o := range msgs[i].options {
+			if so, ok := o.(*telebot.SendOptions); ok && so.ReplyMarkup != nil {
+				return so.ReplyMarkup.InlineKeyboard[0][0].Data
+			}
+		}
+	}
+	t.Fatal("no Confirm button was sent")
+	return ""
+}
+
+func subscribed(t *testing.T, chats *ChatStore, id int64) bool {
+	_, err := chats.GetChatInfo(id)
+	if errors.Is(err, ErrChatNotFound) {
+		return false
+	}
+	require.NoError(t, err)
+	return true
+}
+
+func TestProtectNeedsGlobalAdmin(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithExtraAdmins(secondAdmin.ID))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleProtect(&telebot.Message{Sender: secondAdmin, Chat: sharedGroup, Text: "/protect on", Payload: "on"}))
+	msgs := tb.messages()
+	require.Equal(t, "123", msgs[0].to, "the global admins are told")
+	require.Contains(t, msgs[0].text(), "@darlene (456) tried to turn protection of the chat payments-oncall (-100) on")
+	require.Equal(t, "Only global admins can protect chats.", tb.lastText())
+
+	ci, err := chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.False(t, ci.Protected)
+}
+
+func TestProtectedStop(t *testing.T) {
+	b, tb, chats := protectedGroup(t)
+	stop := b.protected(b.handleStop)
+
+	require.NoError(t, stop(&telebot.Message{Sender: secondAdmin, Chat: sharedGroup, Text: CommandStop}))
+	require.True(t, subscribed(t, chats, sharedGroup.ID), "a single admin can't stop a protected chat")
+	require.Contains(t, tb.lastText(), "a second admin has to confirm /stop within 5 minutes")
+	key := confirmButton(t, tb)
+
+	var toGlobal []string
+	for _, m := range tb.messages() {
+		if m.to == "123" {
+			toGlobal = append(toGlobal, m.text())
+		}
+	}
+	require.Len(t, toGlobal, 1)
+	require.Contains(t, toGlobal[0], "@darlene (456) used /stop in the protected chat payments-oncall (-100)")
+
+	b.handleProtectConfirm(&telebot.Callback{Sender: secondAdmin, Data: key, Message: &telebot.Message{Chat: sharedGroup}})
+	require.Equal(t, "A second admin has to confirm this.", tb.responses[len(tb.responses)-1].Text)
+	require.True(t, subscribed(t, chats, sharedGroup.ID))
+
+	b.handleProtectConfirm(&telebot.Callback{Sender: &telebot.User{ID: 999}, Data: key, Message: &telebot.Message{Chat: sharedGroup}})
+	require.Equal(t, "Only admins can confirm changes of this chat.", tb.responses[len(tb.responses)-1].Text)
+	require.True(t, subscribed(t, chats, sharedGroup.ID))
+
+	b.handleProtectConfirm(&telebot.Callback{Sender: thirdAdmin, Data: key, Message: &telebot.Message{Chat: sharedGroup}})
+	require.False(t, subscribed(t, chats, sharedGroup.ID), "the second admin's confirmation stops the chat")
+	require.NotEmpty(t, tb.edited, "the Confirm button is removed")
+
+	b.handleProtectConfirm(&telebot.Callback{Sender: thirdAdmin, Data: key, Message: &telebot.Message{Chat: sharedGroup}})
+	require.Equal(t, "This change has expired, run the command again.", tb.responses[len(tb.responses)-1].Text, "a change is only done once")
+}
+
+func TestProtectedChangeTimeout(t *testing.T) {
+	b, tb, chats := protectedGroup(t)
+	clock := &fakeClock{t: time.Now()}
+	b.protectedChanges.now = clock.now
+
+	require.NoError(t, b.protected(b.handleStop)(&telebot.Message{Sender: secondAdmin, Chat: sharedGroup, Text: CommandStop}))
+	key := confirmButton(t, tb)
+
+	clock.advance(protectConfirmTimeout + time.Second)
+	b.handleProtectConfirm(&telebot.Callback{Sender: thirdAdmin, Data: key, Message: &telebot.Message{Chat: sharedGroup}})
+	require.Equal(t, "This change has expired, run the command again.", tb.responses[len(tb.responses)-1].Text)
+	require.True(t, subscribed(t, chats, sharedGroup.ID))
+}
+
+func TestProtectedGlobalAdmin(t *testing.T) {
+	b, _, chats := protectedGroup(t)
+
+	require.NoError(t, b.protected(b.handleStop)(&telebot.Message{Sender: testAdmin, Chat: sharedGroup, Text: CommandStop}))
+	require.False(t, subscribed(t, chats, sharedGroup.ID), "global admins change protected chats on their own")
+}
+
+func TestProtectedStoreError(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithExtraAdmins(secondAdmin.ID), WithEnvironments("prod,staging"), WithProjects("billing,frontend"))
+	require.NoError(t, err)
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	kv.err = errors.New("Unexpected response code: 503")
+	var ran bool
+	require.NoError(t, b.protected(func(*telebot.Message) error {
+		ran = true
+		return nil
+	})(&telebot.Message{Sender: secondAdmin, Chat: sharedGroup, Text: CommandStop}))
+	require.False(t, ran, "commands aren't run if it can't be told whether the chat is protected")
+	require.Equal(t, "I can't reach my store right now, try again in a bit.", tb.lastText())
+}
+
+func TestUnprotectedStop(t *testing.T) {
+	b, _, chats := newTestBot(t, WithExtraAdmins(secondAdmin.ID))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.protected(b.handleStop)(&telebot.Message{Sender: secondAdmin, Chat: sharedGroup, Text: CommandStop}))
+	require.False(t, subscribed(t, chats, sharedGroup.ID))
+}
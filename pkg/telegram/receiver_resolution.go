@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// receiverPatternID is replaced by the chat's ID in the receiver pattern.
+	receiverPatternID = "{id}"
+	// defaultReceiverPattern is the receiver /alerts asks Alertmanager for if it knows no better, the chat's webhook path.
+	defaultReceiverPattern = "/webhooks/telegram/" + receiverPatternID
+
+	saveReceiverUnique = "save_receiver"
+	// saveReceiverDataMax is what fits into the data of a callback button next to its unique.
+	saveReceiverDataMax = 48
+)
+
+// Where the receiver of a chat comes from, in the order they're tried.
+const (
+	receiverStored     = "stored"
+	receiverDiscovered = "discovered"
+	receiverPattern    = "pattern"
+)
+
+// WithReceiverPattern sets the name of the receiver sending a chat its alerts, which /alerts asks Alertmanager
+// for if the chat has none stored and none is found by its ID. {id} is replaced by the chat's ID.
+func WithReceiverPattern(pattern string) BotOption {
+	return func(b *Bot) error {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("the receiver pattern must not be empty")
+		}
+		b.receiverPattern = pattern
+		return nil
+	}
+}
+
+// SetReceiver stores the receiver sending the chat its alerts, "" forgets it.
+func (s *ChatStore) SetReceiver(id int64, receiver string) error {
+	s.receiverMu.Lock()
+	defer s.receiverMu.Unlock()
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Receiver = receiver
+		return nil
+	})
+}
+
+// resolvedReceiver is the receiver of a chat and where it comes from.
+type resolvedReceiver struct {
+	Name   string
+	Source string
+}
+
+// resolveReceiver returns the receiver sending the chat its alerts: the one stored for the chat, else the one
+// found among Alertmanager's receivers by the chat's ID, else the one the receiver pattern names.
+func (b *Bot) resolveReceiver(ctx context.Context, ci *ChatInfo) resolvedReceiver {
+	if ci.Receiver != "" {
+		return resolvedReceiver{Name: ci.Receiver, Source: receiverStored}
+	}
+
+	pattern := strings.Replace(b.receiverPattern, receiverPatternID, strconv.FormatInt(ci.Chat.ID, 10), -1)
+	receivers, err := b.listReceivers(ctx)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list receivers", "err", err)
+		return resolvedReceiver{Name: pattern, Source: receiverPattern}
+	}
+	if found := discoverReceiver(receivers, ci.Chat); found != "" && found != pattern {
+		return resolvedReceiver{Name: found, Source: receiverDiscovered}
+	}
+	return resolvedReceiver{Name: pattern, Source: receiverPattern}
+}
+
+// discoverReceiver returns the receiver of the chat among Alertmanager's: one it's known by, like the name
+// /webhook_config suggests, or else the only one containing the chat's ID. It's "" if there's none or it's ambiguous.
+func discoverReceiver(receivers []string, chat *telebot.Chat) string {
+	for _, name := range chatReceivers(chat) {
+		if hasReceiver(receivers, name) {
+			return name
+		}
+	}
+
+	var found []string
+	for _, r := range receivers {
+		if containsChatID(r, chat.ID) {
+			found = append(found, r)
+		}
+	}
+	if len(found) != 1 {
+		return ""
+	}
+	return found[0]
+}
+
+// containsChatID tells if the name contains the chat's ID as a whole number, with or without its sign.
+func containsChatID(name string, id int64) bool {
+	digits := strconv.FormatInt(id, 10)
+	digits = strings.TrimPrefix(digits, "-")
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	for offset := 0; ; {
+		i := strings.Index(name[offset:], digits)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(digits)
+		if (start == 0 || !isDigit(name[start-1])) && (end == len(name) || !isDigit(name[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// offerReceiver asks to store a receiver found for the chat, so that it's used without looking for it again.
+func (b *Bot) offerReceiver(message *telebot.Message, receiver string) {
+	text := fmt.Sprintf("Found receiver '%s' for this chat — save it?", receiver)
+	options := &telebot.SendOptions{}
+	if len(receiver) <= saveReceiverDataMax {
+		options.ReplyMarkup = &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+			{Unique: saveReceiverUnique, Text: "Yes", Data: receiver},
+		}}}
+	} else {
+		text = fmt.Sprintf("Found receiver '%s' for this chat.", receiver)
+	}
+	if _, err := b.reply(message, text, options); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to offer to save the receiver", "err", err)
+	}
+}
+
+func (b *Bot) handleSaveReceiver(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil || c.Data == "" {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can save the receiver of this chat.")
+		return
+	}
+
+	chat := c.Message.Chat
+	if err := b.chats.SetReceiver(chat.ID, c.Data); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to save receiver", "chat_id", chat.ID, "err", err)
+		respond(b.storeErrorReply(err, "save the receiver"))
+		return
+	}
+	respond("")
+	b.checkSharedReceivers()
+
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove save receiver button", "err", err)
+	}
+	if _, err := b.send(chat, fmt.Sprintf("Saved receiver '%s' for this chat, %s uses it from now on.", c.Data, CommandAlerts), "button save receiver"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send save receiver confirmation", "err", err)
+	}
+}
@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestDiscoverReceiver(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		receivers []string
+		expected  string
+	}{
+		{name: "webhook path", receivers: []string{"/webhooks/telegram/-100", "telegram-payments-oncall"}, expected: "/webhooks/telegram/-100"},
+		{name: "webhook_config name", receivers: []string{"blackhole", "telegram-payments-oncall"}, expected: "telegram-payments-oncall"},
+		{name: "containing the ID", receivers: []string{"blackhole", "team-payments-telegram-100"}, expected: "team-payments-telegram-100"},
+		{name: "ID with more digits", receivers: []string{"team-payments-1000", "team-100200"}},
+		{name: "ambiguous", receivers: []string{"payments-100", "billing-100"}},
+		{name: "none", receivers: []string{"blackhole"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, discoverReceiver(tc.receivers, sharedGroup))
+		})
+	}
+}
+
+func TestResolveReceiver(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Receivers: []string{"blackhole", "team-payments-telegram-100"}}
+	b, _, _ := newTestBot(t, WithAlertmanager(am), WithReceiverPattern("telegram-{id}"))
+
+	ci := &ChatInfo{Chat: sharedGroup, Receiver: "payments"}
+	require.Equal(t, resolvedReceiver{Name: "payments", Source: receiverStored}, b.resolveReceiver(context.Background(), ci))
+
+	ci.Receiver = ""
+	require.Equal(t, resolvedReceiver{Name: "team-payments-telegram-100", Source: receiverDiscovered}, b.resolveReceiver(context.Background(), ci))
+
+	ci.Chat = testChat
+	require.Equal(t, resolvedReceiver{Name: "telegram-123", Source: receiverPattern}, b.resolveReceiver(context.Background(), ci))
+
+	_, err := NewBotWithTelegram(nil, &fakeTelebot{}, testAdmin.ID, WithReceiverPattern(" "))
+	require.Error(t, err)
+}
+
+func TestAlertsOfferDiscoveredReceiver(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Receivers: []string{"blackhole", "team-payments-telegram-100"}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: sharedGroup}))
+	calls := am.Calls()
+	require.Equal(t, "team-payments-telegram-100", calls[len(calls)-1].Receiver, "the discovered receiver is asked for its alerts")
+	msgs := tb.messages()
+	offer := msgs[len(msgs)-2]
+	require.Equal(t, "Found receiver 'team-payments-telegram-100' for this chat — save it?", offer.text())
+	button := offer.options[0].(*telebot.SendOptions).ReplyMarkup.InlineKeyboard[0][0]
+	require.Equal(t, "Yes", button.Text)
+
+	b.handleSaveReceiver(&telebot.Callback{Sender: &telebot.User{ID: 999}, Message: &telebot.Message{ID: 1, Chat: sharedGroup}, Data: button.Data})
+	require.Equal(t, "Only admins can save the receiver of this chat.", tb.responses[0].Text)
+
+	b.handleSaveReceiver(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: sharedGroup}, Data: button.Data})
+	require.Equal(t, "Saved receiver 'team-payments-telegram-100' for this chat, /alerts uses it from now on.", tb.lastText())
+	ci, err := chats.GetChatInfo(sharedGroup.ID)
+	require.NoError(t, err)
+	require.Equal(t, "team-payments-telegram-100", ci.Receiver)
+
+	sent := len(tb.messages())
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: sharedGroup}))
+	require.Len(t, tb.messages(), sent+1, "a stored receiver isn't offered again")
+	require.Equal(t, "No alerts right now! 🎉", tb.lastText())
+}
+
+func TestAlertsNotSubscribed(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithAlertmanager(&alertmanagertest.Alertmanager{}))
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Contains(t, tb.lastText(), "This chat hasn't been setup to receive any alerts yet")
+}
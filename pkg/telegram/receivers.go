@@ -0,0 +1,214 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandReceivers = "/receivers"
+
+	// receiversCacheTTL is how long the receivers listed by Alertmanager are reused.
+	receiversCacheTTL = time.Minute
+	// receiverSuggestions is the most receivers suggested for a chat whose receiver is missing.
+	receiverSuggestions = 3
+)
+
+// receiverCache keeps the receivers Alertmanager lists for a short time,
+// so that every /alerts doesn't ask Alertmanager for them.
+type receiverCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	names   []string
+	fetched time.Time
+}
+
+func newReceiverCache(ttl time.Duration) *receiverCache {
+	return &receiverCache{ttl: ttl, now: time.Now}
+}
+
+// listReceivers returns the receivers known to Alertmanager, cached for a while. Failures aren't cached.
+func (b *Bot) listReceivers(ctx context.Context) ([]string, error) {
+	c := b.receivers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.names != nil && now.Sub(c.fetched) < c.ttl {
+		return c.names, nil
+	}
+	names, err := b.alertmanager.ListReceivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	c.names, c.fetched = names, now
+	return names, nil
+}
+
+// hasReceiver returns whether name is one of the sorted receivers.
+func hasReceiver(receivers []string, name string) bool {
+	i := sort.SearchStrings(receivers, name)
+	return i < len(receivers) && receivers[i] == name
+}
+
+// chatReceivers are the names a receiver of the chat may have:
+// the webhook path /alerts asks for and the name /webhook_config suggests.
+func chatReceivers(chat *telebot.Chat) []string {
+	return []string{"/webhooks/telegram/" + fmt.Sprint(chat.ID), receiverName(chat)}
+}
+
+// suggestReceivers returns the receivers closest to the missing one, the closest first.
+// The receiver /webhook_config names for the chat is always suggested if Alertmanager knows it.
+func suggestReceivers(receivers []string, missing string, chat *telebot.Chat) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	limit := len(missing) / 3
+	if limit < 3 {
+		limit = 3
+	}
+
+	var candidates []candidate
+	for _, r := range receivers {
+		if d := editDistance(strings.ToLower(r), strings.ToLower(missing)); d <= limit {
+			candidates = append(candidates, candidate{name: r, distance: d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	var suggestions []string
+	if named := receiverName(chat); hasReceiver(receivers, named) {
+		suggestions = append(suggestions, named)
+	}
+	for _, c := range candidates {
+		if len(suggestions) == receiverSuggestions {
+			break
+		}
+		if len(suggestions) == 0 || suggestions[0] != c.name {
+			suggestions = append(suggestions, c.name)
+		}
+	}
+	return suggestions
+}
+
+// editDistance is the Levenshtein distance of a and b in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkReceiver replies and returns false if Alertmanager has no receiver for the chat.
+// If the receivers can't be listed the chat's receiver is assumed to exist.
+// So it is if none are listed: Alertmanager's root route always has one.
+func (b *Bot) checkReceiver(message *telebot.Message, receiver string) bool {
+	receivers, err := b.listReceivers(context.TODO())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list receivers", "err", err)
+		return true
+	}
+	if len(receivers) == 0 || hasReceiver(receivers, receiver) {
+		return true
+	}
+
+	reply := fmt.Sprintf("Alertmanager has no receiver %q, so it has no alerts for this chat.", receiver)
+	if suggestions := suggestReceivers(receivers, receiver, message.Chat); len(suggestions) > 0 {
+		reply += "\nDid you mean " + strings.Join(quoteAll(suggestions), ", ") + "?"
+	}
+	reply += "\n" + CommandWebhookConfig + " shows the receiver to add to Alertmanager."
+	if _, err := b.reply(message, reply); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send missing receiver reply", "err", err)
+	}
+	return false
+}
+
+func quoteAll(l []string) []string {
+	quoted := make([]string, 0, len(l))
+	for _, s := range l {
+		quoted = append(quoted, fmt.Sprintf("%q", s))
+	}
+	return quoted
+}
+
+func (b *Bot) handleReceivers(message *telebot.Message) error {
+	receivers, err := b.listReceivers(context.TODO())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list receivers", "err", err)
+		_, err = b.respondFailed(message, "list receivers", err)
+		return err
+	}
+	if len(receivers) == 0 {
+		_, err = b.reply(message, "Alertmanager has no receivers.")
+		return err
+	}
+
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats from chat store", "err", err)
+	}
+	mapped := map[string][]string{}
+	var unmapped []string
+	for _, ci := range chats {
+		found := false
+		names := chatReceivers(ci.Chat)
+		if ci.Receiver != "" {
+			names = getUniqueStrings(append([]string{ci.Receiver}, names...))
+		}
+		for _, name := range names {
+			if hasReceiver(receivers, name) {
+				mapped[name] = append(mapped[name], chatName(ci.Chat))
+				found = true
+			}
+		}
+		if !found {
+			unmapped = append(unmapped, chatName(ci.Chat))
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Alertmanager has %d receivers, ✅ send to subscribed chats:\n", len(receivers))
+	for _, r := range receivers {
+		if chats, ok := mapped[r]; ok {
+			fmt.Fprintf(&sb, "✅ %s → %s\n", r, strings.Join(chats, ", "))
+		} else {
+			fmt.Fprintf(&sb, "▫️ %s\n", r)
+		}
+	}
+	if len(unmapped) > 0 {
+		fmt.Fprintf(&sb, "\nSubscribed chats without a receiver:\n%s\n", strings.Join(unmapped, "\n"))
+	}
+	_, err = b.reply(message, b.truncateMessage(sb.String()))
+	return err
+}
@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestAlertsMissingReceiver(t *testing.T) {
+	group := &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+
+	for _, tc := range []struct {
+		name      string
+		receivers []string
+		// stored is the receiver saved for the chat, which isn't looked for among the receivers.
+		stored   string
+		expected string
+	}{
+		{
+			name:      "close matches",
+			stored:    "/webhooks/telegram/-100",
+			receivers: []string{"/webhooks/telegram/-1000", "/webhooks/telegram/-200", "blackhole", "telegram-payments-oncall"},
+			expected: "Alertmanager has no receiver \"/webhooks/telegram/-100\", so it has no alerts for this chat.\n" +
+				"Did you mean \"telegram-payments-oncall\", \"/webhooks/telegram/-1000\", \"/webhooks/telegram/-200\"?\n" +
+				"/webhook_config shows the receiver to add to Alertmanager.",
+		},
+		{
+			name:      "nothing close",
+			receivers: []string{"blackhole", "pagerduty"},
+			expected: "Alertmanager has no receiver \"/webhooks/telegram/-100\", so it has no alerts for this chat.\n" +
+				"/webhook_config shows the receiver to add to Alertmanager.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			am := &alertmanagertest.Alertmanager{Receivers: tc.receivers}
+			b, tb, chats := newTestBot(t, WithAlertmanager(am))
+			require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+			if tc.stored != "" {
+				require.NoError(t, chats.SetReceiver(group.ID, tc.stored))
+			}
+
+			require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: group}))
+			require.Equal(t, tc.expected, tb.lastText())
+			require.Zero(t, am.CallCount(alertmanagertest.MethodListAlerts))
+		})
+	}
+}
+
+func TestAlertsReceiverCached(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Receivers: []string{"/webhooks/telegram/123", "blackhole"}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	clock := &fakeClock{t: time.Now()}
+	b.receivers.now = clock.now
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+		require.Equal(t, "No alerts right now! 🎉", tb.lastText())
+	}
+	require.Equal(t, 1, am.CallCount(alertmanagertest.MethodListReceivers))
+
+	clock.advance(receiversCacheTTL)
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, 2, am.CallCount(alertmanagertest.MethodListReceivers))
+}
+
+func TestAlertsReceiversUnavailable(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{Errs: map[string]error{alertmanagertest.MethodListReceivers: errors.New("404 Not Found")}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "No alerts right now! 🎉", tb.lastText(), "alerts are listed if the receivers aren't known")
+}
+
+func TestHandleReceivers(t *testing.T) {
+	group := &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+	am := &alertmanagertest.Alertmanager{Receivers: []string{"/webhooks/telegram/123", "blackhole", "telegram-payments-oncall"}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+	other := &telebot.Chat{ID: 999, Username: "elliot", Type: telebot.ChatPrivate}
+	require.NoError(t, chats.AddChat(other, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleReceivers(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "Alertmanager has 3 receivers, ✅ send to subscribed chats:\n"+
+		"✅ /webhooks/telegram/123 → "+chatName(testChat)+"\n"+
+		"▫️ blackhole\n"+
+		"✅ telegram-payments-oncall → payments-oncall (-100)\n"+
+		"\nSubscribed chats without a receiver:\n@elliot (999)\n", tb.lastText())
+
+	am.Err = errors.New("connection refused")
+	b.receivers = newReceiverCache(receiversCacheTTL)
+	require.NoError(t, b.handleReceivers(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "failed to list receivers... connection refused", tb.lastText())
+}
@@ -0,0 +1,212 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandReconcile = "/reconcile"
+
+	// telegramRemovedChatsDirectory keeps the chats removed by reconciliation, so they can be restored by hand.
+	telegramRemovedChatsDirectory = "telegram/removed_chats"
+
+	// defaultReconcileInterval keeps reconciliation well below Telegram's limit of 30 requests per second.
+	defaultReconcileInterval = 100 * time.Millisecond
+)
+
+// WithReconciliation checks all stored chats against Telegram when the bot starts, if onStartup is true.
+// Chats Telegram doesn't know anymore are marked unreachable and get no more alerts.
+// If they are still unreachable after the grace period, they are removed.
+func WithReconciliation(onStartup bool, grace time.Duration) BotOption {
+	return func(b *Bot) error {
+		b.reconcileOnStartup = onStartup
+		b.reconcileGrace = grace
+		return nil
+	}
+}
+
+// reconcileReport sums up a reconciliation.
+type reconcileReport struct {
+	Reachable   int
+	Recovered   []string
+	Unreachable []string
+	Removed     []string
+	Unknown     int
+}
+
+func (r reconcileReport) String() string {
+	list := func(chats []string) string {
+		if len(chats) == 0 {
+			return ""
+		}
+		return ": " + strings.Join(chats, ", ")
+	}
+	return fmt.Sprintf(
+		"Reachable: %d\nReachable again: %d%s\nUnreachable: %d%s\nRemoved: %d%s\nNot checked because of errors: %d",
+		r.Reachable,
+		len(r.Recovered), list(r.Recovered),
+		len(r.Unreachable), list(r.Unreachable),
+		len(r.Removed), list(r.Removed),
+		r.Unknown,
+	)
+}
+
+// chatName is how a chat is called in reports.
+func chatName(c *telebot.Chat) string {
+	switch {
+	case c.Title != "":
+		return fmt.Sprintf("%s (%d)", c.Title, c.ID)
+	case c.Username != "":
+		return fmt.Sprintf("@%s (%d)", c.Username, c.ID)
+	default:
+		return strconv.FormatInt(c.ID, 10)
+	}
+}
+
+// SetUnreachable marks a chat unreachable since the given time, a zero time marks it reachable.
+func (s *ChatStore) SetUnreachable(id int64, since time.Time) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Unreachable = !since.IsZero()
+		ci.UnreachableSince = since
+		return nil
+	})
+}
+
+// SoftDeleteChat moves a chat out of the subscribed chats, keeping its record.
+func (s *ChatStore) SoftDeleteChat(id int64) error {
+	kv, err := s.get(chatKey(id), ErrChatNotFound)
+	if err != nil {
+		return err
+	}
+	if err := s.put(removedChatKey(id), kv.Value); err != nil {
+		return err
+	}
+	return s.delete(chatKey(id))
+}
+
+// reconcile asks Telegram about every stored chat, at most one chat per reconcile interval.
+func (b *Bot) reconcile(ctx context.Context, now time.Time) (reconcileReport, error) {
+	var report reconcileReport
+	chats, err := b.chats.List()
+	if err != nil {
+		return report, err
+	}
+
+	ticker := time.NewTicker(b.reconcileInterval)
+	defer ticker.Stop()
+
+	for i, ci := range chats {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return report, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		b.reconcileChat(ci, now, &report)
+	}
+	return report, nil
+}
+
+func (b *Bot) reconcileChat(ci ChatInfo, now time.Time, report *reconcileReport) {
+	logger := log.With(b.logger, "chat_id", ci.Chat.ID)
+	_, err := b.telegram.ChatByID(strconv.FormatInt(ci.Chat.ID, 10))
+	switch {
+	case err == nil:
+		report.Reachable++
+		if ci.Unreachable {
+			report.Recovered = append(report.Recovered, chatName(ci.Chat))
+			if err := b.chats.SetUnreachable(ci.Chat.ID, time.Time{}); err != nil {
+				level.Warn(logger).Log("msg", "failed to mark chat reachable", "err", err)
+			}
+		}
+	case classifyTelegramError(err) == deleteResultTransient:
+		level.Warn(logger).Log("msg", "failed to check chat", "err", err)
+		report.Unknown++
+	case ci.Unreachable && now.Sub(ci.UnreachableSince) >= b.reconcileGrace:
+		level.Info(logger).Log("msg", "removing unreachable chat", "unreachable_since", ci.UnreachableSince, "err", err)
+		report.Removed = append(report.Removed, chatName(ci.Chat))
+		if err := b.chats.SoftDeleteChat(ci.Chat.ID); err != nil {
+			level.Warn(logger).Log("msg", "failed to remove chat", "err", err)
+		}
+	case ci.Unreachable:
+		report.Unreachable = append(report.Unreachable, chatName(ci.Chat))
+	default:
+		level.Info(logger).Log("msg", "chat is unreachable", "err", err)
+		report.Unreachable = append(report.Unreachable, chatName(ci.Chat))
+		if err := b.chats.SetUnreachable(ci.Chat.ID, now); err != nil {
+			level.Warn(logger).Log("msg", "failed to mark chat unreachable", "err", err)
+		}
+	}
+}
+
+// reconcileOnce runs a reconciliation unless one is running already.
+func (b *Bot) reconcileOnce(ctx context.Context) (reconcileReport, bool, error) {
+	if !atomic.CompareAndSwapInt32(&b.reconcileRunning, 0, 1) {
+		return reconcileReport{}, false, nil
+	}
+	defer atomic.StoreInt32(&b.reconcileRunning, 0)
+	report, err := b.reconcile(ctx, time.Now())
+	return report, true, err
+}
+
+// reconcileOnStart reconciles the chats and sends the report to all admins.
+func (b *Bot) reconcileOnStart(ctx context.Context) {
+	if !b.leading() {
+		return
+	}
+	report, _, err := b.reconcileOnce(ctx)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to reconcile chats", "err", err)
+		return
+	}
+	for _, admin := range b.admins {
+		b.SendAdminMessage(admin, "Reconciled the subscribed chats with Telegram:\n"+report.String())
+	}
+}
+
+func (b *Bot) handleReconcile(message *telebot.Message) error {
+	if _, err := b.reply(message, "Checking all subscribed chats with Telegram..."); err != nil {
+		return err
+	}
+
+	report, ran, err := b.reconcileOnce(context.TODO())
+	if !ran {
+		_, err = b.reply(message, "A reconciliation is running already.")
+		return err
+	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to reconcile chats", "err", err)
+		_, err = b.replyStoreError(message, err, "reconcile chats")
+		return err
+	}
+
+	_, err = b.reply(message, report.String())
+	return err
+}
+
+// removedChat returns a chat removed by reconciliation, for tests and manual restores.
+func (s *ChatStore) removedChat(id int64) (*ChatInfo, error) {
+	kv, err := s.get(removedChatKey(id), ErrChatNotFound)
+	if err != nil {
+		return nil, err
+	}
+	var ci ChatInfo
+	if err := decode(removedChatKey(id), kv.Value, &ci); err != nil {
+		return nil, err
+	}
+	return &ci, nil
+}
+
+func removedChatKey(id int64) string {
+	return fmt.Sprintf("%s/%d", telegramRemovedChatsDirectory, id)
+}
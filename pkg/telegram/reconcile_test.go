@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestReconcile(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithReconciliation(false, 24*time.Hour))
+	b.reconcileInterval = time.Millisecond
+
+	reachable := &telebot.Chat{ID: 1, Title: "ops", Type: telebot.ChatGroup}
+	gone := &telebot.Chat{ID: 2, Title: "old team", Type: telebot.ChatGroup}
+	blocked := &telebot.Chat{ID: 3, Username: "bob", Type: telebot.ChatPrivate}
+	flaky := &telebot.Chat{ID: 4, Title: "flaky", Type: telebot.ChatGroup}
+	for _, c := range []*telebot.Chat{reachable, gone, blocked, flaky} {
+		require.NoError(t, chats.AddChat(c, b.environmentsAndOther, b.projectsAndOther))
+	}
+
+	tb.chatErr = func(id string) error {
+		switch id {
+		case "2":
+			return telebot.ErrChatNotFound
+		case "3":
+			return telebot.ErrBlockedByUser
+		case "4":
+			return telebot.ErrInternal
+		}
+		return nil
+	}
+
+	now := time.Now()
+	report, err := b.reconcile(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, reconcileReport{
+		Reachable:   1,
+		Unreachable: []string{"old team (2)", "@bob (3)"},
+		Unknown:     1,
+	}, report)
+
+	ci, err := chats.GetChatInfo(gone.ID)
+	require.NoError(t, err)
+	require.True(t, ci.Unreachable)
+	require.True(t, now.Equal(ci.UnreachableSince))
+	ci, err = chats.GetChatInfo(flaky.ID)
+	require.NoError(t, err)
+	require.False(t, ci.Unreachable)
+
+	// Within the grace period unreachable chats are kept.
+	report, err = b.reconcile(context.Background(), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []string{"old team (2)", "@bob (3)"}, report.Unreachable)
+	require.Empty(t, report.Removed)
+
+	// The blocked user came back, the other chat is still gone after the grace period.
+	tb.chatErr = func(id string) error {
+		if id == "2" {
+			return telebot.ErrChatNotFound
+		}
+		return nil
+	}
+	report, err = b.reconcile(context.Background(), now.Add(25*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, reconcileReport{
+		Reachable: 3,
+		Recovered: []string{"@bob (3)"},
+		Removed:   []string{"old team (2)"},
+	}, report)
+
+	_, err = chats.GetChatInfo(gone.ID)
+	require.Equal(t, ChatNotFoundErr, err)
+	removed, err := chats.removedChat(gone.ID)
+	require.NoError(t, err)
+	require.Equal(t, "old team", removed.Chat.Title)
+
+	ci, err = chats.GetChatInfo(blocked.ID)
+	require.NoError(t, err)
+	require.False(t, ci.Unreachable)
+	require.True(t, ci.UnreachableSince.IsZero())
+}
+
+func TestWebhookSkipsUnreachableChat(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetUnreachable(testChat.ID, time.Now()))
+
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{issueAlert},
+	}}}
+	d, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Equal(t, 0, d.Messages)
+	require.Empty(t, tb.messages())
+}
+
+func TestHandleReconcile(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	b.reconcileInterval = time.Millisecond
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleReconcile(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "Reachable: 1\nReachable again: 0\nUnreachable: 0\nRemoved: 0\nNot checked because of errors: 0", tb.lastText())
+}
@@ -0,0 +1,137 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandRedact = "/redact"
+
+	redacted = "[REDACTED]"
+
+	responseRedactUsage = "Usage: " + CommandRedact + " strict|normal\n" +
+		"strict only shows alertname, severity and environment of alerts, normal shows everything but redacted secrets."
+)
+
+// strictLabels are the only labels a chat in strict redaction mode gets to see.
+var strictLabels = []string{"alertname", "severity", labelEnvironment}
+
+// WithRedactionPatterns replaces everything matching one of the regular expressions
+// in label and annotation values with [REDACTED] before alerts are rendered.
+func WithRedactionPatterns(patterns ...string) BotOption {
+	return func(b *Bot) error {
+		for _, p := range patterns {
+			if p == "" {
+				continue
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("failed to compile redaction pattern %q: %w", p, err)
+			}
+			b.redactions = append(b.redactions, re)
+		}
+		return nil
+	}
+}
+
+func (b *Bot) redactValue(v string) string {
+	for _, re := range b.redactions {
+		v = re.ReplaceAllString(v, redacted)
+	}
+	return v
+}
+
+func (b *Bot) redactKV(kv template.KV, strict bool) template.KV {
+	if kv == nil {
+		return nil
+	}
+	out := make(template.KV, len(kv))
+	if strict {
+		for _, name := range strictLabels {
+			if v, ok := kv[name]; ok {
+				out[name] = b.redactValue(v)
+			}
+		}
+		return out
+	}
+	for k, v := range kv {
+		out[k] = b.redactValue(v)
+	}
+	return out
+}
+
+// redactAlerts returns copies of the alerts with secrets redacted.
+// In strict mode the annotations are dropped and only a few labels kept.
+func (b *Bot) redactAlerts(alerts template.Alerts, strict bool) template.Alerts {
+	if alerts == nil {
+		return nil
+	}
+	out := make(template.Alerts, len(alerts))
+	for i, a := range alerts {
+		a.Labels = b.redactKV(a.Labels, strict)
+		if strict {
+			a.Annotations = template.KV{}
+			a.GeneratorURL = ""
+		} else {
+			a.Annotations = b.redactKV(a.Annotations, false)
+		}
+		out[i] = a
+	}
+	return out
+}
+
+// redactData returns a copy of the template data that is safe to send to the chat.
+// Redacting happens before templating, so no template can get to the secrets.
+func (b *Bot) redactData(chatInfo *ChatInfo, data *template.Data) *template.Data {
+	strict := chatInfo != nil && chatInfo.RedactStrict
+	redactedData := *data
+	redactedData.Alerts = b.redactAlerts(data.Alerts, strict)
+	redactedData.GroupLabels = b.redactKV(data.GroupLabels, strict)
+	redactedData.CommonLabels = b.redactKV(data.CommonLabels, strict)
+	if strict {
+		redactedData.CommonAnnotations = template.KV{}
+	} else {
+		redactedData.CommonAnnotations = b.redactKV(data.CommonAnnotations, false)
+	}
+	return &redactedData
+}
+
+// SetRedactStrict turns the strict redaction mode of a chat on or off.
+func (s *ChatStore) SetRedactStrict(c *telebot.Chat, strict bool) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.RedactStrict = strict
+		return nil
+	})
+}
+
+func (b *Bot) handleRedact(message *telebot.Message) error {
+	var strict bool
+	switch strings.TrimSpace(message.Payload) {
+	case "strict":
+		strict = true
+	case "normal":
+		strict = false
+	default:
+		_, err := b.reply(message, responseRedactUsage)
+		return err
+	}
+
+	if err := b.chats.SetRedactStrict(message.Chat, strict); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set redaction mode", "err", err)
+		_, err = b.replyStoreError(message, err, "set redaction mode")
+		return err
+	}
+
+	if strict {
+		_, err := b.reply(message, "Alerts in this chat only show alertname, severity and environment now.")
+		return err
+	}
+	_, err := b.reply(message, "Alerts in this chat show all labels and annotations again, secrets are still redacted.")
+	return err
+}
@@ -0,0 +1,213 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// telegramConfigKey keeps the environments and projects the bot last ran with.
+	telegramConfigKey = "telegram/config"
+
+	// removedNoticeInterval spaces the notices about removed environments and projects, to stay clear of flood control.
+	removedNoticeInterval = 100 * time.Millisecond
+)
+
+// errConfigNotFound is returned before the bot stored the environments and projects it runs with.
+var errConfigNotFound = errors.New("no config stored")
+
+// StoredConfig is what the bot remembers of its configuration, to find the environments and projects
+// removed from it when it's started again.
+type StoredConfig struct {
+	Environments []string
+	Projects     []string
+}
+
+// LastConfig returns the config the bot last ran with, or errConfigNotFound if it never stored one.
+func (s *ChatStore) LastConfig() (*StoredConfig, error) {
+	kv, err := s.get(telegramConfigKey, errConfigNotFound)
+	if err != nil {
+		return nil, err
+	}
+	var c StoredConfig
+	if err := decode(kv.Key, kv.Value, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveConfig stores the config the bot runs with.
+func (s *ChatStore) SaveConfig(c StoredConfig) error {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.put(telegramConfigKey, value)
+}
+
+// ForEach updates every chat with fn, like UpdateChat, in the order they're listed.
+// fn is called again for a chat written by someone else meanwhile, its last call counts.
+func (s *ChatStore) ForEach(fn func(ci *ChatInfo) error) error {
+	chats, err := s.List()
+	if err != nil {
+		if errors.Is(err, ErrChatNotFound) {
+			return nil
+		}
+		return err
+	}
+	for _, listed := range chats {
+		if listed.Chat == nil {
+			continue
+		}
+		err := s.UpdateChat(listed.Chat.ID, fn)
+		if err != nil && !errors.Is(err, ErrChatNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removedValues returns the values of previous that current doesn't have anymore.
+func removedValues(previous, current []string) []string {
+	var removed []string
+	for _, v := range previous {
+		if !contains(current, v) {
+			removed = append(removed, v)
+		}
+	}
+	return removed
+}
+
+// withoutValues splits values into those not in removed and those that are.
+func withoutValues(values, removed []string) ([]string, []string) {
+	var kept, dropped []string
+	for _, v := range values {
+		if contains(removed, v) {
+			dropped = append(dropped, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	if kept == nil && values != nil {
+		kept = []string{}
+	}
+	return kept, dropped
+}
+
+// removedNotice is the notice for a chat about the removed environments and projects it muted.
+func removedNotice(envs, prs []string) string {
+	var lines []string
+	for _, env := range envs {
+		lines = append(lines, fmt.Sprintf("Environment '%s' was removed from monitoring; your mute for it has been cleaned up.", env))
+	}
+	for _, pr := range prs {
+		lines = append(lines, fmt.Sprintf("Project '%s' was removed from monitoring; your mute for it has been cleaned up.", pr))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanupRemovedConfig removes the environments and projects that were configured when the bot last ran, but
+// aren't anymore, from the subscriptions and mutes of all chats. Chats that muted them are told about it.
+// It returns how many chats were changed.
+func (b *Bot) cleanupRemovedConfig(ctx context.Context) (int, error) {
+	current := StoredConfig{Environments: b.environments, Projects: b.projects}
+	last, err := b.chats.LastConfig()
+	if errors.Is(err, errConfigNotFound) {
+		return 0, b.chats.SaveConfig(current)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removedEnvs := removedValues(last.Environments, current.Environments)
+	removedPrs := removedValues(last.Projects, current.Projects)
+	if len(removedEnvs) == 0 && len(removedPrs) == 0 {
+		return 0, b.chats.SaveConfig(current)
+	}
+	level.Info(b.logger).Log("msg", "cleaning up environments and projects removed from the config",
+		"environments", strings.Join(removedEnvs, ","), "projects", strings.Join(removedPrs, ","))
+
+	type outcome struct {
+		chat *telebot.Chat
+		// notice is what the chat is told, empty if it only subscribed to what was removed.
+		notice string
+	}
+	// The outcomes are by chat, ForEach calls fn again for chats written meanwhile.
+	var order []int64
+	outcomes := map[int64]*outcome{}
+	err = b.chats.ForEach(func(ci *ChatInfo) error {
+		var mutedEnvs, mutedPrs, subscribedEnvs, subscribedPrs []string
+		ci.MutedEnvironments, mutedEnvs = withoutValues(ci.MutedEnvironments, removedEnvs)
+		ci.MutedProjects, mutedPrs = withoutValues(ci.MutedProjects, removedPrs)
+		ci.AlertEnvironments, subscribedEnvs = withoutValues(ci.AlertEnvironments, removedEnvs)
+		ci.AlertProjects, subscribedPrs = withoutValues(ci.AlertProjects, removedPrs)
+		if _, ok := outcomes[ci.Chat.ID]; !ok {
+			order = append(order, ci.Chat.ID)
+		}
+		outcomes[ci.Chat.ID] = nil
+		if len(mutedEnvs)+len(mutedPrs)+len(subscribedEnvs)+len(subscribedPrs) == 0 {
+			return nil
+		}
+		o := &outcome{chat: ci.Chat}
+		if len(mutedEnvs)+len(mutedPrs) > 0 {
+			o.notice = removedNotice(mutedEnvs, mutedPrs)
+		}
+		outcomes[ci.Chat.ID] = o
+		return nil
+	})
+	var changed int
+	var notices []*outcome
+	for _, id := range order {
+		if o := outcomes[id]; o != nil {
+			changed++
+			if o.notice != "" {
+				notices = append(notices, o)
+			}
+		}
+	}
+	if err != nil {
+		return changed, err
+	}
+	// The config is only stored once the chats are cleaned up, so that a failed cleanup is retried on the next start.
+	if err := b.chats.SaveConfig(current); err != nil {
+		return changed, err
+	}
+
+	ticker := time.NewTicker(removedNoticeInterval)
+	defer ticker.Stop()
+	for i, n := range notices {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return changed, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		if _, err := b.send(n.chat, n.notice, "removed config"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to tell chat about removed environments and projects", "chat_id", n.chat.ID, "err", err)
+		}
+	}
+	return changed, nil
+}
+
+// cleanupRemovedOnStart cleans up the environments and projects removed from the config since the last run.
+func (b *Bot) cleanupRemovedOnStart(ctx context.Context) {
+	if !b.leading() {
+		return
+	}
+	changed, err := b.cleanupRemovedConfig(ctx)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to clean up environments and projects removed from the config", "err", err)
+		return
+	}
+	if changed > 0 {
+		level.Info(b.logger).Log("msg", "cleaned up environments and projects removed from the config", "chats", changed)
+	}
+}
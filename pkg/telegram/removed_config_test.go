@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestCleanupRemovedConfig(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+
+	changed, err := b.cleanupRemovedConfig(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, changed, "the first run only stores the config")
+	last, err := chats.LastConfig()
+	require.NoError(t, err)
+	require.Equal(t, &StoredConfig{Environments: []string{"prod", "staging"}, Projects: []string{"billing", "frontend"}}, last)
+
+	// The bot last ran with legacy-dc and the dropped project as well.
+	require.NoError(t, chats.SaveConfig(StoredConfig{
+		Environments: []string{"prod", "staging", "legacy-dc"},
+		Projects:     []string{"billing", "frontend", "dropped"},
+	}))
+	allEnvs := []string{"prod", "staging", "legacy-dc", "other"}
+	allPrs := []string{"billing", "frontend", "dropped", "other"}
+
+	muting := &telebot.Chat{ID: 1, Type: telebot.ChatPrivate}
+	both := &telebot.Chat{ID: 2, Type: telebot.ChatGroup, Title: "ops"}
+	subscribed := &telebot.Chat{ID: 3, Type: telebot.ChatPrivate}
+	unaffected := &telebot.Chat{ID: 4, Type: telebot.ChatPrivate}
+	for _, c := range []*telebot.Chat{muting, both, subscribed} {
+		require.NoError(t, chats.AddChat(c, allEnvs, allPrs))
+	}
+	require.NoError(t, chats.AddChat(unaffected, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(muting, []string{"legacy-dc", "staging"}, allEnvs))
+	require.NoError(t, chats.MuteEnvironments(both, []string{"legacy-dc"}, allEnvs))
+	require.NoError(t, chats.MuteProjects(both, []string{"dropped"}, allPrs))
+	require.NoError(t, chats.MuteEnvironments(unaffected, []string{"staging"}, b.environmentsAndOther))
+
+	changed, err = b.cleanupRemovedConfig(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3, changed)
+
+	ci, err := chats.GetChatInfo(muting.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"staging"}, ci.MutedEnvironments)
+	require.Equal(t, []string{"prod", "other"}, ci.AlertEnvironments)
+	require.Equal(t, []string{"billing", "frontend", "other"}, ci.AlertProjects)
+
+	ci, err = chats.GetChatInfo(both.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.MutedEnvironments)
+	require.Empty(t, ci.MutedProjects)
+
+	ci, err = chats.GetChatInfo(subscribed.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod", "staging", "other"}, ci.AlertEnvironments)
+
+	ci, err = chats.GetChatInfo(unaffected.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"staging"}, ci.MutedEnvironments)
+
+	sent := tb.messages()
+	require.Len(t, sent, 2, "only chats that muted what was removed are told")
+	require.Equal(t, "1", sent[0].to)
+	require.Equal(t, "Environment 'legacy-dc' was removed from monitoring; your mute for it has been cleaned up.", sent[0].text())
+	require.Equal(t, "2", sent[1].to)
+	require.Equal(t, "Environment 'legacy-dc' was removed from monitoring; your mute for it has been cleaned up.\n"+
+		"Project 'dropped' was removed from monitoring; your mute for it has been cleaned up.", sent[1].text())
+
+	// The notices are one-time, the next start finds nothing removed.
+	changed, err = b.cleanupRemovedConfig(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, changed)
+	require.Len(t, tb.messages(), 2)
+}
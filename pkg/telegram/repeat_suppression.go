@@ -0,0 +1,391 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandSuppress     = "/suppress"
+	CommandSuppressions = "/suppressions"
+	CommandSuppressDel  = "/suppress_del"
+
+	// telegramRepeatsDirectory keeps when alerts with repeat suppressions were last delivered, by chat and scope.
+	telegramRepeatsDirectory = "telegram/repeats"
+	labelAlertname           = "alertname"
+	labelPer                 = "per"
+
+	responseSuppressUsage = "Usage: " + CommandSuppress + " alertname[BackupJobFailed] 24h to send the alert at most once a day, " +
+		CommandSuppress + " alertname[DiskFull] per[environment, project] 6h to do so per environment and project, " +
+		CommandSuppressDel + " <number> to delete a rule listed by " + CommandSuppressions + "."
+)
+
+// RepeatSuppression drops the chat's firing alerts with the alertname while one was delivered to it within the window.
+type RepeatSuppression struct {
+	Alertname string
+	Window    time.Duration
+	// Per are the labels, environment and project, whose values get a window each. Empty shares one window.
+	Per []string `json:",omitempty"`
+}
+
+func (r RepeatSuppression) String() string {
+	s := fmt.Sprintf("alertname[%s]", r.Alertname)
+	if len(r.Per) > 0 {
+		s += fmt.Sprintf(" per[%s]", strings.Join(r.Per, ", "))
+	}
+	return s + " " + formatExpiry(r.Window)
+}
+
+// scope is what a window of the rule is kept for with the alert's labels, like
+// "alertname=DiskFull environment=prod", and the values of its per labels.
+func (r RepeatSuppression) scope(labels template.KV) (string, map[string]string) {
+	parts := []string{labelAlertname + "=" + r.Alertname}
+	var per map[string]string
+	for _, l := range r.Per {
+		if per == nil {
+			per = map[string]string{}
+		}
+		per[l] = labels[l]
+		parts = append(parts, l+"="+labels[l])
+	}
+	return strings.Join(parts, " "), per
+}
+
+// RepeatRecord is when an alert with a repeat suppression was last delivered to a chat, for a scope of its rule.
+type RepeatRecord struct {
+	ChatID      int64
+	Scope       string
+	Alertname   string
+	Per         map[string]string `json:",omitempty"`
+	DeliveredAt time.Time
+	// Until is when the window of the delivery ended, the record is pruned afterwards.
+	Until time.Time
+}
+
+// SetRepeatSuppressions sets the repeat suppression rules of the chat, one per alertname.
+func (s *ChatStore) SetRepeatSuppressions(id int64, rules []RepeatSuppression) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.RepeatSuppressions = rules
+		return nil
+	})
+}
+
+func repeatKey(chatID int64, scope string) string {
+	return fmt.Sprintf("%s/%d/%x", telegramRepeatsDirectory, chatID, sha256.Sum256([]byte(scope)))
+}
+
+// GetRepeats returns the chat's last deliveries of alerts with repeat suppressions by scope.
+// Records that can't be read are skipped.
+func (s *ChatStore) GetRepeats(chatID int64) (map[string]RepeatRecord, error) {
+	// The trailing slash keeps the prefix from matching chats whose ID starts with this one's.
+	prefix := fmt.Sprintf("%s/%d/", telegramRepeatsDirectory, chatID)
+	records := map[string]RepeatRecord{}
+	_, err := s.listRecords(prefix, func(kv *store.KVPair) error {
+		if !strings.HasPrefix(kv.Key, prefix) {
+			return nil
+		}
+		var r RepeatRecord
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return err
+		}
+		records[r.Scope] = r
+		return nil
+	})
+	return records, err
+}
+
+// RecordRepeats stores the deliveries, replacing the last ones of their chats and scopes.
+func (s *ChatStore) RecordRepeats(records []RepeatRecord) error {
+	for _, r := range records {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if err := s.put(repeatKey(r.ChatID, r.Scope), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneRepeats removes the deliveries whose window ended and returns how many there were.
+// Records that can't be read are skipped.
+func (s *ChatStore) PruneRepeats(now time.Time) (int, error) {
+	var ended []string
+	_, err := s.listRecords(telegramRepeatsDirectory, func(kv *store.KVPair) error {
+		var r RepeatRecord
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return err
+		}
+		if !now.Before(r.Until) {
+			ended = append(ended, kv.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range ended {
+		if err := s.delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(ended), nil
+}
+
+// repeatRule returns the chat's rule for the alert's alertname, nil if it has none.
+func repeatRule(rules []RepeatSuppression, a template.Alert) *RepeatSuppression {
+	for i, r := range rules {
+		if r.Alertname == a.Labels[labelAlertname] {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// suppressRepeats drops the firing alerts of the chat's repeat suppression rules while one with the same scope
+// was delivered within the rule's window, and the resolved alerts that started after the last delivery, whose
+// firing was dropped. If the deliveries can't be read, no alerts are dropped.
+func (b *Bot) suppressRepeats(logger log.Logger, ci *ChatInfo, alerts template.Alerts, now time.Time) template.Alerts {
+	if len(ci.RepeatSuppressions) == 0 {
+		return alerts
+	}
+	var records map[string]RepeatRecord
+	kept := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		r := repeatRule(ci.RepeatSuppressions, a)
+		if r == nil {
+			kept = append(kept, a)
+			continue
+		}
+		if records == nil {
+			var err error
+			if records, err = b.chats.GetRepeats(ci.Chat.ID); err != nil {
+				level.Warn(logger).Log("msg", "failed to get the last deliveries of suppressed repeats", "err", err)
+				return alerts
+			}
+		}
+		scope, _ := r.scope(a.Labels)
+		last, ok := records[scope]
+		switch {
+		case !ok:
+		case a.Status == "resolved" && a.StartsAt.After(last.DeliveredAt):
+			level.Debug(logger).Log("msg", "dropping resolved alert whose firing was suppressed as a repeat", "scope", scope)
+			continue
+		case a.Status != "resolved" && now.Before(last.DeliveredAt.Add(r.Window)):
+			level.Debug(logger).Log("msg", "suppressing repeated alert", "scope", scope, "delivered_at", last.DeliveredAt)
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// recordRepeats remembers that the firing alerts of the chat's repeat suppression rules were delivered.
+func (b *Bot) recordRepeats(logger log.Logger, ci *ChatInfo, alerts template.Alerts, now time.Time) {
+	var records []RepeatRecord
+	seen := map[string]bool{}
+	for _, a := range alerts {
+		r := repeatRule(ci.RepeatSuppressions, a)
+		if r == nil || a.Status == "resolved" {
+			continue
+		}
+		scope, per := r.scope(a.Labels)
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		records = append(records, RepeatRecord{
+			ChatID:      ci.Chat.ID,
+			Scope:       scope,
+			Alertname:   r.Alertname,
+			Per:         per,
+			DeliveredAt: now,
+			Until:       now.Add(r.Window),
+		})
+	}
+	if len(records) == 0 {
+		return
+	}
+	if err := b.chats.RecordRepeats(records); err != nil {
+		level.Warn(logger).Log("msg", "failed to record deliveries of suppressed repeats", "err", err)
+	}
+}
+
+// pruneRepeats forgets the deliveries whose window ended, with the other cleanups.
+func (b *Bot) pruneRepeats(now time.Time) {
+	pruned, err := b.chats.PruneRepeats(now)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to prune deliveries of suppressed repeats", "err", err)
+		return
+	}
+	if pruned > 0 {
+		level.Debug(b.logger).Log("msg", "pruned deliveries of suppressed repeats", "count", pruned)
+	}
+}
+
+// parseRepeatSuppression parses a rule like alertname[BackupJobFailed] per[environment] 24h.
+func parseRepeatSuppression(payload string) (RepeatSuppression, error) {
+	var r RepeatSuppression
+	fields := strings.Fields(payload)
+	if len(fields) < 2 {
+		return r, errors.New("expected an alertname[...] and a window like 24h")
+	}
+	window, err := parseExpiry(fields[len(fields)-1])
+	if err != nil {
+		return r, err
+	}
+	r.Window = window
+
+	groups, err := parseBracketArgs(strings.Join(fields[:len(fields)-1], " "), labelAlertname, labelPer)
+	if err != nil {
+		return r, err
+	}
+	for _, g := range groups {
+		switch g.Keyword {
+		case labelAlertname:
+			if len(g.Values) != 1 {
+				return r, errors.New("expected one alertname in alertname[...]")
+			}
+			r.Alertname = g.Values[0]
+		case labelPer:
+			for _, l := range g.Values {
+				if l != labelEnvironment && l != labelProject {
+					return r, fmt.Errorf("per[] takes %s and %s, not %s", labelEnvironment, labelProject, l)
+				}
+				if !contains(r.Per, l) {
+					r.Per = append(r.Per, l)
+				}
+			}
+			sort.Strings(r.Per)
+		}
+	}
+	if r.Alertname == "" {
+		return r, errors.New("no alertname[...] given")
+	}
+	return r, nil
+}
+
+func (b *Bot) handleSuppress(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		_, err := b.reply(message, responseSuppressUsage)
+		return err
+	}
+	r, err := parseRepeatSuppression(payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("failed to parse repeat suppression... %v\n%s", err, responseSuppressUsage))
+		return err
+	}
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the repeat suppressions of this chat")
+		return err
+	}
+
+	rules := append([]RepeatSuppression(nil), ci.RepeatSuppressions...)
+	replaced := false
+	for i := range rules {
+		if rules[i].Alertname == r.Alertname {
+			rules[i], replaced = r, true
+		}
+	}
+	if !replaced {
+		rules = append(rules, r)
+	}
+	if err := b.chats.SetRepeatSuppressions(message.Chat.ID, rules); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set repeat suppressions", "err", err)
+		_, err = b.replyStoreError(message, err, "set the repeat suppressions of this chat")
+		return err
+	}
+	per := ""
+	if len(r.Per) > 0 {
+		per = " per " + strings.Join(r.Per, " and ")
+	}
+	_, err = b.reply(message, fmt.Sprintf("%s is sent to this chat at most once in %s%s now.", r.Alertname, formatExpiry(r.Window), per))
+	return err
+}
+
+func (b *Bot) handleSuppressDel(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the repeat suppressions of this chat")
+		return err
+	}
+	rules := ci.RepeatSuppressions
+	n, _ := strconv.Atoi(strings.TrimSpace(message.Payload))
+	if n < 1 || n > len(rules) {
+		_, err = b.reply(message, fmt.Sprintf("There is no such rule, this chat has %d.\n%s", len(rules), responseSuppressUsage))
+		return err
+	}
+	deleted := rules[n-1]
+	rules = append(append([]RepeatSuppression(nil), rules[:n-1]...), rules[n:]...)
+	if err := b.chats.SetRepeatSuppressions(message.Chat.ID, rules); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set repeat suppressions", "err", err)
+		_, err = b.replyStoreError(message, err, "set the repeat suppressions of this chat")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Deleted repeat suppression %s.", deleted))
+	return err
+}
+
+// formatNextAllowed tells when the rule lets the alerts of a scope through again, given their last deliveries.
+func formatNextAllowed(ci *ChatInfo, r RepeatSuppression, records map[string]RepeatRecord, now time.Time) []string {
+	var lines []string
+	for _, rec := range records {
+		if scope, _ := r.scope(rec.Per); scope != rec.Scope {
+			continue
+		}
+		next := rec.DeliveredAt.Add(r.Window)
+		if !now.Before(next) {
+			continue
+		}
+		at := fmt.Sprintf("%s (in %s)", next.In(chatLocation(ci)).Format("2006-01-02 15:04 MST"), formatFiringDuration(next.Sub(now)))
+		if len(r.Per) == 0 {
+			return []string{"   next allowed at " + at}
+		}
+		lines = append(lines, fmt.Sprintf("   %s next allowed at %s", strings.TrimPrefix(rec.Scope, labelAlertname+"="+r.Alertname+" "), at))
+	}
+	if len(lines) == 0 {
+		return []string{"   allowed now"}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func (b *Bot) handleSuppressions(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the repeat suppressions of this chat")
+		return err
+	}
+	if len(ci.RepeatSuppressions) == 0 {
+		_, err = b.reply(message, "This chat has no repeat suppressions.\n"+responseSuppressUsage)
+		return err
+	}
+	records, err := b.chats.GetRepeats(ci.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the last deliveries of suppressed repeats")
+		return err
+	}
+	now := b.repeatsNow()
+	lines := []string{"Repeats of these alerts aren't sent to this chat within the window:"}
+	for i, r := range ci.RepeatSuppressions {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, r))
+		lines = append(lines, formatNextAllowed(ci, r, records, now)...)
+	}
+	_, err = b.reply(message, strings.Join(lines, "\n"))
+	return err
+}
@@ -0,0 +1,143 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func repeatAlert(status, alertname, env string, startsAt time.Time) template.Alert {
+	return template.Alert{Status: status, StartsAt: startsAt, Labels: template.KV{"alertname": alertname, "environment": env}}
+}
+
+func TestParseRepeatSuppression(t *testing.T) {
+	r, err := parseRepeatSuppression("alertname[BackupJobFailed] 24h")
+	require.NoError(t, err)
+	require.Equal(t, RepeatSuppression{Alertname: "BackupJobFailed", Window: 24 * time.Hour}, r)
+	require.Equal(t, "alertname[BackupJobFailed] 1d", r.String())
+
+	r, err = parseRepeatSuppression("per[project, environment] alertname[DiskFull] 90m")
+	require.NoError(t, err)
+	require.Equal(t, RepeatSuppression{Alertname: "DiskFull", Window: 90 * time.Minute, Per: []string{"environment", "project"}}, r)
+
+	for _, payload := range []string{"24h", "alertname[BackupJobFailed]", "alertname[BackupJobFailed] soon", "alertname[A, B] 1h", "alertname[A] per[instance] 1h", "severity[critical] 1h"} {
+		_, err := parseRepeatSuppression(payload)
+		require.Error(t, err, payload)
+	}
+}
+
+func TestSuppressRepeatsWindow(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetRepeatSuppressions(testChat.ID, []RepeatSuppression{{Alertname: "BackupJobFailed", Window: 24 * time.Hour}}))
+	clock := &fakeClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	b.repeatsNow = clock.now
+	ctx := context.Background()
+	send := func(alerts ...template.Alert) int {
+		before := len(tb.messages())
+		_, err := b.processWebhook(ctx, mixedWebhook("firing", alerts...))
+		require.NoError(t, err)
+		return len(tb.messages()) - before
+	}
+	started := clock.t.Add(-time.Hour)
+
+	require.Equal(t, 1, send(repeatAlert("firing", "BackupJobFailed", "prod", started)))
+	clock.advance(time.Hour)
+	require.Zero(t, send(repeatAlert("firing", "BackupJobFailed", "prod", started)), "repeats within the window are dropped")
+	require.Equal(t, 1, send(repeatAlert("firing", "HighCPU", "prod", started)), "other alertnames aren't suppressed")
+
+	clock.advance(23*time.Hour - time.Second)
+	require.Zero(t, send(repeatAlert("firing", "BackupJobFailed", "staging", started)), "the window is shared without per[]")
+	clock.advance(time.Second)
+	require.Equal(t, 1, send(repeatAlert("firing", "BackupJobFailed", "prod", started)), "the window ends 24h after the delivery")
+	require.Contains(t, tb.lastText(), "BackupJobFailed")
+
+	clock.advance(time.Minute)
+	require.Zero(t, send(repeatAlert("firing", "BackupJobFailed", "prod", clock.t)))
+	require.Zero(t, send(repeatAlert("resolved", "BackupJobFailed", "prod", clock.t)), "resolved alerts whose firing was dropped are dropped, too")
+	require.Equal(t, 1, send(repeatAlert("resolved", "BackupJobFailed", "prod", started)), "resolved alerts whose firing was delivered are sent")
+}
+
+func TestSuppressRepeatsPerEnvironment(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetRepeatSuppressions(testChat.ID, []RepeatSuppression{{Alertname: "DiskFull", Window: 6 * time.Hour, Per: []string{"environment"}}}))
+	clock := &fakeClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	b.repeatsNow = clock.now
+	ctx := context.Background()
+
+	_, err := b.processWebhook(ctx, mixedWebhook("firing", repeatAlert("firing", "DiskFull", "prod", clock.t)))
+	require.NoError(t, err)
+	clock.advance(time.Hour)
+	_, err = b.processWebhook(ctx, mixedWebhook("firing", repeatAlert("firing", "DiskFull", "prod", clock.t), repeatAlert("firing", "DiskFull", "staging", clock.t)))
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 2)
+	require.Contains(t, tb.lastText(), "staging")
+	require.NotContains(t, tb.lastText(), "prod", "prod was delivered within its window")
+
+	records, err := chats.GetRepeats(testChat.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, clock.t, records["alertname=DiskFull environment=staging"].DeliveredAt)
+	require.Equal(t, map[string]string{"environment": "prod"}, records["alertname=DiskFull environment=prod"].Per)
+}
+
+func TestHandleSuppress(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	clock := &fakeClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	b.repeatsNow = clock.now
+
+	require.NoError(t, b.handleSuppressions(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSuppressions}))
+	require.Equal(t, "This chat has no repeat suppressions.\n"+responseSuppressUsage, tb.lastText())
+	require.NoError(t, b.handleSuppress(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "alertname[BackupJobFailed] 12h"}))
+	require.Equal(t, "BackupJobFailed is sent to this chat at most once in 12h now.", tb.lastText())
+	require.NoError(t, b.handleSuppress(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "alertname[BackupJobFailed] 24h"}))
+	require.NoError(t, b.handleSuppress(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "alertname[DiskFull] per[environment] 6h"}))
+	require.Equal(t, "DiskFull is sent to this chat at most once in 6h per environment now.", tb.lastText())
+	require.NoError(t, b.handleSuppress(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "alertname[DiskFull] every day"}))
+	require.Contains(t, tb.lastText(), "failed to parse repeat suppression...")
+
+	_, err := b.processWebhook(context.Background(), mixedWebhook("firing", repeatAlert("firing", "DiskFull", "prod", clock.t)))
+	require.NoError(t, err)
+	clock.advance(time.Hour)
+	require.NoError(t, b.handleSuppressions(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSuppressions}))
+	require.Equal(t, "Repeats of these alerts aren't sent to this chat within the window:\n"+
+		"1. alertname[BackupJobFailed] 1d\n"+
+		"   allowed now\n"+
+		"2. alertname[DiskFull] per[environment] 6h\n"+
+		"   environment=prod next allowed at 2021-03-01 16:00 UTC (in 5 hours)", tb.lastText())
+
+	require.NoError(t, b.handleSuppressDel(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "3"}))
+	require.Contains(t, tb.lastText(), "There is no such rule, this chat has 2.")
+	require.NoError(t, b.handleSuppressDel(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "1"}))
+	require.Equal(t, "Deleted repeat suppression alertname[BackupJobFailed] 1d.", tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []RepeatSuppression{{Alertname: "DiskFull", Window: 6 * time.Hour, Per: []string{"environment"}}}, ci.RepeatSuppressions)
+}
+
+func TestPruneRepeats(t *testing.T) {
+	_, _, chats := newTestBot(t)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, chats.RecordRepeats([]RepeatRecord{
+		{ChatID: testChat.ID, Scope: "alertname=A", Alertname: "A", DeliveredAt: now, Until: now.Add(time.Hour)},
+		{ChatID: testChat.ID, Scope: "alertname=B", Alertname: "B", DeliveredAt: now, Until: now.Add(2 * time.Hour)},
+		{ChatID: 1234, Scope: "alertname=A", Alertname: "A", DeliveredAt: now, Until: now.Add(time.Hour)},
+	}))
+
+	pruned, err := chats.PruneRepeats(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, pruned, "records are pruned once their window ended")
+	records, err := chats.GetRepeats(testChat.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Contains(t, records, "alertname=B")
+	records, err = chats.GetRepeats(1234)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
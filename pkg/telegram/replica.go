@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// ReplicaRole is what a replica of the bot does when several run against the same store.
+type ReplicaRole string
+
+const (
+	// RoleLeader delivers webhooks, runs the background jobs and handles all commands.
+	RoleLeader ReplicaRole = "leader"
+	// RoleFollower only answers read-only commands, spreading the load and staying useful while the leader fails over.
+	RoleFollower ReplicaRole = "follower"
+
+	responseReadOnlyReplica = "This replica is read-only, the active instance will handle state changes. Try again in a moment."
+)
+
+var (
+	// errFollower is what followers ack webhooks with, for sources that can redeliver them to the leader.
+	errFollower = errors.New("this replica is a follower and doesn't deliver webhooks")
+	// errFollowerPolling is returned for followers polling Telegram, only one replica can poll.
+	errFollowerPolling = errors.New("followers can't poll Telegram for updates while the leader does, have Telegram send them by webhook")
+)
+
+// readOnlyCommands are the commands followers answer, they only read the store, Alertmanager and the configuration.
+var readOnlyCommands = map[string]bool{
+	CommandHelp:          true,
+	CommandID:            true,
+	CommandStatus:        true,
+	CommandAlerts:        true,
+	CommandSilences:      true,
+	CommandFilters:       true,
+	CommandEnvironments:  true,
+	CommandProjects:      true,
+	CommandMutedEnvs:     true,
+	CommandMutedPrs:      true,
+	CommandChats:         true,
+	CommandTags:          true,
+	CommandConfig:        true,
+	CommandDebugInfo:     true,
+	CommandReceivers:     true,
+	CommandWebhookConfig: true,
+	CommandSentLog:       true,
+	CommandMyData:        true,
+	CommandRouteList:     true,
+	CommandRoutes:        true,
+	CommandUnknownChats:  true,
+	CommandSuppressions:  true,
+}
+
+// WithReplicaRole makes the bot start as leader or follower. A leader election can change it with SetRole.
+func WithReplicaRole(role ReplicaRole) BotOption {
+	return func(b *Bot) error {
+		switch role {
+		case RoleLeader, RoleFollower:
+		default:
+			return fmt.Errorf("unknown replica role %q, use %s or %s", role, RoleLeader, RoleFollower)
+		}
+		b.replica.role = role
+		return nil
+	}
+}
+
+// WithTelegramWebhook has Telegram send the updates to publicURL, which has to reach the bot listening at listen,
+// instead of the bot polling for them. Only one replica can poll, but all can get updates by webhook, the leader
+// as well as followers, so it's needed to run followers. Once set, Telegram refuses polling until the webhook is deleted.
+func WithTelegramWebhook(listen string, publicURL string) BotOption {
+	return func(b *Bot) error {
+		u, err := url.Parse(publicURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("the URL Telegram sends updates to must be an https URL, is %q", publicURL)
+		}
+		if listen == "" {
+			return fmt.Errorf("the address to listen for updates from Telegram must not be empty")
+		}
+		b.updatesWebhook = &telebot.Webhook{Listen: listen, Endpoint: &telebot.WebhookEndpoint{PublicURL: publicURL}}
+		return nil
+	}
+}
+
+// replicaState is the role of the replica, which changes on failover.
+type replicaState struct {
+	mu   sync.Mutex
+	role ReplicaRole
+}
+
+// Role returns whether the replica is leader or follower.
+func (b *Bot) Role() ReplicaRole {
+	b.replica.mu.Lock()
+	defer b.replica.mu.Unlock()
+	return b.replica.role
+}
+
+// SetRole makes the replica leader or follower, like when a leader election fails over to it.
+// The commands and webhooks it gets afterwards are handled as the role requires.
+func (b *Bot) SetRole(role ReplicaRole) {
+	b.replica.mu.Lock()
+	previous := b.replica.role
+	b.replica.role = role
+	b.replica.mu.Unlock()
+	if previous != role {
+		level.Info(b.logger).Log("msg", "replica role changed", "role", role, "previous", previous)
+	}
+}
+
+// followerRetryAfter is the Retry-After, in seconds, of webhooks refused by followers.
+const followerRetryAfter = "10"
+
+// LeaderWebhooks refuses the webhooks posted to a follower with 503 Service Unavailable, passing them on to next on
+// the leader. HTTP webhooks can't be redelivered to another replica like queued ones, so Alertmanager has to retry
+// them, against the leader once a load balancer or the failover sent it there.
+func (b *Bot) LeaderWebhooks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.leading() {
+			level.Debug(b.logger).Log("msg", "refusing webhook, this replica is a follower")
+			w.Header().Set("Retry-After", followerRetryAfter)
+			http.Error(w, errFollower.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// leading tells if the replica delivers webhooks, runs the background jobs and handles commands changing state.
+func (b *Bot) leading() bool {
+	return b.Role() == RoleLeader
+}
+
+// readOnlyRefused answers a command changing state with responseReadOnlyReplica if the replica is a follower,
+// telling if it did.
+func (b *Bot) readOnlyRefused(m *telebot.Message, command string) bool {
+	if b.leading() || readOnlyCommands[strings.Split(command, "@")[0]] || b.readOnlyCustomCommand(command) {
+		return false
+	}
+	if _, err := b.reply(m, responseReadOnlyReplica); err != nil {
+		level.Warn(b.messageLogger(m)).Log("msg", "failed to answer command on a read-only replica", "err", err)
+	}
+	return true
+}
+
+// leaderCallback answers the buttons changing state with responseReadOnlyReplica on followers.
+func (b *Bot) leaderCallback(next func(*telebot.Callback)) func(*telebot.Callback) {
+	return func(c *telebot.Callback) {
+		if b.leading() {
+			next(c)
+			return
+		}
+		if err := b.telegram.Respond(c, &telebot.CallbackResponse{Text: responseReadOnlyReplica}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestFollowerAnswersReadOnlyCommands(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithReplicaRole(RoleFollower))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	resolved := b.middleware(b.handleResolved)
+	filters := b.middleware(b.handleFilters)
+
+	resolved(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandResolved + " off", Payload: "off"})
+	require.Equal(t, responseReadOnlyReplica, tb.lastText())
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Nil(t, ci.SendResolved, "followers don't change state")
+
+	filters(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandFilters + "@alertmanager_bot"})
+	require.Contains(t, tb.lastText(), "\nResolved alerts: on\n", "read-only commands are answered")
+
+	// The leader failed over to this replica.
+	b.SetRole(RoleLeader)
+	resolved(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandResolved + " off", Payload: "off"})
+	require.Equal(t, "This chat won't be notified about resolved alerts anymore, only about firing ones.", tb.lastText())
+}
+
+func TestFollowerCallbacks(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithReplicaRole(RoleFollower))
+	var handled int
+	button := b.leaderCallback(func(c *telebot.Callback) { handled++ })
+
+	button(&telebot.Callback{Sender: testAdmin})
+	require.Zero(t, handled)
+	require.Equal(t, responseReadOnlyReplica, tb.responses[0].Text)
+
+	b.SetRole(RoleLeader)
+	button(&telebot.Callback{Sender: testAdmin})
+	require.Equal(t, 1, handled)
+}
+
+func TestFollowerDoesntDeliverWebhooks(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithReplicaRole(RoleFollower))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	acked := map[string]error{}
+	queue := func(name string) {
+		w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}})
+		w.Message.GroupKey = name
+		w.Ack = func(err error) { acked[name] = err }
+		b.webhookQueues.push(w)
+	}
+
+	queue("on follower")
+	require.NoError(t, b.drain(context.Background(), b.webhookQueues, testChat.ID))
+	require.Equal(t, errFollower, acked["on follower"], "the webhook is left for a source to redeliver to the leader")
+	require.Empty(t, tb.messages())
+
+	b.SetRole(RoleLeader)
+	queue("on leader")
+	require.NoError(t, b.drain(context.Background(), b.webhookQueues, testChat.ID))
+	require.NoError(t, acked["on leader"])
+	require.Len(t, tb.messages(), 1)
+}
+
+func TestFollowerLeavesWebhooksToTheLeader(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithReplicaRole(RoleFollower), WithDurableOutbox(true))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now()}))
+	left := outboxWebhook("DiskFull")
+	require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: left.ChatID, Seq: 1, ReceivedAt: time.Now(), Message: left.Message}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	webhooks := make(chan alertmanager.TelegramWebhook)
+	go func() { done <- b.sendWebhook(ctx, ctx, webhooks) }()
+	acked := make(chan error, 1)
+	w := maintenanceWebhook("firing", "HighCPU")
+	w.Ack = func(err error) { acked <- err }
+	webhooks <- w
+	require.Equal(t, errFollower, <-acked, "followers don't hold webhooks during maintenance")
+	cancel()
+	require.NoError(t, <-done)
+
+	held, _ := b.maintenanceBuffer.take()
+	require.Empty(t, held)
+	require.Empty(t, tb.messages(), "followers don't replay the outbox")
+	require.Equal(t, 1, outboxLen(t, chats))
+
+	b.SetRole(RoleLeader)
+	ack(b.writeAhead(outboxWebhook("HighCPU"), time.Now()), errFollower)
+	require.Equal(t, 2, outboxLen(t, chats), "webhooks left on losing the lead stay in the outbox")
+}
+
+func TestReplicaOptions(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	require.Equal(t, RoleLeader, b.Role(), "a single replica leads")
+
+	for _, opt := range []BotOption{
+		WithReplicaRole("observer"),
+		WithTelegramWebhook(":8443", "http://bot.example.com/updates"),
+		WithTelegramWebhook("", "https://bot.example.com/updates"),
+	} {
+		_, err := NewBotWithTelegram(nil, &fakeTelebot{}, testAdmin.ID, opt)
+		require.Error(t, err)
+	}
+
+	b, _, _ = newTestBot(t, WithTelegramWebhook(":8443", "https://bot.example.com/updates"))
+	require.Equal(t, "https://bot.example.com/updates", b.updatesWebhook.Endpoint.PublicURL)
+	require.Equal(t, ":8443", b.updatesWebhook.Listen)
+}
+
+func TestFollowerRefusesWebhookPosts(t *testing.T) {
+	b, _, _ := newTestBot(t, WithReplicaRole(RoleFollower))
+	webhooks := make(chan alertmanager.TelegramWebhook, 1)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "webhooks_total"})
+	h := b.LeaderWebhooks(alertmanager.HandleTelegramWebhook(log.NewNopLogger(), counter, webhooks))
+	body, err := json.Marshal(webhook.Message{Version: "4", Data: &template.Data{Status: "firing", Alerts: template.Alerts{{Status: "firing"}}}})
+	require.NoError(t, err)
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/webhooks/telegram/123", bytes.NewReader(body)))
+		return rec
+	}
+
+	rec := post()
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code, "Alertmanager retries the webhook, against the leader")
+	require.Equal(t, followerRetryAfter, rec.Header().Get("Retry-After"))
+	require.Empty(t, webhooks)
+
+	b.SetRole(RoleLeader)
+	require.Equal(t, http.StatusOK, post().Code)
+	require.Len(t, webhooks, 1)
+}
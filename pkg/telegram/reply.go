@@ -0,0 +1,37 @@
+package telegram
+
+import "gopkg.in/tucnak/telebot.v2"
+
+// WithReplyToCommands sets if command replies are sent as replies to the command,
+// so that in busy groups it's clear which answer belongs to whom.
+func WithReplyToCommands(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.replyToCommands = enabled
+		return nil
+	}
+}
+
+// reply sends what to the chat of the command message, as a reply to it unless WithReplyToCommands(false).
+// Replies sent in several parts are each threaded to the command.
+func (b *Bot) reply(message *telebot.Message, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	if b.replyToCommands && message.ID != 0 {
+		options = replyingTo(message, options)
+	}
+	return b.send(message.Chat, what, "command "+commandName(message), options...)
+}
+
+// replyingTo adds replying to message to the send options.
+// telebot takes the last *SendOptions as is, so the last one there gets the reply set on a copy,
+// otherwise new ones go first for later options to change them.
+func replyingTo(message *telebot.Message, options []interface{}) []interface{} {
+	for i := len(options) - 1; i >= 0; i-- {
+		if so, ok := options[i].(*telebot.SendOptions); ok && so != nil {
+			withReply := *so
+			withReply.ReplyTo = message
+			replied := append([]interface{}(nil), options...)
+			replied[i] = &withReply
+			return replied
+		}
+	}
+	return append([]interface{}{&telebot.SendOptions{ReplyTo: message}}, options...)
+}
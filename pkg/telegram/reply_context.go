@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const responseReplyContextUnknown = "I don't know which alerts the message you replied to was about.\n" +
+	"Use " + CommandMute + " environment[...] project[...] instead."
+
+// targetsFromReply returns the environments and projects of the alert message
+// a bare command (like /mute without arguments) replies to.
+// If the command isn't such a reply, inferred is false and the command's arguments should be parsed instead.
+func (b *Bot) targetsFromReply(message *telebot.Message) (envs []string, prs []string, inferred bool, err error) {
+	if message.ReplyTo == nil || len(strings.Fields(message.Text)) > 1 {
+		return nil, nil, false, nil
+	}
+
+	record, err := b.chats.GetMessage(message.Chat.ID, message.ReplyTo.ID)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if len(record.Environments) == 0 && len(record.Projects) == 0 {
+		return nil, nil, true, fmt.Errorf("the alert you replied to has no %s or %s label", labelEnvironment, labelProject)
+	}
+
+	return record.Environments, record.Projects, true, nil
+}
+
+// describeTargets formats environments and projects for confirmations, e.g. "environment prod, project billing".
+func describeTargets(envs []string, prs []string) string {
+	var parts []string
+	if len(envs) == 1 {
+		parts = append(parts, "environment "+envs[0])
+	} else if len(envs) > 1 {
+		parts = append(parts, "environments "+strings.Join(envs, ", "))
+	}
+	if len(prs) == 1 {
+		parts = append(parts, "project "+prs[0])
+	} else if len(prs) > 1 {
+		parts = append(parts, "projects "+strings.Join(prs, ", "))
+	}
+	return strings.Join(parts, ", ")
+}
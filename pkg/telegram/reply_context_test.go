@@ -0,0 +1,92 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestNewMessageRecord(t *testing.T) {
+	sent := &telebot.Message{ID: 7, Chat: testChat}
+	record := newMessageRecord(sent, template.Alerts{
+		{Labels: template.KV{"alertname": "HighCPU", labelEnvironment: "prod", labelProject: "billing"}, Fingerprint: "a"},
+		{Labels: template.KV{"alertname": "DiskFull", labelEnvironment: "prod"}, Fingerprint: "b"},
+	})
+
+	require.Equal(t, int64(123), record.ChatID)
+	require.Equal(t, 7, record.MessageID)
+	require.Equal(t, []string{"prod"}, record.Environments)
+	require.Equal(t, []string{"billing"}, record.Projects)
+	require.ElementsMatch(t, []string{"a", "b"}, record.Fingerprints)
+}
+
+func TestMuteReplyToAlert(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddMessage(MessageRecord{
+		ChatID:       testChat.ID,
+		MessageID:    42,
+		Environments: []string{"prod"},
+		Projects:     []string{"billing"},
+	}))
+
+	reply := &telebot.Message{
+		Sender:  testAdmin,
+		Chat:    testChat,
+		Text:    CommandMute,
+		ReplyTo: &telebot.Message{ID: 42, Chat: testChat},
+	}
+	require.NoError(t, b.handleMute(reply))
+	require.Equal(t, "Muted environment prod, project billing — inferred from the alert you replied to", tb.lastText())
+
+	envs, err := chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod"}, envs)
+	prs, err := chats.MutedProjects(testChat)
+	require.NoError(t, err)
+	require.Equal(t, []string{"billing"}, prs)
+
+	reply.Text = CommandMuteDel
+	require.NoError(t, b.handleMuteDel(reply))
+	require.Equal(t, "Deleted mute of environment prod, project billing — inferred from the alert you replied to", tb.lastText())
+
+	envs, err = chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	require.Empty(t, envs)
+}
+
+func TestMuteReplyWithArgumentsUsesParser(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMute(&telebot.Message{
+		Sender:  testAdmin,
+		Chat:    testChat,
+		Text:    "/mute environment[staging]",
+		ReplyTo: &telebot.Message{ID: 42, Chat: testChat},
+	}))
+	require.Equal(t, "You were successfully muted environments and/or projects", tb.lastText())
+
+	envs, err := chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	require.Equal(t, []string{"staging"}, envs)
+}
+
+func TestMuteReplyToUnknownMessage(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleMute(&telebot.Message{
+		Sender:  testAdmin,
+		Chat:    testChat,
+		Text:    CommandMute,
+		ReplyTo: &telebot.Message{ID: 99, Chat: testChat},
+	}))
+	require.Equal(t, responseReplyContextUnknown, tb.lastText())
+
+	envs, err := chats.MutedEnvironments(testChat)
+	require.NoError(t, err)
+	require.Empty(t, envs)
+}
@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// repliedTo returns the message the sent message replies to, nil if none.
+func repliedTo(m sentMessage) *telebot.Message {
+	var to *telebot.Message
+	for _, o := range m.options {
+		if so, ok := o.(*telebot.SendOptions); ok {
+			to = so.ReplyTo
+		}
+	}
+	return to
+}
+
+func TestReplyToCommands(t *testing.T) {
+	group := &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+
+	for _, tc := range []struct {
+		name    string
+		command string
+		handle  func(b *Bot) func(*telebot.Message) error
+		parts   int
+	}{
+		{name: "plain reply", command: CommandResolved, handle: func(b *Bot) func(*telebot.Message) error { return b.handleResolved }, parts: 1},
+		{name: "reply with parse mode", command: CommandID, handle: func(b *Bot) func(*telebot.Message) error { return b.handleID }, parts: 1},
+		{name: "reply in several parts", command: CommandConfig, handle: func(b *Bot) func(*telebot.Message) error { return b.handleConfig }, parts: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, tb, chats := newTestBot(t, WithEnvironments(strings.Repeat("environment,", 500)))
+			require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+			command := &telebot.Message{ID: 42, Sender: testAdmin, Chat: group, Text: tc.command}
+
+			require.NoError(t, tc.handle(b)(command))
+
+			msgs := tb.messages()
+			require.GreaterOrEqual(t, len(msgs), tc.parts)
+			for _, m := range msgs {
+				require.Same(t, command, repliedTo(m), m.text())
+			}
+			if tc.command == CommandID {
+				require.Equal(t, telebot.ModeMarkdown, msgs[0].options[0].(*telebot.SendOptions).ParseMode, "the other options are kept")
+			}
+		})
+	}
+}
+
+func TestReplyToCommandsOff(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithReplyToCommands(false))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleResolved(&telebot.Message{ID: 42, Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	require.Nil(t, repliedTo(tb.messages()[0]))
+}
+
+func TestReplyingToKeepsOptions(t *testing.T) {
+	command := &telebot.Message{ID: 42}
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{{Text: "OK"}}}}
+	given := &telebot.SendOptions{ParseMode: telebot.ModeHTML}
+
+	options := replyingTo(command, []interface{}{given, markup, telebot.NoPreview})
+	require.Equal(t, &telebot.SendOptions{ParseMode: telebot.ModeHTML, ReplyTo: command}, options[0])
+	require.Nil(t, given.ReplyTo, "the given options aren't changed")
+	require.Equal(t, []interface{}{markup, telebot.NoPreview}, options[1:])
+
+	options = replyingTo(command, []interface{}{markup})
+	require.Equal(t, []interface{}{&telebot.SendOptions{ReplyTo: command}, markup}, options)
+}
@@ -0,0 +1,123 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// firingDuration returns how long a resolved alert was firing and if that's known.
+// Alerts without StartsAt count from firstSeen, and clock skew making EndsAt come first counts as 0.
+func firingDuration(a template.Alert, firstSeen time.Time) (time.Duration, bool) {
+	if a.Status != "resolved" || a.EndsAt.IsZero() {
+		return 0, false
+	}
+	start := a.StartsAt
+	if start.IsZero() {
+		start = firstSeen
+	}
+	if start.IsZero() {
+		return 0, false
+	}
+	d := a.EndsAt.Sub(start)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// formatFiringDuration rounds down to minutes, like 1 hour 5 minutes.
+func formatFiringDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	return durafmt.Parse(d.Truncate(time.Minute)).String()
+}
+
+// firstSeen returns when the chat was first sent a message with each of the fingerprints, where that's known.
+func (b *Bot) firstSeen(logger log.Logger, chatID int64, fingerprints map[string]bool) map[string]time.Time {
+	seen := map[string]time.Time{}
+	records, err := b.chats.ListMessages()
+	if err != nil {
+		if !errors.Is(err, ErrMessageStoreEmpty) {
+			level.Warn(logger).Log("msg", "failed to list messages for first seen times", "err", err)
+		}
+		return seen
+	}
+	for _, r := range records {
+		if r.ChatID != chatID || r.SentAt.IsZero() {
+			continue
+		}
+		for _, fp := range r.Fingerprints {
+			if !fingerprints[fp] {
+				continue
+			}
+			if first, ok := seen[fp]; !ok || r.SentAt.Before(first) {
+				seen[fp] = r.SentAt
+			}
+		}
+	}
+	return seen
+}
+
+// resolvedAfter maps the fingerprints of the resolved alerts to how long they were firing, where that's known.
+// The message history of the chat is only looked at for alerts without StartsAt.
+func (b *Bot) resolvedAfter(logger log.Logger, chatID int64, alerts template.Alerts) map[string]time.Duration {
+	unknownStart := map[string]bool{}
+	for _, a := range alerts {
+		if a.Status == "resolved" && a.StartsAt.IsZero() && a.Fingerprint != "" {
+			unknownStart[a.Fingerprint] = true
+		}
+	}
+	var seen map[string]time.Time
+	if len(unknownStart) > 0 {
+		seen = b.firstSeen(logger, chatID, unknownStart)
+	}
+
+	after := map[string]time.Duration{}
+	for _, a := range alerts {
+		if a.Fingerprint == "" {
+			continue
+		}
+		if d, ok := firingDuration(a, seen[a.Fingerprint]); ok {
+			after[a.Fingerprint] = d
+		}
+	}
+	return after
+}
+
+// resolvedSummary is the line under a message with resolved alerts, like "✅ Resolved after 42 minutes".
+// It's "" if no alert of the message resolved with a known duration.
+func resolvedSummary(alerts template.Alerts, after map[string]time.Duration) string {
+	var n int
+	var shortest, longest time.Duration
+	for _, a := range alerts {
+		d, ok := after[a.Fingerprint]
+		if a.Status != "resolved" || !ok {
+			continue
+		}
+		if n == 0 || d < shortest {
+			shortest = d
+		}
+		if n == 0 || d > longest {
+			longest = d
+		}
+		n++
+	}
+	switch {
+	case n == 0:
+		return ""
+	case n == 1:
+		return "✅ Resolved after " + formatFiringDuration(shortest)
+	}
+	from, to := formatFiringDuration(shortest), formatFiringDuration(longest)
+	if from == to {
+		return fmt.Sprintf("✅ %d alerts resolved after %s", n, from)
+	}
+	return fmt.Sprintf("✅ %d alerts resolved after %s to %s", n, from, to)
+}
@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+func TestFiringDuration(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	firstSeen := start.Add(-time.Hour)
+
+	for _, tc := range []struct {
+		name      string
+		alert     template.Alert
+		firstSeen time.Time
+		expected  time.Duration
+		known     bool
+	}{
+		{
+			name:     "resolved",
+			alert:    template.Alert{Status: "resolved", StartsAt: start, EndsAt: start.Add(42 * time.Minute)},
+			expected: 42 * time.Minute,
+			known:    true,
+		},
+		{
+			name:      "StartsAt wins over first seen",
+			alert:     template.Alert{Status: "resolved", StartsAt: start, EndsAt: start.Add(time.Minute)},
+			firstSeen: firstSeen,
+			expected:  time.Minute,
+			known:     true,
+		},
+		{
+			name:      "no StartsAt falls back to first seen",
+			alert:     template.Alert{Status: "resolved", EndsAt: start},
+			firstSeen: firstSeen,
+			expected:  time.Hour,
+			known:     true,
+		},
+		{
+			name:  "no StartsAt and never seen",
+			alert: template.Alert{Status: "resolved", EndsAt: start},
+		},
+		{
+			name:  "no EndsAt",
+			alert: template.Alert{Status: "resolved", StartsAt: start},
+		},
+		{
+			name:     "clock skew clamps to zero",
+			alert:    template.Alert{Status: "resolved", StartsAt: start, EndsAt: start.Add(-5 * time.Second)},
+			expected: 0,
+			known:    true,
+		},
+		{
+			name:  "firing",
+			alert: template.Alert{Status: "firing", StartsAt: start, EndsAt: start.Add(time.Hour)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, known := firingDuration(tc.alert, tc.firstSeen)
+			require.Equal(t, tc.known, known)
+			require.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func TestFormatFiringDuration(t *testing.T) {
+	require.Equal(t, "less than a minute", formatFiringDuration(0))
+	require.Equal(t, "less than a minute", formatFiringDuration(59*time.Second))
+	require.Equal(t, "42 minutes", formatFiringDuration(42*time.Minute+30*time.Second))
+	require.Equal(t, "1 hour 5 minutes", formatFiringDuration(65*time.Minute))
+}
+
+func TestResolvedSummary(t *testing.T) {
+	alerts := template.Alerts{
+		{Status: "resolved", Fingerprint: "a"},
+		{Status: "resolved", Fingerprint: "b"},
+		{Status: "resolved", Fingerprint: "unknown"},
+		{Status: "firing", Fingerprint: "c"},
+	}
+	require.Equal(t, "", resolvedSummary(alerts, nil))
+	require.Equal(t, "✅ Resolved after 42 minutes", resolvedSummary(alerts, map[string]time.Duration{"a": 42 * time.Minute}))
+	require.Equal(t, "✅ 2 alerts resolved after 5 minutes to 2 hours",
+		resolvedSummary(alerts, map[string]time.Duration{"a": 2 * time.Hour, "b": 5 * time.Minute}))
+	require.Equal(t, "✅ 2 alerts resolved after 5 minutes",
+		resolvedSummary(alerts, map[string]time.Duration{"a": 5 * time.Minute, "b": 5*time.Minute + time.Second}))
+}
+
+func TestResolvedAfterFirstSeen(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	end := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1, SentAt: end.Add(-30 * time.Minute), Fingerprints: []string{"a"}}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 2, SentAt: end.Add(-20 * time.Minute), Fingerprints: []string{"a", "b"}}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: -1, MessageID: 3, SentAt: end.Add(-3 * time.Hour), Fingerprints: []string{"a"}}))
+
+	after := b.resolvedAfter(log.NewNopLogger(), testChat.ID, template.Alerts{
+		{Status: "resolved", Fingerprint: "a", EndsAt: end},
+		{Status: "resolved", Fingerprint: "b", EndsAt: end},
+		{Status: "resolved", Fingerprint: "c", EndsAt: end},
+	})
+	require.Equal(t, map[string]time.Duration{"a": 30 * time.Minute, "b": 20 * time.Minute}, after)
+}
+
+func TestWebhookResolvedDuration(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	start := time.Now().Add(-time.Hour)
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{Status: "resolved", Alerts: template.Alerts{
+		{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}, Fingerprint: "a", StartsAt: start, EndsAt: start.Add(42 * time.Minute)},
+	}}}}
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	text := tb.messages()[0].text()
+	require.Contains(t, text, "<b>Duration:</b> 42 minutes\n")
+	require.True(t, strings.HasSuffix(text, "\n\n✅ Resolved after 42 minutes"), text)
+}
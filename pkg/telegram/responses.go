@@ -0,0 +1,220 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// responseTemplateExt is the extension of the files overriding responses.
+const responseTemplateExt = ".tmpl"
+
+// defaultResponses are the canned replies by name, as text/template sources rendered with a ResponseContext.
+// A file <name>.tmpl in the directory given to WithResponseTemplates overrides one.
+var defaultResponses = map[string]string{
+	"start_private": "Hey{{ with .FirstName }}, {{ . }}{{ end }}! I will now keep you up to date!\n" + CommandHelp,
+	"start_group":   "Hey! I will now keep you all up to date!\n" + CommandHelp,
+	"start_failed":  "I can't add this chat to the subscribers list.",
+	"stop":          "Alright, {{ .FirstName }}! I won't talk to you again.\n" + CommandHelp,
+	"stop_failed":   "I can't remove this chat from the subscribers list.",
+	"mute_done":     "You were successfully muted environments and/or projects",
+	"unmute_done":   "You were successfully delete mute from environments and/or projects",
+	"no_alerts":     "No alerts right now! 🎉",
+	"alerts_not_configured": "This chat hasn't been setup to receive any alerts yet... 😕\n\n" +
+		"Ask an administrator of the Alertmanager to add a webhook with `/webhooks/telegram/{{ .ChatID }}` as URL.",
+	"reply_context_unknown": responseReplyContextUnknown,
+	"store_not_subscribed":  "This chat isn't subscribed, use " + CommandStart + " first.",
+	"store_unavailable":     "I can't reach my store right now, try again in a bit.",
+	"store_corrupt":         "The stored settings of this chat are broken, ask an admin to check the logs.",
+	"store_failed":          "failed to {{ .Action }}... {{ .Error }}",
+
+	"protect_usage":       "Usage: " + CommandProtect + " on|off",
+	"protect_admins_only": "Only global admins can protect chats.",
+	"protect_on":          "This chat is protected now: " + CommandStop + " and " + CommandParseMode + " need a global admin or a second admin to confirm.",
+	"protect_off":         "This chat isn't protected anymore.",
+	"invite_usage":        responseInviteUsage,
+	"invite_invalid":      responseInviteInvalid,
+	"expire_usage":        responseExpireUsage,
+	"expire_none":         "This chat's subscription doesn't expire.",
+	"expire_off":          "This chat's subscription doesn't expire anymore.",
+	"debounce_usage":      responseDebounceUsage,
+	"debounce_none":       "Firing alerts are sent to this chat right away.",
+	"debounce_off":        "Firing alerts are sent to this chat right away now.",
+	"route_usage":         responseRouteUsage,
+	"routes_none":         "This chat has no severity routes.",
+	"archive_usage":       responseArchiveUsage,
+	"archive_none":        "This chat has no archive chat.",
+	"archive_self":        "A chat can't be its own archive chat.",
+	"archive_bot":         "The bot can't be an archive chat.",
+	"archive_off":         "Alert messages of this chat aren't archived anymore.",
+
+	"mute_inferred":       "Muted {{ .Args }} — inferred from the alert you replied to",
+	"unmute_inferred":     "Deleted mute of {{ .Args }} — inferred from the alert you replied to",
+	"mute_infer_failed":   "failed to infer mute from the replied message... {{ .Error }}",
+	"mute_parse_failed":   "failed to parse mute command... {{ .Error }}",
+	"unmute_infer_failed": "failed to infer unmute from the replied message... {{ .Error }}",
+	"unmute_parse_failed": "failed to parse unmute command... {{ .Error }}",
+	"command_failed":      "failed to {{ .Action }}... {{ .Error }}",
+}
+
+// builtinResponses are the parsed defaultResponses.
+var builtinResponses = parseDefaultResponses()
+
+func parseDefaultResponses() map[string]*template.Template {
+	parsed := make(map[string]*template.Template, len(defaultResponses))
+	for name, text := range defaultResponses {
+		parsed[name] = template.Must(template.New(name).Parse(text))
+	}
+	return parsed
+}
+
+// responseOverrides are the responses overridden with WithResponseTemplates.
+type responseOverrides struct {
+	templates map[string]*template.Template
+	// bad are the overrides that are ignored and why.
+	bad []string
+}
+
+// ResponseContext is what the response templates are rendered with.
+type ResponseContext struct {
+	// FirstName is the first name of the user the bot replies to.
+	FirstName string
+	// ChatTitle is the title of the group, or the @username of a private chat.
+	ChatTitle string
+	ChatID    int64
+	// Args are the arguments of the command replied to.
+	Args string
+	// Action is what failed, for error responses.
+	Action string
+	Error  string
+	// Language is the bundle the response is taken from, empty is English.
+	Language string
+}
+
+// responseContext returns the context of a reply to the message.
+func responseContext(message *telebot.Message) ResponseContext {
+	var ctx ResponseContext
+	if message.Sender != nil {
+		ctx.FirstName = message.Sender.FirstName
+	}
+	if message.Chat != nil {
+		ctx.ChatID = message.Chat.ID
+		ctx.ChatTitle = message.Chat.Title
+		if ctx.ChatTitle == "" && message.Chat.Username != "" {
+			ctx.ChatTitle = "@" + message.Chat.Username
+		}
+	}
+	ctx.Args = strings.TrimSpace(message.Payload)
+	return ctx
+}
+
+// WithResponseTemplates overrides the canned replies with the <name>.tmpl files in dir.
+// Overrides that don't parse or don't name a response are ignored with a warning, the built-in response is used instead.
+func WithResponseTemplates(dir string) BotOption {
+	return func(b *Bot) error {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read the response templates: %w", err)
+		}
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != responseTemplateExt {
+				continue
+			}
+			name := strings.TrimSuffix(f.Name(), responseTemplateExt)
+			if _, ok := defaultResponses[name]; !ok {
+				b.responses.bad = append(b.responses.bad, f.Name()+": no such response")
+				continue
+			}
+			text, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				b.responses.bad = append(b.responses.bad, fmt.Sprintf("%s: %v", f.Name(), err))
+				continue
+			}
+			tmpl, err := template.New(name).Parse(string(text))
+			if err != nil {
+				b.responses.bad = append(b.responses.bad, fmt.Sprintf("%s: %v", f.Name(), err))
+				continue
+			}
+			if b.responses.templates == nil {
+				b.responses.templates = map[string]*template.Template{}
+			}
+			b.responses.templates[name] = tmpl
+		}
+		sort.Strings(b.responses.bad)
+		return nil
+	}
+}
+
+// warnBadResponses logs the response overrides that are ignored, once the options are applied.
+func (b *Bot) warnBadResponses() {
+	if len(b.responses.bad) == 0 {
+		return
+	}
+	level.Warn(b.logger).Log("msg", "ignoring response template overrides, using the built-in responses", "overrides", strings.Join(b.responses.bad, "; "))
+}
+
+// responseText renders the named response, falling back to the built-in one if the override fails.
+func (b *Bot) responseText(name string, ctx ResponseContext) string {
+	if tmpl, ok := b.responses.templates[name]; ok {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, ctx)
+		if err == nil {
+			return buf.String()
+		}
+		level.Warn(b.logger).Log("msg", "failed to render response override, using the built-in response", "response", name, "err", err)
+	}
+
+	if tmpl, ok := bundledResponses[ctx.Language][name]; ok {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, ctx)
+		if err == nil {
+			return buf.String()
+		}
+		level.Warn(b.logger).Log("msg", "failed to render translated response, using the built-in response", "response", name, "language", ctx.Language, "err", err)
+	}
+
+	tmpl, ok := builtinResponses[name]
+	if !ok {
+		level.Warn(b.logger).Log("msg", "unknown response", "response", name)
+		return name
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to render response", "response", name, "err", err)
+	}
+	return buf.String()
+}
+
+// respondError replies to the message with the named response rendered with the error.
+func (b *Bot) respondError(message *telebot.Message, name string, err error) (*telebot.Message, error) {
+	ctx := responseContext(message)
+	ctx.Error = err.Error()
+	return b.respond(message, name, ctx)
+}
+
+// failedText is the "command_failed" response telling that the action failed with the error.
+func (b *Bot) failedText(message *telebot.Message, action string, err error) string {
+	ctx := b.localResponseContext(message)
+	ctx.Action, ctx.Error = action, err.Error()
+	return b.responseText("command_failed", ctx)
+}
+
+// respondFailed replies to the message that the action failed with the error.
+func (b *Bot) respondFailed(message *telebot.Message, action string, err error) (*telebot.Message, error) {
+	return b.reply(message, b.failedText(message, action, err))
+}
+
+// respond replies to the message with the named response, in the chat's language if ctx doesn't set one.
+func (b *Bot) respond(message *telebot.Message, name string, ctx ResponseContext, options ...interface{}) (*telebot.Message, error) {
+	if ctx.Language == "" {
+		ctx.Language = b.chatLanguage(message.Chat)
+	}
+	return b.reply(message, b.responseText(name, ctx), options...)
+}
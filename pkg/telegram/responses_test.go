@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// responseTemplatesDir returns a directory with the files.
+func responseTemplatesDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0o600))
+	}
+	return dir
+}
+
+func TestResponseDefaults(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStart}))
+	require.Equal(t, "Hey, Elliot! I will now keep you up to date!\n"+CommandHelp, tb.lastText())
+
+	anonymous := &telebot.User{ID: 124}
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: anonymous, Chat: &telebot.Chat{ID: 124, Type: telebot.ChatPrivate}, Text: CommandStart}))
+	require.Equal(t, "Hey! I will now keep you up to date!\n"+CommandHelp, tb.lastText())
+
+	require.NoError(t, b.handleStop(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStop}))
+	require.Equal(t, "Alright, Elliot! I won't talk to you again.\n"+CommandHelp, tb.lastText())
+}
+
+func TestResponseOverrides(t *testing.T) {
+	var logs bytes.Buffer
+	dir := responseTemplatesDir(t, map[string]string{
+		"start_private.tmpl": "Welcome {{ .FirstName }} to {{ .ChatTitle }}! Read the runbooks at https://wiki.example.com/oncall first.",
+		"stop.tmpl":          "Bye {{ .FirstName }}, ask #oncall to subscribe again.",
+		"start_group.tmpl":   "Hello {{ .Broken",
+		"goodbye.tmpl":       "Not a response.",
+		"README.md":          "Not a template.",
+	})
+	b, tb, _ := newTestBot(t, WithLogger(log.NewLogfmtLogger(&logs)), WithResponseTemplates(dir))
+
+	require.Contains(t, logs.String(), "ignoring response template overrides")
+	require.Contains(t, logs.String(), "goodbye.tmpl: no such response")
+	require.Contains(t, logs.String(), "start_group.tmpl: template: start_group:1")
+	require.NotContains(t, logs.String(), "README.md")
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStart}))
+	require.Equal(t, "Welcome Elliot to @elliot! Read the runbooks at https://wiki.example.com/oncall first.", tb.lastText())
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: sharedGroup, Text: CommandStart}))
+	require.Equal(t, "Hey! I will now keep you all up to date!\n"+CommandHelp, tb.lastText(), "a broken override falls back to the built-in response")
+
+	require.NoError(t, b.handleStop(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStop}))
+	require.Equal(t, "Bye Elliot, ask #oncall to subscribe again.", tb.lastText())
+}
+
+func TestResponseOverrideRenderFailure(t *testing.T) {
+	dir := responseTemplatesDir(t, map[string]string{
+		"stop.tmpl": "Bye {{ .Nickname }}",
+	})
+	b, tb, chats := newTestBot(t, WithResponseTemplates(dir))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleStop(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStop}))
+	require.Equal(t, "Alright, Elliot! I won't talk to you again.\n"+CommandHelp, tb.lastText(), "an override failing to render falls back to the built-in response")
+}
+
+func TestResponseTemplatesMissingDir(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	_, err = NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID, WithResponseTemplates(filepath.Join(t.TempDir(), "missing")))
+	require.Error(t, err)
+}
+
+func TestResponseOverridesCommandTexts(t *testing.T) {
+	dir := responseTemplatesDir(t, map[string]string{
+		"debounce_usage.tmpl":    "See https://wiki.example.com/debounce",
+		"archive_self.tmpl":      "Pick another chat than {{ .ChatID }}.",
+		"mute_inferred.tmpl":     "Stumm: {{ .Args }}",
+		"mute_parse_failed.tmpl": "Unlesbar: {{ .Error }}",
+	})
+	b, tb, chats := newTestBot(t, WithResponseTemplates(dir))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "Firing alerts are sent to this chat right away.\nSee https://wiki.example.com/debounce", tb.lastText())
+	require.NoError(t, b.handleDebounce(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "soon"}))
+	require.Contains(t, tb.lastText(), "\nSee https://wiki.example.com/debounce")
+
+	require.NoError(t, b.handleArchiveTo(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "123"}))
+	require.Equal(t, "Pick another chat than 123.", tb.lastText())
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 42, Environments: []string{"prod"}}))
+	require.NoError(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandMute, ReplyTo: &telebot.Message{ID: 42, Chat: testChat}}))
+	require.Equal(t, "Stumm: environment prod", tb.lastText())
+	require.Error(t, b.handleMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandMute + " prod"}))
+	require.Regexp(t, "^Unlesbar: .+", tb.lastText())
+}
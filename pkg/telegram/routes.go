@@ -0,0 +1,420 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandRouteAdd  = "/route_add"
+	CommandRouteList = "/route_list"
+	CommandRouteDel  = "/route_del"
+
+	telegramRoutesDirectory = "telegram/routes"
+
+	// RouteFirstMatch sends generic webhooks to the targets of the first matching route.
+	RouteFirstMatch RouteMode = "first"
+	// RouteAllMatches sends generic webhooks to the targets of all matching routes.
+	RouteAllMatches RouteMode = "all"
+
+	routeOnReceiver = "receiver"
+	routeOnGroup    = "group"
+	routeOnCommon   = "common"
+	routeChats      = "chats"
+	routeTags       = "tags"
+
+	responseRouteAddUsage = "Usage: " + CommandRouteAdd + ` receiver[team-a] group["alertname=HighCPU"] common["severity=~critical|warning"] chats[-100123] tags[payments], ` +
+		`every matcher is optional, a receiver like "=~team-.*" is a regular expression. At least one chat or tag is needed.`
+	responseRouteDelUsage = "Usage: " + CommandRouteDel + " <id>, the IDs are listed by " + CommandRouteList + "."
+)
+
+// ErrRouteNotFound is returned for routes that aren't stored.
+var ErrRouteNotFound = errors.New("route not found")
+
+// RouteMode is how generic webhooks are routed when several routes match.
+type RouteMode string
+
+// RouteMatcher matches the receiver, a group label or a common label of a webhook.
+type RouteMatcher struct {
+	// On is what the matcher matches: receiver, group or common.
+	On string
+	// Name is the label matched, receiver for receiver matchers.
+	Name  string
+	Type  string
+	Value string
+}
+
+// Route sends the generic webhooks its matchers all match to its chats and the chats with its tags.
+// A route without matchers matches every webhook.
+type Route struct {
+	// ID numbers the routes stored by /route_add, routes configured with WithRoutes have none.
+	ID       int `json:",omitempty"`
+	Matchers []RouteMatcher
+	ChatIDs  []int64  `json:",omitempty"`
+	Tags     []string `json:",omitempty"`
+}
+
+// WithRoutes routes the webhooks sent to /webhooks/telegram without a chat ID, before the routes added with /route_add.
+func WithRoutes(rules ...Route) BotOption {
+	return func(b *Bot) error {
+		for i, r := range rules {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("route %d: %w", i+1, err)
+			}
+		}
+		b.routes = rules
+		return nil
+	}
+}
+
+// WithRouteMode sets whether generic webhooks go to the first matching route or all of them.
+func WithRouteMode(mode RouteMode) BotOption {
+	return func(b *Bot) error {
+		switch mode {
+		case RouteFirstMatch, RouteAllMatches:
+			b.routeMode = mode
+			return nil
+		}
+		return fmt.Errorf("unknown route mode %q, use %s or %s", mode, RouteFirstMatch, RouteAllMatches)
+	}
+}
+
+// WithRouteDefaultChat sends generic webhooks no route matches to the chat, 0 rejects them.
+func WithRouteDefaultChat(id int64) BotOption {
+	return func(b *Bot) error {
+		b.routeDefaultChat = id
+		return nil
+	}
+}
+
+// matchType returns the labels.MatchType written like =~.
+func matchType(op string) (labels.MatchType, bool) {
+	for _, t := range []labels.MatchType{labels.MatchEqual, labels.MatchNotEqual, labels.MatchRegexp, labels.MatchNotRegexp} {
+		if t.String() == op {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func (m RouteMatcher) matcher() (*labels.Matcher, error) {
+	t, ok := matchType(m.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown match type %q", m.Type)
+	}
+	return labels.NewMatcher(t, m.Name, m.Value)
+}
+
+func (m RouteMatcher) String() string {
+	if m.On == routeOnReceiver {
+		return fmt.Sprintf("receiver%s%q", m.Type, m.Value)
+	}
+	return fmt.Sprintf("%s.%s%s%q", m.On, m.Name, m.Type, m.Value)
+}
+
+// labelSet is what the matcher matches of the webhook.
+func (m RouteMatcher) labelSet(message webhook.Message) model.LabelSet {
+	if message.Data == nil {
+		return model.LabelSet{}
+	}
+	var kv map[string]string
+	switch m.On {
+	case routeOnReceiver:
+		return model.LabelSet{routeOnReceiver: model.LabelValue(message.Receiver)}
+	case routeOnGroup:
+		kv = message.GroupLabels
+	case routeOnCommon:
+		kv = message.CommonLabels
+	}
+	lset := make(model.LabelSet, len(kv))
+	for k, v := range kv {
+		lset[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return lset
+}
+
+func (r Route) validate() error {
+	if len(r.ChatIDs) == 0 && len(r.Tags) == 0 {
+		return errors.New("no chats[...] or tags[...] to send to")
+	}
+	for _, m := range r.Matchers {
+		switch m.On {
+		case routeOnReceiver, routeOnGroup, routeOnCommon:
+		default:
+			return fmt.Errorf("unknown matcher on %q", m.On)
+		}
+		if _, err := m.matcher(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns whether all matchers of the route match the webhook.
+func (r Route) Matches(message webhook.Message) bool {
+	for _, rm := range r.Matchers {
+		m, err := rm.matcher()
+		if err != nil || !alertmanager.MatcherMatches(m, rm.labelSet(message)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Route) String() string {
+	var parts []string
+	for _, m := range r.Matchers {
+		parts = append(parts, m.String())
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "everything")
+	}
+	var targets []string
+	for _, id := range r.ChatIDs {
+		targets = append(targets, strconv.FormatInt(id, 10))
+	}
+	for _, tag := range r.Tags {
+		targets = append(targets, targetTagPrefix+tag)
+	}
+	return strings.Join(parts, " ") + " → " + strings.Join(targets, ", ")
+}
+
+// ParseRoute parses a route written like for /route_add: receiver[name], group["label=value"] and
+// common["label=~regex"] select the webhooks, chats[id, ...] and tags[tag, ...] where they're sent.
+func ParseRoute(text string) (Route, error) {
+	var r Route
+	groups, err := parseBracketArgs(text, routeOnReceiver, routeOnGroup, routeOnCommon, routeChats, routeTags)
+	if err != nil {
+		return r, err
+	}
+	for _, g := range groups {
+		if len(g.Values) == 0 {
+			return r, fmt.Errorf("%s[] is empty", g.Keyword)
+		}
+		switch g.Keyword {
+		case routeOnReceiver:
+			if len(g.Values) > 1 {
+				return r, errors.New(`receiver[] takes one receiver, match several with a regular expression like "=~team-a|team-b"`)
+			}
+			m := RouteMatcher{On: routeOnReceiver, Name: routeOnReceiver, Type: labels.MatchEqual.String(), Value: g.Values[0]}
+			if strings.HasPrefix(m.Value, labels.MatchRegexp.String()) {
+				m.Type, m.Value = labels.MatchRegexp.String(), strings.TrimPrefix(m.Value, labels.MatchRegexp.String())
+			}
+			r.Matchers = append(r.Matchers, m)
+		case routeOnGroup, routeOnCommon:
+			for _, v := range g.Values {
+				lm, err := labels.ParseMatcher(v)
+				if err != nil {
+					return r, fmt.Errorf("%s[...]: %v", g.Keyword, err)
+				}
+				r.Matchers = append(r.Matchers, RouteMatcher{On: g.Keyword, Name: lm.Name, Type: lm.Type.String(), Value: lm.Value})
+			}
+		case routeChats:
+			for _, v := range g.Values {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return r, fmt.Errorf("chats[...]: %q is no chat ID", v)
+				}
+				r.ChatIDs = append(r.ChatIDs, id)
+			}
+		case routeTags:
+			for _, v := range g.Values {
+				r.Tags = append(r.Tags, strings.ToLower(v))
+			}
+		}
+	}
+	return r, r.validate()
+}
+
+func routeKey(id int) string {
+	return fmt.Sprintf("%s/%d", telegramRoutesDirectory, id)
+}
+
+// ListRoutes returns the routes added with /route_add, in the order they were added.
+func (s *ChatStore) ListRoutes() ([]Route, error) {
+	kvPairs, err := s.list(telegramRoutesDirectory, nil)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]Route, 0, len(kvPairs))
+	for _, kv := range kvPairs {
+		var r Route
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	return routes, nil
+}
+
+// AddRoute stores the route after the others and returns it with its ID.
+func (s *ChatStore) AddRoute(r Route) (Route, error) {
+	routes, err := s.ListRoutes()
+	if err != nil {
+		return r, err
+	}
+	r.ID = 1
+	if len(routes) > 0 {
+		r.ID = routes[len(routes)-1].ID + 1
+	}
+	value, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	return r, s.put(routeKey(r.ID), value)
+}
+
+// RemoveRoute removes the stored route, ErrRouteNotFound if there is none with the ID.
+func (s *ChatStore) RemoveRoute(id int) error {
+	if _, err := s.get(routeKey(id), ErrRouteNotFound); err != nil {
+		return err
+	}
+	return s.delete(routeKey(id))
+}
+
+// matchingRoutes returns the routes matching the webhook, only the first one unless all matches are routed.
+func (b *Bot) matchingRoutes(message webhook.Message, routes []Route) []Route {
+	var matching []Route
+	for _, r := range routes {
+		if !r.Matches(message) {
+			continue
+		}
+		matching = append(matching, r)
+		if b.routeMode != RouteAllMatches {
+			break
+		}
+	}
+	return matching
+}
+
+// RouteWebhook returns the chats a webhook sent without a chat ID goes to: those of the configured routes,
+// then those added with /route_add, and the chats with their tags. Without a matching route it goes
+// to the default chat, if there is one, or fails with alertmanager.ErrNoRoute.
+func (b *Bot) RouteWebhook(message webhook.Message) ([]int64, error) {
+	stored, err := b.chats.ListRoutes()
+	if err != nil {
+		return nil, err
+	}
+	routes := append(append([]Route(nil), b.routes...), stored...)
+
+	var ids []int64
+	seen := map[int64]bool{}
+	add := func(id int64) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	var chats []ChatInfo
+	for _, r := range b.matchingRoutes(message, routes) {
+		for _, id := range r.ChatIDs {
+			add(id)
+		}
+		if len(r.Tags) > 0 && chats == nil {
+			chats, err = b.chats.List()
+			if err != nil && !errors.Is(err, ErrChatNotFound) {
+				return nil, err
+			}
+		}
+		for _, tag := range r.Tags {
+			for _, ci := range chats {
+				if contains(ci.Tags, tag) {
+					add(ci.Chat.ID)
+				}
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		if b.routeDefaultChat == 0 {
+			return nil, alertmanager.ErrNoRoute
+		}
+		add(b.routeDefaultChat)
+	}
+	return ids, nil
+}
+
+func (b *Bot) handleRouteAdd(message *telebot.Message) error {
+	if strings.TrimSpace(message.Payload) == "" {
+		_, err := b.reply(message, responseRouteAddUsage)
+		return err
+	}
+	r, err := ParseRoute(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("failed to parse route... %v\n%s", err, responseRouteAddUsage))
+		return err
+	}
+	r, err = b.chats.AddRoute(r)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to add route", "err", err)
+		_, err = b.replyStoreError(message, err, "add the route")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Added route %d: %s", r.ID, r))
+	return err
+}
+
+func (b *Bot) handleRouteList(message *telebot.Message) error {
+	stored, err := b.chats.ListRoutes()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list routes", "err", err)
+		_, err = b.replyStoreError(message, err, "list the routes")
+		return err
+	}
+	if len(b.routes) == 0 && len(stored) == 0 {
+		_, err = b.reply(message, "There are no routes for webhooks without a chat ID.")
+		return err
+	}
+
+	var out strings.Builder
+	mode := "the first matching route"
+	if b.routeMode == RouteAllMatches {
+		mode = "all matching routes"
+	}
+	fmt.Fprintf(&out, "Webhooks without a chat ID go to %s, ", mode)
+	if b.routeDefaultChat != 0 {
+		fmt.Fprintf(&out, "or chat %d if none matches.\n", b.routeDefaultChat)
+	} else {
+		out.WriteString("or are rejected if none matches.\n")
+	}
+	for _, r := range b.routes {
+		fmt.Fprintf(&out, "\nconfigured: %s", r)
+	}
+	for _, r := range stored {
+		fmt.Fprintf(&out, "\n%d: %s", r.ID, r)
+	}
+	_, err = b.reply(message, out.String())
+	return err
+}
+
+func (b *Bot) handleRouteDel(message *telebot.Message) error {
+	id, err := strconv.Atoi(strings.TrimSpace(message.Payload))
+	if err != nil {
+		_, err = b.reply(message, responseRouteDelUsage)
+		return err
+	}
+	err = b.chats.RemoveRoute(id)
+	if errors.Is(err, ErrRouteNotFound) {
+		_, err = b.reply(message, fmt.Sprintf("There is no route %d, the routes are listed by %s.", id, CommandRouteList))
+		return err
+	}
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove route", "err", err)
+		_, err = b.replyStoreError(message, err, "delete the route")
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("Deleted route %d.", id))
+	return err
+}
@@ -0,0 +1,225 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func routedWebhook(receiver string, group, common template.KV) webhook.Message {
+	return webhook.Message{Data: &template.Data{Receiver: receiver, Status: "firing", GroupLabels: group, CommonLabels: common}}
+}
+
+func mustParseRoute(t *testing.T, text string) Route {
+	t.Helper()
+	r, err := ParseRoute(text)
+	require.NoError(t, err)
+	return r
+}
+
+func TestParseRoute(t *testing.T) {
+	r, err := ParseRoute(`receiver["=~team-.*"] group["alertname=HighCPU"] common["severity=~critical|warning", "team!=payments"] chats[-100123, 42] tags[Payments]`)
+	require.NoError(t, err)
+	require.Equal(t, Route{
+		Matchers: []RouteMatcher{
+			{On: "receiver", Name: "receiver", Type: "=~", Value: "team-.*"},
+			{On: "group", Name: "alertname", Type: "=", Value: "HighCPU"},
+			{On: "common", Name: "severity", Type: "=~", Value: "critical|warning"},
+			{On: "common", Name: "team", Type: "!=", Value: "payments"},
+		},
+		ChatIDs: []int64{-100123, 42},
+		Tags:    []string{"payments"},
+	}, r)
+	require.Equal(t, `receiver=~"team-.*" group.alertname="HighCPU" common.severity=~"critical|warning" common.team!="payments" → -100123, 42, tag:payments`, r.String())
+
+	r, err = ParseRoute("/route_add receiver[team-a] chats[1]")
+	require.NoError(t, err)
+	require.Equal(t, []RouteMatcher{{On: "receiver", Name: "receiver", Type: "=", Value: "team-a"}}, r.Matchers)
+
+	r, err = ParseRoute("tags[all]")
+	require.NoError(t, err)
+	require.Equal(t, "everything → tag:all", r.String())
+
+	for text, expected := range map[string]string{
+		"receiver[a]":                     "no chats[...] or tags[...] to send to",
+		"receiver[a, b] chats[1]":         `receiver[] takes one receiver, match several with a regular expression like "=~team-a|team-b"`,
+		`common["severity"] chats[1]`:     `common[...]: bad matcher format: severity`,
+		`group["alertname=~(a"] chats[1]`: "group[...]: error parsing regexp: missing closing ): `^(?:(a)$`",
+		"chats[me]":                       `chats[...]: "me" is no chat ID`,
+		"chats[]":                         "chats[] is empty",
+		"severity[critical] chats[1]":     "unknown severity[], use receiver[...] or group[...] or common[...] or chats[...] or tags[...]",
+		`receiver["=~(a"] chats[1]`:       "error parsing regexp: missing closing ): `^(?:(a)$`",
+	} {
+		_, err := ParseRoute(text)
+		require.EqualError(t, err, expected, text)
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	message := routedWebhook("team-a",
+		template.KV{"alertname": "HighCPU"},
+		template.KV{"alertname": "HighCPU", "severity": "critical", "team": "payments"},
+	)
+	for _, tc := range []struct {
+		route   string
+		matches bool
+	}{
+		{route: "chats[1]", matches: true},
+		{route: "receiver[team-a] chats[1]", matches: true},
+		{route: "receiver[team-b] chats[1]", matches: false},
+		{route: `receiver["=~team-.*"] chats[1]`, matches: true},
+		{route: `receiver["=~team"] chats[1]`, matches: false},
+		{route: `group["alertname=HighCPU"] chats[1]`, matches: true},
+		{route: `group["severity=critical"] chats[1]`, matches: false},
+		{route: `common["severity=critical"] chats[1]`, matches: true},
+		{route: `common["severity=~crit.*|warning"] chats[1]`, matches: true},
+		{route: `common["severity=~warn.*"] chats[1]`, matches: false},
+		{route: `common["team!=payments"] chats[1]`, matches: false},
+		{route: `common["team!~pay.*"] chats[1]`, matches: false},
+		{route: `common["missing=~.*"] chats[1]`, matches: true},
+		{route: `common["missing=x"] chats[1]`, matches: false},
+		{route: `receiver[team-a] common["severity=critical", "team=payments"] chats[1]`, matches: true},
+		{route: `receiver[team-a] common["severity=critical", "team=frontend"] chats[1]`, matches: false},
+	} {
+		t.Run(tc.route, func(t *testing.T) {
+			require.Equal(t, tc.matches, mustParseRoute(t, tc.route).Matches(message))
+		})
+	}
+
+	require.True(t, mustParseRoute(t, "chats[1]").Matches(webhook.Message{}), "routes without matchers match webhooks without data")
+	require.False(t, mustParseRoute(t, "receiver[a] chats[1]").Matches(webhook.Message{}))
+}
+
+func TestRouteWebhook(t *testing.T) {
+	critical := routedWebhook("team-a", nil, template.KV{"severity": "critical"})
+	warning := routedWebhook("team-a", nil, template.KV{"severity": "warning"})
+	other := routedWebhook("team-b", nil, nil)
+
+	for _, tc := range []struct {
+		name     string
+		opts     []BotOption
+		message  webhook.Message
+		expected []int64
+		err      error
+	}{
+		{
+			name:     "first match",
+			message:  critical,
+			expected: []int64{1},
+		},
+		{
+			name:     "all matches",
+			opts:     []BotOption{WithRouteMode(RouteAllMatches)},
+			message:  critical,
+			expected: []int64{1, 2, 3, -100, 123},
+		},
+		{
+			name:     "later route",
+			message:  warning,
+			expected: []int64{2, 3},
+		},
+		{
+			name:    "no match is rejected",
+			message: other,
+			err:     alertmanager.ErrNoRoute,
+		},
+		{
+			name:     "no match goes to the default chat",
+			opts:     []BotOption{WithRouteDefaultChat(-42)},
+			message:  other,
+			expected: []int64{-42},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]BotOption{WithRoutes(
+				mustParseRoute(t, `common["severity=critical"] chats[1]`),
+				mustParseRoute(t, `receiver[team-a] chats[2, 3, 2]`),
+			)}, tc.opts...)
+			b, _, chats := newTestBot(t, opts...)
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, chats.SetTags(testChat, []string{"payments"}))
+			require.NoError(t, chats.SetTags(sharedGroup, []string{"payments"}))
+			_, err := chats.AddRoute(mustParseRoute(t, `common["severity=~critical|warning"] tags[payments] chats[3]`))
+			require.NoError(t, err)
+
+			ids, err := b.RouteWebhook(tc.message)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestRouteStore(t *testing.T) {
+	_, _, chats := newTestBot(t)
+
+	routes, err := chats.ListRoutes()
+	require.NoError(t, err)
+	require.Empty(t, routes)
+
+	for _, text := range []string{"chats[1]", "chats[2]", "chats[3]"} {
+		_, err := chats.AddRoute(mustParseRoute(t, text))
+		require.NoError(t, err)
+	}
+	require.NoError(t, chats.RemoveRoute(3))
+	require.Equal(t, ErrRouteNotFound, chats.RemoveRoute(3))
+
+	r, err := chats.AddRoute(mustParseRoute(t, "chats[4]"))
+	require.NoError(t, err)
+	require.Equal(t, 3, r.ID)
+
+	routes, err = chats.ListRoutes()
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+	for i, r := range routes {
+		require.Equal(t, i+1, r.ID)
+	}
+	require.Equal(t, []int64{4}, routes[2].ChatIDs)
+}
+
+func TestRouteCommands(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithRoutes(mustParseRoute(t, "receiver[team-a] chats[1]")))
+	handlers := map[string]func(*telebot.Message) error{
+		CommandRouteAdd:  b.handleRouteAdd,
+		CommandRouteList: b.handleRouteList,
+		CommandRouteDel:  b.handleRouteDel,
+	}
+	command := func(text string) string {
+		t.Helper()
+		fields := strings.SplitN(text, " ", 2)
+		m := &telebot.Message{Sender: testAdmin, Chat: testChat, Text: text}
+		if len(fields) == 2 {
+			m.Payload = fields[1]
+		}
+		require.NoError(t, handlers[fields[0]](m))
+		return tb.lastText()
+	}
+
+	require.Equal(t, responseRouteAddUsage, command("/route_add"))
+	require.Equal(t, "failed to parse route... no chats[...] or tags[...] to send to\n"+responseRouteAddUsage, command("/route_add receiver[team-b]"))
+	require.Equal(t, `Added route 1: common.severity="critical" → tag:payments`, command(`/route_add common["severity=critical"] tags[payments]`))
+	require.Equal(t, "Webhooks without a chat ID go to the first matching route, or are rejected if none matches.\n\n"+
+		`configured: receiver="team-a" → 1`+"\n"+
+		`1: common.severity="critical" → tag:payments`, command("/route_list"))
+
+	require.Equal(t, responseRouteDelUsage, command("/route_del one"))
+	require.Equal(t, "There is no route 2, the routes are listed by /route_list.", command("/route_del 2"))
+	require.Equal(t, "Deleted route 1.", command("/route_del 1"))
+	require.Equal(t, "Webhooks without a chat ID go to the first matching route, or are rejected if none matches.\n\n"+
+		`configured: receiver="team-a" → 1`, command("/route_list"))
+}
+
+func TestRouteListEmpty(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.NoError(t, b.handleRouteList(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: "/route_list"}))
+	require.Equal(t, "There are no routes for webhooks without a chat ID.", tb.lastText())
+}
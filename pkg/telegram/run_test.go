@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"go.uber.org/goleak"
+)
+
+func TestRunShutdownRun(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	b, tb, chats := newTestBot(t,
+		WithWebhookWorkers(4),
+		WithSuppressedCriticalAlerting(true),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+	)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	starts := func() int {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+		return tb.starts
+	}
+
+	webhooks := make(chan alertmanager.TelegramWebhook, 8)
+	var handlers int
+	for round := 1; round <= 2; round++ {
+		done := make(chan error, 1)
+		go func() { done <- b.Run(context.Background(), ChannelSource(webhooks)) }()
+		require.Eventually(t, func() bool { return starts() == round }, time.Second, time.Millisecond)
+		require.Error(t, b.Run(context.Background(), ChannelSource(webhooks)), "a second Run has to wait for the first to return")
+
+		// Webhooks queued before Shutdown are still delivered.
+		for i := 0; i < 3; i++ {
+			webhooks <- suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"})
+		}
+		require.Eventually(t, func() bool { return len(tb.messages()) == 3*round }, time.Second, time.Millisecond)
+		require.NoError(t, b.Shutdown(context.Background()))
+		require.NoError(t, <-done)
+
+		tb.mu.Lock()
+		if round == 1 {
+			handlers = len(tb.handlers)
+		}
+		require.Equal(t, handlers, len(tb.handlers))
+		tb.mu.Unlock()
+	}
+
+	require.NoError(t, b.Shutdown(context.Background()), "shutting down a stopped bot does nothing")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	release := make(chan struct{})
+	tb.beforeSend = func() { <-release }
+
+	webhooks := make(chan alertmanager.TelegramWebhook, 8)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background(), ChannelSource(webhooks)) }()
+	for i := 0; i < 3; i++ {
+		webhooks <- suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"})
+	}
+	require.Eventually(t, func() bool { return len(webhooks) == 0 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		// Let Shutdown give up on the queue before the stuck send returns.
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	require.Equal(t, context.DeadlineExceeded, b.Shutdown(ctx))
+	require.NoError(t, <-done)
+	require.Less(t, len(tb.messages()), 3, "the queued webhooks are dropped once the deadline passed")
+}
@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"errors"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// telebotBot is the Telebot of a real bot, telling which user the bot is.
+type telebotBot struct {
+	*telebot.Bot
+}
+
+// Me returns the bot's own user, as Telegram told when the bot was created.
+func (t telebotBot) Me() *telebot.User {
+	return t.Bot.Me
+}
+
+// isSelf returns whether u is the bot itself.
+func (b *Bot) isSelf(u *telebot.User) bool {
+	if u == nil {
+		return false
+	}
+	me := b.telegram.Me()
+	return me != nil && me.ID != 0 && u.ID == me.ID
+}
+
+// ownMessage returns whether the bot sent the message itself, or it was sent through the bot's inline mode.
+// Handling those can loop: replies to them are handled again and again.
+func (b *Bot) ownMessage(m *telebot.Message) bool {
+	return b.isSelf(m.Sender) || b.isSelf(m.Via)
+}
+
+// skipOwnMessage logs and counts the bot's own message, if it is one, and returns whether to skip it.
+func (b *Bot) skipOwnMessage(m *telebot.Message) bool {
+	if !b.ownMessage(m) {
+		return false
+	}
+	level.Debug(b.logger).Log("msg", "skipping the bot's own message", "chat_id", m.Chat.ID, "message_id", m.ID)
+	b.ownMessagesSkipped.Inc()
+	return true
+}
+
+// fallbackLoops returns whether making fallbackID the fallback chat of chatID lets alerts go round in circles,
+// following the fallback chats of the fallback chats.
+func (b *Bot) fallbackLoops(chatID, fallbackID int64) (bool, error) {
+	seen := map[int64]bool{chatID: true}
+	for id := fallbackID; id != 0; {
+		if seen[id] {
+			return true, nil
+		}
+		seen[id] = true
+		ci, err := b.chats.GetChatInfo(id)
+		if errors.Is(err, ErrChatNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		id = ci.FallbackChatID
+	}
+	return false, nil
+}
@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var testBotUser = &telebot.User{ID: 555, Username: "alertmanager_bot", IsBot: true}
+
+func TestOwnMessagesSkipped(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithExtraAdmins(testBotUser.ID))
+	tb.me = testBotUser
+	skipped := testutil.ToFloat64(b.ownMessagesSkipped)
+
+	var handled int
+	handler := b.middleware(func(*telebot.Message) error {
+		handled++
+		return nil
+	})
+	handler(&telebot.Message{Sender: testBotUser, Chat: testChat, Text: CommandStatus})
+	handler(&telebot.Message{Sender: testAdmin, Via: testBotUser, Chat: testChat, Text: CommandStatus})
+	b.handleChannelPost(&telebot.Message{Sender: testBotUser, Chat: &telebot.Chat{ID: -1001, Type: telebot.ChatChannel}, Text: CommandID})
+	require.Zero(t, handled, "the bot's own messages aren't handled, even if it's an admin")
+	require.Empty(t, tb.messages())
+	require.Equal(t, skipped+3, testutil.ToFloat64(b.ownMessagesSkipped))
+
+	handler(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStatus})
+	require.Equal(t, 1, handled)
+}
+
+func TestFallbackLoops(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	tb.me = testBotUser
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	standby := &telebot.Chat{ID: -300, Title: "standby", Type: telebot.ChatGroup}
+	require.NoError(t, chats.AddChat(standby, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetFallback(oncall, standby.ID))
+	require.NoError(t, chats.SetFallback(standby, payments.ID))
+
+	require.NoError(t, b.handleFallback(&telebot.Message{Chat: payments, Sender: testAdmin, Payload: "-200"}))
+	require.Equal(t, "Chat -200 falls back to this chat already, alerts would go round in circles.", tb.lastText())
+	require.NoError(t, b.handleFallback(&telebot.Message{Chat: payments, Sender: testAdmin, Payload: "555"}))
+	require.Equal(t, "The bot can't be a fallback chat.", tb.lastText())
+
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Zero(t, ci.FallbackChatID)
+}
@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const CommandResolved = "/resolved"
+
+// WithSendResolved sets if chats get notified about resolved alerts, unless they chose otherwise with /resolved.
+func WithSendResolved(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.sendResolved = enabled
+		return nil
+	}
+}
+
+// sendsResolved tells if the chat gets notified about resolved alerts.
+func (b *Bot) sendsResolved(ci *ChatInfo) bool {
+	if ci.SendResolved != nil {
+		return *ci.SendResolved
+	}
+	return b.sendResolved
+}
+
+// firingAlerts drops the resolved alerts.
+func firingAlerts(alerts template.Alerts) template.Alerts {
+	firing := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		if a.Status != "resolved" {
+			firing = append(firing, a)
+		}
+	}
+	return firing
+}
+
+// SetSendResolved sets if the chat gets notified about resolved alerts.
+func (s *ChatStore) SetSendResolved(c *telebot.Chat, enabled bool) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.SendResolved = &enabled
+		return nil
+	})
+}
+
+func (b *Bot) handleResolved(message *telebot.Message) error {
+	var enabled bool
+	switch strings.TrimSpace(message.Payload) {
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		_, err := b.reply(message, "Usage: "+CommandResolved+" on|off")
+		return err
+	}
+
+	if err := b.chats.SetSendResolved(message.Chat, enabled); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set resolved notifications", "err", err)
+		_, err = b.replyStoreError(message, err, "set resolved notifications")
+		return err
+	}
+
+	if enabled {
+		_, err := b.reply(message, "This chat will be notified about resolved alerts.")
+		return err
+	}
+	_, err := b.reply(message, "This chat won't be notified about resolved alerts anymore, only about firing ones.")
+	return err
+}
@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func mixedWebhook(status string, alerts ...template.Alert) alertmanager.TelegramWebhook {
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{Status: status, Alerts: alerts}}}
+}
+
+func TestSendResolved(t *testing.T) {
+	firing := template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}}
+	resolved := template.Alert{Status: "resolved", Labels: template.KV{"alertname": "DiskFull"}}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []BotOption
+		chat     string
+		webhook  alertmanager.TelegramWebhook
+		expected []string
+		dropped  []string
+	}{
+		{
+			name:     "default sends resolved",
+			opts:     []BotOption{WithSplitByStatus(false)},
+			webhook:  mixedWebhook("firing", firing, resolved),
+			expected: []string{"HighCPU", "DiskFull"},
+		},
+		{
+			name:     "chat off drops resolved of mixed payloads",
+			chat:     "off",
+			webhook:  mixedWebhook("firing", firing, resolved),
+			expected: []string{"HighCPU"},
+			dropped:  []string{"DiskFull"},
+		},
+		{
+			name:    "chat off drops resolved only payloads",
+			chat:    "off",
+			webhook: mixedWebhook("resolved", resolved),
+		},
+		{
+			name:     "bot off drops resolved",
+			opts:     []BotOption{WithSendResolved(false)},
+			webhook:  mixedWebhook("firing", firing, resolved),
+			expected: []string{"HighCPU"},
+			dropped:  []string{"DiskFull"},
+		},
+		{
+			name:     "chat on overrides bot off",
+			opts:     []BotOption{WithSendResolved(false)},
+			chat:     "on",
+			webhook:  mixedWebhook("resolved", resolved),
+			expected: []string{"DiskFull"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]BotOption{WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl")}, tc.opts...)
+			b, tb, chats := newTestBot(t, opts...)
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+			if tc.chat != "" {
+				require.NoError(t, b.handleResolved(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: tc.chat}))
+			}
+			sent := len(tb.messages())
+
+			_, err := b.processWebhook(context.Background(), tc.webhook)
+			require.NoError(t, err)
+
+			msgs := tb.messages()[sent:]
+			if len(tc.expected) == 0 {
+				require.Empty(t, msgs)
+				return
+			}
+			require.Len(t, msgs, 1)
+			for _, name := range tc.expected {
+				require.Contains(t, msgs[0].text(), "<b>"+name+"</b>")
+			}
+			for _, name := range tc.dropped {
+				require.False(t, strings.Contains(msgs[0].text(), name), msgs[0].text())
+			}
+		})
+	}
+}
+
+func TestHandleResolved(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleResolved(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "Usage: /resolved on|off", tb.lastText())
+
+	require.NoError(t, b.handleResolved(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "off"}))
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Contains(t, tb.lastText(), "\nResolved alerts: off\n")
+
+	require.NoError(t, b.handleResolved(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "on"}))
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Contains(t, tb.lastText(), "\nResolved alerts: on\n")
+}
@@ -0,0 +1,324 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandSentLog = "/sent_log"
+
+	// messageSinkQueueLength is how many sent messages wait for the sinks before more are dropped.
+	messageSinkQueueLength = 1024
+	// messageSinkTimeout is the longest a sink gets to record a message.
+	messageSinkTimeout = 10 * time.Second
+	// sentLogShown is how many of a chat's sent messages /sent_log shows.
+	sentLogShown = 10
+	// sentLogTextLength is how much of the text of each message /sent_log shows.
+	sentLogTextLength = 200
+
+	// supergroupIDOffset is what the IDs of supergroups and channels are below, by their ID in Telegram.
+	// Their message links have that ID, chat -1001234567890 is c/1234567890 in them.
+	supergroupIDOffset = -1000000000000
+	// maxChannelID is the highest ID of a supergroup or channel in Telegram, lower chat IDs are something else.
+	maxChannelID = 1000000000000 - (1 << 31)
+)
+
+// SentMessage is a message the bot sent, as given to the MessageSinks.
+type SentMessage struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text"`
+	// Origin is what the message was sent for: "webhook <group key>" or "command <command>".
+	Origin string `json:"origin"`
+}
+
+// MessageSink records the messages the bot sent, for audits. Sinks that are io.Closers are closed
+// when Run returns, after recording what was sent until then, and must take records again afterwards.
+type MessageSink interface {
+	Record(ctx context.Context, m SentMessage) error
+}
+
+// SentLog is a MessageSink that can tell what was sent to a chat, for /sent_log.
+type SentLog interface {
+	MessageSink
+	// Sent returns the messages recorded for the chat, oldest first.
+	Sent(chatID int64) ([]SentMessage, error)
+}
+
+// WithMessageSinks records every notification and command reply the bot sends with the sinks.
+// The sinks record in the background, a failing or slow sink doesn't hold up sending.
+func WithMessageSinks(sinks ...MessageSink) BotOption {
+	return func(b *Bot) error {
+		if len(sinks) == 0 {
+			return nil
+		}
+		if b.messageSinks == nil {
+			b.messageSinks = &messageSinks{records: make(chan SentMessage, messageSinkQueueLength)}
+		}
+		b.messageSinks.sinks = append(b.messageSinks.sinks, sinks...)
+		return nil
+	}
+}
+
+// messageSinks hands the sent messages to the sinks in order, in a goroutine started with the first message
+// and stopped when Run returns.
+type messageSinks struct {
+	sinks   []MessageSink
+	records chan SentMessage
+
+	mu sync.Mutex
+	// stop and done are the running goroutine's, nil while none runs.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// send sends what to the recipient like telebot does and hands it to the message sinks as sent for origin.
+// Everything the bot sends goes through it, or through reply for command replies.
+func (b *Bot) send(to telebot.Recipient, what interface{}, origin string, options ...interface{}) (*telebot.Message, error) {
+	sent, err := b.telegram.Send(to, what, options...)
+	if err == nil {
+		b.recordSent(sent, what, origin)
+	}
+	return sent, err
+}
+
+// recordSent hands a sent message to the sinks, or drops it if they're behind.
+func (b *Bot) recordSent(sent *telebot.Message, what interface{}, origin string) {
+	if b.messageSinks == nil || sent == nil || sent.Chat == nil {
+		return
+	}
+	m := SentMessage{
+		ChatID:    sent.Chat.ID,
+		MessageID: sent.ID,
+		Time:      time.Now().UTC(),
+		Text:      sentText(sent, what),
+		Origin:    origin,
+	}
+
+	b.startMessageSinks()
+	select {
+	case b.messageSinks.records <- m:
+	default:
+		level.Warn(b.logger).Log("msg", "message sinks are behind, dropping sent message", "chat_id", m.ChatID, "message_id", m.MessageID)
+		b.messageSinkFailures.WithLabelValues("dropped").Inc()
+	}
+}
+
+// startMessageSinks starts recording the sent messages with the sinks, unless that's running already.
+func (b *Bot) startMessageSinks() {
+	s := b.messageSinks
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go b.drainMessageSinks(s.stop, s.done)
+}
+
+// stopMessageSinks records the messages sent so far, stops recording them and closes the sinks that are
+// io.Closers, flushing what they hold. Messages sent afterwards start recording them again.
+func (b *Bot) stopMessageSinks() {
+	s := b.messageSinks
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop, s.done = nil, nil
+	}
+	for _, sink := range s.sinks {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to close message sink", "sink", fmt.Sprintf("%T", sink), "err", err)
+			}
+		}
+	}
+}
+
+// drainMessageSinks records the sent messages with every sink until stop is closed, then records those
+// still queued and closes done.
+func (b *Bot) drainMessageSinks(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		select {
+		case m := <-b.messageSinks.records:
+			b.recordWithSinks(m)
+		case <-stop:
+			for {
+				select {
+				case m := <-b.messageSinks.records:
+					b.recordWithSinks(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// recordWithSinks records a sent message with every sink.
+func (b *Bot) recordWithSinks(m SentMessage) {
+	for _, sink := range b.messageSinks.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), messageSinkTimeout)
+		err := sink.Record(ctx, m)
+		cancel()
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to record sent message", "sink", fmt.Sprintf("%T", sink), "chat_id", m.ChatID, "err", err)
+			b.messageSinkFailures.WithLabelValues("failed").Inc()
+		}
+	}
+}
+
+// sentText is the text of what was sent, documents are named by their file.
+func sentText(sent *telebot.Message, what interface{}) string {
+	switch w := what.(type) {
+	case string:
+		return w
+	case *telebot.Document:
+		return "[document " + w.FileName + "]"
+	}
+	return sent.Text
+}
+
+// KVSink keeps the last messages sent to each chat in the store, as a ring buffer per chat.
+type KVSink struct {
+	kv     store.Store
+	prefix string
+	size   int
+
+	mu sync.Mutex
+}
+
+// NewKVSink keeps the last size messages sent to each chat under prefix.
+func NewKVSink(kv store.Store, prefix string, size int) (*KVSink, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("the sent log must keep at least 1 message per chat, is %d", size)
+	}
+	return &KVSink{kv: kv, prefix: strings.TrimSuffix(prefix, "/"), size: size}, nil
+}
+
+func (s *KVSink) key(chatID int64) string {
+	return s.prefix + "/" + strconv.FormatInt(chatID, 10)
+}
+
+// Record adds the message to its chat's log, dropping the oldest messages beyond the size.
+func (s *KVSink) Record(_ context.Context, m SentMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent, err := s.Sent(m.ChatID)
+	if err != nil {
+		return err
+	}
+	sent = append(sent, m)
+	if len(sent) > s.size {
+		sent = sent[len(sent)-s.size:]
+	}
+	b, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(s.key(m.ChatID), b, nil)
+}
+
+// Sent returns the messages kept for the chat, oldest first.
+func (s *KVSink) Sent(chatID int64) ([]SentMessage, error) {
+	kvPair, err := s.kv.Get(s.key(chatID))
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sent []SentMessage
+	if err := json.Unmarshal(kvPair.Value, &sent); err != nil {
+		return nil, fmt.Errorf("failed to read the sent log of chat %d: %w", chatID, err)
+	}
+	return sent, nil
+}
+
+// messageLink returns the t.me link of a message, "" if it has none. Only supergroups and channels have links
+// by chat ID, private chats and legacy groups, whose IDs aren't below -10^12, don't. The links open for members only.
+func messageLink(chatID int64, messageID int) string {
+	if chatID >= supergroupIDOffset || chatID < supergroupIDOffset-maxChannelID || messageID <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%d/%d", supergroupIDOffset-chatID, messageID)
+}
+
+// sentLog returns the first sink that can list what was sent, nil if none can.
+func (b *Bot) sentLog() SentLog {
+	if b.messageSinks == nil {
+		return nil
+	}
+	for _, s := range b.messageSinks.sinks {
+		if l, ok := s.(SentLog); ok {
+			return l
+		}
+	}
+	return nil
+}
+
+func (b *Bot) handleSentLog(message *telebot.Message) error {
+	kept := b.sentLog()
+	if kept == nil {
+		_, err := b.reply(message, "The bot doesn't keep a log of the messages it sends.")
+		return err
+	}
+
+	chatID := message.Chat.ID
+	if payload := strings.TrimSpace(message.Payload); payload != "" {
+		id, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			_, err = b.reply(message, "Usage: "+CommandSentLog+" [chat_id]")
+			return err
+		}
+		chatID = id
+	}
+
+	sent, err := kept.Sent(chatID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to read the sent log", "err", err)
+		_, err = b.respondFailed(message, "read the sent log", err)
+		return err
+	}
+	if len(sent) == 0 {
+		_, err = b.reply(message, fmt.Sprintf("No messages to chat %d are logged.", chatID))
+		return err
+	}
+	if len(sent) > sentLogShown {
+		sent = sent[len(sent)-sentLogShown:]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The last %d messages sent to chat %d:\n", len(sent), chatID)
+	for _, m := range sent {
+		text := plainText(m.Text)
+		if r := []rune(text); len(r) > sentLogTextLength {
+			text = string(r[:sentLogTextLength]) + "…"
+		}
+		fmt.Fprintf(&sb, "\n%s #%d, %s:\n%s\n", m.Time.UTC().Format("2006-01-02 15:04:05 UTC"), m.MessageID, m.Origin, text)
+		if link := messageLink(m.ChatID, m.MessageID); link != "" {
+			sb.WriteString(link + "\n")
+		}
+	}
+	_, err = b.reply(message, b.truncateMessage(sb.String()))
+	return err
+}
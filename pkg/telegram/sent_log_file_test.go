@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sentLogLines returns the message IDs in the JSON lines file, nil if there's none.
+func sentLogLines(t *testing.T, path string) []int {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	defer f.Close()
+
+	var ids []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m SentMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		ids = append(ids, m.MessageID)
+	}
+	require.NoError(t, scanner.Err())
+	return ids
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.jsonl")
+	line, err := json.Marshal(SentMessage{ChatID: testChat.ID, MessageID: 1, Text: "HighCPU"})
+	require.NoError(t, err)
+
+	// Two lines fit into a file.
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	require.NoError(t, err)
+	for i := 1; i <= 7; i++ {
+		require.NoError(t, sink.Record(context.Background(), SentMessage{ChatID: testChat.ID, MessageID: i, Text: "HighCPU"}))
+	}
+	require.NoError(t, sink.Close())
+
+	require.Equal(t, []int{7}, sentLogLines(t, path))
+	require.Equal(t, []int{5, 6}, sentLogLines(t, path+".1"))
+	require.Equal(t, []int{3, 4}, sentLogLines(t, path+".2"))
+	require.Nil(t, sentLogLines(t, path+".3"), "older files are removed")
+
+	// Reopening appends and keeps counting the size.
+	sink, err = NewFileSink(path, int64(2*(len(line)+1)), 2)
+	require.NoError(t, err)
+	for i := 8; i <= 9; i++ {
+		require.NoError(t, sink.Record(context.Background(), SentMessage{ChatID: testChat.ID, MessageID: i, Text: "HighCPU"}))
+	}
+	require.NoError(t, sink.Close())
+	require.Equal(t, []int{9}, sentLogLines(t, path))
+	require.Equal(t, []int{7, 8}, sentLogLines(t, path+".1"))
+	require.Equal(t, []int{5, 6}, sentLogLines(t, path+".2"))
+}
+
+func TestFileSinkLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.jsonl")
+	sink, err := NewFileSink(path, 10, 0)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	for i := 1; i <= 2; i++ {
+		require.NoError(t, sink.Record(context.Background(), SentMessage{MessageID: i, Text: "longer than the file may be"}))
+	}
+	require.Equal(t, []int{2}, sentLogLines(t, path), "a line too long for the file gets a file of its own")
+}
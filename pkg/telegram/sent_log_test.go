@@ -0,0 +1,208 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"go.uber.org/goleak"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// recordingSink keeps what it's given, failing with err if set.
+type recordingSink struct {
+	mu       sync.Mutex
+	recorded []SentMessage
+	err      error
+	block    chan struct{}
+}
+
+func (s *recordingSink) Record(_ context.Context, m SentMessage) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded = append(s.recorded, m)
+	return s.err
+}
+
+func (s *recordingSink) messages() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SentMessage(nil), s.recorded...)
+}
+
+func TestMessageSinks(t *testing.T) {
+	sink := &recordingSink{}
+	failing := &recordingSink{err: errors.New("disk full")}
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithMessageSinks(failing, sink))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	failed := testutil.ToFloat64(b.messageSinkFailures.WithLabelValues("failed"))
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "HighCPU"}})
+	w.Message.GroupKey = `{}:{alertname="HighCPU"}`
+	d, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Equal(t, 1, d.Messages, "a failing sink doesn't fail the send")
+	require.NoError(t, b.handleResolved(&telebot.Message{ID: 42, Sender: testAdmin, Chat: testChat, Text: "/resolved off", Payload: "off"}))
+
+	require.Eventually(t, func() bool { return len(sink.messages()) == 2 }, time.Second, time.Millisecond)
+	recorded := sink.messages()
+	msgs := tb.messages()
+	require.Equal(t, testChat.ID, recorded[0].ChatID)
+	require.Equal(t, 1, recorded[0].MessageID)
+	require.Equal(t, `webhook {}:{alertname="HighCPU"}`, recorded[0].Origin)
+	require.Equal(t, msgs[0].text(), recorded[0].Text)
+	require.Equal(t, "command /resolved", recorded[1].Origin)
+	require.Equal(t, msgs[1].text(), recorded[1].Text)
+	require.WithinDuration(t, time.Now(), recorded[1].Time, time.Minute)
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(b.messageSinkFailures.WithLabelValues("failed")) == failed+2
+	}, time.Second, time.Millisecond)
+}
+
+func TestMessageSinksBehind(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	b, _, chats := newTestBot(t, WithMessageSinks(sink))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	dropped := testutil.ToFloat64(b.messageSinkFailures.WithLabelValues("dropped"))
+
+	// One message is taken by the blocked sink, the queue holds the next ones.
+	for i := 0; i < messageSinkQueueLength+3; i++ {
+		require.NoError(t, b.handleID(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandID}))
+	}
+	require.GreaterOrEqual(t, testutil.ToFloat64(b.messageSinkFailures.WithLabelValues("dropped")), dropped+2, "sending doesn't wait for the sinks")
+	close(sink.block)
+}
+
+func TestKVSink(t *testing.T) {
+	sink, err := NewKVSink(newMemoryKV(), "telegram/sent_log/", 3)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, sink.Record(ctx, SentMessage{ChatID: testChat.ID, MessageID: i, Text: "alert"}))
+	}
+	require.NoError(t, sink.Record(ctx, SentMessage{ChatID: -100, MessageID: 1}))
+
+	sent, err := sink.Sent(testChat.ID)
+	require.NoError(t, err)
+	require.Len(t, sent, 3, "only the last messages are kept")
+	require.Equal(t, []int{3, 4, 5}, []int{sent[0].MessageID, sent[1].MessageID, sent[2].MessageID})
+
+	sent, err = sink.Sent(999)
+	require.NoError(t, err)
+	require.Empty(t, sent)
+
+	_, err = NewKVSink(newMemoryKV(), "telegram/sent_log", 0)
+	require.Error(t, err)
+}
+
+func TestHandleSentLog(t *testing.T) {
+	b, tb, _ := newTestBot(t)
+	require.NoError(t, b.handleSentLog(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSentLog}))
+	require.Equal(t, "The bot doesn't keep a log of the messages it sends.", tb.lastText())
+
+	sink, err := NewKVSink(newMemoryKV(), "telegram/sent_log", 20)
+	require.NoError(t, err)
+	b, tb, _ = newTestBot(t, WithMessageSinks(sink))
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, sink.Record(context.Background(), SentMessage{
+		ChatID: -100, MessageID: 7, Time: at, Text: "<b>HighCPU</b> &amp; more", Origin: `webhook {}:{alertname="HighCPU"}`,
+	}))
+
+	require.NoError(t, b.handleSentLog(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSentLog + " -100", Payload: "-100"}))
+	require.Equal(t, "The last 1 messages sent to chat -100:\n\n"+
+		"2021-03-04 05:06:07 UTC #7, webhook {}:{alertname=\"HighCPU\"}:\nHighCPU & more\n", tb.lastText())
+
+	require.NoError(t, b.handleSentLog(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSentLog}))
+	require.Equal(t, "No messages to chat 123 are logged.", tb.lastText())
+
+	require.NoError(t, b.handleSentLog(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSentLog + " chat", Payload: "chat"}))
+	require.Equal(t, "Usage: /sent_log [chat_id]", tb.lastText())
+}
+
+func TestMessageLink(t *testing.T) {
+	for _, tc := range []struct {
+		chatID    int64
+		messageID int
+		expected  string
+	}{
+		{-1001234567890, 42, "https://t.me/c/1234567890/42"},
+		{-1000000000001, 1, "https://t.me/c/1/1"},
+		{-1997852516352, 7, "https://t.me/c/997852516352/7"},
+		// Supergroups have no ID at the offset itself, or below the highest channel ID.
+		{-1000000000000, 42, ""},
+		{-1997852516353, 42, ""},
+		// Legacy groups and private chats have no links by ID.
+		{-123456789, 42, ""},
+		{-100, 42, ""},
+		{123, 42, ""},
+		{0, 42, ""},
+		{-1001234567890, 0, ""},
+	} {
+		require.Equal(t, tc.expected, messageLink(tc.chatID, tc.messageID), "%d/%d", tc.chatID, tc.messageID)
+	}
+}
+
+func TestSentLogLinks(t *testing.T) {
+	sink, err := NewKVSink(newMemoryKV(), "telegram/sent_log", 20)
+	require.NoError(t, err)
+	b, tb, _ := newTestBot(t, WithMessageSinks(sink))
+	at := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, sink.Record(context.Background(), SentMessage{ChatID: -1001234567890, MessageID: 7, Time: at, Text: "HighCPU", Origin: "command /alerts"}))
+
+	require.NoError(t, b.handleSentLog(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSentLog + " -1001234567890", Payload: "-1001234567890"}))
+	require.Equal(t, "The last 1 messages sent to chat -1001234567890:\n\n"+
+		"2021-03-04 05:06:07 UTC #7, command /alerts:\nHighCPU\nhttps://t.me/c/1234567890/7\n", tb.lastText())
+}
+
+// closingSink is a recordingSink that counts how often it's closed.
+type closingSink struct {
+	recordingSink
+	closed int
+}
+
+func (s *closingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed++
+	return nil
+}
+
+func TestMessageSinksStopWithRun(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	sink := &closingSink{}
+	b, _, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithMessageSinks(sink))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+
+	webhooks := make(chan alertmanager.TelegramWebhook, 8)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background(), ChannelSource(webhooks)) }()
+	for i := 0; i < 3; i++ {
+		webhooks <- suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"})
+	}
+	b.SendAdminMessage(testAdmin.ID, "Chat payments was removed")
+	require.Eventually(t, func() bool { return len(webhooks) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, b.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+
+	recorded := sink.messages()
+	require.Len(t, recorded, 4, "what was sent is recorded by the time Run returns")
+	var origins []string
+	for _, m := range recorded {
+		origins = append(origins, m.Origin)
+	}
+	require.Contains(t, origins, "admin message", "messages the bot sends on its own are recorded, too")
+	require.Equal(t, 1, sink.closed)
+}
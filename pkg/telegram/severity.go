@@ -0,0 +1,247 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	labelSeverity = "severity"
+
+	// defaultSeverityEmoji is the symbol of firing alerts whose severity has no emoji.
+	defaultSeverityEmoji = "🔥"
+)
+
+// The severity orderings of WithSeverityOrdering besides a list of the severities.
+const (
+	// SeverityNumericAscending ranks numeric severities, higher numbers are more severe.
+	SeverityNumericAscending = "numeric-ascending"
+	// SeverityNumericDescending ranks numeric severities, lower numbers are more severe, like 1 is the worst in PagerDuty.
+	SeverityNumericDescending = "numeric-descending"
+)
+
+// severityOrdering ranks the values of the severity label.
+type severityOrdering struct {
+	// numeric is 1 if higher numbers are more severe, -1 if lower ones are and 0 for a list of levels.
+	numeric float64
+	// levels are the known severities from the least to the most severe, if they're listed.
+	levels []string
+}
+
+// defaultSeverityOrdering knows the severities of the default templates.
+var defaultSeverityOrdering = severityOrdering{levels: []string{"info", "warning", "critical"}}
+
+// parseSeverityOrdering reads numeric-ascending, numeric-descending or the severities from the least to the most
+// severe, like info,warning,critical.
+func parseSeverityOrdering(spec string) (severityOrdering, error) {
+	switch spec {
+	case SeverityNumericAscending:
+		return severityOrdering{numeric: 1}, nil
+	case SeverityNumericDescending:
+		return severityOrdering{numeric: -1}, nil
+	}
+	var levels []string
+	for _, level := range strings.Split(spec, ",") {
+		level = strings.TrimSpace(level)
+		if level == "" {
+			return severityOrdering{}, fmt.Errorf("the severity ordering %q has an empty severity", spec)
+		}
+		if contains(levels, level) {
+			return severityOrdering{}, fmt.Errorf("the severity ordering %q has %s twice", spec, level)
+		}
+		levels = append(levels, level)
+	}
+	return severityOrdering{levels: levels}, nil
+}
+
+// WithSeverityOrdering sets how severities rank for minimum severities, the emoji of summaries and tallies:
+// numeric-ascending, numeric-descending or a list from the least to the most severe, which is
+// info,warning,critical by default. Severities the ordering doesn't cover rank lowest.
+func WithSeverityOrdering(spec string) BotOption {
+	return func(b *Bot) error {
+		o, err := parseSeverityOrdering(spec)
+		if err != nil {
+			return err
+		}
+		b.severityOrdering = o
+		return nil
+	}
+}
+
+// rank returns how severe the severity is, higher is more severe, and false if the ordering doesn't cover it.
+func (o severityOrdering) rank(severity string) (float64, bool) {
+	if o.numeric != 0 {
+		n, err := strconv.ParseFloat(strings.TrimSpace(severity), 64)
+		if err != nil || math.IsNaN(n) {
+			return 0, false
+		}
+		return o.numeric * n, true
+	}
+	for i, s := range o.levels {
+		if s == severity {
+			return float64(i), true
+		}
+	}
+	return 0, false
+}
+
+// known tells if the ordering covers the severity.
+func (o severityOrdering) known(severity string) bool {
+	_, ok := o.rank(severity)
+	return ok
+}
+
+// compare returns -1, 0 or 1 as a is less, as or more severe than b. Severities the ordering doesn't cover
+// rank lowest, they're as severe as each other.
+func (o severityOrdering) compare(a, b string) int {
+	ra, okA := o.rank(a)
+	rb, okB := o.rank(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	return 0
+}
+
+// describe tells which severities the ordering knows, for replies and logs.
+func (o severityOrdering) describe() string {
+	if o.numeric != 0 {
+		return "a number"
+	}
+	return "one of " + strings.Join(o.levels, ", ")
+}
+
+// atLeast drops the alerts less severe than min, all are kept if min is empty or unknown.
+// Alerts with a severity the ordering doesn't cover, or none, are kept too, so that nothing is lost because of
+// a typo in a rule.
+func (o severityOrdering) atLeast(alerts template.Alerts, min string) template.Alerts {
+	if !o.known(min) {
+		return alerts
+	}
+
+	kept := make(template.Alerts, 0, len(alerts))
+	for _, a := range alerts {
+		if s := a.Labels[labelSeverity]; !o.known(s) || o.compare(s, min) >= 0 {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// below returns the listed severities less severe than min, nil for numeric orderings.
+func (o severityOrdering) below(min string) []string {
+	for i, s := range o.levels {
+		if s == min {
+			return o.levels[:i]
+		}
+	}
+	return nil
+}
+
+// mostSevere returns the severity of the most severe alert.
+func (o severityOrdering) mostSevere(alerts template.Alerts) string {
+	var severity string
+	for i, a := range alerts {
+		if s := a.Labels[labelSeverity]; i == 0 || o.compare(s, severity) > 0 {
+			severity = s
+		}
+	}
+	return severity
+}
+
+// countUnknownSeverities counts the alerts whose severity the ordering doesn't cover, so that misconfigured
+// severities show up in the metrics. Alerts without a severity aren't counted.
+func (b *Bot) countUnknownSeverities(alerts template.Alerts) {
+	for _, a := range alerts {
+		if s := a.Labels[labelSeverity]; s != "" && !b.severityOrdering.known(s) {
+			b.unknownSeverities.WithLabelValues(s).Inc()
+		}
+	}
+}
+
+// WithSeverityEmoji sets the emoji of each severity, like 🟥 for critical.
+// Templates get them with the severityEmoji func, and the summaries and digests the bot builds use them too.
+// Severities without an emoji get 🔥.
+func WithSeverityEmoji(emoji map[string]string) BotOption {
+	return func(b *Bot) error {
+		for severity, e := range emoji {
+			if strings.TrimSpace(e) == "" {
+				return fmt.Errorf("the emoji of severity %q must not be empty", severity)
+			}
+		}
+		b.severityEmojis = emoji
+		return nil
+	}
+}
+
+// severityEmoji returns the emoji of the severity, defaultSeverityEmoji if it has none.
+func (b *Bot) severityEmoji(severity string) string {
+	if e, ok := b.severityEmojis[severity]; ok {
+		return e
+	}
+	return defaultSeverityEmoji
+}
+
+// severityEmojiFunc returns severityEmoji, or nil without severity emoji.
+func (b *Bot) severityEmojiFunc() func(string) string {
+	if len(b.severityEmojis) == 0 {
+		return nil
+	}
+	return b.severityEmoji
+}
+
+// mostSevereEmojiFunc returns the emoji of the most severe of some alerts, or nil without severity emoji.
+func (b *Bot) mostSevereEmojiFunc() func(template.Alerts) string {
+	if len(b.severityEmojis) == 0 {
+		return nil
+	}
+	return func(alerts template.Alerts) string {
+		return b.severityEmoji(b.severityOrdering.mostSevere(alerts))
+	}
+}
+
+// severityTally counts the firing alerts by severity, the most severe first, like "🟥 2 critical, 🟧 1 warning".
+// It's "" without severity emoji, so that the bot's messages only change once they're configured.
+func (b *Bot) severityTally(alerts template.Alerts) string {
+	if len(b.severityEmojis) == 0 {
+		return ""
+	}
+	counts := map[string]int{}
+	for _, a := range alerts.Firing() {
+		counts[a.Labels[labelSeverity]]++
+	}
+	severities := make([]string, 0, len(counts))
+	for s := range counts {
+		severities = append(severities, s)
+	}
+	sort.Slice(severities, func(i, j int) bool {
+		if c := b.severityOrdering.compare(severities[i], severities[j]); c != 0 {
+			return c > 0
+		}
+		return severities[i] < severities[j]
+	})
+
+	tally := make([]string, 0, len(severities))
+	for _, s := range severities {
+		name := s
+		if name == "" {
+			name = "no severity"
+		}
+		tally = append(tally, fmt.Sprintf("%s %d %s", b.severityEmoji(s), counts[s], html.EscapeString(name)))
+	}
+	return strings.Join(tally, ", ")
+}
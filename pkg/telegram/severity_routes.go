@@ -0,0 +1,278 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandRoute  = "/route"
+	CommandRoutes = "/routes"
+
+	responseRouteUsage = "Usage: " + CommandRoute + " severity[critical] to <chat_id> [topic <topic_id>] [also], or " + CommandRoute + " del <number> to delete a route listed by " +
+		CommandRoutes + ". Routed alerts don't go to this chat, unless the route ends with also."
+)
+
+// SeverityRoute sends the alerts of a chat with one of its severities to another chat, or to a forum topic.
+type SeverityRoute struct {
+	Severities []string
+	ChatID     int64
+	// TopicID is the forum topic of the chat the alerts go to, 0 sends them to the chat.
+	TopicID int `json:",omitempty"`
+	// Also keeps sending the alerts to the chat the route belongs to.
+	Also bool `json:",omitempty"`
+}
+
+func (r SeverityRoute) String() string {
+	s := fmt.Sprintf("severity[%s] to %d", strings.Join(r.Severities, ", "), r.ChatID)
+	if r.TopicID != 0 {
+		s += fmt.Sprintf(" topic %d", r.TopicID)
+	}
+	if r.Also {
+		s += " also"
+	}
+	return s
+}
+
+// target describes where the route sends alerts.
+func (r SeverityRoute) target() string {
+	if r.TopicID != 0 {
+		return fmt.Sprintf("topic %d of chat %d", r.TopicID, r.ChatID)
+	}
+	return fmt.Sprintf("chat %d", r.ChatID)
+}
+
+// severityRoutedKey marks the context of deliveries of routed alerts, which aren't routed again.
+// That way routes pointing at each other, or at the chat getting unlabeled alerts, can't send alerts in circles.
+type severityRoutedKey struct{}
+
+// SetSeverityRoutes sets the severity routes of the chat, in the order they're evaluated.
+func (s *ChatStore) SetSeverityRoutes(id int64, routes []SeverityRoute) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.SeverityRoutes = routes
+		return nil
+	})
+}
+
+// parseSeverityRoute parses a route like severity[critical, warning] to -100123 also.
+// A target like topic 42, without a chat, is a topic of the chat the route belongs to: ChatID is left 0 then.
+func parseSeverityRoute(payload string) (SeverityRoute, error) {
+	var r SeverityRoute
+	payload = " " + payload
+	i := strings.LastIndex(payload, " to ")
+	if i < 0 {
+		return r, errors.New("no target given, like to -100123")
+	}
+	groups, err := parseBracketArgs(payload[:i], labelSeverity)
+	if err != nil {
+		return r, err
+	}
+	if len(groups) == 0 || len(groups[0].Values) == 0 {
+		return r, errors.New("no severity[...] given")
+	}
+	for _, v := range groups[0].Values {
+		r.Severities = append(r.Severities, strings.ToLower(v))
+	}
+
+	target := strings.Fields(payload[i+len(" to "):])
+	if len(target) > 0 && target[len(target)-1] == "also" {
+		r.Also = true
+		target = target[:len(target)-1]
+	}
+	if n := len(target); n >= 2 && target[n-2] == "topic" {
+		topic, err := strconv.Atoi(target[n-1])
+		if err != nil || topic < 1 {
+			return r, fmt.Errorf("%q is no topic ID", target[n-1])
+		}
+		r.TopicID = topic
+		target = target[:n-2]
+		if len(target) == 0 {
+			return r, nil
+		}
+	}
+	if len(target) != 1 {
+		return r, errors.New("expected a chat ID after to")
+	}
+	r.ChatID, err = strconv.ParseInt(target[0], 10, 64)
+	if err != nil {
+		return r, fmt.Errorf("%q is no chat ID", target[0])
+	}
+	return r, nil
+}
+
+// routeBySeverity splits the alerts by the first route matching their severity, in the order the routes were added.
+// The alerts no route matches, or whose route is also for the chat, are kept.
+func routeBySeverity(routes []SeverityRoute, alerts template.Alerts) (kept template.Alerts, routed map[int]template.Alerts) {
+	kept = make(template.Alerts, 0, len(alerts))
+	routed = map[int]template.Alerts{}
+	for _, a := range alerts {
+		i := -1
+		for j, r := range routes {
+			if contains(r.Severities, strings.ToLower(a.Labels[labelSeverity])) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			kept = append(kept, a)
+			continue
+		}
+		routed[i] = append(routed[i], a)
+		if routes[i].Also {
+			kept = append(kept, a)
+		}
+	}
+	return kept, routed
+}
+
+// applySeverityRoutes delivers the chat's alerts matching its severity routes to their targets and returns
+// the alerts the chat keeps. Alerts that were routed to the chat aren't routed again.
+func (b *Bot) applySeverityRoutes(ctx context.Context, logger log.Logger, w alertmanager.TelegramWebhook, ci *ChatInfo, alerts template.Alerts) (template.Alerts, error) {
+	if len(ci.SeverityRoutes) == 0 || ctx.Value(severityRoutedKey{}) != nil {
+		return alerts, nil
+	}
+	kept, routed := routeBySeverity(ci.SeverityRoutes, alerts)
+	routedCtx := context.WithValue(ctx, severityRoutedKey{}, ci.Chat.ID)
+	for i, r := range ci.SeverityRoutes {
+		if len(routed[i]) == 0 {
+			continue
+		}
+		data := *w.Message.Data
+		data.Alerts = routed[i]
+		data.Status = "resolved"
+		if len(routed[i].Firing()) > 0 {
+			data.Status = "firing"
+		}
+		forward := alertmanager.TelegramWebhook{
+			ChatID:  r.ChatID,
+			Message: webhook.Message{Data: &data, Version: w.Message.Version, GroupKey: w.Message.GroupKey},
+		}
+
+		level.Debug(logger).Log("msg", "routing alerts by severity", "count", len(routed[i]), "route_chat_id", r.ChatID, "route_topic_id", r.TopicID)
+		header := fmt.Sprintf("↪️ routed here by severity from %s\n\n", html.EscapeString(chatName(ci.Chat)))
+		deliveryCtx := routedCtx
+		if r.TopicID != 0 {
+			deliveryCtx = withRouteTopic(routedCtx, r.ChatID, r.TopicID)
+		}
+		d, err := b.deliverWebhook(deliveryCtx, forward, header)
+		if err != nil {
+			return nil, err
+		}
+		if d.Failed != nil {
+			level.Warn(logger).Log("msg", "failed to deliver alerts routed by severity", "route_chat_id", r.ChatID, "route_topic_id", r.TopicID, "err", d.Failed)
+		}
+	}
+	return kept, nil
+}
+
+// validateSeverityRoute tells why the chat can't route alerts to the route's target, "" if it can.
+func (b *Bot) validateSeverityRoute(chatID int64, r SeverityRoute) (string, error) {
+	if r.ChatID == chatID {
+		if r.TopicID != 0 {
+			return "", nil
+		}
+		return "A chat can't route alerts to itself, only to one of its topics.", nil
+	}
+	if me := b.telegram.Me(); me != nil && r.ChatID == int64(me.ID) {
+		return "The bot can't be the target of a route.", nil
+	}
+	target, err := b.chats.GetChatInfo(r.ChatID)
+	if errors.Is(err, ErrChatNotFound) {
+		return fmt.Sprintf("Chat %d didn't subscribe, send %s there first.", r.ChatID, CommandStart), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if target.Unreachable {
+		return fmt.Sprintf("Chat %d is unreachable, the bot can't send to it.", r.ChatID), nil
+	}
+	return "", nil
+}
+
+func (b *Bot) handleRoute(message *telebot.Message) error {
+	payload := strings.TrimSpace(message.Payload)
+	if payload == "" {
+		_, err := b.respond(message, "route_usage", responseContext(message))
+		return err
+	}
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the routes of this chat")
+		return err
+	}
+	routes := ci.SeverityRoutes
+
+	var reply string
+	if fields := strings.Fields(payload); fields[0] == "del" {
+		n := 0
+		if len(fields) == 2 {
+			n, _ = strconv.Atoi(fields[1])
+		}
+		if n < 1 || n > len(routes) {
+			_, err = b.reply(message, fmt.Sprintf("There is no such route, this chat has %d.\n%s", len(routes), b.responseText("route_usage", b.localResponseContext(message))))
+			return err
+		}
+		reply = fmt.Sprintf("Deleted route %s.", routes[n-1])
+		routes = append(append([]SeverityRoute(nil), routes[:n-1]...), routes[n:]...)
+	} else {
+		r, err := parseSeverityRoute(payload)
+		if err != nil {
+			_, err = b.reply(message, fmt.Sprintf("failed to parse route... %v\n%s", err, b.responseText("route_usage", b.localResponseContext(message))))
+			return err
+		}
+		if r.ChatID == 0 {
+			r.ChatID = message.Chat.ID
+		}
+		invalid, err := b.validateSeverityRoute(message.Chat.ID, r)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "check the route's chat")
+			return err
+		}
+		if invalid != "" {
+			_, err = b.reply(message, invalid)
+			return err
+		}
+		routes = append(routes, r)
+		reply = fmt.Sprintf("Alerts with severity %s go to %s now.", strings.Join(r.Severities, ", "), r.target())
+		if r.Also {
+			reply = fmt.Sprintf("Alerts with severity %s go to %s too now.", strings.Join(r.Severities, ", "), r.target())
+		}
+	}
+
+	if err := b.chats.SetSeverityRoutes(message.Chat.ID, routes); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set severity routes", "err", err)
+		_, err = b.replyStoreError(message, err, "set the routes of this chat")
+		return err
+	}
+	_, err = b.reply(message, reply)
+	return err
+}
+
+func (b *Bot) handleRoutes(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the routes of this chat")
+		return err
+	}
+	if len(ci.SeverityRoutes) == 0 {
+		_, err = b.reply(message, b.responseText("routes_none", b.localResponseContext(message))+"\n"+b.responseText("route_usage", b.localResponseContext(message)))
+		return err
+	}
+	lines := []string{"Alerts of this chat go to the first route matching their severity:"}
+	for i, r := range ci.SeverityRoutes {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, r))
+	}
+	_, err = b.reply(message, strings.Join(lines, "\n"))
+	return err
+}
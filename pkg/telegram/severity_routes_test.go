@@ -0,0 +1,248 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestParseSeverityRoute(t *testing.T) {
+	r, err := parseSeverityRoute("severity[Critical, page] to -100987654")
+	require.NoError(t, err)
+	require.Equal(t, SeverityRoute{Severities: []string{"critical", "page"}, ChatID: -100987654}, r)
+
+	r, err = parseSeverityRoute("severity[warning] to 42 also")
+	require.NoError(t, err)
+	require.Equal(t, SeverityRoute{Severities: []string{"warning"}, ChatID: 42, Also: true}, r)
+	require.Equal(t, "severity[warning] to 42 also", r.String())
+
+	r, err = parseSeverityRoute("severity[critical] to -100987654 topic 7 also")
+	require.NoError(t, err)
+	require.Equal(t, SeverityRoute{Severities: []string{"critical"}, ChatID: -100987654, TopicID: 7, Also: true}, r)
+	require.Equal(t, "severity[critical] to -100987654 topic 7 also", r.String())
+
+	r, err = parseSeverityRoute("severity[critical] to topic 42")
+	require.NoError(t, err)
+	require.Equal(t, SeverityRoute{Severities: []string{"critical"}, TopicID: 42}, r, "a topic without a chat is one of the route's own chat")
+
+	for payload, expected := range map[string]string{
+		"severity[critical]":                "no target given, like to -100123",
+		"severity[] to 1":                   "no severity[...] given",
+		"to 1":                              "no severity[...] given",
+		"project[billing] to 1":             "unknown project[], use severity[...]",
+		"severity[critical] to topic":       `"topic" is no chat ID`,
+		"severity[critical] to topic 0":     `"0" is no topic ID`,
+		"severity[critical] to 1 topic x":   `"x" is no topic ID`,
+		"severity[critical] to 1 2 topic 3": "expected a chat ID after to",
+		"severity[critical] to oncall":      `"oncall" is no chat ID`,
+		"severity[critical] to 1 2":         "expected a chat ID after to",
+		"severity[critical] to also":        "expected a chat ID after to",
+		"severity[critical] to -100 maybe":  "expected a chat ID after to",
+	} {
+		_, err := parseSeverityRoute(payload)
+		require.EqualError(t, err, expected, payload)
+	}
+}
+
+func TestRouteBySeverity(t *testing.T) {
+	critical := template.Alert{Labels: template.KV{"alertname": "A", labelSeverity: "critical"}}
+	page := template.Alert{Labels: template.KV{"alertname": "B", labelSeverity: "Page"}}
+	warning := template.Alert{Labels: template.KV{"alertname": "C", labelSeverity: "warning"}}
+	none := template.Alert{Labels: template.KV{"alertname": "D"}}
+	routes := []SeverityRoute{
+		{Severities: []string{"critical", "page"}, ChatID: 1},
+		{Severities: []string{"critical", "warning"}, ChatID: 2, Also: true},
+	}
+
+	kept, routed := routeBySeverity(routes, template.Alerts{critical, page, warning, none})
+	require.Equal(t, template.Alerts{warning, none}, kept)
+	require.Equal(t, map[int]template.Alerts{0: {critical, page}, 1: {warning}}, routed, "the first matching route wins")
+}
+
+// sentTo returns the texts of the messages sent to the chat.
+func sentTo(tb *fakeTelebot, chat *telebot.Chat) []string {
+	var texts []string
+	for _, m := range tb.messages() {
+		if m.to == chat.Recipient() {
+			texts = append(texts, m.text())
+		}
+	}
+	return texts
+}
+
+func TestSeverityRoutesWithMutes(t *testing.T) {
+	alert := func(name, env, severity string) template.Alert {
+		return template.Alert{Status: "firing", Labels: template.KV{"alertname": name, labelEnvironment: env, labelProject: "billing", labelSeverity: severity}}
+	}
+	w := mixedWebhook("firing",
+		alert("CriticalProd", "prod", "critical"),
+		alert("CriticalStaging", "staging", "critical"),
+		alert("WarningProd", "prod", "warning"),
+	)
+
+	for _, tc := range []struct {
+		name        string
+		route       SeverityRoute
+		sharedMutes []string
+		chat        []string
+		shared      []string
+	}{
+		{
+			name:   "instead",
+			route:  SeverityRoute{Severities: []string{"critical"}, ChatID: sharedGroup.ID},
+			chat:   []string{"WarningProd"},
+			shared: []string{"CriticalProd"},
+		},
+		{
+			name:   "also",
+			route:  SeverityRoute{Severities: []string{"critical"}, ChatID: sharedGroup.ID, Also: true},
+			chat:   []string{"CriticalProd", "WarningProd"},
+			shared: []string{"CriticalProd"},
+		},
+		{
+			name:        "the target's mutes apply as well",
+			route:       SeverityRoute{Severities: []string{"critical", "warning"}, ChatID: sharedGroup.ID},
+			sharedMutes: []string{"prod"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, tb, chats := newTestBot(t)
+			require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+			require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+			// The chat's own mutes are applied before its alerts are routed.
+			require.NoError(t, chats.MuteEnvironments(testChat, []string{"staging"}, b.environments))
+			if tc.sharedMutes != nil {
+				require.NoError(t, chats.MuteEnvironments(sharedGroup, tc.sharedMutes, b.environments))
+			}
+			require.NoError(t, chats.SetSeverityRoutes(testChat.ID, []SeverityRoute{tc.route}))
+
+			_, err := b.processWebhook(context.Background(), w)
+			require.NoError(t, err)
+
+			chatTexts, sharedTexts := sentTo(tb, testChat), sentTo(tb, sharedGroup)
+			require.Len(t, chatTexts, min1(len(tc.chat)))
+			require.Len(t, sharedTexts, min1(len(tc.shared)))
+			for _, name := range tc.chat {
+				require.Contains(t, chatTexts[0], name)
+			}
+			for _, name := range tc.shared {
+				require.Contains(t, sharedTexts[0], name)
+				require.True(t, strings.HasPrefix(sharedTexts[0], "↪️ routed here by severity from @elliot"), sharedTexts[0])
+			}
+			all := strings.Join(append(chatTexts, sharedTexts...), "\n")
+			require.NotContains(t, all, "CriticalStaging")
+			if len(tc.chat) == 1 {
+				require.NotContains(t, chatTexts[0], "CriticalProd")
+			}
+		})
+	}
+}
+
+// min1 is 1 if n is positive, a chat gets all of its alerts of a webhook in one message.
+func min1(n int) int {
+	if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+func TestSeverityRoutesDontLoop(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetSeverityRoutes(testChat.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: sharedGroup.ID}}))
+	require.NoError(t, chats.SetSeverityRoutes(sharedGroup.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: testChat.ID}}))
+
+	w := mixedWebhook("firing", template.Alert{Status: "firing", Labels: template.KV{"alertname": "Fire", labelSeverity: "critical"}})
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	require.Len(t, tb.messages(), 1)
+	require.Len(t, sentTo(tb, sharedGroup), 1, "routed alerts aren't routed again")
+}
+
+func TestRouteCommand(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	route := func(payload string) string {
+		t.Helper()
+		require.NoError(t, b.handleRoute(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandRoute + " " + payload, Payload: payload}))
+		return tb.lastText()
+	}
+	routes := func() string {
+		t.Helper()
+		require.NoError(t, b.handleRoutes(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandRoutes}))
+		return tb.lastText()
+	}
+
+	require.Equal(t, responseRouteUsage, route(""))
+	require.Equal(t, "This chat has no severity routes.\n"+responseRouteUsage, routes())
+	require.Equal(t, "A chat can't route alerts to itself, only to one of its topics.", route("severity[critical] to 123"))
+	require.Equal(t, "Chat -42 didn't subscribe, send /start there first.", route("severity[critical] to -42"))
+
+	require.NoError(t, chats.SetUnreachable(sharedGroup.ID, time.Now()))
+	require.Equal(t, "Chat -100 is unreachable, the bot can't send to it.", route("severity[critical] to -100"))
+	require.NoError(t, chats.SetUnreachable(sharedGroup.ID, time.Time{}))
+
+	require.Equal(t, "Alerts with severity critical go to chat -100 now.", route("severity[critical] to -100"))
+	require.Equal(t, "Alerts with severity warning, info go to chat -100 too now.", route("severity[warning, info] to -100 also"))
+	require.Equal(t, "Alerts with severity page go to topic 42 of chat 123 now.", route("severity[page] to topic 42"))
+	require.Equal(t, "Alerts of this chat go to the first route matching their severity:\n1. severity[critical] to -100\n2. severity[warning, info] to -100 also\n3. severity[page] to 123 topic 42", routes())
+
+	require.Equal(t, "There is no such route, this chat has 3.\n"+responseRouteUsage, route("del 4"))
+	require.Equal(t, "Deleted route severity[page] to 123 topic 42.", route("del 3"))
+	require.Equal(t, "Deleted route severity[critical] to -100.", route("del 1"))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []SeverityRoute{{Severities: []string{"warning", "info"}, ChatID: sharedGroup.ID, Also: true}}, ci.SeverityRoutes)
+}
+
+func TestSeverityRoutesToTopics(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(sharedGroup, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetSeverityRoutes(sharedGroup.ID, []SeverityRoute{
+		{Severities: []string{"critical"}, ChatID: sharedGroup.ID, TopicID: 7},
+		{Severities: []string{"warning"}, ChatID: testChat.ID, TopicID: 9, Also: true},
+	}))
+
+	w := mixedWebhook("firing",
+		template.Alert{Status: "firing", Labels: template.KV{"alertname": "Fire", labelSeverity: "critical"}},
+		template.Alert{Status: "firing", Labels: template.KV{"alertname": "Smoke", labelSeverity: "warning"}},
+	)
+	w.ChatID = sharedGroup.ID
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+
+	topics := map[string]interface{}{}
+	var plain []string
+	for _, m := range tb.messages() {
+		if len(m.options) == 1 {
+			if params, ok := m.options[0].(map[string]interface{}); ok {
+				topics[m.to+" "+m.text()] = params["message_thread_id"]
+				continue
+			}
+		}
+		plain = append(plain, m.to+" "+m.text())
+	}
+	require.Len(t, topics, 2)
+	for text, topic := range topics {
+		switch {
+		case strings.HasPrefix(text, "-100 ") && strings.Contains(text, "Fire"):
+			require.Equal(t, 7, topic, "routes can send to a topic of their own chat")
+		case strings.HasPrefix(text, "123 ") && strings.Contains(text, "Smoke"):
+			require.Equal(t, 9, topic)
+		default:
+			t.Fatalf("unexpected message to a topic: %s", text)
+		}
+	}
+	require.Len(t, plain, 1, "the chat keeps the alerts of routes that are also for it")
+	require.Contains(t, plain[0], "Smoke")
+	require.NotContains(t, plain[0], "Fire")
+}
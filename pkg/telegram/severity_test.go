@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+var customSeverityEmoji = map[string]string{"critical": "🟥", "warning": "🟧", "info": "🟦"}
+
+func severityAlert(name, severity string) template.Alert {
+	return template.Alert{
+		Status:      "firing",
+		Labels:      template.KV{"alertname": name, "severity": severity},
+		Fingerprint: name + "/" + severity,
+		StartsAt:    time.Now(),
+	}
+}
+
+func TestSeverityEmoji(t *testing.T) {
+	b, _, _ := newTestBot(t, WithSeverityEmoji(customSeverityEmoji))
+	require.Equal(t, "🟥", b.severityEmoji("critical"))
+	require.Equal(t, "🔥", b.severityEmoji("page"), "unknown severities get the default")
+	require.Equal(t, "🔥", b.severityEmoji(""))
+
+	alerts := template.Alerts{
+		severityAlert("A", "warning"), severityAlert("B", "critical"), severityAlert("C", "page"),
+		severityAlert("D", "warning"), severityAlert("E", ""),
+	}
+	resolved := severityAlert("F", "critical")
+	resolved.Status = "resolved"
+	alerts = append(alerts, resolved)
+	require.Equal(t, "🟥 1 critical, 🟧 2 warning, 🔥 1 no severity, 🔥 1 page", b.severityTally(alerts))
+
+	plain, _, _ := newTestBot(t)
+	require.Equal(t, "", plain.severityTally(alerts), "messages only change once emoji are configured")
+
+	require.Error(t, WithSeverityEmoji(map[string]string{"critical": " "})(&Bot{}))
+}
+
+func TestSeverityEmojiTemplate(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithSeverityEmoji(customSeverityEmoji))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{severityAlert("HighCPU", "warning"), severityAlert("Watchdog", "none")},
+	}}}
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	text := tb.lastText()
+	require.Contains(t, text, "🟧 <b>HighCPU</b> 🟧")
+	require.Contains(t, text, "🔥 <b>Watchdog</b> 🔥")
+}
+
+func TestSeverityEmojiOverflow(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithSeverityEmoji(customSeverityEmoji), WithMaxAlerts(1))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	alerts := template.Alerts{
+		severityAlert("HighCPU", "info"),
+		severityAlert("TargetDown", "warning"), severityAlert("TargetDown", "critical"),
+		severityAlert("DiskFull", "info"),
+	}
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{Status: "firing", Alerts: alerts}}}
+	_, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	text := tb.messages()[0].text()
+	require.True(t, strings.HasSuffix(text, "\n\n…and 3 more: 2× 🟥 TargetDown, 1× 🟦 DiskFull"), text)
+}
+
+func TestSeverityEmojiDigest(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithSeverityEmoji(customSeverityEmoji))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now()}))
+
+	for _, a := range []template.Alert{severityAlert("HighCPU", "warning"), severityAlert("DiskFull", "critical"), severityAlert("Backup", "warning")} {
+		require.True(t, b.holdForMaintenance(alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+			Status: "firing",
+			Alerts: template.Alerts{a},
+		}}}, time.Now()))
+	}
+	_, err := b.endMaintenance(context.Background(), nil)
+	require.NoError(t, err)
+	digest := tb.lastText()
+	require.True(t, strings.HasPrefix(digest, "<b>🛠 Catch-up after maintenance</b>, 3 alerts came in meanwhile\n🟥 1 critical, 🟧 2 warning\n\n"), digest)
+	require.Contains(t, digest, "🟥 <b>DiskFull</b> 🟥")
+}
+
+func TestParseSeverityOrdering(t *testing.T) {
+	o, err := parseSeverityOrdering("low, high")
+	require.NoError(t, err)
+	require.Equal(t, severityOrdering{levels: []string{"low", "high"}}, o)
+	o, err = parseSeverityOrdering(SeverityNumericDescending)
+	require.NoError(t, err)
+	require.Equal(t, severityOrdering{numeric: -1}, o)
+
+	_, err = parseSeverityOrdering("info,,critical")
+	require.EqualError(t, err, `the severity ordering "info,,critical" has an empty severity`)
+	_, err = parseSeverityOrdering("info,critical,info")
+	require.EqualError(t, err, `the severity ordering "info,critical,info" has info twice`)
+}
+
+func TestSeverityOrderingCompare(t *testing.T) {
+	list := defaultSeverityOrdering
+	ascending := severityOrdering{numeric: 1}
+	descending := severityOrdering{numeric: -1}
+
+	for _, tc := range []struct {
+		name     string
+		ordering severityOrdering
+		a, b     string
+		expected int
+	}{
+		{"list", list, "critical", "warning", 1},
+		{"list equal", list, "info", "info", 0},
+		{"list unknown ranks lowest", list, "page", "info", -1},
+		{"list unknowns are equal", list, "page", "", 0},
+		{"ascending", ascending, "5", "1", 1},
+		{"ascending decimals", ascending, "2.5", "10", -1},
+		{"descending", descending, "1", "5", 1},
+		{"descending equal", descending, "2", " 2", 0},
+		{"descending unknown ranks lowest", descending, "critical", "9", -1},
+		{"descending NaN is unknown", descending, "NaN", "9", -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.ordering.compare(tc.a, tc.b))
+			require.Equal(t, -tc.expected, tc.ordering.compare(tc.b, tc.a), "the comparison is antisymmetric")
+		})
+	}
+}
+
+func TestSeverityOrderingNumeric(t *testing.T) {
+	o := severityOrdering{numeric: -1}
+	alerts := template.Alerts{
+		severityAlert("A", "1"), severityAlert("B", "3"), severityAlert("C", "2"),
+		severityAlert("D", ""), severityAlert("E", "high"),
+	}
+	var names []string
+	for _, a := range o.atLeast(alerts, "2") {
+		names = append(names, a.Labels["alertname"])
+	}
+	require.Equal(t, []string{"A", "C", "D", "E"}, names, "unknown and missing severities are kept")
+	require.Equal(t, "1", o.mostSevere(alerts))
+	require.Equal(t, "", o.matcher("2"), "Alertmanager can't match numeric orderings")
+	require.Equal(t, `severity!~"info|warning"`, defaultSeverityOrdering.matcher("critical"))
+	require.Equal(t, "a number", o.describe())
+
+	b, _, _ := newTestBot(t, WithSeverityOrdering(SeverityNumericDescending), WithSeverityEmoji(map[string]string{"1": "🟥", "2": "🟧"}))
+	require.Equal(t, "🟥 1 1, 🟧 1 2, 🔥 1 3, 🔥 1 no severity, 🔥 1 high", b.severityTally(alerts))
+	require.Equal(t, "🟥", b.mostSevereEmojiFunc()(alerts))
+
+	_, _, err := b.parseInvite("severity[critical]")
+	require.EqualError(t, err, "severity must be a number")
+	setup, _, err := b.parseInvite("severity[2]")
+	require.NoError(t, err)
+	require.Equal(t, "2", setup.MinSeverity)
+}
+
+func TestUnknownSeveritiesMetric(t *testing.T) {
+	b, _, chats := newTestBot(t, WithSeverityOrdering(SeverityNumericDescending))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	// The metric is global, so only its change is compared.
+	before := testutil.ToFloat64(b.unknownSeverities.WithLabelValues("critical"))
+
+	_, err := b.processWebhook(context.Background(), mixedWebhook("firing",
+		severityAlert("A", "critical"), severityAlert("B", "1"), severityAlert("C", ""),
+	))
+	require.NoError(t, err)
+	require.Equal(t, before+1, testutil.ToFloat64(b.unknownSeverities.WithLabelValues("critical")))
+	require.Zero(t, testutil.ToFloat64(b.unknownSeverities.WithLabelValues("")), "missing severities aren't counted")
+}
@@ -0,0 +1,201 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const CommandReceiverClaim = "/receiver_claim"
+
+// sharedReceivers returns the stored receivers more than one chat has, with those chats sorted by ID.
+// One receiver sends to one chat, a receiver stored for several is usually a mistake: /alerts shows
+// each of them the others' alerts, too.
+func sharedReceivers(chats []ChatInfo) map[string][]*telebot.Chat {
+	byReceiver := map[string][]*telebot.Chat{}
+	for _, ci := range chats {
+		if ci.Receiver != "" && ci.Chat != nil {
+			byReceiver[ci.Receiver] = append(byReceiver[ci.Receiver], ci.Chat)
+		}
+	}
+	for receiver, sharing := range byReceiver {
+		if len(sharing) < 2 {
+			delete(byReceiver, receiver)
+			continue
+		}
+		sort.Slice(sharing, func(i, j int) bool { return sharing[i].ID < sharing[j].ID })
+	}
+	return byReceiver
+}
+
+// ClaimReceiver stores the receiver for the chat and forgets it for all other chats, which it returns.
+// Claims and SetReceiver don't overlap, so two chats claiming the same receiver leave it with one of them.
+func (s *ChatStore) ClaimReceiver(id int64, receiver string) ([]*telebot.Chat, error) {
+	s.receiverMu.Lock()
+	defer s.receiverMu.Unlock()
+
+	chats, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	err = s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.Receiver = receiver
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The chat has the receiver first, a failure below leaves it shared rather than with nobody.
+	var released []*telebot.Chat
+	for _, listed := range chats {
+		if listed.Chat == nil || listed.Chat.ID == id || listed.Receiver != receiver {
+			continue
+		}
+		err := s.UpdateChat(listed.Chat.ID, func(ci *ChatInfo) error {
+			if ci.Receiver == receiver {
+				ci.Receiver = ""
+			}
+			return nil
+		})
+		if errors.Is(err, ErrChatNotFound) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released = append(released, listed.Chat)
+	}
+	return released, nil
+}
+
+// sharedReceiverNotices remembers which chats shared each receiver when the admins were last told,
+// so that they're told again only when that changes.
+type sharedReceiverNotices struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+// observe tells notify about the receivers that are shared by other chats than last time.
+func (n *sharedReceiverNotices) observe(shared map[string][]*telebot.Chat, notify func(receiver string, sharing []*telebot.Chat)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.sent == nil {
+		n.sent = map[string]string{}
+	}
+	receivers := make([]string, 0, len(shared))
+	for receiver := range shared {
+		receivers = append(receivers, receiver)
+	}
+	sort.Strings(receivers)
+	for _, receiver := range receivers {
+		ids := make([]string, len(shared[receiver]))
+		for i, c := range shared[receiver] {
+			ids[i] = strconv.FormatInt(c.ID, 10)
+		}
+		key := strings.Join(ids, ",")
+		if n.sent[receiver] == key {
+			continue
+		}
+		n.sent[receiver] = key
+		notify(receiver, shared[receiver])
+	}
+	for receiver := range n.sent {
+		if _, ok := shared[receiver]; !ok {
+			delete(n.sent, receiver)
+		}
+	}
+}
+
+// notifySharedReceiver tells the global admins about a receiver stored for several chats.
+func (b *Bot) notifySharedReceiver(receiver string, sharing []*telebot.Chat) {
+	names := make([]string, len(sharing))
+	for i, c := range sharing {
+		names[i] = chatName(c)
+	}
+	level.Warn(b.logger).Log("msg", "receiver is stored for several chats", "receiver", receiver, "chats", strings.Join(names, ", "))
+	notice := fmt.Sprintf("⚠️ Receiver '%s' is stored for %d chats: %s. /alerts shows each of them the alerts of all. "+
+		"Send %s in the chat it belongs to, to take it away from the others.", receiver, len(sharing), strings.Join(names, ", "), CommandReceiverClaim)
+	for _, admin := range b.globalAdmins {
+		b.SendAdminMessage(admin, notice)
+	}
+}
+
+// checkSharedReceivers tells the global admins about receivers stored for several chats, once until that changes.
+func (b *Bot) checkSharedReceivers() {
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats to check their receivers", "err", err)
+		return
+	}
+	b.sharedReceivers.observe(sharedReceivers(chats), b.notifySharedReceiver)
+}
+
+// receiverSharers returns how many other chats the receiver of the chat is stored for.
+func (b *Bot) receiverSharers(ci *ChatInfo) int {
+	if ci.Receiver == "" {
+		return 0
+	}
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats to check their receivers", "err", err)
+		return 0
+	}
+	sharing := sharedReceivers(chats)[ci.Receiver]
+	if len(sharing) == 0 {
+		return 0
+	}
+	return len(sharing) - 1
+}
+
+func (b *Bot) handleReceiverClaim(message *telebot.Message) error {
+	receiver := strings.TrimSpace(message.Payload)
+	if receiver == "" {
+		ci, err := b.chats.GetChatInfo(message.Chat.ID)
+		if err != nil {
+			_, err = b.replyStoreError(message, err, "get the receiver of this chat")
+			return err
+		}
+		if ci.Receiver == "" {
+			_, err = b.reply(message, "This chat has no receiver stored. Usage: "+CommandReceiverClaim+" [receiver] "+
+				"stores the receiver for this chat only, the chat's own if none is given.")
+			return err
+		}
+		receiver = ci.Receiver
+	}
+
+	released, err := b.chats.ClaimReceiver(message.Chat.ID, receiver)
+	for _, c := range released {
+		level.Info(b.logger).Log("msg", "receiver claimed by another chat", "receiver", receiver, "chat_id", c.ID, "claimed_by", message.Chat.ID)
+		notice := fmt.Sprintf("Receiver '%s' isn't stored for this chat anymore, %s claimed it. %s looks for the receiver of this chat again.",
+			receiver, chatName(message.Chat), CommandAlerts)
+		if _, err := b.send(c, notice, "command "+CommandReceiverClaim); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to tell chat about claimed receiver", "chat_id", c.ID, "err", err)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, ErrChatNotFound) {
+			level.Warn(b.logger).Log("msg", "failed to claim receiver", "receiver", receiver, "err", err)
+		}
+		_, err = b.replyStoreError(message, err, "claim the receiver")
+		return err
+	}
+	b.checkSharedReceivers()
+
+	text := fmt.Sprintf("Receiver '%s' is stored for this chat only.", receiver)
+	if len(released) > 0 {
+		names := make([]string, len(released))
+		for i, c := range released {
+			names[i] = chatName(c)
+		}
+		text = fmt.Sprintf("Receiver '%s' is stored for this chat only, it was taken away from %s.", receiver, strings.Join(names, ", "))
+	}
+	_, err = b.reply(message, text)
+	return err
+}
@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// sharingBot returns a bot with the receiver "payments" stored for payments and oncall.
+func sharingBot(t *testing.T) (*Bot, *fakeTelebot, *ChatStore) {
+	t.Helper()
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(oncall, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetReceiver(payments.ID, "payments"))
+	require.NoError(t, chats.SetReceiver(oncall.ID, "payments"))
+	return b, tb, chats
+}
+
+func TestSharedReceivers(t *testing.T) {
+	shared := sharedReceivers([]ChatInfo{
+		{Chat: oncall, Receiver: "payments"},
+		{Chat: payments, Receiver: "payments"},
+		{Chat: testChat, Receiver: "frontend"},
+		{Chat: &telebot.Chat{ID: -300}},
+		{Chat: &telebot.Chat{ID: -400}},
+	})
+	require.Equal(t, map[string][]*telebot.Chat{"payments": {oncall, payments}}, shared)
+}
+
+func TestSharedReceiverNotices(t *testing.T) {
+	b, tb, chats := sharingBot(t)
+
+	b.checkSharedReceivers()
+	require.Equal(t, "⚠️ Receiver 'payments' is stored for 2 chats: oncall-fallback (-200), payments-oncall (-100). "+
+		"/alerts shows each of them the alerts of all. Send /receiver_claim in the chat it belongs to, to take it away from the others.", tb.lastText())
+	require.Equal(t, "123", tb.messages()[0].to)
+
+	b.checkSharedReceivers()
+	require.Len(t, tb.messages(), 1, "admins are told once")
+
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.SetReceiver(testChat.ID, "payments"))
+	b.checkSharedReceivers()
+	require.Len(t, tb.messages(), 2, "and again once another chat has the receiver")
+	require.Contains(t, tb.lastText(), "stored for 3 chats")
+}
+
+func TestHandleReceiverClaim(t *testing.T) {
+	b, tb, chats := sharingBot(t)
+
+	require.NoError(t, b.handleReceiverClaim(&telebot.Message{Chat: payments, Sender: testAdmin, Text: CommandReceiverClaim}))
+	msgs := tb.messages()
+	require.Equal(t, "-200", msgs[0].to)
+	require.Equal(t, "Receiver 'payments' isn't stored for this chat anymore, payments-oncall (-100) claimed it. "+
+		"/alerts looks for the receiver of this chat again.", msgs[0].text())
+	require.Equal(t, "Receiver 'payments' is stored for this chat only, it was taken away from oncall-fallback (-200).", tb.lastText())
+
+	ci, err := chats.GetChatInfo(oncall.ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.Receiver)
+	ci, err = chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Equal(t, "payments", ci.Receiver)
+
+	require.NoError(t, b.handleReceiverClaim(&telebot.Message{Chat: oncall, Sender: testAdmin, Text: CommandReceiverClaim}))
+	require.True(t, strings.HasPrefix(tb.lastText(), "This chat has no receiver stored."), tb.lastText())
+
+	require.NoError(t, b.handleReceiverClaim(&telebot.Message{Chat: oncall, Sender: testAdmin, Text: CommandReceiverClaim + " oncall", Payload: "oncall"}))
+	require.Equal(t, "Receiver 'oncall' is stored for this chat only.", tb.lastText())
+}
+
+func TestConcurrentReceiverClaims(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	claimers := []*telebot.Chat{payments, oncall, testChat, {ID: -300}, {ID: -400}}
+	for _, c := range claimers {
+		require.NoError(t, chats.AddChat(c, b.environmentsAndOther, b.projectsAndOther))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(claimers))
+	for i, c := range claimers {
+		wg.Add(1)
+		go func(i int, c *telebot.Chat) {
+			defer wg.Done()
+			_, errs[i] = chats.ClaimReceiver(c.ID, "payments")
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	list, err := chats.List()
+	require.NoError(t, err)
+	having := 0
+	for _, ci := range list {
+		if ci.Receiver == "payments" {
+			having++
+		}
+	}
+	require.Equal(t, 1, having, "the receiver is left with one chat")
+}
+
+func TestFiltersShowSharedReceiver(t *testing.T) {
+	b, _, chats := sharingBot(t)
+
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	require.Contains(t, b.formatFilters(ci), "\nReceiver: payments (shared with 1 other chat)")
+
+	_, err = chats.ClaimReceiver(payments.ID, "payments")
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(b.formatFilters(ci), "\nReceiver: payments"), "not shared anymore")
+}
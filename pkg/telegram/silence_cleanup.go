@@ -0,0 +1,195 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/types"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandSilencesCleanup = "/silences_cleanup"
+
+	// silenceCleanupProgressEvery is after how many deletions the progress of a cleanup is updated.
+	silenceCleanupProgressEvery = 10
+	// defaultSilenceCleanupInterval paces the deletions of a cleanup, so that Alertmanager isn't flooded.
+	defaultSilenceCleanupInterval = 200 * time.Millisecond
+	// silenceCleanupFailuresShown is how many of the failed deletions the summary of a cleanup lists.
+	silenceCleanupFailuresShown = 10
+
+	responseSilencesCleanupUsage = "Usage: " + CommandSilencesCleanup + " expired|active|pending created_by:<name>|me older_than:30d, silences matching all of them are deleted."
+)
+
+// silenceFilter tells if a silence is to be cleaned up.
+type silenceFilter func(s *types.Silence, now time.Time) bool
+
+// silenceInState matches the silences in the state, like expired.
+func silenceInState(state types.SilenceState) silenceFilter {
+	return func(s *types.Silence, _ time.Time) bool {
+		return s.Status.State == state
+	}
+}
+
+// silenceCreatedBy matches the silences created by any of the names, ignoring case.
+func silenceCreatedBy(names ...string) silenceFilter {
+	return func(s *types.Silence, _ time.Time) bool {
+		for _, name := range names {
+			if strings.EqualFold(s.CreatedBy, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// silenceOlderThan matches the silences that started longer than d ago.
+func silenceOlderThan(d time.Duration) silenceFilter {
+	return func(s *types.Silence, now time.Time) bool {
+		return now.Sub(s.StartsAt) > d
+	}
+}
+
+// allSilenceFilters matches the silences all of the filters match.
+func allSilenceFilters(filters ...silenceFilter) silenceFilter {
+	return func(s *types.Silence, now time.Time) bool {
+		for _, f := range filters {
+			if !f(s, now) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filterSilences returns the silences the filter matches.
+func filterSilences(silences []*types.Silence, filter silenceFilter, now time.Time) []*types.Silence {
+	var matched []*types.Silence
+	for _, s := range silences {
+		if filter(s, now) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+// parseSilenceFilters parses filters like expired created_by:me older_than:30d, at least one is needed.
+// created_by:me matches the silences the sender created, in Telegram or as their username elsewhere.
+func parseSilenceFilters(payload string, sender *telebot.User) (silenceFilter, error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no filter given")
+	}
+	filters := make([]silenceFilter, 0, len(fields))
+	for _, field := range fields {
+		switch {
+		case field == string(types.SilenceStateExpired) || field == string(types.SilenceStateActive) || field == string(types.SilenceStatePending):
+			filters = append(filters, silenceInState(types.SilenceState(field)))
+		case field == "created_by:me":
+			names := []string{senderName(sender)}
+			if sender.Username != "" {
+				names = append(names, sender.Username, "@"+sender.Username)
+			}
+			filters = append(filters, silenceCreatedBy(names...))
+		case strings.HasPrefix(field, "created_by:"):
+			filters = append(filters, silenceCreatedBy(strings.TrimPrefix(field, "created_by:")))
+		case strings.HasPrefix(field, "older_than:"):
+			d, err := parseExpiry(strings.TrimPrefix(field, "older_than:"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", field, err)
+			}
+			filters = append(filters, silenceOlderThan(d))
+		default:
+			return nil, fmt.Errorf("unknown filter %q", field)
+		}
+	}
+	return allSilenceFilters(filters...), nil
+}
+
+// silenceNames names the silences, the first targetNamesShown of them.
+func silenceNames(silences []*types.Silence) string {
+	names := make([]string, 0, len(silences))
+	for i, s := range silences {
+		if i == targetNamesShown {
+			names = append(names, fmt.Sprintf("and %d more", len(silences)-i))
+			break
+		}
+		names = append(names, silenceName(s))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (b *Bot) handleSilencesCleanup(message *telebot.Message) error {
+	filter, err := parseSilenceFilters(message.Payload, message.Sender)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseSilencesCleanupUsage))
+		return err
+	}
+
+	silences, err := b.alertmanager.ListSilences(context.TODO())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list silences", "err", err)
+		_, err = b.replyError(message, "failed to list silences", fmt.Sprintf("failed to list silences... %v", err))
+		return err
+	}
+	matched := filterSilences(silences, filter, time.Now())
+	if len(matched) == 0 {
+		_, err = b.reply(message, "No silences match.")
+		return err
+	}
+
+	key := b.targetedChanges.add(message, func(m *telebot.Message) error {
+		return b.deleteSilences(m, matched)
+	})
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: targetConfirmUnique, Text: fmt.Sprintf("Delete %d silences", len(matched)), Data: key},
+	}}}
+	_, err = b.reply(message, fmt.Sprintf("%d silences match: %s.\nConfirm to delete all of them.", len(matched), silenceNames(matched)),
+		&telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+// deleteSilences deletes the silences one by one, paced by the cleanup interval.
+// The progress is updated every silenceCleanupProgressEvery deletions, a summary with the failures follows.
+func (b *Bot) deleteSilences(message *telebot.Message, silences []*types.Silence) error {
+	progress, err := b.reply(message, fmt.Sprintf("Deleting %d silences...", len(silences)))
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send silence cleanup progress", "err", err)
+	}
+
+	var failures []string
+	for i, s := range silences {
+		if i > 0 && b.silenceCleanupInterval > 0 {
+			time.Sleep(b.silenceCleanupInterval)
+		}
+		if err := b.alertmanager.DeleteSilence(context.TODO(), s.ID); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to delete silence", "silence_id", s.ID, "err", err)
+			failures = append(failures, fmt.Sprintf("%s (%s): %v", silenceName(s), s.ID, err))
+		}
+		done := i + 1
+		if progress != nil && done%silenceCleanupProgressEvery == 0 && done < len(silences) {
+			if _, err := b.telegram.Edit(progress, fmt.Sprintf("Deleting %d silences... %d done.", len(silences), done)); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to update silence cleanup progress", "err", err)
+			}
+		}
+	}
+	level.Info(b.logger).Log("msg", "cleaned up silences", "silences", len(silences), "failed", len(failures),
+		"user_id", message.Sender.ID, "username", message.Sender.Username)
+
+	summary := fmt.Sprintf("Deleted %d of %d silences.", len(silences)-len(failures), len(silences))
+	if len(failures) > 0 {
+		summary += fmt.Sprintf("\nFailed to delete %d:", len(failures))
+		for i, f := range failures {
+			if i == silenceCleanupFailuresShown {
+				summary += fmt.Sprintf("\nand %d more", len(failures)-i)
+				break
+			}
+			summary += "\n" + f
+		}
+	}
+	_, err = b.reply(message, summary)
+	return err
+}
@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestSilenceFilters(t *testing.T) {
+	now := time.Now()
+	expired := alertmanagertest.Silence("expired").Comment("@elliot (123)", "").Ends(now.Add(-time.Hour)).Build()
+	old := alertmanagertest.Silence("old").Comment("darlene", "").Ends(now.Add(-24 * time.Hour)).Build()
+	old.StartsAt = now.Add(-40 * 24 * time.Hour)
+	active := alertmanagertest.Silence("active").Comment("elliot", "").Build()
+	silences := []*types.Silence{expired, old, active}
+
+	ids := func(filter silenceFilter) []string {
+		var ids []string
+		for _, s := range filterSilences(silences, filter, now) {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{"expired", "old"}, ids(silenceInState(types.SilenceStateExpired)))
+	require.Equal(t, []string{"active"}, ids(silenceInState(types.SilenceStateActive)))
+	require.Equal(t, []string{"old"}, ids(silenceCreatedBy("Darlene")))
+	require.Equal(t, []string{"old"}, ids(silenceOlderThan(30*24*time.Hour)))
+	require.Equal(t, []string{"expired"}, ids(allSilenceFilters(silenceInState(types.SilenceStateExpired), silenceCreatedBy("@elliot (123)"))))
+	require.Empty(t, ids(allSilenceFilters(silenceInState(types.SilenceStateActive), silenceOlderThan(time.Hour*24))))
+
+	me := &telebot.User{ID: 123, Username: "elliot"}
+	filter, err := parseSilenceFilters("created_by:me", me)
+	require.NoError(t, err)
+	require.Equal(t, []string{"expired", "active"}, ids(filter), "silences created in Telegram and by the username are the sender's")
+	filter, err = parseSilenceFilters("expired older_than:30d", me)
+	require.NoError(t, err)
+	require.Equal(t, []string{"old"}, ids(filter))
+
+	for _, payload := range []string{"", "everything", "older_than:soon"} {
+		_, err := parseSilenceFilters(payload, me)
+		require.Error(t, err, payload)
+	}
+}
+
+func TestSilencesCleanup(t *testing.T) {
+	var silences []*types.Silence
+	for i := 0; i < 25; i++ {
+		silences = append(silences, alertmanagertest.Silence(fmt.Sprintf("s%d", i)).Matcher("alertname", "HighCPU").Ends(time.Now().Add(-time.Hour)).Build())
+	}
+	silences = append(silences, alertmanagertest.Silence("active").Matcher("alertname", "DiskFull").Build())
+	am := &alertmanagertest.Alertmanager{Silences: silences}
+	b, tb, _ := newTestBot(t, WithAlertmanager(am), WithReplyToCommands(true))
+	b.silenceCleanupInterval = 0
+
+	message := &telebot.Message{ID: 7, Sender: testAdmin, Chat: testChat, Text: CommandSilencesCleanup + " expired", Payload: "expired"}
+	require.NoError(t, b.handleSilencesCleanup(message))
+	require.Equal(t, "25 silences match: HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, HighCPU, and 15 more.\nConfirm to delete all of them.", tb.lastText())
+	require.Empty(t, am.DeletedSilences(), "nothing is deleted before it's confirmed")
+	data := buttons(tb.messages()[0])
+	require.Len(t, data, 1)
+
+	b.handleTargetConfirm(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: data[0]})
+	require.Len(t, am.DeletedSilences(), 25)
+	require.NotContains(t, am.DeletedSilences(), "active")
+	require.Equal(t, "Deleting 25 silences...", tb.messages()[1].text())
+	require.Equal(t, message, repliedTo(tb.messages()[1]), "the progress replies to the command like the summary")
+	require.Len(t, tb.editedTexts, 2, "the progress is updated every 10 deletions")
+	require.Equal(t, "Deleting 25 silences... 20 done.", tb.editedTexts[1].what)
+	require.Equal(t, "Deleted 25 of 25 silences.", tb.lastText())
+
+	b.handleTargetConfirm(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: data[0]})
+	require.Len(t, am.DeletedSilences(), 25, "a cleanup is confirmed once")
+}
+
+func TestSilencesCleanupFailures(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{
+		Silences: []*types.Silence{
+			alertmanagertest.Silence("s1").Matcher("alertname", "HighCPU").Comment("darlene", "").Build(),
+			alertmanagertest.Silence("s2").Matcher("alertname", "DiskFull").Comment("darlene", "").Build(),
+		},
+		Errs: map[string]error{alertmanagertest.MethodDeleteSilence: errors.New("silence not found")},
+	}
+	b, tb, _ := newTestBot(t, WithAlertmanager(am))
+	b.silenceCleanupInterval = 0
+
+	require.NoError(t, b.handleSilencesCleanup(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "created_by:darlene"}))
+	data := buttons(tb.messages()[0])
+	b.handleTargetConfirm(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: data[0]})
+	require.Equal(t, "Deleted 0 of 2 silences.\nFailed to delete 2:\nHighCPU (s1): silence not found\nDiskFull (s2): silence not found", tb.lastText())
+
+	require.NoError(t, b.handleSilencesCleanup(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "pending"}))
+	require.Equal(t, "No silences match.", tb.lastText())
+}
@@ -0,0 +1,290 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandSilenceExtend = "/silence_extend"
+
+	silenceExtendUnique   = "silence_extend"
+	silenceRecreateUnique = "silence_recreate"
+	// callbackDataMax is the most Telegram takes as callback data, telebot's "\f<unique>|" included.
+	callbackDataMax = 64
+
+	defaultMaxSilenceExtension = 7 * 24 * time.Hour
+
+	responseSilenceExtendUsage = "Usage: " + CommandSilenceExtend + " <silence id> 4h"
+)
+
+// silenceExtensions are the extensions offered as buttons for every active silence /silences lists.
+var silenceExtensions = []time.Duration{time.Hour, 4 * time.Hour}
+
+var (
+	errSilenceNotFound = errors.New("there is no silence with this ID")
+	errSilenceExpired  = errors.New("the silence has expired already")
+)
+
+// WithMaxSilenceExtension caps how far a silence can be extended at once.
+func WithMaxSilenceExtension(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d <= 0 {
+			return fmt.Errorf("the maximum silence extension must be positive, is %s", d)
+		}
+		b.maxSilenceExtension = d
+		return nil
+	}
+}
+
+// formatExtension drops the zero minutes and seconds of durations, 4h0m0s reads 4h.
+func formatExtension(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// silenceName is the alertname a silence matches, or the start of its ID.
+func silenceName(s *types.Silence) string {
+	for _, m := range s.Matchers {
+		if m.Name == "alertname" {
+			return m.Value
+		}
+	}
+	if len(s.ID) > 8 {
+		return s.ID[:8]
+	}
+	return s.ID
+}
+
+// findSilence gets the silence with the ID from Alertmanager.
+func (b *Bot) findSilence(ctx context.Context, id string) (*types.Silence, error) {
+	silences, err := b.alertmanager.ListSilences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range silences {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, errSilenceNotFound
+}
+
+// extendSilence moves the end of an active or pending silence forward by d and returns it as updated.
+func (b *Bot) extendSilence(ctx context.Context, id string, d time.Duration, by *telebot.User) (*types.Silence, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("the extension must be positive, is %s", d)
+	}
+	if d > b.maxSilenceExtension {
+		return nil, fmt.Errorf("silences can be extended by at most %s at once", formatExtension(b.maxSilenceExtension))
+	}
+	s, err := b.findSilence(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status.State == types.SilenceStateExpired || alertmanager.Resolved(s) {
+		return nil, errSilenceExpired
+	}
+
+	extended := *s
+	extended.EndsAt = s.EndsAt.Add(d)
+	if _, err := b.alertmanager.UpdateSilence(ctx, &extended); err != nil {
+		return nil, err
+	}
+	level.Info(b.logger).Log("msg", "extended silence", "silence_id", id, "user_id", by.ID, "username", by.Username,
+		"extension", d, "ends_at_before", s.EndsAt, "ends_at", extended.EndsAt)
+	return &extended, nil
+}
+
+// recreateSilence creates a new silence like the expired one, from now on for d.
+func (b *Bot) recreateSilence(ctx context.Context, id string, d time.Duration, by *telebot.User) (*types.Silence, error) {
+	if d <= 0 || d > b.maxSilenceExtension {
+		return nil, fmt.Errorf("silences can be recreated for at most %s", formatExtension(b.maxSilenceExtension))
+	}
+	s, err := b.findSilence(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	recreated := *s
+	recreated.ID = ""
+	recreated.StartsAt = time.Now()
+	recreated.EndsAt = recreated.StartsAt.Add(d)
+	if recreated.ID, err = b.alertmanager.UpdateSilence(ctx, &recreated); err != nil {
+		return nil, err
+	}
+	level.Info(b.logger).Log("msg", "recreated expired silence", "silence_id", id, "new_silence_id", recreated.ID,
+		"user_id", by.ID, "username", by.Username, "ends_at", recreated.EndsAt)
+	return &recreated, nil
+}
+
+// silenceButtonData encodes a silence and a duration as "id;4h", "" if it doesn't fit the button.
+func silenceButtonData(unique string, id string, d time.Duration) string {
+	data := id + ";" + formatExtension(d)
+	if len("\f"+unique+"|"+data) > callbackDataMax {
+		return ""
+	}
+	return data
+}
+
+func parseSilenceButtonData(data string) (string, time.Duration, error) {
+	parts := strings.SplitN(data, ";", 2)
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("malformed silence button data %q", data)
+	}
+	d, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return "", 0, err
+	}
+	return parts[0], d, nil
+}
+
+// silenceExtendMarkup has a row of extension buttons for every silence that hasn't expired.
+func silenceExtendMarkup(silences []*types.Silence) *telebot.ReplyMarkup {
+	var rows [][]telebot.InlineButton
+	for _, s := range silences {
+		if s.Status.State == types.SilenceStateExpired || alertmanager.Resolved(s) {
+			continue
+		}
+		var row []telebot.InlineButton
+		for _, d := range silenceExtensions {
+			if data := silenceButtonData(silenceExtendUnique, s.ID, d); data != "" {
+				row = append(row, telebot.InlineButton{
+					Unique: silenceExtendUnique,
+					Text:   fmt.Sprintf("%s +%s", silenceName(s), formatExtension(d)),
+					Data:   data,
+				})
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return &telebot.ReplyMarkup{InlineKeyboard: rows}
+}
+
+// silenceExtendedReply tells when the silence ends now, in the chat's timezone.
+func (b *Bot) silenceExtendedReply(chat *telebot.Chat, s *types.Silence, verb string) string {
+	ci, _ := b.chats.GetChatInfo(chat.ID)
+	return fmt.Sprintf("Silence %s %s, it ends at %s now.",
+		silenceName(s), verb, s.EndsAt.In(chatLocation(ci)).Format("2006-01-02 15:04 MST"))
+}
+
+// sendRecreateOffer offers to recreate the expired silence for d.
+func (b *Bot) sendRecreateOffer(chat *telebot.Chat, id string, d time.Duration) error {
+	text := fmt.Sprintf("Silence %s has expired already and can't be extended.", id)
+	data := silenceButtonData(silenceRecreateUnique, id, d)
+	if data == "" {
+		_, err := b.send(chat, text, "silence recreate offer")
+		return err
+	}
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: silenceRecreateUnique, Text: "Recreate it for " + formatExtension(d), Data: data},
+	}}}
+	_, err := b.send(chat, text, "silence recreate offer", &telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleSilenceExtend(message *telebot.Message) error {
+	fields := strings.Fields(message.Payload)
+	if len(fields) != 2 {
+		_, err := b.reply(message, responseSilenceExtendUsage)
+		return err
+	}
+	d, err := time.ParseDuration(fields[1])
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseSilenceExtendUsage))
+		return err
+	}
+
+	s, err := b.extendSilence(context.TODO(), fields[0], d, message.Sender)
+	switch {
+	case errors.Is(err, errSilenceExpired):
+		return b.sendRecreateOffer(message.Chat, fields[0], d)
+	case err != nil:
+		level.Warn(b.logger).Log("msg", "failed to extend silence", "silence_id", fields[0], "err", err)
+		_, err = b.respondFailed(message, "extend silence", err)
+		return err
+	}
+	_, err = b.reply(message, b.silenceExtendedReply(message.Chat, s, "extended by "+formatExtension(d)))
+	return err
+}
+
+// handleSilenceButton extends or recreates a silence from the buttons of /silences and recreate offers.
+func (b *Bot) handleSilenceButton(recreate bool) func(*telebot.Callback) {
+	return func(c *telebot.Callback) {
+		respond := func(text string) {
+			var resp []*telebot.CallbackResponse
+			if text != "" {
+				resp = append(resp, &telebot.CallbackResponse{Text: text})
+			}
+			if err := b.telegram.Respond(c, resp...); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+			}
+		}
+
+		if c.Message == nil || c.Message.Chat == nil {
+			respond("")
+			return
+		}
+		if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+			respond("Only admins can change silences.")
+			return
+		}
+		id, d, err := parseSilenceButtonData(c.Data)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to parse silence button", "err", err)
+			respond("")
+			return
+		}
+
+		var s *types.Silence
+		var verb string
+		if recreate {
+			s, err = b.recreateSilence(context.TODO(), id, d, c.Sender)
+			verb = "recreated for " + formatExtension(d)
+		} else {
+			s, err = b.extendSilence(context.TODO(), id, d, c.Sender)
+			verb = "extended by " + formatExtension(d)
+		}
+		switch {
+		case errors.Is(err, errSilenceExpired):
+			respond("")
+			if err := b.sendRecreateOffer(c.Message.Chat, id, d); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to offer recreating the silence", "err", err)
+			}
+			return
+		case err != nil:
+			level.Warn(b.logger).Log("msg", "failed to change silence", "silence_id", id, "err", err)
+			respond(b.failedText(c.Message, "change silence", err))
+			return
+		}
+		respond("")
+
+		if recreate {
+			if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to remove recreate button", "err", err)
+			}
+		}
+		if _, err := b.send(c.Message.Chat, b.silenceExtendedReply(c.Message.Chat, s, verb), "button silence "+verb); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send silence confirmation", "err", err)
+		}
+	}
+}
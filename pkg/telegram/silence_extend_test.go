@@ -0,0 +1,103 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	activeSilenceID  = "34f5f82b-b66f-456b-aff7-b556a7eafe81"
+	expiredSilenceID = "9c2b1f7e-5d1a-4e0b-8a45-5a0c6e3f2d10"
+)
+
+func silenceExtendAlertmanager(now time.Time) *alertmanagertest.Alertmanager {
+	return &alertmanagertest.Alertmanager{Silences: []*types.Silence{
+		{
+			ID:       activeSilenceID,
+			StartsAt: now.Add(-time.Hour),
+			EndsAt:   now.Add(time.Hour),
+			Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "DiskFull"}},
+			Status:   types.SilenceStatus{State: types.SilenceStateActive},
+		},
+		{
+			ID:       expiredSilenceID,
+			StartsAt: now.Add(-3 * time.Hour),
+			EndsAt:   now.Add(-time.Hour),
+			Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"}},
+			Status:   types.SilenceStatus{State: types.SilenceStateExpired},
+		},
+	}}
+}
+
+func TestSilenceExtend(t *testing.T) {
+	now := time.Now()
+	am := silenceExtendAlertmanager(now)
+	b, tb, _ := newTestBot(t, WithAlertmanager(am), WithMaxSilenceExtension(8*time.Hour))
+
+	require.NoError(t, b.handleSilenceExtend(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: activeSilenceID + " 4h"}))
+	require.Len(t, am.UpdatedSilences(), 1)
+	require.Equal(t, activeSilenceID, am.UpdatedSilences()[0].ID, "posting with the ID updates the silence")
+	require.Equal(t, am.Silences[0].StartsAt, am.UpdatedSilences()[0].StartsAt)
+	require.Equal(t, am.Silences[0].EndsAt.Add(4*time.Hour), am.UpdatedSilences()[0].EndsAt)
+	require.Contains(t, tb.lastText(), "Silence DiskFull extended by 4h, it ends at ")
+
+	require.NoError(t, b.handleSilenceExtend(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: activeSilenceID + " 9h"}))
+	require.Equal(t, "failed to extend silence... silences can be extended by at most 8h at once", tb.lastText())
+	require.NoError(t, b.handleSilenceExtend(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "unknown 1h"}))
+	require.Equal(t, "failed to extend silence... "+errSilenceNotFound.Error(), tb.lastText())
+	require.NoError(t, b.handleSilenceExtend(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: activeSilenceID}))
+	require.Equal(t, responseSilenceExtendUsage, tb.lastText())
+	require.Len(t, am.UpdatedSilences(), 1)
+
+	// Expired silences can't be extended, but recreated.
+	require.NoError(t, b.handleSilenceExtend(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: expiredSilenceID + " 2h"}))
+	require.Equal(t, "Silence "+expiredSilenceID+" has expired already and can't be extended.", tb.lastText())
+	require.Len(t, am.UpdatedSilences(), 1)
+	data := buttons(tb.messages()[len(tb.messages())-1])
+	require.Equal(t, []string{expiredSilenceID + ";2h"}, data)
+
+	b.handleSilenceButton(true)(&telebot.Callback{
+		Sender:  testAdmin,
+		Message: &telebot.Message{ID: len(tb.messages()), Chat: testChat},
+		Data:    data[0],
+	})
+	require.Len(t, am.UpdatedSilences(), 2)
+	recreated := am.UpdatedSilences()[1]
+	require.Empty(t, recreated.ID, "posting without an ID creates a new silence")
+	require.Equal(t, am.Silences[1].Matchers, recreated.Matchers)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), recreated.EndsAt, time.Minute)
+	require.Contains(t, tb.lastText(), "Silence HighCPU recreated for 2h, it ends at ")
+	require.Len(t, tb.edited, 1, "the recreate button is removed")
+}
+
+func TestSilenceExtendButtons(t *testing.T) {
+	am := silenceExtendAlertmanager(time.Now())
+	b, tb, _ := newTestBot(t, WithAlertmanager(am))
+
+	require.NoError(t, b.handleSilences(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandSilences}))
+	data := buttons(tb.messages()[0])
+	require.Equal(t, []string{activeSilenceID + ";1h", activeSilenceID + ";4h"}, data, "only silences that haven't expired can be extended")
+
+	b.handleSilenceButton(false)(&telebot.Callback{
+		Sender:  &telebot.User{ID: 999},
+		Message: &telebot.Message{ID: 1, Chat: testChat},
+		Data:    data[1],
+	})
+	require.Equal(t, "Only admins can change silences.", tb.responses[0].Text)
+	require.Empty(t, am.UpdatedSilences())
+
+	b.handleSilenceButton(false)(&telebot.Callback{
+		Sender:  testAdmin,
+		Message: &telebot.Message{ID: 1, Chat: testChat},
+		Data:    data[1],
+	})
+	require.Len(t, am.UpdatedSilences(), 1)
+	require.Equal(t, am.Silences[0].EndsAt.Add(4*time.Hour), am.UpdatedSilences()[0].EndsAt)
+	require.Contains(t, tb.lastText(), "Silence DiskFull extended by 4h")
+}
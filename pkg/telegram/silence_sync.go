@@ -0,0 +1,257 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandAck = "/ack"
+
+	// silenceSyncMaxAge is how old notifications can be and still get edited for new silences.
+	silenceSyncMaxAge = 24 * time.Hour
+	// silenceSyncTimeout is the longest a silence sync waits for Alertmanager.
+	silenceSyncTimeout = 30 * time.Second
+
+	responseAckUsage = "Reply to an alert notification with " + CommandAck + " to acknowledge it."
+)
+
+// WithSilenceSync checks Alertmanager for new silences every interval and edits the notifications
+// of the alerts they cover to tell who silenced them and why, 0 doesn't.
+// The notifications' text is kept in the store for that.
+func WithSilenceSync(interval time.Duration) BotOption {
+	return func(b *Bot) error {
+		if interval < 0 {
+			return fmt.Errorf("the silence sync interval must not be negative, is %s", interval)
+		}
+		b.silenceSyncInterval = interval
+		return nil
+	}
+}
+
+// WithAckCreatesSilence makes /ack silence the alerts of the acknowledged notification in Alertmanager for d,
+// 0 only acknowledges them in the chat.
+func WithAckCreatesSilence(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d < 0 {
+			return fmt.Errorf("the acknowledgement silence duration must not be negative, is %s", d)
+		}
+		b.ackSilence = d
+		return nil
+	}
+}
+
+// silenceSyncState is how far the silence sync got.
+type silenceSyncState struct {
+	mu sync.Mutex
+	// since is when the newest silence seen was updated, by Alertmanager's clock.
+	since time.Time
+	// started is false until the first sync, which only notes the silences there are already.
+	started bool
+}
+
+func (b *Bot) runSilenceSync(ctx context.Context) {
+	ticker := time.NewTicker(b.silenceSyncInterval)
+	defer ticker.Stop()
+	for {
+		if b.leading() {
+			b.syncSilencesOnce(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bot) syncSilencesOnce(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, silenceSyncTimeout)
+	defer cancel()
+	if err := b.syncSilences(ctx, time.Now()); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to sync silences", "err", err)
+	}
+}
+
+// syncSilences edits the recent notifications of alerts covered by silences created or updated since the last sync.
+func (b *Bot) syncSilences(ctx context.Context, now time.Time) error {
+	silences, err := b.alertmanager.ListSilences(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list silences: %w", err)
+	}
+
+	state := b.silenceSync
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var fresh []*types.Silence
+	newest := state.since
+	for _, s := range silences {
+		if s.UpdatedAt.After(newest) {
+			newest = s.UpdatedAt
+		}
+		if state.started && s.Status.State == types.SilenceStateActive && s.UpdatedAt.After(state.since) {
+			fresh = append(fresh, s)
+		}
+	}
+	started := state.started
+	state.since, state.started = newest, true
+	if !started || len(fresh) == 0 {
+		return nil
+	}
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i].UpdatedAt.Before(fresh[j].UpdatedAt) })
+
+	alerts, err := b.alertmanager.ListAlerts(ctx, correlationAllReceivers, true)
+	if err != nil {
+		return fmt.Errorf("failed to list alerts: %w", err)
+	}
+	alertLabels := make(map[string]model.LabelSet, len(alerts))
+	for _, a := range alerts {
+		alertLabels[a.Fingerprint().String()] = a.Labels
+	}
+
+	records, err := b.chats.ListMessages()
+	if err != nil {
+		return fmt.Errorf("failed to list messages: %w", err)
+	}
+	for _, r := range records {
+		if r.Text == "" || now.Sub(r.SentAt) > silenceSyncMaxAge {
+			continue
+		}
+		var notes []string
+		for _, s := range fresh {
+			if contains(r.SilencedBy, s.ID) || !silenceCovers(s, r.Fingerprints, alertLabels) {
+				continue
+			}
+			notes = append(notes, silenceNote(s, r.ParseMode))
+			r.SilencedBy = append(r.SilencedBy, s.ID)
+		}
+		if len(notes) == 0 {
+			continue
+		}
+		b.editSilenced(r, notes)
+	}
+	return nil
+}
+
+// editSilenced appends the notes to the message and remembers which silences it was edited for.
+func (b *Bot) editSilenced(r MessageRecord, notes []string) {
+	r.Text = r.Text + "\n\n" + strings.Join(notes, "\n")
+	msg := &telebot.Message{ID: r.MessageID, Chat: &telebot.Chat{ID: r.ChatID}}
+	if _, err := b.telegram.Edit(msg, r.Text, &telebot.SendOptions{ParseMode: r.ParseMode, ReplyMarkup: r.ReplyMarkup}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to edit silenced notification", "chat_id", r.ChatID, "message_id", r.MessageID, "err", err)
+		return
+	}
+	if err := b.chats.AddMessage(r); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to store edited message", "err", err)
+	}
+}
+
+// silenceCovers returns whether the silence matches any of the alerts with the fingerprints.
+func silenceCovers(s *types.Silence, fingerprints []string, alertLabels map[string]model.LabelSet) bool {
+	for _, fp := range fingerprints {
+		if lset, ok := alertLabels[fp]; ok && alertmanager.SilenceMatches(s, lset) {
+			return true
+		}
+	}
+	return false
+}
+
+// silenceNote tells who silenced the alerts and why, formatted for the parse mode of the notification.
+func silenceNote(s *types.Silence, mode telebot.ParseMode) string {
+	note := "🔇 silenced by " + s.CreatedBy
+	if s.Comment != "" {
+		note = note + ": " + s.Comment
+	}
+	switch mode {
+	case telebot.ModeHTML:
+		return html.EscapeString(note)
+	case telebot.ModeMarkdownV2:
+		return htmlToMarkdownV2(html.EscapeString(note))
+	default:
+		return note
+	}
+}
+
+// ackSilences silences every firing alert of the notification on its own, matching all its labels.
+// It returns how many alerts it silenced.
+func (b *Bot) ackSilences(ctx context.Context, r *MessageRecord, by string, now time.Time) (int, error) {
+	alerts, err := b.alertmanager.ListAlerts(ctx, correlationAllReceivers, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list alerts: %w", err)
+	}
+
+	var silenced int
+	for _, a := range alerts {
+		if !contains(r.Fingerprints, a.Fingerprint().String()) || a.Resolved() {
+			continue
+		}
+		names := make([]string, 0, len(a.Labels))
+		for name := range a.Labels {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		matchers := make(labels.Matchers, 0, len(names))
+		for _, name := range names {
+			matchers = append(matchers, &labels.Matcher{Type: labels.MatchEqual, Name: name, Value: string(a.Labels[model.LabelName(name)])})
+		}
+
+		_, err := b.alertmanager.UpdateSilence(ctx, &types.Silence{
+			Matchers:  matchers,
+			StartsAt:  now,
+			EndsAt:    now.Add(b.ackSilence),
+			CreatedBy: by,
+			Comment:   "Acknowledged in Telegram",
+		})
+		if err != nil {
+			return silenced, fmt.Errorf("failed to create silence: %w", err)
+		}
+		silenced++
+	}
+	return silenced, nil
+}
+
+func (b *Bot) handleAck(message *telebot.Message) error {
+	if message.ReplyTo == nil {
+		_, err := b.reply(message, responseAckUsage)
+		return err
+	}
+	record, err := b.chats.GetMessage(message.Chat.ID, message.ReplyTo.ID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get the acknowledged message", "err", err)
+		_, err = b.reply(message, "I don't know which alerts the message you replied to was about.\n"+responseAckUsage)
+		return err
+	}
+
+	by := senderName(message.Sender)
+	level.Info(b.logger).Log("msg", "alerts acknowledged", "chat_id", message.Chat.ID, "message_id", record.MessageID, "sender_id", message.Sender.ID)
+	if b.ackSilence == 0 {
+		_, err = b.reply(message, fmt.Sprintf("✅ Acknowledged by %s.", by))
+		return err
+	}
+
+	silenced, err := b.ackSilences(context.TODO(), record, by, time.Now())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to silence acknowledged alerts", "err", err)
+		_, err = b.reply(message, fmt.Sprintf("✅ Acknowledged by %s, but failed to silence the alerts... %v", by, err))
+		return err
+	}
+	if silenced == 0 {
+		_, err = b.reply(message, fmt.Sprintf("✅ Acknowledged by %s. None of the alerts are firing anymore, so nothing was silenced.", by))
+		return err
+	}
+	_, err = b.reply(message, fmt.Sprintf("✅ Acknowledged by %s and silenced %d alerts for %s.", by, silenced, formatExtension(b.ackSilence)))
+	return err
+}
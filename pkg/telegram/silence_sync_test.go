@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// notifyAlert sends a notification about the alert to testChat and returns its message ID.
+func notifyAlert(t *testing.T, b *Bot, tb *fakeTelebot, a *types.Alert) int {
+	labels := template.KV{}
+	for name, value := range a.Labels {
+		labels[string(name)] = string(value)
+	}
+	_, err := b.processWebhook(context.Background(), mixedWebhook("firing", template.Alert{
+		Status:      "firing",
+		Labels:      labels,
+		Fingerprint: a.Fingerprint().String(),
+	}))
+	require.NoError(t, err)
+	return len(tb.messages())
+}
+
+func TestSilenceSync(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Project("billing").Build()
+	diskFull := alertmanagertest.Alert("DiskFull").Project("frontend").Build()
+	am := &alertmanagertest.Alertmanager{
+		Alerts:   []*types.Alert{highCPU, diskFull},
+		Silences: []*types.Silence{alertmanagertest.Silence("old").Matcher("alertname", "HighCPU").Build()},
+	}
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithAlertmanager(am),
+		WithSilenceSync(time.Minute),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ctx := context.Background()
+
+	highCPUMessage := notifyAlert(t, b, tb, highCPU)
+	notifyAlert(t, b, tb, diskFull)
+	record, err := chats.GetMessage(testChat.ID, highCPUMessage)
+	require.NoError(t, err)
+	require.Equal(t, tb.messages()[0].text(), record.Text, "the text is kept to edit it")
+	require.Equal(t, telebot.ModeHTML, record.ParseMode)
+
+	require.NoError(t, b.syncSilences(ctx, time.Now()))
+	require.Empty(t, tb.editedTexts, "silences there were before the first sync are only noted")
+
+	silence := alertmanagertest.Silence("new").Matcher("project", "billing").Comment("darlene", "Deploying <v2>").Build()
+	silence.UpdatedAt = time.Now()
+	expired := alertmanagertest.Silence("expired").Matcher("alertname", "DiskFull").Ends(time.Now().Add(-time.Minute)).Build()
+	expired.UpdatedAt = time.Now()
+	am.Silences = append(am.Silences, silence, expired)
+
+	require.NoError(t, b.syncSilences(ctx, time.Now()))
+	require.Len(t, tb.editedTexts, 1, "only the message of the silenced alert is edited")
+	edited := tb.editedTexts[0]
+	require.Equal(t, "123/1", edited.to)
+	require.Equal(t, record.Text+"\n\n🔇 silenced by darlene: Deploying &lt;v2&gt;", edited.text())
+	require.Equal(t, telebot.ModeHTML, edited.options[0].(*telebot.SendOptions).ParseMode)
+
+	record, err = chats.GetMessage(testChat.ID, highCPUMessage)
+	require.NoError(t, err)
+	require.Equal(t, []string{"new"}, record.SilencedBy)
+	require.Equal(t, edited.text(), record.Text)
+
+	// An update of the silence doesn't edit the message again.
+	silence.UpdatedAt = time.Now().Add(time.Second)
+	require.NoError(t, b.syncSilences(ctx, time.Now()))
+	require.Len(t, tb.editedTexts, 1)
+}
+
+func TestSilenceSyncOff(t *testing.T) {
+	a := alertmanagertest.Alert("HighCPU").Build()
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	record, err := chats.GetMessage(testChat.ID, notifyAlert(t, b, tb, a))
+	require.NoError(t, err)
+	require.Empty(t, record.Text, "the text isn't kept without the silence sync")
+}
+
+func TestAckCreatesSilence(t *testing.T) {
+	highCPU := alertmanagertest.Alert("HighCPU").Project("billing").Build()
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{highCPU, alertmanagertest.Alert("DiskFull").Build()}}
+	b, tb, chats := newTestBot(t,
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithAlertmanager(am),
+		WithAckCreatesSilence(30*time.Minute),
+	)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	notification := &telebot.Message{ID: notifyAlert(t, b, tb, highCPU), Chat: testChat}
+
+	require.NoError(t, b.handleAck(&telebot.Message{Sender: secondAdmin, Chat: testChat, Text: CommandAck, ReplyTo: notification}))
+	require.Equal(t, "✅ Acknowledged by @darlene (456) and silenced 1 alerts for 30m.", tb.lastText())
+
+	silences := am.UpdatedSilences()
+	require.Len(t, silences, 1)
+	s := silences[0]
+	require.Equal(t, "", s.ID, "a new silence is created")
+	require.Equal(t, "@darlene (456)", s.CreatedBy)
+	require.Equal(t, 30*time.Minute, s.EndsAt.Sub(s.StartsAt))
+	require.Equal(t, labels.Matchers{
+		{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"},
+		{Type: labels.MatchEqual, Name: "project", Value: "billing"},
+	}, s.Matchers)
+}
+
+func TestAck(t *testing.T) {
+	am := &alertmanagertest.Alertmanager{}
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithAlertmanager(am))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAck}))
+	require.Equal(t, responseAckUsage, tb.lastText())
+
+	require.NoError(t, b.handleAck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAck, ReplyTo: &telebot.Message{ID: 99}}))
+	require.Contains(t, tb.lastText(), "I don't know which alerts the message you replied to was about.")
+
+	notification := &telebot.Message{ID: notifyAlert(t, b, tb, alertmanagertest.Alert("HighCPU").Build()), Chat: testChat}
+	require.NoError(t, b.handleAck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandAck, ReplyTo: notification}))
+	require.Equal(t, "✅ Acknowledged by @elliot (123).", tb.lastText())
+	require.Empty(t, am.UpdatedSilences(), "no silence is created unless the bot is set up to")
+}
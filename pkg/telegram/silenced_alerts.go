@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// tmplAlertsWithSilences renders each alert once, followed by the silences covering it.
+func (b *Bot) tmplAlertsWithSilences(chatInfo *ChatInfo, alerts []*types.Alert) (string, error) {
+	silences, err := b.alertmanager.ListSilences(context.TODO())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list silences for alerts", "err", err)
+	}
+
+	var out strings.Builder
+	seen := map[string]bool{}
+	for _, a := range alerts {
+		fingerprint := a.Fingerprint().String()
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		rendered, err := b.tmplAlerts(chatInfo, a)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(strings.TrimRight(rendered, "\n"))
+		out.WriteString("\n")
+		for _, s := range alertmanager.CoveringSilences(silences, a.Labels) {
+			out.WriteString(silencedByLine(s))
+			out.WriteString("\n")
+		}
+	}
+	return out.String(), nil
+}
+
+// silencedByLine tells which silence covers an alert, like:
+// 🔇 silenced by 34f5f82b-b66f-456b-aff7-b556a7eafe81 until 2022-01-11 16:10 UTC (maintenance)
+func silencedByLine(s *types.Silence) string {
+	line := fmt.Sprintf("🔇 silenced by <code>%s</code> until %s", html.EscapeString(s.ID), s.EndsAt.UTC().Format("2006-01-02 15:04 MST"))
+	if s.Comment != "" {
+		line = line + fmt.Sprintf(" (%s)", html.EscapeString(s.Comment))
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestAlertsSilencedBy(t *testing.T) {
+	endsAt := time.Date(2022, 1, 11, 16, 10, 0, 0, time.UTC)
+	alert := &types.Alert{Alert: model.Alert{
+		Labels:   model.LabelSet{"alertname": "HighCPU", "instance": "node-01"},
+		StartsAt: time.Now().Add(-time.Hour),
+	}}
+	am := &alertmanagertest.Alertmanager{
+		Alerts: []*types.Alert{alert, alert},
+		Silences: []*types.Silence{
+			{
+				ID:       "by-name",
+				Comment:  "upgrade <db>",
+				EndsAt:   endsAt,
+				Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "HighCPU"}},
+				Status:   types.SilenceStatus{State: types.SilenceStateActive},
+			},
+			{
+				ID:       "by-instance",
+				EndsAt:   endsAt.Add(-time.Hour),
+				Matchers: labels.Matchers{{Type: labels.MatchRegexp, Name: "instance", Value: "node-0[0-9]"}},
+				Status:   types.SilenceStatus{State: types.SilenceStateActive},
+			},
+			{
+				ID:       "other",
+				EndsAt:   endsAt,
+				Matchers: labels.Matchers{{Type: labels.MatchEqual, Name: "alertname", Value: "DiskFull"}},
+				Status:   types.SilenceStatus{State: types.SilenceStateActive},
+			},
+		},
+	}
+
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "silenced"}))
+	out := tb.lastText()
+
+	require.Equal(t, 1, strings.Count(out, "HighCPU"), "duplicate alerts are listed once")
+	require.Contains(t, out, "🔇 silenced by <code>by-name</code> until 2022-01-11 16:10 UTC (upgrade &lt;db&gt;)\n🔇 silenced by <code>by-instance</code> until 2022-01-11 15:10 UTC\n")
+	require.NotContains(t, out, "other")
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.NotContains(t, tb.lastText(), "silenced by")
+}
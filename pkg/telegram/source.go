@@ -0,0 +1,34 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// errWebhookDropped is what webhooks are acked with if the bot stopped before delivering them.
+var errWebhookDropped = errors.New("the bot stopped before delivering the webhook")
+
+// WebhookSource is where Run gets webhooks from.
+type WebhookSource interface {
+	// Receive returns the webhooks of the source until ctx is done. Webhooks that
+	// can be redelivered come with an Ack, which the bot calls once it's done with them.
+	Receive(ctx context.Context) (<-chan alertmanager.TelegramWebhook, error)
+}
+
+// ChannelSource is a WebhookSource of webhooks sent to a channel,
+// like those Alertmanager posts to the bot's HTTP handler.
+type ChannelSource <-chan alertmanager.TelegramWebhook
+
+// Receive returns the channel.
+func (c ChannelSource) Receive(context.Context) (<-chan alertmanager.TelegramWebhook, error) {
+	return c, nil
+}
+
+// ack tells the source of the webhook whether it was delivered, if it wants to know.
+func ack(w alertmanager.TelegramWebhook, err error) {
+	if w.Ack != nil {
+		w.Ack(err)
+	}
+}
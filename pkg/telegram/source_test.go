@@ -0,0 +1,124 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/source"
+)
+
+const webhooksSubject = "alertmanager.telegram"
+
+// runJetStream runs a NATS server with a JetStream stream of webhooks.
+func runJetStream(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoSigs: true})
+	require.NoError(t, err)
+	s.Start()
+	t.Cleanup(s.Shutdown)
+	require.True(t, s.ReadyForConnections(5*time.Second))
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "WEBHOOKS", Subjects: []string{webhooksSubject}})
+	require.NoError(t, err)
+	return s
+}
+
+func TestNATSSourceRedelivery(t *testing.T) {
+	s := runJetStream(t)
+
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	var sends int32
+	var failedAt time.Time
+	tb.sendErr = func() error {
+		if atomic.AddInt32(&sends, 1) == 1 {
+			failedAt = time.Now()
+			return errors.New("telegram is down")
+		}
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(context.Background(), source.NewNATS(s.ClientURL(), webhooksSubject,
+			source.WithQueueGroup("bots"),
+			source.WithNakBackoff(200*time.Millisecond, time.Second),
+		))
+	}()
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	payload, err := json.Marshal(suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"}))
+	require.NoError(t, err)
+	_, err = js.Publish(webhooksSubject, payload)
+	require.NoError(t, err)
+
+	// The failed send nacks the webhook after the backoff, NATS redelivers it and the second send gets through.
+	require.Eventually(t, func() bool { return len(tb.messages()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&sends))
+	require.GreaterOrEqual(t, int64(time.Since(failedAt)), int64(200*time.Millisecond), "the webhook isn't redelivered before the backoff")
+	require.Contains(t, tb.lastText(), "DiskFull")
+
+	require.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo("WEBHOOKS", "bots")
+		return err == nil && info.NumAckPending == 0 && info.Delivered.Consumer == 2
+	}, 5*time.Second, 10*time.Millisecond, "the delivered webhook is acked")
+
+	require.NoError(t, b.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+}
+
+func TestNATSSourceMaxDeliver(t *testing.T) {
+	s := runJetStream(t)
+
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	var sends int32
+	tb.sendErr = func() error {
+		atomic.AddInt32(&sends, 1)
+		return errors.New("telegram is down")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(context.Background(), source.NewNATS(s.ClientURL(), webhooksSubject,
+			source.WithQueueGroup("bots"),
+			source.WithMaxDeliver(2),
+			source.WithNakBackoff(10*time.Millisecond, 10*time.Millisecond),
+		))
+	}()
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	payload, err := json.Marshal(suppressedWebhook(payments.ID, "firing", template.KV{"alertname": "DiskFull"}))
+	require.NoError(t, err)
+	_, err = js.Publish(webhooksSubject, payload)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&sends) == 2 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&sends), "NATS gives up on the webhook after delivering it twice")
+
+	require.NoError(t, b.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+}
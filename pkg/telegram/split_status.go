@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// WithSplitByStatus sends the firing and the resolved alerts of a webhook as separate messages, the firing ones first,
+// so that resolved alerts don't hide firing ones in the same message. It's on by default.
+func WithSplitByStatus(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.splitByStatus = enabled
+		return nil
+	}
+}
+
+// unsplitKey marks the deliveries of digests, like the catch-up after maintenance, which are sent as one message.
+type unsplitKey struct{}
+
+// unsplit keeps the alerts delivered with the context in one message.
+func unsplit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unsplitKey{}, true)
+}
+
+// splitByStatus partitions the alerts into firing and resolved ones, keeping their order.
+func splitByStatus(alerts template.Alerts) (firing, resolved template.Alerts) {
+	for _, a := range alerts {
+		if a.Status == "resolved" {
+			resolved = append(resolved, a)
+		} else {
+			firing = append(firing, a)
+		}
+	}
+	return firing, resolved
+}
+
+// commonKV returns the pairs all of the KVs have.
+func commonKV(kvs []template.KV) template.KV {
+	common := template.KV{}
+	if len(kvs) == 0 {
+		return common
+	}
+	for k, v := range kvs[0] {
+		common[k] = v
+	}
+	for _, kv := range kvs[1:] {
+		for k, v := range common {
+			if kv[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// statusPart is the webhook with only the alerts of one status, its status and common labels and annotations
+// are those of the alerts. It keeps the group key, so that both parts belong to the same alert group.
+func statusPart(w alertmanager.TelegramWebhook, status string, alerts template.Alerts) alertmanager.TelegramWebhook {
+	labels := make([]template.KV, len(alerts))
+	annotations := make([]template.KV, len(alerts))
+	for i, a := range alerts {
+		labels[i], annotations[i] = a.Labels, a.Annotations
+	}
+	data := *w.Message.Data
+	data.Status = status
+	data.Alerts = alerts
+	data.CommonLabels = commonKV(labels)
+	data.CommonAnnotations = commonKV(annotations)
+	w.Message = webhook.Message{Data: &data, Version: w.Message.Version, GroupKey: w.Message.GroupKey, TruncatedAlerts: w.Message.TruncatedAlerts}
+	return w
+}
+
+// statusHeader is put above each of the messages a webhook is split into.
+func statusHeader(status string, n int) string {
+	if status == "resolved" {
+		return fmt.Sprintf("✅ <b>%d resolved</b>\n\n", n)
+	}
+	return fmt.Sprintf("🔥 <b>%d firing</b>\n\n", n)
+}
@@ -0,0 +1,119 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// mixedStatusWebhook is a webhook with firing and resolved alerts whose common labels are those of all of them.
+func mixedStatusWebhook() alertmanager.TelegramWebhook {
+	alerts := template.Alerts{
+		{Status: "resolved", Labels: template.KV{"alertname": "DiskFull", labelEnvironment: "prod", labelSeverity: "warning"}, Annotations: template.KV{"runbook": "disk"}},
+		{Status: "firing", Labels: template.KV{"alertname": "HighCPU", labelEnvironment: "prod", labelSeverity: "critical"}, Annotations: template.KV{"runbook": "cpu"}},
+		{Status: "firing", Labels: template.KV{"alertname": "HighLoad", labelEnvironment: "prod", labelSeverity: "critical"}, Annotations: template.KV{"runbook": "cpu"}},
+	}
+	return alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{
+		Data: &template.Data{
+			Receiver:          "telegram",
+			Status:            "firing",
+			Alerts:            alerts,
+			GroupLabels:       template.KV{labelEnvironment: "prod"},
+			CommonLabels:      template.KV{labelEnvironment: "prod"},
+			CommonAnnotations: template.KV{},
+		},
+		Version:         "4",
+		GroupKey:        `{}:{environment="prod"}`,
+		TruncatedAlerts: 2,
+	}}
+}
+
+func TestSplitByStatus(t *testing.T) {
+	w := mixedStatusWebhook()
+	firing, resolved := splitByStatus(w.Message.Alerts)
+	require.Equal(t, template.Alerts{w.Message.Alerts[1], w.Message.Alerts[2]}, firing)
+	require.Equal(t, template.Alerts{w.Message.Alerts[0]}, resolved)
+
+	part := statusPart(w, "firing", firing)
+	require.Equal(t, "firing", part.Message.Status)
+	require.Equal(t, firing, part.Message.Alerts)
+	require.Equal(t, template.KV{labelEnvironment: "prod", labelSeverity: "critical"}, part.Message.CommonLabels)
+	require.Equal(t, template.KV{"runbook": "cpu"}, part.Message.CommonAnnotations)
+	require.Equal(t, w.Message.GroupLabels, part.Message.GroupLabels)
+	require.Equal(t, w.Message.GroupKey, part.Message.GroupKey)
+	require.Equal(t, w.ChatID, part.ChatID)
+
+	part = statusPart(w, "resolved", resolved)
+	require.Equal(t, "resolved", part.Message.Status)
+	require.Equal(t, template.KV{"alertname": "DiskFull", labelEnvironment: "prod", labelSeverity: "warning"}, part.Message.CommonLabels)
+
+	require.Equal(t, "firing", w.Message.Status, "the webhook isn't changed")
+	require.Len(t, w.Message.Alerts, 3)
+	require.Equal(t, template.KV{labelEnvironment: "prod"}, w.Message.CommonLabels)
+}
+
+func TestCommonKV(t *testing.T) {
+	require.Equal(t, template.KV{}, commonKV(nil))
+	require.Equal(t, template.KV{"a": "1", "b": "2"}, commonKV([]template.KV{{"a": "1", "b": "2"}}))
+	require.Equal(t, template.KV{"a": "1"}, commonKV([]template.KV{{"a": "1", "b": "2"}, {"a": "1", "b": "3"}, {"a": "1", "c": "2"}}))
+	require.Equal(t, template.KV{}, commonKV([]template.KV{{"a": "1"}, {}}))
+}
+
+func TestWebhookSplitByStatus(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	d, err := b.processWebhook(context.Background(), mixedStatusWebhook())
+	require.NoError(t, err)
+	require.Equal(t, 2, d.Messages)
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 2)
+	firing, resolved := msgs[0].text(), msgs[1].text()
+	require.True(t, strings.HasPrefix(firing, "🔥 <b>2 firing</b>\n\n"), firing)
+	require.Contains(t, firing, "<b>HighCPU</b>")
+	require.Contains(t, firing, "<b>HighLoad</b>")
+	require.NotContains(t, firing, "DiskFull")
+	require.Contains(t, firing, "2 more alerts were left out of this webhook", "the truncated alerts are told about once")
+
+	require.True(t, strings.HasPrefix(resolved, "✅ <b>1 resolved</b>\n\n"), resolved)
+	require.Contains(t, resolved, "<b>DiskFull</b>")
+	require.NotContains(t, resolved, "HighCPU")
+	require.NotContains(t, resolved, "left out")
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 2, "both messages are tracked")
+}
+
+func TestWebhookSplitByStatusOff(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithSplitByStatus(false))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	_, err := b.processWebhook(context.Background(), mixedStatusWebhook())
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1)
+	require.NotContains(t, tb.lastText(), "1 resolved")
+}
+
+func TestWebhookSplitFiringFails(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	attempts := 0
+	tb.sendErr = func() error {
+		attempts++
+		return errors.New("telegram: internal server error (500)")
+	}
+
+	d, err := b.processWebhook(context.Background(), mixedStatusWebhook())
+	require.NoError(t, err)
+	require.Error(t, d.Failed)
+	require.Equal(t, 1, attempts, "the resolved alerts wait for the webhook to be retried")
+}
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+)
+
+const defaultStaleAfter = time.Hour
+
+// WithStaleThreshold sets how long a firing alert can go without Alertmanager updating it
+// before /alerts warns that its data may be stale. 0 turns the warning off.
+func WithStaleThreshold(d time.Duration) BotOption {
+	return func(b *Bot) error {
+		if d < 0 {
+			return fmt.Errorf("the stale threshold must not be negative, is %s", d)
+		}
+		b.staleAfter = d
+		return nil
+	}
+}
+
+// isStale tells if something last updated at updatedAt is older than the threshold by now.
+// Unknown update times and a threshold of 0 are never stale.
+func isStale(updatedAt time.Time, threshold time.Duration, now time.Time) bool {
+	return !updatedAt.IsZero() && threshold > 0 && now.Sub(updatedAt) > threshold
+}
+
+// alertStaleFor returns how long Alertmanager hasn't updated a firing alert and if that makes it stale.
+func alertStaleFor(a *types.Alert, threshold time.Duration, now time.Time) (time.Duration, bool) {
+	if a.Resolved() {
+		return 0, false
+	}
+	return now.Sub(a.UpdatedAt), isStale(a.UpdatedAt, threshold, now)
+}
+
+// formatStaleFor rounds down to whole hours, or minutes below an hour, like 3h.
+func formatStaleFor(d time.Duration) string {
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// alertsUpdatedAt maps the fingerprints of the alerts to when Alertmanager last updated them.
+func alertsUpdatedAt(alerts []*types.Alert) map[string]time.Time {
+	updated := make(map[string]time.Time, len(alerts))
+	for _, a := range alerts {
+		updated[a.Fingerprint().String()] = a.UpdatedAt
+	}
+	return updated
+}
+
+// staleSummary is the line above /alerts counting the stale ones, "" if none is.
+func (b *Bot) staleSummary(alerts []*types.Alert, now time.Time) string {
+	var stale int
+	for _, a := range alerts {
+		if _, ok := alertStaleFor(a, b.staleAfter, now); ok {
+			stale++
+		}
+	}
+	if stale == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<b>%d alerts</b>, ⚠️ %d of them not updated for over %s\n", len(alerts), stale, formatStaleFor(b.staleAfter))
+}
@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager/alertmanagertest"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestAlertStaleFor(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	alert := func(updatedAt time.Time, endsAt time.Time) *types.Alert {
+		return &types.Alert{Alert: model.Alert{Labels: model.LabelSet{"alertname": "DiskFull"}, EndsAt: endsAt}, UpdatedAt: updatedAt}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		alert     *types.Alert
+		threshold time.Duration
+		stale     bool
+	}{
+		{name: "just updated", alert: alert(now, time.Time{}), threshold: time.Hour},
+		{name: "exactly at the threshold", alert: alert(now.Add(-time.Hour), time.Time{}), threshold: time.Hour},
+		{name: "just over the threshold", alert: alert(now.Add(-time.Hour-time.Second), time.Time{}), threshold: time.Hour, stale: true},
+		{name: "never updated", alert: alert(time.Time{}, time.Time{}), threshold: time.Hour},
+		{name: "turned off", alert: alert(now.Add(-48*time.Hour), time.Time{}), threshold: 0},
+		{name: "resolved", alert: alert(now.Add(-48*time.Hour), now.Add(-47*time.Hour)), threshold: time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, stale := alertStaleFor(tc.alert, tc.threshold, now)
+			require.Equal(t, tc.stale, stale)
+		})
+	}
+
+	d, _ := alertStaleFor(alert(now.Add(-3*time.Hour-20*time.Minute), time.Time{}), time.Hour, now)
+	require.Equal(t, "3h", formatStaleFor(d))
+	require.Equal(t, "45m", formatStaleFor(45*time.Minute+30*time.Second))
+}
+
+func TestAlertsStale(t *testing.T) {
+	now := time.Now()
+	am := &alertmanagertest.Alertmanager{Alerts: []*types.Alert{
+		{Alert: model.Alert{Labels: model.LabelSet{"alertname": "DiskFull"}, StartsAt: now.Add(-5 * time.Hour)}, UpdatedAt: now.Add(-3*time.Hour - time.Minute)},
+		{Alert: model.Alert{Labels: model.LabelSet{"alertname": "HighCPU"}, StartsAt: now.Add(-5 * time.Hour)}, UpdatedAt: now.Add(-time.Minute)},
+	}}
+	b, tb, chats := newTestBot(t, WithAlertmanager(am), WithStaleThreshold(2*time.Hour), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	out := tb.lastText()
+	require.True(t, strings.HasPrefix(out, "<b>2 alerts</b>, ⚠️ 1 of them not updated for over 2h\n"), out)
+	require.Equal(t, 1, strings.Count(out, "⚠️ not updated for 3h — data may be stale"))
+	require.Less(t, strings.Index(out, "DiskFull"), strings.Index(out, "data may be stale"))
+	require.Less(t, strings.Index(out, "data may be stale"), strings.Index(out, "HighCPU"))
+
+	// Nothing stale, no summary.
+	am.Alerts = am.Alerts[1:]
+	require.NoError(t, b.handleAlerts(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.NotContains(t, tb.lastText(), "⚠️")
+}
@@ -0,0 +1,220 @@
+package telegram
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	defaultStartupAttempts = 1
+	defaultStartupBackoff  = 2 * time.Second
+	// maxStartupBackoff caps the doubling wait between startup attempts.
+	maxStartupBackoff = time.Minute
+)
+
+// startupFailure is the kind of problem reaching Telegram or the store when the bot starts.
+type startupFailure int
+
+const (
+	startupFailureOther startupFailure = iota
+	startupFailureInvalidToken
+	startupFailureUnreachable
+	startupFailureTLS
+	startupFailureStore
+)
+
+// classifyStartupError tells the kind of problem of an error reaching Telegram or the store.
+func classifyStartupError(err error) startupFailure {
+	var apiErr *telebot.APIError
+	if errors.As(err, &apiErr) && (apiErr.Code == 401 || apiErr.Code == 404) {
+		// Telegram answers tokens it doesn't know with 401 and malformed ones with 404.
+		return startupFailureInvalidToken
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		return startupFailureStore
+	}
+	// Before the network errors: url.Error is a net.Error, whatever it wraps.
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) || errors.As(err, &recordHeader) {
+		return startupFailureTLS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return startupFailureUnreachable
+	}
+	return startupFailureOther
+}
+
+// retry tells if trying again may help.
+func (f startupFailure) retry() bool {
+	return f != startupFailureInvalidToken && f != startupFailureTLS
+}
+
+// hint tells what to check about the failure.
+func (f startupFailure) hint() string {
+	switch f {
+	case startupFailureInvalidToken:
+		return "Telegram doesn't accept the token, check it with @BotFather"
+	case startupFailureUnreachable:
+		return "Telegram can't be reached, check the network, DNS and any HTTPS_PROXY"
+	case startupFailureTLS:
+		return "the TLS connection to Telegram failed, check the CA certificates and any proxy intercepting TLS"
+	case startupFailureStore:
+		return "the store can't be reached, check that it's running and its address"
+	}
+	return ""
+}
+
+// StartupError is returned by NewBotContext when Telegram or the store couldn't be reached.
+type StartupError struct {
+	// What couldn't be reached.
+	What string
+	// Errs are the errors of the attempts, the last one may be the context's.
+	Errs []error
+	// token is redacted from the errors, requests to Telegram have it in their URL.
+	token string
+}
+
+func (e *StartupError) Error() string {
+	tried := e.Errs
+	var b strings.Builder
+	if last := tried[len(tried)-1]; errors.Is(last, context.Canceled) || errors.Is(last, context.DeadlineExceeded) {
+		fmt.Fprintf(&b, "stopped trying to reach %s", e.What)
+		if len(tried) == 1 {
+			return b.String() + ": " + last.Error()
+		}
+		tried = tried[:len(tried)-1]
+	} else {
+		fmt.Fprintf(&b, "failed to reach %s", e.What)
+	}
+	if len(tried) > 1 {
+		fmt.Fprintf(&b, " after %d attempts", len(tried))
+	}
+	for _, err := range tried {
+		if hint := classifyStartupError(err).hint(); hint != "" {
+			b.WriteString(", " + hint)
+			break
+		}
+	}
+	if len(tried) == 1 {
+		return b.String() + ": " + e.redact(tried[0])
+	}
+	b.WriteString(":")
+	for i, err := range tried {
+		fmt.Fprintf(&b, "\n  - attempt %d: %s", i+1, e.redact(err))
+	}
+	return b.String()
+}
+
+// Unwrap lets errors.Is and errors.As find the last error, like telebot.ErrUnauthorized.
+func (e *StartupError) Unwrap() error {
+	return e.Errs[len(e.Errs)-1]
+}
+
+func (e *StartupError) redact(err error) string {
+	if e.token == "" {
+		return err.Error()
+	}
+	return strings.ReplaceAll(err.Error(), e.token, "<token>")
+}
+
+// WithStartupRetry tries up to attempts times to reach Telegram and the store when the bot is created,
+// waiting backoff before the second attempt and twice as long before each further one, up to a minute.
+// An invalid token and TLS failures aren't tried again.
+func WithStartupRetry(attempts int, backoff time.Duration) BotOption {
+	return func(b *Bot) error {
+		if attempts <= 0 {
+			return fmt.Errorf("the startup attempts must be positive, are %d", attempts)
+		}
+		if backoff <= 0 {
+			return fmt.Errorf("the startup backoff must be positive, is %s", backoff)
+		}
+		b.startupAttempts = attempts
+		b.startupBackoff = backoff
+		return nil
+	}
+}
+
+// retryStartup calls attempt until it succeeds, fails in a way trying again doesn't help, the attempts are
+// used up or ctx is done. An attempt still running when ctx is done is left behind.
+func (b *Bot) retryStartup(ctx context.Context, what string, token string, attempt func() error) error {
+	failed := &StartupError{What: what, token: token}
+	backoff := b.startupBackoff
+	for i := 1; ; i++ {
+		done := make(chan error, 1)
+		go func() { done <- attempt() }()
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err == nil {
+			return nil
+		}
+		failed.Errs = append(failed.Errs, err)
+		if ctx.Err() != nil {
+			return failed
+		}
+
+		failure := classifyStartupError(err)
+		if !failure.retry() || i >= b.startupAttempts {
+			return failed
+		}
+		level.Warn(b.logger).Log("msg", "failed to reach "+what+", trying again", "attempt", i, "backoff", backoff, "err", failed.redact(err), "hint", failure.hint())
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			failed.Errs = append(failed.Errs, ctx.Err())
+			return failed
+		}
+		if backoff *= 2; backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
+
+// checkStoreOnStart lists the chats to check that the store can be reached. Records that fail to decode
+// don't keep the bot from starting, the store answered.
+func (b *Bot) checkStoreOnStart() error {
+	if _, err := b.chats.List(); err != nil && errors.Is(err, ErrStoreUnavailable) {
+		return err
+	}
+	return nil
+}
+
+// connect creates the Telebot of the token with dial and checks the store, each as WithStartupRetry allows.
+func (b *Bot) connect(ctx context.Context, token string, dial func(token string) (Telebot, error)) (Telebot, error) {
+	if b.chats != nil {
+		if err := b.retryStartup(ctx, "the store", "", b.checkStoreOnStart); err != nil {
+			return nil, err
+		}
+	}
+	var bot Telebot
+	err := b.retryStartup(ctx, "Telegram", token, func() error {
+		var err error
+		bot, err = dial(token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bot, nil
+}
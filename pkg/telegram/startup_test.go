@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// requestError is how telebot fails a request to Telegram with the token.
+func requestError(err error) error {
+	return pkgerrors.Wrap(&url.Error{Op: "Post", URL: "https://api.telegram.org/bot123:secret/getMe", Err: err}, "telebot")
+}
+
+func TestClassifyStartupError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		err     error
+		failure startupFailure
+	}{
+		{name: "unauthorized", err: telebot.ErrUnauthorized, failure: startupFailureInvalidToken},
+		{name: "malformed token", err: telebot.ErrNotFound, failure: startupFailureInvalidToken},
+		{name: "dns", err: requestError(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "api.telegram.org"}}), failure: startupFailureUnreachable},
+		{name: "connection refused", err: requestError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}), failure: startupFailureUnreachable},
+		{name: "unknown authority", err: requestError(x509.UnknownAuthorityError{}), failure: startupFailureTLS},
+		{name: "hostname", err: requestError(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "api.telegram.org"}), failure: startupFailureTLS},
+		{name: "not tls", err: requestError(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), failure: startupFailureTLS},
+		{name: "store", err: &storeError{sentinel: ErrStoreUnavailable, err: errors.New("dial tcp: i/o timeout")}, failure: startupFailureStore},
+		{name: "other", err: telebot.NewAPIError(502, "Bad Gateway"), failure: startupFailureOther},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failure := classifyStartupError(tc.err)
+			require.Equal(t, tc.failure, failure)
+			require.Equal(t, tc.failure != startupFailureInvalidToken && tc.failure != startupFailureTLS, failure.retry())
+		})
+	}
+}
+
+// flakyDial returns a dial failing the first failures times with err.
+func flakyDial(failures int, err error) (dial func(token string, updates *telebot.Webhook) (Telebot, error), calls func() int) {
+	var mu sync.Mutex
+	n := 0
+	dial = func(token string, updates *telebot.Webhook) (Telebot, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		if n <= failures {
+			return nil, err
+		}
+		return &fakeTelebot{me: &telebot.User{ID: 42, Username: "alerts_bot"}}, nil
+	}
+	return dial, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+func TestNewBotStartupRetry(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	unreachable := requestError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: network is unreachable")})
+
+	dial, calls := flakyDial(2, unreachable)
+	b, err := newBotContext(context.Background(), chats, "123:secret", testAdmin.ID, dial, WithStartupRetry(3, time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 3, calls())
+	require.Equal(t, "alerts_bot", b.telegram.Me().Username)
+
+	dial, calls = flakyDial(5, unreachable)
+	_, err = newBotContext(context.Background(), chats, "123:secret", testAdmin.ID, dial, WithStartupRetry(3, time.Millisecond))
+	require.Equal(t, 3, calls())
+	var startupErr *StartupError
+	require.True(t, errors.As(err, &startupErr))
+	require.Len(t, startupErr.Errs, 3)
+	require.Contains(t, err.Error(), "failed to reach Telegram after 3 attempts, Telegram can't be reached, check the network, DNS and any HTTPS_PROXY:\n  - attempt 1: ")
+	require.NotContains(t, err.Error(), "secret", "the token is redacted")
+
+	dial, calls = flakyDial(5, telebot.ErrUnauthorized)
+	_, err = newBotContext(context.Background(), chats, "123:secret", testAdmin.ID, dial, WithStartupRetry(3, time.Millisecond))
+	require.Equal(t, 1, calls(), "an invalid token isn't tried again")
+	require.True(t, errors.Is(err, telebot.ErrUnauthorized))
+	require.EqualError(t, err, "failed to reach Telegram, Telegram doesn't accept the token, check it with @BotFather: telegram: Unauthorized (401)")
+}
+
+func TestNewBotStartupCanceled(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	dial, calls := flakyDial(5, requestError(&net.DNSError{Err: "no such host", Name: "api.telegram.org"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = newBotContext(ctx, chats, "123:secret", testAdmin.ID, dial, WithStartupRetry(10, time.Hour))
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Less(t, int64(time.Since(start)), int64(time.Minute))
+	require.Equal(t, 1, calls())
+	require.Equal(t, "stopped trying to reach Telegram, Telegram can't be reached, check the network, DNS and any HTTPS_PROXY: "+
+		"telebot: Post \"https://api.telegram.org/bot<token>/getMe\": lookup api.telegram.org: no such host", err.Error())
+}
+
+func TestNewBotStoreUnavailable(t *testing.T) {
+	chats, err := NewChatStore(&failingKV{memoryKV: newMemoryKV(), err: errors.New("dial tcp 10.0.0.1:2379: connect: connection refused")}, telegramChatsDirectory)
+	require.NoError(t, err)
+	dial, calls := flakyDial(0, nil)
+
+	_, err = newBotContext(context.Background(), chats, "123:secret", testAdmin.ID, dial, WithStartupRetry(2, time.Millisecond))
+	require.True(t, errors.Is(err, ErrStoreUnavailable))
+	require.Contains(t, err.Error(), "failed to reach the store after 2 attempts, the store can't be reached")
+	require.Equal(t, 0, calls(), "Telegram isn't tried without the store")
+}
+
+func TestWithStartupRetryValidation(t *testing.T) {
+	_, err := NewBotWithTelegram(nil, nil, testAdmin.ID, WithStartupRetry(0, time.Second))
+	require.EqualError(t, err, "invalid options: the startup attempts must be positive, are 0")
+	_, err = NewBotWithTelegram(nil, nil, testAdmin.ID, WithStartupRetry(1, 0))
+	require.EqualError(t, err, "invalid options: the startup backoff must be positive, is 0s")
+}
@@ -0,0 +1,651 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// The areas of the store records are added to all the time, which WithStoreQuota can bound.
+	AreaMessages     = "messages"
+	AreaFingerprints = "fingerprints"
+	AreaOutbox       = "outbox"
+
+	// telegramFingerprintSegmentsDirectory keeps the compacted fingerprint records, many per key,
+	// by the first characters of their fingerprints.
+	telegramFingerprintSegmentsDirectory = "telegram/fingerprint_segments"
+	// fingerprintSegmentPrefix is how many characters of a fingerprint pick its segment, at most 256 segments
+	// for the hexadecimal fingerprints of Alertmanager.
+	fingerprintSegmentPrefix = 2
+
+	// storeAreasInterval is how often the quotas of the areas are enforced and their sizes measured.
+	storeAreasInterval = 10 * time.Minute
+)
+
+// storeAreaNames are the areas in the order they're checked and listed.
+var storeAreaNames = []string{AreaMessages, AreaFingerprints, AreaOutbox}
+
+// AreaQuota bounds an area of the store, 0 doesn't bound it.
+type AreaQuota struct {
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+// AreaStats is how big an area of the store is.
+type AreaStats struct {
+	Area string `json:"area"`
+	// Entries are the records that could be read.
+	Entries int `json:"entries"`
+	// Keys are the keys of the area, fewer than the entries once it's compacted.
+	Keys int `json:"keys"`
+	// Corrupt are the keys of the records that can't be read, they're skipped.
+	Corrupt []string `json:"corrupt,omitempty"`
+	// Removed are the entries removed by the quota.
+	Removed int `json:"removed,omitempty"`
+}
+
+// areaEntry is a record of an area, by when it was written, and all the keys it's stored at.
+type areaEntry struct {
+	id   string
+	at   time.Time
+	keys []string
+}
+
+// listRecords calls record with each pair below the directory. The pairs record fails to decode are skipped,
+// their keys are returned, so that a single corrupt record doesn't keep the others from being read.
+func (s *ChatStore) listRecords(directory string, record func(kv *store.KVPair) error) (corrupt []string, err error) {
+	kvPairs, err := s.list(directory, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvPairs {
+		if err := record(kv); err != nil {
+			var c *ErrCorruptRecord
+			if !errors.As(err, &c) {
+				return corrupt, err
+			}
+			corrupt = append(corrupt, kv.Key)
+		}
+	}
+	return corrupt, nil
+}
+
+// fingerprintSegmentKey is the key of the segment the fingerprint is compacted into.
+func fingerprintSegmentKey(fingerprint string) string {
+	prefix := fingerprint
+	if len(prefix) > fingerprintSegmentPrefix {
+		prefix = prefix[:fingerprintSegmentPrefix]
+	}
+	return telegramFingerprintSegmentsDirectory + "/" + prefix
+}
+
+// fingerprintSegment returns the records compacted into the segment, by fingerprint.
+func (s *ChatStore) fingerprintSegment(key string) (map[string]FingerprintRecord, error) {
+	kv, err := s.get(key, errKeyMissing)
+	if errors.Is(err, errKeyMissing) {
+		return map[string]FingerprintRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []FingerprintRecord
+	if err := decode(key, kv.Value, &records); err != nil {
+		return nil, err
+	}
+	segment := make(map[string]FingerprintRecord, len(records))
+	for _, r := range records {
+		segment[r.Fingerprint] = r
+	}
+	return segment, nil
+}
+
+// putFingerprintSegment writes the records of a segment, deleting it once it's empty.
+func (s *ChatStore) putFingerprintSegment(key string, segment map[string]FingerprintRecord) error {
+	if len(segment) == 0 {
+		return s.delete(key)
+	}
+	records := make([]FingerprintRecord, 0, len(segment))
+	for _, r := range segment {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Fingerprint < records[j].Fingerprint })
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return s.put(key, value)
+}
+
+// fingerprintArea is all the fingerprint records: those written since the last compaction at a key each,
+// the others in segments by their segment's key.
+type fingerprintArea struct {
+	loose    map[string]FingerprintRecord
+	segments map[string]map[string]FingerprintRecord
+	corrupt  []string
+}
+
+func (s *ChatStore) scanFingerprints() (*fingerprintArea, error) {
+	a := &fingerprintArea{loose: map[string]FingerprintRecord{}, segments: map[string]map[string]FingerprintRecord{}}
+	corrupt, err := s.listRecords(telegramFingerprintsDirectory, func(kv *store.KVPair) error {
+		var r FingerprintRecord
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return err
+		}
+		a.loose[r.Fingerprint] = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.corrupt = corrupt
+	corrupt, err = s.listRecords(telegramFingerprintSegmentsDirectory, func(kv *store.KVPair) error {
+		var records []FingerprintRecord
+		if err := decode(kv.Key, kv.Value, &records); err != nil {
+			return err
+		}
+		segment := make(map[string]FingerprintRecord, len(records))
+		for _, r := range records {
+			segment[r.Fingerprint] = r
+		}
+		a.segments[kv.Key] = segment
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.corrupt = append(a.corrupt, corrupt...)
+	return a, nil
+}
+
+// entries returns a record per fingerprint, the loose one if it's stored twice, it's the newer one.
+func (a *fingerprintArea) entries() []areaEntry {
+	byID := map[string]*areaEntry{}
+	for key, segment := range a.segments {
+		for fp, r := range segment {
+			byID[fp] = &areaEntry{id: fp, at: r.LastNotified, keys: []string{key}}
+		}
+	}
+	for fp, r := range a.loose {
+		e, ok := byID[fp]
+		if !ok {
+			e = &areaEntry{id: fp}
+			byID[fp] = e
+		}
+		e.at = r.LastNotified
+		e.keys = append(e.keys, fingerprintKey(fp))
+	}
+	entries := make([]areaEntry, 0, len(byID))
+	for _, e := range byID {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// messageArea is all the message records: those of the batches of the chats, those an earlier version stored
+// at a key each and those still buffered, the newest of them by chat and message ID.
+type messageArea struct {
+	records map[int64]map[int]MessageRecord
+	// keys are the keys each record is stored at, none for records that are only buffered.
+	keys    map[int64]map[int][]string
+	corrupt []string
+	// stored is how many keys the records are stored at.
+	stored int
+}
+
+func (s *ChatStore) scanMessages() (*messageArea, error) {
+	a := &messageArea{records: map[int64]map[int]MessageRecord{}, keys: map[int64]map[int][]string{}}
+	add := func(key string, r MessageRecord, newer bool) {
+		if a.records[r.ChatID] == nil {
+			a.records[r.ChatID] = map[int]MessageRecord{}
+			a.keys[r.ChatID] = map[int][]string{}
+		}
+		if _, ok := a.records[r.ChatID][r.MessageID]; newer || !ok {
+			a.records[r.ChatID][r.MessageID] = r
+		}
+		a.keys[r.ChatID][r.MessageID] = append(a.keys[r.ChatID][r.MessageID], key)
+	}
+	corrupt, err := s.listRecords(telegramMessageBatchesDirectory, func(kv *store.KVPair) error {
+		var records []MessageRecord
+		if err := decode(kv.Key, kv.Value, &records); err != nil {
+			return err
+		}
+		for _, r := range records {
+			add(kv.Key, r, true)
+		}
+		a.stored++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.corrupt = corrupt
+	// The records stored at a key each are older than those of the batches.
+	corrupt, err = s.listRecords(telegramMessagesDirectory, func(kv *store.KVPair) error {
+		var r MessageRecord
+		if err := decode(kv.Key, kv.Value, &r); err != nil {
+			return err
+		}
+		add(kv.Key, r, false)
+		a.stored++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.corrupt = append(a.corrupt, corrupt...)
+	if s.writes != nil {
+		s.writes.applyTo(a.records)
+	}
+	return a, nil
+}
+
+// entries returns an entry per record, by its chat and message ID.
+func (a *messageArea) entries() []areaEntry {
+	var entries []areaEntry
+	for chatID, chat := range a.records {
+		for messageID, r := range chat {
+			entries = append(entries, areaEntry{id: fmt.Sprintf("%d/%d", chatID, messageID), at: r.SentAt, keys: a.keys[chatID][messageID]})
+		}
+	}
+	return entries
+}
+
+// scanArea returns the entries of the area, the keys of its corrupt records and how many keys it has.
+func (s *ChatStore) scanArea(area string) ([]areaEntry, []string, int, error) {
+	var (
+		entries   []areaEntry
+		directory string
+		at        func(key string, value []byte) (time.Time, error)
+	)
+	switch area {
+	case AreaFingerprints:
+		a, err := s.scanFingerprints()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return a.entries(), a.corrupt, len(a.loose) + len(a.segments) + len(a.corrupt), nil
+	case AreaMessages:
+		a, err := s.scanMessages()
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return a.entries(), a.corrupt, a.stored + len(a.corrupt), nil
+	case AreaOutbox:
+		directory = telegramOutboxDirectory
+		at = func(key string, value []byte) (time.Time, error) {
+			var e OutboxEntry
+			err := decode(key, value, &e)
+			return e.ReceivedAt, err
+		}
+	default:
+		return nil, nil, 0, fmt.Errorf("unknown store area %q, the areas are %s", area, strings.Join(storeAreaNames, ", "))
+	}
+	corrupt, err := s.listRecords(directory, func(kv *store.KVPair) error {
+		t, err := at(kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, areaEntry{id: kv.Key, at: t, keys: []string{kv.Key}})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return entries, corrupt, len(entries) + len(corrupt), nil
+}
+
+// goneKeys returns how many of the keys the removed entries are stored at none of the kept ones is.
+func goneKeys(removed []areaEntry, kept []areaEntry) int {
+	keeping := map[string]bool{}
+	for _, e := range kept {
+		for _, key := range e.keys {
+			keeping[key] = true
+		}
+	}
+	gone := map[string]bool{}
+	for _, e := range removed {
+		for _, key := range e.keys {
+			if !keeping[key] {
+				gone[key] = true
+			}
+		}
+	}
+	return len(gone)
+}
+
+// removeEntries removes the entries from all the keys they're stored at and returns how many keys are gone.
+// Keys of messages and outbox entries are gone once none of the kept entries is stored at them.
+func (s *ChatStore) removeEntries(area string, entries []areaEntry, kept []areaEntry) (int, error) {
+	switch area {
+	case AreaMessages:
+		for i, e := range entries {
+			var chatID int64
+			var messageID int
+			if _, err := fmt.Sscanf(e.id, "%d/%d", &chatID, &messageID); err != nil {
+				return goneKeys(entries[:i], kept), err
+			}
+			if err := s.RemoveMessage(chatID, messageID); err != nil {
+				return goneKeys(entries[:i], kept), err
+			}
+		}
+		return goneKeys(entries, kept), nil
+	case AreaOutbox:
+		for i, e := range entries {
+			// Like RemoveOutbox, behind the writes of the records.
+			if err := s.writeBehind(e.keys[0], nil); err != nil {
+				return i, err
+			}
+		}
+		return len(entries), nil
+	}
+
+	segments := map[string][]string{}
+	removed := 0
+	for _, e := range entries {
+		for _, key := range e.keys {
+			if key != fingerprintKey(e.id) {
+				segments[key] = append(segments[key], e.id)
+				continue
+			}
+			if err := s.delete(key); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	for key, fps := range segments {
+		segment, err := s.fingerprintSegment(key)
+		if err != nil {
+			return removed, err
+		}
+		for _, fp := range fps {
+			delete(segment, fp)
+		}
+		if err := s.putFingerprintSegment(key, segment); err != nil {
+			return removed, err
+		}
+		if len(segment) == 0 {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// CheckArea returns how big the area is and which of its records can't be read.
+func (s *ChatStore) CheckArea(area string) (AreaStats, error) {
+	entries, corrupt, keys, err := s.scanArea(area)
+	if err != nil {
+		return AreaStats{Area: area}, err
+	}
+	return AreaStats{Area: area, Entries: len(entries), Keys: keys, Corrupt: corrupt}, nil
+}
+
+// EnforceQuota removes the entries of the area older than the quota's maximum age and then the oldest ones
+// beyond its maximum number of entries. Corrupt records are left for /store_check to tell about.
+func (s *ChatStore) EnforceQuota(area string, q AreaQuota, now time.Time) (AreaStats, error) {
+	if area == AreaFingerprints {
+		s.fingerprintsMu.Lock()
+		defer s.fingerprintsMu.Unlock()
+	}
+	entries, corrupt, keys, err := s.scanArea(area)
+	if err != nil {
+		return AreaStats{Area: area}, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+
+	expired := 0
+	if q.MaxAge > 0 {
+		for expired < len(entries) && now.Sub(entries[expired].at) > q.MaxAge {
+			expired++
+		}
+	}
+	if q.MaxEntries > 0 && len(entries)-expired > q.MaxEntries {
+		expired = len(entries) - q.MaxEntries
+	}
+
+	stats := AreaStats{Area: area, Entries: len(entries), Keys: keys, Corrupt: corrupt}
+	removedKeys, err := s.removeEntries(area, entries[:expired], entries[expired:])
+	stats.Keys -= removedKeys
+	if err != nil {
+		return stats, err
+	}
+	stats.Entries -= expired
+	stats.Removed = expired
+	return stats, nil
+}
+
+// CompactArea moves the records of the area written one key each into fewer keys holding many, for backends
+// where thousands of small keys are expensive. Only the fingerprints can be compacted: the records of messages
+// are batched by chat as they're written, outbox entries are looked up and removed one by one, so they keep a key each.
+func (s *ChatStore) CompactArea(area string) (AreaStats, error) {
+	if area != AreaFingerprints {
+		return AreaStats{Area: area}, fmt.Errorf("the %s area can't be compacted", area)
+	}
+	s.fingerprintsMu.Lock()
+	defer s.fingerprintsMu.Unlock()
+
+	a, err := s.scanFingerprints()
+	if err != nil {
+		return AreaStats{Area: area}, err
+	}
+	// The segments are written before the loose records are deleted, a failure in between leaves records
+	// twice, which reads the same as once.
+	changed := map[string]bool{}
+	for fp, r := range a.loose {
+		key := fingerprintSegmentKey(fp)
+		if a.segments[key] == nil {
+			a.segments[key] = map[string]FingerprintRecord{}
+		}
+		a.segments[key][fp] = r
+		changed[key] = true
+	}
+	for key := range changed {
+		if err := s.putFingerprintSegment(key, a.segments[key]); err != nil {
+			return AreaStats{Area: area}, err
+		}
+	}
+	for fp := range a.loose {
+		if err := s.delete(fingerprintKey(fp)); err != nil {
+			return AreaStats{Area: area}, err
+		}
+	}
+	return AreaStats{Area: area, Entries: len(a.entries()), Keys: len(a.segments) + len(a.corrupt), Corrupt: a.corrupt}, nil
+}
+
+// WithStoreQuota bounds an area of the store, messages, fingerprints or outbox, to maxEntries records and
+// removes those older than maxAge, 0 doesn't bound it by that. The quota is enforced every 10 minutes and
+// when the records written since exceed it by a tenth.
+func WithStoreQuota(area string, maxEntries int, maxAge time.Duration) BotOption {
+	return func(b *Bot) error {
+		known := false
+		for _, name := range storeAreaNames {
+			known = known || name == area
+		}
+		if !known {
+			return fmt.Errorf("unknown store area %q, the areas are %s", area, strings.Join(storeAreaNames, ", "))
+		}
+		if maxEntries < 0 {
+			return fmt.Errorf("the maximum entries of the %s area must not be negative, are %d", area, maxEntries)
+		}
+		if maxAge < 0 {
+			return fmt.Errorf("the maximum age of the %s area must not be negative, is %s", area, maxAge)
+		}
+		if b.storeQuotas == nil {
+			b.storeQuotas = map[string]AreaQuota{}
+		}
+		b.storeQuotas[area] = AreaQuota{MaxEntries: maxEntries, MaxAge: maxAge}
+		return nil
+	}
+}
+
+// WithStoreCompaction compacts the areas that can be compacted once they have more than keys keys,
+// 0 doesn't compact them, which is the default.
+func WithStoreCompaction(keys int) BotOption {
+	return func(b *Bot) error {
+		if keys < 0 {
+			return fmt.Errorf("the keys to compact the store areas above must not be negative, are %d", keys)
+		}
+		b.storeCompactAbove = keys
+		return nil
+	}
+}
+
+// areaCounts are about how many entries the areas with a quota of entries have, to enforce it on write
+// without listing the area every time. Writes replacing records count too, so the counts are rather too high.
+type areaCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newAreaCounts() *areaCounts {
+	return &areaCounts{counts: map[string]int{}}
+}
+
+// add counts n writes to the area and tells if its quota should be enforced: when it's exceeded by a tenth
+// or the area wasn't measured yet. Then the count starts over, so that concurrent writes don't enforce it too.
+func (c *areaCounts) add(area string, n int, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, measured := c.counts[area]
+	count += n
+	if !measured || count > max+max/10 {
+		c.counts[area] = 0
+		return true
+	}
+	c.counts[area] = count
+	return false
+}
+
+func (c *areaCounts) set(area string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[area] = n
+}
+
+// observeArea updates the gauges and counts of the area.
+func (b *Bot) observeArea(stats AreaStats) {
+	b.storeAreaEntries.WithLabelValues(stats.Area).Set(float64(stats.Entries))
+	b.storeAreaCorrupt.WithLabelValues(stats.Area).Set(float64(len(stats.Corrupt)))
+	b.areaCounts.set(stats.Area, stats.Entries)
+}
+
+// enforceQuota enforces the quota of the area, if it has one.
+func (b *Bot) enforceQuota(area string, now time.Time) (AreaStats, error) {
+	q, ok := b.storeQuotas[area]
+	if !ok {
+		return b.chats.CheckArea(area)
+	}
+	stats, err := b.chats.EnforceQuota(area, q, now)
+	if stats.Removed > 0 {
+		level.Info(b.logger).Log("msg", "removed records beyond the quota of the store area", "area", area, "removed", stats.Removed, "entries", stats.Entries)
+	}
+	return stats, err
+}
+
+// wroteArea counts the records written to the area and enforces its quota once it's exceeded.
+func (b *Bot) wroteArea(area string, n int) {
+	q, ok := b.storeQuotas[area]
+	if !ok || q.MaxEntries <= 0 || !b.areaCounts.add(area, n, q.MaxEntries) {
+		return
+	}
+	stats, err := b.enforceQuota(area, time.Now())
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to enforce the quota of the store area", "area", area, "err", err)
+		return
+	}
+	b.observeArea(stats)
+}
+
+// checkStoreAreas enforces the quotas of the areas, compacts them if enabled and measures them.
+func (b *Bot) checkStoreAreas(now time.Time) {
+	for _, area := range storeAreaNames {
+		stats, err := b.enforceQuota(area, now)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "failed to check the store area", "area", area, "err", err)
+			continue
+		}
+		if b.storeCompactAbove > 0 && area == AreaFingerprints && stats.Keys > b.storeCompactAbove {
+			before := stats.Keys
+			if stats, err = b.chats.CompactArea(area); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to compact the store area", "area", area, "err", err)
+				continue
+			}
+			level.Info(b.logger).Log("msg", "compacted the store area", "area", area, "keys_before", before, "keys", stats.Keys)
+		}
+		if len(stats.Corrupt) > 0 {
+			level.Warn(b.logger).Log("msg", "skipped records of the store area that can't be read, "+CommandStoreCheck+" lists them", "area", area, "corrupt", len(stats.Corrupt))
+		}
+		b.observeArea(stats)
+	}
+}
+
+// runStoreAreas checks the store areas every storeAreasInterval until the context is done.
+func (b *Bot) runStoreAreas(ctx context.Context) {
+	ticker := time.NewTicker(storeAreasInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if b.leading() {
+				b.checkStoreAreas(now)
+			}
+		}
+	}
+}
+
+// formatAreaProblems tells about the records of the areas that can't be read, "" if there are none.
+func formatAreaProblems(stats []AreaStats) string {
+	var out string
+	for _, s := range stats {
+		if len(s.Corrupt) == 0 {
+			continue
+		}
+		if len(s.Corrupt) == 1 {
+			out += fmt.Sprintf("The %s area has a record that can't be read, it's skipped: %s\n", s.Area, s.Corrupt[0])
+			continue
+		}
+		out += fmt.Sprintf("The %s area has %d records that can't be read, they're skipped: %s\n", s.Area, len(s.Corrupt), strings.Join(s.Corrupt, ", "))
+	}
+	return out
+}
+
+// quotaChatStore counts the records written to the areas with a quota, to enforce it once it's exceeded.
+type quotaChatStore struct {
+	BotChatStore
+	bot *Bot
+}
+
+func (s quotaChatStore) AddMessage(r MessageRecord) error {
+	if err := s.BotChatStore.AddMessage(r); err != nil {
+		return err
+	}
+	s.bot.wroteArea(AreaMessages, 1)
+	return nil
+}
+
+func (s quotaChatStore) AddOutbox(e OutboxEntry) error {
+	if err := s.BotChatStore.AddOutbox(e); err != nil {
+		return err
+	}
+	s.bot.wroteArea(AreaOutbox, 1)
+	return nil
+}
+
+func (s quotaChatStore) RecordNotified(fingerprints []string, at time.Time) error {
+	if err := s.BotChatStore.RecordNotified(fingerprints, at); err != nil {
+		return err
+	}
+	s.bot.wroteArea(AreaFingerprints, len(fingerprints))
+	return nil
+}
@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var areasNow = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seedMessages stores records of n messages sent an hour apart, the last at areasNow, and corrupt records.
+func seedMessages(t *testing.T, chats *ChatStore, n int, corrupt ...int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: i, SentAt: areasNow.Add(time.Duration(i-n) * time.Hour)}))
+	}
+	for _, id := range corrupt {
+		require.NoError(t, chats.kv.Put(messageKey(testChat.ID, id), []byte("{not json"), nil))
+	}
+}
+
+func TestCorruptRecordsAreSkipped(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory, WithSyncWrites(true))
+	require.NoError(t, err)
+	seedMessages(t, chats, 3, 100)
+	require.NoError(t, chats.AddOutbox(OutboxEntry{ChatID: testChat.ID, Seq: 1, ReceivedAt: areasNow}))
+	require.NoError(t, chats.kv.Put(outboxKey(testChat.ID, 2), []byte("[]"), nil))
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	entries, err := chats.ListOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	stats, err := chats.CheckArea(AreaMessages)
+	require.NoError(t, err)
+	require.Equal(t, AreaStats{Area: AreaMessages, Entries: 3, Keys: 2, Corrupt: []string{messageKey(testChat.ID, 100)}}, stats, "the records are in the chat's batch")
+	stats, err = chats.CheckArea(AreaOutbox)
+	require.NoError(t, err)
+	require.Equal(t, []string{outboxKey(testChat.ID, 2)}, stats.Corrupt)
+
+	_, err = chats.CheckArea("audit")
+	require.Error(t, err)
+}
+
+func TestEnforceQuota(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory, WithSyncWrites(true))
+	require.NoError(t, err)
+	seedMessages(t, chats, 10, 100)
+
+	stats, err := chats.EnforceQuota(AreaMessages, AreaQuota{MaxAge: 6*time.Hour + time.Minute}, areasNow)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Removed, "messages 1 to 3 are older than 6h")
+	require.Equal(t, 7, stats.Entries)
+	require.Equal(t, 2, stats.Keys, "the batch and the corrupt record are kept")
+
+	stats, err = chats.EnforceQuota(AreaMessages, AreaQuota{MaxEntries: 4}, areasNow)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Removed)
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	var ids []int
+	for _, r := range records {
+		ids = append(ids, r.MessageID)
+	}
+	require.ElementsMatch(t, []int{7, 8, 9, 10}, ids, "the oldest are removed")
+
+	stats, err = chats.EnforceQuota(AreaMessages, AreaQuota{MaxEntries: 4}, areasNow)
+	require.NoError(t, err)
+	require.Zero(t, stats.Removed)
+}
+
+func TestCompactFingerprints(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	var fps []string
+	for i := 0; i < 40; i++ {
+		fps = append(fps, fmt.Sprintf("%02x%014x", i%4, i))
+	}
+	require.NoError(t, chats.RecordNotified(fps, areasNow.Add(-48*time.Hour)))
+	require.NoError(t, chats.kv.Put(fingerprintKey("ffcorrupt"), []byte("{not json"), nil))
+
+	stats, err := chats.CompactArea(AreaFingerprints)
+	require.NoError(t, err)
+	require.Equal(t, 40, stats.Entries)
+	require.Equal(t, 5, stats.Keys, "a segment for each of the 4 prefixes and the corrupt record")
+	require.Len(t, stats.Corrupt, 1)
+
+	records, err := chats.GetFingerprints(fps[:2])
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, 1, records[fps[0]].Notifications)
+
+	// Recorded again, the loose record is newer than the compacted one.
+	require.NoError(t, chats.RecordNotified(fps[:1], areasNow))
+	records, err = chats.GetFingerprints(fps[:1])
+	require.NoError(t, err)
+	require.Equal(t, 2, records[fps[0]].Notifications)
+	require.Equal(t, areasNow, records[fps[0]].LastNotified.UTC())
+
+	pruned, err := chats.PruneFingerprints(areasNow.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 39, pruned, "compacted records are pruned, too")
+	stats, err = chats.CheckArea(AreaFingerprints)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Entries)
+	records, err = chats.GetFingerprints(fps[:1])
+	require.NoError(t, err)
+	require.Equal(t, 2, records[fps[0]].Notifications)
+
+	stats, err = chats.EnforceQuota(AreaFingerprints, AreaQuota{MaxEntries: 0, MaxAge: time.Hour}, areasNow.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Removed)
+	records, err = chats.GetFingerprints(fps[:1])
+	require.NoError(t, err)
+	require.Empty(t, records, "neither the loose record nor the compacted one are left")
+
+	_, err = chats.CompactArea(AreaMessages)
+	require.Error(t, err)
+}
+
+func TestStoreQuotaOnWrite(t *testing.T) {
+	b, _, chats := newTestBot(t, WithStoreQuota(AreaMessages, 5, 0))
+	for i := 1; i <= 20; i++ {
+		require.NoError(t, b.chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: i, SentAt: areasNow.Add(time.Duration(i) * time.Minute)}))
+	}
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(records), 6)
+	require.Equal(t, 20, records[len(records)-1].MessageID, "the newest records are kept")
+
+	require.Error(t, WithStoreQuota("audit", 5, 0)(b))
+	require.Error(t, WithStoreQuota(AreaMessages, -1, 0)(b))
+}
+
+func TestCheckStoreAreas(t *testing.T) {
+	b, _, chats := newTestBot(t, WithStoreQuota(AreaFingerprints, 0, time.Hour), WithStoreCompaction(3))
+	var fps []string
+	for i := 0; i < 10; i++ {
+		fps = append(fps, fmt.Sprintf("%016x", i))
+	}
+	require.NoError(t, chats.RecordNotified(fps[:5], areasNow.Add(-2*time.Hour)))
+	require.NoError(t, chats.RecordNotified(fps[5:], areasNow))
+	seedMessages(t, chats, 2, 100)
+
+	b.checkStoreAreas(areasNow)
+	stats, err := chats.CheckArea(AreaFingerprints)
+	require.NoError(t, err)
+	require.Equal(t, 5, stats.Entries, "the fingerprints older than the quota are removed")
+	require.Equal(t, 1, stats.Keys, "the others are compacted")
+	require.Equal(t, 5.0, testutil.ToFloat64(b.storeAreaEntries.WithLabelValues(AreaFingerprints)))
+	require.Equal(t, 2.0, testutil.ToFloat64(b.storeAreaEntries.WithLabelValues(AreaMessages)))
+	require.Equal(t, 1.0, testutil.ToFloat64(b.storeAreaCorrupt.WithLabelValues(AreaMessages)))
+}
+
+func TestStoreCheckCorruptRecords(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	seedMessages(t, chats, 2, 100)
+
+	require.NoError(t, b.handleStoreCheck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStoreCheck}))
+	require.Equal(t, "The messages area has a record that can't be read, it's skipped: telegram/messages/123/100\n", tb.lastText())
+}
@@ -0,0 +1,223 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/docker/libkv/store"
+)
+
+var (
+	// ErrChatNotFound is returned by the store if a chat isn't found.
+	ErrChatNotFound = errors.New("chat not found in store")
+	// ErrMessageNotFound is returned by the store if a sent message isn't tracked.
+	ErrMessageNotFound = errors.New("message not found in store")
+	// ErrMessageStoreEmpty is returned when listing messages while none are tracked.
+	ErrMessageStoreEmpty = errors.New("no messages in store")
+	// ErrStoreUnavailable wraps errors of a store backend that can't be reached.
+	ErrStoreUnavailable = errors.New("store unavailable")
+)
+
+// ChatNotFoundErr returned by the store if a chat isn't found.
+//
+// Deprecated: use ErrChatNotFound.
+var ChatNotFoundErr = ErrChatNotFound
+
+// MessageNotFoundErr returned by the store if a sent message isn't tracked.
+//
+// Deprecated: use ErrMessageNotFound.
+var MessageNotFoundErr = ErrMessageNotFound
+
+// ErrCorruptRecord is returned when a stored record can't be decoded.
+type ErrCorruptRecord struct {
+	Key string
+	Err error
+}
+
+func (e *ErrCorruptRecord) Error() string {
+	return fmt.Sprintf("corrupt record %s in store: %v", e.Key, e.Err)
+}
+
+func (e *ErrCorruptRecord) Unwrap() error { return e.Err }
+
+// storeError keeps the message of a backend error while matching a sentinel with errors.Is.
+type storeError struct {
+	sentinel error
+	err      error
+}
+
+func (e *storeError) Error() string { return fmt.Sprintf("%v: %v", e.sentinel, e.err) }
+
+func (e *storeError) Is(target error) bool { return target == e.sentinel }
+
+func (e *storeError) Unwrap() error { return e.err }
+
+// unavailableMarkers are parts of backend error messages telling the store can't be reached.
+var unavailableMarkers = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"timeout",
+	"timed out",
+	"unavailable",
+	"no leader",
+	"unexpected response code: 5",
+	"database not open",
+}
+
+// translateStoreError turns a backend error into one of the typed store errors.
+// A missing key becomes notFound, which is returned as is when nil.
+func translateStoreError(err error, notFound error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.ToLower(err.Error())
+
+	// etcd reports missing keys as "100: Key not found (/key) [index]".
+	if errors.Is(err, store.ErrKeyNotFound) || strings.Contains(msg, "key not found") {
+		if notFound == nil {
+			return err
+		}
+		return notFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &storeError{sentinel: ErrStoreUnavailable, err: err}
+	}
+	for _, marker := range unavailableMarkers {
+		if strings.Contains(msg, marker) {
+			return &storeError{sentinel: ErrStoreUnavailable, err: err}
+		}
+	}
+	return err
+}
+
+func (s *ChatStore) get(key string, notFound error) (*store.KVPair, error) {
+	if err := s.flushFor(key); err != nil {
+		return nil, err
+	}
+	backend, release, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := backend.Get(key)
+	err = translateStoreError(err, notFound)
+	release(err)
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+func (s *ChatStore) put(key string, value []byte) error {
+	backend, release, err := s.backend()
+	if err != nil {
+		return err
+	}
+	err = translateStoreError(backend.Put(key, value, nil), nil)
+	release(err)
+	return err
+}
+
+// atomicPut writes a key unless it was written since previous was read from it, errKeyModified if it was.
+func (s *ChatStore) atomicPut(key string, value []byte, previous *store.KVPair) error {
+	backend, release, err := s.backend()
+	if err != nil {
+		return err
+	}
+	_, _, err = backend.AtomicPut(key, value, previous, nil)
+	if errors.Is(err, store.ErrKeyModified) || errors.Is(err, store.ErrKeyExists) {
+		release(nil)
+		return errKeyModified
+	}
+	err = translateStoreError(err, nil)
+	release(err)
+	return err
+}
+
+// atomicDelete removes a key unless it was written or removed since previous was read from it, errKeyModified if it was.
+func (s *ChatStore) atomicDelete(key string, previous *store.KVPair) error {
+	backend, release, err := s.backend()
+	if err != nil {
+		return err
+	}
+	_, err = backend.AtomicDelete(key, previous)
+	if errors.Is(err, store.ErrKeyModified) || errors.Is(err, store.ErrKeyNotFound) {
+		release(nil)
+		return errKeyModified
+	}
+	err = translateStoreError(err, nil)
+	release(err)
+	return err
+}
+
+// delete removes a key, a missing key is not an error.
+func (s *ChatStore) delete(key string) error {
+	backend, release, err := s.backend()
+	if err != nil {
+		return err
+	}
+	err = backend.Delete(key)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		err = nil
+	}
+	err = translateStoreError(err, nil)
+	release(err)
+	return err
+}
+
+// list returns the pairs below a directory. When there are none it returns empty,
+// which may be nil.
+func (s *ChatStore) list(directory string, empty error) ([]*store.KVPair, error) {
+	if err := s.flushFor(directory); err != nil {
+		return nil, err
+	}
+	backend, release, err := s.backend()
+	if err != nil {
+		return nil, err
+	}
+	kvPairs, err := backend.List(directory)
+	err = translateStoreError(err, errKeyMissing)
+	release(err)
+	if err != nil && err != errKeyMissing {
+		return nil, err
+	}
+	if len(kvPairs) == 0 {
+		return nil, empty
+	}
+	return kvPairs, nil
+}
+
+// errKeyMissing marks a missing key or a directory without keys.
+var errKeyMissing = errors.New("key missing")
+
+// errKeyModified marks a key written by someone else since it was read.
+var errKeyModified = errors.New("key modified")
+
+// decode unmarshals a stored record, returning ErrCorruptRecord if it can't.
+func decode(key string, value []byte, v interface{}) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return &ErrCorruptRecord{Key: key, Err: err}
+	}
+	return nil
+}
+
+// storeErrorReply is the reply to a command that failed with a store error.
+func (b *Bot) storeErrorReply(err error, action string) string {
+	var corrupt *ErrCorruptRecord
+	switch {
+	case errors.Is(err, ErrChatNotFound):
+		return b.responseText("store_not_subscribed", ResponseContext{})
+	case errors.Is(err, ErrStoreUnavailable):
+		return b.responseText("store_unavailable", ResponseContext{})
+	case errors.As(err, &corrupt):
+		return b.responseText("store_corrupt", ResponseContext{})
+	default:
+		return b.responseText("store_failed", ResponseContext{Action: action, Error: err.Error()})
+	}
+}
@@ -0,0 +1,124 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// failingKV is a memoryKV whose operations fail with err while it is set.
+type failingKV struct {
+	*memoryKV
+	err error
+}
+
+func (f *failingKV) Get(key string) (*store.KVPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.memoryKV.Get(key)
+}
+
+func (f *failingKV) Put(key string, value []byte, opts *store.WriteOptions) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.memoryKV.Put(key, value, opts)
+}
+
+func (f *failingKV) List(prefix string) ([]*store.KVPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.memoryKV.List(prefix)
+}
+
+func (f *failingKV) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	if f.err != nil {
+		return false, nil, f.err
+	}
+	return f.memoryKV.AtomicPut(key, value, previous, opts)
+}
+
+func TestTranslateStoreError(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  error
+		expected error
+	}{
+		{name: "libkv", backend: store.ErrKeyNotFound, expected: ErrChatNotFound},
+		{name: "etcd not found", backend: errors.New("100: Key not found (/telegram/chats/1) [42]"), expected: ErrChatNotFound},
+		{name: "consul unavailable", backend: errors.New("Unexpected response code: 500 (No cluster leader)"), expected: ErrStoreUnavailable},
+		{name: "etcd unavailable", backend: errors.New("client: etcd cluster is unavailable or misconfigured"), expected: ErrStoreUnavailable},
+		{name: "connection refused", backend: errors.New("dial tcp 127.0.0.1:8500: connect: connection refused"), expected: ErrStoreUnavailable},
+		{name: "bolt closed", backend: errors.New("database not open"), expected: ErrStoreUnavailable},
+		{name: "net error", backend: &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}, expected: ErrStoreUnavailable},
+		{name: "eof", backend: fmt.Errorf("reading response: %w", io.EOF), expected: ErrStoreUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translateStoreError(tt.backend, ErrChatNotFound)
+			require.True(t, errors.Is(err, tt.expected), "got %v", err)
+		})
+	}
+
+	other := errors.New("permission denied")
+	require.Equal(t, other, translateStoreError(other, ErrChatNotFound))
+	require.NoError(t, translateStoreError(nil, ErrChatNotFound))
+}
+
+func TestChatStoreTypedErrors(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.Equal(t, ErrChatNotFound, err)
+	require.Equal(t, ChatNotFoundErr, err)
+	_, err = chats.GetMessage(testChat.ID, 1)
+	require.Equal(t, ErrMessageNotFound, err)
+	_, err = chats.ListMessages()
+	require.Equal(t, ErrMessageStoreEmpty, err)
+	list, err := chats.List()
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	require.NoError(t, kv.Put(chatKey(testChat.ID), []byte("{not json"), nil))
+	_, err = chats.GetChatInfo(testChat.ID)
+	var corrupt *ErrCorruptRecord
+	require.True(t, errors.As(err, &corrupt))
+	require.Equal(t, chatKey(testChat.ID), corrupt.Key)
+
+	kv.err = errors.New("dial tcp 127.0.0.1:2379: connect: connection refused")
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.True(t, errors.Is(err, ErrStoreUnavailable))
+	require.Contains(t, err.Error(), "connection refused")
+	require.True(t, errors.Is(chats.AddChat(testChat, nil, nil), ErrStoreUnavailable))
+}
+
+func TestStoreErrorReplies(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "This chat isn't subscribed, use /start first.", tb.lastText())
+
+	kv.err = errors.New("Unexpected response code: 503")
+	require.NoError(t, b.handleFilters(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "I can't reach my store right now, try again in a bit.", tb.lastText())
+
+	// The webhook is dropped, the bot keeps running.
+	_, err = b.processWebhook(context.Background(), maintenanceWebhook("firing", "DiskFull"))
+	require.NoError(t, err)
+}
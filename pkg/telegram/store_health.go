@@ -0,0 +1,170 @@
+package telegram
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// defaultStoreFailureThreshold is how many calls in a row have to find the backend unavailable
+	// before the store counts as unhealthy.
+	defaultStoreFailureThreshold = 3
+	defaultStoreReconnectMin     = time.Second
+	defaultStoreReconnectMax     = time.Minute
+)
+
+// ChatStoreOption changes the defaults of a ChatStore.
+type ChatStoreOption func(s *ChatStore)
+
+// WithStoreFactory lets the ChatStore replace its backend client with a new one from factory
+// when the backend keeps being unavailable, like after a Consul restart.
+func WithStoreFactory(factory func() (store.Store, error)) ChatStoreOption {
+	return func(s *ChatStore) {
+		s.health.factory = factory
+	}
+}
+
+// WithStoreReconnectBackoff waits min before trying the backend again once it's unhealthy,
+// doubling with every failed attempt up to max.
+func WithStoreReconnectBackoff(min time.Duration, max time.Duration) ChatStoreOption {
+	return func(s *ChatStore) {
+		s.health.backoffMin = min
+		s.health.backoffMax = max
+		s.health.backoff = min
+	}
+}
+
+// WithStoreLogger sets the logger telling about the health of the backend.
+func WithStoreLogger(l log.Logger) ChatStoreOption {
+	return func(s *ChatStore) {
+		s.health.logger = l
+	}
+}
+
+// storeHealth tracks if the backend of a ChatStore is reachable.
+// Once it's unhealthy calls fail fast with ErrStoreUnavailable, until the backoff allows a call
+// through to probe the backend, for which the backend client is created anew if there's a factory.
+type storeHealth struct {
+	factory    func() (store.Store, error)
+	threshold  int
+	backoffMin time.Duration
+	backoffMax time.Duration
+	logger     log.Logger
+	now        func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	unhealthy bool
+	lastErr   error
+	backoff   time.Duration
+	nextProbe time.Time
+	probing   bool
+}
+
+func newStoreHealth() *storeHealth {
+	return &storeHealth{
+		threshold:  defaultStoreFailureThreshold,
+		backoffMin: defaultStoreReconnectMin,
+		backoffMax: defaultStoreReconnectMax,
+		backoff:    defaultStoreReconnectMin,
+		logger:     log.NewNopLogger(),
+		now:        time.Now,
+	}
+}
+
+// Healthy tells if the store's backend is reachable, as far as the last calls tell.
+func (s *ChatStore) Healthy() bool {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	return !s.health.unhealthy
+}
+
+// Close flushes the buffered writes and closes the store's backend client.
+func (s *ChatStore) Close() {
+	if err := s.Flush(); err != nil {
+		level.Warn(s.health.logger).Log("msg", "failed to flush buffered writes, they're lost", "err", err)
+	}
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+	s.kv.Close()
+}
+
+// backend returns the backend client to call, or ErrStoreUnavailable if the backend is unhealthy
+// and it's not time to probe it yet. release has to be called with the call's error.
+func (s *ChatStore) backend() (kv store.Store, release func(err error), err error) {
+	h := s.health
+	h.mu.Lock()
+	probe := false
+	if h.unhealthy {
+		if h.probing || h.now().Before(h.nextProbe) {
+			err := h.lastErr
+			h.mu.Unlock()
+			return nil, nil, err
+		}
+		h.probing = true
+		probe = true
+	}
+	h.mu.Unlock()
+
+	if probe && h.factory != nil {
+		fresh, err := h.factory()
+		if err != nil {
+			err = &storeError{sentinel: ErrStoreUnavailable, err: err}
+			s.observe(err, true)
+			return nil, nil, err
+		}
+		s.kvMu.Lock()
+		old := s.kv
+		s.kv = fresh
+		s.kvMu.Unlock()
+		old.Close()
+		level.Info(h.logger).Log("msg", "created a new store backend client")
+	}
+
+	s.kvMu.RLock()
+	kv = s.kv
+	s.kvMu.RUnlock()
+	return kv, func(err error) { s.observe(err, probe) }, nil
+}
+
+// observe records the outcome of a call to the backend.
+func (s *ChatStore) observe(err error, probe bool) {
+	h := s.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if probe {
+		h.probing = false
+	}
+
+	if !errors.Is(err, ErrStoreUnavailable) {
+		if h.unhealthy {
+			level.Info(h.logger).Log("msg", "store is reachable again")
+		}
+		h.failures = 0
+		h.unhealthy = false
+		h.backoff = h.backoffMin
+		return
+	}
+
+	h.lastErr = err
+	h.failures++
+	if !h.unhealthy && h.failures < h.threshold {
+		return
+	}
+	if !h.unhealthy {
+		level.Warn(h.logger).Log("msg", "store is unavailable, failing fast until it's reachable again", "err", err)
+	}
+	h.unhealthy = true
+	if probe {
+		h.backoff *= 2
+		if h.backoff > h.backoffMax {
+			h.backoff = h.backoffMax
+		}
+	}
+	h.nextProbe = h.now().Add(h.backoff)
+}
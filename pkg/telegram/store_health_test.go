@@ -0,0 +1,101 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a time that only moves when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestChatStoreReconnect(t *testing.T) {
+	data := newMemoryKV()
+	dead := &failingKV{memoryKV: data, err: errors.New("Unexpected response code: 500 (No cluster leader)")}
+
+	var created int
+	factoryErr := errors.New("dial tcp 127.0.0.1:8500: connect: connection refused")
+	factory := func() (store.Store, error) {
+		created++
+		if factoryErr != nil {
+			return nil, factoryErr
+		}
+		return &failingKV{memoryKV: data}, nil
+	}
+	chats, err := NewChatStore(dead, telegramChatsDirectory, WithStoreFactory(factory), WithStoreReconnectBackoff(time.Second, 2*time.Second))
+	require.NoError(t, err)
+	clock := &fakeClock{t: time.Now()}
+	chats.health.now = clock.now
+
+	for i := 0; i < defaultStoreFailureThreshold; i++ {
+		require.True(t, chats.Healthy(), "the store is healthy until %d calls in a row failed", defaultStoreFailureThreshold)
+		_, err = chats.GetChatInfo(testChat.ID)
+		require.True(t, errors.Is(err, ErrStoreUnavailable))
+	}
+	require.False(t, chats.Healthy())
+
+	// Calls fail fast without reaching the backend, even if it recovered meanwhile.
+	dead.err = nil
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.True(t, errors.Is(err, ErrStoreUnavailable))
+	require.Contains(t, err.Error(), "No cluster leader")
+	require.Zero(t, created)
+
+	// Once the backoff passed a new client is created, which fails and doubles the backoff.
+	clock.advance(time.Second)
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.True(t, errors.Is(err, ErrStoreUnavailable))
+	require.Contains(t, err.Error(), "connection refused")
+	require.Equal(t, 1, created)
+
+	clock.advance(time.Second)
+	require.True(t, errors.Is(chats.AddChat(testChat, nil, nil), ErrStoreUnavailable))
+	require.Equal(t, 1, created)
+
+	factoryErr = nil
+	clock.advance(time.Second)
+	require.NoError(t, chats.AddChat(testChat, nil, nil))
+	require.Equal(t, 2, created)
+	require.True(t, chats.Healthy())
+	_, err = chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+}
+
+func TestBotResumesAfterStoreOutage(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithStoreReconnectBackoff(time.Second, time.Minute))
+	require.NoError(t, err)
+	clock := &fakeClock{t: time.Now()}
+	chats.health.now = clock.now
+
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, err)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	kv.err = errors.New("read tcp 127.0.0.1:8500: connection reset by peer")
+	for i := 0; i < defaultStoreFailureThreshold+2; i++ {
+		d, err := b.processWebhook(context.Background(), maintenanceWebhook("firing", "DiskFull"))
+		require.NoError(t, err)
+		require.True(t, errors.Is(d.Failed, ErrStoreUnavailable))
+	}
+	require.False(t, chats.Healthy())
+	require.Empty(t, tb.messages())
+
+	kv.err = nil
+	clock.advance(time.Second)
+	d, err := b.processWebhook(context.Background(), maintenanceWebhook("firing", "DiskFull"))
+	require.NoError(t, err)
+	require.NoError(t, d.Failed)
+	require.Len(t, tb.messages(), 1)
+	require.True(t, chats.Healthy())
+}
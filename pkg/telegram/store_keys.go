@@ -0,0 +1,229 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandStoreRepair = "/store_repair"
+
+	storeRepairUnique = "store_repair"
+)
+
+// validChatKey matches the keys chat records are stored at, capturing the chat ID.
+var validChatKey = regexp.MustCompile(`^` + regexp.QuoteMeta(telegramChatsDirectory) + `/(-?[0-9]+)$`)
+
+// ChatKeyProblem is a chat record that isn't stored at the key of its chat, like telegram/chats/telegram/chats/123
+// or telegram/chats/123/ left by copying keys between backends. The bot never finds those chats by their ID,
+// so they get no webhooks.
+type ChatKeyProblem struct {
+	Key    string
+	Reason string
+	// Target is the key the record belongs at, empty if it can't be repaired.
+	Target string `json:",omitempty"`
+	// Repaired is set once the record was moved to Target.
+	Repaired bool `json:",omitempty"`
+
+	value []byte
+}
+
+// checkChatKey tells what's wrong with a chat record stored at key, nil if nothing is.
+// The chat ID of the record is trusted over the one in the key, it's what the bot writes records by.
+func checkChatKey(key string, value []byte) *ChatKeyProblem {
+	var ci ChatInfo
+	if err := decode(key, value, &ci); err != nil || ci.Chat == nil {
+		return &ChatKeyProblem{Key: key, Reason: "the record can't be read", value: value}
+	}
+	target := chatKey(ci.Chat.ID)
+	if key == target {
+		return nil
+	}
+	p := &ChatKeyProblem{Key: key, Target: target, value: value}
+	m := validChatKey.FindStringSubmatch(key)
+	if m == nil {
+		p.Reason = fmt.Sprintf("the key isn't %s/<chat ID>", telegramChatsDirectory)
+		return p
+	}
+	if _, err := strconv.ParseInt(m[1], 10, 64); err != nil {
+		p.Reason = fmt.Sprintf("%s isn't a chat ID", m[1])
+		return p
+	}
+	p.Reason = fmt.Sprintf("the record is of chat %d", ci.Chat.ID)
+	return p
+}
+
+// CheckChatKeys returns the chat records that aren't stored at the key of their chat, sorted by key.
+func (s *ChatStore) CheckChatKeys() ([]ChatKeyProblem, error) {
+	kvPairs, err := s.list(telegramChatsDirectory, nil)
+	if err != nil {
+		return nil, err
+	}
+	var problems []ChatKeyProblem
+	for _, kv := range kvPairs {
+		if p := checkChatKey(kv.Key, kv.Value); p != nil {
+			problems = append(problems, *p)
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Key < problems[j].Key })
+
+	// A record already at the target is the chat's, it's kept rather than overwritten.
+	targets := map[string]bool{}
+	for i, p := range problems {
+		if p.Target == "" {
+			continue
+		}
+		_, err := s.get(p.Target, errKeyMissing)
+		switch {
+		case err == nil:
+			problems[i].Reason += fmt.Sprintf(", %s has a record already", p.Target)
+			problems[i].Target = ""
+		case !errors.Is(err, errKeyMissing):
+			return nil, err
+		case targets[p.Target]:
+			problems[i].Reason += fmt.Sprintf(", another key belongs at %s", p.Target)
+			problems[i].Target = ""
+		default:
+			targets[p.Target] = true
+		}
+	}
+	return problems, nil
+}
+
+// RepairChatKeys moves the chat records that aren't stored at the key of their chat there, keeping their data,
+// and deletes the malformed keys. The records that can't be repaired are left as they are.
+func (s *ChatStore) RepairChatKeys() ([]ChatKeyProblem, error) {
+	problems, err := s.CheckChatKeys()
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range problems {
+		if p.Target == "" {
+			continue
+		}
+		if err := s.put(p.Target, p.value); err != nil {
+			return problems, err
+		}
+		if err := s.delete(p.Key); err != nil {
+			return problems, err
+		}
+		problems[i].Repaired = true
+	}
+	return problems, nil
+}
+
+// formatChatKeyProblems lists the problems, with what repairing does or did about each.
+func formatChatKeyProblems(problems []ChatKeyProblem) string {
+	lines := make([]string, 0, len(problems))
+	for _, p := range problems {
+		switch {
+		case p.Repaired:
+			lines = append(lines, fmt.Sprintf("%s: %s, moved to %s", p.Key, p.Reason, p.Target))
+		case p.Target != "":
+			lines = append(lines, fmt.Sprintf("%s: %s, belongs at %s", p.Key, p.Reason, p.Target))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s, can't be repaired", p.Key, p.Reason))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// repairable counts the problems repairing can fix.
+func repairable(problems []ChatKeyProblem) int {
+	n := 0
+	for _, p := range problems {
+		if p.Target != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkChatKeysOnStart logs the chat records stored at the wrong key, which never get webhooks.
+func (b *Bot) checkChatKeysOnStart() {
+	problems, err := b.chats.CheckChatKeys()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to check the keys of chat records", "err", err)
+		return
+	}
+	for _, p := range problems {
+		level.Warn(b.logger).Log("msg", "chat record is stored at the wrong key, "+CommandStoreRepair+" moves it", "key", p.Key, "reason", p.Reason, "target", p.Target)
+	}
+}
+
+func (b *Bot) handleStoreRepair(message *telebot.Message) error {
+	problems, err := b.chats.CheckChatKeys()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to check the keys of chat records", "err", err)
+		_, err = b.replyStoreError(message, err, "check the keys of chat records")
+		return err
+	}
+	if len(problems) == 0 {
+		_, err = b.reply(message, "All chat records are stored at the key of their chat.")
+		return err
+	}
+	text := "Chat records stored at the wrong key:\n" + formatChatKeyProblems(problems)
+	n := repairable(problems)
+	if n == 0 {
+		_, err = b.reply(message, text)
+		return err
+	}
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: storeRepairUnique, Text: fmt.Sprintf("Move %d records", n)},
+	}}}
+	_, err = b.reply(message, text+"\n\nNothing was changed yet.", &telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleStoreRepairConfirm(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can repair the store.")
+		return
+	}
+
+	// The keys are checked again, the store may have changed since the button was sent.
+	problems, err := b.chats.RepairChatKeys()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to repair the keys of chat records", "err", err)
+		respond(b.storeErrorReply(err, "repair the keys of chat records"))
+		return
+	}
+	respond("")
+	for _, p := range problems {
+		if p.Repaired {
+			level.Info(b.logger).Log("msg", "moved chat record to its key", "key", p.Key, "target", p.Target, "sender_id", c.Sender.ID)
+		}
+	}
+
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove store repair button", "err", err)
+	}
+	text := "All chat records are stored at the key of their chat."
+	if len(problems) > 0 {
+		text = "Repaired the store:\n" + formatChatKeyProblems(problems)
+	}
+	if _, err := b.send(c.Message.Chat, text, "button store repair"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send store repair result", "err", err)
+	}
+}
@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// seedMalformedChatKeys stores chat records at the keys a migration script left behind.
+func seedMalformedChatKeys(t *testing.T, kv store.Store) {
+	t.Helper()
+	record := func(id int64) []byte {
+		value, err := json.Marshal(ChatInfo{Chat: &telebot.Chat{ID: id, Type: telebot.ChatGroup}, AlertEnvironments: []string{"prod"}})
+		require.NoError(t, err)
+		return value
+	}
+	for key, value := range map[string][]byte{
+		"telegram/chats/telegram/chats/-1":    record(-1),
+		"telegram/chats/-2/":                  record(-2),
+		"telegram/chats/-3":                   record(-4),
+		"telegram/chats/ -5":                  record(-5),
+		"telegram/chats/-6":                   record(-6),
+		"telegram/chats/-6/":                  record(-6),
+		"telegram/chats/99999999999999999999": record(-7),
+		"telegram/chats/-8/":                  []byte("{"),
+	} {
+		require.NoError(t, kv.Put(key, value, nil))
+	}
+}
+
+func TestCheckChatKeys(t *testing.T) {
+	for _, tc := range []struct {
+		key, value string
+		expected   *ChatKeyProblem
+	}{
+		{"telegram/chats/-1", `{"Chat":{"id":-1}}`, nil},
+		{"telegram/chats/telegram/chats/-1", `{"Chat":{"id":-1}}`, &ChatKeyProblem{Reason: "the key isn't telegram/chats/<chat ID>", Target: "telegram/chats/-1"}},
+		{"telegram/chats/-1/", `{"Chat":{"id":-1}}`, &ChatKeyProblem{Reason: "the key isn't telegram/chats/<chat ID>", Target: "telegram/chats/-1"}},
+		{"telegram/chats/-2", `{"Chat":{"id":-1}}`, &ChatKeyProblem{Reason: "the record is of chat -1", Target: "telegram/chats/-1"}},
+		{"telegram/chats/99999999999999999999", `{"Chat":{"id":-1}}`, &ChatKeyProblem{Reason: "99999999999999999999 isn't a chat ID", Target: "telegram/chats/-1"}},
+		{"telegram/chats/-1", `{}`, &ChatKeyProblem{Reason: "the record can't be read"}},
+		{"telegram/chats/-1", `{`, &ChatKeyProblem{Reason: "the record can't be read"}},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			p := checkChatKey(tc.key, []byte(tc.value))
+			if tc.expected == nil {
+				require.Nil(t, p)
+				return
+			}
+			require.NotNil(t, p)
+			require.Equal(t, tc.key, p.Key)
+			require.Equal(t, tc.expected.Reason, p.Reason)
+			require.Equal(t, tc.expected.Target, p.Target)
+		})
+	}
+}
+
+func TestRepairChatKeys(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	seedMalformedChatKeys(t, kv)
+
+	problems, err := chats.CheckChatKeys()
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"telegram/chats/ -5: the key isn't telegram/chats/<chat ID>, belongs at telegram/chats/-5",
+		"telegram/chats/-2/: the key isn't telegram/chats/<chat ID>, belongs at telegram/chats/-2",
+		"telegram/chats/-3: the record is of chat -4, belongs at telegram/chats/-4",
+		"telegram/chats/-6/: the key isn't telegram/chats/<chat ID>, telegram/chats/-6 has a record already, can't be repaired",
+		"telegram/chats/-8/: the record can't be read, can't be repaired",
+		"telegram/chats/99999999999999999999: 99999999999999999999 isn't a chat ID, belongs at telegram/chats/-7",
+		"telegram/chats/telegram/chats/-1: the key isn't telegram/chats/<chat ID>, belongs at telegram/chats/-1",
+	}, "\n"), formatChatKeyProblems(problems))
+	_, err = chats.GetChatInfo(-1)
+	require.ErrorIs(t, err, ErrChatNotFound, "checking changes nothing")
+
+	problems, err = chats.RepairChatKeys()
+	require.NoError(t, err)
+	require.Equal(t, 5, repairable(problems))
+	for _, id := range []int64{-1, -2, -4, -5, -6, -7} {
+		ci, err := chats.GetChatInfo(id)
+		require.NoError(t, err, id)
+		require.Equal(t, id, ci.Chat.ID)
+		require.Equal(t, []string{"prod"}, ci.AlertEnvironments, "the data is kept")
+	}
+	for _, key := range []string{"telegram/chats/telegram/chats/-1", "telegram/chats/-2/", "telegram/chats/-3", "telegram/chats/ -5", "telegram/chats/99999999999999999999"} {
+		_, err := kv.Get(key)
+		require.ErrorIs(t, err, store.ErrKeyNotFound, key)
+	}
+
+	problems, err = chats.CheckChatKeys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"telegram/chats/-6/", "telegram/chats/-8/"}, []string{problems[0].Key, problems[1].Key}, "what can't be repaired is left")
+	require.Len(t, problems, 2)
+}
+
+func TestHandleStoreRepair(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID)
+	require.NoError(t, err)
+	require.NoError(t, kv.Put("telegram/chats/-1/", []byte(`{"Chat":{"id":-1}}`), nil))
+
+	require.NoError(t, b.handleStoreCheck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStoreCheck}))
+	require.Equal(t, "Found chat records stored at the wrong key, /store_repair shows what repairing them does:\n"+
+		"telegram/chats/-1/: the key isn't telegram/chats/<chat ID>, belongs at telegram/chats/-1\n", tb.lastText())
+
+	require.NoError(t, b.handleStoreRepair(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStoreRepair}))
+	require.True(t, strings.HasSuffix(tb.lastText(), "\n\nNothing was changed yet."), tb.lastText())
+	require.Len(t, buttons(tb.messages()[len(tb.messages())-1]), 1)
+
+	b.handleStoreRepairConfirm(&telebot.Callback{Sender: &telebot.User{ID: 999}, Message: &telebot.Message{ID: 1, Chat: testChat}})
+	require.Equal(t, "Only admins can repair the store.", tb.responses[0].Text)
+	_, err = chats.GetChatInfo(-1)
+	require.ErrorIs(t, err, ErrChatNotFound)
+
+	b.handleStoreRepairConfirm(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: testChat}})
+	require.Equal(t, "Repaired the store:\ntelegram/chats/-1/: the key isn't telegram/chats/<chat ID>, moved to telegram/chats/-1", tb.lastText())
+	_, err = chats.GetChatInfo(-1)
+	require.NoError(t, err)
+
+	require.NoError(t, b.handleStoreCheck(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandStoreCheck}))
+	require.Equal(t, "No problems found in the store.", tb.lastText())
+}
@@ -0,0 +1,184 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	defaultSuppressedWindow = 5 * time.Minute
+	defaultSuppressedValue  = "critical"
+)
+
+// WithSuppressedCriticalAlerting tells the admins when a chat's mutes or minimum severity suppress a critical alert.
+func WithSuppressedCriticalAlerting(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.suppressedAlerting = enabled
+		return nil
+	}
+}
+
+// WithSuppressedCriticalSettings changes what counts as critical, how long suppressed alerts are collected
+// before a notice is sent and where it goes. A chatID of 0 sends the notices to the admins.
+func WithSuppressedCriticalSettings(window time.Duration, label string, value string, chatID int64) BotOption {
+	return func(b *Bot) error {
+		if window <= 0 {
+			return fmt.Errorf("the window for suppressed critical alerts must be positive, is %s", window)
+		}
+		if label == "" || value == "" {
+			return fmt.Errorf("the severity label and value of critical alerts must not be empty")
+		}
+		b.suppressed.window = window
+		b.suppressed.label = label
+		b.suppressed.value = value
+		b.suppressed.chatID = chatID
+		return nil
+	}
+}
+
+// suppression is why a chat doesn't get an alert: a mute of its environment or project, or its minimum severity.
+type suppression struct {
+	// Label is labelEnvironment or labelProject for mutes, labelSeverity for the minimum severity.
+	Label string
+	// Value is the muted value, or the severity of the alert.
+	Value string
+	// MinSeverity is the chat's minimum severity the alert is below.
+	MinSeverity string
+}
+
+// String reads like "its mute of environment[staging]", as the suppressed critical alerts notices put it.
+func (s suppression) String() string {
+	if s.Label == labelSeverity {
+		return fmt.Sprintf("its minimum severity %s", s.MinSeverity)
+	}
+	return fmt.Sprintf("its mute of %s[%s]", s.Label, s.Value)
+}
+
+// explain reads like "environment 'staging' is muted", for users asking why they don't get an alert.
+func (s suppression) explain() string {
+	if s.Label == labelSeverity {
+		return fmt.Sprintf("severity '%s' is below the chat's minimum severity '%s'", s.Value, s.MinSeverity)
+	}
+	return fmt.Sprintf("%s '%s' is muted", s.Label, s.Value)
+}
+
+// suppressedBy returns why the chat doesn't get an alert, or nil if it does.
+func (b *Bot) suppressedBy(ci *ChatInfo, a template.Alert) *suppression {
+	_, s := b.evaluateFilters(ci, a)
+	return s
+}
+
+// suppressedKey groups suppressed alerts by chat and reason.
+type suppressedKey struct {
+	chat   string
+	reason string
+}
+
+// suppressedNotices collects the critical alerts chats suppressed until they are sent in one notice.
+type suppressedNotices struct {
+	window time.Duration
+	label  string
+	value  string
+	chatID int64
+
+	mu     sync.Mutex
+	counts map[suppressedKey]int
+	order  []suppressedKey
+}
+
+func newSuppressedNotices() *suppressedNotices {
+	return &suppressedNotices{
+		window: defaultSuppressedWindow,
+		label:  labelSeverity,
+		value:  defaultSuppressedValue,
+		counts: map[suppressedKey]int{},
+	}
+}
+
+func (n *suppressedNotices) add(chat string, reason string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := suppressedKey{chat: chat, reason: reason}
+	if _, ok := n.counts[key]; !ok {
+		n.order = append(n.order, key)
+	}
+	n.counts[key]++
+}
+
+// take returns the lines of the notice and starts collecting anew, "" if nothing was suppressed.
+func (n *suppressedNotices) take() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.order) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(n.order, func(i, j int) bool { return n.counts[n.order[i]] > n.counts[n.order[j]] })
+	lines := make([]string, 0, len(n.order))
+	for _, key := range n.order {
+		alerts := "alerts were"
+		if n.counts[key] == 1 {
+			alerts = "alert was"
+		}
+		lines = append(lines, fmt.Sprintf("%d %s %s suppressed for chat %s by %s",
+			n.counts[key], n.value, alerts, key.chat, key.reason))
+	}
+	n.counts = map[suppressedKey]int{}
+	n.order = nil
+	return strings.Join(lines, "\n")
+}
+
+// recordSuppressed remembers the critical alerts of a webhook the chat doesn't get.
+func (b *Bot) recordSuppressed(ci *ChatInfo, alerts template.Alerts) {
+	if !b.suppressedAlerting {
+		return
+	}
+	for _, a := range alerts {
+		if a.Status == "resolved" || a.Labels[b.suppressed.label] != b.suppressed.value {
+			continue
+		}
+		if reason := b.suppressedBy(ci, a); reason != nil {
+			b.suppressed.add(chatName(ci.Chat), reason.String())
+		}
+	}
+}
+
+// sendSuppressed sends what was suppressed since the last notice.
+func (b *Bot) sendSuppressed() {
+	notice := b.suppressed.take()
+	if notice == "" {
+		return
+	}
+	if b.suppressed.chatID != 0 {
+		if _, err := b.send(&telebot.Chat{ID: b.suppressed.chatID}, notice, "suppressed alerts"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send suppressed critical alerts notice", "chat_id", b.suppressed.chatID, "err", err)
+		}
+		return
+	}
+	for _, admin := range b.admins {
+		b.SendAdminMessage(admin, notice)
+	}
+}
+
+// runSuppressedNotices sends a notice every window until the context is done.
+func (b *Bot) runSuppressedNotices(ctx context.Context) {
+	ticker := time.NewTicker(b.suppressed.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.sendSuppressed()
+			return
+		case <-ticker.C:
+			b.sendSuppressed()
+		}
+	}
+}
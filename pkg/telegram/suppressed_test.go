@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var payments = &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+
+func suppressedWebhook(chatID int64, status string, labels ...template.KV) alertmanager.TelegramWebhook {
+	alerts := make(template.Alerts, 0, len(labels))
+	for _, l := range labels {
+		alerts = append(alerts, template.Alert{Status: status, Labels: l})
+	}
+	return alertmanager.TelegramWebhook{ChatID: chatID, Message: webhook.Message{Data: &template.Data{Status: status, Alerts: alerts}}}
+}
+
+func TestSuppressedCriticalBatching(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithSuppressedCriticalAlerting(true), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"prod"}, b.environmentsAndOther))
+	require.NoError(t, chats.MuteProjects(payments, []string{"frontend"}, b.projectsAndOther))
+
+	critical := template.KV{"alertname": "DiskFull", labelSeverity: "critical", labelEnvironment: "prod"}
+	for i := 0; i < 2; i++ {
+		_, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing", critical, critical))
+		require.NoError(t, err)
+	}
+	_, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing",
+		template.KV{"alertname": "HighLatency", labelSeverity: "critical", labelEnvironment: "staging", labelProject: "frontend"},
+		template.KV{"alertname": "HighLatency", labelSeverity: "warning", labelEnvironment: "prod"},
+	))
+	require.NoError(t, err)
+	_, err = b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "resolved", critical))
+	require.NoError(t, err)
+	require.Empty(t, tb.messages(), "nothing is delivered and notices wait for the window")
+
+	b.sendSuppressed()
+	msgs := tb.messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, strconv.Itoa(testAdmin.ID), msgs[0].to)
+	require.Equal(t,
+		"4 critical alerts were suppressed for chat payments-oncall (-100) by its mute of environment[prod]\n"+
+			"1 critical alert was suppressed for chat payments-oncall (-100) by its mute of project[frontend]",
+		msgs[0].text())
+
+	// The next window starts empty.
+	b.sendSuppressed()
+	require.Len(t, tb.messages(), 1)
+}
+
+func TestSuppressedCriticalSettings(t *testing.T) {
+	b, tb, chats := newTestBot(t,
+		WithSuppressedCriticalAlerting(true),
+		WithSuppressedCriticalSettings(10*time.Millisecond, "priority", "P1", -42),
+	)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"prod"}, b.environmentsAndOther))
+
+	_, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing",
+		template.KV{"alertname": "DiskFull", labelSeverity: "critical", labelEnvironment: "prod"},
+		template.KV{"alertname": "DiskFull", "priority": "P1", labelEnvironment: "prod"},
+	))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.runSuppressedNotices(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return len(tb.messages()) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	msgs := tb.messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "-42", msgs[0].to)
+	require.Equal(t, "1 P1 alert was suppressed for chat payments-oncall (-100) by its mute of environment[prod]", msgs[0].text())
+
+	_, err = NewBotWithTelegram(chats, tb, testAdmin.ID, WithSuppressedCriticalSettings(0, "severity", "critical", 0))
+	require.Error(t, err)
+}
+
+func TestSuppressedCriticalDisabled(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"prod"}, b.environmentsAndOther))
+
+	_, err := b.processWebhook(context.Background(), suppressedWebhook(payments.ID, "firing",
+		template.KV{"alertname": "DiskFull", labelSeverity: "critical", labelEnvironment: "prod"},
+	))
+	require.NoError(t, err)
+	b.sendSuppressed()
+	require.Empty(t, tb.messages())
+}
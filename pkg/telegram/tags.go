@@ -0,0 +1,312 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandTag       = "/tag"
+	CommandTags      = "/tags"
+	CommandBroadcast = "/broadcast"
+	CommandBulkMute  = "/bulk_mute"
+
+	targetConfirmUnique = "target_confirm"
+	// targetTagPrefix targets all chats with a tag where commands take a chat ID, like tag:frontend.
+	targetTagPrefix = "tag:"
+	// targetConfirmThreshold is the most chats a command targets without asking to confirm first.
+	targetConfirmThreshold = 5
+	// targetNamesShown is how many of the targeted chats are named when asking to confirm.
+	targetNamesShown = 10
+
+	responseTagUsage       = "Usage: " + CommandTag + " add|del <tag>..."
+	responseBroadcastUsage = "Usage: " + CommandBroadcast + ` <chat_id>|tag:<tag> "message"`
+	responseBulkMuteUsage  = "Usage: " + CommandBulkMute + " <chat_id>|tag:<tag> environment[prod] project[billing]"
+)
+
+var (
+	validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+	errNoTarget = errors.New("not a chat ID or tag:<tag>")
+)
+
+// newTargetedChanges keeps the commands targeting many chats until the admin confirms them.
+func newTargetedChanges(timeout time.Duration) *protectedChanges {
+	c := newProtectedChanges(timeout)
+	c.initiatorConfirms = true
+	return c
+}
+
+// SetTags sets the tags of the chat, admin commands target all chats with a tag by tag:<tag>.
+func (s *ChatStore) SetTags(c *telebot.Chat, tags []string) error {
+	return s.UpdateChat(c.ID, func(ci *ChatInfo) error {
+		ci.Tags = tags
+		return nil
+	})
+}
+
+// resolveTargets returns the chats a command targets: the chat with the ID or all chats with the tag:<tag>.
+// No chat having the tag isn't an error.
+func (b *Bot) resolveTargets(target string) ([]ChatInfo, error) {
+	if strings.HasPrefix(target, targetTagPrefix) {
+		tag := strings.ToLower(strings.TrimPrefix(target, targetTagPrefix))
+		chats, err := b.chats.List()
+		if err != nil {
+			return nil, err
+		}
+		var tagged []ChatInfo
+		for _, ci := range chats {
+			if contains(ci.Tags, tag) {
+				tagged = append(tagged, ci)
+			}
+		}
+		return tagged, nil
+	}
+
+	id, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return nil, errNoTarget
+	}
+	ci, err := b.chats.GetChatInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	return []ChatInfo{*ci}, nil
+}
+
+// targetNames names the targeted chats, up to targetNamesShown of them.
+func targetNames(chats []ChatInfo) string {
+	names := make([]string, 0, len(chats))
+	for i, ci := range chats {
+		if i == targetNamesShown {
+			names = append(names, fmt.Sprintf("and %d more", len(chats)-i))
+			break
+		}
+		names = append(names, chatName(ci.Chat))
+	}
+	return strings.Join(names, ", ")
+}
+
+// runTargeted resolves the target and tells how many chats it matched. Then it runs the command for them,
+// once the admin confirmed it with a button if there are more than targetConfirmThreshold.
+func (b *Bot) runTargeted(message *telebot.Message, target string, what string, run func([]ChatInfo) string) error {
+	chats, err := b.resolveTargets(target)
+	switch {
+	case errors.Is(err, errNoTarget):
+		_, err = b.reply(message, fmt.Sprintf("%q is %v.", target, err))
+		return err
+	case errors.Is(err, ErrChatNotFound):
+		_, err = b.reply(message, fmt.Sprintf("Chat %s didn't subscribe.", target))
+		return err
+	case err != nil:
+		level.Warn(b.logger).Log("msg", "failed to resolve target chats", "target", target, "err", err)
+		_, err = b.replyStoreError(message, err, "find the chats")
+		return err
+	case len(chats) == 0:
+		_, err = b.reply(message, fmt.Sprintf("No chat matches %s.", target))
+		return err
+	}
+
+	matched := fmt.Sprintf("%s matches %d chats: %s.", target, len(chats), targetNames(chats))
+	if len(chats) <= targetConfirmThreshold {
+		_, err = b.reply(message, matched+"\n"+run(chats))
+		return err
+	}
+
+	key := b.targetedChanges.add(message, func(m *telebot.Message) error {
+		_, err := b.reply(m, run(chats))
+		return err
+	})
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: targetConfirmUnique, Text: fmt.Sprintf("Confirm for %d chats", len(chats)), Data: key},
+	}}}
+	_, err = b.reply(message, fmt.Sprintf("%s\nConfirm to %s all of them.", matched, what), &telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleTargetConfirm(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can confirm this.")
+		return
+	}
+	change, err := b.targetedChanges.confirm(c.Data, c.Sender.ID)
+	if err != nil {
+		respond("This has expired, run the command again.")
+	} else {
+		respond("")
+	}
+	if c.Message != nil {
+		if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to remove target confirmation button", "err", err)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err := change.run(change.message); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to handle confirmed command", "err", err)
+	}
+}
+
+func (b *Bot) handleTag(message *telebot.Message) error {
+	fields := strings.Fields(strings.ToLower(message.Payload))
+	if len(fields) < 2 || (fields[0] != "add" && fields[0] != "del") {
+		_, err := b.reply(message, responseTagUsage)
+		return err
+	}
+	for _, tag := range fields[1:] {
+		if !validTag.MatchString(tag) {
+			_, err := b.reply(message, fmt.Sprintf("%q isn't a valid tag, tags are up to 32 letters, digits, dots, dashes and underscores.", tag))
+			return err
+		}
+	}
+
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get chat", "err", err)
+		_, err = b.replyStoreError(message, err, "get the chat's tags")
+		return err
+	}
+	tags := ci.Tags
+	if fields[0] == "add" {
+		tags = getUniqueStrings(append(tags, fields[1:]...))
+	} else {
+		tags = arrayDifference(tags, fields[1:])
+	}
+	sort.Strings(tags)
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	if err := b.chats.SetTags(message.Chat, tags); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set chat tags", "err", err)
+		_, err = b.replyStoreError(message, err, "set the chat's tags")
+		return err
+	}
+	if len(tags) == 0 {
+		_, err = b.reply(message, "This chat has no tags anymore.")
+		return err
+	}
+	_, err = b.reply(message, "This chat is tagged "+strings.Join(tags, ", ")+".")
+	return err
+}
+
+func (b *Bot) handleTags(message *telebot.Message) error {
+	chats, err := b.chats.List()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list chats from chat store", "err", err)
+		_, err = b.replyStoreError(message, err, "list the tags")
+		return err
+	}
+
+	counts := map[string]int{}
+	var own []string
+	for _, ci := range chats {
+		for _, tag := range ci.Tags {
+			counts[tag]++
+		}
+		if ci.Chat.ID == message.Chat.ID {
+			own = ci.Tags
+		}
+	}
+	if len(counts) == 0 {
+		_, err = b.reply(message, "No chat is tagged, tag chats with "+CommandTag+" add <tag>.")
+		return err
+	}
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var sb strings.Builder
+	if len(own) > 0 {
+		fmt.Fprintf(&sb, "This chat is tagged %s.\n\n", strings.Join(own, ", "))
+	}
+	sb.WriteString("Chats by tag:\n")
+	for _, tag := range tags {
+		fmt.Fprintf(&sb, "%s%s: %d\n", targetTagPrefix, tag, counts[tag])
+	}
+	_, err = b.reply(message, b.truncateMessage(sb.String()))
+	return err
+}
+
+// splitTarget splits the payload of a targeting command into its target and the rest.
+func splitTarget(payload string) (string, string) {
+	payload = strings.TrimSpace(payload)
+	i := strings.IndexAny(payload, " \t\n")
+	if i < 0 {
+		return payload, ""
+	}
+	return payload[:i], strings.TrimSpace(payload[i+1:])
+}
+
+func (b *Bot) handleBroadcast(message *telebot.Message) error {
+	target, text := splitTarget(message.Payload)
+	if len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`) {
+		text = text[1 : len(text)-1]
+	}
+	if target == "" || strings.TrimSpace(text) == "" {
+		_, err := b.reply(message, responseBroadcastUsage)
+		return err
+	}
+
+	return b.runTargeted(message, target, "send the message to", func(chats []ChatInfo) string {
+		var sent int
+		for _, ci := range chats {
+			if _, err := b.send(ci.Chat, text, "command "+CommandBroadcast); err != nil {
+				level.Warn(b.logger).Log("msg", "failed to broadcast", "chat_id", ci.Chat.ID, "err", err)
+				continue
+			}
+			sent++
+		}
+		return fmt.Sprintf("Sent the message to %d of %d chats.", sent, len(chats))
+	})
+}
+
+func (b *Bot) handleBulkMute(message *telebot.Message) error {
+	target, rest := splitTarget(message.Payload)
+	envs, prs, err := parseMuteTargets(rest)
+	if target == "" || err != nil {
+		_, err := b.reply(message, responseBulkMuteUsage)
+		return err
+	}
+
+	return b.runTargeted(message, target, "mute "+describeTargets(envs, prs)+" in", func(chats []ChatInfo) string {
+		var muted int
+		for _, ci := range chats {
+			if len(envs) > 0 {
+				if err := b.chats.MuteEnvironments(ci.Chat, envs, b.environmentsAndOther); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to mute environments", "chat_id", ci.Chat.ID, "err", err)
+					continue
+				}
+			}
+			if len(prs) > 0 {
+				if err := b.chats.MuteProjects(ci.Chat, prs, b.projectsAndOther); err != nil {
+					level.Warn(b.logger).Log("msg", "failed to mute projects", "chat_id", ci.Chat.ID, "err", err)
+					continue
+				}
+			}
+			muted++
+		}
+		return fmt.Sprintf("Muted %s in %d of %d chats.", describeTargets(envs, prs), muted, len(chats))
+	})
+}
@@ -0,0 +1,128 @@
+package telegram
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// taggedChats subscribes chats -1 to -n, tagging each with its tags.
+func taggedChats(t *testing.T, b *Bot, chats *ChatStore, tags ...[]string) []*telebot.Chat {
+	var out []*telebot.Chat
+	for i, tt := range tags {
+		c := &telebot.Chat{ID: int64(-1 - i), Title: fmt.Sprintf("team-%d", i+1), Type: telebot.ChatGroup}
+		require.NoError(t, chats.AddChat(c, b.environmentsAndOther, b.projectsAndOther))
+		require.NoError(t, chats.SetTags(c, tt))
+		out = append(out, c)
+	}
+	return out
+}
+
+func TestHandleTag(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	tag := func(payload string) string {
+		require.NoError(t, b.handleTag(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: payload}))
+		return tb.lastText()
+	}
+
+	require.Equal(t, "This chat is tagged frontend, team-payments.", tag("add team-payments Frontend"))
+	require.Equal(t, "This chat is tagged frontend, team-payments.", tag("add frontend"))
+	require.Equal(t, "This chat is tagged team-payments.", tag("del frontend"))
+	require.Equal(t, `"no/slashes" isn't a valid tag, tags are up to 32 letters, digits, dots, dashes and underscores.`, tag("add no/slashes"))
+	require.Equal(t, responseTagUsage, tag("add"))
+	require.Equal(t, "This chat has no tags anymore.", tag("del team-payments"))
+
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Nil(t, ci.Tags)
+}
+
+func TestHandleTags(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, b.handleTags(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "No chat is tagged, tag chats with /tag add <tag>.", tb.lastText())
+
+	taggedChats(t, b, chats, []string{"frontend", "staging"}, []string{"frontend"})
+	require.NoError(t, chats.SetTags(testChat, []string{"staging"}))
+	require.NoError(t, b.handleTags(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	require.Equal(t, "This chat is tagged staging.\n\nChats by tag:\ntag:frontend: 2\ntag:staging: 2\n", tb.lastText())
+}
+
+func TestResolveTargets(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	taggedChats(t, b, chats, []string{"frontend", "staging"}, []string{"frontend"}, nil)
+
+	frontend, err := b.resolveTargets("tag:frontend")
+	require.NoError(t, err)
+	require.Len(t, frontend, 2, "chats with several tags match each of them")
+	staging, err := b.resolveTargets("tag:Staging")
+	require.NoError(t, err)
+	require.Len(t, staging, 1)
+	require.Equal(t, int64(-1), staging[0].Chat.ID)
+
+	none, err := b.resolveTargets("tag:backend")
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	single, err := b.resolveTargets("-3")
+	require.NoError(t, err)
+	require.Len(t, single, 1)
+	_, err = b.resolveTargets("-4")
+	require.ErrorIs(t, err, ErrChatNotFound)
+	_, err = b.resolveTargets("frontend")
+	require.ErrorIs(t, err, errNoTarget)
+}
+
+func TestBroadcast(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	taggedChats(t, b, chats, []string{"frontend"}, []string{"frontend"}, []string{"backend"})
+
+	require.NoError(t, b.handleBroadcast(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: `tag:backend-2 "maintenance at 5"`}))
+	require.Equal(t, "No chat matches tag:backend-2.", tb.lastText())
+
+	require.NoError(t, b.handleBroadcast(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: `tag:frontend "maintenance at 5"`}))
+	msgs := tb.messages()
+	require.Len(t, msgs, 4)
+	require.Equal(t, "-1", msgs[1].to)
+	require.Equal(t, "maintenance at 5", msgs[1].text())
+	require.Equal(t, "-2", msgs[2].to)
+	require.Equal(t, "tag:frontend matches 2 chats: team-1 (-1), team-2 (-2).\nSent the message to 2 of 2 chats.", tb.lastText())
+
+	require.NoError(t, b.handleBroadcast(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "tag:frontend"}))
+	require.Equal(t, responseBroadcastUsage, tb.lastText())
+}
+
+func TestBulkMuteConfirmation(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	var tags [][]string
+	for i := 0; i < targetConfirmThreshold+1; i++ {
+		tags = append(tags, []string{"staging"})
+	}
+	targeted := taggedChats(t, b, chats, tags...)
+
+	require.NoError(t, b.handleBulkMute(&telebot.Message{Sender: testAdmin, Chat: testChat, Payload: "tag:staging project[billing]"}))
+	require.Contains(t, tb.lastText(), "tag:staging matches 6 chats")
+	require.Contains(t, tb.lastText(), "Confirm to mute project billing in all of them.")
+	ci, err := chats.GetChatInfo(targeted[0].ID)
+	require.NoError(t, err)
+	require.Empty(t, ci.MutedProjects, "nothing is muted before it's confirmed")
+
+	key := confirmButton(t, tb)
+	b.handleTargetConfirm(&telebot.Callback{Sender: &telebot.User{ID: 999}, Data: key})
+	require.Equal(t, "Only admins can confirm this.", tb.responses[len(tb.responses)-1].Text)
+
+	b.handleTargetConfirm(&telebot.Callback{Sender: testAdmin, Data: key, Message: &telebot.Message{Chat: testChat}})
+	require.Equal(t, "Muted project billing in 6 of 6 chats.", tb.lastText())
+	for _, c := range targeted {
+		ci, err := chats.GetChatInfo(c.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"billing"}, ci.MutedProjects)
+	}
+
+	b.handleTargetConfirm(&telebot.Callback{Sender: testAdmin, Data: key, Message: &telebot.Message{Chat: testChat}})
+	require.Equal(t, "This has expired, run the command again.", tb.responses[len(tb.responses)-1].Text)
+}
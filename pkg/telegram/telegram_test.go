@@ -0,0 +1,415 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var (
+	testAdmin = &telebot.User{ID: 123, FirstName: "Elliot", Username: "elliot"}
+	testChat  = &telebot.Chat{ID: 123, FirstName: "Elliot", Username: "elliot", Type: telebot.ChatPrivate}
+)
+
+// memoryKV is a libkv store.Store keeping everything in a map.
+type memoryKV struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	idx  map[string]uint64
+	last uint64
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{data: map[string][]byte{}, idx: map[string]uint64{}}
+}
+
+func (m *memoryKV) Put(key string, value []byte, _ *store.WriteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last++
+	m.data[key] = append([]byte(nil), value...)
+	m.idx[key] = m.last
+	return nil
+}
+
+func (m *memoryKV) Get(key string) (*store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return &store.KVPair{Key: key, Value: append([]byte(nil), v...), LastIndex: m.idx[key]}, nil
+}
+
+func (m *memoryKV) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+	delete(m.data, key)
+	delete(m.idx, key)
+	return nil
+}
+
+func (m *memoryKV) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.data[key]
+	return ok, nil
+}
+
+func (m *memoryKV) Watch(string, <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memoryKV) WatchTree(string, <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (m *memoryKV) NewLock(string, *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// List behaves like the boltdb backend: prefix matching and ErrKeyNotFound if nothing matches.
+func (m *memoryKV) List(prefix string) ([]*store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Strings(keys)
+	kvs := make([]*store.KVPair, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &store.KVPair{Key: k, Value: append([]byte(nil), m.data[k]...), LastIndex: m.idx[k]})
+	}
+	return kvs, nil
+}
+
+func (m *memoryKV) DeleteTree(prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.data, k)
+			delete(m.idx, k)
+		}
+	}
+	return nil
+}
+
+func (m *memoryKV) AtomicPut(key string, value []byte, previous *store.KVPair, _ *store.WriteOptions) (bool, *store.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	idx, exists := m.idx[key]
+	if previous == nil && exists {
+		return false, nil, store.ErrKeyExists
+	}
+	if previous != nil && (!exists || previous.LastIndex != idx) {
+		return false, nil, store.ErrKeyModified
+	}
+	m.last++
+	m.data[key] = append([]byte(nil), value...)
+	m.idx[key] = m.last
+	return true, &store.KVPair{Key: key, Value: value, LastIndex: m.last}, nil
+}
+
+func (m *memoryKV) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	if idx, ok := m.idx[key]; !ok || idx != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	delete(m.data, key)
+	delete(m.idx, key)
+	return true, nil
+}
+
+func (m *memoryKV) Close() {}
+
+type sentMessage struct {
+	to      string
+	what    interface{}
+	options []interface{}
+}
+
+// text returns the sent message if it was a string.
+func (s sentMessage) text() string {
+	text, _ := s.what.(string)
+	return text
+}
+
+// fakeTelebot records everything sent and hands out increasing message IDs.
+type fakeTelebot struct {
+	mu       sync.Mutex
+	sent     []sentMessage
+	handlers map[interface{}]interface{}
+	nextID   int
+	// sendErr, if set, is called for every Send and its error returned instead of sending.
+	sendErr func() error
+	// unreachable are the recipients Send and Notify fail for, like chats the bot was kicked from.
+	unreachable map[string]bool
+	// beforeSend, if set, is called for every Send before it takes the lock, to slow down concurrent sends.
+	beforeSend func()
+	// deleteErr, if set, is called for every Delete and its error returned.
+	deleteErr func(msg telebot.Editable) error
+	deleted   []telebot.Editable
+	responses []*telebot.CallbackResponse
+	// chatErr, if set, is called for every ChatByID and its error returned.
+	chatErr func(id string) error
+	edited  []*telebot.ReplyMarkup
+	// editedTexts are the messages Edit got, with the new text as what.
+	editedTexts []sentMessage
+	pinned      []telebot.Editable
+	// forwarded are the messages Forward got, with the forwarded message as what.
+	forwarded []sentMessage
+	// unpinned counts the Unpin calls.
+	unpinned int
+	answers  []*telebot.QueryResponse
+	// me is the bot's own user.
+	me *telebot.User
+	// starts counts the Start calls, stop ends the running one.
+	starts int
+	stop   chan struct{}
+}
+
+// Start blocks until Stop, like polling Telegram does.
+func (f *fakeTelebot) Start() {
+	f.mu.Lock()
+	f.starts++
+	f.mu.Unlock()
+	<-f.stopped()
+}
+
+func (f *fakeTelebot) Stop() {
+	f.stopped() <- struct{}{}
+}
+
+func (f *fakeTelebot) stopped() chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stop == nil {
+		f.stop = make(chan struct{})
+	}
+	return f.stop
+}
+
+func (f *fakeTelebot) Send(to telebot.Recipient, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	if f.beforeSend != nil {
+		f.beforeSend()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		if err := f.sendErr(); err != nil {
+			return nil, err
+		}
+	}
+	if f.unreachable[to.Recipient()] {
+		return nil, telebot.ErrBlockedByUser
+	}
+	f.sent = append(f.sent, sentMessage{to: to.Recipient(), what: what, options: options})
+	f.nextID++
+
+	chat, ok := to.(*telebot.Chat)
+	if !ok {
+		chat = &telebot.Chat{}
+	}
+	return &telebot.Message{ID: f.nextID, Chat: chat, Unixtime: time.Now().Unix()}, nil
+}
+
+// Raw handles sendMessage like Send, the payload is the only option. Other methods aren't used.
+func (f *fakeTelebot) Raw(method string, payload interface{}) ([]byte, error) {
+	if method != "sendMessage" {
+		return nil, fmt.Errorf("unexpected raw call of %s", method)
+	}
+	params := payload.(map[string]interface{})
+	to := params["chat_id"].(string)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sendErr != nil {
+		if err := f.sendErr(); err != nil {
+			return nil, err
+		}
+	}
+	if f.unreachable[to] {
+		return nil, telebot.ErrBlockedByUser
+	}
+	f.sent = append(f.sent, sentMessage{to: to, what: params["text"], options: []interface{}{params}})
+	f.nextID++
+
+	chatID, err := strconv.ParseInt(to, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"ok":     true,
+		"result": telebot.Message{ID: f.nextID, Chat: &telebot.Chat{ID: chatID}, Unixtime: time.Now().Unix()},
+	})
+}
+
+func (f *fakeTelebot) Delete(msg telebot.Editable) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		if err := f.deleteErr(msg); err != nil {
+			return err
+		}
+	}
+	f.deleted = append(f.deleted, msg)
+	return nil
+}
+
+func (f *fakeTelebot) Respond(c *telebot.Callback, resp ...*telebot.CallbackResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, resp...)
+	return nil
+}
+
+func (f *fakeTelebot) Answer(q *telebot.Query, resp *telebot.QueryResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp.QueryID = q.ID
+	f.answers = append(f.answers, resp)
+	return nil
+}
+
+func (f *fakeTelebot) Me() *telebot.User {
+	return f.me
+}
+
+func (f *fakeTelebot) ChatByID(id string) (*telebot.Chat, error) {
+	if f.chatErr != nil {
+		if err := f.chatErr(id); err != nil {
+			return nil, err
+		}
+	}
+	chatID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &telebot.Chat{ID: chatID}, nil
+}
+
+func (f *fakeTelebot) EditReplyMarkup(msg telebot.Editable, markup *telebot.ReplyMarkup) (*telebot.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edited = append(f.edited, markup)
+	m, _ := msg.(*telebot.Message)
+	return m, nil
+}
+
+func (f *fakeTelebot) Edit(msg telebot.Editable, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	messageID, chatID := msg.MessageSig()
+	f.editedTexts = append(f.editedTexts, sentMessage{to: strconv.FormatInt(chatID, 10) + "/" + messageID, what: what, options: options})
+	m, _ := msg.(*telebot.Message)
+	return m, nil
+}
+
+func (f *fakeTelebot) Forward(to telebot.Recipient, msg telebot.Editable, options ...interface{}) (*telebot.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable[to.Recipient()] {
+		return nil, telebot.ErrBlockedByUser
+	}
+	f.forwarded = append(f.forwarded, sentMessage{to: to.Recipient(), what: msg, options: options})
+	f.nextID++
+	chat, _ := to.(*telebot.Chat)
+	return &telebot.Message{ID: f.nextID, Chat: chat, Unixtime: time.Now().Unix()}, nil
+}
+
+func (f *fakeTelebot) Pin(msg telebot.Editable, options ...interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pinned = append(f.pinned, msg)
+	return nil
+}
+
+func (f *fakeTelebot) Unpin(chat *telebot.Chat) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unpinned++
+	return nil
+}
+
+func (f *fakeTelebot) Notify(to telebot.Recipient, _ telebot.ChatAction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable[to.Recipient()] {
+		return telebot.ErrBlockedByUser
+	}
+	return nil
+}
+
+func (f *fakeTelebot) setUnreachable(to string, unreachable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unreachable == nil {
+		f.unreachable = map[string]bool{}
+	}
+	f.unreachable[to] = unreachable
+}
+
+func (f *fakeTelebot) Handle(endpoint interface{}, handler interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.handlers == nil {
+		f.handlers = map[interface{}]interface{}{}
+	}
+	f.handlers[endpoint] = handler
+}
+
+func (f *fakeTelebot) messages() []sentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentMessage(nil), f.sent...)
+}
+
+func (f *fakeTelebot) lastText() string {
+	msgs := f.messages()
+	if len(msgs) == 0 {
+		return ""
+	}
+	return msgs[len(msgs)-1].text()
+}
+
+// newTestBot returns a Bot with a fake Telegram and a ChatStore backed by memory.
+func newTestBot(t *testing.T, opts ...BotOption) (*Bot, *fakeTelebot, *ChatStore) {
+	t.Helper()
+
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+
+	tb := &fakeTelebot{}
+	opts = append([]BotOption{
+		WithEnvironments("prod,staging"),
+		WithProjects("billing,frontend"),
+	}, opts...)
+
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID, opts...)
+	require.NoError(t, err)
+
+	return b, tb, chats
+}
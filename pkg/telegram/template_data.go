@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// templateData is what alert templates render. It embeds Alertmanager's data, so that
+// templates written for it keep working, and adds the chat and the bot rendering it.
+type templateData struct {
+	*template.Data
+	Chat templateChat
+	Bot  templateBot
+	// UpdatedAt is when Alertmanager last updated the alerts by fingerprint, where that's known like in /alerts.
+	UpdatedAt map[string]time.Time
+	// StaleAfter is how long a firing alert can go without updates before isStale says it's stale.
+	StaleAfter time.Duration
+	// ResolvedAfter is how long the resolved alerts were firing by fingerprint, where that's known.
+	ResolvedAfter map[string]time.Duration
+	// Seen tells for firing alerts by fingerprint whether they're new or when the bot first notified about them,
+	// where new alerts are marked.
+	Seen map[string]*alertSeen
+}
+
+// templateChat is the chat an alert message is rendered for.
+type templateChat struct {
+	ID       int64
+	Title    string
+	Username string
+	// Timezone is the IANA name of the chat's timezone, empty is UTC.
+	Timezone string
+	// Location is the chat's timezone to localise times with, like {{ .StartsAt.In $.Chat.Location }}.
+	Location          *time.Location
+	MinSeverity       string
+	MutedEnvironments []string
+	MutedProjects     []string
+	// Format is the preset the alerts are rendered in, like compact, empty is normal.
+	Format string
+}
+
+// templateBot is the bot rendering alert messages.
+type templateBot struct {
+	Revision    string
+	ExternalURL string
+}
+
+// chatLocation returns the chat's timezone, UTC if it has none or an unknown one.
+func chatLocation(ci *ChatInfo) *time.Location {
+	if ci == nil || ci.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(ci.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// newTemplateData wraps data with the chat, which may be nil if it's unknown.
+func (b *Bot) newTemplateData(ci *ChatInfo, data *template.Data) *templateData {
+	td := &templateData{
+		Data: data,
+		Chat: templateChat{Location: chatLocation(ci)},
+		Bot:  templateBot{Revision: b.revision},
+
+		StaleAfter: b.staleAfter,
+	}
+	if b.templates != nil && b.templates.ExternalURL != nil {
+		td.Bot.ExternalURL = b.templates.ExternalURL.String()
+	}
+	if ci != nil {
+		td.Chat.Timezone = ci.Timezone
+		td.Chat.MinSeverity = ci.MinSeverity
+		td.Chat.MutedEnvironments = ci.MutedEnvironments
+		td.Chat.MutedProjects = ci.MutedProjects
+		td.Chat.Format = ci.Format
+		if ci.Chat != nil {
+			td.Chat.ID = ci.Chat.ID
+			td.Chat.Title = ci.Chat.Title
+			td.Chat.Username = ci.Chat.Username
+		}
+	}
+	return td
+}
+
+// renderAlerts renders the alerts of data with the template of the chat's format preset, telegram.default for normal.
+func (b *Bot) renderAlerts(ci *ChatInfo, data *template.Data) (string, error) {
+	return b.renderTemplateData(b.newTemplateData(ci, data))
+}
+
+func (b *Bot) renderTemplateData(td *templateData) (string, error) {
+	if b.templates == nil {
+		return "", errNoTemplates
+	}
+	return b.templates.ExecuteHTMLString(`{{ template "`+formatTemplate(td.Chat.Format)+`" . }}`, td)
+}
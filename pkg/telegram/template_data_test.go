@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// defaultTemplateBefore is default.tmpl as it was before templates could use the chat.
+const defaultTemplateBefore = `{{ define "telegram.default" }}
+{{ range .Alerts }}
+{{ if eq .Status "firing"}}🔥 <b>{{ .Labels.alertname }}</b> 🔥{{ else }}✅ <b>{{ .Labels.alertname }}</b> ✅{{ end }}
+<b>Labels:</b>{{ range $key, $value := .Labels }}{{ if ne $key "alertname" }}
+    {{ $key }}: {{ $value }}{{ end }}{{ end }}
+<b>Annotations:</b>{{ range $key, $value := .Annotations }}
+    {{ $key }}: {{ $value }}{{ end }}{{ if eq .Status "firing"}}
+<b>Duration:</b> {{ since .StartsAt }}{{ else }}
+<b>Duration:</b> {{ duration .StartsAt .EndsAt }}
+<b>Ended:</b> {{ .EndsAt | since }}{{ end }}
+{{ end }}
+{{ end }}
+`
+
+// sinceValues are the durations templates render relative to now.
+var sinceValues = regexp.MustCompile(`(<b>(?:Duration|Ended):</b>) [^\n]*`)
+
+func withoutSince(s string) string {
+	return sinceValues.ReplaceAllString(s, "$1 …")
+}
+
+func templateTestData() *template.Data {
+	startsAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	return &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"alertname": "DiskFull", labelSeverity: "critical"}, StartsAt: startsAt},
+			{Status: "resolved", Labels: template.KV{"alertname": "HighCPU"}, StartsAt: startsAt, EndsAt: startsAt.Add(time.Hour)},
+		},
+	}
+}
+
+func TestRenderAlertsDefaultChatUnchanged(t *testing.T) {
+	before := filepath.Join(t.TempDir(), "before.tmpl")
+	require.NoError(t, ioutil.WriteFile(before, []byte(defaultTemplateBefore), 0o600))
+	old, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, before))
+	expected, err := old.templates.ExecuteHTMLString(`{{ template "telegram.default" . }}`, templateTestData())
+	require.NoError(t, err)
+
+	b, _, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+
+	rendered, err := b.renderAlerts(ci, templateTestData())
+	require.NoError(t, err)
+	require.Equal(t, withoutSince(expected), withoutSince(rendered))
+	rendered, err = b.renderAlerts(nil, templateTestData())
+	require.NoError(t, err)
+	require.Equal(t, withoutSince(expected), withoutSince(rendered))
+
+	// Templates written for Alertmanager's data render the same with the chat around it.
+	rendered, err = old.renderAlerts(ci, templateTestData())
+	require.NoError(t, err)
+	require.Equal(t, withoutSince(expected), withoutSince(rendered))
+}
+
+func TestRenderAlertsChatTimezone(t *testing.T) {
+	b, _, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	ci.Timezone = "Europe/Berlin"
+
+	rendered, err := b.renderAlerts(ci, templateTestData())
+	require.NoError(t, err)
+	require.Contains(t, rendered, "<b>Started:</b> 2021-03-01 11:00 CET")
+	require.Equal(t, 1, strings.Count(rendered, "<b>Started:</b>"), "resolved alerts show no start")
+}
+
+func TestRenderAlertsChatAndBotFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}`+
+		`{{ .Chat.Title }} ({{ .Chat.ID }}) {{ .Chat.MinSeverity }}`+
+		`{{ if .Chat.MutedProjects }} muted: {{ range .Chat.MutedProjects }}{{ . }}{{ end }}{{ end }}`+
+		` {{ .Bot.Revision }} {{ .Bot.ExternalURL }} {{ len .Alerts.Firing }} firing`+
+		`{{ end }}`), 0o600))
+
+	b, _, chats := newTestBot(t, WithRevision("abc123"), WithTemplates(&url.URL{Scheme: "https", Host: "am.example.com"}, path))
+	group := &telebot.Chat{ID: -100, Title: "payments-oncall", Type: telebot.ChatGroup}
+	require.NoError(t, chats.AddChat(group, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteProjects(group, []string{"billing"}, b.projectsAndOther))
+	ci, err := chats.GetChatInfo(group.ID)
+	require.NoError(t, err)
+	ci.MinSeverity = "warning"
+
+	rendered, err := b.renderAlerts(ci, templateTestData())
+	require.NoError(t, err)
+	require.Equal(t, "payments-oncall (-100) warning muted: billing abc123 https://am.example.com 1 firing", rendered)
+}
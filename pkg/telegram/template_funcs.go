@@ -0,0 +1,313 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hako/durafmt"
+	"github.com/prometheus/alertmanager/asset"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// alertTemplates render the alerts. Unlike template.FromGlobs, which only knows template.DefaultFuncs,
+// they're parsed with the funcs of the bot, so that bots don't share them.
+type alertTemplates struct {
+	html        *htmltemplate.Template
+	ExternalURL *url.URL
+}
+
+// parseTemplateGlobs parses Alertmanager's default templates and then the files matching the globs, like
+// template.FromGlobs, with funcs on top of template.DefaultFuncs.
+func parseTemplateGlobs(funcs template.FuncMap, globs ...string) (*alertTemplates, error) {
+	all := htmltemplate.FuncMap{}
+	for name, f := range template.DefaultFuncs {
+		all[name] = f
+	}
+	for name, f := range funcs {
+		all[name] = f
+	}
+	tmpl := htmltemplate.New("").Option("missingkey=zero").Funcs(all)
+
+	f, err := asset.Assets.Open("/templates/default.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defaults, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl, err = tmpl.Parse(string(defaults)); err != nil {
+		return nil, err
+	}
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			if tmpl, err = tmpl.ParseGlob(glob); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &alertTemplates{html: tmpl}, nil
+}
+
+// ExecuteHTMLString renders text with the templates, escaping what it inserts for HTML.
+// A panic of the execution is returned as an error.
+func (t *alertTemplates) ExecuteHTMLString(text string, data interface{}) (_ string, err error) {
+	defer recoverTemplate(&err)
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := t.html.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl, err = tmpl.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
+
+// templateFuncs are the funcs the bot's templates are parsed with besides template.DefaultFuncs.
+func (b *Bot) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"since": func(v interface{}) string {
+			t, ok := templateTime(v)
+			if !ok {
+				return templateUnknown
+			}
+			return durafmt.Parse(time.Since(t)).String()
+		},
+		"duration": func(startv interface{}, endv interface{}) string {
+			start, ok := templateTime(startv)
+			end, endOK := templateTime(endv)
+			if !ok || !endOK {
+				return templateUnknown
+			}
+			return durafmt.Parse(end.Sub(start)).String()
+		},
+		"isStale": func(v interface{}, threshold time.Duration) bool {
+			updatedAt, _ := templateTime(v)
+			return isStale(updatedAt, threshold, time.Now())
+		},
+		"staleFor": func(v interface{}) string {
+			updatedAt, ok := templateTime(v)
+			if !ok {
+				return templateUnknown
+			}
+			return formatStaleFor(time.Since(updatedAt))
+		},
+		"resolvedAfter": func(after map[string]time.Duration, fingerprint string) string {
+			d, ok := after[fingerprint]
+			if !ok {
+				return ""
+			}
+			return formatFiringDuration(d)
+		},
+		"firingFor": func(v interface{}) string {
+			t, ok := templateTime(v)
+			if !ok {
+				return templateUnknown
+			}
+			return formatFiringDuration(time.Since(t))
+		},
+		"severityEmoji": b.severityEmoji,
+
+		"label":            label,
+		"hasLabel":         hasLabel,
+		"joinLabels":       joinLabels,
+		"sortedLabelPairs": sortedLabelPairs,
+		"trunc":            trunc,
+		"humanizeBytes":    humanizeBytes,
+		"humanizeDuration": humanizeDuration,
+	}
+}
+
+// templateUnknown is what the time funcs render for times that aren't known, like a missing one.
+const templateUnknown = "unknown"
+
+// templateTime returns the time of a template value, a time or a pointer to one. It's false for the zero
+// time, which Alertmanager sends for alerts that haven't ended, and anything else like nil.
+func templateTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case *time.Time:
+		if v != nil {
+			return *v, !v.IsZero()
+		}
+	}
+	return time.Time{}, false
+}
+
+// labelsOf returns the labels of an alert, or the labels given, nil for anything else like a missing alert.
+func labelsOf(v interface{}) template.KV {
+	switch v := v.(type) {
+	case template.Alert:
+		return v.Labels
+	case *template.Alert:
+		if v != nil {
+			return v.Labels
+		}
+	case template.KV:
+		return v
+	case map[string]string:
+		return v
+	}
+	return nil
+}
+
+// label returns the value of the label, empty if it's missing.
+// In a range over .Alerts, {{ label "severity" . }} is "critical".
+func label(name string, v interface{}) string {
+	return labelsOf(v)[name]
+}
+
+// hasLabel tells if the label is set and isn't empty.
+// In a range over .Alerts, {{ if hasLabel "runbook" . }}...{{ end }} only renders for alerts with a runbook.
+func hasLabel(name string, v interface{}) bool {
+	return labelsOf(v)[name] != ""
+}
+
+// joinLabels joins the labels sorted by name as name=value.
+// {{ .Labels | joinLabels ", " }} is "alertname=HighCPU, severity=critical".
+func joinLabels(sep string, v interface{}) string {
+	pairs := labelsOf(v).SortedPairs()
+	joined := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		joined = append(joined, p.Name+"="+p.Value)
+	}
+	return strings.Join(joined, sep)
+}
+
+// sortedLabelPairs returns the labels sorted by name, without the denied ones.
+// {{ range sortedLabelPairs .Labels "alertname" "severity" }}{{ .Name }}: {{ .Value }}{{ end }} leaves those two out.
+func sortedLabelPairs(v interface{}, deny ...string) template.Pairs {
+	denied := make(map[string]bool, len(deny))
+	for _, name := range deny {
+		denied[name] = true
+	}
+	pairs := template.Pairs{}
+	for _, p := range labelsOf(v).SortedPairs() {
+		if !denied[p.Name] {
+			pairs = append(pairs, p)
+		}
+	}
+	return pairs
+}
+
+// trunc shortens v to at most n characters, the last of them … if it's cut. It cuts before escaping,
+// so no escape sequence is cut in half. {{ trunc 10 "disk is almost full" }} is "disk is a…", nil is empty.
+func trunc(n int, v interface{}) string {
+	s := toTemplateString(v)
+	runes := []rune(s)
+	switch {
+	case n < 0 || len(runes) <= n:
+		return s
+	case n == 0:
+		return ""
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// templateNumber is the number of a template value, like an annotation "1536" or a float from a query.
+func templateNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// humanizeBytes formats a number of bytes with binary prefixes, values that aren't numbers stay as they are.
+// {{ humanizeBytes .Annotations.value }} of "1536" is "1.5 KiB".
+func humanizeBytes(v interface{}) string {
+	f, ok := templateNumber(v)
+	if !ok {
+		return toTemplateString(v)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	i := 0
+	for math.Abs(f) >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(f, 'g', 4, 64) + " " + units[i]
+}
+
+// humanizeDuration formats seconds like Prometheus does, values that aren't numbers stay as they are.
+// {{ humanizeDuration .Annotations.value }} of "3725" is "1h 2m 5s", of "0.25" it's "250ms".
+func humanizeDuration(v interface{}) string {
+	f, ok := templateNumber(v)
+	if !ok {
+		return toTemplateString(v)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+	if f < 1 {
+		if f == 0 {
+			return "0s"
+		}
+		return sign + strconv.FormatFloat(f*1000, 'g', 4, 64) + "ms"
+	}
+	seconds := int64(f) % 60
+	minutes := int64(f) / 60 % 60
+	hours := int64(f) / 3600 % 24
+	days := int64(f) / 86400
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%s%dd %dh %dm %ds", sign, days, hours, minutes, seconds)
+	case hours > 0:
+		return fmt.Sprintf("%s%dh %dm %ds", sign, hours, minutes, seconds)
+	case minutes > 0:
+		return fmt.Sprintf("%s%dm %ds", sign, minutes, seconds)
+	}
+	return sign + strconv.FormatFloat(f, 'g', 4, 64) + "s"
+}
+
+// toTemplateString renders v like a template would, nil as empty.
+func toTemplateString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
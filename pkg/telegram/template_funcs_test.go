@@ -0,0 +1,144 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"math"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+)
+
+var funcsAlert = template.Alert{
+	Status: "firing",
+	Labels: template.KV{"alertname": "HighCPU", labelSeverity: "critical", "instance": "db-1", "empty": ""},
+}
+
+func TestLabel(t *testing.T) {
+	require.Equal(t, "critical", label(labelSeverity, funcsAlert))
+	require.Equal(t, "critical", label(labelSeverity, &funcsAlert))
+	require.Equal(t, "db-1", label("instance", funcsAlert.Labels))
+	require.Equal(t, "db-1", label("instance", map[string]string{"instance": "db-1"}))
+	require.Equal(t, "", label("missing", funcsAlert))
+	require.Equal(t, "", label(labelSeverity, nil))
+	require.Equal(t, "", label(labelSeverity, (*template.Alert)(nil)))
+	require.Equal(t, "", label(labelSeverity, 42))
+}
+
+func TestHasLabel(t *testing.T) {
+	require.True(t, hasLabel("instance", funcsAlert))
+	require.False(t, hasLabel("empty", funcsAlert), "empty labels are like missing ones")
+	require.False(t, hasLabel("missing", funcsAlert))
+	require.False(t, hasLabel("instance", nil))
+}
+
+func TestJoinLabels(t *testing.T) {
+	require.Equal(t, "alertname=HighCPU, empty=, instance=db-1, severity=critical", joinLabels(", ", funcsAlert.Labels))
+	require.Equal(t, "alertname=HighCPU", joinLabels(" ", template.KV{"alertname": "HighCPU"}))
+	require.Equal(t, "", joinLabels(", ", nil))
+}
+
+func TestSortedLabelPairs(t *testing.T) {
+	names := func(pairs template.Pairs) []string {
+		out := []string{}
+		for _, p := range pairs {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+	require.Equal(t, []string{"alertname", "empty", "instance", "severity"}, names(sortedLabelPairs(funcsAlert)))
+	require.Equal(t, []string{"empty", "instance"}, names(sortedLabelPairs(funcsAlert.Labels, "alertname", labelSeverity, "missing")))
+	require.Empty(t, sortedLabelPairs(nil))
+}
+
+func TestTrunc(t *testing.T) {
+	require.Equal(t, "disk is a…", trunc(10, "disk is almost full"))
+	require.Equal(t, "disk", trunc(10, "disk"))
+	require.Equal(t, "ÄÖÜ…", trunc(4, "ÄÖÜäöü"), "characters aren't cut in half")
+	require.Equal(t, "…", trunc(1, "disk"))
+	require.Equal(t, "", trunc(0, "disk"))
+	require.Equal(t, "disk", trunc(-1, "disk"))
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{v: "1536", want: "1.5 KiB"},
+		{v: 512, want: "512 B"},
+		{v: 1024.0, want: "1 KiB"},
+		{v: int64(5 << 30), want: "5 GiB"},
+		{v: " 123456789 ", want: "117.7 MiB"},
+		{v: -2048, want: "-2 KiB"},
+		{v: math.Inf(1), want: "+Inf"},
+		{v: "lots", want: "lots"},
+		{v: nil, want: ""},
+	} {
+		require.Equal(t, tc.want, humanizeBytes(tc.v), "%v", tc.v)
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{v: "3725", want: "1h 2m 5s"},
+		{v: 90061, want: "1d 1h 1m 1s"},
+		{v: 61.5, want: "1m 1s"},
+		{v: "12.5", want: "12.5s"},
+		{v: "0.25", want: "250ms"},
+		{v: 0, want: "0s"},
+		{v: -3725, want: "-1h 2m 5s"},
+		{v: math.NaN(), want: "NaN"},
+		{v: "soon", want: "soon"},
+		{v: nil, want: ""},
+	} {
+		require.Equal(t, tc.want, humanizeDuration(tc.v), "%v", tc.v)
+	}
+}
+
+// renderWithFuncs renders the template text with the funcs of a test bot.
+func renderWithFuncs(t *testing.T, text string, data interface{}) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "funcs.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{{ define "test" }}`+text+`{{ end }}`), 0o600))
+	b, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, path))
+	out, err := b.templates.ExecuteHTMLString(`{{ template "test" . }}`, data)
+	require.NoError(t, err)
+	return out
+}
+
+func TestTemplateFuncsEscapeHTML(t *testing.T) {
+	alert := template.Alert{
+		Labels:      template.KV{"alertname": "<b>HighCPU</b>", "team": "a&b"},
+		Annotations: template.KV{"summary": "<<<<<<<<", "size": "1536"},
+	}
+	require.Equal(t, "&lt;b&gt;HighCPU&lt;/b&gt;", renderWithFuncs(t, `{{ label "alertname" . }}`, alert))
+	require.Equal(t, "alertname=&lt;b&gt;HighCPU&lt;/b&gt;; team=a&amp;b", renderWithFuncs(t, `{{ .Labels | joinLabels "; " }}`, alert))
+	require.Equal(t, "team: a&amp;b", renderWithFuncs(t, `{{ range sortedLabelPairs .Labels "alertname" }}{{ .Name }}: {{ .Value }}{{ end }}`, alert))
+	require.Equal(t, "&lt;&lt;&lt;&lt;…", renderWithFuncs(t, `{{ trunc 5 .Annotations.summary }}`, alert), "cut before escaping")
+	require.Equal(t, "1.5 KiB", renderWithFuncs(t, `{{ humanizeBytes .Annotations.size }}`, alert))
+	require.Equal(t, "no runbook", renderWithFuncs(t, `{{ if hasLabel "runbook" . }}runbook{{ else }}no runbook{{ end }}`, alert))
+	require.Equal(t, "", renderWithFuncs(t, `{{ label "alertname" .Missing }}`, map[string]interface{}{}), "missing data renders empty")
+}
+
+func TestTemplateFuncsPerBot(t *testing.T) {
+	before := template.DefaultFuncs["severityEmoji"]
+	path := filepath.Join(t.TempDir(), "emoji.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{{ define "emoji" }}{{ severityEmoji "critical" }}{{ end }}`), 0o600))
+	red, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, path), WithSeverityEmoji(map[string]string{"critical": "🟥"}))
+	blue, _, _ := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, path), WithSeverityEmoji(map[string]string{"critical": "🟦"}))
+
+	out, err := red.templates.ExecuteHTMLString(`{{ template "emoji" . }}`, nil)
+	require.NoError(t, err)
+	require.Equal(t, "🟥", out)
+	out, err = blue.templates.ExecuteHTMLString(`{{ template "emoji" . }}`, nil)
+	require.NoError(t, err)
+	require.Equal(t, "🟦", out)
+	require.Nil(t, before, "the funcs aren't added to template.DefaultFuncs")
+	require.Nil(t, template.DefaultFuncs["label"])
+}
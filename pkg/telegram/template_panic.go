@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTemplateFailures is how many distinct template failures are remembered for logging each only once.
+// Past it they're forgotten and logged again.
+const maxTemplateFailures = 1000
+
+// templatePanic is the error of a template execution that panicked.
+type templatePanic struct {
+	value interface{}
+	// at is the function that panicked.
+	at string
+}
+
+func (p *templatePanic) Error() string {
+	return fmt.Sprintf("template panicked in %s: %v", p.at, p.value)
+}
+
+// recoverTemplate turns a panic of a template execution into a templatePanic error in err, so that the
+// alerts are sent as plain text instead of the bot crashing. It must be deferred.
+func recoverTemplate(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*err = &templatePanic{value: r, at: panickedAt()}
+}
+
+// panickedAt returns the function that panicked, called while recovering.
+func panickedAt() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			panicking = true
+		case panicking && !strings.HasPrefix(frame.Function, "runtime."):
+			return frame.Function
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// templateFailures remembers the signatures of the template failures logged, so that a template failing
+// for every webhook is logged once rather than for each of them.
+type templateFailures struct {
+	mu     sync.Mutex
+	logged map[string]bool
+}
+
+func newTemplateFailures() *templateFailures {
+	return &templateFailures{logged: map[string]bool{}}
+}
+
+// first tells if the failure of the template wasn't logged before, remembering it.
+func (f *templateFailures) first(name string, err error) bool {
+	signature := name + ": " + err.Error()
+	var p *templatePanic
+	if errors.As(err, &p) {
+		// The panic's value may tell the data apart, where it panicked doesn't.
+		signature = fmt.Sprintf("%s: panic %T in %s", name, p.value, p.at)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.logged[signature] {
+		return false
+	}
+	if len(f.logged) >= maxTemplateFailures {
+		f.logged = map[string]bool{}
+	}
+	f.logged[signature] = true
+	return true
+}
+
+// summarizeTemplateData describes the data a template failed for without the labels and annotations,
+// which may be long or sensitive: how many alerts of which status and names, for which receiver.
+func summarizeTemplateData(td *templateData) string {
+	if td == nil || td.Data == nil {
+		return "no data"
+	}
+	statuses := map[string]int{}
+	names := map[string]bool{}
+	for _, a := range td.Alerts {
+		statuses[a.Status]++
+		names[a.Labels["alertname"]] = true
+	}
+	counts := make([]string, 0, len(statuses))
+	for status, n := range statuses {
+		counts = append(counts, fmt.Sprintf("%d %s", n, status))
+	}
+	sort.Strings(counts)
+	alertnames := make([]string, 0, len(names))
+	for name := range names {
+		alertnames = append(alertnames, name)
+	}
+	sort.Strings(alertnames)
+	return fmt.Sprintf("%d alerts (%s) of %s for receiver %q", len(td.Alerts), strings.Join(counts, ", "), strings.Join(alertnames, ", "), td.Receiver)
+}
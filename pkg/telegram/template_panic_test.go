@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverTemplate(t *testing.T) {
+	execute := func(alerts []int) (out int, err error) {
+		defer recoverTemplate(&err)
+		return alerts[3], nil
+	}
+	_, err := execute([]int{1})
+	require.Error(t, err)
+	var p *templatePanic
+	require.ErrorAs(t, err, &p)
+	require.Contains(t, p.at, "TestRecoverTemplate")
+	require.Contains(t, err.Error(), "index out of range")
+
+	out, err := execute([]int{1, 2, 3, 4})
+	require.NoError(t, err)
+	require.Equal(t, 4, out)
+}
+
+func TestTemplatePanicFallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panic.tmpl")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{{ define "telegram.default" }}{{ range .Alerts }}{{ boom . }}{{ end }}{{ end }}`), 0o600))
+	var logs bytes.Buffer
+	b, tb, chats := newTestBot(t, WithLogger(level.NewFilter(log.NewLogfmtLogger(&logs), level.AllowInfo())))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	funcs := b.templateFuncs()
+	funcs["boom"] = func(a template.Alert) string {
+		var seen map[string]bool
+		seen[a.Fingerprint] = true
+		return ""
+	}
+	tmpl, err := parseTemplateGlobs(funcs, path)
+	require.NoError(t, err)
+	tmpl.ExternalURL = &url.URL{Host: "localhost"}
+	b.templates = tmpl
+
+	for i := 0; i < 3; i++ {
+		_, err := b.processWebhook(context.Background(), mixedWebhook("firing", template.Alert{Status: "firing", Fingerprint: "a", Labels: template.KV{"alertname": "HighCPU"}}))
+		require.NoError(t, err)
+		require.Contains(t, tb.lastText(), "[FIRING] HighCPU", "the plain fallback is sent")
+	}
+	require.Len(t, tb.messages(), 3)
+	require.Equal(t, 1, strings.Count(logs.String(), "failed to template alerts"), "the failure is logged once")
+	require.Contains(t, logs.String(), `template=telegram.default data="1 alerts (1 firing) of HighCPU for receiver \"\""`)
+}
+
+func TestTemplateFuncsZeroTimes(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	funcs := b.templateFuncs()
+	for _, name := range []string{"since", "staleFor", "firingFor"} {
+		f := funcs[name].(func(interface{}) string)
+		require.Equal(t, "unknown", f(time.Time{}), name)
+		require.Equal(t, "unknown", f(nil), name)
+		require.Equal(t, "unknown", f((*time.Time)(nil)), name)
+	}
+	duration := funcs["duration"].(func(interface{}, interface{}) string)
+	require.Equal(t, "unknown", duration(time.Now(), time.Time{}))
+	require.Equal(t, "1 minute", duration(time.Time{}.Add(time.Hour), time.Time{}.Add(time.Hour+time.Minute)))
+	require.False(t, funcs["isStale"].(func(interface{}, time.Duration) bool)(nil, time.Minute))
+	require.Equal(t, "", funcs["resolvedAfter"].(func(map[string]time.Duration, string) string)(nil, "a"))
+	require.Equal(t, "", trunc(10, nil))
+}
+
+func TestDefaultTemplateZeroTimes(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	_, err := b.processWebhook(context.Background(), mixedWebhook("resolved", template.Alert{Status: "resolved", Labels: template.KV{"alertname": "HighCPU"}}))
+	require.NoError(t, err)
+	require.Contains(t, tb.lastText(), "<b>Duration:</b> unknown")
+	require.Contains(t, tb.lastText(), "<b>Ended:</b> unknown")
+}
@@ -0,0 +1,150 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandTestAlert = "/testalert"
+
+	// testAlertReceiver marks webhooks generated by /testalert.
+	testAlertReceiver = "alertmanager-bot-testalert"
+	testAlertHeader   = "<b>🧪 TEST ALERT - sent by " + CommandTestAlert + ", not a real alert</b>\n\n"
+
+	defaultTestAlertSeverity = "warning"
+
+	responseTestAlertUsage = "Usage: " + CommandTestAlert + " [severity] [environment] [project], like " + CommandTestAlert + " critical prod billing"
+)
+
+// testAlert is what /testalert sends an alert for.
+type testAlert struct {
+	Severity    string
+	Environment string
+	Project     string
+}
+
+// parseTestAlert reads the severity, environment and project in that order.
+// Those left out are warning and the first environment and project configured.
+func (b *Bot) parseTestAlert(payload string) (testAlert, error) {
+	t := testAlert{Severity: defaultTestAlertSeverity}
+	if len(b.environments) > 0 {
+		t.Environment = b.environments[0]
+	}
+	if len(b.projects) > 0 {
+		t.Project = b.projects[0]
+	}
+
+	fields := strings.Fields(payload)
+	if len(fields) > 3 {
+		return t, fmt.Errorf("too many arguments")
+	}
+	for i, value := range fields {
+		switch i {
+		case 0:
+			t.Severity = value
+		case 1:
+			t.Environment = value
+		case 2:
+			t.Project = value
+		}
+	}
+	return t, nil
+}
+
+// webhook is the synthetic webhook of the test alert, firing now for the chat.
+func (t testAlert) webhook(chatID int64, now time.Time) alertmanager.TelegramWebhook {
+	labels := template.KV{
+		"alertname": "TestAlert",
+		"test":      "true",
+	}
+	if t.Severity != "" {
+		labels[labelSeverity] = t.Severity
+	}
+	if t.Environment != "" {
+		labels[labelEnvironment] = t.Environment
+	}
+	if t.Project != "" {
+		labels[labelProject] = t.Project
+	}
+	alert := template.Alert{
+		Status:      "firing",
+		Labels:      labels,
+		Annotations: template.KV{"summary": "Test alert sent by " + CommandTestAlert},
+		StartsAt:    now,
+		Fingerprint: fmt.Sprintf("%016x", now.UnixNano()),
+	}
+	return alertmanager.TelegramWebhook{
+		ChatID: chatID,
+		Message: webhook.Message{Data: &template.Data{
+			Receiver:     testAlertReceiver,
+			Status:       "firing",
+			Alerts:       template.Alerts{alert},
+			CommonLabels: labels,
+		}},
+	}
+}
+
+// testAlertSuppressed explains why the chat wouldn't get the test alert, "" if it would.
+// It goes through the same rules as the evaluate endpoint of the JSON API.
+func (b *Bot) testAlertSuppressed(ci *ChatInfo, w alertmanager.TelegramWebhook, now time.Time) (string, error) {
+	for _, a := range w.Message.Alerts {
+		d, err := b.evaluate(ci, a, now)
+		if err != nil {
+			return "", err
+		}
+		if r := d.suppressed(); r != nil {
+			return r.Reason, nil
+		}
+	}
+	return "", nil
+}
+
+// handleTestAlert sends the chat a test alert through the same filters and templates as alerts from Alertmanager,
+// or explains why it wouldn't get it.
+func (b *Bot) handleTestAlert(message *telebot.Message) error {
+	t, err := b.parseTestAlert(message.Payload)
+	if err != nil {
+		_, err = b.reply(message, fmt.Sprintf("%v\n%s", err, responseTestAlertUsage))
+		return err
+	}
+
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "get the filters of this chat")
+		return err
+	}
+
+	now := time.Now()
+	w := t.webhook(message.Chat.ID, now)
+	reason, err := b.testAlertSuppressed(ci, w, now)
+	if err != nil {
+		_, err = b.replyStoreError(message, err, "evaluate the filters of this chat")
+		return err
+	}
+	if reason != "" {
+		_, err = b.reply(message, "The test alert would be suppressed: "+reason+".")
+		return err
+	}
+
+	d, err := b.deliverWebhook(context.TODO(), w, testAlertHeader)
+	if err != nil {
+		return err
+	}
+	switch {
+	case d.RateLimited:
+		_, err = b.reply(message, fmt.Sprintf("The test alert was rate limited by Telegram... %v", d.Failed))
+	case d.Failed != nil:
+		_, err = b.respondFailed(message, "send the test alert", d.Failed)
+	case d.Messages == 0:
+		_, err = b.reply(message, "The test alert was dropped, the bot's logs tell why.")
+	}
+	return err
+}
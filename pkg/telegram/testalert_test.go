@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestParseTestAlert(t *testing.T) {
+	b, _, _ := newTestBot(t)
+
+	alert, err := b.parseTestAlert("")
+	require.NoError(t, err)
+	require.Equal(t, testAlert{Severity: "warning", Environment: "prod", Project: "billing"}, alert)
+
+	alert, err = b.parseTestAlert("critical staging")
+	require.NoError(t, err)
+	require.Equal(t, testAlert{Severity: "critical", Environment: "staging", Project: "billing"}, alert)
+
+	_, err = b.parseTestAlert("critical staging billing extra")
+	require.Error(t, err)
+}
+
+func TestTestAlert(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleTestAlert(&telebot.Message{Sender: testAdmin, Chat: payments, Payload: "critical prod billing"}))
+	msgs := tb.messages()
+	require.Len(t, msgs, 1, "the test alert is the only reply")
+	require.True(t, strings.HasPrefix(msgs[0].text(), testAlertHeader))
+	require.Contains(t, msgs[0].text(), "TestAlert")
+}
+
+func TestTestAlertSuppressed(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"staging"}, b.environmentsAndOther))
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	ci.MinSeverity = "warning"
+	require.NoError(t, chats.putChatInfo(ci))
+
+	for payload, reason := range map[string]string{
+		"critical staging": "environment 'staging' is muted",
+		"info prod":        "severity 'info' is below the chat's minimum severity 'warning'",
+	} {
+		require.NoError(t, b.handleTestAlert(&telebot.Message{Sender: testAdmin, Chat: payments, Payload: payload}))
+		require.Equal(t, "The test alert would be suppressed: "+reason+".", tb.lastText(), payload)
+	}
+
+	require.NoError(t, chats.SetMaintenance(Maintenance{Since: time.Now(), Reason: "network migration"}))
+	require.NoError(t, b.handleTestAlert(&telebot.Message{Sender: testAdmin, Chat: payments, Payload: "critical prod"}))
+	require.Contains(t, tb.lastText(), "maintenance is on, it would be sent once it's over")
+	require.Len(t, tb.messages(), 3, "nothing but the explanations is sent")
+}
+
+func TestSuppressionReasons(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	ci := &ChatInfo{MutedProjects: []string{otherValue}, MinSeverity: "critical"}
+
+	reason := b.suppressedBy(ci, template.Alert{Labels: template.KV{labelProject: "unknown"}})
+	require.Equal(t, &suppression{Label: labelProject, Value: otherValue}, reason)
+	require.Equal(t, "its mute of project[other]", reason.String())
+
+	reason = b.suppressedBy(ci, template.Alert{Labels: template.KV{labelProject: "billing", labelSeverity: "warning"}})
+	require.Equal(t, "its minimum severity critical", reason.String())
+	require.Nil(t, b.suppressedBy(ci, template.Alert{Labels: template.KV{labelProject: "billing", labelSeverity: "critical"}}))
+}
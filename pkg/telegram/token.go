@@ -0,0 +1,265 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// TokenPath is where TokenHandler rotates the Telegram token.
+const TokenPath = APIPrefix + "token"
+
+// errRotateWebhook is why a bot getting its updates by webhook can't rotate its token: the listener of the old
+// token's updates shuts down in the background, the new one couldn't listen at the same address until it did.
+var errRotateWebhook = errors.New("rotating the token needs polling, restart the bot to rotate it with the Telegram webhook")
+
+// telebotHandler is a handler registered with Handle, to register again with the Telebot swapped in.
+type telebotHandler struct {
+	endpoint interface{}
+	handler  interface{}
+}
+
+// swappableTelebot is the Telebot RotateToken swaps while the bot is running. Calls wait while it's swapped,
+// so they go to either the old or the new Telebot and none is lost.
+type swappableTelebot struct {
+	mu       sync.RWMutex
+	current  Telebot
+	handlers []telebotHandler
+	// running is the Telebot Start is polling with, nil if it isn't or it's being stopped.
+	running Telebot
+	// stopped is set by Stop until Start returns.
+	stopped bool
+}
+
+// Start polls with the current Telebot until Stop, starting the one swapped in once the old one stopped.
+func (t *swappableTelebot) Start() {
+	for {
+		t.mu.Lock()
+		if t.stopped {
+			t.stopped = false
+			t.mu.Unlock()
+			return
+		}
+		running := t.current
+		t.running = running
+		t.mu.Unlock()
+
+		running.Start()
+
+		t.mu.Lock()
+		if t.running == running {
+			t.running = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Stop ends Start. Whoever takes the running Telebot stops it, so it's stopped once.
+func (t *swappableTelebot) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	running := t.running
+	t.running = nil
+	t.mu.Unlock()
+	if running != nil {
+		running.Stop()
+	}
+}
+
+// swap makes next the Telebot all calls go to, with the handlers registered so far.
+// The old one is stopped if it's polling, then Start polls with next.
+func (t *swappableTelebot) swap(next Telebot) {
+	t.mu.Lock()
+	for _, h := range t.handlers {
+		next.Handle(h.endpoint, h.handler)
+	}
+	t.current = next
+	running := t.running
+	t.running = nil
+	t.mu.Unlock()
+	if running != nil {
+		running.Stop()
+	}
+}
+
+// setCommands sets the command menu with the current Telebot, if it's a commandSetter.
+func (t *swappableTelebot) setCommands(cmds []telebot.Command) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if setter, ok := t.current.(commandSetter); ok {
+		return setter.SetCommands(cmds)
+	}
+	return nil
+}
+
+func (t *swappableTelebot) Handle(endpoint interface{}, handler interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers = append(t.handlers, telebotHandler{endpoint: endpoint, handler: handler})
+	t.current.Handle(endpoint, handler)
+}
+
+func (t *swappableTelebot) Send(to telebot.Recipient, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Send(to, what, options...)
+}
+
+func (t *swappableTelebot) Raw(method string, payload interface{}) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Raw(method, payload)
+}
+
+func (t *swappableTelebot) Notify(to telebot.Recipient, action telebot.ChatAction) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Notify(to, action)
+}
+
+func (t *swappableTelebot) Delete(msg telebot.Editable) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Delete(msg)
+}
+
+func (t *swappableTelebot) Respond(c *telebot.Callback, resp ...*telebot.CallbackResponse) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Respond(c, resp...)
+}
+
+func (t *swappableTelebot) ChatByID(id string) (*telebot.Chat, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.ChatByID(id)
+}
+
+func (t *swappableTelebot) EditReplyMarkup(msg telebot.Editable, markup *telebot.ReplyMarkup) (*telebot.Message, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.EditReplyMarkup(msg, markup)
+}
+
+func (t *swappableTelebot) Edit(msg telebot.Editable, what interface{}, options ...interface{}) (*telebot.Message, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Edit(msg, what, options...)
+}
+
+func (t *swappableTelebot) Forward(to telebot.Recipient, msg telebot.Editable, options ...interface{}) (*telebot.Message, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Forward(to, msg, options...)
+}
+
+func (t *swappableTelebot) Pin(msg telebot.Editable, options ...interface{}) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Pin(msg, options...)
+}
+
+func (t *swappableTelebot) Unpin(chat *telebot.Chat) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Unpin(chat)
+}
+
+func (t *swappableTelebot) Answer(query *telebot.Query, resp *telebot.QueryResponse) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Answer(query, resp)
+}
+
+func (t *swappableTelebot) Me() *telebot.User {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current.Me()
+}
+
+// dialTelegram creates a Telebot with the token, getting the updates from the webhook if it's set and polling
+// Telegram otherwise. Creating it checks the token with Telegram.
+func dialTelegram(token string, updates *telebot.Webhook) (Telebot, error) {
+	var poller telebot.Poller = &telebot.LongPoller{Timeout: 10 * time.Second}
+	if updates != nil {
+		poller = updates
+	}
+	bot, err := telebot.NewBot(telebot.Settings{
+		Token:  token,
+		Poller: poller,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return telebotBot{bot}, nil
+}
+
+// RotateToken switches the bot to a new Telegram token without stopping it. The token is checked with Telegram
+// and has to be of the same bot. Sends wait while the new token is swapped in, webhooks keep being queued,
+// and polling continues with the new token. If anything fails, the old token stays in use.
+func (b *Bot) RotateToken(ctx context.Context, token string) error {
+	token = strings.TrimSpace(token)
+	switch {
+	case token == "":
+		return ErrEmptyToken
+	case b.newTelebot == nil:
+		return errors.New("the bot wasn't created with a token, it can't rotate it")
+	case b.updatesWebhook != nil:
+		return errRotateWebhook
+	}
+
+	next, err := b.newTelebot(token)
+	if err != nil {
+		// The errors of requests to Telegram have the token in their URL.
+		return fmt.Errorf("failed to check the new token with Telegram: %s", strings.ReplaceAll(err.Error(), token, "<token>"))
+	}
+	old, me := b.telegram.Me(), next.Me()
+	if old != nil && (me == nil || me.ID != old.ID) {
+		return fmt.Errorf("the new token isn't of %s", senderName(old))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.swappable.swap(next)
+	level.Info(b.logger).Log("msg", "rotated the Telegram token")
+	return nil
+}
+
+// tokenRequest is what TokenHandler takes.
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// TokenHandler rotates the Telegram token to the one posted as {"token": "..."}. It changes what the bot
+// runs as, so it must only be served behind BearerAuth with a token.
+func (b *Bot) TokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			b.writeAPI(w, http.StatusMethodNotAllowed, apiError{Error: "only POST is allowed"})
+			return
+		}
+		var req tokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			b.writeAPI(w, http.StatusBadRequest, apiError{Error: "the body must be {\"token\": \"...\"}"})
+			return
+		}
+		if err := b.RotateToken(r.Context(), req.Token); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to rotate the Telegram token", "err", err)
+			b.writeAPI(w, http.StatusBadRequest, apiError{Error: err.Error()})
+			return
+		}
+		b.writeAPI(w, http.StatusOK, struct {
+			Rotated bool `json:"rotated"`
+		}{Rotated: true})
+	})
+}
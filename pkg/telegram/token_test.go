@@ -0,0 +1,141 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// rotatingBot returns a bot on the old Telebot, rotating to next with the token "new-token".
+func rotatingBot(t *testing.T) (b *Bot, old *fakeTelebot, next *fakeTelebot) {
+	t.Helper()
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	old = &fakeTelebot{me: &telebot.User{ID: 42, Username: "alerts_bot"}}
+	next = &fakeTelebot{me: &telebot.User{ID: 42, Username: "alerts_bot"}}
+	b, err = NewBotWithTelegram(chats, old, testAdmin.ID)
+	require.NoError(t, err)
+	b.newTelebot = func(token string) (Telebot, error) {
+		switch token {
+		case "new-token":
+			return next, nil
+		case "other-bot":
+			return &fakeTelebot{me: &telebot.User{ID: 43, Username: "other_bot"}}, nil
+		}
+		return nil, errors.New(`Post "https://api.telegram.org/bot` + token + `/getMe": dial tcp: i/o timeout`)
+	}
+	return b, old, next
+}
+
+func TestRotateToken(t *testing.T) {
+	b, old, next := rotatingBot(t)
+	b.handlersOnce.Do(b.registerHandlers)
+
+	polling := make(chan struct{})
+	go func() {
+		b.telegram.Start()
+		close(polling)
+	}()
+	require.Eventually(t, func() bool {
+		old.mu.Lock()
+		defer old.mu.Unlock()
+		return old.starts == 1
+	}, time.Second, time.Millisecond)
+
+	// Sends keep going while the token is rotated, each goes to one of the Telebots.
+	const sends = 200
+	old.beforeSend = func() { time.Sleep(time.Millisecond) }
+	var wg sync.WaitGroup
+	errs := make([]error, sends)
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = b.telegram.Send(testChat, "HighCPU")
+		}(i)
+		if i == sends/2 {
+			require.NoError(t, b.RotateToken(context.Background(), " new-token\n"))
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Len(t, append(old.messages(), next.messages()...), sends, "no send is lost")
+	require.NotEmpty(t, next.messages())
+
+	_, err := b.telegram.Send(testChat, "DiskFull")
+	require.NoError(t, err)
+	require.Equal(t, "DiskFull", next.lastText())
+	require.Len(t, next.handlers, len(old.handlers), "the handlers are registered again")
+
+	require.Eventually(t, func() bool {
+		next.mu.Lock()
+		defer next.mu.Unlock()
+		return next.starts == 1
+	}, time.Second, time.Millisecond, "polling goes on with the new token")
+	b.telegram.Stop()
+	select {
+	case <-polling:
+	case <-time.After(time.Second):
+		t.Fatal("polling didn't stop")
+	}
+}
+
+func TestRotateTokenFailures(t *testing.T) {
+	b, old, next := rotatingBot(t)
+
+	require.Equal(t, ErrEmptyToken, b.RotateToken(context.Background(), " "))
+	err := b.RotateToken(context.Background(), "123:secret")
+	require.EqualError(t, err, `failed to check the new token with Telegram: Post "https://api.telegram.org/bot<token>/getMe": dial tcp: i/o timeout`)
+	require.EqualError(t, b.RotateToken(context.Background(), "other-bot"), "the new token isn't of @alerts_bot (42)")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, b.RotateToken(ctx, "new-token"))
+
+	_, err = b.telegram.Send(testChat, "HighCPU")
+	require.NoError(t, err)
+	require.Len(t, old.messages(), 1, "the old token stays in use")
+	require.Empty(t, next.messages())
+
+	b.updatesWebhook = &telebot.Webhook{}
+	require.Equal(t, errRotateWebhook, b.RotateToken(context.Background(), "new-token"))
+	b.newTelebot = nil
+	b.updatesWebhook = nil
+	require.Error(t, b.RotateToken(context.Background(), "new-token"))
+}
+
+func TestTokenHandler(t *testing.T) {
+	b, _, next := rotatingBot(t)
+	h := b.TokenHandler()
+	post := func(body string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, TokenPath, bytes.NewBufferString(body)))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := post(`{"token": "123:secret"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.NotContains(t, body, "secret")
+	code, _ = post(`token=new-token`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body = post(`{"token": "new-token"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"rotated": true}`, body)
+	_, err := b.telegram.Send(testChat, "HighCPU")
+	require.NoError(t, err)
+	require.Len(t, next.messages(), 1)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, TokenPath, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
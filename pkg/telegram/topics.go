@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// routeTopicKey marks the context of deliveries routed to a forum topic, with the routeTopic.
+type routeTopicKey struct{}
+
+// routeTopic is the forum topic of a chat that a delivery goes to.
+type routeTopic struct {
+	chatID  int64
+	topicID int
+}
+
+// withRouteTopic returns a context whose deliveries to the chat go to its forum topic.
+func withRouteTopic(ctx context.Context, chatID int64, topicID int) context.Context {
+	return context.WithValue(ctx, routeTopicKey{}, routeTopic{chatID: chatID, topicID: topicID})
+}
+
+// routeTopicOf returns the forum topic deliveries to the chat go to, 0 for the chat itself.
+// Deliveries to other chats, like its fallback chat, don't go to the topic.
+func routeTopicOf(ctx context.Context, chatID int64) int {
+	t, ok := ctx.Value(routeTopicKey{}).(routeTopic)
+	if !ok || t.chatID != chatID {
+		return 0
+	}
+	return t.topicID
+}
+
+// sendToTopic sends the text to a forum topic of the chat, like send. telebot v2 predates forum topics and its
+// send options have no message_thread_id, so the message is sent with a raw sendMessage.
+func (b *Bot) sendToTopic(to *telebot.Chat, topicID int, text string, origin string, options *telebot.SendOptions) (*telebot.Message, error) {
+	params := map[string]interface{}{
+		"chat_id":           to.Recipient(),
+		"message_thread_id": topicID,
+		"text":              text,
+	}
+	if options.ParseMode != telebot.ModeDefault {
+		params["parse_mode"] = options.ParseMode
+	}
+	if options.DisableWebPagePreview {
+		params["disable_web_page_preview"] = true
+	}
+	if options.DisableNotification {
+		params["disable_notification"] = true
+	}
+	if options.ReplyMarkup != nil {
+		params["reply_markup"] = rawReplyMarkup(options.ReplyMarkup)
+	}
+
+	data, err := b.telegram.Raw("sendMessage", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result *telebot.Message
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	b.recordSent(resp.Result, text, origin)
+	return resp.Result, nil
+}
+
+// rawReplyMarkup returns a copy of the markup whose buttons carry their callback data like telebot sends them,
+// "\f<unique>|<data>", so the callbacks of buttons sent raw reach their handlers.
+func rawReplyMarkup(markup *telebot.ReplyMarkup) *telebot.ReplyMarkup {
+	raw := *markup
+	raw.InlineKeyboard = make([][]telebot.InlineButton, len(markup.InlineKeyboard))
+	for i, row := range markup.InlineKeyboard {
+		raw.InlineKeyboard[i] = append([]telebot.InlineButton(nil), row...)
+		for j := range raw.InlineKeyboard[i] {
+			button := &raw.InlineKeyboard[i][j]
+			if button.Unique == "" {
+				continue
+			}
+			if button.Data == "" {
+				button.Data = "\f" + button.Unique
+			} else {
+				button.Data = "\f" + button.Unique + "|" + button.Data
+			}
+		}
+	}
+	return &raw
+}
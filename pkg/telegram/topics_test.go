@@ -0,0 +1,22 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestRawReplyMarkup(t *testing.T) {
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{
+		{Unique: "show_all", Text: "Show all", Data: "42"},
+		{Unique: "ack", Text: "Ack"},
+		{Text: "Runbook", URL: "https://runbooks.example.com"},
+	}}}
+
+	raw := rawReplyMarkup(markup)
+	require.Equal(t, "\fshow_all|42", raw.InlineKeyboard[0][0].Data)
+	require.Equal(t, "\fack", raw.InlineKeyboard[0][1].Data)
+	require.Equal(t, "", raw.InlineKeyboard[0][2].Data)
+	require.Equal(t, "42", markup.InlineKeyboard[0][0].Data, "the markup sent to other chats is left alone")
+}
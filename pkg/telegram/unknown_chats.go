@@ -0,0 +1,374 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandUnknownChats = "/unknown_chats"
+
+	// telegramUnknownChatsKey keeps the webhooks for unsubscribed chats in the store, so they survive restarts.
+	telegramUnknownChatsKey = "telegram/unknown_chats"
+
+	unknownChatSubscribeUnique = "unknown_chat_subscribe"
+
+	defaultUnknownChatThreshold = 50
+	defaultUnknownChatCooldown  = 6 * time.Hour
+	// unknownChatWindow is how far back the webhooks are counted against the threshold.
+	unknownChatWindow = time.Hour
+	// unknownChatMemory is how long a chat is remembered after its last webhook.
+	unknownChatMemory = 7 * 24 * time.Hour
+	// unknownChatsPersistInterval is how often changes are written to the store.
+	unknownChatsPersistInterval = time.Minute
+	// maxUnknownChatButtons is how many chats /unknown_chats offers to subscribe.
+	maxUnknownChatButtons = 10
+)
+
+// WithUnknownChatAlerting tells the admins when more than threshold webhooks arrived for a chat that isn't
+// subscribed within an hour, at most once per cooldown for each chat. A threshold of 0 never tells them,
+// the webhooks are still counted for /unknown_chats.
+func WithUnknownChatAlerting(threshold int, cooldown time.Duration) BotOption {
+	return func(b *Bot) error {
+		if threshold < 0 {
+			return fmt.Errorf("the threshold of webhooks for unsubscribed chats must not be negative, is %d", threshold)
+		}
+		if cooldown < 0 {
+			return fmt.Errorf("the cooldown of notices about unsubscribed chats must not be negative, is %s", cooldown)
+		}
+		b.unknownChats.threshold = threshold
+		b.unknownChats.cooldown = cooldown
+		return nil
+	}
+}
+
+// UnknownChat are the webhooks that arrived for a chat that isn't subscribed.
+type UnknownChat struct {
+	ChatID int64
+	// Receiver is the Alertmanager receiver of the last of them, naming the route that sends them.
+	Receiver  string `json:",omitempty"`
+	Total     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Minutes counts the webhooks of the last hour by the minute they arrived in.
+	Minutes []unknownChatMinute `json:",omitempty"`
+	// NotifiedAt is when the admins were last told about the chat.
+	NotifiedAt time.Time `json:",omitempty"`
+}
+
+type unknownChatMinute struct {
+	At    time.Time
+	Count int
+}
+
+// LastHour counts the webhooks within the hour before now.
+func (c UnknownChat) LastHour(now time.Time) int {
+	n := 0
+	for _, m := range c.Minutes {
+		if now.Sub(m.At) < unknownChatWindow {
+			n += m.Count
+		}
+	}
+	return n
+}
+
+// clone copies the chat, so that the copy isn't changed by later webhooks.
+func (c *UnknownChat) clone() UnknownChat {
+	cp := *c
+	cp.Minutes = append([]unknownChatMinute(nil), c.Minutes...)
+	return cp
+}
+
+// GetUnknownChats returns the stored webhooks for unsubscribed chats.
+func (s *ChatStore) GetUnknownChats() ([]UnknownChat, error) {
+	kv, err := s.get(telegramUnknownChatsKey, errKeyMissing)
+	if err != nil {
+		if err == errKeyMissing {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var chats []UnknownChat
+	if err := decode(telegramUnknownChatsKey, kv.Value, &chats); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+// SetUnknownChats stores the webhooks for unsubscribed chats, replacing those stored.
+func (s *ChatStore) SetUnknownChats(chats []UnknownChat) error {
+	value, err := json.Marshal(chats)
+	if err != nil {
+		return err
+	}
+	return s.put(telegramUnknownChatsKey, value)
+}
+
+// unknownChats counts the webhooks for unsubscribed chats by chat, in memory and written to the store
+// every unknownChatsPersistInterval.
+type unknownChats struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	chats map[int64]*UnknownChat
+	// dirty tells if chats changed since they were last written to the store.
+	dirty bool
+}
+
+func newUnknownChats() *unknownChats {
+	return &unknownChats{
+		threshold: defaultUnknownChatThreshold,
+		cooldown:  defaultUnknownChatCooldown,
+		chats:     map[int64]*UnknownChat{},
+	}
+}
+
+// record counts a webhook for the chat. It returns the chat and true if the admins are to be told about it,
+// which is once it crossed the threshold and they weren't told within the cooldown.
+func (u *unknownChats) record(chatID int64, receiver string, now time.Time) (UnknownChat, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.dirty = true
+
+	c, ok := u.chats[chatID]
+	if !ok {
+		c = &UnknownChat{ChatID: chatID, FirstSeen: now}
+		u.chats[chatID] = c
+	}
+	c.Total++
+	c.LastSeen = now
+	if receiver != "" {
+		c.Receiver = receiver
+	}
+	minute := now.Truncate(time.Minute)
+	kept := c.Minutes[:0]
+	for _, m := range c.Minutes {
+		if now.Sub(m.At) < unknownChatWindow {
+			kept = append(kept, m)
+		}
+	}
+	c.Minutes = kept
+	if n := len(c.Minutes); n > 0 && c.Minutes[n-1].At.Equal(minute) {
+		c.Minutes[n-1].Count++
+	} else {
+		c.Minutes = append(c.Minutes, unknownChatMinute{At: minute, Count: 1})
+	}
+
+	if u.threshold == 0 || c.LastHour(now) <= u.threshold {
+		return UnknownChat{}, false
+	}
+	if !c.NotifiedAt.IsZero() && now.Sub(c.NotifiedAt) < u.cooldown {
+		return UnknownChat{}, false
+	}
+	c.NotifiedAt = now
+	return c.clone(), true
+}
+
+// list returns the chats with the most webhooks in the last hour first, forgetting those without webhooks
+// for longer than unknownChatMemory.
+func (u *unknownChats) list(now time.Time) []UnknownChat {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.listLocked(now)
+}
+
+func (u *unknownChats) listLocked(now time.Time) []UnknownChat {
+	chats := make([]UnknownChat, 0, len(u.chats))
+	for id, c := range u.chats {
+		if now.Sub(c.LastSeen) > unknownChatMemory {
+			delete(u.chats, id)
+			u.dirty = true
+			continue
+		}
+		chats = append(chats, c.clone())
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		if hi, hj := chats[i].LastHour(now), chats[j].LastHour(now); hi != hj {
+			return hi > hj
+		}
+		return chats[i].LastSeen.After(chats[j].LastSeen)
+	})
+	return chats
+}
+
+// forget drops the chat, once it's subscribed.
+func (u *unknownChats) forget(chatID int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.chats[chatID]; ok {
+		delete(u.chats, chatID)
+		u.dirty = true
+	}
+}
+
+// load adds the stored chats that aren't counted in memory yet.
+func (u *unknownChats) load(stored []UnknownChat) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range stored {
+		if _, ok := u.chats[stored[i].ChatID]; !ok {
+			c := stored[i]
+			u.chats[c.ChatID] = &c
+		}
+	}
+}
+
+// takeDirty returns the chats to store and true if they changed since they were last taken.
+func (u *unknownChats) takeDirty(now time.Time) ([]UnknownChat, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.dirty {
+		return nil, false
+	}
+	chats := u.listLocked(now)
+	u.dirty = false
+	return chats, true
+}
+
+// recordUnknownChat counts a webhook for a chat that isn't subscribed, telling the admins once there are too many.
+func (b *Bot) recordUnknownChat(w alertmanager.TelegramWebhook, now time.Time) {
+	c, notify := b.unknownChats.record(w.ChatID, w.Message.Receiver, now)
+	if !notify {
+		return
+	}
+	route := "the routes"
+	if c.Receiver != "" {
+		route = fmt.Sprintf("route '%s'", c.Receiver)
+	}
+	text := fmt.Sprintf("Receiving webhooks for unsubscribed chat %d (%d in the last hour) — check %s in Alertmanager", c.ChatID, c.LastHour(now), route)
+	markup := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{{unknownChatButton(c.ChatID)}}}
+	for _, admin := range b.admins {
+		if _, err := b.send(&telebot.User{ID: admin}, text, "unknown chat", &telebot.SendOptions{ReplyMarkup: markup}); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to tell admin about webhooks for unsubscribed chat", "admin_id", admin, "chat_id", c.ChatID, "err", err)
+		}
+	}
+}
+
+func unknownChatButton(chatID int64) telebot.InlineButton {
+	return telebot.InlineButton{Unique: unknownChatSubscribeUnique, Text: fmt.Sprintf("Subscribe %d", chatID), Data: strconv.FormatInt(chatID, 10)}
+}
+
+// persistUnknownChats writes the webhooks for unsubscribed chats to the store if they changed.
+func (b *Bot) persistUnknownChats(now time.Time) {
+	chats, dirty := b.unknownChats.takeDirty(now)
+	if !dirty || !b.leading() {
+		return
+	}
+	if err := b.chats.SetUnknownChats(chats); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to store webhooks for unsubscribed chats", "err", err)
+	}
+}
+
+// runUnknownChats loads the stored webhooks for unsubscribed chats and writes the changes back
+// until the context is done.
+func (b *Bot) runUnknownChats(ctx context.Context) {
+	stored, err := b.chats.GetUnknownChats()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to load webhooks for unsubscribed chats", "err", err)
+	}
+	b.unknownChats.load(stored)
+
+	ticker := time.NewTicker(unknownChatsPersistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.persistUnknownChats(time.Now())
+			return
+		case now := <-ticker.C:
+			b.persistUnknownChats(now)
+		}
+	}
+}
+
+func (b *Bot) handleUnknownChats(message *telebot.Message) error {
+	now := time.Now()
+	var lines []string
+	var buttons [][]telebot.InlineButton
+	for _, c := range b.unknownChats.list(now) {
+		line := fmt.Sprintf("%d: %d in the last hour, %d since %s, last at %s", c.ChatID, c.LastHour(now), c.Total,
+			c.FirstSeen.UTC().Format("2006-01-02 15:04 MST"), c.LastSeen.UTC().Format("2006-01-02 15:04 MST"))
+		if c.Receiver != "" {
+			line += fmt.Sprintf(", receiver '%s'", c.Receiver)
+		}
+		lines = append(lines, line)
+		if len(buttons) < maxUnknownChatButtons {
+			buttons = append(buttons, []telebot.InlineButton{unknownChatButton(c.ChatID)})
+		}
+	}
+	if len(lines) == 0 {
+		_, err := b.reply(message, "No webhooks arrived for unsubscribed chats.")
+		return err
+	}
+	text := "Webhooks for unsubscribed chats:\n" + strings.Join(lines, "\n")
+	_, err := b.reply(message, text, &telebot.SendOptions{ReplyMarkup: &telebot.ReplyMarkup{InlineKeyboard: buttons}})
+	return err
+}
+
+// handleUnknownChatSubscribe subscribes a chat webhooks arrived for, to all environments and projects like /start.
+func (b *Bot) handleUnknownChatSubscribe(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can subscribe chats.")
+		return
+	}
+	chatID, err := strconv.ParseInt(c.Data, 10, 64)
+	if err != nil {
+		respond("That's not a chat.")
+		return
+	}
+	if _, err := b.chats.GetChatInfo(chatID); err == nil {
+		b.unknownChats.forget(chatID)
+		respond(fmt.Sprintf("Chat %d is subscribed already.", chatID))
+		return
+	} else if !errors.Is(err, ErrChatNotFound) {
+		respond(b.storeErrorReply(err, "check the chat"))
+		return
+	}
+	chat, err := b.telegram.ChatByID(c.Data)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to get unsubscribed chat from Telegram", "chat_id", chatID, "err", err)
+		respond(fmt.Sprintf("Telegram doesn't know chat %d or the bot isn't in it.", chatID))
+		return
+	}
+	if err := b.chats.AddChat(chat, b.environmentsAndOther, b.projectsAndOther); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to add chat to chat store", "chat_id", chatID, "err", err)
+		respond(b.storeErrorReply(err, "subscribe the chat"))
+		return
+	}
+	level.Info(b.logger).Log("msg", "subscribed chat webhooks arrived for", "chat_id", chatID, "sender_id", c.Sender.ID)
+	b.unknownChats.forget(chatID)
+	respond("")
+
+	if _, err := b.telegram.EditReplyMarkup(c.Message, &telebot.ReplyMarkup{}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to remove subscribe button", "err", err)
+	}
+	if _, err := b.send(c.Message.Chat, fmt.Sprintf("Subscribed chat %s to all environments and projects.", chatName(chat)), "button subscribe unknown chat"); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send subscribe result", "err", err)
+	}
+}
@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestUnknownChatsRecord(t *testing.T) {
+	u := newUnknownChats()
+	u.threshold, u.cooldown = 3, 2*time.Hour
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		_, notify := u.record(-1009876, "payments", now.Add(time.Duration(i)*time.Minute))
+		require.False(t, notify, "not above the threshold yet")
+	}
+	c, notify := u.record(-1009876, "", now.Add(10*time.Minute))
+	require.True(t, notify)
+	require.Equal(t, 4, c.LastHour(now.Add(10*time.Minute)))
+	require.Equal(t, "payments", c.Receiver, "the last receiver is kept for webhooks without one")
+	_, notify = u.record(-1009876, "payments", now.Add(11*time.Minute))
+	require.False(t, notify, "the admins were told within the cooldown")
+
+	later := now.Add(3 * time.Hour)
+	for i := 0; i < 3; i++ {
+		_, notify = u.record(-1009876, "payments", later)
+		require.False(t, notify, "the webhooks of the hours before don't count")
+	}
+	c, notify = u.record(-1009876, "payments", later)
+	require.True(t, notify)
+	require.Equal(t, 9, c.Total)
+	require.Equal(t, now, c.FirstSeen)
+
+	u.record(-200, "", later.Add(-time.Minute))
+	chats := u.list(later)
+	require.Len(t, chats, 2)
+	require.Equal(t, int64(-1009876), chats[0].ChatID, "the chat with the most webhooks in the last hour comes first")
+	require.Empty(t, u.list(later.Add(unknownChatMemory+time.Minute)), "chats are forgotten a while after their last webhook")
+}
+
+func TestUnknownChatNotice(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithUnknownChatAlerting(2, time.Hour))
+	w := alertmanager.TelegramWebhook{ChatID: -1009876, Message: webhook.Message{Data: &template.Data{Receiver: "payments", Status: "firing"}}}
+	for i := 0; i < 4; i++ {
+		_, err := b.processWebhook(context.Background(), w)
+		require.NoError(t, err)
+	}
+	require.Len(t, tb.messages(), 1, "the admins are told once")
+	require.Equal(t, "Receiving webhooks for unsubscribed chat -1009876 (3 in the last hour) — check route 'payments' in Alertmanager", tb.lastText())
+	require.Equal(t, []string{"-1009876"}, buttons(tb.messages()[0]))
+
+	require.Error(t, WithUnknownChatAlerting(-1, time.Hour)(b))
+}
+
+func TestHandleUnknownChats(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, b.handleUnknownChats(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandUnknownChats}))
+	require.Equal(t, "No webhooks arrived for unsubscribed chats.", tb.lastText())
+
+	now := time.Now()
+	b.unknownChats.record(-1009876, "payments", now)
+	b.unknownChats.record(-1009876, "payments", now)
+	require.NoError(t, b.handleUnknownChats(&telebot.Message{Sender: testAdmin, Chat: testChat, Text: CommandUnknownChats}))
+	require.Contains(t, tb.lastText(), "Webhooks for unsubscribed chats:\n-1009876: 2 in the last hour, 2 since ")
+	require.Contains(t, tb.lastText(), ", receiver 'payments'")
+	require.Equal(t, []string{"-1009876"}, buttons(tb.messages()[len(tb.messages())-1]))
+
+	b.handleUnknownChatSubscribe(&telebot.Callback{Sender: &telebot.User{ID: 999}, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: "-1009876"})
+	require.Equal(t, "Only admins can subscribe chats.", tb.responses[0].Text)
+	_, err := chats.GetChatInfo(-1009876)
+	require.ErrorIs(t, err, ErrChatNotFound)
+
+	b.handleUnknownChatSubscribe(&telebot.Callback{Sender: testAdmin, Message: &telebot.Message{ID: 1, Chat: testChat}, Data: "-1009876"})
+	require.Equal(t, "Subscribed chat -1009876 to all environments and projects.", tb.lastText())
+	ci, err := chats.GetChatInfo(-1009876)
+	require.NoError(t, err)
+	require.ElementsMatch(t, b.environmentsAndOther, ci.AlertEnvironments)
+	require.Empty(t, b.unknownChats.list(now), "subscribed chats are forgotten")
+}
+
+func TestPersistUnknownChats(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	b.unknownChats.record(-1009876, "payments", now)
+	b.persistUnknownChats(now)
+
+	stored, err := chats.GetUnknownChats()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	require.Equal(t, 1, stored[0].LastHour(now))
+
+	restarted, _, _ := newTestBot(t)
+	restarted.unknownChats.load(stored)
+	c, _ := restarted.unknownChats.record(-1009876, "payments", now.Add(time.Minute))
+	require.Zero(t, c.Total, "nothing is returned without a notice")
+	require.Equal(t, 2, restarted.unknownChats.list(now.Add(time.Minute))[0].Total, "the counts go on after a restart")
+}
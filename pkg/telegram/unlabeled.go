@@ -0,0 +1,156 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+// The policies for alerts without an environment or project label.
+const (
+	// UnlabeledOther delivers them as if the labels were "other", like alerts of environments and projects that
+	// aren't configured.
+	UnlabeledOther = "other"
+	// UnlabeledDrop drops them.
+	UnlabeledDrop = "drop"
+	// UnlabeledAdmin delivers them to the catch-all chat of WithUnlabeledChat only.
+	UnlabeledAdmin = "admin"
+	// UnlabeledTag delivers them as usual, with a warning above the message.
+	UnlabeledTag = "tag"
+)
+
+// WithUnlabeledPolicy sets what happens to alerts without an environment or project label, see the Unlabeled policies.
+// The policy applies before mutes, so that an alert a chat doesn't get can't be muted there either.
+func WithUnlabeledPolicy(policy string) BotOption {
+	return func(b *Bot) error {
+		switch policy {
+		case UnlabeledOther, UnlabeledDrop, UnlabeledAdmin, UnlabeledTag:
+		default:
+			return fmt.Errorf("unknown policy %q for unlabeled alerts, use %s, %s, %s or %s", policy, UnlabeledOther, UnlabeledDrop, UnlabeledAdmin, UnlabeledTag)
+		}
+		b.unlabeledPolicy = policy
+		return nil
+	}
+}
+
+// WithUnlabeledChat sets the chat getting the alerts without an environment or project label with the admin policy.
+// The chat has to be subscribed. It gets the alerts once for every chat they were sent to.
+func WithUnlabeledChat(chatID int64) BotOption {
+	return func(b *Bot) error {
+		b.unlabeledChat = chatID
+		return nil
+	}
+}
+
+// missingLabels returns which of the environment and project labels the alert doesn't have.
+func missingLabels(a template.Alert) []string {
+	var missing []string
+	for _, l := range []string{labelEnvironment, labelProject} {
+		if a.Labels[l] == "" {
+			missing = append(missing, l)
+		}
+	}
+	return missing
+}
+
+// unlabeledTag is the warning above messages with alerts missing the labels.
+func unlabeledTag(missing []string) string {
+	if len(missing) == 1 {
+		return fmt.Sprintf("⚠️ missing %s label", missing[0])
+	}
+	return fmt.Sprintf("⚠️ missing %s labels", strings.Join(missing, " and "))
+}
+
+// applyUnlabeledPolicy keeps the alerts the chat gets under the unlabeled policy. With the admin policy it returns
+// the unlabeled alerts to forward to the catch-all chat, with the tag policy the warning to put above the message.
+func (b *Bot) applyUnlabeledPolicy(chatID int64, alerts template.Alerts) (kept template.Alerts, forward template.Alerts, tag string) {
+	kept = make(template.Alerts, 0, len(alerts))
+	var missing []string
+	for _, a := range alerts {
+		m := missingLabels(a)
+		if len(m) == 0 {
+			kept = append(kept, a)
+			continue
+		}
+
+		switch b.unlabeledPolicy {
+		case UnlabeledDrop:
+			b.unlabeledCounter.WithLabelValues("dropped").Inc()
+		case UnlabeledAdmin:
+			if chatID == b.unlabeledChat {
+				b.unlabeledCounter.WithLabelValues("catch_all").Inc()
+				kept = append(kept, a)
+				continue
+			}
+			// Counted once the catch-all chat gets it.
+			forward = append(forward, a)
+		case UnlabeledTag:
+			b.unlabeledCounter.WithLabelValues("tagged").Inc()
+			for _, l := range m {
+				if !contains(missing, l) {
+					missing = append(missing, l)
+				}
+			}
+			kept = append(kept, a)
+		default:
+			b.unlabeledCounter.WithLabelValues("other").Inc()
+			kept = append(kept, a)
+		}
+	}
+	if len(missing) > 0 {
+		// Keep the order the same whichever alert was missing what.
+		if len(missing) == 2 && missing[0] != labelEnvironment {
+			missing[0], missing[1] = missing[1], missing[0]
+		}
+		tag = unlabeledTag(missing)
+	}
+	return kept, forward, tag
+}
+
+// forwardUnlabeled delivers the unlabeled alerts of the chat's webhook to the catch-all chat.
+// A failure is logged and counted, the alerts aren't forwarded again.
+func (b *Bot) forwardUnlabeled(ctx context.Context, logger log.Logger, w alertmanager.TelegramWebhook, ci *ChatInfo, alerts template.Alerts) {
+	data := *w.Message.Data
+	data.Alerts = alerts
+	data.Status = "resolved"
+	if len(alerts.Firing()) > 0 {
+		data.Status = "firing"
+	}
+	forward := alertmanager.TelegramWebhook{
+		ChatID:  b.unlabeledChat,
+		Message: webhook.Message{Data: &data, Version: w.Message.Version, GroupKey: w.Message.GroupKey},
+	}
+
+	level.Info(logger).Log("msg", "forwarding alerts without environment or project label", "count", len(alerts), "catch_all_chat_id", b.unlabeledChat)
+	header := fmt.Sprintf("⚠️ delivered here because they have no %s or %s label, they were sent to %s\n\n", labelEnvironment, labelProject, html.EscapeString(chatName(ci.Chat)))
+	d, err := b.deliverWebhook(ctx, forward, header)
+	if err == nil {
+		err = d.Failed
+	}
+	if err != nil {
+		// The chat still gets its own alerts, failing to forward the others mustn't hold them up.
+		level.Warn(logger).Log("msg", "failed to forward alerts without environment or project label", "err", err)
+		b.unlabeledCounter.WithLabelValues("forward_failed").Add(float64(len(alerts)))
+	}
+}
+
+// unlabeledStatus tells how alerts without an environment or project label are handled, for /status.
+func (b *Bot) unlabeledStatus() string {
+	switch b.unlabeledPolicy {
+	case UnlabeledDrop:
+		return "dropped"
+	case UnlabeledAdmin:
+		return fmt.Sprintf("sent to chat %d only", b.unlabeledChat)
+	case UnlabeledTag:
+		return "delivered with a warning"
+	default:
+		return "delivered as other"
+	}
+}
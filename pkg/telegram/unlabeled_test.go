@@ -0,0 +1,186 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// unlabeledWebhook has a labelled alert and alerts missing the project, the environment and both labels.
+func unlabeledWebhook(chatID int64) alertmanager.TelegramWebhook {
+	alert := func(name string, labels template.KV) template.Alert {
+		labels["alertname"] = name
+		return template.Alert{Status: "firing", Labels: labels, Fingerprint: name}
+	}
+	return alertmanager.TelegramWebhook{ChatID: chatID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			alert("Labelled", template.KV{labelEnvironment: "prod", labelProject: "billing"}),
+			alert("NoProject", template.KV{labelEnvironment: "prod"}),
+			alert("NoEnvironment", template.KV{labelProject: "billing"}),
+			alert("NoLabels", template.KV{}),
+		},
+	}}}
+}
+
+func unlabeledCount(b *Bot, outcome string) float64 {
+	return testutil.ToFloat64(b.unlabeledCounter.WithLabelValues(outcome))
+}
+
+func TestUnlabeledOther(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	before := unlabeledCount(b, "other")
+
+	_, err := b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	text := tb.lastText()
+	for _, name := range []string{"Labelled", "NoProject", "NoEnvironment", "NoLabels"} {
+		require.Contains(t, text, name)
+	}
+	require.NotContains(t, text, "⚠️")
+	require.Equal(t, float64(3), unlabeledCount(b, "other")-before)
+
+	// Muting other mutes the unlabeled alerts.
+	require.NoError(t, chats.MuteEnvironments(testChat, []string{"other"}, b.environmentsAndOther))
+	_, err = b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	require.NotContains(t, tb.lastText(), "NoEnvironment")
+	require.NotContains(t, tb.lastText(), "NoLabels")
+}
+
+func TestUnlabeledDrop(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithUnlabeledPolicy(UnlabeledDrop))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	before := unlabeledCount(b, "dropped")
+
+	_, err := b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1)
+	text := tb.lastText()
+	require.Contains(t, text, "Labelled")
+	for _, name := range []string{"NoProject", "NoEnvironment", "NoLabels"} {
+		require.NotContains(t, text, name)
+	}
+	require.Equal(t, float64(3), unlabeledCount(b, "dropped")-before)
+
+	w := unlabeledWebhook(testChat.ID)
+	w.Message.Alerts = w.Message.Alerts[1:]
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1, "a webhook with unlabeled alerts only isn't sent")
+}
+
+func TestUnlabeledAdmin(t *testing.T) {
+	catchAll := &telebot.Chat{ID: -200, Title: "label-hygiene", Type: telebot.ChatGroup}
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithUnlabeledPolicy(UnlabeledAdmin), WithUnlabeledChat(catchAll.ID))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.AddChat(catchAll, b.environmentsAndOther, b.projectsAndOther))
+	before := unlabeledCount(b, "catch_all")
+
+	_, err := b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	msgs := tb.messages()
+	require.Len(t, msgs, 2)
+
+	require.Equal(t, "-200", msgs[0].to)
+	forwarded := msgs[0].text()
+	require.True(t, strings.HasPrefix(forwarded, "⚠️ delivered here because they have no environment or project label, they were sent to @elliot"), forwarded)
+	require.NotContains(t, forwarded, "Labelled")
+	for _, name := range []string{"NoProject", "NoEnvironment", "NoLabels"} {
+		require.Contains(t, forwarded, name)
+	}
+
+	require.Equal(t, "123", msgs[1].to)
+	require.Contains(t, msgs[1].text(), "Labelled")
+	require.NotContains(t, msgs[1].text(), "NoLabels")
+	require.Equal(t, float64(3), unlabeledCount(b, "catch_all")-before)
+
+	// The catch-all chat's own webhooks keep their unlabeled alerts.
+	_, err = b.processWebhook(context.Background(), unlabeledWebhook(catchAll.ID))
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 3)
+	require.Contains(t, tb.lastText(), "NoLabels")
+	require.NotContains(t, tb.lastText(), "⚠️ delivered here")
+}
+
+// chatFailingKV fails reading one chat, like a store failing for some of its keys.
+type chatFailingKV struct {
+	*memoryKV
+	chatID int64
+}
+
+func (k *chatFailingKV) Get(key string) (*store.KVPair, error) {
+	if key == chatKey(k.chatID) {
+		return nil, errors.New("Unexpected response code: 500")
+	}
+	return k.memoryKV.Get(key)
+}
+
+func TestUnlabeledAdminForwardFails(t *testing.T) {
+	catchAll := &telebot.Chat{ID: -200, Title: "label-hygiene", Type: telebot.ChatGroup}
+	chats, err := NewChatStore(&chatFailingKV{memoryKV: newMemoryKV(), chatID: catchAll.ID}, telegramChatsDirectory)
+	require.NoError(t, err)
+	tb := &fakeTelebot{}
+	b, err := NewBotWithTelegram(chats, tb, testAdmin.ID,
+		WithEnvironments("prod,staging"),
+		WithProjects("billing,frontend"),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithUnlabeledPolicy(UnlabeledAdmin),
+		WithUnlabeledChat(catchAll.ID),
+	)
+	require.NoError(t, err)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	before := unlabeledCount(b, "forward_failed")
+
+	_, err = b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	require.Len(t, tb.messages(), 1, "the chat gets its own alerts although forwarding the others failed")
+	require.Equal(t, "123", tb.messages()[0].to)
+	require.Contains(t, tb.lastText(), "Labelled")
+	require.Equal(t, float64(3), unlabeledCount(b, "forward_failed")-before)
+}
+
+func TestUnlabeledAdminNeedsChat(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+	_, err = NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID, WithUnlabeledPolicy(UnlabeledAdmin))
+	require.EqualError(t, err, "invalid options: the admin policy for unlabeled alerts needs a catch-all chat")
+
+	_, err = NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID, WithUnlabeledPolicy("ignore"))
+	require.Error(t, err)
+}
+
+func TestUnlabeledTag(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"), WithUnlabeledPolicy(UnlabeledTag))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	before := unlabeledCount(b, "tagged")
+
+	_, err := b.processWebhook(context.Background(), unlabeledWebhook(testChat.ID))
+	require.NoError(t, err)
+	text := tb.lastText()
+	require.True(t, strings.HasPrefix(text, "⚠️ missing environment and project labels\n\n"), text)
+	require.Contains(t, text, "NoLabels")
+	require.Equal(t, float64(3), unlabeledCount(b, "tagged")-before)
+
+	w := unlabeledWebhook(testChat.ID)
+	w.Message.Alerts = w.Message.Alerts[:2]
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(tb.lastText(), "⚠️ missing project label\n\n"), tb.lastText())
+
+	w.Message.Alerts = w.Message.Alerts[:1]
+	_, err = b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.NotContains(t, tb.lastText(), "⚠️")
+}
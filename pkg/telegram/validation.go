@@ -0,0 +1,180 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// ErrEmptyToken is the problem of a Bot created without a Telegram token.
+var ErrEmptyToken = errors.New("the Telegram token is empty")
+
+const (
+	// telegramDeleteLimit is how old messages bots can still delete.
+	telegramDeleteLimit = 48 * time.Hour
+	// maxWebhookWorkers is about how many messages a second Telegram takes from a bot, more workers only get it flooded.
+	maxWebhookWorkers = 30
+	// maxMaxAlerts is the most alerts a message may show in full, more never fit into a Telegram message.
+	maxMaxAlerts = 100
+	// minCheckInterval is the shortest interval Telegram and Alertmanager may be checked or polled at.
+	minCheckInterval = time.Second
+)
+
+// OptionsError lists every problem with the options a Bot was created with.
+type OptionsError struct {
+	Errs []error
+}
+
+func (e *OptionsError) Error() string {
+	if len(e.Errs) == 1 {
+		return "invalid options: " + e.Errs[0].Error()
+	}
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, "\n  - "+err.Error())
+	}
+	return fmt.Sprintf("%d invalid options:%s", len(e.Errs), strings.Join(msgs, ""))
+}
+
+// Is lets errors.Is find any of the problems.
+func (e *OptionsError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the options for consistency once they're all applied, so that their order doesn't matter,
+// and does what's too expensive to do for options that might turn out to be invalid anyway, like parsing templates.
+// Settings that are legal but likely not what was meant are logged.
+func (b *Bot) validate() []error {
+	var problems []error
+
+	for i, id := range b.admins {
+		if id <= 0 {
+			problems = append(problems, fmt.Errorf("admin ID %d is invalid, user IDs are positive", id))
+		}
+		// b.admins is sorted, so duplicates are next to each other.
+		if i > 0 && b.admins[i-1] == id {
+			problems = append(problems, fmt.Errorf("admin ID %d is given more than once", id))
+		}
+	}
+
+	for _, id := range b.globalAdmins {
+		if !b.isAdminID(id) {
+			problems = append(problems, fmt.Errorf("global admin %d isn't one of the admins", id))
+		}
+	}
+
+	if b.fetchPeriod < 0 {
+		problems = append(problems, fmt.Errorf("the fetch period must not be negative, is %gs", b.fetchPeriod))
+	}
+	if b.deletePeriod < 0 {
+		problems = append(problems, fmt.Errorf("the delete period must not be negative, is %gs", b.deletePeriod))
+	}
+	if b.maxTrackedMessages < 0 {
+		problems = append(problems, fmt.Errorf("the number of tracked messages must not be negative, is %d", b.maxTrackedMessages))
+	}
+	if b.reconcileGrace < 0 {
+		problems = append(problems, fmt.Errorf("the reconciliation grace period must not be negative, is %s", b.reconcileGrace))
+	}
+	if b.webhookWorkers > maxWebhookWorkers {
+		problems = append(problems, fmt.Errorf("the number of webhook workers must be at most %d, Telegram doesn't take more messages at once, is %d", maxWebhookWorkers, b.webhookWorkers))
+	}
+	if b.maxAlerts > maxMaxAlerts {
+		problems = append(problems, fmt.Errorf("max alerts per message must be at most %d, more don't fit into a message, is %d", maxMaxAlerts, b.maxAlerts))
+	}
+	if b.failoverProbeInterval < minCheckInterval {
+		problems = append(problems, fmt.Errorf("the failover probe interval must be at least %s, is %s", minCheckInterval, b.failoverProbeInterval))
+	}
+	for _, interval := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"poll interval", b.pollInterval},
+		{"silence sync interval", b.silenceSyncInterval},
+		{"cluster check interval", b.clusterCheckInterval},
+	} {
+		if interval.d > 0 && interval.d < minCheckInterval {
+			problems = append(problems, fmt.Errorf("the %s must be at least %s or 0 to turn it off, is %s", interval.name, minCheckInterval, interval.d))
+		}
+	}
+
+	if b.unlabeledPolicy == UnlabeledAdmin && b.unlabeledChat == 0 {
+		problems = append(problems, fmt.Errorf("the %s policy for unlabeled alerts needs a catch-all chat", UnlabeledAdmin))
+	}
+
+	if len(b.config.TemplatePaths) > 0 {
+		problems = append(problems, b.parseTemplates()...)
+	} else {
+		level.Warn(b.logger).Log("msg", "no templates given, alerts are rendered with the built-in template")
+		tmpl, err := parseBuiltinTemplate(b.templatesURL, b.templateFuncs())
+		if err != nil {
+			problems = append(problems, err)
+		}
+		b.templates = tmpl
+	}
+
+	if b.fetchPeriod > 0 && b.deletePeriod > 0 && b.deletePeriod < b.fetchPeriod {
+		level.Warn(b.logger).Log(
+			"msg", "old messages are only looked for every fetch period, so they're kept longer than the delete period",
+			"fetch_period", b.fetchPeriod,
+			"delete_period", b.deletePeriod,
+		)
+	}
+	if time.Duration(b.deletePeriod*float64(time.Second)) > telegramDeleteLimit {
+		level.Warn(b.logger).Log(
+			"msg", "Telegram doesn't let bots delete messages older than 48 hours, so old messages won't be deleted",
+			"delete_period", b.deletePeriod,
+		)
+	}
+	if b.suppressedAlerting && b.suppressed.label == labelSeverity && !b.severityOrdering.known(b.suppressed.value) {
+		level.Warn(b.logger).Log(
+			"msg", "the severity of critical alerts isn't one of the known severities",
+			"value", b.suppressed.value,
+			"known", b.severityOrdering.describe(),
+		)
+	}
+
+	return problems
+}
+
+// parseTemplates parses the templates of WithTemplates after the format presets, failing for globs that are bad
+// or match no files.
+func (b *Bot) parseTemplates() []error {
+	var problems []error
+	for _, glob := range b.config.TemplatePaths {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("bad template glob %q: %w", glob, err))
+			continue
+		}
+		if len(matches) == 0 {
+			problems = append(problems, fmt.Errorf("template glob %q matches no files", glob))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	// The presets come first, so that the templates can override them.
+	presets, remove, err := writeTemplateFile(presetTemplates)
+	if err != nil {
+		return []error{fmt.Errorf("failed to write the format presets: %w", err)}
+	}
+	defer remove()
+	tmpl, err := parseTemplateGlobs(b.templateFuncs(), append([]string{presets}, b.config.TemplatePaths...)...)
+	if err != nil {
+		return []error{fmt.Errorf("failed to parse templates: %w", err)}
+	}
+	tmpl.ExternalURL = b.templatesURL
+	b.templates = tmpl
+	b.config.TemplatesParsed = true
+	return nil
+}
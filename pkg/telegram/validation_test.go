@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsValidation(t *testing.T) {
+	am := &url.URL{Host: "localhost"}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []BotOption
+		problems []string
+	}{
+		{
+			name: "valid",
+			opts: []BotOption{WithTemplates(am, "../../default.tmpl"), WithExtraAdmins(456), WithFetchPeriod(60), WithDeletePeriod(3600)},
+		},
+		{
+			name:     "duplicate admins",
+			opts:     []BotOption{WithExtraAdmins(456, testAdmin.ID, 456)},
+			problems: []string{"admin ID 123 is given more than once", "admin ID 456 is given more than once"},
+		},
+		{
+			name: "bad template globs",
+			opts: []BotOption{WithTemplates(am, "../../default.tmpl", "templates/[", "/nonexistent/*.tmpl")},
+			problems: []string{
+				`bad template glob "templates/[": syntax error in pattern`,
+				`template glob "/nonexistent/*.tmpl" matches no files`,
+			},
+		},
+		{
+			name: "out of range",
+			opts: []BotOption{
+				WithWebhookWorkers(100),
+				WithMaxAlerts(500),
+				WithFailover(3, 10*time.Millisecond),
+				WithPollAlerts(100 * time.Millisecond),
+				WithSilenceSync(time.Millisecond),
+				WithClusterCheck(time.Minute, 3),
+			},
+			problems: []string{
+				"the number of webhook workers must be at most 30, Telegram doesn't take more messages at once, is 100",
+				"max alerts per message must be at most 100, more don't fit into a message, is 500",
+				"the failover probe interval must be at least 1s, is 10ms",
+				"the poll interval must be at least 1s or 0 to turn it off, is 100ms",
+				"the silence sync interval must be at least 1s or 0 to turn it off, is 1ms",
+			},
+		},
+		{
+			name: "every problem at once, whatever the order",
+			opts: []BotOption{
+				WithFetchPeriod(-1),
+				WithWebhookWorkers(0),
+				WithTemplates(am, "/nonexistent.tmpl"),
+				WithExtraAdmins(-5),
+				WithFailover(0, time.Minute),
+				WithMaxAlerts(-1),
+				WithRedactionPatterns("("),
+				WithDeletePeriod(-60),
+			},
+			problems: []string{
+				"the number of webhook workers must be at least 1, is 0",
+				"the failover threshold must be positive, is 0",
+				"max alerts per message must not be negative, is -1",
+				`failed to compile redaction pattern "("`,
+				"admin ID -5 is invalid, user IDs are positive",
+				"the fetch period must not be negative, is -1s",
+				"the delete period must not be negative, is -60s",
+				`template glob "/nonexistent.tmpl" matches no files`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+			require.NoError(t, err)
+
+			_, err = NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID, tc.opts...)
+			if len(tc.problems) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var invalid *OptionsError
+			require.True(t, errors.As(err, &invalid), "%v", err)
+			require.Len(t, invalid.Errs, len(tc.problems), err.Error())
+			for _, p := range tc.problems {
+				require.Contains(t, err.Error(), p)
+			}
+		})
+	}
+}
+
+func TestOptionsValidationEmptyToken(t *testing.T) {
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+
+	var applied int
+	counted := func(*Bot) error {
+		applied++
+		return nil
+	}
+	_, err = NewBot(chats, " ", testAdmin.ID, WithExtraAdmins(testAdmin.ID), WithMaxAlerts(-1), counted)
+	require.True(t, errors.Is(err, ErrEmptyToken))
+	require.Equal(t, 1, applied, "the options are applied once")
+	require.Equal(t, "3 invalid options:\n"+
+		"  - the Telegram token is empty\n"+
+		"  - max alerts per message must not be negative, is -1\n"+
+		"  - admin ID 123 is given more than once", err.Error())
+}
+
+func TestOptionsValidationWarnings(t *testing.T) {
+	var logs bytes.Buffer
+	chats, err := NewChatStore(newMemoryKV(), telegramChatsDirectory)
+	require.NoError(t, err)
+
+	_, err = NewBotWithTelegram(chats, &fakeTelebot{}, testAdmin.ID,
+		WithDeletePeriod(60),
+		WithFetchPeriod(300),
+		WithLogger(log.NewLogfmtLogger(&logs)),
+		WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"),
+		WithSuppressedCriticalAlerting(true),
+		WithSuppressedCriticalSettings(time.Minute, "severity", "page", 0),
+	)
+	require.NoError(t, err, "suspicious settings are only warned about")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2, logs.String())
+	require.Contains(t, lines[0], "kept longer than the delete period")
+	require.Contains(t, lines[1], "value=page")
+}
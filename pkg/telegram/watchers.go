@@ -0,0 +1,306 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandWatchSubscriptions = "/watch_subscriptions"
+
+	// telegramWatchersDirectory keeps a record per chat told about the changes of subscriptions.
+	telegramWatchersDirectory = "telegram/watchers"
+
+	// watchEventsPerMinute is how many changes a watcher is told about per minute. The others are counted
+	// and the watcher is told how many it missed with the next one.
+	watchEventsPerMinute = 20
+
+	responseWatchUsage = "Usage: " + CommandWatchSubscriptions + " on|off"
+)
+
+// Watcher is a chat told about every change of the subscriptions of chats.
+type Watcher struct {
+	ChatID int64
+	Since  time.Time
+}
+
+func watcherKey(id int64) string {
+	return fmt.Sprintf("%s/%d", telegramWatchersDirectory, id)
+}
+
+// ListWatchers returns the chats watching the subscriptions.
+func (s *ChatStore) ListWatchers() ([]Watcher, error) {
+	kvPairs, err := s.list(telegramWatchersDirectory, nil)
+	if err != nil {
+		return nil, err
+	}
+	watchers := make([]Watcher, 0, len(kvPairs))
+	for _, kv := range kvPairs {
+		var w Watcher
+		if err := decode(kv.Key, kv.Value, &w); err != nil {
+			return nil, err
+		}
+		watchers = append(watchers, w)
+	}
+	sort.Slice(watchers, func(i, j int) bool { return watchers[i].ChatID < watchers[j].ChatID })
+	return watchers, nil
+}
+
+// SetWatcher makes the chat a watcher of the subscriptions since the given time, or not one anymore.
+func (s *ChatStore) SetWatcher(id int64, on bool, since time.Time) error {
+	if !on {
+		return s.delete(watcherKey(id))
+	}
+	value, err := json.Marshal(Watcher{ChatID: id, Since: since})
+	if err != nil {
+		return err
+	}
+	return s.put(watcherKey(id), value)
+}
+
+// commandActors remembers who sent the command being handled in a chat, to tell watchers who changed it.
+type commandActors struct {
+	mu     sync.Mutex
+	byChat map[int64]*telebot.User
+}
+
+func newCommandActors() *commandActors {
+	return &commandActors{byChat: map[int64]*telebot.User{}}
+}
+
+// acting remembers u as who changes the chat until done is called.
+func (a *commandActors) acting(chatID int64, u *telebot.User) (done func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byChat[chatID] = u
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.byChat[chatID] == u {
+			delete(a.byChat, chatID)
+		}
+	}
+}
+
+// by tells who changes the chat, like " by @alice (1)", empty if it's no command sent in the chat.
+func (a *commandActors) by(chatID int64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if u := a.byChat[chatID]; u != nil {
+		return " by " + senderName(u)
+	}
+	return ""
+}
+
+// watchWindow counts the events sent to a watcher in the current minute.
+type watchWindow struct {
+	start   time.Time
+	sent    int
+	skipped int
+}
+
+// watchLimits keeps every watcher at watchEventsPerMinute.
+type watchLimits struct {
+	mu      sync.Mutex
+	windows map[int64]*watchWindow
+}
+
+func newWatchLimits() *watchLimits {
+	return &watchLimits{windows: map[int64]*watchWindow{}}
+}
+
+// allow returns the event to send to the watcher, telling how many it missed before, or false if it has
+// gotten too many this minute.
+func (l *watchLimits) allow(chatID int64, event string, now time.Time) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.windows[chatID]
+	if w == nil {
+		w = &watchWindow{start: now}
+		l.windows[chatID] = w
+	}
+	if now.Sub(w.start) >= time.Minute {
+		w.start, w.sent = now, 0
+	}
+	if w.sent >= watchEventsPerMinute {
+		w.skipped++
+		return "", false
+	}
+	w.sent++
+	if w.skipped > 0 {
+		event += fmt.Sprintf("\n(%d more changes weren't sent, there were too many at once)", w.skipped)
+		w.skipped = 0
+	}
+	return event, true
+}
+
+// tellWatchers sends the event to every watcher.
+func (b *Bot) tellWatchers(event string) {
+	watchers, err := b.chats.ListWatchers()
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "failed to list the watchers of subscriptions", "err", err)
+		return
+	}
+	now := time.Now()
+	for _, w := range watchers {
+		text, ok := b.watchLimits.allow(w.ChatID, event, now)
+		if !ok {
+			continue
+		}
+		if _, err := b.send(&telebot.Chat{ID: w.ChatID}, text, "watch "+event); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to tell a watcher about a change of subscriptions", "chat_id", w.ChatID, "err", err)
+		}
+	}
+}
+
+// watchedChatStore tells the watchers about the changes of subscriptions made through the BotChatStore it wraps.
+// All changes go through it, so every new way of changing subscriptions is told about, too.
+type watchedChatStore struct {
+	BotChatStore
+	bot *Bot
+}
+
+// name returns the name of the stored chat, its ID if it isn't stored.
+func (s watchedChatStore) name(id int64) string {
+	ci, err := s.BotChatStore.GetChatInfo(id)
+	if err != nil || ci.Chat == nil {
+		return fmt.Sprintf("%d", id)
+	}
+	return chatName(ci.Chat)
+}
+
+func (s watchedChatStore) tell(chatID int64, event string) {
+	s.bot.tellWatchers(event + s.bot.actors.by(chatID))
+}
+
+func (s watchedChatStore) AddChat(c *telebot.Chat, allEnvs []string, allPrs []string) error {
+	if err := s.BotChatStore.AddChat(c, allEnvs, allPrs); err != nil {
+		return err
+	}
+	s.tell(c.ID, "➕ chat "+chatName(c)+" subscribed")
+	return nil
+}
+
+func (s watchedChatStore) RemoveChat(c *telebot.Chat) error {
+	if err := s.BotChatStore.RemoveChat(c); err != nil {
+		return err
+	}
+	s.tell(c.ID, "➖ chat "+chatName(c)+" unsubscribed")
+	return nil
+}
+
+func (s watchedChatStore) SoftDeleteChat(id int64) error {
+	name := s.name(id)
+	if err := s.BotChatStore.SoftDeleteChat(id); err != nil {
+		return err
+	}
+	s.tell(id, "➖ chat "+name+" was removed, Telegram doesn't know it anymore")
+	return nil
+}
+
+func (s watchedChatStore) ForgetChat(id int64) (map[string]int, error) {
+	name := s.name(id)
+	removed, err := s.BotChatStore.ForgetChat(id)
+	if removed[chatDataChat] > 0 {
+		s.tell(id, "➖ chat "+name+" was forgotten")
+	}
+	return removed, err
+}
+
+func (s watchedChatStore) MuteEnvironments(c *telebot.Chat, envs []string, allEnvs []string) error {
+	if err := s.BotChatStore.MuteEnvironments(c, envs, allEnvs); err != nil {
+		return err
+	}
+	s.tell(c.ID, fmt.Sprintf("🔇 %s muted %s[%s]", chatName(c), labelEnvironment, strings.Join(envs, ", ")))
+	return nil
+}
+
+func (s watchedChatStore) MuteProjects(c *telebot.Chat, prs []string, allPrs []string) error {
+	if err := s.BotChatStore.MuteProjects(c, prs, allPrs); err != nil {
+		return err
+	}
+	s.tell(c.ID, fmt.Sprintf("🔇 %s muted %s[%s]", chatName(c), labelProject, strings.Join(prs, ", ")))
+	return nil
+}
+
+func (s watchedChatStore) UnmuteEnvironment(c *telebot.Chat, env string, allEnvs []string) error {
+	if err := s.BotChatStore.UnmuteEnvironment(c, env, allEnvs); err != nil {
+		return err
+	}
+	s.tell(c.ID, fmt.Sprintf("🔊 %s unmuted %s[%s]", chatName(c), labelEnvironment, env))
+	return nil
+}
+
+func (s watchedChatStore) UnmuteProject(c *telebot.Chat, pr string, allPrs []string) error {
+	if err := s.BotChatStore.UnmuteProject(c, pr, allPrs); err != nil {
+		return err
+	}
+	s.tell(c.ID, fmt.Sprintf("🔊 %s unmuted %s[%s]", chatName(c), labelProject, pr))
+	return nil
+}
+
+func (s watchedChatStore) SetSeverityRoutes(id int64, routes []SeverityRoute) error {
+	if err := s.BotChatStore.SetSeverityRoutes(id, routes); err != nil {
+		return err
+	}
+	event := "🔀 " + s.name(id) + " routes no severities anymore"
+	if len(routes) > 0 {
+		described := make([]string, 0, len(routes))
+		for _, r := range routes {
+			described = append(described, r.String())
+		}
+		event = "🔀 " + s.name(id) + " routes " + strings.Join(described, "; ")
+	}
+	s.tell(id, event)
+	return nil
+}
+
+func (s watchedChatStore) ApplySetup(id int64, setup ChatSetup, allEnvs []string, allPrs []string) error {
+	if err := s.BotChatStore.ApplySetup(id, setup, allEnvs, allPrs); err != nil {
+		return err
+	}
+	event := fmt.Sprintf("🧭 %s was set up for %s[%s] %s[%s]", s.name(id),
+		labelEnvironment, strings.Join(setup.Environments, ", "), labelProject, strings.Join(setup.Projects, ", "))
+	if setup.MinSeverity != "" {
+		event += fmt.Sprintf(" %s[%s] and above", labelSeverity, setup.MinSeverity)
+	}
+	s.tell(id, event)
+	return nil
+}
+
+func (b *Bot) handleWatchSubscriptions(message *telebot.Message) error {
+	if !b.isGlobalAdmin(message.Sender.ID) {
+		_, err := b.reply(message, "Only global admins can watch the subscriptions.")
+		return err
+	}
+
+	var on bool
+	switch strings.TrimSpace(message.Payload) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		_, err := b.reply(message, responseWatchUsage)
+		return err
+	}
+
+	if err := b.chats.SetWatcher(message.Chat.ID, on, time.Now()); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to set the watcher of subscriptions", "err", err)
+		_, err = b.replyStoreError(message, err, "set the watcher")
+		return err
+	}
+	text := "This chat is told about every chat subscribing, unsubscribing and changing its mutes or severities now."
+	if !on {
+		text = "This chat isn't told about changes of subscriptions anymore."
+	}
+	_, err := b.reply(message, text)
+	return err
+}
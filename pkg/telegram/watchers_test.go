@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+func TestWatcherEvents(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.SetWatcher(oncall.ID, true, time.Now()))
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	for _, tc := range []struct {
+		name  string
+		op    func() error
+		event string
+	}{
+		{
+			name:  "subscribe",
+			op:    func() error { return b.chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther) },
+			event: "➕ chat payments-oncall (-100) subscribed",
+		},
+		{
+			name:  "mute environments",
+			op:    func() error { return b.chats.MuteEnvironments(payments, []string{"staging"}, b.environmentsAndOther) },
+			event: "🔇 payments-oncall (-100) muted environment[staging]",
+		},
+		{
+			name: "mute projects",
+			op: func() error {
+				return b.chats.MuteProjects(payments, []string{"billing", "frontend"}, b.projectsAndOther)
+			},
+			event: "🔇 payments-oncall (-100) muted project[billing, frontend]",
+		},
+		{
+			name:  "unmute environment",
+			op:    func() error { return b.chats.UnmuteEnvironment(payments, "staging", b.environmentsAndOther) },
+			event: "🔊 payments-oncall (-100) unmuted environment[staging]",
+		},
+		{
+			name:  "unmute project",
+			op:    func() error { return b.chats.UnmuteProject(payments, "billing", b.projectsAndOther) },
+			event: "🔊 payments-oncall (-100) unmuted project[billing]",
+		},
+		{
+			name: "severity routes",
+			op: func() error {
+				return b.chats.SetSeverityRoutes(payments.ID, []SeverityRoute{{Severities: []string{"critical"}, ChatID: oncall.ID, Also: true}})
+			},
+			event: "🔀 payments-oncall (-100) routes severity[critical] to -200 also",
+		},
+		{
+			name: "setup",
+			op: func() error {
+				return b.chats.ApplySetup(payments.ID, ChatSetup{Environments: []string{"prod"}, Projects: []string{"billing"}, MinSeverity: "warning"}, b.environmentsAndOther, b.projectsAndOther)
+			},
+			event: "🧭 payments-oncall (-100) was set up for environment[prod] project[billing] severity[warning] and above",
+		},
+		{
+			name:  "unsubscribe",
+			op:    func() error { return b.chats.RemoveChat(payments) },
+			event: "➖ chat payments-oncall (-100) unsubscribed",
+		},
+		{
+			name:  "removed",
+			op:    func() error { return b.chats.SoftDeleteChat(testChat.ID) },
+			event: "➖ chat @elliot (123) was removed, Telegram doesn't know it anymore",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sent := len(tb.messages())
+			require.NoError(t, tc.op())
+			msgs := tb.messages()
+			require.Len(t, msgs, sent+1)
+			require.Equal(t, "-200", msgs[sent].to)
+			require.Equal(t, tc.event, msgs[sent].text())
+		})
+	}
+
+	sent := len(tb.messages())
+	require.Error(t, b.chats.MuteEnvironments(payments, []string{"prod"}, b.environmentsAndOther))
+	require.Len(t, tb.messages(), sent, "failed changes aren't told about")
+}
+
+func TestWatcherEventActor(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.SetWatcher(oncall.ID, true, time.Now()))
+
+	done := b.actors.acting(payments.ID, testAdmin)
+	require.NoError(t, b.chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.Equal(t, "➕ chat payments-oncall (-100) subscribed by @elliot (123)", tb.lastText())
+
+	require.NoError(t, b.chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+	require.Equal(t, "➕ chat @elliot (123) subscribed", tb.lastText(), "the command was sent in another chat")
+
+	done()
+	require.NoError(t, b.chats.RemoveChat(payments))
+	require.Equal(t, "➖ chat payments-oncall (-100) unsubscribed", tb.lastText())
+}
+
+func TestWatchLimits(t *testing.T) {
+	l := newWatchLimits()
+	now := time.Now()
+	for i := 0; i < watchEventsPerMinute; i++ {
+		event, ok := l.allow(oncall.ID, fmt.Sprintf("event %d", i), now)
+		require.True(t, ok)
+		require.Equal(t, fmt.Sprintf("event %d", i), event)
+	}
+	_, ok := l.allow(oncall.ID, "too many", now.Add(30*time.Second))
+	require.False(t, ok)
+	_, ok = l.allow(oncall.ID, "too many", now.Add(40*time.Second))
+	require.False(t, ok)
+	event, ok := l.allow(payments.ID, "another watcher", now)
+	require.True(t, ok, "every watcher has its own limit")
+	require.Equal(t, "another watcher", event)
+
+	event, ok = l.allow(oncall.ID, "next minute", now.Add(time.Minute))
+	require.True(t, ok)
+	require.Equal(t, "next minute\n(2 more changes weren't sent, there were too many at once)", event)
+	event, _ = l.allow(oncall.ID, "after", now.Add(time.Minute))
+	require.Equal(t, "after", event)
+}
+
+func TestHandleWatchSubscriptions(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithExtraAdmins(456))
+	other := &telebot.User{ID: 456, Username: "mallory"}
+
+	require.NoError(t, b.handleWatchSubscriptions(&telebot.Message{Chat: oncall, Sender: other, Payload: "on"}))
+	require.Equal(t, "Only global admins can watch the subscriptions.", tb.lastText())
+
+	require.NoError(t, b.handleWatchSubscriptions(&telebot.Message{Chat: oncall, Sender: testAdmin, Payload: "sometimes"}))
+	require.Equal(t, responseWatchUsage, tb.lastText())
+
+	require.NoError(t, b.handleWatchSubscriptions(&telebot.Message{Chat: oncall, Sender: testAdmin, Payload: "on"}))
+	watchers, err := chats.ListWatchers()
+	require.NoError(t, err)
+	require.Len(t, watchers, 1)
+	require.Equal(t, oncall.ID, watchers[0].ChatID)
+
+	require.NoError(t, b.handleWatchSubscriptions(&telebot.Message{Chat: oncall, Sender: testAdmin, Payload: "off"}))
+	require.Equal(t, "This chat isn't told about changes of subscriptions anymore.", tb.lastText())
+	watchers, err = chats.ListWatchers()
+	require.NoError(t, err)
+	require.Empty(t, watchers)
+}
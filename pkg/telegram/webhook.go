@@ -0,0 +1,290 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// delivery is what happened to a single webhook on its way to Telegram.
+type delivery struct {
+	// Messages is the number of messages sent, 0 if the webhook was dropped.
+	Messages int
+	// Truncated is true if the rendered alerts didn't fit into a single message.
+	Truncated bool
+	// RateLimited is true if Telegram refused the message because of flood control.
+	RateLimited bool
+	// Failed is why the webhook couldn't be delivered but might be later, nil if it was delivered or dropped for good.
+	Failed error
+}
+
+// processWebhook renders a single webhook and sends it to its chat.
+// Only errors that should stop the bot are returned, everything else is logged.
+func (b *Bot) processWebhook(ctx context.Context, w alertmanager.TelegramWebhook) (delivery, error) {
+	if w.Message.Data != nil {
+		b.countUnknownSeverities(w.Message.Alerts)
+	}
+	return b.deliverWebhook(ctx, w, "")
+}
+
+// deliverWebhook is processWebhook with a header put above the alerts.
+func (b *Bot) deliverWebhook(ctx context.Context, w alertmanager.TelegramWebhook, header string) (delivery, error) {
+	var d delivery
+	logger := b.webhookLogger(w, nil)
+	level.Debug(logger).Log("msg", "got webhook")
+	chatInfo, err := b.chats.GetChatInfo(w.ChatID)
+	if err != nil {
+		var corrupt *ErrCorruptRecord
+		switch {
+		case errors.Is(err, ErrChatNotFound):
+			b.errorLogger(logger, level.Warn, w.ChatID, "chat not subscribed").Log("msg", "chat is not subscribed for alerts", "err", err)
+			b.recordUnknownChat(w, time.Now())
+			return d, nil
+		case errors.Is(err, ErrStoreUnavailable):
+			b.errorLogger(logger, level.Warn, w.ChatID, "store unavailable").Log("msg", "dropping webhook, the store is unavailable", "err", err)
+			d.Failed = err
+			return d, nil
+		case errors.As(err, &corrupt):
+			b.errorLogger(logger, level.Error, w.ChatID, "corrupt record").Log("msg", "dropping webhook, the chat's record is corrupt", "key", corrupt.Key, "err", err)
+			return d, nil
+		}
+		return d, err
+	}
+	logger = b.webhookLogger(w, chatInfo)
+	if chatInfo.Unreachable {
+		b.errorLogger(logger, level.Warn, w.ChatID, "chat unreachable").Log("msg", "dropping webhook for unreachable chat", "unreachable_since", chatInfo.UnreachableSince)
+		return d, nil
+	}
+	// The payload is echoed even if its alerts are dropped, that's what it's there to debug.
+	if echoing(chatInfo, time.Now()) {
+		b.echoWebhook(logger, chatInfo, w, time.Now())
+	}
+	if chatInfo.Paused {
+		level.Debug(logger).Log("msg", "dropping webhook for paused chat", "paused_since", chatInfo.PausedSince)
+		return d, nil
+	}
+	chat := chatInfo.Chat
+	level.Debug(logger).Log("msg", "chat found for webhook")
+
+	uninhibited := b.withoutInhibited(ctx, logger, w.Message.Alerts)
+	uninhibited, unlabeled, tag := b.applyUnlabeledPolicy(chat.ID, uninhibited)
+	if len(unlabeled) > 0 {
+		b.forwardUnlabeled(ctx, logger, w, chatInfo, unlabeled)
+	}
+	if tag != "" {
+		header = tag + "\n\n" + header
+	}
+	b.recordSuppressed(chatInfo, uninhibited)
+	webhookAlerts := b.chatAlerts(chatInfo, uninhibited)
+	if len(webhookAlerts) == 0 && len(w.Message.Alerts) > 0 {
+		level.Info(logger).Log("msg", "dropping webhook, all its alerts are inhibited, unlabeled, muted or below the chat's minimum severity")
+		return d, nil
+	}
+	beforeDebounce := len(webhookAlerts)
+	webhookAlerts = b.debounce(ctx, chatInfo, w, webhookAlerts, time.Now())
+	if len(webhookAlerts) == 0 && beforeDebounce > 0 {
+		level.Debug(logger).Log("msg", "holding webhook, its alerts are debounced or resolved while they were")
+		return d, nil
+	}
+	beforeRepeats := len(webhookAlerts)
+	webhookAlerts = b.suppressRepeats(logger, chatInfo, webhookAlerts, b.repeatsNow())
+	if len(webhookAlerts) == 0 && beforeRepeats > 0 {
+		level.Info(logger).Log("msg", "dropping webhook, its alerts were delivered within the window of their repeat suppression")
+		return d, nil
+	}
+	if !b.sendsResolved(chatInfo) {
+		firing := firingAlerts(webhookAlerts)
+		if len(firing) == 0 && len(webhookAlerts) > 0 {
+			level.Info(logger).Log("msg", "dropping webhook, all its alerts are resolved and the chat doesn't get resolved alerts")
+			return d, nil
+		}
+		webhookAlerts = firing
+	}
+	beforeRoutes := len(webhookAlerts)
+	webhookAlerts, err = b.applySeverityRoutes(ctx, logger, w, chatInfo, webhookAlerts)
+	if err != nil {
+		return d, err
+	}
+	if len(webhookAlerts) == 0 && beforeRoutes > 0 {
+		level.Info(logger).Log("msg", "all alerts of the webhook were routed to other chats by severity")
+		return d, nil
+	}
+
+	if b.splitByStatus && ctx.Value(unsplitKey{}) == nil {
+		if firing, resolved := splitByStatus(webhookAlerts); len(firing) > 0 && len(resolved) > 0 {
+			level.Debug(logger).Log("msg", "splitting webhook by status", "firing", len(firing), "resolved", len(resolved))
+			// Firing alerts go first, if they can't be sent the resolved ones wait for the webhook to be retried.
+			d, err = b.sendAlerts(ctx, statusPart(w, "firing", firing), chatInfo, firing, statusHeader("firing", len(firing))+header)
+			if err != nil || d.Failed != nil || d.Messages == 0 {
+				return d, err
+			}
+			resolvedPart := statusPart(w, "resolved", resolved)
+			resolvedPart.Message.TruncatedAlerts = 0
+			rd, err := b.sendAlerts(ctx, resolvedPart, chatInfo, resolved, statusHeader("resolved", len(resolved))+header)
+			rd.Messages += d.Messages
+			rd.Truncated = rd.Truncated || d.Truncated
+			return rd, err
+		}
+	}
+	return b.sendAlerts(ctx, w, chatInfo, webhookAlerts, header)
+}
+
+// sendAlerts renders the alerts of the webhook the chat gets and sends them.
+func (b *Bot) sendAlerts(ctx context.Context, w alertmanager.TelegramWebhook, chatInfo *ChatInfo, webhookAlerts template.Alerts, header string) (delivery, error) {
+	var d delivery
+	logger := b.webhookLogger(w, chatInfo)
+	chat := chatInfo.Chat
+	var err error
+
+	data := b.redactData(chatInfo, &template.Data{
+		Receiver:          w.Message.Receiver,
+		Status:            w.Message.Status,
+		Alerts:            webhookAlerts,
+		GroupLabels:       w.Message.GroupLabels,
+		CommonLabels:      w.Message.CommonLabels,
+		CommonAnnotations: w.Message.CommonAnnotations,
+		ExternalURL:       externalURL(chatInfo, w.Message.ExternalURL),
+	})
+
+	format := b.chatFormat(chatInfo)
+	if !format.Annotations {
+		data = withoutAnnotations(data)
+	}
+
+	alerts := data.Alerts
+	resolvedAfter := b.resolvedAfter(logger, chat.ID, alerts)
+	seen := b.seenAlerts(logger, alerts, time.Now())
+	render := func(data *template.Data) string {
+		td := b.newTemplateData(chatInfo, data)
+		td.ResolvedAfter = resolvedAfter
+		td.Seen = seen
+		return b.renderAlertsOrPlain(logger, td)
+	}
+	shown, overflow := splitOverflow(alerts, format.MaxAlerts)
+	var listing string
+	if len(overflow) > 0 && format.ShowAll {
+		listing = plainText(render(data))
+	}
+	if len(overflow) > 0 {
+		shownData := *data
+		shownData.Alerts = shown
+		data = &shownData
+	}
+
+	out := render(data)
+
+	// The header and footers are kept when the alerts have to be truncated.
+	var footer string
+	if isSyntheticWebhook(w) {
+		header = syntheticMessageHeader + header
+	}
+	if n := w.Message.TruncatedAlerts; n > 0 {
+		footer = footer + fmt.Sprintf("\n\n<i>%d more alerts were left out of this webhook.</i>", n)
+	}
+	if summary := resolvedSummary(alerts, resolvedAfter); summary != "" {
+		footer = footer + "\n\n" + summary
+	}
+	if summary := seenSummary(alerts, seen); summary != "" {
+		footer = footer + "\n\n" + summary
+	}
+	if summary := summarizeOverflow(overflow, overflowSummaryMaxLength, b.mostSevereEmojiFunc()); summary != "" && format.Digest {
+		footer = footer + "\n\n" + summary
+	}
+	if hints := b.correlationFooter(ctx, alerts); hints != "" {
+		footer = footer + "\n\n" + hints
+	}
+	out = strings.TrimRight(out, "\n")
+	level.Debug(logger).Log("msg", "rendered message", "body", out)
+
+	sendOptions := &telebot.SendOptions{ParseMode: telebot.ModeHTML}
+	var buttons [][]telebot.InlineButton
+	if format.IssueButtons {
+		if markup := issueButtons(b.issueTracker, data.Alerts); markup != nil {
+			buttons = markup.InlineKeyboard
+		}
+	}
+	if listing != "" {
+		buttons = append(buttons, []telebot.InlineButton{showAllButton(b.overflowListings.add(listing))})
+	}
+	if len(buttons) > 0 {
+		sendOptions.ReplyMarkup = &telebot.ReplyMarkup{InlineKeyboard: buttons}
+	}
+
+	var sentText string
+	var sentParseMode telebot.ParseMode
+	send := func(to *telebot.Chat, header string) (*telebot.Message, error) {
+		mode := chatParseMode(chatInfo)
+		if to.ID != chat.ID {
+			mode = b.parseModeOf(to.ID)
+		}
+		options := *sendOptions
+		// The body is truncated once converted, MarkdownV2's escaping makes it longer than its HTML.
+		text, parseMode, truncated := b.formatTruncated(mode, header, out, footer, sendOptions.ParseMode)
+		d.Truncated = truncated
+		text = b.checkedHTML(logger, text, parseMode)
+		options.ParseMode = parseMode
+		sentText, sentParseMode = text, parseMode
+		var sent *telebot.Message
+		var err error
+		if topic := routeTopicOf(ctx, to.ID); topic != 0 {
+			sent, err = b.sendToTopic(to, topic, text, "webhook "+w.Message.GroupKey, &options)
+		} else {
+			sent, err = b.send(to, text, "webhook "+w.Message.GroupKey, &options)
+		}
+		b.deliveryStats.delivery(err == nil)
+		// Deliveries to the fallback chat don't count for it, it needn't even be subscribed anymore.
+		if to.ID == chat.ID {
+			if err := b.chats.RecordDelivery(chat.ID, err == nil); err != nil {
+				level.Warn(logger).Log("msg", "failed to record delivery", "err", err)
+			}
+			b.recordBlocked(logger, chat, err, time.Now())
+		}
+		return sent, err
+	}
+	fallback := &telebot.Chat{ID: chatInfo.FallbackChatID}
+
+	var sent *telebot.Message
+	if b.failingOver(chatInfo) {
+		level.Debug(logger).Log("msg", "sending alerts to the fallback chat", "fallback_chat_id", fallback.ID)
+		sent, err = send(fallback, fallbackHeader(chat)+header)
+	} else {
+		sent, err = send(chat, header)
+		if err != nil && !isMigratedError(err) && b.startFailover(logger, chatInfo) {
+			sent, err = send(fallback, fallbackHeader(chat)+header)
+		}
+	}
+	if err != nil {
+		if isMigratedError(err) {
+			level.Warn(logger).Log("msg", "chat was migrated to a supergroup, waiting for the migration update")
+			return d, nil
+		}
+		var flood telebot.FloodError
+		d.RateLimited = errors.As(err, &flood)
+		d.Failed = err
+		level.Warn(logger).Log("msg", "failed to send message with alerts", "err", err)
+		return d, nil
+	}
+	d.Messages++
+	b.recordNotified(logger, alerts, time.Now())
+	b.recordRepeats(logger, chatInfo, webhookAlerts, b.repeatsNow())
+	if sent != nil {
+		record := newMessageRecord(sent, b.redactAlerts(webhookAlerts, false))
+		if b.silenceSyncInterval > 0 || chatInfo.ArchiveChatID != 0 {
+			record.Text, record.ParseMode, record.ReplyMarkup = sentText, sentParseMode, sendOptions.ReplyMarkup
+		}
+		if chatInfo.ArchiveChatID != 0 && w.Message.Status == "resolved" {
+			b.archiveMessage(logger, chatInfo, &record)
+		}
+		if err := b.chats.AddMessage(record); err != nil {
+			level.Warn(logger).Log("msg", "failed to store sent message", "err", err)
+		}
+	}
+	return d, nil
+}
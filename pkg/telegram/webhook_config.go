@@ -0,0 +1,163 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	CommandWebhookConfig = "/webhook_config"
+
+	// defaultPublicURL is where the bot is guessed to be reachable by Alertmanager if no public URL is set.
+	defaultPublicURL = "http://alertmanager-bot:8080"
+)
+
+// webhookConfigTemplate is the Alertmanager receiver and route sending a chat its alerts.
+var webhookConfigTemplate = texttemplate.Must(texttemplate.New("webhook_config").Parse(`receivers:
+  - name: {{ .Receiver }}
+    webhook_configs:
+      - url: {{ .URL }}
+        send_resolved: true
+
+route:
+  routes:
+    - receiver: {{ .Receiver }}
+      continue: true{{ if .Matchers }}
+      matchers:{{ range .Matchers }}
+        - '{{ . }}'{{ end }}{{ end }}
+`))
+
+var receiverNameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// WithPublicURL sets the URL Alertmanager reaches the bot at, used by /webhook_config.
+func WithPublicURL(raw string) BotOption {
+	return func(b *Bot) error {
+		if raw == "" {
+			return nil
+		}
+		u, err := parseExternalURL(raw)
+		if err != nil {
+			return fmt.Errorf("invalid public URL: %w", err)
+		}
+		b.publicURL = u
+		return nil
+	}
+}
+
+// webhookConfig is what webhookConfigTemplate renders.
+type webhookConfig struct {
+	Receiver string
+	URL      string
+	Matchers []string
+}
+
+// receiverName names the chat's receiver after its title or username, or its ID if it has neither.
+func receiverName(chat *telebot.Chat) string {
+	name := chat.Title
+	if name == "" {
+		name = chat.Username
+	}
+	name = strings.Trim(receiverNameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		name = strconv.FormatInt(chat.ID, 10)
+	}
+	return "telegram-" + name
+}
+
+// muteMatcher matches the values of a label the chat didn't mute, "" if it muted none.
+// Values that aren't configured count as "other", so if that's muted only the unmuted configured values match.
+func muteMatcher(label string, muted []string, configured []string) string {
+	quote := func(values []string) string {
+		quoted := make([]string, 0, len(values))
+		for _, v := range values {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+		return strings.Join(quoted, "|")
+	}
+
+	if len(muted) == 0 {
+		return ""
+	}
+	if contains(muted, otherValue) {
+		return fmt.Sprintf(`%s=~"%s"`, label, quote(arrayDifference(configured, muted)))
+	}
+	return fmt.Sprintf(`%s!~"%s"`, label, quote(muted))
+}
+
+// matcher drops the severities below the chat's minimum, "" if it has none.
+// Unknown severities still match, like they are still delivered. Numeric orderings can't be matched,
+// the bot drops the severities below the minimum itself then.
+func (o severityOrdering) matcher(min string) string {
+	below := o.below(min)
+	if len(below) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`%s!~"%s"`, labelSeverity, strings.Join(below, "|"))
+}
+
+// webhookConfigFor returns the receiver and route for a chat, ci is nil if the chat didn't subscribe.
+func (b *Bot) webhookConfigFor(chat *telebot.Chat, ci *ChatInfo) webhookConfig {
+	publicURL := b.publicURL
+	if publicURL == "" {
+		publicURL = defaultPublicURL
+	}
+	c := webhookConfig{
+		Receiver: receiverName(chat),
+		URL:      fmt.Sprintf("%s/webhooks/telegram/%d", publicURL, chat.ID),
+	}
+	if ci == nil {
+		return c
+	}
+	for _, m := range []string{
+		muteMatcher(labelEnvironment, ci.MutedEnvironments, b.environments),
+		muteMatcher(labelProject, ci.MutedProjects, b.projects),
+		b.severityOrdering.matcher(ci.MinSeverity),
+	} {
+		if m != "" {
+			c.Matchers = append(c.Matchers, m)
+		}
+	}
+	return c
+}
+
+func renderWebhookConfig(c webhookConfig) (string, error) {
+	var buf bytes.Buffer
+	if err := webhookConfigTemplate.Execute(&buf, c); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (b *Bot) handleWebhookConfig(message *telebot.Message) error {
+	ci, err := b.chats.GetChatInfo(message.Chat.ID)
+	if err != nil && !errors.Is(err, ErrChatNotFound) {
+		level.Warn(b.logger).Log("msg", "failed to get chat info", "err", err)
+		_, err = b.replyStoreError(message, err, "get the filters of this chat")
+		return err
+	}
+
+	config, err := renderWebhookConfig(b.webhookConfigFor(message.Chat, ci))
+	if err != nil {
+		return err
+	}
+
+	text := "Add this to the Alertmanager configuration to send this chat its alerts:\n"
+	if b.publicURL == "" {
+		text += "<i>The bot's public URL isn't set, check that Alertmanager reaches it at " + defaultPublicURL + ".</i>\n"
+	}
+	if ci == nil {
+		text += "<i>This chat didn't subscribe yet, send " + CommandStart + " to get the alerts.</i>\n"
+	}
+	text += `<pre><code class="language-yaml">` + html.EscapeString(config) + "</code></pre>"
+	_, err = b.replyFormatted(message, text, &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+	return err
+}
@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const webhookConfigUnfiltered = `receivers:
+  - name: telegram-elliot
+    webhook_configs:
+      - url: http://alertmanager-bot:8080/webhooks/telegram/123
+        send_resolved: true
+
+route:
+  routes:
+    - receiver: telegram-elliot
+      continue: true
+`
+
+const webhookConfigFiltered = `receivers:
+  - name: telegram-payments-oncall
+    webhook_configs:
+      - url: https://bot.example.com/alertmanager/webhooks/telegram/-100
+        send_resolved: true
+
+route:
+  routes:
+    - receiver: telegram-payments-oncall
+      continue: true
+      matchers:
+        - 'environment!~"staging"'
+        - 'project=~"billing"'
+        - 'severity!~"info|warning"'
+`
+
+func TestWebhookConfigGolden(t *testing.T) {
+	b, _, chats := newTestBot(t)
+	config, err := renderWebhookConfig(b.webhookConfigFor(testChat, nil))
+	require.NoError(t, err)
+	require.Equal(t, webhookConfigUnfiltered, config)
+
+	b, _, chats = newTestBot(t, WithPublicURL("https://bot.example.com/alertmanager/"))
+	require.NoError(t, chats.AddChat(payments, b.environmentsAndOther, b.projectsAndOther))
+	require.NoError(t, chats.MuteEnvironments(payments, []string{"staging"}, b.environmentsAndOther))
+	require.NoError(t, chats.MuteProjects(payments, []string{"frontend", otherValue}, b.projectsAndOther))
+	ci, err := chats.GetChatInfo(payments.ID)
+	require.NoError(t, err)
+	ci.MinSeverity = "critical"
+
+	config, err = renderWebhookConfig(b.webhookConfigFor(payments, ci))
+	require.NoError(t, err)
+	require.Equal(t, webhookConfigFiltered, config)
+}
+
+func TestReceiverName(t *testing.T) {
+	require.Equal(t, "telegram-payments-oncall", receiverName(&telebot.Chat{ID: -100, Title: "Payments / Oncall"}))
+	require.Equal(t, "telegram-jdoe", receiverName(&telebot.Chat{ID: 1, Username: "jdoe"}))
+	require.Equal(t, "telegram--100", receiverName(&telebot.Chat{ID: -100, Title: "🔥"}))
+}
+
+func TestHandleWebhookConfig(t *testing.T) {
+	b, tb, chats := newTestBot(t)
+	require.NoError(t, chats.AddChat(testChat, b.environmentsAndOther, b.projectsAndOther))
+
+	require.NoError(t, b.handleWebhookConfig(&telebot.Message{Chat: testChat, Sender: testAdmin, Text: CommandWebhookConfig}))
+	text := tb.messages()[0].text()
+	require.Contains(t, text, "public URL isn't set")
+	require.Contains(t, text, `<pre><code class="language-yaml">receivers:`)
+	require.NotContains(t, text, CommandStart)
+
+	_, err := NewBotWithTelegram(chats, tb, testAdmin.ID, WithPublicURL("bot.example.com"))
+	require.Error(t, err)
+}
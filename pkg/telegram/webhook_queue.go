@@ -0,0 +1,277 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+)
+
+const defaultWebhookWorkers = 1
+
+// WithWebhookWorkers processes the webhooks of up to n chats at the same time.
+// Each chat still gets its notifications in the order their webhooks arrived.
+func WithWebhookWorkers(n int) BotOption {
+	return func(b *Bot) error {
+		if n < 1 {
+			return fmt.Errorf("the number of webhook workers must be at least 1, is %d", n)
+		}
+		b.webhookWorkers = n
+		return nil
+	}
+}
+
+// chatQueues keeps the webhooks of every chat in a FIFO queue, drained by one worker at a time.
+// A resolved notification thus can't overtake the firing one of its chat, while different chats
+// are worked on in parallel. A chat's queue is dropped as soon as it's drained.
+type chatQueues struct {
+	mu sync.Mutex
+	// queues holds the chats a worker owns, with the webhooks waiting behind the one being processed.
+	queues map[int64][]queuedWebhook
+	// ready gets the chats that got a webhook while no worker owned them.
+	ready chan int64
+	now   func() time.Time
+}
+
+// queuedWebhook is a webhook waiting for a worker and when it came in.
+type queuedWebhook struct {
+	alertmanager.TelegramWebhook
+	received time.Time
+}
+
+func newChatQueues(workers int) *chatQueues {
+	return &chatQueues{
+		queues: map[int64][]queuedWebhook{},
+		ready:  make(chan int64, workers),
+		now:    time.Now,
+	}
+}
+
+// push queues the webhook behind the others of its chat and returns
+// true if no worker owns the chat, so it has to be handed to one.
+func (q *chatQueues) push(w alertmanager.TelegramWebhook) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue, owned := q.queues[w.ChatID]
+	q.queues[w.ChatID] = append(queue, queuedWebhook{TelegramWebhook: w, received: q.now()})
+	return !owned
+}
+
+// next takes the oldest webhook of the chat. Once there is none, the queue is
+// dropped and the chat is given up by its worker.
+func (q *chatQueues) next(chatID int64) (queuedWebhook, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := q.queues[chatID]
+	if len(queue) == 0 {
+		delete(q.queues, chatID)
+		return queuedWebhook{}, false
+	}
+	w := queue[0]
+	queue[0] = queuedWebhook{}
+	// An empty but non-nil queue keeps the chat owned while w is processed.
+	q.queues[chatID] = queue[1:]
+	return w, true
+}
+
+// rest takes all webhooks waiting for the chat, which stays owned by its worker.
+func (q *chatQueues) rest(chatID int64) []queuedWebhook {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue := q.queues[chatID]
+	if len(queue) == 0 {
+		return nil
+	}
+	q.queues[chatID] = []queuedWebhook{}
+	return queue
+}
+
+// len returns the number of chats that are queued or worked on.
+func (q *chatQueues) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues)
+}
+
+// depth returns the number of webhooks waiting for a worker and the number of chats queued or worked on.
+func (q *chatQueues) depth() (webhooks int, chats int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, queue := range q.queues {
+		webhooks += len(queue)
+	}
+	return webhooks, len(q.queues)
+}
+
+// reset drops the queued webhooks, which only workers that gave up early leave behind,
+// and returns how many there were. Their sources learn that they weren't delivered.
+func (q *chatQueues) reset() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var dropped int
+	for _, queue := range q.queues {
+		for _, w := range queue {
+			ack(w.TelegramWebhook, errWebhookDropped)
+		}
+		dropped += len(queue)
+	}
+	q.queues = map[int64][]queuedWebhook{}
+	for {
+		select {
+		case <-q.ready:
+		default:
+			return dropped
+		}
+	}
+}
+
+// drain processes the webhooks of the chat until its queue is empty or ctx is done.
+func (b *Bot) drain(ctx context.Context, q *chatQueues, chatID int64) error {
+	for ctx.Err() == nil {
+		w, ok := q.next(chatID)
+		if !ok {
+			return nil
+		}
+		if !b.leading() {
+			level.Debug(b.webhookLogger(w.TelegramWebhook, nil)).Log("msg", "not delivering webhook on a follower")
+			ack(w.TelegramWebhook, errFollower)
+			continue
+		}
+		if shed, err := b.shedLoad(ctx, q, w); shed || err != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		d, err := b.processWebhook(ctx, w.TelegramWebhook)
+		b.observeLoadTest(w.TelegramWebhook, d)
+		if err != nil {
+			ack(w.TelegramWebhook, err)
+			return err
+		}
+		ack(w.TelegramWebhook, d.Failed)
+	}
+	return nil
+}
+
+// sendWebhook hands the webhooks to the workers until stop is done or a webhook fails.
+// The workers then deliver the webhooks already queued, unless work is done too.
+// All workers have returned when sendWebhook does.
+func (b *Bot) sendWebhook(stop context.Context, work context.Context, webhooks <-chan alertmanager.TelegramWebhook) error {
+	work, abort := context.WithCancel(work)
+	defer abort()
+
+	q := b.webhookQueues
+	errs := make(chan error, b.webhookWorkers)
+	// stopped is closed once no more chats get ready, for the workers to return when they've none left.
+	stopped := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < b.webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var chatID int64
+				select {
+				case <-work.Done():
+					return
+				case chatID = <-q.ready:
+				case <-stopped:
+					select {
+					case chatID = <-q.ready:
+					default:
+						return
+					}
+				}
+				if err := b.drain(work, q, chatID); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	// enqueue hands the webhook to the workers, it returns false once no more webhooks can be handed to them.
+	enqueue := func(w alertmanager.TelegramWebhook) bool {
+		if !q.push(w) {
+			return true
+		}
+		select {
+		case q.ready <- w.ChatID:
+			return true
+		case <-work.Done():
+			return false
+		case err = <-errs:
+			abort()
+			return false
+		}
+	}
+
+	// dispatch holds, writes ahead and enqueues the webhook, it returns false once no more can be handed to the workers.
+	dispatch := func(w alertmanager.TelegramWebhook) bool {
+		if !b.leading() {
+			// Followers neither hold nor write ahead webhooks, a source can redeliver them to the leader.
+			level.Debug(b.webhookLogger(w, nil)).Log("msg", "not delivering webhook on a follower")
+			ack(w, errFollower)
+			return true
+		}
+		if b.holdForMaintenance(w, time.Now()) {
+			// Held webhooks are the bot's to deliver now.
+			ack(w, nil)
+			return true
+		}
+		return enqueue(b.writeAhead(w, time.Now()))
+	}
+
+	// The webhooks left in the outbox go first, those that can't be queued stay there.
+	queuing := true
+	for _, w := range b.replayOutbox(time.Now()) {
+		if queuing = enqueue(w); !queuing {
+			break
+		}
+	}
+
+dispatch:
+	for queuing {
+		select {
+		case <-stop.Done():
+			break dispatch
+		case err = <-errs:
+			abort()
+			break dispatch
+		case w, ok := <-webhooks:
+			if !ok {
+				level.Warn(b.logger).Log("msg", "the webhook source closed its channel, no more webhooks will come in")
+				webhooks = nil
+				continue
+			}
+			b.deliveryStats.webhook(time.Now())
+			b.webhookActivity.webhook(w.ChatID, time.Now())
+			if !dispatch(w) {
+				break dispatch
+			}
+		case w := <-b.syntheticWebhooks:
+			// Load tests go through the pipeline like the source's webhooks, without counting as activity.
+			if !dispatch(w) {
+				break dispatch
+			}
+		}
+	}
+	close(stopped)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	if dropped := q.reset(); dropped > 0 {
+		level.Warn(b.logger).Log("msg", "dropped queued webhooks", "count", dropped)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+var sequenceAlertname = regexp.MustCompile(`seq-(\d+)`)
+
+func TestWebhookWorkersKeepChatOrder(t *testing.T) {
+	const (
+		chats = 50
+		pairs = 10
+	)
+	b, tb, store := newTestBot(t, WithWebhookWorkers(8), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+	tb.beforeSend = func() { time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond) }
+	for i := 1; i <= chats; i++ {
+		require.NoError(t, store.AddChat(&telebot.Chat{ID: int64(i), Type: telebot.ChatGroup}, b.environmentsAndOther, b.projectsAndOther))
+	}
+
+	webhooks := make(chan alertmanager.TelegramWebhook)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.sendWebhook(ctx, ctx, webhooks) }()
+
+	// The firing and resolved webhooks of every chat alternate, interleaved with those of the other chats.
+	for seq := 0; seq < 2*pairs; seq++ {
+		status := "firing"
+		if seq%2 == 1 {
+			status = "resolved"
+		}
+		for i := 1; i <= chats; i++ {
+			webhooks <- alertmanager.TelegramWebhook{ChatID: int64(i), Message: webhook.Message{Data: &template.Data{
+				Status: status,
+				Alerts: template.Alerts{{Status: status, Labels: template.KV{"alertname": fmt.Sprintf("seq-%d", seq)}}},
+			}}}
+		}
+	}
+	require.Eventually(t, func() bool { return len(tb.messages()) == chats*2*pairs }, 10*time.Second, 10*time.Millisecond)
+
+	next := map[string]int{}
+	for _, m := range tb.messages() {
+		match := sequenceAlertname.FindStringSubmatch(m.text())
+		require.NotNil(t, match, m.text())
+		seq, err := strconv.Atoi(match[1])
+		require.NoError(t, err)
+		require.Equal(t, next[m.to], seq, "chat %s got its notifications out of order", m.to)
+		next[m.to]++
+	}
+	require.Len(t, next, chats)
+
+	// Idle chats don't keep their queues.
+	require.Eventually(t, func() bool { return b.webhookQueues.len() == 0 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
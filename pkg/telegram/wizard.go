@@ -0,0 +1,352 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	setupWizardUnique = "setup"
+	// setupWizardTimeout abandons setups nobody clicked on for a while.
+	setupWizardTimeout = 10 * time.Minute
+
+	defaultTimezone = "UTC"
+)
+
+// setupTimezones are offered by the setup wizard, it's not meant to be a complete list.
+var setupTimezones = []string{
+	"UTC",
+	"Europe/London",
+	"Europe/Berlin",
+	"Europe/Moscow",
+	"America/New_York",
+	"America/Los_Angeles",
+	"Asia/Tokyo",
+}
+
+// WithSetupWizard asks new chats after /start what they want to get alerts for.
+func WithSetupWizard(enabled bool) BotOption {
+	return func(b *Bot) error {
+		b.setupWizardEnabled = enabled
+		return nil
+	}
+}
+
+type setupStep int
+
+const (
+	setupStepEnvironments setupStep = iota
+	setupStepProjects
+	setupStepSeverity
+	setupStepTimezone
+	setupStepDone
+)
+
+// ChatSetup is what the setup wizard asks for.
+type ChatSetup struct {
+	Environments []string
+	Projects     []string
+	MinSeverity  string
+	Timezone     string
+}
+
+// setupSession is a setup wizard in progress.
+type setupSession struct {
+	step    setupStep
+	setup   ChatSetup
+	updated time.Time
+}
+
+// setupSessions keeps the setup wizards in progress by chat, in memory only.
+type setupSessions struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	sessions map[int64]*setupSession
+}
+
+func newSetupSessions(timeout time.Duration) *setupSessions {
+	return &setupSessions{timeout: timeout, sessions: map[int64]*setupSession{}}
+}
+
+// start begins a setup for the chat, replacing one in progress, and abandons the stale ones.
+func (s *setupSessions) start(chatID int64, setup ChatSetup, now time.Time) setupSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if now.Sub(session.updated) > s.timeout {
+			delete(s.sessions, id)
+		}
+	}
+	session := &setupSession{step: setupStepEnvironments, setup: setup, updated: now}
+	s.sessions[chatID] = session
+	return *session
+}
+
+// update changes the chat's setup in progress and returns the result.
+// It returns false if there is none or it was abandoned.
+// Finished setups are removed.
+func (s *setupSessions) update(chatID int64, now time.Time, change func(*setupSession)) (setupSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[chatID]
+	if !ok {
+		return setupSession{}, false
+	}
+	if now.Sub(session.updated) > s.timeout {
+		delete(s.sessions, chatID)
+		return setupSession{}, false
+	}
+
+	change(session)
+	session.updated = now
+	if session.step == setupStepDone {
+		delete(s.sessions, chatID)
+	}
+	return *session, true
+}
+
+// defaultSetup is what a chat gets when it skips the setup: everything, in UTC.
+func (b *Bot) defaultSetup() ChatSetup {
+	return ChatSetup{
+		Environments: append([]string(nil), b.environmentsAndOther...),
+		Projects:     append([]string(nil), b.projectsAndOther...),
+		Timezone:     defaultTimezone,
+	}
+}
+
+// applyChoice moves the setup on by a button's data:
+// toggle:<value> selects or deselects an environment or project, pick:<value> chooses a severity or timezone,
+// next finishes selecting and skip uses the default of the step.
+func (b *Bot) applyChoice(session *setupSession, data string) {
+	action, value := data, ""
+	if i := strings.Index(data, ":"); i >= 0 {
+		action, value = data[:i], data[i+1:]
+	}
+	defaults := b.defaultSetup()
+
+	switch session.step {
+	case setupStepEnvironments:
+		switch action {
+		case "toggle":
+			session.setup.Environments = toggle(session.setup.Environments, value, b.environmentsAndOther)
+		case "skip":
+			session.setup.Environments = defaults.Environments
+			session.step++
+		case "next":
+			session.step++
+		}
+	case setupStepProjects:
+		switch action {
+		case "toggle":
+			session.setup.Projects = toggle(session.setup.Projects, value, b.projectsAndOther)
+		case "skip":
+			session.setup.Projects = defaults.Projects
+			session.step++
+		case "next":
+			session.step++
+		}
+	case setupStepSeverity:
+		switch action {
+		case "pick":
+			if value == "all" || b.severityOrdering.known(value) {
+				session.setup.MinSeverity = strings.TrimPrefix(value, "all")
+				session.step++
+			}
+		case "skip":
+			session.setup.MinSeverity = defaults.MinSeverity
+			session.step++
+		}
+	case setupStepTimezone:
+		switch action {
+		case "pick":
+			if contains(setupTimezones, value) {
+				session.setup.Timezone = value
+				session.step++
+			}
+		case "skip":
+			session.setup.Timezone = defaults.Timezone
+			session.step++
+		}
+	}
+}
+
+// toggle adds the value to the selected ones or removes it, keeping the order of all.
+func toggle(selected []string, value string, all []string) []string {
+	if !contains(all, value) {
+		return selected
+	}
+	wanted := !contains(selected, value)
+	var toggled []string
+	for _, v := range all {
+		if v == value {
+			if wanted {
+				toggled = append(toggled, v)
+			}
+		} else if contains(selected, v) {
+			toggled = append(toggled, v)
+		}
+	}
+	return toggled
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func setupButton(text string, data string) telebot.InlineButton {
+	return telebot.InlineButton{Unique: setupWizardUnique, Text: text, Data: data}
+}
+
+// toggleButtons returns a checkbox per value, two per row.
+func toggleButtons(all []string, selected []string) [][]telebot.InlineButton {
+	var rows [][]telebot.InlineButton
+	for i, v := range all {
+		box := "⬜ "
+		if contains(selected, v) {
+			box = "✅ "
+		}
+		if i%2 == 0 {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], setupButton(box+v, "toggle:"+v))
+	}
+	return rows
+}
+
+// setupStepMessage returns the question and buttons of the session's current step.
+func (b *Bot) setupStepMessage(session setupSession) (string, *telebot.ReplyMarkup) {
+	skip := setupButton("Skip", "skip")
+	var text string
+	var rows [][]telebot.InlineButton
+
+	switch session.step {
+	case setupStepEnvironments:
+		text = "Setup 1/4: Which environments should this chat get alerts for?"
+		rows = append(toggleButtons(b.environmentsAndOther, session.setup.Environments), []telebot.InlineButton{setupButton("Next", "next"), skip})
+	case setupStepProjects:
+		text = "Setup 2/4: Which projects should this chat get alerts for?"
+		rows = append(toggleButtons(b.projectsAndOther, session.setup.Projects), []telebot.InlineButton{setupButton("Next", "next"), skip})
+	case setupStepSeverity:
+		text = "Setup 3/4: What's the lowest severity this chat should get alerts for?"
+		row := []telebot.InlineButton{setupButton("all", "pick:all")}
+		for _, s := range b.severityOrdering.levels {
+			row = append(row, setupButton(s, "pick:"+s))
+		}
+		rows = [][]telebot.InlineButton{row, {skip}}
+	case setupStepTimezone:
+		text = "Setup 4/4: Which timezone is this chat in?"
+		for _, tz := range setupTimezones {
+			rows = append(rows, []telebot.InlineButton{setupButton(tz, "pick:"+tz)})
+		}
+		rows = append(rows, []telebot.InlineButton{skip})
+	}
+	return text, &telebot.ReplyMarkup{InlineKeyboard: rows}
+}
+
+// setupSummary sums up a finished setup.
+func setupSummary(setup ChatSetup) string {
+	list := func(values []string) string {
+		if len(values) == 0 {
+			return "none"
+		}
+		return strings.Join(values, ", ")
+	}
+	severity := setup.MinSeverity
+	if severity == "" {
+		severity = "all"
+	}
+	return fmt.Sprintf(
+		"Setup done, this chat gets alerts for\nEnvironments: %s\nProjects: %s\nMinimum severity: %s\nTimezone: %s\n\nSee %s for all settings.",
+		list(setup.Environments), list(setup.Projects), severity, setup.Timezone, CommandFilters,
+	)
+}
+
+// ApplySetup stores everything the setup wizard asked for in a single write.
+func (s *ChatStore) ApplySetup(id int64, setup ChatSetup, allEnvs []string, allPrs []string) error {
+	return s.UpdateChat(id, func(ci *ChatInfo) error {
+		ci.AlertEnvironments = append([]string{}, setup.Environments...)
+		ci.MutedEnvironments = append([]string{}, arrayDifference(allEnvs, setup.Environments)...)
+		ci.AlertProjects = append([]string{}, setup.Projects...)
+		ci.MutedProjects = append([]string{}, arrayDifference(allPrs, setup.Projects)...)
+		ci.MinSeverity = setup.MinSeverity
+		ci.Timezone = setup.Timezone
+		return nil
+	})
+}
+
+// startSetup sends the first question of the setup wizard.
+func (b *Bot) startSetup(chat *telebot.Chat) error {
+	session := b.setupSessions.start(chat.ID, b.defaultSetup(), time.Now())
+	text, markup := b.setupStepMessage(session)
+	_, err := b.send(chat, text, "setup", &telebot.SendOptions{ReplyMarkup: markup})
+	return err
+}
+
+func (b *Bot) handleSetup(c *telebot.Callback) {
+	respond := func(text string) {
+		var resp []*telebot.CallbackResponse
+		if text != "" {
+			resp = append(resp, &telebot.CallbackResponse{Text: text})
+		}
+		if err := b.telegram.Respond(c, resp...); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to respond to callback", "err", err)
+		}
+	}
+
+	if c.Message == nil || c.Message.Chat == nil {
+		respond("")
+		return
+	}
+	if c.Sender == nil || !b.isAdminID(c.Sender.ID) {
+		respond("Only admins can set up this chat.")
+		return
+	}
+
+	chat := c.Message.Chat
+	var before setupStep
+	session, ok := b.setupSessions.update(chat.ID, time.Now(), func(s *setupSession) {
+		before = s.step
+		b.applyChoice(s, c.Data)
+	})
+	if !ok {
+		respond("This setup has expired, send " + CommandStart + " to begin again.")
+		return
+	}
+	respond("")
+
+	if session.step == before {
+		// Toggles only change the checkboxes of the question.
+		_, markup := b.setupStepMessage(session)
+		if _, err := b.telegram.EditReplyMarkup(c.Message, markup); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to update setup buttons", "err", err)
+		}
+		return
+	}
+
+	if session.step == setupStepDone {
+		if err := b.chats.ApplySetup(chat.ID, session.setup, b.environmentsAndOther, b.projectsAndOther); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to store chat setup", "chat_id", chat.ID, "err", err)
+			_, _ = b.send(chat, b.storeErrorReply(err, "store the setup"), "setup")
+			return
+		}
+		if _, err := b.send(chat, setupSummary(session.setup)+b.receivesNothingWarning(chat.ID), "setup"); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to send setup summary", "err", err)
+		}
+		return
+	}
+
+	text, markup := b.setupStepMessage(session)
+	if _, err := b.send(chat, text, "setup", &telebot.SendOptions{ReplyMarkup: markup}); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send setup step", "err", err)
+	}
+}
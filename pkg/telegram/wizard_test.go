@@ -0,0 +1,162 @@
+package telegram
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+	"github.com/tshigapov/alertmanager-bot/pkg/alertmanager"
+	"gopkg.in/tucnak/telebot.v2"
+)
+
+// click presses a setup button in the last message sent.
+func click(t *testing.T, b *Bot, tb *fakeTelebot, data string) {
+	t.Helper()
+	msgs := tb.messages()
+	require.NotEmpty(t, msgs)
+	b.handleSetup(&telebot.Callback{
+		Sender:  testAdmin,
+		Message: &telebot.Message{ID: len(msgs), Chat: testChat},
+		Data:    data,
+	})
+}
+
+// buttons returns the data of all buttons of a sent message.
+func buttons(m sentMessage) []string {
+	var data []string
+	for _, o := range m.options {
+		if opts, ok := o.(*telebot.SendOptions); ok && opts.ReplyMarkup != nil {
+			for _, row := range opts.ReplyMarkup.InlineKeyboard {
+				for _, b := range row {
+					data = append(data, b.Data)
+				}
+			}
+		}
+	}
+	return data
+}
+
+func TestSetupWizard(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithSetupWizard(true), WithTemplates(&url.URL{Host: "localhost"}, "../../default.tmpl"))
+
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+	msgs := tb.messages()
+	require.Len(t, msgs, 2)
+	require.Equal(t, "Setup 1/4: Which environments should this chat get alerts for?", msgs[1].text())
+	require.Equal(t, []string{"toggle:prod", "toggle:staging", "toggle:other", "next", "skip"}, buttons(msgs[1]))
+
+	// Toggling only updates the buttons.
+	click(t, b, tb, "toggle:staging")
+	click(t, b, tb, "toggle:other")
+	require.Len(t, tb.messages(), 2)
+	require.Len(t, tb.edited, 2)
+	require.Equal(t, "✅ prod", tb.edited[1].InlineKeyboard[0][0].Text)
+	require.Equal(t, "⬜ staging", tb.edited[1].InlineKeyboard[0][1].Text)
+
+	click(t, b, tb, "next")
+	require.Equal(t, "Setup 2/4: Which projects should this chat get alerts for?", tb.lastText())
+	click(t, b, tb, "toggle:frontend")
+	click(t, b, tb, "next")
+	require.Equal(t, "Setup 3/4: What's the lowest severity this chat should get alerts for?", tb.lastText())
+	click(t, b, tb, "pick:warning")
+	require.Equal(t, "Setup 4/4: Which timezone is this chat in?", tb.lastText())
+	click(t, b, tb, "pick:Europe/Berlin")
+	require.Equal(t,
+		"Setup done, this chat gets alerts for\nEnvironments: prod\nProjects: billing, other\nMinimum severity: warning\nTimezone: Europe/Berlin\n\nSee /filters for all settings.",
+		tb.lastText(),
+	)
+
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod"}, ci.AlertEnvironments)
+	require.ElementsMatch(t, []string{"staging", "other"}, ci.MutedEnvironments)
+	require.Equal(t, []string{"billing", "other"}, ci.AlertProjects)
+	require.Equal(t, []string{"frontend"}, ci.MutedProjects)
+	require.Equal(t, "warning", ci.MinSeverity)
+	require.Equal(t, "Europe/Berlin", ci.Timezone)
+
+	// The setup is over, further clicks are answered but change nothing.
+	click(t, b, tb, "skip")
+	require.Equal(t, "This setup has expired, send /start to begin again.", tb.responses[len(tb.responses)-1].Text)
+
+	// Alerts below the minimum severity aren't sent anymore.
+	w := alertmanager.TelegramWebhook{ChatID: testChat.ID, Message: webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"alertname": "Noisy", "severity": "info"}}},
+	}}}
+	d, err := b.processWebhook(context.Background(), w)
+	require.NoError(t, err)
+	require.Equal(t, 0, d.Messages)
+}
+
+func TestSetupWizardSkip(t *testing.T) {
+	b, tb, chats := newTestBot(t, WithSetupWizard(true))
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+
+	click(t, b, tb, "toggle:prod")
+	for i := 0; i < 4; i++ {
+		click(t, b, tb, "skip")
+	}
+	ci, err := chats.GetChatInfo(testChat.ID)
+	require.NoError(t, err)
+	require.Equal(t, b.environmentsAndOther, ci.AlertEnvironments)
+	require.Empty(t, ci.MutedEnvironments)
+	require.Equal(t, b.projectsAndOther, ci.AlertProjects)
+	require.Equal(t, "", ci.MinSeverity)
+	require.Equal(t, "UTC", ci.Timezone)
+}
+
+func TestSetupWizardOnlyAdmins(t *testing.T) {
+	b, tb, _ := newTestBot(t, WithSetupWizard(true))
+	require.NoError(t, b.handleStart(&telebot.Message{Sender: testAdmin, Chat: testChat}))
+
+	b.handleSetup(&telebot.Callback{
+		Sender:  &telebot.User{ID: 999},
+		Message: &telebot.Message{ID: 2, Chat: testChat},
+		Data:    "skip",
+	})
+	require.Equal(t, "Only admins can set up this chat.", tb.responses[0].Text)
+	require.Len(t, tb.messages(), 2)
+}
+
+func TestSetupSessionsTimeout(t *testing.T) {
+	sessions := newSetupSessions(time.Minute)
+	now := time.Now()
+	sessions.start(1, ChatSetup{}, now)
+	sessions.start(2, ChatSetup{}, now)
+
+	_, ok := sessions.update(1, now.Add(30*time.Second), func(s *setupSession) {})
+	require.True(t, ok)
+	_, ok = sessions.update(2, now.Add(2*time.Minute), func(s *setupSession) {})
+	require.False(t, ok)
+
+	// Starting another setup abandons the stale ones.
+	sessions.start(3, ChatSetup{}, now.Add(5*time.Minute))
+	require.Len(t, sessions.sessions, 1)
+}
+
+func TestAtLeastSeverity(t *testing.T) {
+	alerts := template.Alerts{
+		{Labels: template.KV{"alertname": "A", "severity": "info"}},
+		{Labels: template.KV{"alertname": "B", "severity": "warning"}},
+		{Labels: template.KV{"alertname": "C", "severity": "critical"}},
+		{Labels: template.KV{"alertname": "D"}},
+		{Labels: template.KV{"alertname": "E", "severity": "page"}},
+	}
+	names := func(alerts template.Alerts) []string {
+		var names []string
+		for _, a := range alerts {
+			names = append(names, a.Labels["alertname"])
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"A", "B", "C", "D", "E"}, names(defaultSeverityOrdering.atLeast(alerts, "")))
+	require.Equal(t, []string{"A", "B", "C", "D", "E"}, names(defaultSeverityOrdering.atLeast(alerts, "info")))
+	require.Equal(t, []string{"B", "C", "D", "E"}, names(defaultSeverityOrdering.atLeast(alerts, "warning")))
+	require.Equal(t, []string{"C", "D", "E"}, names(defaultSeverityOrdering.atLeast(alerts, "critical")))
+}
@@ -0,0 +1,280 @@
+package telegram
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	defaultWriteBufferRecords = 100
+	defaultWriteBufferDelay   = 500 * time.Millisecond
+)
+
+// bufferedDirectories are written behind with the message records, the writes of all other keys go to the
+// backend right away. Outbox entries are only removed behind, with the records of the messages their webhooks
+// were delivered in.
+var bufferedDirectories = []string{telegramOutboxDirectory}
+
+// WithSyncWrites writes every message record to the backend before AddMessage and RemoveMessage return,
+// rather than buffering them.
+func WithSyncWrites(enabled bool) ChatStoreOption {
+	return func(s *ChatStore) {
+		switch {
+		case enabled:
+			s.writes = nil
+		case s.writes == nil:
+			s.writes = newWriteBuffer()
+		}
+	}
+}
+
+// WithWriteBuffer buffers the writes of message records and the removals of outbox entries in memory,
+// writing them to the backend once records are buffered or delay passed since the first of them,
+// whichever comes first. The records of a chat are written with a single write of its batch.
+// That's the most a crash loses: the records of the messages sent within delay,
+// at most records of them. The outbox entries of the webhooks those messages were for are removed with them,
+// so a crash has the webhooks delivered again on the next start rather than their records lost.
+// By default 100 records are buffered for up to 500ms.
+func WithWriteBuffer(records int, delay time.Duration) ChatStoreOption {
+	return func(s *ChatStore) {
+		if s.writes != nil && records > 0 && delay > 0 {
+			s.writes.max, s.writes.delay = records, delay
+		}
+	}
+}
+
+// writeBuffer holds the writes of message records and of keys in the buffered directories until they're flushed.
+// A record or key written again before it's flushed is written once, with its last value.
+type writeBuffer struct {
+	max   int
+	delay time.Duration
+
+	mu sync.Mutex
+	// records are the message records to write by chat and message ID, nil removes the record.
+	// Reads look buffered records up in them rather than flushing the buffer.
+	records map[int64]map[int]*MessageRecord
+	// pending are the values of the other keys to write by key, nil deletes the key.
+	pending map[string][]byte
+	// order are the pending keys in the order they were first written.
+	order []string
+	// size is how many records and keys are buffered.
+	size  int
+	timer *time.Timer
+
+	// flushMu keeps flushes from overtaking each other, which could write an older value last.
+	flushMu sync.Mutex
+}
+
+func newWriteBuffer() *writeBuffer {
+	return &writeBuffer{
+		max:     defaultWriteBufferRecords,
+		delay:   defaultWriteBufferDelay,
+		records: map[int64]map[int]*MessageRecord{},
+		pending: map[string][]byte{},
+	}
+}
+
+// buffered tells if writes of the key or below the directory are buffered.
+func buffered(key string) bool {
+	for _, dir := range bufferedDirectories {
+		if strings.HasPrefix(key, dir) || strings.HasPrefix(dir, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// addRecord buffers a write of the record of the message, nil removes it, and tells if the buffer is full.
+func (w *writeBuffer) addRecord(chatID int64, messageID int, r *MessageRecord, flush func()) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	chat, ok := w.records[chatID]
+	if !ok {
+		chat = map[int]*MessageRecord{}
+		w.records[chatID] = chat
+	}
+	if _, ok := chat[messageID]; !ok {
+		w.size++
+	}
+	chat[messageID] = r
+	w.startTimer(flush)
+	return w.size >= w.max
+}
+
+// add buffers a write of the key and tells if the buffer is full.
+func (w *writeBuffer) add(key string, value []byte, flush func()) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.pending[key]; !ok {
+		w.order = append(w.order, key)
+		w.size++
+	}
+	w.pending[key] = value
+	w.startTimer(flush)
+	return w.size >= w.max
+}
+
+// startTimer flushes the buffer after the delay, unless that's already due. w.mu must be held.
+func (w *writeBuffer) startTimer(flush func()) {
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.delay, flush)
+	}
+}
+
+// record returns the buffered record of the message, nil if it's removed, and false if it isn't buffered.
+func (w *writeBuffer) record(chatID int64, messageID int) (*MessageRecord, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r, ok := w.records[chatID][messageID]
+	if r != nil {
+		copied := *r
+		r = &copied
+	}
+	return r, ok
+}
+
+// applyTo applies the buffered writes of records to the records by chat and message ID.
+func (w *writeBuffer) applyTo(records map[int64]map[int]MessageRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for chatID, chat := range w.records {
+		for messageID, r := range chat {
+			if r == nil {
+				delete(records[chatID], messageID)
+				continue
+			}
+			if records[chatID] == nil {
+				records[chatID] = map[int]MessageRecord{}
+			}
+			records[chatID][messageID] = *r
+		}
+	}
+}
+
+// take empties the buffer and returns the writes it held.
+func (w *writeBuffer) take() (records map[int64]map[int]*MessageRecord, keys []string, values map[string][]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	records, keys, values = w.records, w.order, w.pending
+	w.records, w.order, w.pending, w.size = map[int64]map[int]*MessageRecord{}, nil, map[string][]byte{}, 0
+	return records, keys, values
+}
+
+// putBack returns the writes that failed to the buffer, unless the records or keys were written again since.
+func (w *writeBuffer) putBack(records map[int64]map[int]*MessageRecord, keys []string, values map[string][]byte, flush func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for chatID, chat := range records {
+		for messageID, r := range chat {
+			if _, ok := w.records[chatID][messageID]; ok {
+				continue
+			}
+			if w.records[chatID] == nil {
+				w.records[chatID] = map[int]*MessageRecord{}
+			}
+			w.records[chatID][messageID] = r
+			w.size++
+		}
+	}
+	var order []string
+	for _, key := range keys {
+		if _, ok := w.pending[key]; ok {
+			continue
+		}
+		w.pending[key] = values[key]
+		order = append(order, key)
+		w.size++
+	}
+	w.order = append(order, w.order...)
+	if w.size > 0 {
+		w.startTimer(flush)
+	}
+}
+
+// writeMessageBehind buffers a write of the record of the message, nil removes it. A full buffer is flushed
+// right away, like with writeBehind.
+func (s *ChatStore) writeMessageBehind(chatID int64, messageID int, r *MessageRecord) error {
+	if s.writes == nil {
+		return s.writeMessages(chatID, map[int]*MessageRecord{messageID: r})
+	}
+	if s.writes.addRecord(chatID, messageID, r, s.flushLater) {
+		return s.Flush()
+	}
+	return nil
+}
+
+// writeBehind buffers a write of the key, nil deletes it. A full buffer is flushed right away,
+// so that callers see the store failing rather than the buffer growing without end.
+func (s *ChatStore) writeBehind(key string, value []byte) error {
+	if s.writes == nil {
+		if value == nil {
+			return s.delete(key)
+		}
+		return s.put(key, value)
+	}
+	if s.writes.add(key, value, s.flushLater) {
+		return s.Flush()
+	}
+	return nil
+}
+
+// flushLater flushes the buffer once the delay passed, failed writes are tried again after another delay.
+func (s *ChatStore) flushLater() {
+	if err := s.Flush(); err != nil {
+		level.Warn(s.health.logger).Log("msg", "failed to flush buffered writes, trying again later", "err", err)
+	}
+}
+
+// Flush writes the buffered writes to the backend, a write per chat with records and per key.
+// The records go first, the outbox entries of their webhooks are removed after them. Those that fail stay buffered.
+func (s *ChatStore) Flush() error {
+	if s.writes == nil {
+		return nil
+	}
+	s.writes.flushMu.Lock()
+	defer s.writes.flushMu.Unlock()
+
+	records, keys, values := s.writes.take()
+	chatIDs := make([]int64, 0, len(records))
+	for chatID := range records {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	for _, chatID := range chatIDs {
+		if err := s.writeMessages(chatID, records[chatID]); err != nil {
+			s.writes.putBack(records, keys, values, s.flushLater)
+			return err
+		}
+		delete(records, chatID)
+	}
+
+	for i, key := range keys {
+		var err error
+		if values[key] == nil {
+			err = s.delete(key)
+		} else {
+			err = s.put(key, values[key])
+		}
+		if err != nil {
+			s.writes.putBack(nil, keys[i:], values, s.flushLater)
+			return err
+		}
+	}
+	return nil
+}
+
+// flushFor flushes the buffer before reading the key or the keys below the directory, if their writes are buffered.
+func (s *ChatStore) flushFor(key string) error {
+	if s.writes == nil || !buffered(key) {
+		return nil
+	}
+	return s.Flush()
+}
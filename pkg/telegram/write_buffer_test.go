@@ -0,0 +1,198 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/require"
+)
+
+// storedMessage tells if the record of the message is in its chat's batch in the backend.
+func storedMessage(t *testing.T, kv store.Store, chatID int64, messageID int) bool {
+	t.Helper()
+	kvPair, err := kv.Get(messageBatchKey(chatID))
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return false
+	}
+	require.NoError(t, err)
+	var records []MessageRecord
+	require.NoError(t, json.Unmarshal(kvPair.Value, &records))
+	for _, r := range records {
+		if r.MessageID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// writes returns how many writes the backend had.
+func (m *memoryKV) writes() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+func TestWriteBufferFlushes(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(3, time.Hour))
+	require.NoError(t, err)
+	stored := func(messageID int) bool { return storedMessage(t, kv, testChat.ID, messageID) }
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1}))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 2}))
+	require.False(t, stored(1), "the records are buffered")
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 3}))
+	require.True(t, stored(1), "a full buffer is flushed")
+	require.True(t, stored(3))
+	require.Equal(t, uint64(1), kv.writes(), "the records of a chat are written at once")
+
+	// A record added and removed before the flush is never written.
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 4}))
+	require.NoError(t, chats.RemoveMessage(testChat.ID, 4))
+	require.NoError(t, chats.RemoveMessage(testChat.ID, 1))
+	require.True(t, stored(1))
+	require.NoError(t, chats.Flush())
+	require.False(t, stored(1))
+	require.False(t, stored(4))
+	require.True(t, stored(2))
+	require.Equal(t, uint64(2), kv.writes())
+}
+
+func TestWriteBufferWritesEachChatOnce(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	for id := 1; id <= 10; id++ {
+		require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: id}))
+		require.NoError(t, chats.AddMessage(MessageRecord{ChatID: payments.ID, MessageID: id}))
+	}
+	require.NoError(t, chats.Flush())
+	require.Equal(t, uint64(2), kv.writes(), "a write per chat")
+
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 20)
+	r, err := chats.GetMessage(payments.ID, 7)
+	require.NoError(t, err)
+	require.Equal(t, payments.ID, r.ChatID)
+}
+
+func TestWriteBufferFlushesAfterDelay(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(100, 10*time.Millisecond))
+	require.NoError(t, err)
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1}))
+	require.Eventually(t, func() bool {
+		return storedMessage(t, kv, testChat.ID, 1)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWriteBufferReadsAreFresh(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1, Text: "HighCPU"}))
+	r, err := chats.GetMessage(testChat.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, "HighCPU", r.Text)
+	_, err = chats.GetMessage(testChat.ID, 2)
+	require.ErrorIs(t, err, ErrMessageNotFound)
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Zero(t, kv.writes(), "reads don't flush the buffer")
+
+	require.NoError(t, chats.RemoveMessage(testChat.ID, 1))
+	_, err = chats.GetMessage(testChat.ID, 1)
+	require.ErrorIs(t, err, ErrMessageNotFound)
+	_, err = chats.ListMessages()
+	require.ErrorIs(t, err, ErrMessageStoreEmpty)
+}
+
+func TestSyncWrites(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithSyncWrites(true), WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1}))
+	require.True(t, storedMessage(t, kv, testChat.ID, 1))
+	require.NoError(t, chats.RemoveMessage(testChat.ID, 1))
+	_, err = kv.Get(messageBatchKey(testChat.ID))
+	require.ErrorIs(t, err, store.ErrKeyNotFound, "an empty batch is deleted")
+}
+
+func TestMessagesStoredAtAKeyEach(t *testing.T) {
+	kv := newMemoryKV()
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+	// The records of an earlier version, a key each.
+	for id := 1; id <= 2; id++ {
+		value, err := json.Marshal(MessageRecord{ChatID: testChat.ID, MessageID: id, Text: "HighCPU"})
+		require.NoError(t, err)
+		require.NoError(t, kv.Put(messageKey(testChat.ID, id), value, nil))
+	}
+
+	r, err := chats.GetMessage(testChat.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, "HighCPU", r.Text)
+
+	// They're moved into the chat's batch when it's first written.
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 3}))
+	require.NoError(t, chats.RemoveMessage(testChat.ID, 2))
+	require.NoError(t, chats.Flush())
+	require.True(t, storedMessage(t, kv, testChat.ID, 1))
+	require.False(t, storedMessage(t, kv, testChat.ID, 2))
+	require.True(t, storedMessage(t, kv, testChat.ID, 3))
+	for id := 1; id <= 2; id++ {
+		_, err := kv.Get(messageKey(testChat.ID, id))
+		require.ErrorIs(t, err, store.ErrKeyNotFound)
+	}
+	records, err := chats.ListMessages()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+}
+
+func TestWriteBufferCrashBeforeFlush(t *testing.T) {
+	kv := &failingKV{memoryKV: newMemoryKV()}
+	chats, err := NewChatStore(kv, telegramChatsDirectory, WithWriteBuffer(100, time.Hour))
+	require.NoError(t, err)
+
+	// A webhook is written ahead, delivered in a message and removed from the outbox.
+	entry := OutboxEntry{ChatID: testChat.ID, Seq: 1, Message: webhook.Message{Data: &template.Data{}}}
+	require.NoError(t, chats.AddOutbox(entry))
+	require.NoError(t, chats.AddMessage(MessageRecord{ChatID: testChat.ID, MessageID: 1}))
+	require.NoError(t, chats.RemoveOutbox(entry.ChatID, entry.Seq))
+
+	// A bot started on the store after a crash delivers the webhook again, rather than losing its record.
+	restarted, err := NewChatStore(kv, telegramChatsDirectory)
+	require.NoError(t, err)
+	entries, err := restarted.ListOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	_, err = restarted.GetMessage(testChat.ID, 1)
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	// The outbox entry isn't removed before the record is written.
+	kv.err = errors.New("dial tcp 127.0.0.1:8500: connect: connection refused")
+	require.Error(t, chats.Flush())
+	kv.err = nil
+	entries, err = restarted.ListOutbox()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.NoError(t, chats.Flush(), "the failed writes stay buffered")
+	entries, err = restarted.ListOutbox()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	_, err = restarted.GetMessage(testChat.ID, 1)
+	require.NoError(t, err)
+}